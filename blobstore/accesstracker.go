@@ -0,0 +1,126 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"github.com/cinode/golib/utils"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Access statistics of a single blob
+type BlobAccessStats struct {
+	Bid        string    // Blob id
+	Reads      int64     // Number of successful reads
+	Misses     int64     // Number of reads of the blob not found in the storage
+	LastAccess time.Time // Time of the last read attempt
+}
+
+// AccessTracker is a blob storage wrapper recording read statistics of blobs,
+// this can be used to size caches and choose tiering thresholds
+type AccessTracker struct {
+	BlobStorage
+
+	// Source of access times
+	Clock utils.Clock
+
+	lock  sync.Mutex
+	stats map[string]*BlobAccessStats
+}
+
+// Get the wrapped storage
+func (a *AccessTracker) Unwrap() BlobStorage {
+	return a.BlobStorage
+}
+
+// Create new access tracker over given storage
+func NewAccessTracker(storage BlobStorage) *AccessTracker {
+	return &AccessTracker{
+		BlobStorage: storage,
+		Clock:       utils.SystemClock,
+		stats:       make(map[string]*BlobAccessStats),
+	}
+}
+
+func (a *AccessTracker) NewBlobReader(blobId string) (reader io.Reader, err error) {
+	reader, err = a.BlobStorage.NewBlobReader(blobId)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	stats, ok := a.stats[blobId]
+	if !ok {
+		stats = &BlobAccessStats{Bid: blobId}
+		a.stats[blobId] = stats
+	}
+	if err == nil {
+		stats.Reads++
+	} else {
+		stats.Misses++
+	}
+	stats.LastAccess = a.Clock.Now()
+
+	return
+}
+
+// Get statistics of one blob
+func (a *AccessTracker) Stats(blobId string) (stats BlobAccessStats, ok bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	s, ok := a.stats[blobId]
+	if !ok {
+		return BlobAccessStats{Bid: blobId}, false
+	}
+	return *s, true
+}
+
+// Get up to n most frequently read blobs, blobs with equal number
+// of reads are ordered by the time of the last access, most recent first
+func (a *AccessTracker) TopBlobs(n int) []BlobAccessStats {
+	a.lock.Lock()
+	all := make([]BlobAccessStats, 0, len(a.stats))
+	for _, s := range a.stats {
+		all = append(all, *s)
+	}
+	a.lock.Unlock()
+
+	sort.Sort(byPopularity(all))
+	if n < len(all) {
+		all = all[:n]
+	}
+	return all
+}
+
+// Forget all statistics gathered so far
+func (a *AccessTracker) Reset() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.stats = make(map[string]*BlobAccessStats)
+}
+
+// Helper for sorting by popularity
+type byPopularity []BlobAccessStats
+
+func (s byPopularity) Len() int {
+	return len(s)
+}
+
+func (s byPopularity) Less(i, j int) bool {
+	if s[i].Reads != s[j].Reads {
+		return s[i].Reads > s[j].Reads
+	}
+	if !s[i].LastAccess.Equal(s[j].LastAccess) {
+		return s[i].LastAccess.After(s[j].LastAccess)
+	}
+	return s[i].Bid < s[j].Bid
+}
+
+func (s byPopularity) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,66 @@
+package blobstore
+
+import (
+	"github.com/cinode/golib/utils"
+	"testing"
+	"time"
+)
+
+func TestAccessTracker(t *testing.T) {
+
+	tracker := NewAccessTracker(NewMemoryBlobStorage())
+	for _, bid := range []string{"a", "b", "c"} {
+		putBlob(tracker, bid, []byte(bid))
+	}
+
+	for bid, reads := range map[string]int{"a": 1, "b": 3, "c": 2, "missing": 4} {
+		for i := 0; i < reads; i++ {
+			tracker.NewBlobReader(bid)
+		}
+	}
+
+	top := tracker.TopBlobs(2)
+	if len(top) != 2 || top[0].Bid != "b" || top[0].Reads != 3 || top[1].Bid != "c" {
+		t.Fatalf("Invalid top blobs: %v", top)
+	}
+
+	if all := tracker.TopBlobs(10); len(all) != 4 {
+		t.Fatalf("Invalid number of tracked blobs: %v", len(all))
+	}
+
+	stats, ok := tracker.Stats("missing")
+	if !ok || stats.Reads != 0 || stats.Misses != 4 || stats.LastAccess.IsZero() {
+		t.Fatalf("Invalid stats of missing blob: %+v", stats)
+	}
+
+	if _, ok = tracker.Stats("unknown"); ok {
+		t.Fatal("Got stats of blob never accessed")
+	}
+
+	tracker.Reset()
+	if all := tracker.TopBlobs(10); len(all) != 0 {
+		t.Fatalf("Stats not cleared: %v", all)
+	}
+}
+
+func TestAccessTrackerClock(t *testing.T) {
+
+	clock := utils.NewManualClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	tracker := NewAccessTracker(NewMemoryBlobStorage())
+	tracker.Clock = clock
+	putBlob(tracker, "a", []byte("a"))
+	putBlob(tracker, "b", []byte("b"))
+
+	tracker.NewBlobReader("a")
+	clock.Advance(time.Minute)
+	tracker.NewBlobReader("b")
+
+	// Equal number of reads, most recently accessed first
+	top := tracker.TopBlobs(2)
+	if top[0].Bid != "b" || !top[0].LastAccess.Equal(clock.Now()) {
+		t.Fatalf("Invalid top blobs: %v", top)
+	}
+	if !top[1].LastAccess.Equal(clock.Now().Add(-time.Minute)) {
+		t.Fatalf("Invalid access time: %v", top[1].LastAccess)
+	}
+}
@@ -1,23 +1,59 @@
 package blobstore
 
 import (
+	"bytes"
 	"io"
 )
 
 type baseBlobReader struct {
-	storage BlobStorage // Blob storage
+	storage  BlobStorage // Blob storage
+	uncached bool        // Read blobs from the storage even if they're cached
+}
+
+// Get the decrypted content cache used by the reader, nil if there's none
+func (r *baseBlobReader) decryptedCache() *DecryptedCache {
+	if r.uncached {
+		return nil
+	}
+	return decryptedCacheOf(r.storage)
 }
 
 // Internal function, try to open a blob having it's bid and key,
-// don't interpret anything but blob's type
+// don't interpret anything but blob's type. The returned reader
+// must be closed with closeReader to release the storage reader.
 func (r *baseBlobReader) openInternal(
 	bid, key string, requiredValidationMethod int64) (
 	reader io.Reader, blobType int64, err error) {
 
+	bid, key = canonicalForm(bid), canonicalForm(key)
+
+	// Content read before does not have to be fetched nor decrypted
+	cache := r.decryptedCache()
+	if cache != nil {
+		if data, found := cache.get(bid, key); found {
+			reader = bytes.NewReader(data)
+			blobType, err = deserializeInt(reader)
+			return
+		}
+	}
+
 	// Get the raw blob reader
-	if reader, err = r.storage.NewBlobReader(bid); err != nil {
+	raw, err := r.storage.NewBlobReader(bid)
+	if err != nil {
 		return
 	}
+	if reader, blobType, err = openHashBlobData(raw, bid, key, requiredValidationMethod, cache); err != nil {
+		closeReader(raw)
+		return nil, 0, err
+	}
+
+	// Closing the reader releases the storage reader
+	return &rangeReader{Reader: reader, closer: raw}, blobType, nil
+}
+
+// Get the unencrypted stream of the raw blob data and read the blob type
+func openHashBlobData(reader io.Reader, bid, key string, requiredValidationMethod int64, cache *DecryptedCache) (
+	_ io.Reader, blobType int64, err error) {
 
 	// Find out the validation method
 	validationMethod, err := deserializeInt(reader)
@@ -35,12 +71,33 @@ func (r *baseBlobReader) openInternal(
 	if reader, err = createReaderForHashBlobData(reader, bid, key); err != nil {
 		return
 	}
+	if cache != nil {
+		reader = &decryptedCachingReader{reader: reader, cache: cache, bid: bid, key: key}
+	}
 
 	// See what type of a blob this is
-	blobType, err = deserializeInt(reader)
-	if err != nil {
+	if blobType, err = deserializeInt(reader); err != nil {
 		return
 	}
+	return reader, blobType, nil
+}
 
-	return
+// Make sure there's no more data in given reader. Reading the end of the
+// stream does also finish the validation of the blob content, errors found
+// there are returned as is, extraDataErr is returned if there's any
+// data left in the reader.
+func checkEOF(r io.Reader, extraDataErr error) error {
+	var buff [1]byte
+	for {
+		n, err := r.Read(buff[:])
+		if n > 0 {
+			return extraDataErr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
@@ -0,0 +1,109 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"io"
+)
+
+// Storage able to check the existence of many blobs at once, i.e. a remote
+// one where a round-trip per blob would be expensive
+type BatchExistsChecker interface {
+
+	// Get the set of blobs from the list which exist in the storage
+	ExistsBatch(blobIds []string) (existing map[string]bool, err error)
+}
+
+// Check which of the blobs exist, storages not implementing
+// BatchExistsChecker are asked blob by blob
+func ExistsBatch(storage BlobStorage, blobIds []string) (map[string]bool, error) {
+	if checker, ok := storage.(BatchExistsChecker); ok {
+		return checker.ExistsBatch(blobIds)
+	}
+
+	existing := make(map[string]bool)
+	for _, bid := range blobIds {
+		exists, err := storage.Exists(bid)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			existing[bid] = true
+		}
+	}
+	return existing, nil
+}
+
+// Copy the tree of blobs rooted at given blob to the destination storage.
+// Blobs already held by the destination are found with a single batched
+// query and are not transferred. Blobs are copied before blobs referencing
+// them so that an interrupted push never leaves a blob with missing
+// references behind. Signed blobs are not followed.
+func PushTree(source, destination BlobStorage, bid, key string) (pushed, skipped int, err error) {
+
+	// Collect all blobs of the tree, parents go first
+	order := []string{}
+	seen := map[string]bool{}
+	queue := []BlobReference{{Bid: bid, Key: key}}
+	for len(queue) > 0 {
+		ref := queue[0]
+		queue = queue[1:]
+		if seen[ref.Bid] {
+			continue
+		}
+		seen[ref.Bid] = true
+		order = append(order, ref.Bid)
+
+		info, err := InspectBlob(ref.Bid, source)
+		if err != nil {
+			return 0, 0, err
+		}
+		if info.IsSigned() {
+			continue
+		}
+		refs, err := GetBlobReferences(ref.Bid, ref.Key, source)
+		if err != nil {
+			return 0, 0, err
+		}
+		queue = append(queue, refs...)
+	}
+
+	existing, err := ExistsBatch(destination, order)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := len(order) - 1; i >= 0; i-- {
+		if existing[order[i]] {
+			skipped++
+			continue
+		}
+		if err = copyRawBlob(source, destination, order[i]); err != nil {
+			return pushed, skipped, err
+		}
+		pushed++
+	}
+	return pushed, skipped, nil
+}
+
+// Copy the blob as it is stored
+func copyRawBlob(source, destination BlobStorage, bid string) error {
+	reader, err := source.NewBlobReader(bid)
+	if err != nil {
+		return err
+	}
+	defer closeReader(reader)
+
+	writer, err := destination.NewBlobWriter(bid)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, reader); err != nil {
+		writer.Cancel()
+		return err
+	}
+	_, err = writer.Finalize()
+	return err
+}
@@ -0,0 +1,122 @@
+package blobstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// Names of sub-benchmarks follow the key=value form so that benchstat
+// can group results by the configuration
+
+var benchmarkSizes = []int{4 * 1024, 1024 * 1024, 16 * 1024 * 1024}
+
+func benchmarkSizeName(size int) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("size=%dMiB", size/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("size=%dKiB", size/1024)
+	}
+	return fmt.Sprintf("size=%dB", size)
+}
+
+// Content which does not compress, the same one in each run
+func benchmarkContent(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func BenchmarkFileBlobWriter(b *testing.B) {
+	for _, chunking := range []struct {
+		name  string
+		setup func(w *FileBlobWriter)
+	}{
+		{"chunks=default", func(w *FileBlobWriter) {}},
+		{"chunks=1MiB", func(w *FileBlobWriter) { w.Config = &WriterConfig{ChunkSize: 1024 * 1024} }},
+		{"chunks=cdc", func(w *FileBlobWriter) { w.ContentDefined = true }},
+	} {
+		for _, size := range benchmarkSizes {
+			data := benchmarkContent(size)
+			b.Run(chunking.name+"/"+benchmarkSizeName(size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					w := FileBlobWriter{Storage: NewMemoryBlobStorage()}
+					chunking.setup(&w)
+					w.Write(data)
+					if _, err := w.Finalize(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkFileBlobReader(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		data := benchmarkContent(size)
+		storage := NewMemoryBlobStorage()
+		w := FileBlobWriter{Storage: storage}
+		w.Write(data)
+		ref, err := w.Finalize()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(benchmarkSizeName(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				rdr, err := OpenFileBlob(ref.Bid, ref.Key, storage)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err = ioutil.ReadAll(rdr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Validation of hash blobs as done by fsck and sync
+func BenchmarkVerifyHashBlob(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		raw := append([]byte{validationMethodHash}, benchmarkContent(size)...)
+		hasher := createDataHasher()
+		hasher.Write(raw[1:])
+		bid := hex.EncodeToString(hasher.Sum(nil))
+
+		b.Run(benchmarkSizeName(size), func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := VerifyBlob(bid, bytes.NewReader(raw)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMemoryBlobStorageWrite(b *testing.B) {
+	for _, size := range []int{64, 4 * 1024, 1024 * 1024} {
+		content := benchmarkContent(size)
+		b.Run(benchmarkSizeName(size), func(b *testing.B) {
+			bids := benchmarkBids(b.N)
+			storage := NewMemoryBlobStorage()
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for _, bid := range bids {
+				putBlob(storage, bid, content)
+			}
+		})
+	}
+}
@@ -10,24 +10,49 @@ import (
 )
 
 var (
-	ErrBIDCollision = errors.New("A colliding BID has been found")
+	ErrBIDCollision = corruption("A colliding BID has been found - content differs from the stored blob")
 	ErrBIDNotFound  = errors.New("A blob with given BID was not found")
 )
 
+// Writer of the blob, it must be either finalized or canceled, the data
+// written so far may be kept in temporary files until then. Finalize is
+// called at most once. Cancel and Close can be called any number of times,
+// also after Finalize. Close cancels the writer unless it's finalized thus
+// it can be deferred right after the writer is created. Writers which are
+// garbage collected without being finalized or canceled release temporary
+// files, still they should not be abandoned.
 type WriteFinalizeCanceler interface {
 	io.Writer
+	io.Closer
 
 	// Finalize blob generation, if no error is returned,
 	// the duplicate flag will indicate whether this blob
 	// was already inside the blobstore and is equal to the
-	// new one written
-	Finalize() error
+	// new one written. Blobs with the same id but different
+	// content fail with the error matching ErrBIDCollision.
+	Finalize() (duplicate bool, err error)
 
-	// Cancel the blob generation
+	// Cancel the blob generation, already finalized blob is kept
 	Cancel() error
 }
 
-// An interface usefull for blob storage operations
+// An interface usefull for blob storage operations.
+//
+// Storages must be safe for concurrent use. A blob being written is not
+// visible until it's finalized, readers see either no blob or the whole
+// finalized blob. Concurrent writers of the same blob are resolved
+// as sequential ones, in an unspecified order. Writers and readers
+// themselves must not be used concurrently.
+//
+// The writer finalizing the blob already stored, i.e. after losing the race
+// with another writer, reports the duplicate if the content is the same.
+// Different content under the same id is a corruption, apart from newer
+// versions of signed blobs, and it's reported with the error matching both
+// ErrBIDCollision and ErrBlobCorrupted. The stored blob is kept unless it
+// fails the verification while the written one passes it, the corrupted
+// copy is replaced then. Hash-validated blobs found in the storage before
+// their content is written may be reported as duplicates without comparing
+// the content, it's determined by the blob id.
 type BlobStorage interface {
 
 	// Create new writer for blobs
@@ -35,4 +60,36 @@ type BlobStorage interface {
 
 	// Create new reader for existing blob
 	NewBlobReader(blobId string) (reader io.Reader, err error)
+
+	// Check whether the blob exists, this does not validate the blob
+	Exists(blobId string) (exists bool, err error)
+
+	// Remove the blob, ErrBIDNotFound is returned if there's no such blob.
+	// Blobs must only be deleted once they're known to be unreachable,
+	// i.e. by the garbage collector.
+	Delete(blobId string) error
+}
+
+// Optional interface of storage wrappers, optional interfaces of the wrapped
+// storage not implemented by the wrapper are found through it
+type Unwrapper interface {
+
+	// Get the wrapped storage
+	Unwrap() BlobStorage
+}
+
+// Find the first storage of the chain of wrappers, starting with the given
+// one, for which match returns true. Nil is returned if there's none.
+func FindStorage(storage BlobStorage, match func(BlobStorage) bool) BlobStorage {
+	for storage != nil {
+		if match(storage) {
+			return storage
+		}
+		unwrapper, ok := storage.(Unwrapper)
+		if !ok {
+			return nil
+		}
+		storage = unwrapper.Unwrap()
+	}
+	return nil
 }
@@ -0,0 +1,207 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Storage detecting writers which are neither finalized nor canceled
+type leakCheckingStorage struct {
+	BlobStorage
+	lock sync.Mutex
+	open map[*leakCheckedWriter]string
+}
+
+func newLeakCheckingStorage(storage BlobStorage) *leakCheckingStorage {
+	return &leakCheckingStorage{BlobStorage: storage, open: make(map[*leakCheckedWriter]string)}
+}
+
+func (l *leakCheckingStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	writer, err := l.BlobStorage.NewBlobWriter(blobId)
+	if err != nil {
+		return nil, err
+	}
+	checked := &leakCheckedWriter{WriteFinalizeCanceler: writer, storage: l}
+	l.lock.Lock()
+	l.open[checked] = blobId
+	l.lock.Unlock()
+	return checked, nil
+}
+
+// Fail the test if any writer has been abandoned
+func (l *leakCheckingStorage) check(t *testing.T) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var leaked []string
+	for _, bid := range l.open {
+		leaked = append(leaked, bid)
+	}
+	if len(leaked) > 0 {
+		sort.Strings(leaked)
+		t.Fatalf("Writers of blobs leaked: %v", leaked)
+	}
+}
+
+type leakCheckedWriter struct {
+	WriteFinalizeCanceler
+	storage *leakCheckingStorage
+}
+
+func (w *leakCheckedWriter) release() {
+	w.storage.lock.Lock()
+	delete(w.storage.open, w)
+	w.storage.lock.Unlock()
+}
+
+func (w *leakCheckedWriter) Finalize() (bool, error) {
+	w.release()
+	return w.WriteFinalizeCanceler.Finalize()
+}
+
+func (w *leakCheckedWriter) Cancel() error {
+	w.release()
+	return w.WriteFinalizeCanceler.Cancel()
+}
+
+func (w *leakCheckedWriter) Close() error {
+	w.release()
+	return w.WriteFinalizeCanceler.Close()
+}
+
+// List temporary files of the file storage
+func tempFiles(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, tempFilePrefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestWriterClose(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+		NewKeyValueBlobStorage(&mapKeyValueStore{}),
+		NewReplicatedBlobStorage(NewMemoryBlobStorage(), NewMemoryBlobStorage()),
+		NewRetryingStorage(NewMemoryBlobStorage(), RetryPolicy{}),
+	} {
+		// Closed after finalization, the blob is kept
+		writer, err := storage.NewBlobWriter("0a01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte("data"))
+		if _, err = writer.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatalf("Close after finalization failed in %T: %v", storage, err)
+		}
+		if err = writer.Cancel(); err != nil {
+			t.Fatalf("Cancel after finalization failed in %T: %v", storage, err)
+		}
+		if exists, err := storage.Exists("0a01"); err != nil || !exists {
+			t.Fatalf("Finalized blob removed by Close in %T: %v", storage, err)
+		}
+
+		// Closed without finalization, the blob is canceled
+		writer, err = storage.NewBlobWriter("0a02")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte("data"))
+		for i := 0; i < 2; i++ {
+			if err = writer.Close(); err != nil {
+				t.Fatalf("Close failed in %T: %v", storage, err)
+			}
+		}
+		if exists, err := storage.Exists("0a02"); err != nil || exists {
+			t.Fatalf("Closed blob stored in %T: %v", storage, err)
+		}
+	}
+	if names := tempFiles(t, dir); len(names) != 0 {
+		t.Fatalf("Temporary files left: %v", names)
+	}
+
+	// Temporary files of abandoned writers are removed once they're collected
+	for i := 0; i < 10; i++ {
+		writer, err := NewFileBlobStorage(dir).NewBlobWriter("0a03")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte("data"))
+	}
+	for deadline := time.Now().Add(10 * time.Second); len(tempFiles(t, dir)) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Temporary files of abandoned writers left: %v", tempFiles(t, dir))
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWritersNotLeaked(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-leaks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(filepath.Join(source, "sub"), 0777)
+	ioutil.WriteFile(filepath.Join(source, "a.txt"), []byte(strings.Repeat("a", 100000)), 0666)
+	ioutil.WriteFile(filepath.Join(source, "sub", "b.txt"), []byte("b"), 0666)
+
+	l := DefaultLimits
+	l.SpillThreshold = 1024
+	config := &WriterConfig{Limits: &l}
+
+	for _, backend := range []BlobStorage{NewMemoryBlobStorage(), NewFileBlobStorage(filepath.Join(dir, "store"))} {
+		storage := newLeakCheckingStorage(backend)
+
+		if _, _, err = UploadDirectoryWithOptions(source, storage, UploadOptions{Config: &WriterConfig{ChunkSize: 30000, Limits: &l}}); err != nil {
+			t.Fatal(err)
+		}
+		fw := FileBlobWriter{Storage: storage, ContentDefined: true, Config: config}
+		fw.Write(make([]byte, 10000))
+		fw.Cancel()
+
+		// Failed writes
+		ctx, cancel := context.WithCancel(context.Background())
+		fw = FileBlobWriter{Storage: &cancellingStorage{storage, cancel}, Context: ctx, Config: config}
+		fw.Write(make([]byte, maxSimpleFileDataSize+1))
+		limited := FileBlobWriter{Storage: storage, Config: &WriterConfig{MaxBlobSize: 100, Limits: &l}}
+		limited.Write(make([]byte, 1000))
+		if _, err = limited.Finalize(); err == nil {
+			t.Fatal("Too large blob stored")
+		}
+
+		_, privKey, _ := ed25519.GenerateKey(rand.Reader)
+		if _, _, err = CreateSignedBlob(privKey, 1, []byte("Hello"), storage); err != nil {
+			t.Fatal(err)
+		}
+		storage.check(t)
+	}
+	if names := tempFiles(t, filepath.Join(dir, "store")); len(names) != 0 {
+		t.Fatalf("Temporary files left: %v", names)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+// Set of operations supported by the storage
+type Capabilities uint
+
+const (
+	// Blobs can be listed, see Lister
+	CapabilityList Capabilities = 1 << iota
+
+	// Blobs can be written
+	CapabilityWrite
+
+	// Blobs can be deleted
+	CapabilityDelete
+
+	// Finalized blobs appear at once and blobs being written are never
+	// left partially stored, even if the process is killed
+	CapabilityAtomicWrites
+
+	// Parts of blobs can be read without fetching the data
+	// before them, see RangeReader
+	CapabilityRangeReads
+
+	// Blobs can be written with the time to live, see ExpiringStorage
+	CapabilityExpiry
+)
+
+// Check whether all given capabilities are supported
+func (c Capabilities) Has(capabilities Capabilities) bool {
+	return c&capabilities == capabilities
+}
+
+// Optional interface of the blob storage reporting supported operations
+type CapabilitiesReporter interface {
+	Capabilities() Capabilities
+}
+
+// Get operations supported by the storage. Storages not implementing
+// CapabilitiesReporter are assumed to support writes and deletions,
+// listing is supported if they implement Lister, range reads if they
+// implement RangeReader and expiry if they implement ExpiringStorage.
+func StorageCapabilities(storage BlobStorage) Capabilities {
+	if reporter, ok := storage.(CapabilitiesReporter); ok {
+		return reporter.Capabilities()
+	}
+	capabilities := CapabilityWrite | CapabilityDelete
+	if _, ok := storage.(Lister); ok {
+		capabilities |= CapabilityList
+	}
+	if _, ok := storage.(RangeReader); ok {
+		capabilities |= CapabilityRangeReads
+	}
+	if _, ok := storage.(ExpiringStorage); ok {
+		capabilities |= CapabilityExpiry
+	}
+	return capabilities
+}
+
+func (s *memoryBlobStorage) Capabilities() Capabilities {
+	return CapabilityList | CapabilityWrite | CapabilityDelete | CapabilityAtomicWrites | CapabilityRangeReads
+}
+
+// Blobs are written to temporary files renamed once finalized
+func (s *fileBlobStorage) Capabilities() Capabilities {
+	return CapabilityList | CapabilityWrite | CapabilityDelete | CapabilityAtomicWrites | CapabilityRangeReads | CapabilityExpiry
+}
+
+// Writers with options are not passed to the backend
+func (m *MaintenanceStorage) Capabilities() Capabilities {
+	return StorageCapabilities(m.BlobStorage) &^ CapabilityExpiry
+}
+
+func (p *PinningStorage) Capabilities() Capabilities {
+	return StorageCapabilities(p.BlobStorage) &^ CapabilityExpiry
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"math/bits"
+)
+
+// Number of bytes the rolling hash is calculated of
+const cdcWindow = 48
+
+// Random values of bytes used by the buzhash, they must never change
+// since chunk boundaries depend on them
+var buzhashTable [256]uint32
+
+func init() {
+	// Fixed xorshift sequence
+	x := uint32(0x9e3779b9)
+	for i := range buzhashTable {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		buzhashTable[i] = x
+	}
+}
+
+// Split the data at content-defined boundaries, boundaries are searched
+// after the data in the buffer. Boundaries are placed where the rolling hash
+// of the last cdcWindow bytes has the lowest bits cleared, chunks sizes
+// are limited by the limits of the writer.
+func (f *FileBlobWriter) writeContentDefined(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := f.nextBoundary(p)
+		boundary := chunk >= 0
+		if !boundary {
+			chunk = len(p)
+		}
+
+		f.initHasher()
+		f.buffer.Write(p[:chunk])
+		f.hasher.Write(p[:chunk])
+		p = p[chunk:]
+		n += chunk
+
+		if boundary {
+			if err = f.finalizePartialBuffer(); err != nil {
+				f.Cancel()
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Find the number of bytes of p ending the current chunk, -1 if
+// the chunk does not end in p. The rolling hash is updated.
+func (f *FileBlobWriter) nextBoundary(p []byte) int {
+	l := f.chunkLimits()
+	mask := uint32(1)<<l.CDCMaskBits - 1
+	buffered := f.buffer.Bytes()
+	h := f.rollingHash
+
+	for i, b := range p {
+		pos := len(buffered) + i
+		h = bits.RotateLeft32(h, 1) ^ buzhashTable[b]
+		if pos >= cdcWindow {
+			out := pos - cdcWindow
+			var outByte byte
+			if out < len(buffered) {
+				outByte = buffered[out]
+			} else {
+				outByte = p[out-len(buffered)]
+			}
+			h ^= bits.RotateLeft32(buzhashTable[outByte], cdcWindow%32)
+		}
+
+		size := pos + 1
+		if size >= l.CDCMaxSize || (size >= l.CDCMinSize && h&mask == 0) {
+			f.rollingHash = 0
+			return i + 1
+		}
+	}
+
+	f.rollingHash = h
+	return -1
+}
+
+// Get limits of content-defined chunks used by the writer
+func (f *FileBlobWriter) chunkLimits() Limits {
+	if f.ChunkLimits != nil {
+		return *f.ChunkLimits
+	}
+	return f.Config.limits()
+}
@@ -0,0 +1,127 @@
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestContentDefinedChunking(t *testing.T) {
+
+	l := DefaultLimits
+	l.CDCMinSize, l.CDCMaxSize, l.CDCMaskBits = 1024, 16*1024, 12
+	config := &WriterConfig{Limits: &l}
+
+	data := make([]byte, 512*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	// One byte inserted near the beginning
+	modified := append(append(append([]byte{}, data[:100]...), 'x'), data[100:]...)
+
+	storage := NewMemoryBlobStorage()
+	create := func(content []byte) (bid, key string, chunks []FileChunk) {
+		fw := FileBlobWriter{Storage: storage, ContentDefined: true, Config: config}
+
+		// Chunking does not depend on sizes of writes
+		for len(content) > 0 {
+			n := 1000
+			if n > len(content) {
+				n = len(content)
+			}
+			fw.Write(content[:n])
+			content = content[n:]
+		}
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bid, key = ref.Bid, ref.Key
+		if chunks, err = FileChunks(bid, key, storage); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	bid, key, chunks := create(data)
+	_, _, modifiedChunks := create(modified)
+
+	for _, chunk := range chunks {
+		if chunk.Length > int64(l.CDCMaxSize) || (chunk.Length < int64(l.CDCMinSize) && chunk.Offset+chunk.Length != int64(len(data))) {
+			t.Fatalf("Invalid chunk size: %v", chunk.Length)
+		}
+	}
+
+	// Only chunks around the change differ
+	shared := make(map[string]bool)
+	for _, chunk := range chunks {
+		shared[chunk.Bid] = true
+	}
+	different := 0
+	for _, chunk := range modifiedChunks {
+		if !shared[chunk.Bid] {
+			different++
+		}
+	}
+	if len(chunks) < 10 || different > 2 {
+		t.Fatalf("Too many chunks changed: %v of %v", different, len(chunks))
+	}
+
+	// The blob is a regular file blob
+	info, err := InspectBlobWithKey(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsFile() || !info.IsSplit() || info.FileSize != int64(len(data)) || info.PartsCount != int64(len(chunks)) {
+		t.Fatalf("Invalid blob info: %+v", info)
+	}
+	if _, err = StrictDecodeBlob(bid, key, storage); err != nil {
+		t.Fatal(err)
+	}
+	if refs, err := GetBlobReferences(bid, key, storage); err != nil || len(refs) != len(chunks) {
+		t.Fatalf("Invalid references: %v, %v", len(refs), err)
+	}
+
+	rdr, err := OpenFileBlob(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatal("Invalid content of chunked file")
+	}
+
+	// Seeking uses sizes of chunks
+	for _, pos := range []int64{chunks[3].Offset - 1, chunks[3].Offset, 12345, 0} {
+		if _, err = rdr.Seek(pos, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buff := make([]byte, 100)
+		if _, err = io.ReadFull(rdr, buff); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buff, data[pos:pos+100]) {
+			t.Fatalf("Invalid data read at position %v", pos)
+		}
+	}
+}
+
+func TestInvalidLimits(t *testing.T) {
+
+	for _, l := range []Limits{DefaultLimits, ConstrainedLimits} {
+		if err := (&WriterConfig{Limits: &l}).validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := DefaultLimits
+	l.CDCMaxSize = maxSimpleFileDataSize + 1
+	fw := FileBlobWriter{Storage: NewMemoryBlobStorage(), ContentDefined: true, Config: &WriterConfig{Limits: &l}}
+	if _, err := fw.Write([]byte("data")); err != ErrInvalidLimits {
+		t.Fatalf("Invalid error for too large chunks: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"encoding/hex"
+	"github.com/cinode/golib/cipherfactory"
+	"sync"
+)
+
+// Blob of the file chunk found in the chunk index
+type IndexedChunk struct {
+	BlobReference
+	StoredSize int64 // Size of the blob including the header and the encryption overhead
+}
+
+// Index of file chunks by the hash of their content. The blob id of the chunk
+// is only known once it's encrypted, the index lets FileBlobWriter skip the
+// encryption and the upload of chunks stored before, i.e. by the previous
+// backup of the same tree. Hashes are opaque strings, they cover the cipher
+// algorithm too.
+//
+// The index is only a hint, chunks found in it are checked with Exists
+// before being skipped.
+type ChunkIndex interface {
+
+	// Find the blob of the chunk with given content hash
+	LookupChunk(hash string) (chunk IndexedChunk, found bool)
+
+	// Remember the blob of the chunk with given content hash
+	RememberChunk(hash string, chunk IndexedChunk)
+}
+
+// Create chunk index kept in memory, it can be shared by writers
+// running concurrently
+func NewMemoryChunkIndex() ChunkIndex {
+	return &memoryChunkIndex{chunks: make(map[string]IndexedChunk)}
+}
+
+type memoryChunkIndex struct {
+	lock   sync.RWMutex
+	chunks map[string]IndexedChunk
+}
+
+func (m *memoryChunkIndex) LookupChunk(hash string) (IndexedChunk, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	chunk, found := m.chunks[hash]
+	return chunk, found
+}
+
+func (m *memoryChunkIndex) RememberChunk(hash string, chunk IndexedChunk) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.chunks[hash] = chunk
+}
+
+// Get the chunk index hash of the content with given key source, the same
+// content is encrypted differently by different algorithms and gets different
+// blob ids with different hash algorithms
+func chunkIndexHash(config *WriterConfig, keySource []byte) string {
+	if algorithm := config.hashAlgorithm(); algorithm != cipherfactory.DefaultHashAlgorithm {
+		return config.cipherAlgorithm() + ":" + algorithm + ":" + hex.EncodeToString(keySource)
+	}
+	return config.cipherAlgorithm() + ":" + hex.EncodeToString(keySource)
+}
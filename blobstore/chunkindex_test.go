@@ -0,0 +1,67 @@
+package blobstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Storage counting created blob writers, unnamed writers are not exposed
+type writeCountingStorage struct {
+	BlobStorage
+	writers int
+}
+
+func (w *writeCountingStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	w.writers++
+	return w.BlobStorage.NewBlobWriter(blobId)
+}
+
+func TestChunkIndex(t *testing.T) {
+
+	data := bytes.Repeat([]byte("chunk"), maxSimpleFileDataSize/5+10)
+	storage := &writeCountingStorage{BlobStorage: NewMemoryBlobStorage()}
+	index := NewMemoryChunkIndex()
+
+	upload := func() (FinalizeResult, UploadStats) {
+		writer := FileBlobWriter{Storage: storage, ChunkIndex: index}
+		if _, err := writer.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		ref, err := writer.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ref, writer.Stats()
+	}
+
+	first, stats := upload()
+	if stats.BlobsWritten != 3 || storage.writers != 3 {
+		t.Fatalf("Invalid number of blobs written: %+v, writers: %v", stats, storage.writers)
+	}
+
+	// Known chunks are skipped, nothing is written again
+	storage.writers = 0
+	second, stats := upload()
+	if second != first {
+		t.Fatalf("Invalid result of the indexed upload: %+v, expected %+v", second, first)
+	}
+	if stats.BlobsSkipped != 3 || stats.BytesDeduplicated != first.StoredSize || storage.writers != 0 {
+		t.Fatalf("Invalid statistics of the indexed upload: %+v, writers: %v", stats, storage.writers)
+	}
+
+	// Chunks missing in the storage are stored again
+	chunks, err := FileChunks(first.Bid, first.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Delete(chunks[0].Bid); err != nil {
+		t.Fatal(err)
+	}
+	storage.writers = 0
+	if third, stats := upload(); third != first || stats.BlobsWritten != 1 || storage.writers != 1 {
+		t.Fatalf("Invalid result of the upload with stale index: %+v, stats: %+v", third, stats)
+	}
+	if err = ValidateBlob(first.Bid, first.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+// Part of the file stored in a single simple file blob
+type FileChunk struct {
+	Offset int64  // Offset of the chunk data in the file
+	Length int64  // Number of file bytes in the chunk
+	Bid    string // Blob containing the chunk
+	Key    string // Key of the blob
+}
+
+// Get the chunk map of the file blob. Chunks are sorted by offset, each one
+// can be fetched and read independently with OpenFileBlob. Simple files
+// consist of a single chunk, their size is not stored thus the whole blob
+// is decrypted to find it.
+func FileChunks(bid, key string, storage BlobStorage) ([]FileChunk, error) {
+
+	reader := &fileBlobReader{baseBlobReader: baseBlobReader{storage: storage}}
+	if err := reader.Open(bid, key); err != nil {
+		return nil, err
+	}
+	defer closeReader(reader.currentReader)
+
+	if !reader.isSplit {
+		size, err := reader.size()
+		if err != nil {
+			return nil, err
+		}
+		return []FileChunk{{Offset: 0, Length: size, Bid: bid, Key: key}}, nil
+	}
+
+	chunks := make([]FileChunk, len(reader.bids))
+	for i := range chunks {
+		chunks[i] = FileChunk{
+			Offset: reader.offsets[i],
+			Length: reader.offsets[i+1] - reader.offsets[i],
+			Bid:    reader.bids[i],
+			Key:    reader.keys[i],
+		}
+	}
+	return chunks, nil
+}
@@ -0,0 +1,62 @@
+package blobstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFileChunks(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+
+	data := make([]byte, 2*maxSimpleFileDataSize+100)
+	for i := range data {
+		data[i] = byte(i ^ (i >> 8) ^ (i >> 16))
+	}
+
+	for _, size := range []int{0, 100, len(data)} {
+		fw := FileBlobWriter{Storage: storage}
+		fw.Write(data[:size])
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bid, key := ref.Bid, ref.Key
+
+		chunks, err := FileChunks(bid, key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Chunks must cover the whole file and be readable on their own
+		offset := int64(0)
+		for _, chunk := range chunks {
+			if chunk.Offset != offset {
+				t.Fatalf("Invalid chunk offset: %v, expected %v", chunk.Offset, offset)
+			}
+			rdr, err := OpenFileBlob(chunk.Bid, chunk.Key, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadAll(rdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(content)) != chunk.Length || !bytes.Equal(content, data[offset:offset+chunk.Length]) {
+				t.Fatalf("Invalid content of chunk at offset %v", chunk.Offset)
+			}
+			offset += chunk.Length
+		}
+		if offset != int64(size) {
+			t.Fatalf("Chunks cover %v bytes, expected %v", offset, size)
+		}
+	}
+
+	dw := DirBlobWriter{Storage: storage}
+	dirRef, _ := dw.Finalize()
+	dirBid, dirKey := dirRef.Bid, dirRef.Key
+	if _, err := FileChunks(dirBid, dirKey, storage); err != ErrInvalidFileBlobType {
+		t.Fatalf("Invalid error for directory blob: %v", err)
+	}
+}
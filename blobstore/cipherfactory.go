@@ -1,102 +1,100 @@
 package blobstore
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/sha512"
 	"encoding/hex"
-	"errors"
+	"github.com/cinode/golib/cipherfactory"
+	"hash"
 	"io"
+	"strings"
 )
 
 var (
-	ErrInsufficientKeySource = errors.New("Not enough data to create a proper encryption key")
-	ErrInvalidKey            = errors.New("Invalid key")
-	ErrUnknownKeyType        = errors.New("Unknown key type")
+	ErrInsufficientKeySource = cipherfactory.ErrInsufficientKeySource
+	ErrInvalidKey            = cipherfactory.ErrInvalidKey
+	ErrUnknownKeyType        = cipherfactory.ErrUnknownKeyType
+	ErrUnknownHashAlgorithm  = cipherfactory.ErrUnknownHashAlgorithm
 )
 
-func createEncryptor(keySource, ivSource []byte, output io.Writer) (writer io.Writer, key string, err error) {
-
-	// Need at least 32 bytes of the key source
-	if len(keySource) < 32 {
-		err = ErrInsufficientKeySource
-		return
+// Get the hash algorithm of the hash-validated blob id, untagged ids
+// of the SHA-512 hash length are legacy SHA-512 ones
+func BidHashAlgorithm(bid string) (string, error) {
+	if len(bid) == 2*sha512.Size {
+		return cipherfactory.HashSHA512, nil
 	}
-
-	// Create the iv
-	var iv [16]byte
-	for i, b := range ivSource {
-		iv[i] = b
-		if i >= 15 {
-			break
-		}
+	tagged, err := hex.DecodeString(bid)
+	if err != nil {
+		return "", ErrUnknownHashAlgorithm
 	}
+	return cipherfactory.LookupHashTag(tagged)
+}
 
-	keyRaw := keySource[:32]
-	key = cipherAES256Hex + hex.EncodeToString(keyRaw)
-
-	// Generate the encrypted content
-	blobCipher, err := aes.NewCipher(keyRaw)
+// Create the hasher of the content of the hash-validated blob with given id
+func createBidHasher(bid string) (hash.Hash, error) {
+	algorithm, err := BidHashAlgorithm(bid)
 	if err != nil {
-		return
+		return nil, err
 	}
-
-	// Generate the writer
-	writer = &cipher.StreamWriter{
-		S: cipher.NewCFBEncrypter(
-			blobCipher,
-			iv[:]),
-		W: output}
-
-	return
+	return cipherfactory.CreateHasher(algorithm)
 }
 
-func createDecryptor(key string, ivSource []byte, input io.Reader) (reader io.Reader, err error) {
-	keyRaw, err := hex.DecodeString(key)
-	if err != nil || len(keyRaw) < 1 {
-		return nil, ErrInvalidKey
+// Check whether the content with given hash matches the blob id, the hasher
+// of the content must be created by createBidHasher. Only lowercase blob ids
+// are matched.
+func bidMatchesSum(bid string, sum []byte) bool {
+	sumHex := hex.EncodeToString(sum)
+	if len(bid) == len(sumHex) {
+		return bid == sumHex
 	}
+	tag := bid[:len(bid)-len(sumHex)]
+	return tag == strings.ToLower(tag) && bid[len(tag):] == sumHex
+}
 
-	switch keyRaw[0] {
-	case cipherAES256:
-		return createDecryptorAES256(keyRaw[1:], ivSource, input)
+// Get the blob id from the hash of the content, see WriterConfig.HashAlgorithm
+func formatBid(algorithm string, sum []byte) string {
+	if algorithm == cipherfactory.HashSHA512 {
+		return hex.EncodeToString(sum)
 	}
-
-	return nil, ErrUnknownKeyType
+	tag, _ := cipherfactory.HashTag(algorithm)
+	return hex.EncodeToString(append(tag, sum...))
 }
 
-func createDecryptorAES256(key []byte, ivSource []byte, input io.Reader) (reader io.Reader, err error) {
+// Writer of stream ciphers, there's nothing to flush on close
+type nopCloseWriter struct {
+	io.Writer
+}
 
-	if len(key) != 32 {
-		return nil, ErrInvalidKey
-	}
+func (nopCloseWriter) Close() error {
+	return nil
+}
 
-	// Normalize the iv
-	var iv [16]byte
-	for i, b := range ivSource {
-		iv[i] = b
-		if i >= 15 {
-			break
-		}
+// Create the encryptor of the algorithm selected by the config,
+// it must be closed to produce valid data
+func createEncryptor(config *WriterConfig, keySource, ivSource []byte, output io.Writer) (writer io.WriteCloser, key string, err error) {
+	factory, err := cipherfactory.CreateAlgorithm(config.cipherAlgorithm())
+	if err != nil {
+		return nil, "", err
 	}
-
-	// Create new base cipher
-	blobCipher, err := aes.NewCipher(key)
+	w, key, err := factory.CreateEncryptor(keySource, ivSource, output)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	if closer, ok := w.(io.WriteCloser); ok {
+		return closer, key, nil
+	}
+	return nopCloseWriter{w}, key, nil
+}
+
+func createDecryptor(key string, ivSource []byte, input io.Reader) (reader io.Reader, err error) {
+	return cipherfactory.Create().CreateDecryptor(key, ivSource, input)
+}
 
-	// Generate the reader in CFB mode
-	return &cipher.StreamReader{
-			S: cipher.NewCFBDecrypter(
-				blobCipher,
-				iv[:]),
-			R: input},
-		nil
+func createDataHasher() hash.Hash {
+	return sha512.New()
 }
 
 func createDataHash(data []byte) []byte {
-	hasher := sha512.New()
+	hasher := createDataHasher()
 	hasher.Write(data)
 	return hasher.Sum(nil)
 }
@@ -0,0 +1,144 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/cinode/golib/cipherfactory"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAuthenticatedCipher(t *testing.T) {
+
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	storage := NewMemoryBlobStorage()
+
+	fw := FileBlobWriter{Storage: storage, Config: &WriterConfig{CipherAlgorithm: "unknown"}}
+	if _, err := fw.Write(data); err != cipherfactory.ErrUnknownAlgorithm {
+		t.Fatalf("Invalid error for unknown algorithm: %v", err)
+	}
+
+	fw = FileBlobWriter{Storage: storage}
+	fw.Write(data)
+	cfbRef, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfbBid := cfbRef.Bid
+
+	fw = FileBlobWriter{Storage: storage, Config: &WriterConfig{CipherAlgorithm: cipherfactory.AlgorithmAES256GCM}}
+	fw.Write(data)
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+	if bid == cfbBid || key[:2] != "03" {
+		t.Fatalf("Blob not encrypted with the selected algorithm: %v", key[:2])
+	}
+
+	// Blobs are decrypted according to their keys
+	reader, err := OpenFileBlob(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("Invalid data read: %v", err)
+	}
+
+	// Tampering is detected at the damaged chunk, before the blob is read whole
+	corruptBlobAt(t, storage, bid, 100)
+	if _, err = OpenFileBlob(bid, key, storage); err != cipherfactory.ErrChunkAuthenticationFailed {
+		t.Fatalf("Tampered chunk not detected: %v", err)
+	}
+}
+
+// Flip a byte of the stored blob, negative offsets are counted from the end
+func corruptBlobAt(t *testing.T, storage BlobStorage, bid string, offset int) {
+	reader, err := storage.NewBlobReader(bid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	if offset < 0 {
+		offset += len(data)
+	}
+	data[offset] ^= 0xFF
+	storage.Delete(bid)
+	putBlob(storage, bid, data)
+}
+
+func TestHashAlgorithm(t *testing.T) {
+
+	dw := DirBlobWriter{Storage: NewMemoryBlobStorage(), Config: &WriterConfig{HashAlgorithm: "unknown"}}
+	if _, err := dw.Finalize(); err != cipherfactory.ErrUnknownHashAlgorithm {
+		t.Fatalf("Invalid error for unknown hash algorithm: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "cinode-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 10000)
+	rand.Read(data)
+
+	for _, storage := range []BlobStorage{NewMemoryBlobStorage(), NewFileBlobStorage(dir)} {
+		fw := FileBlobWriter{Storage: storage, Config: &WriterConfig{ChunkSize: 4000, HashAlgorithm: cipherfactory.HashSHA512}}
+		fw.Write(data)
+		legacy, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(legacy.Bid) != 128 {
+			t.Fatalf("Legacy blob id is tagged: %v", legacy.Bid)
+		}
+
+		fw = FileBlobWriter{Storage: storage, Config: &WriterConfig{ChunkSize: 4000, HashAlgorithm: cipherfactory.HashBLAKE3}}
+		fw.Write(data)
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ref.Bid) != 68 || ref.Bid[:4] != "1e20" {
+			t.Fatalf("Invalid BLAKE3 blob id: %v", ref.Bid)
+		}
+		if algorithm, err := BidHashAlgorithm(ref.Bid); err != nil || algorithm != cipherfactory.HashBLAKE3 {
+			t.Fatalf("Invalid algorithm of the blob id: %v %v", algorithm, err)
+		}
+
+		// Blobs are validated according to their ids
+		for _, r := range []FinalizeResult{legacy, ref} {
+			reader, err := OpenFileBlob(r.Bid, r.Key, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(read, data) {
+				t.Fatalf("Invalid data read from %T: %v", storage, err)
+			}
+			if err = ValidateBlob(r.Bid, r.Key, storage); err != nil {
+				t.Fatalf("Valid blob reported as invalid in %T: %v", storage, err)
+			}
+		}
+
+		reader, err := storage.NewBlobReader(ref.Bid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := ioutil.ReadAll(reader)
+		closeReader(reader)
+		if err = VerifyBlob(ref.Bid, bytes.NewReader(raw)); err != nil {
+			t.Fatalf("Valid blob not verified: %v", err)
+		}
+		raw[len(raw)-1] ^= 1
+		if err = VerifyBlob(ref.Bid, bytes.NewReader(raw)); err != ErrInvalidHashBlobContent {
+			t.Fatalf("Invalid error of the damaged blob: %v", err)
+		}
+		if err = VerifyBlob("1f20"+ref.Bid[4:], bytes.NewReader(raw)); err != ErrInvalidHashBlobContent {
+			t.Fatalf("Invalid error of the unknown hash: %v", err)
+		}
+	}
+}
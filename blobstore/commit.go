@@ -0,0 +1,160 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// Commit recording the state of the directory tree. Commits link to their
+// parents, the history of the tree is the graph of commits reachable from
+// the latest one. Commits are hash-validated blobs, like all other content
+// they're encrypted and can be read with the key only.
+type Commit struct {
+	Root    BlobReference   // Root directory of the tree
+	Time    time.Time       // Time of the commit
+	Message string          // Description of changes
+	Parents []BlobReference // Previous commits, none for the first commit
+}
+
+// Store the commit blob, commits with the zero time get the current one
+func CreateCommit(commit Commit, storage BlobStorage) (BlobReference, error) {
+	if commit.Root.Bid == "" {
+		return BlobReference{}, ErrMalformedCommitRoot
+	}
+	if len(commit.Parents) > maxSaneCommitParents {
+		return BlobReference{}, ErrMalformedCommitParents
+	}
+	if commit.Time.IsZero() {
+		commit.Time = time.Now()
+	}
+
+	var buffer bytes.Buffer
+	serializeString(commit.Root.Bid, &buffer)
+	serializeString(commit.Root.Key, &buffer)
+	serializeVarint(commit.Time.UnixNano(), &buffer)
+	serializeString(commit.Message, &buffer)
+	serializeInt(int64(len(commit.Parents)), &buffer)
+	for _, parent := range commit.Parents {
+		serializeString(parent.Bid, &buffer)
+		serializeString(parent.Key, &buffer)
+	}
+
+	bid, key, err := CreateTypedBlob(blobTypeCommit, buffer.Bytes(), storage)
+	return BlobReference{Bid: bid, Key: key}, err
+}
+
+// Read the commit blob
+func OpenCommit(bid, key string, storage BlobStorage) (*Commit, error) {
+	reader := baseBlobReader{storage: storage}
+	content, blobType, err := reader.openInternal(bid, key, validationMethodHash)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader(content)
+	if blobType != blobTypeCommit {
+		return nil, ErrInvalidCommitBlobType
+	}
+	return readCommitData(content)
+}
+
+// Visit commits reachable from the head, newest first. Each commit is
+// visited once, the walk stops at the first error returned by fn.
+func WalkHistory(head BlobReference, storage BlobStorage, fn func(ref BlobReference, commit *Commit) error) error {
+	type pendingCommit struct {
+		ref    BlobReference
+		commit *Commit
+	}
+
+	var pending []pendingCommit
+	seen := make(map[string]bool)
+	add := func(ref BlobReference) error {
+		if seen[ref.Bid] {
+			return nil
+		}
+		seen[ref.Bid] = true
+		commit, err := OpenCommit(ref.Bid, ref.Key, storage)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, pendingCommit{ref, commit})
+		return nil
+	}
+
+	if err := add(head); err != nil {
+		return err
+	}
+	for len(pending) > 0 {
+		newest := 0
+		for i := range pending {
+			if pending[i].commit.Time.After(pending[newest].commit.Time) {
+				newest = i
+			}
+		}
+		next := pending[newest]
+		pending = append(pending[:newest], pending[newest+1:]...)
+
+		if err := fn(next.ref, next.commit); err != nil {
+			return err
+		}
+		for _, parent := range next.commit.Parents {
+			if err := add(parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Read the content of the commit blob, the reader must
+// be positioned right after the blob type
+func readCommitData(content io.Reader) (commit *Commit, err error) {
+	commit = &Commit{}
+	if commit.Root.Bid, err = deserializeString(content, maxSaneBidLength); err != nil {
+		return nil, err
+	}
+	if commit.Root.Bid == "" {
+		return nil, ErrMalformedCommitRoot
+	}
+	if commit.Root.Key, err = deserializeString(content, maxSaneKeyLength); err != nil {
+		return nil, err
+	}
+
+	nsec, err := deserializeVarint(content)
+	if err != nil {
+		return nil, err
+	}
+	commit.Time = time.Unix(0, nsec)
+
+	if commit.Message, err = deserializeString(content, maxSaneCommitMessage); err != nil {
+		return nil, err
+	}
+
+	count, err := deserializeInt(content)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || count > maxSaneCommitParents {
+		return nil, ErrMalformedCommitParents
+	}
+	if count > 0 {
+		commit.Parents = make([]BlobReference, count)
+	}
+	for i := range commit.Parents {
+		if commit.Parents[i].Bid, err = deserializeString(content, maxSaneBidLength); err != nil {
+			return nil, err
+		}
+		if commit.Parents[i].Key, err = deserializeString(content, maxSaneKeyLength); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = checkEOF(content, ErrMalformedCommitExtraData); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
@@ -0,0 +1,121 @@
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCommit(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	dw := DirBlobWriter{Storage: storage}
+	tree, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(message string, sec int64, parents ...BlobReference) BlobReference {
+		ref, err := CreateCommit(Commit{Root: tree.BlobReference, Time: time.Unix(sec, 5), Message: message, Parents: parents}, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ref
+	}
+	first := create("first", -100)
+	left := create("left", 200, first)
+	right := create("right", 100, first)
+	merge := create("merge", 300, left, right)
+
+	commit, err := OpenCommit(merge.Bid, merge.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Commit{Root: tree.BlobReference, Time: time.Unix(300, 5), Message: "merge",
+		Parents: []BlobReference{left, right}}
+	if !reflect.DeepEqual(commit, expected) {
+		t.Fatalf("Invalid commit read: %+v", commit)
+	}
+	if commit, _ = OpenCommit(first.Bid, first.Key, storage); !commit.Time.Equal(time.Unix(-100, 5)) || commit.Parents != nil {
+		t.Fatalf("Invalid first commit read: %+v", commit)
+	}
+
+	refs, err := GetBlobReferences(merge.Bid, merge.Key, storage)
+	if err != nil || !reflect.DeepEqual(refs, []BlobReference{tree.BlobReference, left, right}) {
+		t.Fatalf("Invalid references of the commit: %v %v", refs, err)
+	}
+	if err = ValidateBlob(merge.Bid, merge.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = StrictDecodeBlob(merge.Bid, merge.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := InspectBlobWithKey(merge.Bid, merge.Key, storage); !info.IsCommit() || info.IsDir() {
+		t.Fatalf("Invalid information about the commit: %+v", info)
+	}
+
+	// History is visited newest first, the common parent once
+	var messages []string
+	err = WalkHistory(merge, storage, func(ref BlobReference, commit *Commit) error {
+		messages = append(messages, commit.Message)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(messages, []string{"merge", "left", "right", "first"}) {
+		t.Fatalf("Invalid history: %v %v", messages, err)
+	}
+	stop := errors.New("stop")
+	if err = WalkHistory(merge, storage, func(BlobReference, *Commit) error { return stop }); err != stop {
+		t.Fatalf("Walk not stopped: %v", err)
+	}
+
+	if _, err = OpenCommit(tree.Bid, tree.Key, storage); err != ErrInvalidCommitBlobType {
+		t.Fatalf("Invalid error for directory blob: %v", err)
+	}
+	if _, err = CreateCommit(Commit{}, storage); err != ErrMalformedCommitRoot {
+		t.Fatalf("Invalid error for commit without the root: %v", err)
+	}
+}
+
+func TestMalformedCommit(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	build := func(root string, parents int64, extra string) BlobReference {
+		var b bytes.Buffer
+		serializeString(root, &b)
+		serializeString("key", &b)
+		serializeVarint(0, &b)
+		serializeString("message", &b)
+		serializeInt(parents, &b)
+		for i := int64(0); i < parents && i < 2; i++ {
+			serializeString("bid", &b)
+			serializeString("key", &b)
+		}
+		b.WriteString(extra)
+		bid, key, err := CreateTypedBlob(BlobTypeCommit, b.Bytes(), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return BlobReference{Bid: bid, Key: key}
+	}
+
+	for _, d := range []struct {
+		ref BlobReference
+		err error
+	}{
+		{build("", 0, ""), ErrMalformedCommitRoot},
+		{build("bid", maxSaneCommitParents+1, ""), ErrMalformedCommitParents},
+		{build("bid", 1, "x"), ErrMalformedCommitExtraData},
+	} {
+		if _, err := OpenCommit(d.ref.Bid, d.ref.Key, storage); err != d.err {
+			t.Fatalf("Invalid error reading malformed commit: %v, expected %v", err, d.err)
+		}
+		if err := ValidateBlob(d.ref.Bid, d.ref.Key, storage); !errors.Is(err, d.err) {
+			t.Fatalf("Invalid error validating malformed commit: %v, expected %v", err, d.err)
+		}
+		if _, err := StrictDecodeBlob(d.ref.Bid, d.ref.Key, storage); !errors.Is(err, d.err) {
+			t.Fatalf("Invalid error decoding malformed commit: %v, expected %v", err, d.err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha512"
+	"errors"
+	"io"
+)
+
+var (
+	ErrUnknownCompressionMethod = errors.New("Unknown compression method of the file blob")
+	ErrMalformedCompressedData  = corruption("Invalid compressed file blob - compressed data is malformed")
+)
+
+// Compression methods of compressed file blobs, new methods
+// can be added without changing the blob type
+const (
+	compressionDeflate = 0x01
+)
+
+const (
+	// Data shorter than this is never compressed, savings would
+	// not cover the overhead
+	minCompressedDataSize = 512
+
+	// Size of the leading sample of data compressed first, data is
+	// compressed as a whole only if the sample is compressible
+	compressionSampleSize = 64 * 1024
+)
+
+// Compress the file data if it's worth it. Already compressed data such as
+// images or archives is detected with the sample and left as is, the data
+// is compressed only if it shrinks by at least one eighth. Output is
+// the content of the compressed file blob following its type.
+func compressFileData(data []byte) ([]byte, bool) {
+	if len(data) < minCompressedDataSize {
+		return nil, false
+	}
+
+	if len(data) > compressionSampleSize {
+		sample := deflate(data[:compressionSampleSize])
+		if !compressible(len(sample), compressionSampleSize) {
+			return nil, false
+		}
+	}
+
+	compressed := deflate(data)
+	if !compressible(len(compressed), len(data)) {
+		return nil, false
+	}
+	return append([]byte{compressionDeflate}, compressed...), true
+}
+
+func compressible(compressedSize, size int) bool {
+	return compressedSize*8 <= size*7
+}
+
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	w, _ := flate.NewWriter(&b, flate.DefaultCompression)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+// Get the key source of the compressed file blob, the compressed content
+// is hashed instead of the file data thus compressed and uncompressed
+// blobs of the same data get different keys
+func compressedKeySource(content []byte) []byte {
+	hasher := sha512.New()
+	hasher.Write([]byte{blobTypeCompressedStaticFile})
+	hasher.Write(content)
+	return hasher.Sum(nil)
+}
+
+// Get the reader of file data of the compressed file blob, the reader
+// must be positioned right after the blob type
+func newDecompressingReader(reader io.Reader) (io.Reader, error) {
+	method, err := deserializeInt(reader)
+	if err != nil {
+		return nil, err
+	}
+	if method != compressionDeflate {
+		return nil, ErrUnknownCompressionMethod
+	}
+
+	// Buffered reader is a byte reader, the decompressor does not
+	// read past the end of the compressed stream then
+	source := bufio.NewReader(reader)
+	return &decompressingReader{source: source, reader: flate.NewReader(source)}, nil
+}
+
+// Reader of the compressed data, once the end of the compressed stream
+// is reached the blob is read to the end to validate it. Compressed blobs
+// hold data of simple file blobs, streams expanding to more data are
+// rejected.
+type decompressingReader struct {
+	source *bufio.Reader
+	reader io.Reader
+	size   int64 // Number of bytes decompressed so far
+}
+
+func (d *decompressingReader) Read(p []byte) (n int, err error) {
+	n, err = d.reader.Read(p)
+	if d.size += int64(n); d.size > maxSimpleFileDataSize {
+		return 0, ErrMalformedCompressedData
+	}
+	if err == io.ErrUnexpectedEOF {
+		return n, ErrMalformedCompressedData
+	}
+	if _, ok := err.(flate.CorruptInputError); ok {
+		return n, ErrMalformedCompressedData
+	}
+	if err == io.EOF {
+		if eofErr := checkEOF(d.source, ErrMalformedCompressedData); eofErr != nil {
+			return n, eofErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,162 @@
+package blobstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// Generate compressible text of given size
+func genText(size int, seed int64) []byte {
+	words := []string{"blob ", "storage ", "cinode ", "file ", "directory ", "key ", "hash ", "data "}
+	r := rand.New(rand.NewSource(seed))
+	var b bytes.Buffer
+	for b.Len() < size {
+		b.WriteString(words[r.Intn(len(words))])
+	}
+	return b.Bytes()[:size]
+}
+
+func TestCompressedFile(t *testing.T) {
+
+	random := make([]byte, 128*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, d := range []struct {
+		data     []byte
+		blobType int64
+	}{
+		{genText(100*1024, 1), blobTypeCompressedStaticFile},
+		{random, blobTypeSimpleStaticFile},
+		{genText(100, 2), blobTypeSimpleStaticFile},
+	} {
+		storage := NewMemoryBlobStorage()
+		fw := FileBlobWriter{Storage: storage, Compress: true}
+		fw.Write(d.data)
+		result, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := InspectBlobWithKey(result.Bid, result.Key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.BlobType != d.blobType || !info.IsFile() {
+			t.Fatalf("Invalid type of the blob: 0x%02x, expected 0x%02x", info.BlobType, d.blobType)
+		}
+		if d.blobType == blobTypeCompressedStaticFile && result.StoredSize >= result.Size/2 {
+			t.Fatalf("Data not compressed: %d stored bytes of %d", result.StoredSize, result.Size)
+		}
+
+		reader, err := OpenFileBlob(result.Bid, result.Key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ioutil.ReadAll(reader)
+		if err != nil || !bytes.Equal(read, d.data) {
+			t.Fatalf("Invalid content of the file: %v", err)
+		}
+		if err = ValidateBlob(result.Bid, result.Key, storage); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = StrictDecodeBlob(result.Bid, result.Key, storage); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompressedChunks(t *testing.T) {
+
+	data := genText(256*1024, 3)
+	limits := Limits{CDCMinSize: 4096, CDCMaxSize: 16384, CDCMaskBits: 13}
+	storage := NewMemoryBlobStorage()
+
+	fw := FileBlobWriter{Storage: storage, ContentDefined: true, ChunkLimits: &limits, Compress: true}
+	fw.Write(data)
+	result, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := FileChunks(result.Bid, result.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range chunks {
+		info, err := InspectBlobWithKey(chunk.Bid, chunk.Key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.BlobType != blobTypeCompressedStaticFile {
+			t.Fatalf("Chunk not compressed: 0x%02x", info.BlobType)
+		}
+	}
+
+	reader, err := OpenFileBlob(result.Bid, result.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.Seek(100000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(read, data[100000:]) {
+		t.Fatalf("Invalid content after seek: %v", err)
+	}
+
+	// Compressed and uncompressed blobs of the same data
+	// are encrypted with different keys
+	plain := FileBlobWriter{Storage: storage, ContentDefined: true, ChunkLimits: &limits}
+	plain.Write(data)
+	plainResult, err := plain.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainChunks, _ := FileChunks(plainResult.Bid, plainResult.Key, storage)
+	if len(plainChunks) != len(chunks) || plainChunks[0].Key == chunks[0].Key {
+		t.Fatalf("Invalid chunks of the uncompressed file: %v", plainChunks)
+	}
+}
+
+func TestMalformedCompressedFile(t *testing.T) {
+
+	var valid bytes.Buffer
+	w, _ := flate.NewWriter(&valid, flate.DefaultCompression)
+	w.Write([]byte("Hello World!"))
+	w.Close()
+
+	// Data of simple file blobs can't be exceeded
+	var bomb bytes.Buffer
+	w, _ = flate.NewWriter(&bomb, flate.BestCompression)
+	w.Write(make([]byte, maxSimpleFileDataSize+1))
+	w.Close()
+
+	for _, d := range []struct {
+		content []byte
+		err     error
+	}{
+		{append([]byte{compressionDeflate}, valid.Bytes()...), nil},
+		{append([]byte{compressionDeflate}, valid.Bytes()[:valid.Len()-2]...), ErrMalformedCompressedData},
+		{append(append([]byte{compressionDeflate}, valid.Bytes()...), 'x'), ErrMalformedCompressedData},
+		{[]byte{compressionDeflate, 0xFF, 0xFF, 0xFF}, ErrMalformedCompressedData},
+		{append([]byte{0x7E}, valid.Bytes()...), ErrUnknownCompressionMethod},
+		{append([]byte{compressionDeflate}, bomb.Bytes()...), ErrMalformedCompressedData},
+	} {
+		storage := NewMemoryBlobStorage()
+		bid, key, err := CreateTypedBlob(blobTypeCompressedStaticFile, d.content, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := OpenFileBlob(bid, key, storage)
+		if err == nil {
+			_, err = ioutil.ReadAll(reader)
+		}
+		if err != d.err {
+			t.Fatalf("Invalid error of the compressed blob: %v, expected %v", err, d.err)
+		}
+	}
+}
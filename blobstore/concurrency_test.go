@@ -0,0 +1,212 @@
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentAccess(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-concurrency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("blob content "), 100)
+
+	for name, storage := range map[string]BlobStorage{
+		"memory":    NewMemoryBlobStorage(),
+		"interning": NewInterningMemoryBlobStorage(),
+		"file":      NewFileBlobStorage(dir),
+	} {
+		var wg sync.WaitGroup
+		errs := make(chan error, 64)
+
+		for i := 0; i < 16; i++ {
+			wg.Add(2)
+
+			// Writers of the same blob
+			go func() {
+				defer wg.Done()
+				writer, err := storage.NewBlobWriter("b10b")
+				if err != nil {
+					errs <- err
+					return
+				}
+				writer.Write(content)
+				if _, err = writer.Finalize(); err != nil {
+					errs <- err
+				}
+			}()
+
+			// Readers see either no blob or the whole one
+			go func() {
+				defer wg.Done()
+				reader, err := storage.NewBlobReader("b10b")
+				if err == ErrBIDNotFound {
+					return
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer closeReader(reader)
+				data, err := ioutil.ReadAll(reader)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(data, content) {
+					t.Errorf("Partial blob read from %v storage", name)
+				}
+				storage.Exists("b10b")
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatalf("Concurrent access to %v storage failed: %v", name, err)
+		}
+		if err := storage.Delete("b10b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRacingWriters(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-racing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("blob content "), 100)
+
+	for name, storage := range map[string]BlobStorage{
+		"memory": NewMemoryBlobStorage(),
+		"file":   NewFileBlobStorage(dir),
+		"kv":     NewKeyValueBlobStorage(&mapKeyValueStore{}),
+	} {
+
+		// All writers finish writing before any of them finalizes
+		var writers []WriteFinalizeCanceler
+		for i := 0; i < 8; i++ {
+			writer, err := storage.NewBlobWriter("b10b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer.Write(content)
+			writers = append(writers, writer)
+		}
+
+		var (
+			wg     sync.WaitGroup
+			lock   sync.Mutex
+			stored int
+		)
+		for _, writer := range writers {
+			wg.Add(1)
+			go func(writer WriteFinalizeCanceler) {
+				defer wg.Done()
+				duplicate, err := writer.Finalize()
+				if err != nil {
+					t.Errorf("Racing writer of %v storage failed: %v", name, err)
+					return
+				}
+				if !duplicate {
+					lock.Lock()
+					stored++
+					lock.Unlock()
+				}
+			}(writer)
+		}
+		wg.Wait()
+		if stored != 1 {
+			t.Fatalf("Blob stored by %v racing writers of %v storage", stored, name)
+		}
+
+		// Different content under the same id is a corruption
+		writer, _ := storage.NewBlobWriter("b10b")
+		writer.Write([]byte("other content"))
+		_, err := writer.Finalize()
+		if !errors.Is(err, ErrBIDCollision) || !errors.Is(err, ErrBlobCorrupted) {
+			t.Fatalf("Invalid error of colliding blob in %v storage: %v", name, err)
+		}
+		reader, _ := storage.NewBlobReader("b10b")
+		data, _ := ioutil.ReadAll(reader)
+		closeReader(reader)
+		if !bytes.Equal(data, content) {
+			t.Fatalf("Stored blob replaced by the colliding one in %v storage", name)
+		}
+	}
+}
+
+func TestCollidingBlobVerified(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-colliding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, storage := range map[string]BlobStorage{
+		"memory": NewMemoryBlobStorage(),
+		"file":   NewFileBlobStorage(dir),
+		"kv":     NewKeyValueBlobStorage(&mapKeyValueStore{}),
+	} {
+		bid, _, err := CreateTypedBlob(blobTypeSimpleStaticFile, []byte("valid content"), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, _ := storage.NewBlobReader(bid)
+		valid, _ := ioutil.ReadAll(reader)
+		closeReader(reader)
+		corrupted := append([]byte{}, valid...)
+		corrupted[len(corrupted)-1] ^= 0x01
+
+		write := func(data []byte) (bool, error) {
+			writer, err := storage.NewBlobWriter(bid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer.Write(data)
+			return writer.Finalize()
+		}
+		stored := func() []byte {
+			reader, _ := storage.NewBlobReader(bid)
+			data, _ := ioutil.ReadAll(reader)
+			closeReader(reader)
+			return data
+		}
+
+		// Invalid copy written over the valid one is rejected, the file
+		// storage skips writing it after finding the valid one
+		if duplicate, err := write(corrupted); !errors.Is(err, ErrBlobCorrupted) && !(name == "file" && duplicate) {
+			t.Fatalf("Invalid error of corrupted blob in %v storage: %v", name, err)
+		}
+		if !bytes.Equal(stored(), valid) {
+			t.Fatalf("Valid blob replaced by the corrupted one in %v storage", name)
+		}
+
+		// Valid copy replaces the stored invalid one
+		if err = storage.Delete(bid); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = write(corrupted); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = write(valid); err != nil {
+			t.Fatalf("Valid blob rejected in %v storage: %v", name, err)
+		}
+		if !bytes.Equal(stored(), valid) {
+			t.Fatalf("Corrupted blob not replaced in %v storage", name)
+		}
+	}
+}
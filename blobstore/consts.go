@@ -5,24 +5,55 @@
 package blobstore
 
 const (
-	blobTypeSimpleStaticFile = 0x01
-	blobTypeSplitStaticFile  = 0x02
-	blobTypeSimpleStaticDir  = 0x11
-	blobTypeSplitStaticDir   = 0x12
+	blobTypeSimpleStaticFile  = 0x01
+	blobTypeSplitStaticFile   = 0x02
+	blobTypeChunkedStaticFile = 0x03
 
-	cipherAES256    = 0x01
-	cipherAES256Hex = "01"
+	// Simple file with the content compressed before encryption,
+	// the compression method follows the blob type
+	blobTypeCompressedStaticFile = 0x04
+
+	blobTypeSimpleStaticDir = 0x11
+	blobTypeSplitStaticDir  = 0x12
+
+	// Simple directory with typed entries, used only if any entry
+	// is not a plain blob entry so that older blob ids don't change
+	blobTypeSimpleStaticDirV2 = 0x13
+
+	// Split directory listing numbers of entries of its partial blobs,
+	// used for directories split at other counts than maxSimpleDirEntries
+	blobTypeChunkedStaticDir = 0x14
+
+	// Commit of the directory tree with links to its parent commits
+	blobTypeCommit = 0x21
+
+	// Link to another blob with the MIME type and the name of the target
+	blobTypeLink = 0x31
 
 	maxSimpleFileDataSize = 16 * 1024 * 1024
 	maxSimpleDirEntries   = 1024
 
-	maxSaneSplitFileParts  = 1024 * 1024
-	maxSaneBidLength       = 1024
-	maxSaneKeyLength       = 16 * 1024
-	maxSaneNameLenght      = 1024
-	maxSaneMimeTypeLength  = 128
-	maxSanePubKeyLength    = 32 * 1024
-	maxSaneSignatureLength = 1024
+	maxSaneSplitFileParts   = 1024 * 1024
+	maxSaneSplitDirParts    = 1024 * 1024
+	maxSaneBidLength        = 1024
+	maxSaneKeyLength        = 16 * 1024
+	maxSaneNameLenght       = 1024
+	maxSaneMimeTypeLength   = 128
+	maxSaneTargetLength     = 4096
+	maxSaneEntryType        = 0xFFFF
+	maxSaneEntryFieldTag    = 0xFFFF
+	maxSaneEntryFields      = 1024
+	maxSaneEntryFieldLength = 64 * 1024
+	maxSaneCommitMessage    = 1024 * 1024
+	maxSaneCommitParents    = 1024
+	maxSanePubKeyLength     = 32 * 1024
+	maxSaneSignatureLength  = 1024
+
+	// Size of the IV source of signed blobs
+	signedIVSourceSize = 32
+
+	// 64-bit integer can be serialized in 10 bytes, each representing 7 bits of the number
+	maxNumberBytes = 10
 
 	validationMethodHash = 0x01
 	validationMethodSign = 0x02
@@ -0,0 +1,142 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"context"
+	"io"
+)
+
+// Wrap the storage so that all operations are bound to the context. Once the
+// context is cancelled or its deadline passes, reads and writes fail with the
+// context error and blobs being written are cancelled instead of finalized.
+// Decryption and validation is done while the data is read, servers should
+// wrap the storage with the request context so that the work for abandoned
+// requests is aborted. Storages implementing ContextBinder are bound
+// to the context too, operations in progress are aborted by them.
+func WithContext(ctx context.Context, storage BlobStorage) BlobStorage {
+	if binder, ok := storage.(ContextBinder); ok {
+		storage = binder.WithContext(ctx)
+	}
+	return &contextStorage{BlobStorage: storage, ctx: ctx}
+}
+
+// Optional interface of storages able to abort operations in progress,
+// i.e. remote ones cancelling requests in flight
+type ContextBinder interface {
+
+	// Get the storage with operations bound to the context
+	WithContext(ctx context.Context) BlobStorage
+}
+
+// Create the blob writer bound to the context
+func NewBlobWriterCtx(ctx context.Context, storage BlobStorage, blobId string) (WriteFinalizeCanceler, error) {
+	return WithContext(ctx, storage).NewBlobWriter(blobId)
+}
+
+// Create the blob reader bound to the context
+func NewBlobReaderCtx(ctx context.Context, storage BlobStorage, blobId string) (io.Reader, error) {
+	return WithContext(ctx, storage).NewBlobReader(blobId)
+}
+
+// Open the file blob, reading fails once the context is done
+func OpenFileBlobCtx(ctx context.Context, bid, key string, storage BlobStorage) (FileBlobReader, error) {
+	return OpenFileBlob(bid, key, WithContext(ctx, storage))
+}
+
+// Open the directory blob, reading entries fails once the context is done
+func OpenDirBlobCtx(ctx context.Context, bid, key string, storage BlobStorage) (DirBlobReader, error) {
+	return OpenDirBlob(bid, key, WithContext(ctx, storage))
+}
+
+type contextStorage struct {
+	BlobStorage
+	ctx context.Context
+}
+
+// Get the wrapped storage
+func (c *contextStorage) Unwrap() BlobStorage {
+	return c.BlobStorage
+}
+
+func (c *contextStorage) NewBlobWriter(blobId string) (writer WriteFinalizeCanceler, err error) {
+	if err = c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if writer, err = c.BlobStorage.NewBlobWriter(blobId); err != nil {
+		return nil, err
+	}
+	return &contextWriter{WriteFinalizeCanceler: writer, ctx: c.ctx}, nil
+}
+
+func (c *contextStorage) NewBlobReader(blobId string) (reader io.Reader, err error) {
+	if err = c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if reader, err = c.BlobStorage.NewBlobReader(blobId); err != nil {
+		return nil, err
+	}
+	return &contextReader{reader: reader, ctx: c.ctx}, nil
+}
+
+func (c *contextStorage) Exists(blobId string) (bool, error) {
+	if err := c.ctx.Err(); err != nil {
+		return false, err
+	}
+	return c.BlobStorage.Exists(blobId)
+}
+
+func (c *contextStorage) Stat(blobId string) (BlobStat, error) {
+	if err := c.ctx.Err(); err != nil {
+		return BlobStat{}, err
+	}
+	return Stat(c.BlobStorage, blobId)
+}
+
+func (c *contextStorage) Delete(blobId string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.BlobStorage.Delete(blobId)
+}
+
+type contextReader struct {
+	reader io.Reader
+	ctx    context.Context
+}
+
+func (c *contextReader) Read(p []byte) (n int, err error) {
+	if err = c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *contextReader) Close() error {
+	if closer, ok := c.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type contextWriter struct {
+	WriteFinalizeCanceler
+	ctx context.Context
+}
+
+func (c *contextWriter) Write(p []byte) (n int, err error) {
+	if err = c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.WriteFinalizeCanceler.Write(p)
+}
+
+func (c *contextWriter) Finalize() (duplicate bool, err error) {
+	if err = c.ctx.Err(); err != nil {
+		c.WriteFinalizeCanceler.Cancel()
+		return false, err
+	}
+	return c.WriteFinalizeCanceler.Finalize()
+}
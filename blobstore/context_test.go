@@ -0,0 +1,103 @@
+package blobstore
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWithContext(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	storage := NewMemoryBlobStorage()
+	bound := WithContext(ctx, storage)
+
+	fw := FileBlobWriter{Storage: bound}
+	fw.Write(make([]byte, 1024))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+
+	reader, err := OpenFileBlob(bid, key, bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff := make([]byte, 16)
+	if _, err = reader.Read(buff); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading and writing stops once the context is cancelled
+	writer, _ := bound.NewBlobWriter("pending")
+	cancel()
+
+	if _, err = ioutil.ReadAll(reader); err != context.Canceled {
+		t.Fatalf("Invalid error of read with cancelled context: %v", err)
+	}
+	if _, err = writer.Write([]byte("data")); err != context.Canceled {
+		t.Fatalf("Invalid error of write with cancelled context: %v", err)
+	}
+	if _, err = writer.Finalize(); err != context.Canceled {
+		t.Fatalf("Invalid error of finalize with cancelled context: %v", err)
+	}
+	if exists, _ := storage.Exists("pending"); exists {
+		t.Fatal("Blob finalized after the context was cancelled")
+	}
+
+	if _, err = bound.NewBlobReader(bid); err != context.Canceled {
+		t.Fatalf("Invalid error when opening with cancelled context: %v", err)
+	}
+	if _, err = bound.Exists(bid); err != context.Canceled {
+		t.Fatalf("Invalid error of exists with cancelled context: %v", err)
+	}
+	if err = bound.Delete(bid); err != context.Canceled {
+		t.Fatalf("Invalid error of delete with cancelled context: %v", err)
+	}
+}
+
+// Storage cancelling the context once the blob write starts
+type cancellingStorage struct {
+	BlobStorage
+	cancel context.CancelFunc
+}
+
+func (c *cancellingStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	c.cancel()
+	return c.BlobStorage.NewBlobWriter(blobId)
+}
+
+func TestWritersContext(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	storage := NewMemoryBlobStorage()
+
+	// Partial blob being stored is aborted
+	fw := FileBlobWriter{Storage: &cancellingStorage{storage, cancel}, Context: ctx}
+	if _, err := fw.Write(make([]byte, maxSimpleFileDataSize+1)); err != context.Canceled {
+		t.Fatalf("Invalid error of write with cancelled context: %v", err)
+	}
+	if _, err := fw.Write([]byte("data")); err != context.Canceled {
+		t.Fatalf("Invalid error of write with cancelled context: %v", err)
+	}
+	if _, err := fw.Finalize(); err != context.Canceled {
+		t.Fatalf("Invalid error of finalize with cancelled context: %v", err)
+	}
+	if blobs, _ := ListAllBlobs(storage); len(blobs) != 0 {
+		t.Fatalf("Blobs stored after the context was cancelled: %v", blobs)
+	}
+
+	dw := DirBlobWriter{Storage: storage, Context: ctx}
+	dw.AddEntry(DirEntry{Name: "a", Bid: "bid", Key: "key"})
+	if _, err := dw.Finalize(); err != context.Canceled {
+		t.Fatalf("Invalid error of directory finalize with cancelled context: %v", err)
+	}
+
+	if _, err := NewBlobWriterCtx(ctx, storage, "bid"); err != context.Canceled {
+		t.Fatalf("Invalid error of writer with cancelled context: %v", err)
+	}
+	if _, err := OpenDirBlobCtx(ctx, "bid", "key", storage); err != context.Canceled {
+		t.Fatalf("Invalid error of reader with cancelled context: %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Statistics of the decrypted content cache
+type DecryptedCacheStats struct {
+	Hits      int64 // Blobs served from the cache
+	Misses    int64 // Blobs fetched and decrypted
+	Evictions int64 // Blobs dropped to make room for others
+	Entries   int   // Number of cached blobs
+	Bytes     int64 // Size of cached content
+}
+
+// Cache of decrypted content of hash-validated blobs shared by file and
+// directory readers of one storage, see DecryptedCachingStorage. Blobs read
+// again are neither fetched nor decrypted. Blobs are cached by the bid and
+// the key once they're read to the end and validated, least recently used
+// ones are evicted when the size limit is exceeded. Hash-validated blobs
+// never change thus cached content is never outdated.
+type DecryptedCache struct {
+	maxBytes int64
+
+	lock    sync.Mutex
+	entries map[decryptedCacheKey]*list.Element
+	lru     list.List // Most recently used first
+	bytes   int64
+
+	hits, misses, evictions atomic.Int64
+}
+
+type decryptedCacheKey struct {
+	bid, key string
+}
+
+type decryptedCacheEntry struct {
+	id   decryptedCacheKey
+	data []byte
+}
+
+// Create the cache keeping up to maxBytes of decrypted content, blobs
+// larger than that are never cached
+func NewDecryptedCache(maxBytes int64) *DecryptedCache {
+	return &DecryptedCache{
+		maxBytes: maxBytes,
+		entries:  make(map[decryptedCacheKey]*list.Element),
+	}
+}
+
+// Storage wrapper serving file and directory readers with the decrypted
+// content cache. Only blobs read through the wrapper are cached, blobs
+// deleted through it are dropped from the cache. Blobs deleted from the
+// wrapped storage directly may still be read from the cache. Validation
+// of blobs always reads them from the storage.
+type DecryptedCachingStorage struct {
+	BlobStorage
+	cache *DecryptedCache
+}
+
+// Wrap the storage caching up to maxBytes of decrypted content
+func NewDecryptedCachingStorage(storage BlobStorage, maxBytes int64) *DecryptedCachingStorage {
+	return &DecryptedCachingStorage{BlobStorage: storage, cache: NewDecryptedCache(maxBytes)}
+}
+
+// Get the wrapped storage
+func (s *DecryptedCachingStorage) Unwrap() BlobStorage {
+	return s.BlobStorage
+}
+
+// Get the cache of the storage
+func (s *DecryptedCachingStorage) Cache() *DecryptedCache {
+	return s.cache
+}
+
+func (s *DecryptedCachingStorage) Delete(blobId string) error {
+	s.cache.drop(canonicalForm(blobId))
+	return s.BlobStorage.Delete(blobId)
+}
+
+func (s *DecryptedCachingStorage) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	return ListBlobs(s.BlobStorage, prefix, cursor, limit)
+}
+
+func (s *DecryptedCachingStorage) ExistsBatch(blobIds []string) (map[string]bool, error) {
+	return ExistsBatch(s.BlobStorage, blobIds)
+}
+
+func (s *DecryptedCachingStorage) Stat(blobId string) (BlobStat, error) {
+	return Stat(s.BlobStorage, blobId)
+}
+
+func (s *DecryptedCachingStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	return NewBlobReaderRange(s.BlobStorage, blobId, offset, length)
+}
+
+// Expiring blobs would be served from the cache once they're gone
+// thus the expiry is not supported
+func (s *DecryptedCachingStorage) Capabilities() Capabilities {
+	return StorageCapabilities(s.BlobStorage) &^ CapabilityExpiry
+}
+
+// Get the decrypted content cache of the storage, nil if there's none
+func decryptedCacheOf(storage BlobStorage) *DecryptedCache {
+	found := FindStorage(storage, func(s BlobStorage) bool {
+		_, ok := s.(*DecryptedCachingStorage)
+		return ok
+	})
+	if found == nil {
+		return nil
+	}
+	return found.(*DecryptedCachingStorage).cache
+}
+
+// Get statistics of the cache
+func (c *DecryptedCache) Stats() DecryptedCacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return DecryptedCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+	}
+}
+
+// Drop all cached blobs, statistics are kept
+func (c *DecryptedCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[decryptedCacheKey]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// Get the decrypted content of the blob
+func (c *DecryptedCache) get(bid, key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, found := c.entries[decryptedCacheKey{bid, key}]
+	if !found {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*decryptedCacheEntry).data, true
+}
+
+// Check whether the blob is cached, statistics are not updated
+func (c *DecryptedCache) has(bid, key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, found := c.entries[decryptedCacheKey{bid, key}]
+	return found
+}
+
+// Drop all cached content of the blob, whichever key it's read with
+func (c *DecryptedCache) drop(bid string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for id, elem := range c.entries {
+		if id.bid == bid {
+			c.lru.Remove(elem)
+			delete(c.entries, id)
+			c.bytes -= int64(len(elem.Value.(*decryptedCacheEntry).data))
+		}
+	}
+}
+
+// Store the decrypted content of the blob
+func (c *DecryptedCache) put(bid, key string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	id := decryptedCacheKey{bid, key}
+	if _, found := c.entries[id]; found {
+		return
+	}
+	for c.bytes+size > c.maxBytes {
+		oldest := c.lru.Back()
+		entry := c.lru.Remove(oldest).(*decryptedCacheEntry)
+		delete(c.entries, entry.id)
+		c.bytes -= int64(len(entry.data))
+		c.evictions.Add(1)
+	}
+	c.entries[id] = c.lru.PushFront(&decryptedCacheEntry{id: id, data: data})
+	c.bytes += size
+}
+
+// Reader collecting the decrypted content, it's cached once the end
+// of the validated blob is reached. Collecting stops if the content
+// does not fit in the cache.
+type decryptedCachingReader struct {
+	reader   io.Reader
+	cache    *DecryptedCache
+	bid, key string
+	data     bytes.Buffer
+	dropped  bool
+}
+
+func (c *decryptedCachingReader) Read(p []byte) (n int, err error) {
+	n, err = c.reader.Read(p)
+	if c.dropped {
+		return
+	}
+	if int64(c.data.Len()+n) > c.cache.maxBytes {
+		c.dropped = true
+		c.data = bytes.Buffer{}
+		return
+	}
+	c.data.Write(p[:n])
+	if err == io.EOF {
+		c.cache.put(c.bid, c.key, c.data.Bytes())
+		c.dropped = true
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// Storage counting opened blob readers
+type readCountingStorage struct {
+	BlobStorage
+	reads int
+}
+
+func (r *readCountingStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	r.reads++
+	return r.BlobStorage.NewBlobReader(blobId)
+}
+
+func TestDecryptedCache(t *testing.T) {
+
+	backend := &readCountingStorage{BlobStorage: NewMemoryBlobStorage()}
+	storage := NewDecryptedCachingStorage(backend, 900)
+	cache := storage.Cache()
+	store := func(data []byte) BlobReference {
+		fw := FileBlobWriter{Storage: storage}
+		fw.Write(data)
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ref.BlobReference
+	}
+	read := func(ref BlobReference) []byte {
+		reader, err := OpenFileBlob(ref.Bid, ref.Key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	small := bytes.Repeat([]byte("a"), 400)
+	ref := store(small)
+	dw := DirBlobWriter{Storage: storage}
+	dw.AddEntry(DirEntry{Name: "small", Bid: ref.Bid, Key: ref.Key})
+	dir, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if !bytes.Equal(read(ref), small) {
+			t.Fatal("Invalid content read")
+		}
+		r, _ := OpenDirBlob(dir.Bid, dir.Key, storage)
+		if entries, err := r.Entries(); err != nil || len(entries) != 1 || entries[0].Bid != ref.Bid {
+			t.Fatalf("Invalid entries read: %v, %v", entries, err)
+		}
+	}
+	if backend.reads != 2 {
+		t.Fatalf("Invalid number of blobs fetched: %v", backend.reads)
+	}
+	if stats := cache.Stats(); stats.Hits != 4 || stats.Misses != 2 || stats.Entries != 2 {
+		t.Fatalf("Invalid cache statistics: %+v", stats)
+	}
+
+	// Partially read blobs are not cached
+	other := store(bytes.Repeat([]byte("b"), 400))
+	reader, _ := OpenFileBlob(other.Bid, other.Key, storage)
+	reader.Read(make([]byte, 10))
+	if stats := cache.Stats(); stats.Entries != 2 {
+		t.Fatalf("Partially read blob cached: %+v", stats)
+	}
+
+	// Least recently used blobs are evicted, too large ones are not cached
+	r, _ := OpenDirBlob(dir.Bid, dir.Key, storage)
+	r.Entries()
+	read(other)
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Bytes > 900 {
+		t.Fatalf("Invalid cache statistics after eviction: %+v", stats)
+	}
+	backend.reads = 0
+	read(other)
+	read(ref)
+	if backend.reads != 1 {
+		t.Fatalf("Invalid blobs evicted, %v blobs fetched", backend.reads)
+	}
+	large := store(bytes.Repeat([]byte("c"), 2000))
+	read(large)
+	if stats := cache.Stats(); stats.Bytes > 900 {
+		t.Fatalf("Too large blob cached: %+v", stats)
+	}
+}
+
+func TestDecryptedCacheOfStorage(t *testing.T) {
+
+	backend := &readCountingStorage{BlobStorage: NewMemoryBlobStorage()}
+	storage := NewDecryptedCachingStorage(backend, 1<<20)
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _ := OpenFileBlob(ref.Bid, ref.Key, storage)
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "Hello World!" {
+		t.Fatalf("Invalid content read: %q %v", data, err)
+	}
+
+	// Other storages don't answer with content cached for this one
+	if _, err = OpenFileBlob(ref.Bid, ref.Key, NewMemoryBlobStorage()); err != ErrBIDNotFound {
+		t.Fatalf("Blob of other storage read: %v", err)
+	}
+
+	// Validation reads the blob from the storage
+	backend.reads = 0
+	if err = ValidateBlob(ref.Bid, ref.Key, storage); err != nil || backend.reads != 1 {
+		t.Fatalf("Blob not validated from the storage: %v, %v reads", err, backend.reads)
+	}
+
+	// Deleted blobs are dropped from the cache
+	if err = storage.Delete(ref.Bid); err != nil {
+		t.Fatal(err)
+	}
+	if stats := storage.Cache().Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Fatalf("Deleted blob kept in the cache: %+v", stats)
+	}
+	if _, err = OpenFileBlob(ref.Bid, ref.Key, storage); err != ErrBIDNotFound {
+		t.Fatalf("Deleted blob read: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"reflect"
+)
+
+// Kind of the difference between directory trees
+type ChangeType int
+
+const (
+	ChangeAdded ChangeType = iota
+	ChangeRemoved
+	ChangeModified
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	}
+	return "modified"
+}
+
+// Difference between directory trees found by Diff
+type Change struct {
+	Type ChangeType
+	Path string   // Slash-separated path of the entry within trees
+	Old  DirEntry // Entry in the old tree, empty if added
+	New  DirEntry // Entry in the new tree, empty if removed
+}
+
+// Compare directory blob trees calling fn for every entry added, removed
+// or modified in the new tree. Changes are reported depth-first in the
+// order of names. Directories present in both trees are compared entry by
+// entry and reported as modified only if their metadata changed, added
+// and removed directories are reported as one change without their
+// content. Subtrees with the same blob id are identical and are not read.
+// The first error returned by fn stops the comparison and is returned.
+func Diff(oldRoot, newRoot BlobReference, storage BlobStorage, fn func(Change) error) error {
+	return diffTrees("", oldRoot, newRoot, storage, fn)
+}
+
+func diffTrees(prefix string, oldDir, newDir BlobReference, storage BlobStorage, fn func(Change) error) error {
+	if oldDir.Bid == newDir.Bid {
+		return nil
+	}
+
+	oldEntries, err := readDirEntries(oldDir, storage)
+	if err != nil {
+		return err
+	}
+	newEntries, err := readDirEntries(newDir, storage)
+	if err != nil {
+		return err
+	}
+
+	// Entries of both directories are sorted by names
+	for len(oldEntries) > 0 || len(newEntries) > 0 {
+		switch {
+		case len(newEntries) == 0 || len(oldEntries) > 0 && oldEntries[0].Name < newEntries[0].Name:
+			err = fn(Change{Type: ChangeRemoved, Path: prefix + oldEntries[0].Name, Old: oldEntries[0]})
+			oldEntries = oldEntries[1:]
+		case len(oldEntries) == 0 || newEntries[0].Name < oldEntries[0].Name:
+			err = fn(Change{Type: ChangeAdded, Path: prefix + newEntries[0].Name, New: newEntries[0]})
+			newEntries = newEntries[1:]
+		default:
+			err = diffEntries(prefix, oldEntries[0], newEntries[0], storage, fn)
+			oldEntries, newEntries = oldEntries[1:], newEntries[1:]
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compare entries with the same name
+func diffEntries(prefix string, oldEntry, newEntry DirEntry, storage BlobStorage, fn func(Change) error) error {
+	change := Change{Type: ChangeModified, Path: prefix + newEntry.Name, Old: oldEntry, New: newEntry}
+	if oldEntry.Bid == newEntry.Bid && sameEntryMetadata(oldEntry, newEntry) {
+		return nil
+	}
+	if oldEntry.Bid == newEntry.Bid || !oldEntry.IsBlob() || !newEntry.IsBlob() {
+		return fn(change)
+	}
+
+	// Directories are compared entry by entry, the blob is
+	// modified if the kind of the content has changed
+	oldInfo, err := InspectBlobWithKey(oldEntry.Bid, oldEntry.Key, storage)
+	if err != nil {
+		return err
+	}
+	newInfo, err := InspectBlobWithKey(newEntry.Bid, newEntry.Key, storage)
+	if err != nil {
+		return err
+	}
+	if !oldInfo.IsDir() || !newInfo.IsDir() {
+		return fn(change)
+	}
+	if !sameEntryMetadata(oldEntry, newEntry) {
+		if err = fn(change); err != nil {
+			return err
+		}
+	}
+	return diffTrees(change.Path+"/",
+		BlobReference{Bid: oldEntry.Bid, Key: oldEntry.Key},
+		BlobReference{Bid: newEntry.Bid, Key: newEntry.Key},
+		storage, fn)
+}
+
+// Check whether entries differ in their blobs only
+func sameEntryMetadata(a, b DirEntry) bool {
+	return a.Type == b.Type && a.MimeType == b.MimeType && a.Target == b.Target &&
+		a.Mode == b.Mode && a.ModTime.Equal(b.ModTime) && reflect.DeepEqual(a.Attrs, b.Attrs)
+}
+
+func readDirEntries(dir BlobReference, storage BlobStorage) ([]DirEntry, error) {
+	reader, err := OpenDirBlob(dir.Bid, dir.Key, storage)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return reader.Entries()
+}
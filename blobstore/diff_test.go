@@ -0,0 +1,79 @@
+package blobstore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	e := NewTreeEditor(BlobReference{}, storage)
+	for _, dir := range []string{"big", "dir", "dir/sub", "gone"} {
+		e.Mkdir(dir)
+	}
+	for i := 0; i < 50; i++ {
+		e.PutFile(fmt.Sprintf("big/%02d", i), strings.NewReader("same"))
+	}
+	for _, path := range []string{"dir/a", "dir/b", "dir/sub/c", "file", "gone/d", "kind"} {
+		e.PutFile(path, strings.NewReader(path))
+	}
+	oldRoot, err := e.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.PutFile("dir/a", strings.NewReader("changed"))
+	e.Delete("dir/b")
+	e.PutFile("dir/sub/new", strings.NewReader("new"))
+	e.Delete("gone")
+	file, _ := Resolve(oldRoot.Bid, oldRoot.Key, "file", storage)
+	file.ModTime = time.Unix(1400000000, 0)
+	e.PutEntry("file", file)
+	e.Delete("kind")
+	e.Mkdir("kind")
+	e.PutEntry("link", DirEntry{Type: EntryTypeSymlink, Target: "file"})
+	newRoot, err := e.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged subtrees are not read
+	big, _ := Resolve(oldRoot.Bid, oldRoot.Key, "big", storage)
+	if err = storage.Delete(big.Bid); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []string
+	err = Diff(oldRoot, newRoot, storage, func(c Change) error {
+		changes = append(changes, c.Type.String()+" "+c.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"modified dir/a",
+		"removed dir/b",
+		"added dir/sub/new",
+		"modified file",
+		"removed gone",
+		"modified kind",
+		"added link",
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Invalid changes: %q", changes)
+	}
+
+	if err = Diff(newRoot, newRoot, storage, func(c Change) error { return fmt.Errorf("Unexpected change %v", c) }); err != nil {
+		t.Fatal(err)
+	}
+	stop := errors.New("stop")
+	if err = Diff(oldRoot, newRoot, storage, func(Change) error { return stop }); err != stop {
+		t.Fatalf("Diff not stopped: %v", err)
+	}
+}
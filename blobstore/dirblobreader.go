@@ -2,6 +2,7 @@ package blobstore
 
 import (
 	"io"
+	"sort"
 )
 
 type DirBlobReader interface {
@@ -13,13 +14,33 @@ type DirBlobReader interface {
 
 	// Get the next entry from the reader
 	NextEntry() (DirEntry, error)
+
+	// Get the next entry from the reader, io.EOF is returned
+	// once all entries were read
+	Next() (DirEntry, error)
+
+	// Read all entries left
+	Entries() ([]DirEntry, error)
+
+	// Find the entry with given name, ErrDirEntryNotFound is returned if
+	// there's none. Only partial blobs of split directories which may
+	// contain the name are read, reading entries is not affected.
+	Lookup(name string) (DirEntry, error)
+
+	// Release the storage reader of the partial blob being read
+	Close() error
 }
 
 type dirBlobReader struct {
-	baseBlobReader             // Inherit methods of base blob reader
-	Storage        BlobStorage // Blob storage
-	currentReader  io.Reader   // Current reader we work on
-	entriesLeft    int64       // Number of directory entries left to read   
+	baseBlobReader                  // Inherit methods of base blob reader
+	Storage         BlobStorage     // Blob storage
+	currentReader   io.Reader       // Current reader we work on
+	entriesLeft     int64           // Number of directory entries left to read
+	partEntriesLeft int64           // Number of entries left in the current reader
+	partsLeft       []BlobReference // Partial blobs of split directory not opened yet
+	partCountsLeft  []int64         // Numbers of entries of partial blobs not opened yet, chunked directories only
+	extended        bool            // Entries of the current reader are typed
+	bid, key        string          // Directory blob opened
 }
 
 func NewDirBlobReader(storage BlobStorage) DirBlobReader {
@@ -28,8 +49,22 @@ func NewDirBlobReader(storage BlobStorage) DirBlobReader {
 			storage: storage}}
 }
 
+// Open the directory blob with given bid and key
+func OpenDirBlob(bid, key string, storage BlobStorage) (DirBlobReader, error) {
+	reader := NewDirBlobReader(storage)
+	if err := reader.Open(bid, key); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
 func (d *dirBlobReader) Open(bid, key string) error {
 
+	d.Close()
+	d.entriesLeft, d.partEntriesLeft, d.partsLeft = 0, 0, nil
+	d.extended = false
+	d.bid, d.key = canonicalForm(bid), canonicalForm(key)
+
 	// Get the raw blob reader
 	reader, blobType, err := d.openInternal(bid, key, validationMethodHash)
 	if err != nil {
@@ -39,26 +74,46 @@ func (d *dirBlobReader) Open(bid, key string) error {
 	// Validate the blob type
 	switch blobType {
 
-	case blobTypeSimpleStaticDir:
+	case blobTypeSimpleStaticDir, blobTypeSimpleStaticDirV2:
 		d.currentReader = reader
+		d.extended = blobType == blobTypeSimpleStaticDirV2
 		if d.entriesLeft, err = deserializeInt(reader); err != nil {
 			return err
 		}
 		if d.entriesLeft < 0 || d.entriesLeft > maxSimpleDirEntries {
 			return ErrMalformedDirInvalidEntriesCount
 		}
+		d.partEntriesLeft = d.entriesLeft
 		if err = d.eofTest(); err != nil {
 			return err
 		}
 		return nil
 
 	case blobTypeSplitStaticDir:
-		panic("Split directory blobs are unimplemented")
+		defer closeReader(reader)
+		if d.entriesLeft, d.partsLeft, err = readSplitDirData(reader); err != nil {
+			return err
+		}
+		return nil
+
+	case blobTypeChunkedStaticDir:
+		defer closeReader(reader)
+		if d.entriesLeft, d.partCountsLeft, d.partsLeft, err = readChunkedDirData(reader); err != nil {
+			return err
+		}
+		return nil
 	}
 
+	closeReader(reader)
 	return ErrInvalidFileBlobType
 }
 
+func (d *dirBlobReader) Close() error {
+	closeReader(d.currentReader)
+	d.currentReader = nil
+	return nil
+}
+
 func (d *dirBlobReader) IsNextEntry() bool {
 	return d.entriesLeft > 0
 }
@@ -71,29 +126,323 @@ func (d *dirBlobReader) NextEntry() (entry DirEntry, err error) {
 		return
 	}
 
+	// Partial blobs of split directories are opened on demand
+	if d.partEntriesLeft == 0 {
+		if err = d.openNextPart(); err != nil {
+			return
+		}
+	}
+
 	// Make sure the nober of entries left decreases
 	// even in case of an error
 	d.entriesLeft--
+	d.partEntriesLeft--
 
 	// Read one entry
-	if err = entry.deserialize(d.currentReader); err != nil {
+	if d.extended {
+		err = entry.deserializeExtended(d.currentReader)
+	} else {
+		err = entry.deserialize(d.currentReader)
+	}
+	if err != nil {
+		return
+	}
+
+	// After the last entry there must be no more data
+	if err = d.eofTest(); err != nil {
 		return
 	}
 
-	err = nil
 	return
 }
 
+func (d *dirBlobReader) Next() (entry DirEntry, err error) {
+	if !d.IsNextEntry() {
+		return entry, io.EOF
+	}
+	return d.NextEntry()
+}
+
+func (d *dirBlobReader) Entries() (entries []DirEntry, err error) {
+	for d.IsNextEntry() {
+		entry, err := d.NextEntry()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (d *dirBlobReader) Lookup(name string) (DirEntry, error) {
+
+	reader, blobType, err := d.openInternal(d.bid, d.key, validationMethodHash)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	defer closeReader(reader)
+
+	var (
+		parts  []BlobReference
+		counts []int64
+		total  int64
+	)
+	switch blobType {
+
+	case blobTypeSimpleStaticDir, blobTypeSimpleStaticDirV2:
+		entries, err := readSimpleDirEntries(reader, blobType)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		return findDirEntry(entries, name)
+
+	case blobTypeSplitStaticDir:
+		if total, parts, err = readSplitDirData(reader); err != nil {
+			return DirEntry{}, err
+		}
+		// All partial blobs but the last one are full
+		counts = make([]int64, len(parts))
+		for i := range counts {
+			counts[i] = maxSimpleDirEntries
+		}
+		counts[len(counts)-1] = total - int64(len(parts)-1)*maxSimpleDirEntries
+
+	case blobTypeChunkedStaticDir:
+		if _, counts, parts, err = readChunkedDirData(reader); err != nil {
+			return DirEntry{}, err
+		}
+
+	default:
+		return DirEntry{}, ErrInvalidFileBlobType
+	}
+
+	// Partial blobs contain consecutive ranges of sorted entries,
+	// the range containing the name is searched by bisection
+	for lo, hi := 0, len(parts)-1; lo <= hi; {
+		mid := (lo + hi) / 2
+		entries, err := d.readDirPart(parts[mid], counts[mid])
+		if err != nil {
+			return DirEntry{}, err
+		}
+		switch {
+		case name < entries[0].Name:
+			hi = mid - 1
+		case name > entries[len(entries)-1].Name:
+			lo = mid + 1
+		default:
+			return findDirEntry(entries, name)
+		}
+	}
+	return DirEntry{}, ErrDirEntryNotFound
+}
+
+// Read all entries of the partial blob of the split directory
+func (d *dirBlobReader) readDirPart(part BlobReference, count int64) ([]DirEntry, error) {
+	reader, blobType, err := d.openInternal(part.Bid, part.Key, validationMethodHash)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader(reader)
+	if blobType != blobTypeSimpleStaticDir && blobType != blobTypeSimpleStaticDirV2 {
+		return nil, ErrInvalidDirSubBlobType
+	}
+	entries, err := readSimpleDirEntries(reader, blobType)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(entries)) != count {
+		return nil, ErrMalformedDirInvalidEntriesCount
+	}
+	return entries, nil
+}
+
+// Find the entry in sorted entries
+func findDirEntry(entries []DirEntry, name string) (DirEntry, error) {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Name >= name })
+	if i == len(entries) || entries[i].Name != name {
+		return DirEntry{}, ErrDirEntryNotFound
+	}
+	return entries[i], nil
+}
+
+func (d *dirBlobReader) openNextPart() error {
+
+	if len(d.partsLeft) == 0 {
+		return ErrMalformedDirInvalidEntriesCount
+	}
+
+	// The previous partial blob has been read to the end
+	d.Close()
+
+	reader, blobType, err := d.openInternal(d.partsLeft[0].Bid, d.partsLeft[0].Key, validationMethodHash)
+	if err != nil {
+		return err
+	}
+	if blobType != blobTypeSimpleStaticDir && blobType != blobTypeSimpleStaticDirV2 {
+		closeReader(reader)
+		return ErrInvalidDirSubBlobType
+	}
+
+	// All partial blobs but the last one must be full unless
+	// numbers of their entries are listed
+	count, err := deserializeInt(reader)
+	if err != nil {
+		closeReader(reader)
+		return err
+	}
+	expected := d.entriesLeft
+	if d.partCountsLeft != nil {
+		expected = d.partCountsLeft[0]
+		d.partCountsLeft = d.partCountsLeft[1:]
+	} else if expected > maxSimpleDirEntries {
+		expected = maxSimpleDirEntries
+	}
+	if count != expected {
+		closeReader(reader)
+		return ErrMalformedDirInvalidEntriesCount
+	}
+
+	d.partsLeft = d.partsLeft[1:]
+	d.currentReader = reader
+	d.extended = blobType == blobTypeSimpleStaticDirV2
+	d.partEntriesLeft = count
+	return nil
+}
+
 func (d *dirBlobReader) eofTest() error {
-	if !d.IsNextEntry() && !d.atEOF() {
+	if d.partEntriesLeft == 0 && d.currentReader != nil {
 		// There must be no more data if we're at the end
 		// of data stream
-		return ErrMalformedDirExtraData
+		return checkEOF(d.currentReader, ErrMalformedDirExtraData)
 	}
 	return nil
 }
 
-func (d *dirBlobReader) atEOF() bool {
-	// TODO: We're using this for validation only, implement the proper version
-	return true
+// Read all entries of the simple directory blob, the reader must be
+// positioned right after the blob type. The whole blob is read thus
+// its content is validated.
+func readSimpleDirEntries(reader io.Reader, blobType int64) ([]DirEntry, error) {
+
+	count, err := deserializeInt(reader)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || count > maxSimpleDirEntries {
+		return nil, ErrMalformedDirInvalidEntriesCount
+	}
+
+	entries := make([]DirEntry, count)
+	for i := range entries {
+		if blobType == blobTypeSimpleStaticDirV2 {
+			err = entries[i].deserializeExtended(reader)
+		} else {
+			err = entries[i].deserialize(reader)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = checkEOF(reader, ErrMalformedDirExtraData); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Read the content of the split directory blob, the reader
+// must be positioned right after the blob type
+func readSplitDirData(masterBlobReader io.Reader) (totalEntries int64, parts []BlobReference, err error) {
+
+	if totalEntries, err = deserializeInt(masterBlobReader); err != nil {
+		return
+	}
+
+	partsCnt, err := deserializeInt(masterBlobReader)
+	if err != nil {
+		return
+	}
+
+	// Split directory must not fit into a simple one
+	if partsCnt < 2 || partsCnt > maxSaneSplitDirParts {
+		return 0, nil, ErrMalformedSplitDirPartsCount
+	}
+
+	// All partial blobs but the last one are full,
+	// the last one must contain at least one entry
+	maxEntries := partsCnt * maxSimpleDirEntries
+	minEntries := maxEntries - maxSimpleDirEntries + 1
+	if totalEntries < minEntries || totalEntries > maxEntries {
+		return 0, nil, ErrMalformedDirInvalidEntriesCount
+	}
+
+	// Parts are appended as they're read, the declared count
+	// does not allocate memory upfront
+	for i := int64(0); i < partsCnt; i++ {
+		var part BlobReference
+		if part.Bid, err = deserializeString(masterBlobReader, maxSaneBidLength); err != nil {
+			return 0, nil, err
+		}
+		if part.Key, err = deserializeString(masterBlobReader, maxSaneKeyLength); err != nil {
+			return 0, nil, err
+		}
+		parts = append(parts, part)
+	}
+
+	if err = checkEOF(masterBlobReader, ErrMalformedDirExtraData); err != nil {
+		return 0, nil, err
+	}
+
+	return
+}
+
+// Read the content of the chunked directory blob, the reader
+// must be positioned right after the blob type
+func readChunkedDirData(masterBlobReader io.Reader) (totalEntries int64, counts []int64, parts []BlobReference, err error) {
+
+	if totalEntries, err = deserializeInt(masterBlobReader); err != nil {
+		return
+	}
+	if totalEntries < 0 || totalEntries > maxSaneSplitDirParts*maxSimpleDirEntries {
+		return 0, nil, nil, ErrMalformedDirInvalidEntriesCount
+	}
+
+	partsCnt, err := deserializeInt(masterBlobReader)
+	if err != nil {
+		return
+	}
+	if partsCnt < 2 || partsCnt > maxSaneSplitDirParts {
+		return 0, nil, nil, ErrMalformedSplitDirPartsCount
+	}
+
+	// Numbers of entries must sum up to the total, partial blobs can't be empty
+	sum := int64(0)
+	for i := int64(0); i < partsCnt; i++ {
+		count, err := deserializeInt(masterBlobReader)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if count < 1 || count > maxSimpleDirEntries {
+			return 0, nil, nil, ErrMalformedDirInvalidEntriesCount
+		}
+		var part BlobReference
+		if part.Bid, err = deserializeString(masterBlobReader, maxSaneBidLength); err != nil {
+			return 0, nil, nil, err
+		}
+		if part.Key, err = deserializeString(masterBlobReader, maxSaneKeyLength); err != nil {
+			return 0, nil, nil, err
+		}
+
+		sum += count
+		counts = append(counts, count)
+		parts = append(parts, part)
+	}
+	if sum != totalEntries {
+		return 0, nil, nil, ErrMalformedDirInvalidEntriesCount
+	}
+
+	if err = checkEOF(masterBlobReader, ErrMalformedDirExtraData); err != nil {
+		return 0, nil, nil, err
+	}
+
+	return
 }
@@ -1,7 +1,12 @@
 package blobstore
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func genTestDirData() (BlobStorage, *DirBlobWriter, DirBlobReader) {
@@ -26,10 +31,11 @@ func testMultipleEntriesDir(t *testing.T, entries []DirEntry) {
 		w.AddEntry(entry)
 	}
 
-	bid, key, err := w.Finalize()
+	ref, err := w.Finalize()
 	if err != nil {
 		t.Error(err)
 	}
+	bid, key := ref.Bid, ref.Key
 
 	if err = r.Open(bid, key); err != nil {
 		t.Error(err)
@@ -51,7 +57,7 @@ func testMultipleEntriesDir(t *testing.T, entries []DirEntry) {
 			t.Error("Read unknown entry: " + entry.Name)
 		}
 
-		if entry != entry2 {
+		if !reflect.DeepEqual(entry, entry2) {
 			t.Error("Entries do not match: " + entry.Name)
 		}
 
@@ -95,3 +101,373 @@ func TestDirVectors(t *testing.T) {
 		testMultipleEntriesDir(t, data)
 	}
 }
+
+func genEntries(count int) []DirEntry {
+	entries := make([]DirEntry, count)
+	for i := range entries {
+		entries[i] = DirEntry{
+			Name:     fmt.Sprintf("file%05d.txt", i),
+			MimeType: "text/plain",
+			Bid:      fmt.Sprintf("bid%d", i),
+			Key:      fmt.Sprintf("key%d", i)}
+	}
+	return entries
+}
+
+func TestDirNextAndEntries(t *testing.T) {
+
+	for _, count := range []int{0, 3, maxSimpleDirEntries, maxSimpleDirEntries + 1, 2*maxSimpleDirEntries + 5} {
+
+		storage, w, _ := genTestDirData()
+		entries := genEntries(count)
+		for i := len(entries) - 1; i >= 0; i-- {
+			w.AddEntry(entries[i])
+		}
+		ref, err := w.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bid, key := ref.Bid, ref.Key
+
+		r, err := OpenDirBlob(bid, key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; ; i++ {
+			entry, err := r.Next()
+			if err == io.EOF {
+				if i != count {
+					t.Fatalf("Invalid number of entries read: %v, expected %v", i, count)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entry, entries[i]) {
+				t.Fatalf("Invalid entry %v: %v", i, entry)
+			}
+		}
+
+		r, _ = OpenDirBlob(bid, key, storage)
+		read, err := r.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != count || (count > 0 && !reflect.DeepEqual(read[count-1], entries[count-1])) {
+			t.Fatalf("Invalid entries read: %v", len(read))
+		}
+
+		info, err := InspectBlobWithKey(bid, key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.IsDir() || info.IsSplit() != (count > maxSimpleDirEntries) || info.EntriesCount != int64(count) {
+			t.Fatalf("Invalid dir blob info: %+v", info)
+		}
+		if err = ValidateBlob(bid, key, storage); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func createSplitDir(t *testing.T, storage BlobStorage, totalEntries int64, parts ...BlobReference) (bid, key string) {
+	var b bytes.Buffer
+	serializeInt(totalEntries, &b)
+	serializeInt(int64(len(parts)), &b)
+	for _, part := range parts {
+		serializeString(part.Bid, &b)
+		serializeString(part.Key, &b)
+	}
+	bid, key, err := CreateTypedBlob(blobTypeSplitStaticDir, b.Bytes(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestMalformedSplitDir(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	full := DirBlobWriter{Storage: storage}
+	for _, entry := range genEntries(maxSimpleDirEntries) {
+		full.AddEntry(entry)
+	}
+	fullRef, _ := full.Finalize()
+	fullBid, fullKey := fullRef.Bid, fullRef.Key
+	short := DirBlobWriter{Storage: storage}
+	short.AddEntry(genEntries(1)[0])
+	shortRef, _ := short.Finalize()
+	shortBid, shortKey := shortRef.Bid, shortRef.Key
+	fileBid, fileKey, _ := CreateTypedBlob(blobTypeSimpleStaticFile, []byte("data"), storage)
+
+	fullPart := BlobReference{Bid: fullBid, Key: fullKey}
+	shortPart := BlobReference{Bid: shortBid, Key: shortKey}
+	filePart := BlobReference{Bid: fileBid, Key: fileKey}
+
+	// Header errors are found when opening
+	for _, d := range []struct {
+		total int64
+		parts []BlobReference
+		err   error
+	}{
+		{1, []BlobReference{shortPart}, ErrMalformedSplitDirPartsCount},
+		{maxSimpleDirEntries, []BlobReference{fullPart, shortPart}, ErrMalformedDirInvalidEntriesCount},
+		{2*maxSimpleDirEntries + 1, []BlobReference{fullPart, fullPart}, ErrMalformedDirInvalidEntriesCount},
+	} {
+		bid, key := createSplitDir(t, storage, d.total, d.parts...)
+		if _, err := OpenDirBlob(bid, key, storage); err != d.err {
+			t.Fatalf("Invalid error when opening split dir: %v, expected %v", err, d.err)
+		}
+	}
+
+	// Partial blob errors are found while reading entries
+	for _, d := range []struct {
+		total int64
+		parts []BlobReference
+		err   error
+	}{
+		{maxSimpleDirEntries + 1, []BlobReference{shortPart, shortPart}, ErrMalformedDirInvalidEntriesCount},
+		{maxSimpleDirEntries + 2, []BlobReference{fullPart, shortPart}, ErrMalformedDirInvalidEntriesCount},
+		{maxSimpleDirEntries + 1, []BlobReference{fullPart, filePart}, ErrInvalidDirSubBlobType},
+	} {
+		bid, key := createSplitDir(t, storage, d.total, d.parts...)
+		r, err := OpenDirBlob(bid, key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = r.Entries(); err != d.err {
+			t.Fatalf("Invalid error when reading split dir: %v, expected %v", err, d.err)
+		}
+	}
+
+	// References of split directory point to partial blobs
+	bid, key := createSplitDir(t, storage, maxSimpleDirEntries+1, fullPart, shortPart)
+	refs, err := GetBlobReferences(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0] != fullPart || refs[1] != shortPart {
+		t.Fatalf("Invalid references: %v", refs)
+	}
+	if _, err = StrictDecodeBlob(bid, key, storage); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirTypedEntries(t *testing.T) {
+
+	for _, count := range []int{3, 2*maxSimpleDirEntries + 5} {
+
+		storage, w, _ := genTestDirData()
+		entries := genEntries(count)
+		entries[1] = DirEntry{Name: entries[1].Name, Type: EntryTypeSymlink, Target: "../target"}
+		entries[2] = DirEntry{Name: entries[2].Name, Bid: "bid", Key: "key", Type: 7, Target: "future"}
+		for _, entry := range entries {
+			w.AddEntry(entry)
+		}
+		ref, err := w.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, _ := OpenDirBlob(ref.Bid, ref.Key, storage)
+		read, err := r.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != count {
+			t.Fatalf("Invalid number of entries read: %v, expected %v", len(read), count)
+		}
+		for i := range read {
+			if !reflect.DeepEqual(read[i], entries[i]) {
+				t.Fatalf("Invalid entry %v: %+v, expected %+v", i, read[i], entries[i])
+			}
+		}
+		if read[0].IsBlob() != true || read[1].IsBlob() != false {
+			t.Fatalf("Invalid entry types: %v, %v", read[0].Type, read[1].Type)
+		}
+
+		if err = ValidateBlob(ref.Bid, ref.Key, storage); err != nil {
+			t.Fatal(err)
+		}
+		info, err := InspectBlobWithKey(ref.Bid, ref.Key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.IsDir() || info.EntriesCount != int64(count) {
+			t.Fatalf("Invalid dir blob info: %+v", info)
+		}
+	}
+}
+
+func TestDirFormatVersion(t *testing.T) {
+
+	for _, d := range []struct {
+		entry    DirEntry
+		blobType int64
+	}{
+		{DirEntry{Name: "file", Bid: "bid", Key: "key"}, blobTypeSimpleStaticDir},
+		{DirEntry{Name: "link", Type: EntryTypeSymlink, Target: "file"}, blobTypeSimpleStaticDirV2},
+	} {
+		storage, w, _ := genTestDirData()
+		w.AddEntry(d.entry)
+		ref, err := w.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := InspectBlobWithKey(ref.Bid, ref.Key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.BlobType != d.blobType {
+			t.Fatalf("Invalid blob type for entry %+v: %x, expected %x", d.entry, info.BlobType, d.blobType)
+		}
+	}
+
+	// Insane entry types are rejected
+	var b bytes.Buffer
+	serializeInt(1, &b)
+	serializeInt(maxSaneEntryType+1, &b)
+	for i := 0; i < 5; i++ {
+		serializeString("", &b)
+	}
+	storage := NewMemoryBlobStorage()
+	bid, key, err := CreateTypedBlob(blobTypeSimpleStaticDirV2, b.Bytes(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := OpenDirBlob(bid, key, storage)
+	if _, err = r.Next(); err != ErrInvalidEntryType {
+		t.Fatalf("Invalid error for insane entry type: %v", err)
+	}
+}
+
+func TestDirEntryMetadata(t *testing.T) {
+
+	storage, w, _ := genTestDirData()
+	entries := []DirEntry{
+		{Name: "a", Bid: "bid", Key: "key", Mode: 0640, ModTime: time.Unix(1400000000, 5)},
+		{Name: "b", Bid: "bid", Key: "key", Attrs: map[string]string{"user.origin": "backup", "": "empty"}},
+		{Name: "c", Type: EntryTypeSymlink, Target: "a", ModTime: time.Unix(-5, 0)},
+	}
+	for _, entry := range entries {
+		w.AddEntry(entry)
+	}
+	ref, err := w.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := OpenDirBlob(ref.Bid, ref.Key, storage)
+	read, err := r.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Fatalf("Invalid entries read: %+v", read)
+	}
+	if err = ValidateBlob(ref.Bid, ref.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields with unknown tags are skipped
+	var b bytes.Buffer
+	serializeInt(1, &b)
+	serializeInt(int64(EntryTypeBlob), &b)
+	for _, s := range []string{"name", "", "bid", "key", ""} {
+		serializeString(s, &b)
+	}
+	serializeInt(2, &b)
+	serializeInt(100, &b)
+	serializeBuffer([]byte{0xff, 0x00}, &b)
+	serializeInt(entryFieldMode, &b)
+	serializeBuffer([]byte{0x40}, &b)
+	bid, key, err := CreateTypedBlob(blobTypeSimpleStaticDirV2, b.Bytes(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ = OpenDirBlob(bid, key, storage)
+	entry, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != "name" || entry.Mode != 0100 || entry.Attrs != nil {
+		t.Fatalf("Invalid entry with unknown field: %+v", entry)
+	}
+	if err = ValidateBlob(bid, key, storage); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirLookup(t *testing.T) {
+
+	for _, d := range []struct {
+		count  int
+		config *WriterConfig
+		reads  int // Maximum number of blobs read by the lookup
+	}{
+		{0, nil, 1},
+		{5, nil, 1},
+		{10*maxSimpleDirEntries + 3, nil, 5},
+		{100, &WriterConfig{MaxDirEntries: 7}, 6},
+	} {
+		storage := &readCountingStorage{BlobStorage: NewMemoryBlobStorage()}
+		w := DirBlobWriter{Storage: storage, Config: d.config}
+		entries := genEntries(d.count)
+		for _, entry := range entries {
+			w.AddEntry(entry)
+		}
+		ref, err := w.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := OpenDirBlob(ref.Bid, ref.Key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < d.count; i += 1 + d.count/10 {
+			storage.reads = 0
+			entry, err := r.Lookup(entries[i].Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entry, entries[i]) {
+				t.Fatalf("Invalid entry found: %+v", entry)
+			}
+			if storage.reads > d.reads {
+				t.Fatalf("Too many blobs read to find the entry in %d entries: %d", d.count, storage.reads)
+			}
+		}
+		for _, name := range []string{"", "a", "file00000", "file00000.txt0", "z"} {
+			if _, err = r.Lookup(name); err != ErrDirEntryNotFound {
+				t.Fatalf("Invalid error for missing entry %q: %v", name, err)
+			}
+		}
+
+		// Reading entries is not affected
+		read, err := r.Entries()
+		if err != nil || len(read) != d.count {
+			t.Fatalf("Invalid entries read after the lookup: %v %v", len(read), err)
+		}
+	}
+}
+
+func FuzzDirBlobReader(f *testing.F) {
+	storage, seeds := newFuzzStorage(f)
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, content []byte) {
+		bid, key := putFuzzedBlob(t, content, storage)
+
+		if reader, err := OpenDirBlob(bid, key, storage); err == nil {
+			reader.Entries()
+		}
+		if reader, err := OpenDirBlob(bid, key, storage); err == nil {
+			reader.Lookup("b")
+		}
+		GetBlobReferences(bid, key, storage)
+	})
+}
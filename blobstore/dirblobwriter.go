@@ -6,6 +6,7 @@ package blobstore
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"sort"
 )
@@ -31,47 +32,242 @@ type DirBlobWriter struct {
 	// Storage Object
 	Storage BlobStorage
 
+	// Context of the upload, once it's done finalization fails
+	// with the context error
+	Context context.Context
+
+	// Layout of created blobs, the default one is used if nil
+	Config *WriterConfig
+
 	// A list of currently handled entries
 	entries []*DirEntry
+
+	// Positions of entries in the list by their names
+	names map[string]int
+
+	// Size of the directory listing serialized by the last finalization
+	size int64
+
+	// Statistics of blobs stored so far
+	stats UploadStats
 }
 
-// Adds a new entry to the directory
-// TODO: Don't allow adding duplicated entries 
+// Adds a new entry to the directory, ErrDuplicateEntry is returned
+// if there already is an entry with the same name
 func (d *DirBlobWriter) AddEntry(entry DirEntry) error {
-	d.entries = append(d.entries, &entry)
+	if _, exists := d.names[entry.Name]; exists {
+		return ErrDuplicateEntry
+	}
+	d.addEntry(&entry)
 	return nil
 }
 
-func (d *DirBlobWriter) Finalize() (bid string, key string, err error) {
-	if len(d.entries) <= maxSimpleDirEntries {
-		return d.finalizeSimple()
+// Adds a new entry to the directory or replaces the existing one
+// with the same name
+func (d *DirBlobWriter) UpsertEntry(entry DirEntry) {
+	if pos, exists := d.names[entry.Name]; exists {
+		d.entries[pos] = &entry
+		return
+	}
+	d.addEntry(&entry)
+}
+
+func (d *DirBlobWriter) addEntry(entry *DirEntry) {
+	if d.names == nil {
+		d.names = make(map[string]int)
+	}
+	d.names[entry.Name] = len(d.entries)
+	d.entries = append(d.entries, entry)
+}
+
+// Sort entries by name, positions of entries are updated
+func (d *DirBlobWriter) sortEntries() {
+	sort.Sort(sortByName(d.entries))
+	for i, entry := range d.entries {
+		d.names[entry.Name] = i
+	}
+}
+
+// Create the directory blob from entries added so far, the writer
+// can be finalized again after more entries are added
+func (d *DirBlobWriter) Finalize() (FinalizeResult, error) {
+	if d.Context != nil {
+		if err := d.Context.Err(); err != nil {
+			return FinalizeResult{}, err
+		}
 	}
-	return d.finalizeSplit()
+	if err := d.Config.validate(); err != nil {
+		return FinalizeResult{}, err
+	}
+	before := d.stats
+	d.size = 0
+
+	var bid, key string
+	var err error
+	if len(d.entries) <= d.Config.maxDirEntries() {
+		bid, key, err = d.finalizeSimple()
+	} else {
+		bid, key, err = d.finalizeSplit()
+	}
+	if err != nil {
+		return FinalizeResult{}, err
+	}
+	return FinalizeResult{
+		BlobReference: BlobReference{Bid: bid, Key: key},
+		Size:          d.size,
+		StoredSize:    d.stats.storedBytes() - before.storedBytes(),
+		Blobs:         d.stats.blobs() - before.blobs(),
+	}, nil
 }
 
 func (d *DirBlobWriter) finalizeSimple() (bid string, key string, err error) {
 
 	// Sort entries by name
-	sort.Sort(sortByName(d.entries))
+	d.sortEntries()
+
+	return createSimpleDirBlob(d.entries, d.storage(), d.Config, &d.stats, &d.size)
+}
+
+func (d *DirBlobWriter) finalizeSplit() (bid string, key string, err error) {
+
+	// Sort entries by name, partial blobs contain consecutive ranges of entries
+	d.sortEntries()
+
+	maxEntries := d.Config.maxDirEntries()
+	var (
+		parts  []BlobReference
+		counts []int
+	)
+	for entries := d.entries; len(entries) > 0; {
+		count := len(entries)
+		if count > maxEntries {
+			count = maxEntries
+		}
+
+		partBid, partKey, err := createSimpleDirBlob(entries[:count], d.storage(), d.Config, &d.stats, &d.size)
+		if err != nil {
+			return "", "", err
+		}
+		parts = append(parts, BlobReference{Bid: partBid, Key: partKey})
+		counts = append(counts, count)
+		entries = entries[count:]
+	}
 
-	// Serialize the data
+	// Numbers of entries of partial blobs are listed
+	// only if they're not full
 	var buffer bytes.Buffer
-	buffer.WriteByte(blobTypeSimpleStaticDir)
+	chunked := maxEntries != maxSimpleDirEntries
+	if chunked {
+		buffer.WriteByte(blobTypeChunkedStaticDir)
+	} else {
+		buffer.WriteByte(blobTypeSplitStaticDir)
+	}
 
-	// Number of entries first
+	// Total number of entries and the list of partial blobs
 	serializeInt(int64(len(d.entries)), &buffer)
+	serializeInt(int64(len(parts)), &buffer)
+	for i, part := range parts {
+		if chunked {
+			serializeInt(int64(counts[i]), &buffer)
+		}
+		serializeString(part.Bid, &buffer)
+		serializeString(part.Key, &buffer)
+	}
 
-	// All entries right after
-	for _, entry := range d.entries {
-		entry.serialize(&buffer)
+	return createHashValidatedBlobFromReaderGenerator(
+		func() io.Reader { return bytes.NewReader(buffer.Bytes()) },
+		d.storage(), d.Config, &d.stats)
+}
+
+// Get the storage bound to the context of the writer
+func (d *DirBlobWriter) storage() BlobStorage {
+	if d.Context == nil {
+		return d.Config.limitStorage(d.Storage)
 	}
+	return d.Config.limitStorage(WithContext(d.Context, d.Storage))
+}
+
+// Get statistics of blobs stored by the writer so far
+func (d *DirBlobWriter) Stats() UploadStats {
+	return d.stats
+}
+
+// Create simple directory blob from sorted entries, the size of
+// the serialized listing is added to size
+func createSimpleDirBlob(entries []*DirEntry, storage BlobStorage, config *WriterConfig, stats *UploadStats, size *int64) (bid string, key string, err error) {
+	var content bytes.Buffer
+	blobType := serializeSimpleDir(entries, &content)
+
+	var buffer bytes.Buffer
+	serializeInt(blobType, &buffer)
+	buffer.Write(content.Bytes())
+	*size += int64(buffer.Len())
 
 	// Create blob out of the data
 	return createHashValidatedBlobFromReaderGenerator(
 		func() io.Reader { return bytes.NewReader(buffer.Bytes()) },
-		d.Storage)
+		storage, config, stats)
 }
 
-func (d *DirBlobWriter) finalizeSplit() (bid string, key string, err error) {
-	panic("Unimplemented: split dir blob")
+// Serialize sorted entries of the simple directory blob, the blob type
+// is returned. The extended format is used only if any of entries
+// requires it.
+func serializeSimpleDir(entries []*DirEntry, buffer *bytes.Buffer) (blobType int64) {
+
+	// Typed entries need the extended format
+	extended := false
+	for _, entry := range entries {
+		extended = extended || entry.isExtended()
+	}
+
+	// Number of entries first
+	serializeInt(int64(len(entries)), buffer)
+
+	// All entries right after
+	for _, entry := range entries {
+		if extended {
+			entry.serializeExtended(buffer)
+		} else {
+			entry.serialize(buffer)
+		}
+	}
+
+	if extended {
+		return blobTypeSimpleStaticDirV2
+	}
+	return blobTypeSimpleStaticDir
+}
+
+// Get the content of the simple directory blob with given entries, the
+// blob is created by CreateTypedBlob with the returned blob type. Blobs
+// are the same as ones created by DirBlobWriter with the same entries.
+// Entries are sorted by name, names must be unique.
+func MarshalDirBlob(entries []DirEntry) (blobType int64, content []byte, err error) {
+	if len(entries) > maxSimpleDirEntries {
+		return 0, nil, ErrTooManyDirEntries
+	}
+	sorted := make([]*DirEntry, len(entries))
+	for i := range entries {
+		sorted[i] = &entries[i]
+	}
+	sort.Sort(sortByName(sorted))
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Name == sorted[i-1].Name {
+			return 0, nil, ErrDuplicateEntry
+		}
+	}
+
+	var buffer bytes.Buffer
+	blobType = serializeSimpleDir(sorted, &buffer)
+	return blobType, buffer.Bytes(), nil
+}
+
+// Get entries from the content of the simple directory blob of given
+// type, i.e. one returned by OpenTypedBlob. Entries are returned in the
+// order they're stored in.
+func UnmarshalDirBlob(blobType int64, content []byte) ([]DirEntry, error) {
+	if blobType != blobTypeSimpleStaticDir && blobType != blobTypeSimpleStaticDirV2 {
+		return nil, ErrInvalidSimpleDirBlobType
+	}
+	return readSimpleDirEntries(bytes.NewReader(content), blobType)
 }
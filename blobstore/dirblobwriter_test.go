@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/hex"
 	"io/ioutil"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 type testDirEntry struct{ name, mimeType, bid, key string }
@@ -106,10 +109,11 @@ func TestSimpleDirs(t *testing.T) {
 			})
 		}
 
-		rbid, rkey, err := dw.Finalize()
+		ref, err := dw.Finalize()
 		if err != nil {
 			t.Error(err)
 		}
+		rbid, rkey := ref.Bid, ref.Key
 
 		if rbid != bid {
 			t.Errorf("Invalid blob id generated, got: %v..., expected: %v...", rbid[:16], bid[:16])
@@ -151,3 +155,165 @@ func TestSimpleDirs(t *testing.T) {
 		}
 	}
 }
+
+func TestDuplicateDirEntries(t *testing.T) {
+
+	storage, w, _ := genTestDirData()
+
+	if err := w.AddEntry(DirEntry{Name: "b", Bid: "bid1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddEntry(DirEntry{Name: "a", Bid: "bid2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddEntry(DirEntry{Name: "b", Bid: "bid3"}); err != ErrDuplicateEntry {
+		t.Fatalf("Invalid error for duplicated entry: %v", err)
+	}
+
+	w.UpsertEntry(DirEntry{Name: "b", Bid: "bid4"})
+	w.UpsertEntry(DirEntry{Name: "c", Bid: "bid5"})
+
+	ref, err := w.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+
+	// Entries can be replaced after finalizing
+	w.UpsertEntry(DirEntry{Name: "a", Bid: "bid6"})
+	ref2, err := w.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid2, key2 := ref2.Bid, ref2.Key
+
+	for _, d := range []struct {
+		bid, key string
+		bids     string
+	}{
+		{bid, key, "bid2 bid4 bid5"},
+		{bid2, key2, "bid6 bid4 bid5"},
+	} {
+		r, _ := OpenDirBlob(d.bid, d.key, storage)
+		entries, err := r.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bids []string
+		for _, entry := range entries {
+			bids = append(bids, entry.Bid)
+		}
+		if strings.Join(bids, " ") != d.bids {
+			t.Fatalf("Invalid directory entries: %v", entries)
+		}
+	}
+}
+
+func TestMarshalDirBlob(t *testing.T) {
+	modTime := time.Date(2014, 5, 13, 16, 53, 20, 0, time.UTC)
+	for _, entries := range [][]DirEntry{
+		{},
+		{{Name: "b", MimeType: "text/plain", Bid: "bid1", Key: "key1"}, {Name: "a", Bid: "bid2", Key: "key2"}},
+		{
+			{Name: "link", Type: EntryTypeSymlink, Target: "file"},
+			{Name: "file", Bid: "bid3", Key: "key3", Mode: 0644, ModTime: modTime, Attrs: map[string]string{"x": "y"}},
+		},
+	} {
+		blobType, content, err := MarshalDirBlob(entries)
+		if err != nil {
+			t.Fatalf("Couldn't marshal directory entries: %v", err)
+		}
+
+		// Blobs match ones created by the writer
+		storage := NewMemoryBlobStorage()
+		bid, key, err := CreateTypedBlob(blobType, content, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := DirBlobWriter{Storage: storage}
+		for _, entry := range entries {
+			w.AddEntry(entry)
+		}
+		result, err := w.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Bid != bid || result.Key != key {
+			t.Fatalf("Marshaled directory blob differs from the written one")
+		}
+
+		openedType, reader, err := OpenTypedBlob(bid, key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil || openedType != blobType || !bytes.Equal(data, content) {
+			t.Fatalf("Invalid content of the marshaled blob: %v", err)
+		}
+		decoded, err := UnmarshalDirBlob(blobType, data)
+		if err != nil {
+			t.Fatalf("Couldn't unmarshal directory entries: %v", err)
+		}
+		if len(decoded) != len(entries) {
+			t.Fatalf("Invalid number of unmarshaled entries: %d, expected %d", len(decoded), len(entries))
+		}
+		for i := 1; i < len(decoded); i++ {
+			if decoded[i-1].Name >= decoded[i].Name {
+				t.Fatalf("Unmarshaled entries are not sorted")
+			}
+		}
+		for _, entry := range decoded {
+			if entry.Name == "file" && (!entry.ModTime.Equal(modTime) || entry.Mode != 0644 || entry.Attrs["x"] != "y") {
+				t.Fatalf("Invalid unmarshaled entry: %+v", entry)
+			}
+		}
+	}
+
+	if _, _, err := MarshalDirBlob([]DirEntry{{Name: "a"}, {Name: "a"}}); err != ErrDuplicateEntry {
+		t.Fatalf("Duplicate entries must be rejected, got: %v", err)
+	}
+	if _, _, err := MarshalDirBlob(make([]DirEntry, maxSimpleDirEntries+1)); err != ErrTooManyDirEntries {
+		t.Fatalf("Too many entries must be rejected, got: %v", err)
+	}
+	if _, err := UnmarshalDirBlob(BlobTypeSplitDir, nil); err != ErrInvalidSimpleDirBlobType {
+		t.Fatalf("Split directory must not be unmarshaled, got: %v", err)
+	}
+	if _, err := UnmarshalDirBlob(BlobTypeSimpleDir, []byte{0x00, 0x00}); err != ErrMalformedDirExtraData {
+		t.Fatalf("Extra data must be rejected, got: %v", err)
+	}
+}
+
+func FuzzUnmarshalDirBlob(f *testing.F) {
+	for _, entries := range [][]DirEntry{
+		{{Name: "a", MimeType: "text/plain", Bid: "bid", Key: "key"}},
+		{{Name: "link", Type: EntryTypeSymlink, Target: "a", Mode: 0755, Attrs: map[string]string{"k": "v"}}},
+	} {
+		blobType, content, _ := MarshalDirBlob(entries)
+		f.Add(blobType, content)
+	}
+	f.Fuzz(func(t *testing.T, blobType int64, content []byte) {
+		entries, err := UnmarshalDirBlob(blobType, content)
+		if err != nil {
+			return
+		}
+
+		// Entries which can be marshaled again survive the round trip
+		blobType, content, err = MarshalDirBlob(entries)
+		if err != nil {
+			return
+		}
+		decoded, err := UnmarshalDirBlob(blobType, content)
+		if err != nil {
+			t.Fatalf("Couldn't unmarshal marshaled entries: %v", err)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		if len(decoded) != len(entries) {
+			t.Fatalf("Invalid number of entries after round trip")
+		}
+		for i := range decoded {
+			if !reflect.DeepEqual(decoded[i], entries[i]) {
+				t.Fatalf("Entry changed after round trip: %+v, expected %+v", decoded[i], entries[i])
+			}
+		}
+	})
+}
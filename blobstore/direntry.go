@@ -3,11 +3,60 @@ package blobstore
 import (
 	"bytes"
 	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Kind of the directory entry, stored in directory blobs as the type tag.
+// Readers keep entries of unknown types so that kinds added in the future
+// can be passed along by older code.
+type EntryType int64
+
+const (
+	EntryTypeBlob    EntryType = 0 // File or directory, the kind is stored in the blob
+	EntryTypeSymlink EntryType = 1 // Symbolic link, Bid and Key are empty
+)
+
+// Tags of optional fields of extended directory entries, each field is
+// stored as the tag followed by the length-prefixed value. Readers skip
+// fields with unknown tags, new fields don't need another format version.
+const (
+	entryFieldMode    = 1 // Permission bits
+	entryFieldModTime = 2 // Modification time in nanoseconds since the Unix epoch, signed varint
+	entryFieldAttr    = 3 // Custom attribute, the key followed by the value
 )
 
 // Helper structure for holding one directory entry
 type DirEntry struct {
 	Name, MimeType, Bid, Key string
+
+	// Kind of the entry, only blob entries can be stored in directory
+	// blobs of the first format version
+	Type EntryType
+
+	// Target of the symbolic link
+	Target string
+
+	// Permission bits, zero if not recorded
+	Mode os.FileMode
+
+	// Modification time, zero if not recorded
+	ModTime time.Time
+
+	// Custom attributes, nil if there are none
+	Attrs map[string]string
+}
+
+// Check whether the entry points to a file or directory blob
+func (d *DirEntry) IsBlob() bool {
+	return d.Type == EntryTypeBlob
+}
+
+// Check whether the entry can only be stored in the extended format
+func (d *DirEntry) isExtended() bool {
+	return d.Type != EntryTypeBlob || d.Target != "" ||
+		d.Mode != 0 || !d.ModTime.IsZero() || len(d.Attrs) > 0
 }
 
 func (d *DirEntry) serialize(b *bytes.Buffer) {
@@ -17,6 +66,45 @@ func (d *DirEntry) serialize(b *bytes.Buffer) {
 	serializeString(d.Key, b)
 }
 
+// Serialize the entry in the extended format, the type goes first,
+// optional fields go last
+func (d *DirEntry) serializeExtended(b *bytes.Buffer) {
+	serializeInt(int64(d.Type), b)
+	d.serialize(b)
+	serializeString(d.Target, b)
+
+	var fields [][]byte
+	addField := func(tag int64, value func(*bytes.Buffer)) {
+		var field, v bytes.Buffer
+		value(&v)
+		serializeInt(tag, &field)
+		serializeBuffer(v.Bytes(), &field)
+		fields = append(fields, field.Bytes())
+	}
+	if d.Mode != 0 {
+		addField(entryFieldMode, func(v *bytes.Buffer) { serializeInt(int64(d.Mode.Perm()), v) })
+	}
+	if !d.ModTime.IsZero() {
+		addField(entryFieldModTime, func(v *bytes.Buffer) { serializeVarint(d.ModTime.UnixNano(), v) })
+	}
+	keys := make([]string, 0, len(d.Attrs))
+	for k := range d.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		addField(entryFieldAttr, func(v *bytes.Buffer) {
+			serializeString(k, v)
+			serializeString(d.Attrs[k], v)
+		})
+	}
+
+	serializeInt(int64(len(fields)), b)
+	for _, field := range fields {
+		b.Write(field)
+	}
+}
+
 func (d *DirEntry) deserialize(r io.Reader) (err error) {
 	if d.Name, err = deserializeString(r, maxSaneNameLenght); err != nil {
 		return
@@ -32,3 +120,76 @@ func (d *DirEntry) deserialize(r io.Reader) (err error) {
 	}
 	return nil
 }
+
+func (d *DirEntry) deserializeExtended(r io.Reader) (err error) {
+	entryType, err := deserializeInt(r)
+	if err != nil {
+		return
+	}
+	if entryType < 0 || entryType > maxSaneEntryType {
+		return ErrInvalidEntryType
+	}
+	d.Type = EntryType(entryType)
+	if err = d.deserialize(r); err != nil {
+		return
+	}
+	if d.Target, err = deserializeString(r, maxSaneTargetLength); err != nil {
+		return
+	}
+
+	count, err := deserializeInt(r)
+	if err != nil {
+		return
+	}
+	if count < 0 || count > maxSaneEntryFields {
+		return ErrInvalidEntryFields
+	}
+	for ; count > 0; count-- {
+		var tag int64
+		var value []byte
+		if tag, err = deserializeInt(r); err != nil {
+			return
+		}
+		if tag < 0 || tag > maxSaneEntryFieldTag {
+			return ErrInvalidEntryFields
+		}
+		if value, err = deserializeBuffer(r, maxSaneEntryFieldLength); err != nil {
+			return
+		}
+		if err = d.setField(tag, bytes.NewReader(value)); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// Set the optional field from its serialized value, unknown fields are ignored
+func (d *DirEntry) setField(tag int64, value *bytes.Reader) (err error) {
+	switch tag {
+	case entryFieldMode:
+		var mode int64
+		if mode, err = deserializeInt(value); err != nil {
+			return
+		}
+		d.Mode = os.FileMode(mode).Perm()
+	case entryFieldModTime:
+		var nsec int64
+		if nsec, err = deserializeVarint(value); err != nil {
+			return
+		}
+		d.ModTime = time.Unix(0, nsec)
+	case entryFieldAttr:
+		var k, v string
+		if k, err = deserializeString(value, maxSaneEntryFieldLength); err != nil {
+			return
+		}
+		if v, err = deserializeString(value, maxSaneEntryFieldLength); err != nil {
+			return
+		}
+		if d.Attrs == nil {
+			d.Attrs = make(map[string]string)
+		}
+		d.Attrs[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidEncoding = errors.New("Invalid encoding of the blob id or the key")
+	ErrUnknownEncoding = errors.New("Unknown encoding of blob ids and keys")
+)
+
+// Text encoding of blob ids and keys. Hex is the canonical form used by
+// storages, other encodings are shorter forms for URLs and the command
+// line. Encoded strings start with the multibase prefix of the encoding.
+type Encoding byte
+
+const (
+	EncodingHex    Encoding = 0   // Lowercase hex without prefix
+	EncodingBase32 Encoding = 'b' // Lowercase RFC 4648 base32 without padding
+	EncodingBase58 Encoding = 'z' // Base58 with the bitcoin alphabet
+)
+
+var encodingNames = map[string]Encoding{
+	"hex":    EncodingHex,
+	"base32": EncodingBase32,
+	"base58": EncodingBase58,
+}
+
+// Find the encoding by its name: hex, base32 or base58
+func ParseEncoding(name string) (Encoding, error) {
+	encoding, ok := encodingNames[name]
+	if !ok {
+		return 0, ErrUnknownEncoding
+	}
+	return encoding, nil
+}
+
+// Encode the blob id given in the canonical hex form
+func FormatBID(bid string, encoding Encoding) (string, error) {
+	return formatEncoded(bid, encoding)
+}
+
+// Get the canonical hex form of the blob id in any encoding, hex blob ids
+// are returned as they are
+func ParseBID(s string) (string, error) {
+	return parseEncoded(s)
+}
+
+// Encode the key given in the canonical hex form
+func FormatKey(key string, encoding Encoding) (string, error) {
+	return formatEncoded(key, encoding)
+}
+
+// Get the canonical hex form of the key in any encoding, hex keys
+// are returned as they are
+func ParseKey(s string) (string, error) {
+	return parseEncoded(s)
+}
+
+// Get the canonical form of the blob id or the key given to readers,
+// strings in no known encoding are used as they are
+func canonicalForm(s string) string {
+	if canonical, err := parseEncoded(s); err == nil {
+		return canonical
+	}
+	return s
+}
+
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Blob ids and keys are never shorter, shorter strings with encoding
+// prefixes are not taken as encoded ones
+const minEncodedSize = 16
+
+func formatEncoded(s string, encoding Encoding) (string, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return "", ErrInvalidEncoding
+	}
+
+	switch encoding {
+	case EncodingHex:
+		return strings.ToLower(s), nil
+	case EncodingBase32:
+		return string(EncodingBase32) + strings.ToLower(base32Encoding.EncodeToString(raw)), nil
+	case EncodingBase58:
+		return string(EncodingBase58) + base58Encode(raw), nil
+	}
+	return "", ErrUnknownEncoding
+}
+
+// Hex strings are checked first, lengths of encoded blob ids and keys never
+// match lengths of hex ones thus prefixes which are hex digits are not
+// ambiguous
+func parseEncoded(s string) (string, error) {
+	if len(s)%2 == 0 {
+		if _, err := hex.DecodeString(s); err == nil && s != "" {
+			return s, nil
+		}
+	}
+	if len(s) < 2 {
+		return "", ErrInvalidEncoding
+	}
+
+	var raw []byte
+	var err error
+	switch Encoding(s[0]) {
+	case EncodingBase32:
+		raw, err = base32Encoding.DecodeString(strings.ToUpper(s[1:]))
+	case EncodingBase58:
+		raw, err = base58Decode(s[1:])
+	default:
+		return "", ErrInvalidEncoding
+	}
+	if err != nil || len(raw) < minEncodedSize {
+		return "", ErrInvalidEncoding
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Encode the data as a big-endian number in base 58, each leading
+// zero byte is encoded as the first digit
+func base58Encode(data []byte) string {
+	zeros := 0
+	for zeros < len(data) && data[zeros] == 0 {
+		zeros++
+	}
+
+	// Little-endian digits, log(256)/log(58) < 138/100
+	digits := make([]byte, 0, len(data)*138/100+1)
+	for _, b := range data[zeros:] {
+		carry := int(b)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+
+	out := make([]byte, zeros+len(digits))
+	for i := 0; i < zeros; i++ {
+		out[i] = base58Alphabet[0]
+	}
+	for i, d := range digits {
+		out[len(out)-1-i] = base58Alphabet[d]
+	}
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	// Little-endian bytes
+	var value []byte
+	for i := zeros; i < len(s); i++ {
+		carry := strings.IndexByte(base58Alphabet, s[i])
+		if carry < 0 {
+			return nil, ErrInvalidEncoding
+		}
+		for j := range value {
+			carry += int(value[j]) * 58
+			value[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			value = append(value, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	out := make([]byte, zeros+len(value))
+	for i, b := range value {
+		out[len(out)-1-i] = b
+	}
+	return out, nil
+}
@@ -0,0 +1,72 @@
+package blobstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEncoding(t *testing.T) {
+
+	for _, d := range []struct {
+		hex, base32, base58 string
+	}{
+		{hex.EncodeToString([]byte("Hello World!1234")), "bjbswy3dpeblw64tmmqqtcmrtgq", "z9wWTEnNTUzJGD7cXxts7uu"},
+		{"0000" + hex.EncodeToString([]byte("0123456789abcdef")), "baaadamjsgm2dknrxha4wcytdmrswm", "z116xA5cTR1239iti1EFMiXoT"},
+	} {
+		for encoding, expected := range map[Encoding]string{EncodingHex: d.hex, EncodingBase32: d.base32, EncodingBase58: d.base58} {
+			encoded, err := FormatBID(d.hex, encoding)
+			if err != nil || encoded != expected {
+				t.Fatalf("Invalid encoding of %v: %v %v", d.hex, encoded, err)
+			}
+			if parsed, err := ParseKey(encoded); err != nil || parsed != d.hex {
+				t.Fatalf("Invalid hex form of %v: %v %v", encoded, parsed, err)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "b", "z", "zI0O", "bid", "z123", "missing", "b00000000000000000000000000000000"} {
+		if _, err := ParseBID(s); err != ErrInvalidEncoding {
+			t.Fatalf("Invalid error for %q: %v", s, err)
+		}
+	}
+	if _, err := FormatBID("not hex", EncodingBase58); err != ErrInvalidEncoding {
+		t.Fatalf("Invalid error for the non-hex blob id: %v", err)
+	}
+	if _, err := ParseEncoding("base64"); err != ErrUnknownEncoding {
+		t.Fatalf("Invalid error for the unknown encoding: %v", err)
+	}
+}
+
+func TestEncodedReferences(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	data := bytes.Repeat([]byte("Hello World! "), 100)
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write(data)
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers accept blob ids and keys in any encoding
+	for _, name := range []string{"hex", "base32", "base58"} {
+		encoding, err := ParseEncoding(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bid, _ := FormatBID(ref.Bid, encoding)
+		key, _ := FormatKey(ref.Key, encoding)
+		rdr, err := OpenFileBlob(bid, key, storage)
+		if err != nil {
+			t.Fatalf("Couldn't open the blob with %v references: %v", name, err)
+		}
+		if read, err := ioutil.ReadAll(rdr); err != nil || !bytes.Equal(read, data) {
+			t.Fatalf("Invalid data read with %v references: %v", name, err)
+		}
+		if info, err := InspectBlobWithKey(bid, key, storage); err != nil || !info.IsFile() {
+			t.Fatalf("Couldn't inspect the blob with %v references: %v", name, err)
+		}
+	}
+}
@@ -1,21 +1,142 @@
 package blobstore
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
+
+// Categories of errors, errors of the category match it with errors.Is.
+// Failures caused by the blob key match ErrInvalidKey.
+var (
+	ErrBlobCorrupted      = errors.New("Blob is corrupted")
+	ErrStorageUnavailable = errors.New("Blob storage is unavailable")
+	ErrBlobTooLarge       = errors.New("Blob is too large")
+)
 
 var (
-	ErrInvalidValidationMethod = errors.New("Invalid blob validation method")
+	ErrInvalidValidationMethod = corruption("Invalid blob validation method")
+	ErrInvalidHashBlobContent  = corruption("Invalid hash-validated blob - content does not match blob id")
 
 	ErrInvalidFileBlobType              = errors.New("Invalid blob type - not a file blob")
-	ErrInvalidSplitFileSize             = errors.New("Invalid size of a split file")
-	ErrMalformedSplitFileSizePartsCount = errors.New("Invalid split file blob - number of partial blobs is incorrect")
-	ErrMalformedSplitFileExtraData      = errors.New("Invalid split file blob - extra bytes found at the end of the blob")
-	ErrMalformedSplitFileExtraDataPart  = errors.New("Invalid split file blob - extra bytes found at the end of the partial blob")
-	ErrInvalidFileSubBlobType           = errors.New("Invalid sub blob type - not a file blob")
-
-	ErrMalformedDirInvalidEntriesCount = errors.New("Invalid directory blob - incorrect number of entries found")
-	ErrMalformedDirExtraData           = errors.New("Invalid directory blob - extra bytes found at the end")
+	ErrInvalidSplitFileSize             = corruption("Invalid size of a split file")
+	ErrMalformedSplitFileSizePartsCount = corruption("Invalid split file blob - number of partial blobs is incorrect")
+	ErrMalformedSplitFileExtraData      = corruption("Invalid split file blob - extra bytes found at the end of the blob")
+	ErrMalformedSplitFileExtraDataPart  = corruption("Invalid split file blob - extra bytes found at the end of the partial blob")
+	ErrMalformedSplitFileTruncatedPart  = corruption("Invalid split file blob - partial blob is truncated")
+	ErrInvalidFileSubBlobType           = corruption("Invalid sub blob type - not a file blob")
+	ErrInvalidSeekPosition              = errors.New("Invalid seek position")
+
+	ErrMalformedDirInvalidEntriesCount = corruption("Invalid directory blob - incorrect number of entries found")
+	ErrMalformedDirExtraData           = corruption("Invalid directory blob - extra bytes found at the end")
 	ErrNoMoreDirEntries                = errors.New("No more directory entries found")
+	ErrDuplicateEntry                  = errors.New("Directory entry with given name already exists")
+	ErrDirEntryNotFound                = errors.New("Directory entry with given name was not found")
+	ErrMalformedSplitDirPartsCount     = corruption("Invalid split directory blob - number of partial blobs is incorrect")
+	ErrInvalidDirSubBlobType           = corruption("Invalid sub blob type - not a simple directory blob")
+	ErrInvalidEntryName                = errors.New("Invalid directory entry name")
+	ErrInvalidEntryType                = errors.New("Invalid directory entry type")
+	ErrInvalidEntryFields              = corruption("Invalid number of optional directory entry fields")
+	ErrInvalidSimpleDirBlobType        = errors.New("Invalid blob type - not a simple directory blob")
+	ErrTooManyDirEntries               = errors.New("Too many entries for a simple directory blob")
+
+	ErrInvalidCommitBlobType    = errors.New("Invalid blob type - not a commit blob")
+	ErrMalformedCommitRoot      = corruption("Invalid commit blob - root directory is missing")
+	ErrMalformedCommitParents   = corruption("Invalid commit blob - number of parent commits is incorrect")
+	ErrMalformedCommitExtraData = corruption("Invalid commit blob - extra bytes found at the end")
 
-	ErrInvalidPublicKeyBid  = errors.New("Invalid public key - does not match blob id")
-	ErrUnknownPublicKeyType = errors.New("Unknown public key type")
+	ErrInvalidLink            = errors.New("Invalid link - MIME type or name is too long")
+	ErrInvalidLinkBlobType    = errors.New("Invalid blob type - not a link blob")
+	ErrTooManyLinks           = errors.New("Too many links followed")
+	ErrMalformedLinkTarget    = corruption("Invalid link blob - target is missing")
+	ErrMalformedLinkExtraData = corruption("Invalid link blob - extra bytes found at the end")
+
+	ErrInvalidPublicKeyBid   = corruption("Invalid public key - does not match blob id")
+	ErrUnknownPublicKeyType  = errors.New("Unknown public key type")
+	ErrInvalidSignature      = corruption("Invalid signed blob - signature does not match the content")
+	ErrInvalidSignedIVSource = corruption("Invalid signed blob - IV source has invalid size")
+	ErrMalformedSignedBlob   = corruption("Invalid signed blob - header can't be parsed")
+	ErrSignedBlobOutdated    = errors.New("Newer version of the signed blob already exists")
+	ErrSignedBlobConflict    = errors.New("Other content of the same version of the signed blob already exists")
+
+	ErrDuplicateRemoved = errors.New("Stored copy of the blob has been removed while it was written again")
 )
+
+// Error of a category, sentinel errors are created this way to
+// keep them comparable with == while they match the category
+type categorizedError struct {
+	message  string
+	category error
+}
+
+func (e *categorizedError) Error() string {
+	return e.message
+}
+
+func (e *categorizedError) Is(target error) bool {
+	return target == e.category
+}
+
+// Create the error reporting invalid content of a blob
+func corruption(message string) error {
+	return &categorizedError{message: message, category: ErrBlobCorrupted}
+}
+
+// Error of the blob with invalid content, the cause is one of
+// the errors reporting malformed or not validated blobs
+type BlobCorruptedError struct {
+	Bid string
+	Err error // Cause of the error
+}
+
+func (e *BlobCorruptedError) Error() string {
+	return fmt.Sprintf("Blob %s is corrupted: %v", e.Bid, e.Err)
+}
+
+func (e *BlobCorruptedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BlobCorruptedError) Is(target error) bool {
+	return target == ErrBlobCorrupted
+}
+
+// Attach the blob id to the error if it reports invalid content
+func blobCorrupted(bid string, err error) error {
+	var corrupted *BlobCorruptedError
+	if err == nil || !errors.Is(err, ErrBlobCorrupted) || errors.As(err, &corrupted) {
+		return err
+	}
+	return &BlobCorruptedError{Bid: bid, Err: err}
+}
+
+// Error of the storage which can't be reached, the operation
+// may succeed once it's retried
+type StorageUnavailableError struct {
+	Err error // Cause of the error
+}
+
+func (e *StorageUnavailableError) Error() string {
+	return fmt.Sprintf("Blob storage is unavailable: %v", e.Err)
+}
+
+func (e *StorageUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StorageUnavailableError) Is(target error) bool {
+	return target == ErrStorageUnavailable
+}
+
+// Error of the blob exceeding the size accepted by the storage
+type BlobTooLargeError struct {
+	Bid   string
+	Limit int64 // Maximum size of the blob in bytes
+}
+
+func (e *BlobTooLargeError) Error() string {
+	return fmt.Sprintf("Blob %s is larger than %d bytes", e.Bid, e.Limit)
+}
+
+func (e *BlobTooLargeError) Is(target error) bool {
+	return target == ErrBlobTooLarge
+}
@@ -0,0 +1,62 @@
+package blobstore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorCategories(t *testing.T) {
+
+	for _, err := range []error{ErrInvalidHashBlobContent, ErrMalformedDirExtraData, ErrInvalidSignature, ErrDeserializeStringNotUTF8} {
+		if !errors.Is(err, ErrBlobCorrupted) {
+			t.Fatalf("Error does not match the corruption category: %v", err)
+		}
+	}
+	for _, err := range []error{ErrBIDNotFound, ErrDuplicateEntry, ErrInvalidSeekPosition} {
+		if errors.Is(err, ErrBlobCorrupted) {
+			t.Fatalf("Error should not match the corruption category: %v", err)
+		}
+	}
+
+	var unavailable error = &StorageUnavailableError{Err: ErrBIDNotFound}
+	if !errors.Is(unavailable, ErrStorageUnavailable) || !errors.Is(unavailable, ErrBIDNotFound) {
+		t.Fatalf("Invalid categories of the unavailable storage error: %v", unavailable)
+	}
+
+	var tooLarge error = &BlobTooLargeError{Bid: "abc", Limit: 10}
+	if !errors.Is(tooLarge, ErrBlobTooLarge) || errors.Is(tooLarge, ErrBlobCorrupted) {
+		t.Fatalf("Invalid categories of the too large blob error: %v", tooLarge)
+	}
+}
+
+func TestValidateCorruptedBlob(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := storage.(*memoryBlobStorage).lookup(ref.Bid)
+	data = append([]byte{}, data...)
+	data[len(data)-1] ^= 1
+	corruptedStorage := NewMemoryBlobStorage()
+	putBlob(corruptedStorage, ref.Bid, data)
+
+	err = ValidateBlob(ref.Bid, ref.Key, corruptedStorage)
+	var corrupted *BlobCorruptedError
+	if !errors.As(err, &corrupted) || corrupted.Bid != ref.Bid {
+		t.Fatalf("Invalid error of the corrupted blob: %v", err)
+	}
+	if !errors.Is(err, ErrBlobCorrupted) || !errors.Is(err, ErrInvalidHashBlobContent) {
+		t.Fatalf("Corrupted blob error does not match its cause: %v", err)
+	}
+
+	if err = ValidateBlob(ref.Bid, ref.Key, storage); err != nil {
+		t.Fatalf("Valid blob reported as corrupted: %v", err)
+	}
+	if err = ValidateBlob("missing", ref.Key, storage); err != ErrBIDNotFound {
+		t.Fatalf("Invalid error of the missing blob: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package blobstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExistsAndDelete(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewInterningMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+	} {
+		putBlob(storage, "0a", []byte{validationMethodHash})
+		putBlob(storage, "0b", []byte{validationMethodHash})
+
+		if exists, err := storage.Exists("0a"); err != nil || !exists {
+			t.Fatalf("Existing blob not found: %v", err)
+		}
+
+		if err = storage.Delete("0a"); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := storage.Exists("0a"); err != nil || exists {
+			t.Fatalf("Deleted blob still exists: %v", err)
+		}
+		if err = storage.Delete("0a"); err != ErrBIDNotFound {
+			t.Fatalf("Invalid error when deleting missing blob: %v", err)
+		}
+		if _, err = storage.NewBlobReader("0b"); err != nil {
+			t.Fatalf("Blob sharing the content has been lost: %v", err)
+		}
+		if _, err = ProbeValidationMethod(storage, "0a"); err == nil {
+			t.Fatal("Deleted blob can still be probed")
+		}
+	}
+
+	// Interned content is released with the last blob using it
+	storage := NewInterningMemoryBlobStorage().(*memoryBlobStorage)
+	putBlob(storage, "a", []byte("data"))
+	putBlob(storage, "b", []byte("data"))
+	storage.Delete("a")
+	if len(storage.interned) != 1 {
+		t.Fatal("Interned content released too early")
+	}
+	storage.Delete("b")
+	if len(storage.interned) != 0 || len(storage.refs) != 0 {
+		t.Fatal("Interned content has not been released")
+	}
+}
+
+func TestFileStorageSkipsExistingBlobs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-duplicate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := NewFileBlobStorage(dir)
+
+	bid, _, err := CreateTypedBlob(blobTypeSimpleStaticFile, []byte("content"), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _ := storage.NewBlobReader(bid)
+	stored, _ := ioutil.ReadAll(reader)
+	closeReader(reader)
+
+	// Content of the existing valid hash-validated blob is not written
+	writer, _ := storage.NewBlobWriter(bid)
+	writer.Write([]byte{validationMethodHash})
+	if n, err := writer.Write([]byte{4, 5, 6, 7}); n != 4 || err != nil {
+		t.Fatalf("Invalid write of the existing blob: %v %v", n, err)
+	}
+	if duplicate, err := writer.Finalize(); !duplicate || err != nil {
+		t.Fatalf("Existing blob not reported as duplicate: %v %v", duplicate, err)
+	}
+	reader, _ = storage.NewBlobReader(bid)
+	data, _ := ioutil.ReadAll(reader)
+	closeReader(reader)
+	if !bytes.Equal(data, stored) {
+		t.Fatalf("Existing blob changed: %v", data)
+	}
+
+	writer, _ = storage.NewBlobWriter(bid)
+	writer.Write([]byte{validationMethodHash})
+	if err = writer.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("Temporary files left: %v", len(files))
+	}
+}
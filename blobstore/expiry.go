@@ -0,0 +1,446 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/cinode/golib/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrExpiryNotSupported = errors.New("Blob storage does not support blob expiry")
+	ErrInvalidTTL         = errors.New("Invalid time to live of the blob")
+)
+
+// Options of the blob writer
+type WriterOptions struct {
+
+	// Time to live of the blob counted from its finalization, the blob
+	// is removed by the expiry sweeper once it expires. Blobs are kept
+	// until deleted if 0.
+	TTL time.Duration
+}
+
+// Optional interface of the blob storage removing blobs once they expire.
+//
+// Expiry of the blob written again is extended, never shortened. The blob
+// written without the time to live is kept until deleted, even if it was
+// stored with the expiry before. Expired blobs can still be read until
+// they're removed by RemoveExpired.
+type ExpiringStorage interface {
+
+	// Create new writer for blobs with given options
+	NewBlobWriterWithOptions(blobId string, options WriterOptions) (writer WriteFinalizeCanceler, err error)
+
+	// Get the time the blob expires at, zero time if it does not expire.
+	// ErrBIDNotFound is returned if there's no such blob.
+	Expiry(blobId string) (expires time.Time, err error)
+
+	// Remove blobs which expired before given time
+	RemoveExpired(now time.Time) (removed int, err error)
+}
+
+// Create the writer of the blob with given options, ErrExpiryNotSupported
+// is returned if the time to live is requested from the storage that does
+// not implement ExpiringStorage
+func NewBlobWriterWithOptions(storage BlobStorage, blobId string, options WriterOptions) (WriteFinalizeCanceler, error) {
+	if options.TTL < 0 {
+		return nil, ErrInvalidTTL
+	}
+	if expiring, ok := storage.(ExpiringStorage); ok {
+		return expiring.NewBlobWriterWithOptions(blobId, options)
+	}
+	if options.TTL != 0 {
+		return nil, ErrExpiryNotSupported
+	}
+	return storage.NewBlobWriter(blobId)
+}
+
+// Sweeper removing expired blobs of the storage in the background
+type ExpirySweeper struct {
+	stop, done chan struct{}
+}
+
+// Remove expired blobs every interval until the sweeper is stopped. The
+// report function, if not nil, is called after each sweep with the number
+// of removed blobs and the error of the sweep. Blobs are expired by the
+// clock of the storage, the interval is measured by the system clock.
+func StartExpirySweeper(storage ExpiringStorage, interval time.Duration, report func(removed int, err error)) *ExpirySweeper {
+	clock := utils.SystemClock
+	if c, ok := storage.(expiryClock); ok {
+		clock = c.expiryClock()
+	}
+	s := &ExpirySweeper{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				removed, err := storage.RemoveExpired(clock.Now())
+				if report != nil {
+					report(removed, err)
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// Stop the sweeper, the sweep in progress is completed first
+func (s *ExpirySweeper) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// Get the expiry of the blob written now, zero time if it does not expire
+func expiryOf(clock utils.Clock, ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return clock.Now().Add(ttl)
+}
+
+// Storage counting the time to live of blobs with its own clock
+type expiryClock interface {
+	expiryClock() utils.Clock
+}
+
+func (s *fileBlobStorage) expiryClock() utils.Clock {
+	return s.clock
+}
+
+func (s *kvBlobStorage) expiryClock() utils.Clock {
+	return s.clock
+}
+
+// Get the time the blob expires at from the first storage of the chain of
+// wrappers implementing ExpiringStorage, zero time if it does not expire.
+// ErrExpiryNotSupported is returned if there's no such storage.
+func BlobExpiry(storage BlobStorage, blobId string) (time.Time, error) {
+	expiring := FindStorage(storage, func(s BlobStorage) bool {
+		_, ok := s.(ExpiringStorage)
+		return ok
+	})
+	if expiring == nil {
+		return time.Time{}, ErrExpiryNotSupported
+	}
+	return expiring.(ExpiringStorage).Expiry(blobId)
+}
+
+// Check whether the blob is stored and kept until deleted. Writers skipping
+// blobs already stored check it instead of the existence, blobs which
+// expire are written again so that their expiry is cleared.
+func existsWithoutExpiry(storage BlobStorage, blobId string) (bool, error) {
+	exists, err := storage.Exists(blobId)
+	if err != nil || !exists {
+		return false, err
+	}
+	expires, err := BlobExpiry(storage, blobId)
+	switch err {
+	case nil:
+		return expires.IsZero(), nil
+	case ErrExpiryNotSupported:
+		return true, nil
+	case ErrBIDNotFound:
+		return false, nil
+	}
+	return false, err
+}
+
+func encodeExpiry(expires time.Time) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(expires.UnixNano()))
+	return b[:]
+}
+
+func decodeExpiry(data []byte) (time.Time, bool) {
+	if len(data) != 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), true
+}
+
+// Expiry records of the file storage are kept in the directory of
+// the storage, one file per expiring blob
+const expiryDirName = ".expiry"
+
+// Check whether the file of the storage directory is not a blob
+func internalFileName(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix) || name == expiryDirName
+}
+
+func (s *fileBlobStorage) expiryPath(blobId string) string {
+	return filepath.Join(s.path, expiryDirName, blobId)
+}
+
+func (s *fileBlobStorage) NewBlobWriterWithOptions(blobId string, options WriterOptions) (WriteFinalizeCanceler, error) {
+	if options.TTL < 0 {
+		return nil, ErrInvalidTTL
+	}
+	writer, err := newFileBlobWriter(s, blobId)
+	if err != nil {
+		return nil, err
+	}
+	writer.ttl = options.TTL
+	return writer, nil
+}
+
+// Read the expiry record of the blob, zero time if there's none
+func (s *fileBlobStorage) readExpiry(blobId string) (time.Time, error) {
+	if !s.hasExpiry.Load() {
+		return time.Time{}, nil
+	}
+	data, err := ioutil.ReadFile(s.expiryPath(blobId))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	expires, ok := decodeExpiry(data)
+	if !ok {
+		// Damaged records are dropped, the blob is kept
+		return time.Time{}, nil
+	}
+	return expires, nil
+}
+
+// Replace the expiry record of the blob, the record is removed
+// if the blob does not expire
+func (s *fileBlobStorage) writeExpiry(blobId string, expires time.Time) error {
+	if expires.IsZero() {
+		if !s.hasExpiry.Load() {
+			return nil
+		}
+		if err := os.Remove(s.expiryPath(blobId)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	dir := filepath.Join(s.path, expiryDirName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	s.hasExpiry.Store(true)
+	temp, err := ioutil.TempFile(dir, tempFilePrefix)
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(encodeExpiry(expires))
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), s.expiryPath(blobId))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// Record the expiry of the finalized blob, the expiry lock must be held
+// for writing if the blob expires or for reading otherwise
+func (s *fileBlobStorage) updateExpiry(blobId string, ttl time.Duration, duplicate bool) error {
+	expires := expiryOf(s.clock, ttl)
+	if !duplicate || expires.IsZero() {
+		return s.writeExpiry(blobId, expires)
+	}
+
+	// Blobs kept until deleted stay so
+	previous, err := s.readExpiry(blobId)
+	if err != nil || previous.IsZero() || !previous.Before(expires) {
+		return err
+	}
+	return s.writeExpiry(blobId, expires)
+}
+
+// Check whether the blob has the expiry record
+func (s *fileBlobStorage) expires(blobId string) bool {
+	expires, err := s.readExpiry(blobId)
+	return err != nil || !expires.IsZero()
+}
+
+func (s *fileBlobStorage) Expiry(blobId string) (time.Time, error) {
+	s.expiryLock.RLock()
+	defer s.expiryLock.RUnlock()
+
+	if exists, err := s.Exists(blobId); err != nil || !exists {
+		if err == nil {
+			err = ErrBIDNotFound
+		}
+		return time.Time{}, err
+	}
+	return s.readExpiry(blobId)
+}
+
+func (s *fileBlobStorage) RemoveExpired(now time.Time) (removed int, err error) {
+	if !s.hasExpiry.Load() {
+		return 0, nil
+	}
+	dir, err := os.Open(filepath.Join(s.path, expiryDirName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range names {
+		if strings.HasPrefix(name, tempFilePrefix) {
+			continue
+		}
+		expired, err := s.removeIfExpired(name, now)
+		if err != nil {
+			return removed, err
+		}
+		if expired {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Remove the blob if it's expired, the record is checked again since
+// the blob might have been written again in the meantime
+func (s *fileBlobStorage) removeIfExpired(blobId string, now time.Time) (bool, error) {
+	s.expiryLock.Lock()
+	defer s.expiryLock.Unlock()
+
+	expires, err := s.readExpiry(blobId)
+	if err != nil || expires.IsZero() || expires.After(now) {
+		return false, err
+	}
+	err = s.Delete(blobId)
+	if err == ErrBIDNotFound {
+		return false, s.writeExpiry(blobId, time.Time{})
+	}
+	return err == nil, err
+}
+
+// Expiry records of the key-value storage are kept under their own prefix
+const kvExpiryPrefix = "expiry/"
+
+func kvExpiryKey(blobId string) []byte {
+	return []byte(kvExpiryPrefix + blobId)
+}
+
+func (s *kvBlobStorage) NewBlobWriterWithOptions(blobId string, options WriterOptions) (WriteFinalizeCanceler, error) {
+	if options.TTL < 0 {
+		return nil, ErrInvalidTTL
+	}
+	return &kvBlobWriter{storage: s, bid: blobId, ttl: options.TTL}, nil
+}
+
+// Read the expiry record of the blob, zero time if there's none
+func (s *kvBlobStorage) readExpiry(blobId string) (time.Time, error) {
+	data, found, err := s.kv.Get(kvExpiryKey(blobId))
+	if err != nil || !found {
+		return time.Time{}, err
+	}
+	expires, _ := decodeExpiry(data)
+	return expires, nil
+}
+
+// Record the expiry of the finalized blob, the lock of the storage
+// must be held
+func (s *kvBlobStorage) updateExpiry(blobId string, ttl time.Duration, duplicate bool) error {
+	expires := expiryOf(s.clock, ttl)
+	if !duplicate && expires.IsZero() {
+		// Records are only read if they might exist, deleting
+		// keys is not free in append-only stores
+		if _, found, err := s.kv.Get(kvExpiryKey(blobId)); err != nil || !found {
+			return err
+		}
+		return s.kv.Delete(kvExpiryKey(blobId))
+	}
+	previous, err := s.readExpiry(blobId)
+	if err != nil {
+		return err
+	}
+	switch {
+	case expires.IsZero() && !previous.IsZero():
+		return s.kv.Delete(kvExpiryKey(blobId))
+	case expires.IsZero(), duplicate && (previous.IsZero() || !previous.Before(expires)):
+		return nil
+	}
+	return s.kv.Put(kvExpiryKey(blobId), encodeExpiry(expires))
+}
+
+func (s *kvBlobStorage) Expiry(blobId string) (time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if exists, err := s.Exists(blobId); err != nil || !exists {
+		if err == nil {
+			err = ErrBIDNotFound
+		}
+		return time.Time{}, err
+	}
+	return s.readExpiry(blobId)
+}
+
+func (s *kvBlobStorage) RemoveExpired(now time.Time) (removed int, err error) {
+	var bids []string
+	err = s.kv.Scan([]byte(kvExpiryPrefix), func(key []byte, size int64) bool {
+		if !strings.HasPrefix(string(key), kvExpiryPrefix) {
+			return false
+		}
+		bids = append(bids, strings.TrimPrefix(string(key), kvExpiryPrefix))
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, bid := range bids {
+		expired, err := s.removeIfExpired(bid, now)
+		if err != nil {
+			return removed, err
+		}
+		if expired {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Remove the blob if it's expired, the record is checked again since
+// the blob might have been written again in the meantime
+func (s *kvBlobStorage) removeIfExpired(blobId string, now time.Time) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	expires, err := s.readExpiry(blobId)
+	if err != nil || expires.IsZero() || expires.After(now) {
+		return false, err
+	}
+	_, found, err := s.kv.Get(kvBlobKey(blobId))
+	if err != nil {
+		return false, err
+	}
+	if found {
+		if err = s.kv.Delete(kvBlobKey(blobId)); err != nil {
+			return false, err
+		}
+	}
+	return found, s.kv.Delete(kvExpiryKey(blobId))
+}
@@ -0,0 +1,180 @@
+package blobstore
+
+import (
+	"github.com/cinode/golib/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeExpiringBlob(t *testing.T, storage BlobStorage, bid string, data []byte, ttl time.Duration) {
+	writer, err := NewBlobWriterWithOptions(storage, bid, WriterOptions{TTL: ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	if _, err = writer.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlobExpiry(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-expiry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = NewBlobWriterWithOptions(NewMemoryBlobStorage(), "a", WriterOptions{TTL: time.Hour}); err != ErrExpiryNotSupported {
+		t.Fatalf("Invalid error of the storage without expiry: %v", err)
+	}
+	if _, err = NewBlobWriterWithOptions(NewMemoryBlobStorage(), "a", WriterOptions{TTL: -1}); err != ErrInvalidTTL {
+		t.Fatalf("Invalid error of the negative time to live: %v", err)
+	}
+
+	for _, storage := range []BlobStorage{
+		NewFileBlobStorage(filepath.Join(dir, "store")),
+		NewKeyValueBlobStorage(&mapKeyValueStore{}),
+	} {
+		expiring := storage.(ExpiringStorage)
+		if !StorageCapabilities(storage).Has(CapabilityExpiry) {
+			t.Fatalf("Expiry not reported by %T", storage)
+		}
+		now := time.Now()
+
+		writeExpiringBlob(t, storage, "5a01", []byte("short"), time.Minute)
+		writeExpiringBlob(t, storage, "5a02", []byte("long"), time.Hour)
+		writeExpiringBlob(t, storage, "5a03", []byte("kept"), 0)
+		if expires, err := expiring.Expiry("5a01"); err != nil || expires.Before(now.Add(time.Minute)) || expires.After(time.Now().Add(time.Minute)) {
+			t.Fatalf("Invalid expiry in %T: %v %v", storage, expires, err)
+		}
+		if expires, err := expiring.Expiry("5a03"); err != nil || !expires.IsZero() {
+			t.Fatalf("Invalid expiry of the blob kept until deleted in %T: %v %v", storage, expires, err)
+		}
+		if _, err := expiring.Expiry("5a05"); err != ErrBIDNotFound {
+			t.Fatalf("Invalid error of the missing blob in %T: %v", storage, err)
+		}
+		if blobs, err := ListAllBlobs(storage); err != nil || len(blobs) != 3 {
+			t.Fatalf("Invalid blobs listed in %T: %v %v", storage, blobs, err)
+		}
+
+		// Writing again extends the expiry, writing without it keeps the blob
+		writeExpiringBlob(t, storage, "5a01", []byte("short"), 2*time.Hour)
+		writeExpiringBlob(t, storage, "5a01", []byte("short"), time.Minute)
+		if expires, _ := expiring.Expiry("5a01"); expires.Before(now.Add(2 * time.Hour)) {
+			t.Fatalf("Expiry not extended in %T: %v", storage, expires)
+		}
+		writeExpiringBlob(t, storage, "5a03", []byte("kept"), time.Minute)
+		putBlob(storage, "5a02", []byte("long"))
+		for _, bid := range []string{"5a03", "5a02"} {
+			if expires, _ := expiring.Expiry(bid); !expires.IsZero() {
+				t.Fatalf("Blob %v of %T expires: %v", bid, storage, expires)
+			}
+		}
+
+		writeExpiringBlob(t, storage, "5a04", []byte("deleted"), time.Minute)
+		if err = storage.Delete("5a04"); err != nil {
+			t.Fatal(err)
+		}
+		putBlob(storage, "5a04", []byte("deleted"))
+
+		if removed, err := expiring.RemoveExpired(now.Add(time.Hour)); err != nil || removed != 0 {
+			t.Fatalf("Blobs removed before their expiry in %T: %v %v", storage, removed, err)
+		}
+		if removed, err := expiring.RemoveExpired(now.Add(3 * time.Hour)); err != nil || removed != 1 {
+			t.Fatalf("Invalid number of expired blobs removed from %T: %v %v", storage, removed, err)
+		}
+		if exists, _ := storage.Exists("5a01"); exists {
+			t.Fatalf("Expired blob not removed from %T", storage)
+		}
+		if blobs, err := ListAllBlobs(storage); err != nil || len(blobs) != 3 {
+			t.Fatalf("Invalid blobs left in %T: %v %v", storage, blobs, err)
+		}
+	}
+
+	// Snapshots skip expiry records
+	storage := NewFileBlobStorage(filepath.Join(dir, "store"))
+	writeExpiringBlob(t, storage, "5a01", []byte("short"), time.Minute)
+	if err = storage.(*fileBlobStorage).SnapshotStore(filepath.Join(dir, "snapshot")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpirySweeper(t *testing.T) {
+
+	storage := NewKeyValueBlobStorage(&mapKeyValueStore{})
+	writeExpiringBlob(t, storage, "0a", []byte("a"), time.Nanosecond)
+
+	removed := make(chan int, 100)
+	sweeper := StartExpirySweeper(storage.(ExpiringStorage), time.Millisecond, func(n int, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		removed <- n
+	})
+	defer sweeper.Stop()
+
+	for total := 0; total != 1; {
+		select {
+		case n := <-removed:
+			total += n
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expired blob not removed by the sweeper")
+		}
+	}
+	if exists, _ := storage.Exists("0a"); exists {
+		t.Fatal("Expired blob still exists")
+	}
+}
+
+func TestExpiryClock(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-expiry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, storage := range []BlobStorage{
+		NewFileBlobStorageWithOptions(dir, FileBlobStorageOptions{Clock: utils.NewManualClock(start)}),
+		NewKeyValueBlobStorageWithOptions(&mapKeyValueStore{}, KeyValueBlobStorageOptions{Clock: utils.NewManualClock(start)}),
+	} {
+		writeExpiringBlob(t, storage, "0a", []byte("a"), time.Minute)
+		if expires, err := storage.(ExpiringStorage).Expiry("0a"); err != nil || !expires.Equal(start.Add(time.Minute)) {
+			t.Fatalf("Expiry of %T not counted by its clock: %v %v", storage, expires, err)
+		}
+	}
+}
+
+func TestDuplicateClearsExpiry(t *testing.T) {
+
+	content := []byte("Hello world!")
+	source := NewMemoryBlobStorage()
+	bid, _, err := CreateTypedBlob(blobTypeSimpleStaticFile, content, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := source.(*memoryBlobStorage).lookup(bid)
+
+	// Blobs skipped by writers of wrapped storages must not expire
+	backend := NewKeyValueBlobStorage(&mapKeyValueStore{})
+	storage := NewMaintenanceStorage(NewAccessTracker(backend))
+	writeExpiringBlob(t, backend, bid, blob, time.Minute)
+	if expires, err := BlobExpiry(storage, bid); err != nil || expires.IsZero() {
+		t.Fatalf("Invalid expiry found through wrappers: %v %v", expires, err)
+	}
+	if _, _, err = CreateTypedBlob(blobTypeSimpleStaticFile, content, storage); err != nil {
+		t.Fatal(err)
+	}
+	if expires, err := BlobExpiry(storage, bid); err != nil || !expires.IsZero() {
+		t.Fatalf("Expiry of the blob written again not cleared: %v %v", expires, err)
+	}
+
+	if _, err = BlobExpiry(NewMaintenanceStorage(source), bid); err != ErrExpiryNotSupported {
+		t.Fatalf("Invalid error of the storage without expiry: %v", err)
+	}
+}
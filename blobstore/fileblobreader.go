@@ -1,25 +1,47 @@
 package blobstore
 
 import (
+	"github.com/cinode/golib/cipherfactory"
 	"io"
+	"io/ioutil"
+	"sort"
 )
 
+// Reader of file blobs. Seeking is lazy - partial blobs of split files
+// are only fetched and decrypted when data from them is read. Since
+// blob content can not be decrypted from the middle, data of the partial
+// blob before the seek position is decrypted and skipped. Partial blobs
+// encrypted with authenticated ciphers allowing random access, i.e.
+// AES-256-GCM, are the exception if the storage supports range reads, only
+// the data from the chunk containing the seek position is fetched. Chunks
+// are authenticated with the key instead of being validated against the
+// blob id, seeking back to the beginning of the partial blob reads it
+// validated. Partial blobs of ciphers without authentication, i.e.
+// AES-256-CTR, are always validated. The reader must be closed
+// to release the storage reader of the partial blob being read.
 type FileBlobReader interface {
 	io.Reader
+	io.Seeker
+	io.Closer
 
 	Open(bid, key string) error
 }
 
 // fileBlobReader is a structure that can be used to easily read from file blobs
 type fileBlobReader struct {
-	baseBlobReader                // Inherit methods of base blob reader
-	currentReader       io.Reader // Reader object currently used
-	isSplit             bool      // Flag indicating whether this is a split file
-	totalSize           int64     // Total file size. Valid for split files only, used for validation purposes only
-	thisBlobBytesLeft   int       // Number of bytes left to read from this particular blob
-	otherBlobsBytesLeft int64     // Number of bytes left to read in all blobs but this particular one
-	otherBlobsBidsLeft  []string  // Bids for blobs not yet read
-	otherBlobsKeysLeft  []string  // Keys for blobs not yet read
+	baseBlobReader               // Inherit methods of base blob reader
+	bid, key           string    // Blob being read
+	currentReader      io.Reader // Reader object currently used
+	isSplit            bool      // Flag indicating whether this is a split file
+	totalSize          int64     // Total file size, -1 if not known yet
+	thisBlobBytesLeft  int       // Number of bytes left to read from this particular blob
+	otherBlobsBidsLeft []string  // Bids for blobs not yet read
+	otherBlobsKeysLeft []string  // Keys for blobs not yet read
+	bids, keys         []string  // Bids and keys of all partial blobs
+	offsets            []int64   // Offsets of partial blobs in the file followed by the file size
+	position           int64     // Position of the current reader in the file
+	seekPending        bool      // Seek has been requested but the reader was not moved yet
+	seekPosition       int64     // Position requested by the last seek
 }
 
 func NewFileBlobReader(storage BlobStorage) FileBlobReader {
@@ -28,15 +50,31 @@ func NewFileBlobReader(storage BlobStorage) FileBlobReader {
 			storage: storage}}
 }
 
+// Open the file blob with given bid and key
+func OpenFileBlob(bid, key string, storage BlobStorage) (FileBlobReader, error) {
+	reader := NewFileBlobReader(storage)
+	if err := reader.Open(bid, key); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
 // Open does open blob with given bid and key
 func (f *fileBlobReader) Open(bid, key string) error {
 
+	// The blob opened before is no longer read
+	f.Close()
+
 	// Get the raw blob reader
+	bid, key = canonicalForm(bid), canonicalForm(key)
 	reader, blobType, err := f.openInternal(bid, key, validationMethodHash)
 	if err != nil {
 		return err
 	}
 
+	f.bid, f.key = bid, key
+	f.position, f.seekPending = 0, false
+
 	switch blobType {
 
 	// For simple type blob just read the rest of the unencrypted content
@@ -46,32 +84,107 @@ func (f *fileBlobReader) Open(bid, key string) error {
 		f.currentReader = reader
 		return nil
 
+	case blobTypeCompressedStaticFile:
+		f.isSplit = false
+		f.totalSize = -1
+		f.currentReader, err = openDecompressingReader(reader)
+		return err
+
 	// For split file blob we have to read all entries and queue them
-	case blobTypeSplitStaticFile:
-		return f.loadSplitFileData(reader)
+	case blobTypeSplitStaticFile, blobTypeChunkedStaticFile:
+		defer closeReader(reader)
+		return f.loadSplitFileData(reader, blobType)
 	}
 
+	closeReader(reader)
 	return ErrInvalidFileBlobType
 }
 
-// Setup the reader for loading split file content
-func (f *fileBlobReader) loadSplitFileData(masterBlobReader io.Reader) error {
+// Close the reader of the current partial blob, the blob can be opened again
+func (f *fileBlobReader) Close() error {
+	closeReader(f.currentReader)
+	f.currentReader = nil
+	return nil
+}
 
-	// Read the size
-	totalSize, err := deserializeInt(masterBlobReader)
+// Decompress the content of the blob, closing the returned
+// reader closes the one given
+func openDecompressingReader(reader io.Reader) (io.Reader, error) {
+	decompressed, err := newDecompressingReader(reader)
+	if err != nil {
+		closeReader(reader)
+		return nil, err
+	}
+	return &rangeReader{Reader: decompressed, closer: reader}, nil
+}
+
+// Setup the reader for loading split or chunked file content
+func (f *fileBlobReader) loadSplitFileData(masterBlobReader io.Reader, blobType int64) error {
+
+	var (
+		totalSize  int64
+		sizes      []int64
+		bids, keys []string
+		err        error
+	)
+	if blobType == blobTypeChunkedStaticFile {
+		totalSize, sizes, bids, keys, err = readChunkedFileData(masterBlobReader)
+	} else {
+		totalSize, bids, keys, err = readSplitFileData(masterBlobReader)
+		sizes = splitFilePartSizes(totalSize, len(bids))
+	}
 	if err != nil {
 		return err
 	}
 
+	// Fill in the data
+	f.isSplit = true
+	f.currentReader = nil
+	f.totalSize = totalSize
+	f.thisBlobBytesLeft = 0
+	f.otherBlobsBidsLeft = bids
+	f.otherBlobsKeysLeft = keys
+	f.bids = bids
+	f.keys = keys
+	f.offsets = make([]int64, len(sizes)+1)
+	for i, size := range sizes {
+		f.offsets[i+1] = f.offsets[i] + size
+	}
+
+	return nil
+}
+
+// Get sizes of partial blobs of the split file, all but the last one are full
+func splitFilePartSizes(totalSize int64, count int) []int64 {
+	sizes := make([]int64, count)
+	for i := range sizes {
+		sizes[i] = maxSimpleFileDataSize
+	}
+	if count > 0 {
+		sizes[count-1] = totalSize - int64(count-1)*maxSimpleFileDataSize
+	}
+	return sizes
+}
+
+// Read the content of the split file blob, the reader
+// must be positioned right after the blob type
+func readSplitFileData(masterBlobReader io.Reader) (totalSize int64, bids, keys []string, err error) {
+
+	// Read the size
+	if totalSize, err = deserializeInt(masterBlobReader); err != nil {
+		return
+	}
+
 	// Read all sub-blob entries
 	subBlobsCnt, err := deserializeInt(masterBlobReader)
 	if err != nil {
-		return err
+		return
 	}
 
 	// Make sure the sub blobs count is sane value
 	if (subBlobsCnt < 2) || (subBlobsCnt > maxSaneSplitFileParts) {
-		return ErrMalformedSplitFileSizePartsCount
+		err = ErrMalformedSplitFileSizePartsCount
+		return
 	}
 
 	// We can validate the total file size, subBlobsCnt-1 blobs must be of size
@@ -79,19 +192,19 @@ func (f *fileBlobReader) loadSplitFileData(masterBlobReader io.Reader) error {
 	maxSize := subBlobsCnt * maxSimpleFileDataSize
 	minSize := maxSize - maxSimpleFileDataSize + 1
 	if (totalSize < minSize) || (totalSize > maxSize) {
-		return ErrInvalidSplitFileSize
+		err = ErrInvalidSplitFileSize
+		return
 	}
 
 	// Read all sub-blob entries
-	var bids, keys []string
 	for i := int64(0); i < subBlobsCnt; i++ {
 		bid, err := deserializeString(masterBlobReader, maxSaneBidLength)
 		if err != nil {
-			return err
+			return 0, nil, nil, err
 		}
 		key, err := deserializeString(masterBlobReader, maxSaneKeyLength)
 		if err != nil {
-			return err
+			return 0, nil, nil, err
 		}
 
 		bids = append(bids, bid)
@@ -99,26 +212,80 @@ func (f *fileBlobReader) loadSplitFileData(masterBlobReader io.Reader) error {
 	}
 
 	// We must have read everything from the split file blob by now
-	if !f.atEOF(masterBlobReader) {
-		return ErrMalformedSplitFileExtraData
+	if err = checkEOF(masterBlobReader, ErrMalformedSplitFileExtraData); err != nil {
+		return 0, nil, nil, err
 	}
 
-	// Fill in the data
-	f.isSplit = true
-	f.totalSize = totalSize
-	f.thisBlobBytesLeft = 0
-	f.otherBlobsBytesLeft = totalSize
-	f.otherBlobsBidsLeft = bids
-	f.otherBlobsKeysLeft = keys
+	return
+}
 
-	return nil
+// Read the content of the chunked file blob, the reader
+// must be positioned right after the blob type
+func readChunkedFileData(masterBlobReader io.Reader) (totalSize int64, sizes []int64, bids, keys []string, err error) {
+
+	if totalSize, err = deserializeInt(masterBlobReader); err != nil {
+		return
+	}
+	if totalSize < 0 || totalSize > maxSaneSplitFileParts*maxSimpleFileDataSize {
+		return 0, nil, nil, nil, ErrInvalidSplitFileSize
+	}
+
+	count, err := deserializeInt(masterBlobReader)
+	if err != nil {
+		return
+	}
+	if count < 2 || count > maxSaneSplitFileParts {
+		return 0, nil, nil, nil, ErrMalformedSplitFileSizePartsCount
+	}
+
+	// Sizes of chunks must sum up to the total size, chunks can't be empty
+	sum := int64(0)
+	for i := int64(0); i < count; i++ {
+		size, err := deserializeInt(masterBlobReader)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+		if size < 1 || size > maxSimpleFileDataSize {
+			return 0, nil, nil, nil, ErrInvalidSplitFileSize
+		}
+		bid, err := deserializeString(masterBlobReader, maxSaneBidLength)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+		key, err := deserializeString(masterBlobReader, maxSaneKeyLength)
+		if err != nil {
+			return 0, nil, nil, nil, err
+		}
+
+		sum += size
+		sizes = append(sizes, size)
+		bids = append(bids, bid)
+		keys = append(keys, key)
+	}
+	if sum != totalSize {
+		return 0, nil, nil, nil, ErrInvalidSplitFileSize
+	}
+
+	if err = checkEOF(masterBlobReader, ErrMalformedSplitFileExtraData); err != nil {
+		return 0, nil, nil, nil, err
+	}
+
+	return
 }
 
 func (f *fileBlobReader) Read(p []byte) (n int, err error) {
 
+	if f.seekPending {
+		if err = f.reposition(); err != nil {
+			return
+		}
+	}
+
 	// Simple case for the non-split file
 	if !f.isSplit {
-		return f.currentReader.Read(p)
+		n, err = f.currentReader.Read(p)
+		f.position += int64(n)
+		return
 	}
 
 	// Make sure to advance to next partial blob if the current one is exhausted
@@ -130,12 +297,19 @@ func (f *fileBlobReader) Read(p []byte) (n int, err error) {
 
 	// Reduce the number of bytes we will read at this call
 	// to prevent crossing the one partial blob border
-	if f.thisBlobBytesLeft < n {
-		n = f.thisBlobBytesLeft
+	if f.thisBlobBytesLeft < len(p) {
+		p = p[:f.thisBlobBytesLeft]
 	}
 
 	n, err = f.currentReader.Read(p)
 	f.thisBlobBytesLeft -= n
+	f.position += int64(n)
+
+	// Partial blob must not end before the expected size is reached,
+	// such corruption is reported at the offending blob
+	if err == io.EOF && f.thisBlobBytesLeft > 0 {
+		return n, ErrMalformedSplitFileTruncatedPart
+	}
 
 	// Make sure not to throw any error between partial blobs switch
 	if n > 0 {
@@ -147,16 +321,21 @@ func (f *fileBlobReader) Read(p []byte) (n int, err error) {
 
 func (f *fileBlobReader) switchToNextPartialBlob() error {
 
+	// Make sure partial blobs did not contain any extra data,
+	// this also validates the content of the partial blob
+	if f.currentReader != nil {
+		if err := checkEOF(f.currentReader, ErrMalformedSplitFileExtraDataPart); err != nil {
+			return err
+		}
+		closeReader(f.currentReader)
+		f.currentReader = nil
+	}
+
 	// Return EOF if no more blobs left
 	if len(f.otherBlobsBidsLeft) == 0 {
 		return io.EOF
 	}
 
-	// Make sure partial blobs did not contain any extra data
-	if f.currentReader != nil && !f.atEOF(f.currentReader) {
-		return ErrMalformedSplitFileExtraDataPart
-	}
-
 	// Try to open the next blob
 	reader, blobType, err := f.openInternal(
 		f.otherBlobsBidsLeft[0], f.otherBlobsKeysLeft[0],
@@ -164,25 +343,265 @@ func (f *fileBlobReader) switchToNextPartialBlob() error {
 	if err != nil {
 		return err
 	}
-	if blobType != blobTypeSimpleStaticFile {
+	switch blobType {
+	case blobTypeSimpleStaticFile:
+	case blobTypeCompressedStaticFile:
+		if reader, err = openDecompressingReader(reader); err != nil {
+			return err
+		}
+	default:
+		closeReader(reader)
 		return ErrInvalidFileSubBlobType
 	}
 
 	// Update structures
+	part := len(f.bids) - len(f.otherBlobsBidsLeft)
 	f.otherBlobsBidsLeft = f.otherBlobsBidsLeft[1:]
 	f.otherBlobsKeysLeft = f.otherBlobsKeysLeft[1:]
-	if f.otherBlobsBytesLeft > maxSimpleFileDataSize {
-		f.thisBlobBytesLeft = maxSimpleFileDataSize
-	} else {
-		f.thisBlobBytesLeft = int(f.otherBlobsBytesLeft)
-	}
-	f.otherBlobsBytesLeft -= int64(f.thisBlobBytesLeft)
+	f.thisBlobBytesLeft = int(f.offsets[part+1] - f.offsets[part])
 	f.currentReader = reader
 
 	return nil
 }
 
-func (f *fileBlobReader) atEOF(r io.Reader) bool {
-	// TODO: We're using this for validation only, implement the proper version
-	return true
+func (f *fileBlobReader) Seek(offset int64, whence int) (int64, error) {
+
+	current := f.position
+	if f.seekPending {
+		current = f.seekPosition
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += current
+	case io.SeekEnd:
+		size, err := f.size()
+		if err != nil {
+			return current, err
+		}
+		offset += size
+	default:
+		return current, ErrInvalidSeekPosition
+	}
+
+	if offset < 0 {
+		return current, ErrInvalidSeekPosition
+	}
+
+	// The reader is moved on the next read
+	f.seekPosition = offset
+	f.seekPending = offset != f.position
+	return offset, nil
+}
+
+// Get the size of the file, simple file blobs don't store the size
+// so the whole content has to be decrypted to find it
+func (f *fileBlobReader) size() (int64, error) {
+	if f.totalSize >= 0 {
+		return f.totalSize, nil
+	}
+
+	reader, _, err := f.openInternal(f.bid, f.key, validationMethodHash)
+	if err != nil {
+		return 0, err
+	}
+	defer closeReader(reader)
+
+	if f.totalSize, err = io.Copy(ioutil.Discard, reader); err != nil {
+		f.totalSize = -1
+		return 0, err
+	}
+	return f.totalSize, nil
+}
+
+// Move the reader to the position requested by the last seek
+func (f *fileBlobReader) reposition() error {
+
+	target := f.seekPosition
+
+	if !f.isSplit {
+
+		// Going backwards requires reading the blob from the beginning
+		if target < f.position {
+			reader, _, err := f.openInternal(f.bid, f.key, validationMethodHash)
+			if err != nil {
+				return err
+			}
+			closeReader(f.currentReader)
+			f.currentReader, f.position = reader, 0
+		}
+
+		if err := f.skip(target - f.position); err != nil {
+			return err
+		}
+		f.seekPending = false
+		return nil
+	}
+
+	// First partial blob ending after the target position
+	part := sort.Search(len(f.bids), func(i int) bool { return f.offsets[i+1] > target })
+	currentPart := len(f.bids) - len(f.otherBlobsBidsLeft) - 1
+
+	switch {
+
+	// Past the end of the file, no more data to read
+	case target >= f.totalSize:
+		closeReader(f.currentReader)
+		f.currentReader = nil
+		f.thisBlobBytesLeft = 0
+		f.otherBlobsBidsLeft, f.otherBlobsKeysLeft = nil, nil
+		f.position = target
+		f.seekPending = false
+		return nil
+
+	// Forward in the current partial blob
+	case f.currentReader != nil && part == currentPart && target >= f.position:
+
+	// Start reading from the partial blob containing the position
+	default:
+		closeReader(f.currentReader)
+		f.currentReader = nil
+		f.thisBlobBytesLeft = 0
+		f.otherBlobsBidsLeft, f.otherBlobsKeysLeft = f.bids[part:], f.keys[part:]
+
+		reader, err := f.openPartRange(part, target-f.offsets[part])
+		if err != nil {
+			return err
+		}
+		if reader != nil {
+			f.currentReader = reader
+			f.thisBlobBytesLeft = int(f.offsets[part+1] - target)
+			f.otherBlobsBidsLeft, f.otherBlobsKeysLeft = f.bids[part+1:], f.keys[part+1:]
+			f.position = target
+			f.seekPending = false
+			return nil
+		}
+
+		if err := f.switchToNextPartialBlob(); err != nil {
+			return err
+		}
+		f.position = f.offsets[part]
+	}
+
+	skip := target - f.position
+	if err := f.skip(skip); err != nil {
+		return err
+	}
+	f.thisBlobBytesLeft -= int(skip)
+	f.seekPending = false
+	return nil
+}
+
+// Open the partial blob at given offset of its content without fetching
+// the data before it, nil is returned if the partial blob has to be read
+// from its beginning. Only simple partial blobs encrypted with authenticated
+// ciphers allowing random access are read that way, the chunk containing
+// the offset is authenticated with the key as it's read. Partial blobs of
+// other ciphers are read from the beginning so that they're validated.
+func (f *fileBlobReader) openPartRange(part int, offset int64) (io.Reader, error) {
+	bid, key := f.bids[part], f.keys[part]
+	if offset <= 0 || !StorageCapabilities(f.storage).Has(CapabilityRangeReads) || !cipherfactory.IsAuthenticated(key) {
+		return nil, nil
+	}
+	if cache := f.decryptedCache(); cache != nil && cache.has(bid, key) {
+		return nil, nil
+	}
+
+	// The encrypted data follows the validation method, it's fetched up to
+	// the end of the blob so that the truncation is detected
+	source := &blobRangeSource{storage: f.storage, bid: bid, pos: 0, end: 1}
+	validationMethod, err := deserializeInt(source)
+	source.Close()
+	if err != nil {
+		return nil, err
+	}
+	if validationMethod != validationMethodHash {
+		return nil, ErrInvalidValidationMethod
+	}
+	source.pos, source.end = 1, -1
+	decryptor, err := cipherfactory.Create().CreateSeekableDecryptor(key, nil, source)
+	if err == cipherfactory.ErrNotSeekable {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the first chunk is fetched to check the blob type
+	if blobType, err := deserializeInt(decryptor); err != nil || blobType != blobTypeSimpleStaticFile {
+		source.Close()
+		return nil, nil
+	}
+	if _, err = decryptor.Seek(1+offset, io.SeekStart); err != nil {
+		source.Close()
+		return nil, err
+	}
+	return &rangeReader{Reader: decryptor, closer: source}, nil
+}
+
+// Read and drop bytes from the current reader
+func (f *fileBlobReader) skip(count int64) error {
+	n, err := io.CopyN(ioutil.Discard, f.currentReader, count)
+	f.position += n
+	if err == io.EOF {
+		// Position past the end of the simple file
+		if !f.isSplit {
+			f.position += count - n
+			return nil
+		}
+		return ErrMalformedSplitFileTruncatedPart
+	}
+	return err
+}
+
+// Raw data of the blob read in ranges, the range from the current
+// position to the end is fetched on the first read after the seek.
+// Negative end is the end of the blob.
+type blobRangeSource struct {
+	storage  BlobStorage
+	bid      string
+	pos, end int64
+	reader   io.Reader
+}
+
+func (s *blobRangeSource) Read(p []byte) (n int, err error) {
+	if s.reader == nil {
+		length := s.end - s.pos
+		if s.end < 0 {
+			length = -1
+		}
+		if s.reader, err = NewBlobReaderRange(s.storage, s.bid, s.pos, length); err != nil {
+			return
+		}
+	}
+	n, err = s.reader.Read(p)
+	s.pos += int64(n)
+	return
+}
+
+func (s *blobRangeSource) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	default:
+		return s.pos, ErrInvalidSeekPosition
+	}
+	if offset < 0 {
+		return s.pos, ErrInvalidSeekPosition
+	}
+	if offset != s.pos {
+		s.Close()
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *blobRangeSource) Close() error {
+	if s.reader != nil {
+		closeReader(s.reader)
+		s.reader = nil
+	}
+	return nil
 }
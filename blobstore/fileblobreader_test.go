@@ -2,6 +2,8 @@ package blobstore
 
 import (
 	"bytes"
+	"github.com/cinode/golib/blobstore/wire"
+	"io"
 	"io/ioutil"
 	"testing"
 )
@@ -12,6 +14,87 @@ func putBlob(storage BlobStorage, bid string, content []byte) {
 	writer.Finalize()
 }
 
+// Storage with blobs of all built-in file and directory formats referenced
+// by fuzzed blobs, the raw content of each blob is returned as a seed
+func newFuzzStorage(tb testing.TB) (BlobStorage, [][]byte) {
+	storage := NewMemoryBlobStorage()
+	var refs []BlobReference
+	for _, d := range []struct {
+		data     []byte
+		config   *WriterConfig
+		compress bool
+	}{
+		{[]byte("Hello World!"), nil, false},
+		{bytes.Repeat([]byte("0123456789"), 250), &WriterConfig{ChunkSize: 1000}, false},
+		{bytes.Repeat([]byte("a"), 2000), nil, true},
+	} {
+		fw := FileBlobWriter{Storage: storage, Config: d.config, Compress: d.compress}
+		fw.Write(d.data)
+		result, err := fw.Finalize()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		refs = append(refs, result.BlobReference)
+	}
+	for _, config := range []*WriterConfig{nil, {MaxDirEntries: 2}} {
+		dw := DirBlobWriter{Storage: storage, Config: config}
+		dw.AddEntry(DirEntry{Name: "a", MimeType: "text/plain", Bid: refs[0].Bid, Key: refs[0].Key})
+		dw.AddEntry(DirEntry{Name: "b", Bid: refs[1].Bid, Key: refs[1].Key, Mode: 0644})
+		dw.AddEntry(DirEntry{Name: "c", Type: EntryTypeSymlink, Target: "a"})
+		result, err := dw.Finalize()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		refs = append(refs, result.BlobReference)
+	}
+
+	var seeds [][]byte
+	for _, ref := range refs {
+		all := append([]BlobReference{ref}, mustGetReferences(tb, ref, storage)...)
+		for _, ref := range all {
+			blobType, content, err := OpenTypedBlob(ref.Bid, ref.Key, storage)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(content)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			seeds = append(seeds, append(wire.AppendInt(nil, blobType), data...))
+		}
+	}
+	return storage, seeds
+}
+
+func mustGetReferences(tb testing.TB, ref BlobReference, storage BlobStorage) []BlobReference {
+	refs, err := GetBlobReferences(ref.Bid, ref.Key, storage)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return refs
+}
+
+// Store the blob of given raw content, the blob type included. Blobs of
+// the fuzz storage are kept, the new blob is removed once the test ends.
+func putFuzzedBlob(t *testing.T, content []byte, storage BlobStorage) (bid, key string) {
+	blobs, err := ListAllBlobs(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key, err = createHashValidatedBlobFromReaderGenerator(
+		func() io.Reader { return bytes.NewReader(content) }, storage, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blob := range blobs {
+		if blob.Bid == bid {
+			return bid, key
+		}
+	}
+	t.Cleanup(func() { storage.Delete(bid) })
+	return bid, key
+}
+
 func genericFileBlobTest(t *testing.T, bid, key string, blobContent, fileContent []byte) {
 
 	storage := NewMemoryBlobStorage()
@@ -85,10 +168,11 @@ func TestSplitAaaaFile(t *testing.T) {
 		writer.Write(b)
 	}
 	writer.Write(b[:1])
-	bid, key, err := writer.Finalize()
+	ref, err := writer.Finalize()
 	if err != nil {
 		t.Fatal(err)
 	}
+	bid, key := ref.Bid, ref.Key
 	if bid != "f8615f370c23b1bf7b654ed19aadc5e2011ff98d139cd1a05be588a8f4d03af375f3598a10b138e9106702945c7c1642827fa807d70a44454585ec5251d45b8a" ||
 		key != "01bffd8d7830029b88367640a067ce1e0220a929fdd20c0a9157f6e1e094b19ff2" {
 		t.Fatal("Invalid blob generated for testing")
@@ -120,3 +204,279 @@ func TestSplitAaaaFile(t *testing.T) {
 	}
 
 }
+
+func TestCorruptedFileBlob(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	bid := "82aeef202165cf11930ea44a9ad8337aea355d63751a7260552e3e014ad6313bca69c83fa4e3555531d44a1025708183784af0e2002562b7260559ce0e7af262"
+	key := "01ac9d259134ccef987f9f4df3115b0b7a24b379cbebb2aaa91ed811c8cf5e0907"
+	putBlob(storage, bid, []byte{0x01, 0x85, 0x5e, 0x29, 0x6f, 0x95, 0xd1, 0xea, 0xf3, 0xfe, 0xb7, 0xd4, 0x8c, 0xe1})
+
+	rdr := NewFileBlobReader(storage)
+	if err := rdr.Open(bid, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ioutil.ReadAll(rdr); err != ErrInvalidHashBlobContent {
+		t.Fatalf("Corrupted blob not detected, got error: %v", err)
+	}
+}
+
+func TestCorruptedSplitFileBlob(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	writer := FileBlobWriter{Storage: storage}
+
+	// Partial blobs must differ, otherwise they would share the same bid
+	b := make([]byte, 1024)
+	for i := 0; i < 2*16*1024; i++ {
+		b[0] = byte(i / (16 * 1024))
+		writer.Write(b)
+	}
+	writer.Write(b[:1])
+	ref, err := writer.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+
+	memory := storage.(*memoryBlobStorage)
+	secondBid := writer.partialBids[1]
+	original, _ := memory.lookup(secondBid)
+
+	for _, corrupted := range [][]byte{
+
+		// Flipped bit in the second partial blob
+		append(append([]byte{}, original[:len(original)-1]...), original[len(original)-1]^0x01),
+
+		// Truncated second partial blob
+		original[:len(original)-1],
+	} {
+
+		memory.store(secondBid, corrupted)
+
+		rdr := NewFileBlobReader(storage)
+		if err = rdr.Open(bid, key); err != nil {
+			t.Fatal(err)
+		}
+
+		// The error must be reported at the second partial blob,
+		// before any data from the third one is read
+		buff := make([]byte, 1024)
+		read := 0
+		for {
+			n, err := rdr.Read(buff)
+			read += n
+			if err != nil {
+				if err == io.EOF {
+					t.Fatal("Corrupted partial blob not detected")
+				}
+				break
+			}
+		}
+		if read > 2*maxSimpleFileDataSize {
+			t.Fatalf("Corrupted partial blob detected too late, after %v bytes", read)
+		}
+		if read < maxSimpleFileDataSize {
+			t.Fatalf("Valid partial blob not read, got only %v bytes", read)
+		}
+	}
+}
+
+func TestFileBlobSeek(t *testing.T) {
+
+	data := make([]byte, 2*maxSimpleFileDataSize+100)
+	for i := range data {
+		data[i] = byte(i ^ (i >> 8) ^ (i >> 16))
+	}
+
+	storage := NewAccessTracker(NewMemoryBlobStorage())
+	writer := FileBlobWriter{Storage: storage}
+	writer.Write(data)
+	splitRef, err := writer.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitBid, splitKey := splitRef.Bid, splitRef.Key
+	parts := writer.partialBids
+
+	simpleBid, simpleKey, err := CreateTypedBlob(blobTypeSimpleStaticFile, data[:1000], storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []struct {
+		bid, key string
+		data     []byte
+	}{
+		{splitBid, splitKey, data},
+		{simpleBid, simpleKey, data[:1000]},
+	} {
+		rdr, err := OpenFileBlob(d.bid, d.key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := int64(len(d.data))
+
+		for _, s := range []struct {
+			offset int64
+			whence int
+			pos    int64
+		}{
+			{size - 10, io.SeekStart, size - 10},
+			{10, io.SeekStart, 10},
+			{5, io.SeekCurrent, 25},
+			{-1, io.SeekEnd, size - 1},
+			{-size / 2, io.SeekEnd, size - size/2},
+			{maxSimpleFileDataSize - 5, io.SeekStart, maxSimpleFileDataSize - 5},
+			{0, io.SeekStart, 0},
+		} {
+			pos, err := rdr.Seek(s.offset, s.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != s.pos {
+				t.Fatalf("Invalid position after seek: %v, expected %v", pos, s.pos)
+			}
+			if pos >= size {
+				continue
+			}
+
+			// Reads may cross partial blob borders
+			buff := make([]byte, 10)
+			n, err := io.ReadFull(rdr, buff)
+			if pos+10 <= size && err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buff[:n], d.data[pos:pos+int64(n)]) {
+				t.Fatalf("Invalid data read at position %v", pos)
+			}
+		}
+
+		// Seeking past the end is allowed, reading is not
+		if _, err = rdr.Seek(size+5, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := rdr.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+			t.Fatalf("Invalid read past the end: %v, %v", n, err)
+		}
+
+		if _, err = rdr.Seek(-1, io.SeekStart); err != ErrInvalidSeekPosition {
+			t.Fatalf("Invalid error for negative position: %v", err)
+		}
+	}
+
+	// Only the partial blob containing the position is fetched
+	storage.Reset()
+	rdr, _ := OpenFileBlob(splitBid, splitKey, storage)
+	rdr.Seek(2*maxSimpleFileDataSize+50, io.SeekStart)
+	rest, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, data[2*maxSimpleFileDataSize+50:]) {
+		t.Fatal("Invalid data read at the end of the file")
+	}
+	for i, bid := range parts {
+		if stats, _ := storage.Stats(bid); stats.Reads != int64(i/2) {
+			t.Fatalf("Invalid number of reads of partial blob %v: %v", i, stats.Reads)
+		}
+	}
+}
+
+func FuzzFileBlobReader(f *testing.F) {
+	storage, seeds := newFuzzStorage(f)
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, content []byte) {
+		bid, key := putFuzzedBlob(t, content, storage)
+
+		if reader, err := OpenFileBlob(bid, key, storage); err == nil {
+			io.Copy(ioutil.Discard, reader)
+		}
+		if reader, err := OpenFileBlob(bid, key, storage); err == nil {
+			if _, err = reader.Seek(1000, io.SeekStart); err == nil {
+				io.Copy(ioutil.Discard, reader)
+			}
+		}
+		InspectBlobWithKey(bid, key, storage)
+		ValidateBlob(bid, key, storage)
+		StrictDecodeBlob(bid, key, storage)
+	})
+}
+
+// Storage counting readers which were not closed
+type openReadersStorage struct {
+	BlobStorage
+	open int
+}
+
+func (s *openReadersStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	reader, err := s.BlobStorage.NewBlobReader(blobId)
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	return &openReader{Reader: reader, storage: s}, nil
+}
+
+type openReader struct {
+	io.Reader
+	storage *openReadersStorage
+}
+
+func (r *openReader) Close() error {
+	if r.storage != nil {
+		r.storage.open--
+		r.storage = nil
+	}
+	return nil
+}
+
+func TestReadersCloseStorageReaders(t *testing.T) {
+
+	storage := &openReadersStorage{BlobStorage: NewMemoryBlobStorage()}
+	data := bytes.Repeat([]byte("0123456789"), 250)
+	fw := FileBlobWriter{Storage: storage, Config: &WriterConfig{ChunkSize: 1000}}
+	fw.Write(data)
+	file, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dw := DirBlobWriter{Storage: storage, Config: &WriterConfig{MaxDirEntries: 2}}
+	for _, name := range []string{"a", "b", "c"} {
+		dw.AddEntry(DirEntry{Name: name, Bid: file.Bid, Key: file.Key})
+	}
+	dir, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Partially read blobs are released when the reader is closed
+	fr, err := OpenFileBlob(file.Bid, file.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.Read(make([]byte, 1500))
+	fr.Seek(100, io.SeekStart)
+	fr.Read(make([]byte, 10))
+	if storage.open == 0 {
+		t.Fatal("The partial blob being read has not been opened")
+	}
+	if err = fr.Close(); err != nil || storage.open != 0 {
+		t.Fatalf("File blob reader left %d open readers: %v", storage.open, err)
+	}
+
+	dr, err := OpenDirBlob(dir.Bid, dir.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.NextEntry()
+	if _, err = dr.Lookup("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err = dr.Close(); err != nil || storage.open != 0 {
+		t.Fatalf("Directory blob reader left %d open readers: %v", storage.open, err)
+	}
+}
@@ -4,52 +4,551 @@
 
 package blobstore
 
-// TODO: Support for duplicates (let write the blob with same id as long as the content does match)
-
 import (
-	"os"
+	"bufio"
+	"bytes"
+	"github.com/cinode/golib/utils"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func NewFileBlobStorage(path string) BlobStorage {
+	return NewFileBlobStorageWithOptions(path, FileBlobStorageOptions{})
+}
+
+// Options of the file blob storage
+type FileBlobStorageOptions struct {
+
+	// Map blob files into memory for reads instead of reading them through
+	// buffered I/O, repeated and random reads of the same blob are served
+	// from the page cache without copying. Blob files are never modified
+	// in place thus mapped data stays valid until the reader is closed.
+	// Files are read the usual way on platforms without memory mapping
+	// or if the mapping fails.
+	MemoryMapped bool
+
+	// Source of the time blobs expire at, the system clock if nil
+	Clock utils.Clock
+}
+
+func NewFileBlobStorageWithOptions(path string, options FileBlobStorageOptions) BlobStorage {
 	os.MkdirAll(path, 0777)
-	return &fileBlobStorage{path: path}
+	if options.Clock == nil {
+		options.Clock = utils.SystemClock
+	}
+	s := &fileBlobStorage{
+		path:              path,
+		memoryMapped:      options.MemoryMapped,
+		clock:             options.Clock,
+		validationMethods: make(map[string]int64)}
+	if _, err := os.Stat(filepath.Join(path, expiryDirName)); err == nil {
+		s.hasExpiry.Store(true)
+	}
+	return s
 }
 
 type fileBlobStorage struct {
 	path string
+
+	// Read blob files through memory mappings
+	memoryMapped bool
+
+	// Source of the time blobs expire at
+	clock utils.Clock
+
+	// Taken for writing while the snapshot is created
+	snapshotLock sync.RWMutex
+
+	// Serializes checks of existing signed blobs with their replacement
+	signedLock sync.Mutex
+
+	// Taken for writing while expiring blobs are finalized or removed,
+	// other blobs are finalized with the lock taken for reading
+	expiryLock sync.RWMutex
+
+	// Expiry records might exist, see ExpiringStorage
+	hasExpiry atomic.Bool
+
+	// Cache of validation methods of known blobs
+	validationMethods     map[string]int64
+	validationMethodsLock sync.Mutex
 }
 
 type fileBlobWriter struct {
-	fl *os.File
+	fl        *os.File
+	storage   *fileBlobStorage
+	bid       string
+	first     []byte // Leading bytes of the blob, used to find the validation method
+	duplicate bool   // Hash-validated blob is already stored, its content is discarded
+	done      bool   // Finalized or canceled, the temporary file is gone
+	ttl       time.Duration
+}
+
+// Create the writer of the temporary file, the file is removed if the writer
+// is garbage collected without being finalized or canceled
+func newFileBlobWriter(s *fileBlobStorage, bid string) (*fileBlobWriter, error) {
+	if bid != "" {
+		if _, err := s.blobPath(bid); err != nil {
+			return nil, err
+		}
+	}
+	fl, err := ioutil.TempFile(s.path, tempFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	writer := &fileBlobWriter{fl: fl, storage: s, bid: bid}
+	runtime.SetFinalizer(writer, (*fileBlobWriter).Cancel)
+	return writer, nil
+}
+
+// Mark the temporary file as removed or turned into the blob, its name
+// can be taken by other writers from now on
+func (f *fileBlobWriter) release() {
+	f.done = true
+	runtime.SetFinalizer(f, nil)
 }
 
 func (f *fileBlobWriter) Write(p []byte) (n int, err error) {
+	if f.duplicate {
+		return len(p), nil
+	}
+	if len(f.first) < maxNumberBytes {
+		started := len(f.first) > 0
+		l := maxNumberBytes - len(f.first)
+		if l > len(p) {
+			l = len(p)
+		}
+		f.first = append(f.first, p[:l]...)
+
+		// Content of hash-validated blobs is determined by the blob id,
+		// the existing blob is found with a single stat. Ids of unnamed
+		// blobs are not known until they're finalized. Expiring blobs might
+		// be removed before this one is finalized, their data is kept. So
+		// is the data of blobs replacing stored copies which are corrupted.
+		if !started && f.bid != "" && IsHashValidatedBlob(f.first) {
+			if exists, _ := f.storage.Exists(f.bid); exists && !f.storage.expires(f.bid) && f.storage.verifyStored(f.bid) {
+				f.duplicate = true
+				f.fl.Close()
+				os.Remove(f.fl.Name())
+				return len(p), nil
+			}
+		}
+	}
 	return f.fl.Write(p)
 }
 
-func (f *fileBlobWriter) Finalize() error {
-	return f.fl.Close()
+func (f *fileBlobWriter) Finalize() (duplicate bool, err error) {
+	if f.done {
+		return false, os.ErrClosed
+	}
+	defer f.release()
+
+	// Blobs are not removed by the expiry sweeper while their
+	// expiry is being changed
+	if f.ttl != 0 {
+		f.storage.expiryLock.Lock()
+		defer f.storage.expiryLock.Unlock()
+	} else {
+		f.storage.expiryLock.RLock()
+		defer f.storage.expiryLock.RUnlock()
+	}
+	if duplicate, err = f.finalize(); err != nil {
+		return false, err
+	}
+	if err = f.storage.updateExpiry(f.bid, f.ttl, duplicate); err != nil {
+		return false, err
+	}
+	return duplicate, nil
+}
+
+func (f *fileBlobWriter) finalize() (duplicate bool, err error) {
+	if f.duplicate {
+		// The stored copy might have been deleted since the first write,
+		// the discarded content can't be stored instead
+		exists, err := f.storage.Exists(f.bid)
+		if err == nil && !exists {
+			err = ErrDuplicateRemoved
+		}
+		return err == nil, err
+	}
+	path, err := f.storage.blobPath(f.bid)
+	if err == nil {
+		err = f.fl.Close()
+	} else {
+		f.fl.Close()
+	}
+	if err != nil {
+		os.Remove(f.fl.Name())
+		return false, err
+	}
+
+	// Signed blobs must not be replaced with older versions
+	if len(f.first) > 0 && f.first[0] == validationMethodSign {
+		f.storage.signedLock.Lock()
+		defer f.storage.signedLock.Unlock()
+
+		replace, err := f.replacesSignedBlob(path)
+		if err != nil || !replace {
+			os.Remove(f.fl.Name())
+			return err == nil, err
+		}
+	} else {
+
+		// The link fails if the blob is already stored, i.e. by the writer
+		// racing with this one. Filesystems without hard links fall back
+		// to the rename below.
+		f.storage.snapshotLock.RLock()
+		err = os.Link(f.fl.Name(), path)
+		f.storage.snapshotLock.RUnlock()
+		if err == nil || os.IsExist(err) {
+			defer os.Remove(f.fl.Name())
+		}
+		if os.IsExist(err) {
+			return f.matchesStoredBlob(path)
+		}
+		if err == nil {
+			f.cacheValidationMethod()
+			return false, nil
+		}
+	}
+
+	// Blobs appear in the storage atomically, the rename also makes sure
+	// snapshots sharing the previous file with the storage are not changed
+	f.storage.snapshotLock.RLock()
+	err = os.Rename(f.fl.Name(), path)
+	f.storage.snapshotLock.RUnlock()
+	if err != nil {
+		os.Remove(f.fl.Name())
+		return false, err
+	}
+
+	f.cacheValidationMethod()
+	return false, nil
+}
+
+func (f *fileBlobWriter) cacheValidationMethod() {
+	if method, err := deserializeInt(bytes.NewReader(f.first)); err == nil {
+		f.storage.cacheValidationMethod(f.bid, method)
+	}
+}
+
+// Check whether the stored blob passes the verification
+func (s *fileBlobStorage) verifyStored(bid string) bool {
+	path, err := s.blobPath(bid)
+	if err != nil {
+		return false
+	}
+	fl, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer fl.Close()
+	return VerifyBlob(bid, bufio.NewReader(fl)) == nil
+}
+
+// Compare the written blob with the one already stored, the written
+// one is a duplicate if the content is the same. The stored blob is
+// replaced if the content differs and only the written one is valid.
+func (f *fileBlobWriter) matchesStoredBlob(path string) (duplicate bool, err error) {
+	stored, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer stored.Close()
+
+	written, err := os.Open(f.fl.Name())
+	if err != nil {
+		return false, err
+	}
+	defer written.Close()
+
+	same, err := sameContent(stored, written)
+	if err != nil {
+		return false, err
+	}
+	if same {
+		return true, nil
+	}
+
+	if _, err = stored.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if _, err = written.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	replace, err := replacesCollidingBlob(f.bid, stored, written)
+	if err != nil {
+		return false, err
+	}
+	if replace {
+		f.storage.snapshotLock.RLock()
+		err = os.Rename(f.fl.Name(), path)
+		f.storage.snapshotLock.RUnlock()
+		if err != nil {
+			return false, err
+		}
+		f.cacheValidationMethod()
+	}
+	return false, nil
+}
+
+// Check whether both readers give the same data
+func sameContent(a, b io.Reader) (bool, error) {
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		nB, errB := io.ReadFull(b, bufB)
+		for _, err := range []error{errA, errB} {
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return false, err
+			}
+		}
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+
+		// Equal reads shorter than the buffer end both streams
+		if errA != nil {
+			return true, nil
+		}
+	}
+}
+
+// Check whether the written signed blob should replace the existing one
+func (f *fileBlobWriter) replacesSignedBlob(path string) (bool, error) {
+	existing, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer existing.Close()
+
+	updated, err := os.Open(f.fl.Name())
+	if err != nil {
+		return false, err
+	}
+	defer updated.Close()
+
+	return shouldReplaceSignedBlob(f.bid, existing, updated)
 }
 
 func (f *fileBlobWriter) Cancel() error {
+	if f.done {
+		return nil
+	}
+	f.release()
+	if f.duplicate {
+		return nil
+	}
 	f.fl.Close()
 	os.Remove(f.fl.Name())
 	return nil
 }
 
-func (s *fileBlobStorage) blobPath(blobId string) string {
-	return s.path + string(os.PathSeparator) + blobId
+func (f *fileBlobWriter) Close() error {
+	return f.Cancel()
+}
+
+// Prefix of files with blobs being written, such files are not blobs yet
+const tempFilePrefix = ".writing-"
+
+// Get the path of the blob file, names which are not blob ids are rejected
+// so that no file outside of the storage nor internal ones can be reached
+func (s *fileBlobStorage) blobPath(blobId string) (string, error) {
+	if _, err := ParseBID(blobId); err != nil {
+		return "", ErrInvalidBID
+	}
+	return s.path + string(os.PathSeparator) + blobId, nil
 }
 
 func (s *fileBlobStorage) NewBlobWriter(blobId string) (writer WriteFinalizeCanceler, err error) {
-	fl, err := os.OpenFile(s.blobPath(blobId), os.O_WRONLY|os.O_CREATE, 0666)
+	w, err := newFileBlobWriter(s, blobId)
 	if err != nil {
 		return nil, err
 	}
-	return &fileBlobWriter{fl}, nil
+	return w, nil
 }
 
 func (s *fileBlobStorage) NewBlobReader(blobId string) (reader io.Reader, err error) {
-	return os.OpenFile(s.blobPath(blobId), os.O_RDONLY, 0666)
+	path, err := s.blobPath(blobId)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDONLY, 0666)
+	if os.IsNotExist(err) {
+		return nil, ErrBIDNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.memoryMapped {
+		if mapped, ok := mapBlobFile(file); ok {
+			return mapped, nil
+		}
+	}
+	return file, nil
+}
+
+func (s *fileBlobStorage) ValidationMethod(blobId string) (method int64, err error) {
+
+	s.validationMethodsLock.Lock()
+	method, ok := s.validationMethods[blobId]
+	s.validationMethodsLock.Unlock()
+	if ok {
+		return method, nil
+	}
+
+	if method, err = readValidationMethod(s, blobId); err != nil {
+		return
+	}
+
+	s.cacheValidationMethod(blobId, method)
+	return method, nil
+}
+
+func (s *fileBlobStorage) Exists(blobId string) (bool, error) {
+	path, err := s.blobPath(blobId)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *fileBlobStorage) Stat(blobId string) (BlobStat, error) {
+	path, err := s.blobPath(blobId)
+	if err != nil {
+		return BlobStat{}, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return BlobStat{}, ErrBIDNotFound
+	}
+	if err != nil {
+		return BlobStat{}, err
+	}
+	return BlobStat{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// The file is opened at the offset, data before it is not read
+func (s *fileBlobStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	if offset < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	path, err := s.blobPath(blobId)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDONLY, 0666)
+	if os.IsNotExist(err) {
+		return nil, ErrBIDNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.memoryMapped {
+		if mapped, ok := mapBlobFile(file); ok {
+			mapped.Seek(offset, io.SeekStart)
+			return limitRange(mapped, length), nil
+		}
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return limitRange(file, length), nil
+}
+
+func (s *fileBlobStorage) Delete(blobId string) error {
+	path, err := s.blobPath(blobId)
+	if err != nil {
+		return err
+	}
+	s.snapshotLock.RLock()
+	err = os.Remove(path)
+	s.snapshotLock.RUnlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrBIDNotFound
+		}
+		return err
+	}
+
+	s.validationMethodsLock.Lock()
+	delete(s.validationMethods, blobId)
+	s.validationMethodsLock.Unlock()
+	return s.writeExpiry(blobId, time.Time{})
+}
+
+func (s *fileBlobStorage) cacheValidationMethod(blobId string, method int64) {
+	s.validationMethodsLock.Lock()
+	defer s.validationMethodsLock.Unlock()
+	s.validationMethods[blobId] = method
+}
+
+func (s *fileBlobStorage) SnapshotStore(dest string) error {
+
+	if err := os.Mkdir(dest, 0777); err != nil {
+		return err
+	}
+
+	// No blob can appear or disappear until all of them are linked
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+
+	dir, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if internalFileName(name) {
+			continue
+		}
+		if err = linkOrCopy(filepath.Join(s.path, name), filepath.Join(dest, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hardlink the file, copy it if the link can not be created,
+// i.e. when the destination is on another filesystem
+func linkOrCopy(src, dst string) error {
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
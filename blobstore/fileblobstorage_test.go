@@ -0,0 +1,160 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileBlobStorageMemoryMapped(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := NewFileBlobStorageWithOptions(dir, FileBlobStorageOptions{MemoryMapped: true})
+	putBlob(storage, "e0", nil)
+	putBlob(storage, "b10b", []byte("0123456789"))
+
+	reader, err := storage.NewBlobReader("e0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || len(data) != 0 {
+		t.Fatalf("Invalid content of the empty blob: %q %v", data, err)
+	}
+	closeReader(reader)
+
+	reader, err = storage.NewBlobReader("b10b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleted blobs stay readable through the open reader
+	if err = storage.Delete("b10b"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if n, err := reader.(io.ReaderAt).ReadAt(buf, 3); n != 4 || err != nil || string(buf) != "3456" {
+		t.Fatalf("Invalid data read at the offset: %q %v", buf[:n], err)
+	}
+	if pos, err := reader.(io.Seeker).Seek(-2, io.SeekEnd); pos != 8 || err != nil {
+		t.Fatalf("Invalid seek: %v %v", pos, err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "89" {
+		t.Fatalf("Invalid data read after seek: %q %v", data, err)
+	}
+	closer := reader.(io.Closer)
+	if err = closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = closer.Close(); err != nil {
+		t.Fatalf("Second close failed: %v", err)
+	}
+	if n, err := reader.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Closed reader not at the end: %v %v", n, err)
+	}
+
+	// Whole files are read back through mappings
+	data := make([]byte, 100000)
+	rand.Read(data)
+	fw := FileBlobWriter{Storage: storage, Config: &WriterConfig{ChunkSize: 30000}}
+	fw.Write(data)
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr, err := OpenFileBlob(ref.Bid, ref.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := ioutil.ReadAll(fr); err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("Invalid file read through mappings: %v", err)
+	}
+	if err = ValidateBlob(ref.Bid, ref.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileBlobStorageRejectsInvalidIds(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0666)
+
+	storage := NewFileBlobStorage(filepath.Join(dir, "store"))
+	writeExpiringBlob(t, storage, "0a", []byte("a"), time.Minute)
+
+	for _, bid := range []string{"", "../secret.txt", "..", ".expiry", tempFilePrefix + "1", "0a/../0a"} {
+		if _, err = storage.NewBlobReader(bid); err != ErrInvalidBID {
+			t.Fatalf("Invalid error when reading %q: %v", bid, err)
+		}
+		if _, err = storage.Exists(bid); err != ErrInvalidBID {
+			t.Fatalf("Invalid error when checking %q: %v", bid, err)
+		}
+		if err = storage.Delete(bid); err != ErrInvalidBID {
+			t.Fatalf("Invalid error when deleting %q: %v", bid, err)
+		}
+		if bid == "" {
+			continue
+		}
+		if _, err = storage.NewBlobWriter(bid); err != ErrInvalidBID {
+			t.Fatalf("Invalid error when writing %q: %v", bid, err)
+		}
+	}
+
+	writer, err := storage.(UnnamedBlobStorage).NewUnnamedBlobWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte("data"))
+	if _, err = writer.FinalizeAs("../stored.txt"); err != ErrInvalidBID {
+		t.Fatalf("Invalid error when finalizing outside of the storage: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "stored.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Blob written outside of the storage: %v", err)
+	}
+}
+
+func TestFileBlobStorageDuplicateRemoved(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-duplicate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := NewFileBlobStorage(dir)
+	bid, _, err := createHashValidatedBlobFromReaderGenerator(func() io.Reader {
+		return bytes.NewReader([]byte("content"))
+	}, storage, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _ := storage.NewBlobReader(bid)
+	data, _ := ioutil.ReadAll(reader)
+	closeReader(reader)
+
+	// The stored copy is deleted after the write is found to be a duplicate
+	writer, err := storage.NewBlobWriter(bid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	if err = storage.Delete(bid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Finalize(); err != ErrDuplicateRemoved {
+		t.Fatalf("Invalid error of the write of the removed blob: %v", err)
+	}
+}
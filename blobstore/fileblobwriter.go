@@ -6,7 +6,11 @@ package blobstore
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha512"
+	"hash"
 	"io"
+	"time"
 )
 
 // Structure used to generate static file blobs
@@ -15,20 +19,90 @@ type FileBlobWriter struct {
 	// Buffer for storing data before we can hash it
 	buffer bytes.Buffer
 
+	// Hash state of the blob header and data in the buffer, it's kept
+	// between finalizations so that data appended after Finalize()
+	// does not require hashing already processed bytes again
+	hasher hash.Hash
+
 	// Storage object
 	Storage BlobStorage
 
+	// Context of the upload, once it's done writes and finalization fail
+	// with the context error. Blobs being stored are aborted mid-way.
+	Context context.Context
+
+	// Cut partial blobs at boundaries found in the content instead of fixed
+	// offsets, this way similar files share most of their partial blobs
+	ContentDefined bool
+
+	// Limits of content-defined chunks, limits of the configuration are
+	// used if nil.
+	// Chunks and thus blob ids of the file depend on these limits.
+	ChunkLimits *Limits
+
+	// Index of chunks stored before, chunks found in the index and
+	// in the storage are neither encrypted nor uploaded again
+	ChunkIndex ChunkIndex
+
+	// Journal recording stored partial blobs so that the interrupted
+	// upload can be resumed, nil if not needed
+	Journal UploadJournal
+
+	// Compress partial blobs before encryption, data which does
+	// not compress well is stored as is
+	Compress bool
+
+	// Layout of created blobs, the default one is used if nil
+	Config *WriterConfig
+
+	// Called with the number of bytes written so far, nil if not needed.
+	// The total is not known before finalization which reports it. Items
+	// are not named.
+	Progress ProgressFunc
+
+	// Minimum time between calls of the progress function,
+	// DefaultProgressInterval if 0
+	ProgressInterval time.Duration
+
+	// Throttled progress function
+	progress ProgressFunc
+
 	// List of partial file blobs
 	partialBids, partialKeys []string
 
+	// Sizes of partial file blobs
+	partialSizes []int64
+
+	// Rolling hash of the data in the buffer, content-defined chunking only
+	rollingHash uint32
+
 	// Overall number of bytes written so far
 	totalBytes int64
+
+	// Stored size of partial file blobs
+	partialStoredSize int64
+
+	// Statistics of blobs stored so far
+	stats UploadStats
 }
 
 // Performing a write operation on the file blob
 func (f *FileBlobWriter) Write(p []byte) (n int, err error) {
+	if err = f.contextErr(); err != nil {
+		return 0, err
+	}
+	if err = f.Config.validate(); err != nil {
+		return 0, err
+	}
+	if f.ContentDefined {
+		n, err = f.writeContentDefined(p)
+		if err == nil {
+			f.reportProgress(false)
+		}
+		return n, err
+	}
 
-	bufferSpaceLeft := maxSimpleFileDataSize - f.buffer.Len()
+	bufferSpaceLeft := f.Config.chunkSize() - f.buffer.Len()
 	written := 0
 	for len(p) > 0 {
 
@@ -39,7 +113,9 @@ func (f *FileBlobWriter) Write(p []byte) (n int, err error) {
 		}
 
 		// Chop off the next part
+		f.initHasher()
 		f.buffer.Write(p[:partialSize])
+		f.hasher.Write(p[:partialSize])
 		p = p[partialSize:]
 		bufferSpaceLeft -= partialSize
 		written += partialSize
@@ -50,93 +126,261 @@ func (f *FileBlobWriter) Write(p []byte) (n int, err error) {
 				f.Cancel()
 				return 0, err
 			}
-			bufferSpaceLeft = maxSimpleFileDataSize
+			bufferSpaceLeft = f.Config.chunkSize()
 		}
 	}
+	f.reportProgress(false)
 	return written, nil
 }
 
-// Write the current content of internal buffer into a blob,
-// save it's id and key in a list of partial blobs
-func (f *FileBlobWriter) finalizePartialBuffer() error {
+// Report bytes written so far, the final report gives the total
+func (f *FileBlobWriter) reportProgress(final bool) {
+	if f.Progress == nil {
+		return
+	}
+	if f.progress == nil {
+		f.progress = f.Progress.Throttle(f.ProgressInterval)
+	}
+	done, total := f.totalBytes+int64(f.buffer.Len()), int64(BlobInfoUnknown)
+	if final {
+		total = done
+	}
+	f.progress(done, total, "")
+}
+
+// Start hashing the partial blob, the header is hashed before any data
+func (f *FileBlobWriter) initHasher() {
+	if f.hasher == nil {
+		f.hasher = sha512.New()
+		f.hasher.Write([]byte{blobTypeSimpleStaticFile})
+	}
+}
+
+// Create the blob from the current content of the internal buffer,
+// the buffer and the hash state are left untouched
+func (f *FileBlobWriter) createPartialBlob() (bid, key string, err error) {
+
+	f.initHasher()
 
-	// Create the header
-	var hdr bytes.Buffer
-	hdr.WriteByte(blobTypeSimpleStaticFile)
+	// Sum does not change the underlying hash state
+	keySource := f.hasher.Sum(nil)
+
+	// Chunks are indexed by the uncompressed data, compressed
+	// and uncompressed blobs are interchangeable
+	var hash string
+	if f.ChunkIndex != nil {
+		hash = chunkIndexHash(f.Config, keySource)
+		if chunk, found := f.ChunkIndex.LookupChunk(hash); found {
+			exists, err := existsWithoutExpiry(f.storage(), chunk.Bid)
+			if err != nil {
+				return "", "", err
+			}
+			if exists {
+				f.stats.record(chunk.StoredSize, true)
+				return chunk.Bid, chunk.Key, nil
+			}
+		}
+	}
+
+	blobType, content := byte(blobTypeSimpleStaticFile), f.buffer.Bytes()
+	if f.Compress {
+		if compressed, ok := compressFileData(content); ok {
+			blobType, content = blobTypeCompressedStaticFile, compressed
+			keySource = compressedKeySource(compressed)
+		}
+	}
 
 	// Generate the blob
 	readerGen := func() io.Reader {
-		headerReader := bytes.NewReader(hdr.Bytes())
-		contentReader := bytes.NewReader(f.buffer.Bytes())
+		headerReader := bytes.NewReader([]byte{blobType})
+		contentReader := bytes.NewReader(content)
 		return io.MultiReader(headerReader, contentReader)
 	}
-	bid, key, err := createHashValidatedBlobFromReaderGenerator(readerGen, f.Storage)
+
+	before := f.stats.storedBytes()
+	if bid, key, err = createHashValidatedBlobWithKeySource(keySource, readerGen, f.storage(), f.Config, &f.stats); err != nil || f.ChunkIndex == nil {
+		return
+	}
+	f.ChunkIndex.RememberChunk(hash, IndexedChunk{
+		BlobReference: BlobReference{Bid: bid, Key: key},
+		StoredSize:    f.stats.storedBytes() - before,
+	})
+	return
+}
+
+// Write the current content of internal buffer into a blob,
+// save it's id and key in a list of partial blobs
+func (f *FileBlobWriter) finalizePartialBuffer() error {
+
+	before := f.stats.storedBytes()
+	bid, key, err := f.createPartialBlob()
 	if err != nil {
 		return err
 	}
+	f.partialStoredSize += f.stats.storedBytes() - before
 
 	// Queue the blob on a list of partial blobs
-	f.addPartialBlob(bid, key)
+	f.addPartialBlob(bid, key, int64(f.buffer.Len()))
+	if f.Journal != nil {
+		if err = f.Journal.Append(JournalPart{Bid: bid, Key: key, Size: int64(f.buffer.Len())}); err != nil {
+			return err
+		}
+	}
 
 	// Increase the counter of bytes thrown out so far
 	f.totalBytes += int64(f.buffer.Len())
 
 	// Cleanup
 	f.buffer.Reset()
+	f.hasher = nil
 
 	return nil
 }
 
-// Save bid and key into a list of partial blobs
-func (f *FileBlobWriter) addPartialBlob(bid, key string) {
+// Save bid, key and size into a list of partial blobs
+func (f *FileBlobWriter) addPartialBlob(bid, key string, size int64) {
 	f.partialBids = append(f.partialBids, bid)
 	f.partialKeys = append(f.partialKeys, key)
+	f.partialSizes = append(f.partialSizes, size)
+}
+
+// Finalize the generation of this file blob.
+//
+// The writer does remember its state after finalization. More data can
+// be written after this call and the next Finalize() will produce blob
+// for the whole content written so far. Only the data written after the
+// last full partial blob is processed again.
+func (f *FileBlobWriter) Finalize() (FinalizeResult, error) {
+	if err := f.contextErr(); err != nil {
+		return FinalizeResult{}, err
+	}
+	if err := f.Config.validate(); err != nil {
+		return FinalizeResult{}, err
+	}
+	before := f.stats
+	bid, key, err := f.finalize()
+	if err != nil {
+		return FinalizeResult{}, err
+	}
+	f.reportProgress(true)
+	return FinalizeResult{
+		BlobReference: BlobReference{Bid: bid, Key: key},
+		Size:          f.totalBytes + int64(f.buffer.Len()),
+		StoredSize:    f.partialStoredSize + f.stats.storedBytes() - before.storedBytes(),
+		Blobs:         len(f.partialBids) + f.stats.blobs() - before.blobs(),
+	}, nil
 }
 
-// Finalize the generation of this file blob
-func (f *FileBlobWriter) Finalize() (bid string, key string, err error) {
+func (f *FileBlobWriter) finalize() (bid string, key string, err error) {
 
-	// Throw out the last partial if needed
-	if f.buffer.Len() > 0 || len(f.partialBids) == 0 {
-		if err := f.finalizePartialBuffer(); err != nil {
-			f.Cancel()
-			return "", "", err
+	// If there's no data after the last full partial blob, it's
+	// the last one, otherwise the remaining data is put into a blob
+	// without consuming it, more data may be appended later
+	if f.buffer.Len() == 0 && len(f.partialBids) > 0 {
+		if len(f.partialBids) == 1 {
+			return f.partialBids[0], f.partialKeys[0], nil
 		}
+		if f.listsPartSizes() {
+			return f.finalizeChunkedFile(f.partialBids, f.partialKeys, f.partialSizes, f.totalBytes)
+		}
+		return f.finalizeSplitFile(f.partialBids, f.partialKeys, f.totalBytes)
+	}
+
+	lastBid, lastKey, err := f.createPartialBlob()
+	if err != nil {
+		f.Cancel()
+		return "", "", err
 	}
 
 	// If there's only one partial in the list, we don't have to create
 	// any split file blobs
-	if len(f.partialBids) == 1 {
-		return f.partialBids[0], f.partialKeys[0], nil
+	if len(f.partialBids) == 0 {
+		return lastBid, lastKey, nil
+	}
+
+	if f.listsPartSizes() {
+		return f.finalizeChunkedFile(
+			append(f.partialBids[:len(f.partialBids):len(f.partialBids)], lastBid),
+			append(f.partialKeys[:len(f.partialKeys):len(f.partialKeys)], lastKey),
+			append(f.partialSizes[:len(f.partialSizes):len(f.partialSizes)], int64(f.buffer.Len())),
+			f.totalBytes+int64(f.buffer.Len()))
 	}
 
 	// Create split file blob
-	return f.finalizeSplitFile()
+	return f.finalizeSplitFile(
+		append(f.partialBids[:len(f.partialBids):len(f.partialBids)], lastBid),
+		append(f.partialKeys[:len(f.partialKeys):len(f.partialKeys)], lastKey),
+		f.totalBytes+int64(f.buffer.Len()))
+}
+
+// Check whether sizes of partial blobs must be listed, only files split
+// at fixed offsets of the default chunk size don't need them
+func (f *FileBlobWriter) listsPartSizes() bool {
+	return f.ContentDefined || f.Config.chunkSize() != maxSimpleFileDataSize
 }
 
 // Finalize blob generation in case we've created split file blob
-func (f *FileBlobWriter) finalizeSplitFile() (bid string, key string, err error) {
+func (f *FileBlobWriter) finalizeSplitFile(bids, keys []string, totalBytes int64) (bid string, key string, err error) {
 	var b bytes.Buffer
 
 	// Blob type id
 	b.WriteByte(blobTypeSplitStaticFile)
 
 	// Total file size
-	serializeInt(f.totalBytes, &b)
+	serializeInt(totalBytes, &b)
 
 	// Number of partial blobs
-	serializeInt(int64(len(f.partialBids)), &b)
+	serializeInt(int64(len(bids)), &b)
 
 	// Partial blobs list
-	for i, bid := range f.partialBids {
+	for i, bid := range bids {
 		serializeString(bid, &b)
-		serializeString(f.partialKeys[i], &b)
+		serializeString(keys[i], &b)
 	}
 
 	// Write it all to the storage
 	return createHashValidatedBlobFromReaderGenerator(
 		func() io.Reader { return bytes.NewReader(b.Bytes()) },
-		f.Storage)
+		f.storage(), f.Config, &f.stats)
+}
+
+// Finalize blob generation in case we've created chunked file blob
+func (f *FileBlobWriter) finalizeChunkedFile(bids, keys []string, sizes []int64, totalBytes int64) (bid string, key string, err error) {
+	var b bytes.Buffer
+
+	b.WriteByte(blobTypeChunkedStaticFile)
+	serializeInt(totalBytes, &b)
+	serializeInt(int64(len(bids)), &b)
+	for i, bid := range bids {
+		serializeInt(sizes[i], &b)
+		serializeString(bid, &b)
+		serializeString(keys[i], &b)
+	}
+
+	return createHashValidatedBlobFromReaderGenerator(
+		func() io.Reader { return bytes.NewReader(b.Bytes()) },
+		f.storage(), f.Config, &f.stats)
+}
+
+// Get the storage bound to the context of the writer
+func (f *FileBlobWriter) storage() BlobStorage {
+	if f.Context == nil {
+		return f.Config.limitStorage(f.Storage)
+	}
+	return f.Config.limitStorage(WithContext(f.Context, f.Storage))
+}
+
+func (f *FileBlobWriter) contextErr() error {
+	if f.Context == nil {
+		return nil
+	}
+	return f.Context.Err()
+}
+
+// Get statistics of blobs stored by the writer so far. Blobs stored
+// again by repeated calls to Finalize() are counted as deduplicated.
+func (f *FileBlobWriter) Stats() UploadStats {
+	return f.stats
 }
 
 // Cancel the generation of file blob.
@@ -148,6 +392,10 @@ func (f *FileBlobWriter) Cancel() {
 
 	f.partialBids = nil
 	f.partialKeys = nil
+	f.partialSizes = nil
+	f.partialStoredSize = 0
+	f.rollingHash = 0
 	f.buffer.Reset()
+	f.hasher = nil
 	f.totalBytes = 0
 }
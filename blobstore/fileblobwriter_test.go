@@ -19,7 +19,7 @@ type blobTest struct {
 }
 
 type blobFinalizer interface {
-	Finalize() (bid string, key string, err error)
+	Finalize() (FinalizeResult, error)
 }
 
 func hexDump(buff []byte) string {
@@ -34,10 +34,11 @@ func blobValidation(t *testing.T, test blobTest, result blobFinalizer, m BlobSto
 	key := strings.Replace(test.key, " ", "", -1)
 	bid := strings.Replace(test.bid, " ", "", -1)
 
-	rbid, rkey, err := result.Finalize()
+	ref, err := result.Finalize()
 	if err != nil {
 		t.Error(err)
 	}
+	rbid, rkey := ref.Bid, ref.Key
 
 	if rbid != bid {
 		t.Errorf("Invalid blob id generated, got: %v..., expected: %v...", rbid[:16], bid[:16])
@@ -195,3 +196,50 @@ func TestSplitFiles1(t *testing.T) {
 		m,
 	)
 }
+
+func TestFinalizeCheckpoints(t *testing.T) {
+
+	m := NewMemoryBlobStorage()
+	bw := FileBlobWriter{Storage: m}
+
+	b := make([]byte, 1024)
+	for i, _ := range b {
+		b[i] = 'a'
+	}
+
+	// Intermediate finalizations must not change the final result
+	bw.Write(b[:1])
+	if _, err := bw.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	bw.Write(b[1:])
+	for i := 1; i < 16*1024; i++ {
+		bw.Write(b)
+	}
+
+	// Checkpoint at the simple file border
+	blobValidation(
+		t,
+		blobTest{
+			"0155fff9dc9655f537ef8ce5353b0ba71cba4f21e5dceb7088e9652c764b2b5bc80e7c0de9c1fc2e...ed6939a903cb6b3c7e732aa5f819064e0d8daede01af8977c327756464fbcbacdf1ada087116472e",
+			"01bf10a3a98a6bf052317e37199dcea98ec846a258ff1023023c30acd86e35e40e",
+			"a81ab8676d6fd4a6492dc817de80897e7b504d6bc7743c55cfd44f2863be6bed5c8ef02e7177666547abf9bf4646adf09764477330a968a1255cfb43f7cb4b50",
+		},
+		&bw,
+		m,
+	)
+
+	// Appending after the checkpoint turns it into a split file
+	bw.Write(b[:1])
+
+	blobValidation(
+		t,
+		blobTest{
+			"01a19f05435d5b1bc15ca651c37c517ad482efb4cfa76b6e46a3e48367d478049fd3c5550ac160bf...713b00729c26c6cb415226d4024264d5778fe10a9f31549abfc6bffe8fd6be4aa9094a3e4262c053",
+			"01bffd8d7830029b88367640a067ce1e0220a929fdd20c0a9157f6e1e094b19ff2",
+			"f8615f370c23b1bf7b654ed19aadc5e2011ff98d139cd1a05be588a8f4d03af375f3598a10b138e9106702945c7c1642827fa807d70a44454585ec5251d45b8a",
+		},
+		&bw,
+		m,
+	)
+}
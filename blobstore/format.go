@@ -0,0 +1,105 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"io"
+	"sort"
+)
+
+// Blob types of formats built into the library
+const (
+	BlobTypeSimpleFile     = blobTypeSimpleStaticFile
+	BlobTypeSplitFile      = blobTypeSplitStaticFile
+	BlobTypeChunkedFile    = blobTypeChunkedStaticFile
+	BlobTypeCompressedFile = blobTypeCompressedStaticFile
+	BlobTypeSimpleDir      = blobTypeSimpleStaticDir
+	BlobTypeSimpleDirV2    = blobTypeSimpleStaticDirV2
+	BlobTypeSplitDir       = blobTypeSplitStaticDir
+	BlobTypeChunkedDir     = blobTypeChunkedStaticDir
+	BlobTypeCommit         = blobTypeCommit
+	BlobTypeLink           = blobTypeLink
+)
+
+// Kind of content kept in blobs of a format
+type BlobKind int
+
+const (
+	BlobKindOther BlobKind = iota
+	BlobKindFile
+	BlobKindDir
+	BlobKindCommit
+	BlobKindLink
+)
+
+func (k BlobKind) String() string {
+	switch k {
+	case BlobKindFile:
+		return "file"
+	case BlobKindDir:
+		return "directory"
+	case BlobKindCommit:
+		return "commit"
+	case BlobKindLink:
+		return "link"
+	}
+	return "other"
+}
+
+// Description of the format of hash-validated blobs. The blob type is
+// stored as the first value of the unencrypted content, formats are never
+// changed once released - changes are introduced as new blob types with
+// the version increased.
+type BlobFormat struct {
+	Type    int64    // Blob type identifying the format
+	Name    string   // Human-readable name of the format
+	Kind    BlobKind // Kind of the content
+	Version int      // Version among formats of the same name, starting at 1
+	Known   bool     // Whether the handler of the format is registered
+}
+
+// Get all registered blob formats sorted by their types
+func BlobFormats() []BlobFormat {
+	blobTypesLock.RLock()
+	defer blobTypesLock.RUnlock()
+
+	formats := make([]BlobFormat, 0, len(blobTypes))
+	for _, registered := range blobTypes {
+		formats = append(formats, registered.format)
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i].Type < formats[j].Type })
+	return formats
+}
+
+// Find the format of given blob type. Types unknown to this version of the
+// library, i.e. formats introduced later, get a format with Known set to false.
+func LookupBlobFormat(blobType int64) BlobFormat {
+	blobTypesLock.RLock()
+	defer blobTypesLock.RUnlock()
+
+	if registered, ok := blobTypes[blobType]; ok {
+		return registered.format
+	}
+	return BlobFormat{Type: blobType, Name: "unknown", Kind: BlobKindOther}
+}
+
+// Read the blob type from the unencrypted content of the blob, the reader is
+// left right after the blob type. Unknown blob types are not an error, the
+// caller decides whether blobs it can't interpret can be skipped.
+func DetectBlobType(reader io.Reader) (BlobFormat, error) {
+	blobType, err := deserializeInt(reader)
+	if err != nil {
+		return BlobFormat{}, err
+	}
+	return LookupBlobFormat(blobType), nil
+}
+
+// Get the format of the blob, only available if the blob type is known
+func (b *BlobInfo) Format() (BlobFormat, bool) {
+	if b.BlobType == BlobInfoUnknown {
+		return BlobFormat{}, false
+	}
+	return LookupBlobFormat(b.BlobType), true
+}
@@ -0,0 +1,118 @@
+package blobstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlobFormats(t *testing.T) {
+
+	formats := BlobFormats()
+	for i := 1; i < len(formats); i++ {
+		if formats[i-1].Type >= formats[i].Type {
+			t.Fatalf("Formats not sorted by type: %v", formats)
+		}
+	}
+
+	for _, d := range []struct {
+		blobType int64
+		kind     BlobKind
+		version  int
+	}{
+		{BlobTypeSimpleFile, BlobKindFile, 1},
+		{BlobTypeSplitFile, BlobKindFile, 1},
+		{BlobTypeChunkedFile, BlobKindFile, 1},
+		{BlobTypeSimpleDir, BlobKindDir, 1},
+		{BlobTypeSimpleDirV2, BlobKindDir, 2},
+		{BlobTypeSplitDir, BlobKindDir, 1},
+		{BlobTypeChunkedDir, BlobKindDir, 1},
+		{BlobTypeCommit, BlobKindCommit, 1},
+		{BlobTypeLink, BlobKindLink, 1},
+	} {
+		format := LookupBlobFormat(d.blobType)
+		if !format.Known || format.Kind != d.kind || format.Version != d.version || format.Name == "" {
+			t.Fatalf("Invalid format of blob type 0x%02x: %+v", d.blobType, format)
+		}
+	}
+	if LookupBlobFormat(BlobTypeSimpleDir).Name != LookupBlobFormat(BlobTypeSimpleDirV2).Name {
+		t.Fatal("Versions of the simple directory format have different names")
+	}
+
+	if format := LookupBlobFormat(0x7E); format.Known || format.Type != 0x7E || format.Kind != BlobKindOther {
+		t.Fatalf("Invalid format of unknown blob type: %+v", format)
+	}
+}
+
+func TestDetectBlobType(t *testing.T) {
+
+	var buff bytes.Buffer
+	serializeInt(BlobTypeSplitDir, &buff)
+	buff.WriteString("rest")
+
+	format, err := DetectBlobType(&buff)
+	if err != nil || format.Type != BlobTypeSplitDir || !format.Known {
+		t.Fatalf("Invalid detected format: %+v, %v", format, err)
+	}
+	if rest, _ := ioutil.ReadAll(&buff); string(rest) != "rest" {
+		t.Fatalf("Reader not positioned after the blob type: %q", rest)
+	}
+
+	buff.Reset()
+	serializeInt(0x7E, &buff)
+	if format, err = DetectBlobType(&buff); err != nil || format.Known {
+		t.Fatalf("Invalid detected format of unknown type: %+v, %v", format, err)
+	}
+
+	if _, err = DetectBlobType(&buff); err == nil {
+		t.Fatal("Detected the blob type of empty content")
+	}
+}
+
+func TestMaterializeSkipsUnknownFormats(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	unknownBid, unknownKey, err := CreateTypedBlob(0x7E, []byte("future"), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	file, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dw := DirBlobWriter{Storage: storage}
+	dw.AddEntry(DirEntry{Name: "future", Bid: unknownBid, Key: unknownKey})
+	dw.AddEntry(DirEntry{Name: "hello.txt", Bid: file.Bid, Key: file.Key})
+	dir, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := InspectBlobWithKey(unknownBid, unknownKey, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, ok := info.Format(); !ok || format.Known {
+		t.Fatalf("Invalid format of the unknown blob: %+v", format)
+	}
+
+	path, err := ioutil.TempDir("", "cinode-format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	if err = MaterializeDirectory(dir.Bid, dir.Key, storage, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(path, "future")); !os.IsNotExist(err) {
+		t.Fatalf("Blob of unknown format materialized: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(path, "hello.txt")); string(data) != "Hello World!" {
+		t.Fatalf("Invalid materialized file: %q", data)
+	}
+}
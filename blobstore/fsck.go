@@ -0,0 +1,194 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrMissingQuarantine = errors.New("Quarantine storage is required to quarantine corrupted blobs")
+)
+
+// Way of handling corrupted blobs found by Fsck
+type RepairMode int
+
+const (
+	RepairNone       RepairMode = iota // Only report corrupted blobs
+	RepairDelete                       // Delete corrupted blobs
+	RepairQuarantine                   // Move corrupted blobs to the quarantine storage
+)
+
+// Options of the storage verification
+type FsckOptions struct {
+	Repair     RepairMode
+	Quarantine BlobStorage // Target of moved blobs, RepairQuarantine only
+}
+
+// Blob which failed the verification
+type CorruptedBlob struct {
+	StoredBlob
+	Err       error // Reason of the failure
+	Truncated bool  // Content ended prematurely
+	Repaired  bool  // Blob was deleted or moved to the quarantine
+}
+
+// Blob which couldn't be read, it's neither verified nor repaired
+type UnreadableBlob struct {
+	StoredBlob
+	Err error // Reason of the failure
+}
+
+// Result of the storage verification
+type FsckReport struct {
+	Checked      int   // Number of verified blobs
+	CheckedBytes int64 // Overall size of verified blobs
+	Corrupted    []CorruptedBlob
+	Unreadable   []UnreadableBlob
+}
+
+// Check whether all blobs passed the verification or were repaired
+func (r *FsckReport) Clean() bool {
+	if len(r.Unreadable) > 0 {
+		return false
+	}
+	for _, blob := range r.Corrupted {
+		if !blob.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify all blobs of the storage, the storage must implement Lister.
+// Hash-validated blobs are hashed again, signed blobs must carry the valid
+// signature of the public key matching the blob id. Keys are not needed,
+// the content of blobs is not decrypted, it's verified while it's read.
+// Blobs deleted while verifying are skipped.
+//
+// Only blobs read to the end and failing the verification are corrupted,
+// those are reported and handled according to the repair mode. Blobs which
+// can't be read are reported as unreadable and left as they are. Errors of
+// the listing and of the repair abort the verification, so do storages
+// becoming unavailable and canceled contexts.
+func Fsck(storage BlobStorage, options FsckOptions) (*FsckReport, error) {
+	if options.Repair == RepairQuarantine && options.Quarantine == nil {
+		return nil, ErrMissingQuarantine
+	}
+
+	report := &FsckReport{}
+	err := WalkBlobs(storage, "", func(blob StoredBlob) error {
+		size, readErr, err := verifyStoredBlob(storage, blob.Bid)
+		switch {
+		case readErr == ErrBIDNotFound:
+			return nil
+		case isTransient(readErr):
+			return readErr
+		case readErr != nil:
+			report.Unreadable = append(report.Unreadable, UnreadableBlob{StoredBlob: blob, Err: readErr})
+			return nil
+		}
+		report.Checked++
+		report.CheckedBytes += size
+		if err == nil {
+			return nil
+		}
+
+		corrupted := CorruptedBlob{
+			StoredBlob: blob,
+			Err:        err,
+			Truncated:  err == io.EOF || err == io.ErrUnexpectedEOF || size < blob.Size,
+		}
+		if corrupted.Repaired, err = repairBlob(storage, blob.Bid, options); err != nil {
+			return err
+		}
+		report.Corrupted = append(report.Corrupted, corrupted)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Verify the blob while reading it, errors of reading the blob are
+// returned separately from the result of the verification
+func verifyStoredBlob(storage BlobStorage, blobId string) (size int64, readErr, err error) {
+	reader, err := storage.NewBlobReader(blobId)
+	if err != nil {
+		return 0, err, nil
+	}
+	defer closeReader(reader)
+
+	tracker := &readTracker{reader: reader}
+	err = VerifyBlob(blobId, bufio.NewReader(tracker))
+	return tracker.size, tracker.err, err
+}
+
+// Check whether the error is not caused by the blob, the
+// verification may succeed once it's retried
+func isTransient(err error) bool {
+	return errors.Is(err, ErrStorageUnavailable) || errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// Reader keeping the number of bytes read and the error
+// of the underlying reader other than io.EOF
+type readTracker struct {
+	reader io.Reader
+	size   int64
+	err    error
+}
+
+func (r *readTracker) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.size += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// Remove the corrupted blob from the storage according to the repair mode,
+// the blob is copied to the quarantine as it is
+func repairBlob(storage BlobStorage, blobId string, options FsckOptions) (bool, error) {
+	switch options.Repair {
+	case RepairQuarantine:
+		if err := quarantineBlob(storage, blobId, options.Quarantine); err != nil {
+			return false, err
+		}
+	case RepairDelete:
+	default:
+		return false, nil
+	}
+
+	if err := storage.Delete(blobId); err != nil && err != ErrBIDNotFound {
+		return false, err
+	}
+	return true, nil
+}
+
+// Copy the raw blob to the quarantine storage
+func quarantineBlob(storage BlobStorage, blobId string, quarantine BlobStorage) error {
+	reader, err := storage.NewBlobReader(blobId)
+	if err != nil {
+		return err
+	}
+	defer closeReader(reader)
+
+	writer, err := quarantine.NewBlobWriter(blobId)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, reader); err != nil {
+		writer.Cancel()
+		return err
+	}
+	_, err = writer.Finalize()
+	return err
+}
@@ -0,0 +1,147 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+
+	create := func(storage BlobStorage) (good, corrupted, truncated, signed string) {
+		for i, content := range []string{"good", "corrupted", "truncated"} {
+			bid, _, err := createHashValidatedBlobFromReaderGenerator(func() io.Reader {
+				return bytes.NewReader([]byte(content))
+			}, storage, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch i {
+			case 0:
+				good = bid
+			case 1:
+				corrupted = bid
+				corruptBlobAt(t, storage, bid, -1)
+			case 2:
+				truncated = bid
+				storage.Delete(bid)
+				putBlob(storage, bid, nil)
+			}
+		}
+		_, privKey, _ := ed25519.GenerateKey(rand.Reader)
+		signed, _, err := CreateSignedBlob(privKey, 1, []byte("Hello"), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corruptBlobAt(t, storage, signed, -1)
+		return
+	}
+
+	storage := NewMemoryBlobStorage()
+	good, corrupted, truncated, signed := create(storage)
+
+	report, err := Fsck(storage, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 4 || len(report.Corrupted) != 3 || report.Clean() {
+		t.Fatalf("Invalid report: %+v", report)
+	}
+	found := map[string]CorruptedBlob{}
+	for _, blob := range report.Corrupted {
+		found[blob.Bid] = blob
+		if blob.Repaired {
+			t.Fatalf("Blob repaired without the repair mode: %v", blob.Bid)
+		}
+	}
+	if found[corrupted].Err != ErrInvalidHashBlobContent || found[corrupted].Truncated ||
+		!found[truncated].Truncated || found[signed].Err == nil {
+		t.Fatalf("Invalid corrupted blobs: %+v", found)
+	}
+	if _, ok := found[good]; ok {
+		t.Fatal("Valid blob reported as corrupted")
+	}
+
+	// Corrupted blobs are moved to the quarantine
+	quarantine := NewMemoryBlobStorage()
+	if _, err = Fsck(storage, FsckOptions{Repair: RepairQuarantine}); err != ErrMissingQuarantine {
+		t.Fatalf("Invalid error for missing quarantine: %v", err)
+	}
+	report, err = Fsck(storage, FsckOptions{Repair: RepairQuarantine, Quarantine: quarantine})
+	if err != nil || !report.Clean() || len(report.Corrupted) != 3 {
+		t.Fatalf("Invalid report of the repair: %+v, %v", report, err)
+	}
+	for _, bid := range []string{corrupted, truncated, signed} {
+		if exists, _ := storage.Exists(bid); exists {
+			t.Fatalf("Corrupted blob not removed: %v", bid)
+		}
+		if exists, _ := quarantine.Exists(bid); !exists {
+			t.Fatalf("Corrupted blob not quarantined: %v", bid)
+		}
+	}
+	if report, _ = Fsck(storage, FsckOptions{}); report.Checked != 1 || !report.Clean() {
+		t.Fatalf("Invalid report after the repair: %+v", report)
+	}
+
+	// Corrupted blobs are deleted
+	storage = NewMemoryBlobStorage()
+	create(storage)
+	report, err = Fsck(storage, FsckOptions{Repair: RepairDelete})
+	if err != nil || !report.Clean() {
+		t.Fatalf("Invalid report of the deletion: %+v, %v", report, err)
+	}
+	if blobs, _ := ListAllBlobs(storage); len(blobs) != 1 {
+		t.Fatalf("Corrupted blobs not deleted: %v", blobs)
+	}
+}
+
+// Storage failing reads of blobs with the error after the first byte
+type failingReadStorage struct {
+	*memoryBlobStorage
+	err error
+}
+
+func (f *failingReadStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	reader, err := f.memoryBlobStorage.NewBlobReader(blobId)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(io.LimitReader(reader, 1), &failedReader{err: f.err}), nil
+}
+
+func TestFsckReadErrors(t *testing.T) {
+
+	storage := &failingReadStorage{memoryBlobStorage: NewMemoryBlobStorage().(*memoryBlobStorage)}
+	bid, _, err := createHashValidatedBlobFromReaderGenerator(func() io.Reader {
+		return bytes.NewReader([]byte("content"))
+	}, storage.memoryBlobStorage, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blobs which can't be read are not corrupted
+	storage.err = errors.New("I/O error")
+	report, err := Fsck(storage, FsckOptions{Repair: RepairDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 0 || len(report.Corrupted) != 0 || len(report.Unreadable) != 1 ||
+		report.Unreadable[0].Err != storage.err || report.Clean() {
+		t.Fatalf("Invalid report of the unreadable blob: %+v", report)
+	}
+	if exists, _ := storage.Exists(bid); !exists {
+		t.Fatal("Unreadable blob removed")
+	}
+
+	// Unavailable storage aborts the verification
+	storage.err = errFlaky
+	if _, err = Fsck(storage, FsckOptions{Repair: RepairDelete}); err != errFlaky {
+		t.Fatalf("Invalid error of the unavailable storage: %v", err)
+	}
+	if exists, _ := storage.Exists(bid); !exists {
+		t.Fatal("Blob of the unavailable storage removed")
+	}
+}
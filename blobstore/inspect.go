@@ -0,0 +1,195 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"encoding/hex"
+	"io"
+)
+
+const (
+	// Value used for information that is not available
+	BlobInfoUnknown = -1
+)
+
+// Information about the blob gathered from its leading bytes
+type BlobInfo struct {
+
+	// Validation method of the blob
+	ValidationMethod int64
+
+	// Type of the blob, this information is encrypted thus
+	// it's only available if the key was given
+	BlobType int64
+
+	// Version of data, signature-validated blobs only
+	Version int64
+
+	// Size of the public key and the signature, signature-validated blobs only
+	PublicKeySize, SignatureSize int
+
+	// Declared size of the file and the number of partial blobs, split files only
+	FileSize, PartsCount int64
+
+	// Declared number of entries, simple directories only
+	EntriesCount int64
+}
+
+// Check whether this is a file blob
+func (b *BlobInfo) IsFile() bool {
+	return b.BlobType == blobTypeSimpleStaticFile || b.BlobType == blobTypeSplitStaticFile ||
+		b.BlobType == blobTypeChunkedStaticFile || b.BlobType == blobTypeCompressedStaticFile
+}
+
+// Check whether this is a directory blob
+func (b *BlobInfo) IsDir() bool {
+	return b.BlobType == blobTypeSimpleStaticDir || b.BlobType == blobTypeSimpleStaticDirV2 ||
+		b.BlobType == blobTypeSplitStaticDir || b.BlobType == blobTypeChunkedStaticDir
+}
+
+// Check whether this blob is split into partial blobs
+func (b *BlobInfo) IsSplit() bool {
+	return b.BlobType == blobTypeSplitStaticFile || b.BlobType == blobTypeChunkedStaticFile ||
+		b.BlobType == blobTypeSplitStaticDir || b.BlobType == blobTypeChunkedStaticDir
+}
+
+// Check whether this is a commit blob
+func (b *BlobInfo) IsCommit() bool {
+	return b.BlobType == blobTypeCommit
+}
+
+// Check whether this is a link blob
+func (b *BlobInfo) IsLink() bool {
+	return b.BlobType == blobTypeLink
+}
+
+// Check whether this is a signature-validated blob
+func (b *BlobInfo) IsSigned() bool {
+	return b.ValidationMethod == validationMethodSign
+}
+
+// Inspect the blob without the key. Only leading bytes of the blob are read,
+// the content of the blob is not validated.
+func InspectBlob(bid string, storage BlobStorage) (info *BlobInfo, err error) {
+	bid = canonicalForm(bid)
+	reader, info, err := inspectBlobHeader(bid, storage)
+	if reader != nil {
+		closeReader(reader)
+	}
+	return
+}
+
+// Inspect the blob having its key, except the information available
+// through InspectBlob, the unencrypted header of the blob is analyzed.
+// Only leading bytes of the blob are read, the content of the blob
+// is not validated.
+func InspectBlobWithKey(bid, key string, storage BlobStorage) (info *BlobInfo, err error) {
+
+	bid, key = canonicalForm(bid), canonicalForm(key)
+	rawReader, info, err := inspectBlobHeader(bid, storage)
+	if rawReader != nil {
+		defer closeReader(rawReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Signed blobs don't contain structured data
+	if info.ValidationMethod != validationMethodHash {
+		return
+	}
+
+	reader, err := createReaderForHashBlobData(rawReader, bid, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.BlobType, err = deserializeInt(reader); err != nil {
+		return nil, err
+	}
+
+	switch info.BlobType {
+
+	case blobTypeSplitStaticFile, blobTypeChunkedStaticFile:
+		if info.FileSize, err = deserializeInt(reader); err != nil {
+			return nil, err
+		}
+		if info.PartsCount, err = deserializeInt(reader); err != nil {
+			return nil, err
+		}
+
+	case blobTypeSimpleStaticDir, blobTypeSimpleStaticDirV2:
+		if info.EntriesCount, err = deserializeInt(reader); err != nil {
+			return nil, err
+		}
+
+	case blobTypeSplitStaticDir, blobTypeChunkedStaticDir:
+		if info.EntriesCount, err = deserializeInt(reader); err != nil {
+			return nil, err
+		}
+		if info.PartsCount, err = deserializeInt(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// Read the unencrypted header of the blob, returned reader
+// is positioned right after the header
+func inspectBlobHeader(bid string, storage BlobStorage) (reader io.Reader, info *BlobInfo, err error) {
+
+	if reader, err = storage.NewBlobReader(bid); err != nil {
+		return
+	}
+
+	info = &BlobInfo{
+		BlobType:      BlobInfoUnknown,
+		Version:       BlobInfoUnknown,
+		PublicKeySize: BlobInfoUnknown,
+		SignatureSize: BlobInfoUnknown,
+		FileSize:      BlobInfoUnknown,
+		PartsCount:    BlobInfoUnknown,
+		EntriesCount:  BlobInfoUnknown,
+	}
+
+	if info.ValidationMethod, err = deserializeInt(reader); err != nil {
+		return reader, nil, err
+	}
+
+	switch info.ValidationMethod {
+
+	case validationMethodHash:
+		return reader, info, nil
+
+	case validationMethodSign:
+		pubKey, err := deserializeBuffer(reader, maxSanePubKeyLength)
+		if err != nil {
+			return reader, nil, err
+		}
+		if hex.EncodeToString(createDataHash(pubKey)) != bid {
+			return reader, nil, ErrInvalidPublicKeyBid
+		}
+		signature, err := deserializeBuffer(reader, maxSaneSignatureLength)
+		if err != nil {
+			return reader, nil, err
+		}
+		if info.Version, err = deserializeInt(reader); err != nil {
+			return reader, nil, err
+		}
+		info.PublicKeySize = len(pubKey)
+		info.SignatureSize = len(signature)
+		return reader, info, nil
+	}
+
+	return reader, nil, ErrInvalidValidationMethod
+}
+
+// Close the reader if it does support closing
+func closeReader(reader io.Reader) {
+	if closer, ok := reader.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,97 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+)
+
+func TestInspectHashBlobs(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	fileRef, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileBid, fileKey := fileRef.Bid, fileRef.Key
+
+	dw := DirBlobWriter{Storage: storage}
+	dw.AddEntry(DirEntry{Name: "hello.txt", MimeType: "text/plain", Bid: fileBid, Key: fileKey})
+	dw.AddEntry(DirEntry{Name: "hello2.txt", MimeType: "text/plain", Bid: fileBid, Key: fileKey})
+	dirRef, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirBid, dirKey := dirRef.Bid, dirRef.Key
+
+	// Without the key only the validation method is known
+	info, err := InspectBlob(fileBid, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ValidationMethod != validationMethodHash || info.BlobType != BlobInfoUnknown {
+		t.Fatalf("Invalid blob info: %+v", info)
+	}
+
+	info, err = InspectBlobWithKey(fileBid, fileKey, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsFile() || info.IsDir() || info.IsSplit() || info.FileSize != BlobInfoUnknown {
+		t.Fatalf("Invalid file blob info: %+v", info)
+	}
+
+	info, err = InspectBlobWithKey(dirBid, dirKey, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.IsFile() || info.EntriesCount != 2 {
+		t.Fatalf("Invalid dir blob info: %+v", info)
+	}
+
+	if _, err = InspectBlob("missing", storage); err != ErrBIDNotFound {
+		t.Fatalf("Invalid error for missing blob: %v", err)
+	}
+}
+
+func TestInspectSignedBlob(t *testing.T) {
+
+	privKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Could not generate test RSA key")
+	}
+
+	storage := NewMemoryBlobStorage()
+	bid, key, err := createSignValidatedBlobFromReaderGenerator(func() io.Reader {
+		return bytes.NewReader([]byte("Hello world!"))
+	}, privKey, 832, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inspect := range []func() (*BlobInfo, error){
+		func() (*BlobInfo, error) { return InspectBlob(bid, storage) },
+		func() (*BlobInfo, error) { return InspectBlobWithKey(bid, key, storage) },
+	} {
+		info, err := inspect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ValidationMethod != validationMethodSign || info.Version != 832 ||
+			info.PublicKeySize <= 0 || info.SignatureSize <= 0 || info.BlobType != BlobInfoUnknown {
+			t.Fatalf("Invalid signed blob info: %+v", info)
+		}
+	}
+
+	// Public key must match the blob id
+	blob, _ := storage.(*memoryBlobStorage).lookup(bid)
+	putBlob(storage, "invalid", blob)
+	if _, err = InspectBlob("invalid", storage); err != ErrInvalidPublicKeyBid {
+		t.Fatalf("Invalid error for mismatched public key: %v", err)
+	}
+}
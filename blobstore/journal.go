@@ -0,0 +1,209 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrJournalMismatch = errors.New("Upload journal does not match the writer")
+)
+
+// Partial blob of the file recorded in the upload journal
+type JournalPart struct {
+	Bid, Key string
+	Size     int64 // Number of file bytes in the blob
+}
+
+// Journal of the file upload. Partial blobs are recorded as soon as they're
+// stored, an upload interrupted i.e. by a crash can be resumed from the end
+// of the last recorded blob instead of starting over.
+type UploadJournal interface {
+
+	// Get recorded partial blobs in the order of the file content
+	Parts() ([]JournalPart, error)
+
+	// Record the next partial blob, it must be durable once this returns
+	Append(part JournalPart) error
+
+	// Keep only given number of first partial blobs
+	Truncate(count int) error
+}
+
+// Journal kept in memory, it survives failures of the writer only
+type MemoryJournal struct {
+	lock  sync.Mutex
+	parts []JournalPart
+}
+
+func (m *MemoryJournal) Parts() ([]JournalPart, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]JournalPart(nil), m.parts...), nil
+}
+
+func (m *MemoryJournal) Append(part JournalPart) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.parts = append(m.parts, part)
+	return nil
+}
+
+func (m *MemoryJournal) Truncate(count int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if count < len(m.parts) {
+		m.parts = m.parts[:count]
+	}
+	return nil
+}
+
+// Journal stored in a local file, one partial blob per line. Lines are
+// synced to the disk as they're appended, the incomplete last line left
+// by a crash is ignored.
+type FileJournal struct {
+	path string
+	lock sync.Mutex
+}
+
+// Open the journal in given file, the file is created once the first
+// partial blob is recorded
+func OpenFileJournal(path string) *FileJournal {
+	return &FileJournal{path: path}
+}
+
+func (f *FileJournal) Parts() ([]JournalPart, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.parts()
+}
+
+func (f *FileJournal) parts() ([]JournalPart, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only lines ended with a newline are complete, the last
+	// element of the split is either empty or incomplete
+	lines := strings.Split(string(data), "\n")
+	parts := make([]JournalPart, 0, len(lines)-1)
+	for _, line := range lines[:len(lines)-1] {
+		var part JournalPart
+		if _, err := fmt.Sscanf(line, "%d %s %s", &part.Size, &part.Bid, &part.Key); err != nil {
+			return nil, ErrJournalMismatch
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+func (f *FileJournal) Append(part JournalPart) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "%d %s %s\n", part.Size, part.Bid, part.Key)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *FileJournal) Truncate(count int) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	parts, err := f.parts()
+	if err != nil || count >= len(parts) {
+		return err
+	}
+
+	// The journal is replaced at once, a crash leaves either version
+	temp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".")
+	if err != nil {
+		return err
+	}
+	for _, part := range parts[:count] {
+		if _, err = fmt.Fprintf(temp, "%d %s %s\n", part.Size, part.Bid, part.Key); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// Remove the journal once the upload is finished
+func (f *FileJournal) Remove() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Restore the state of the interrupted upload from the journal of the
+// writer, the number of file bytes already stored is returned. Writing must
+// continue from that offset of the file content, statistics and the stored
+// size of the result cover blobs stored after resuming. Recorded blobs
+// missing in the storage are dropped from the journal together with all
+// later ones. The writer must be empty and must split the file the same way
+// as the one which recorded the journal.
+func (f *FileBlobWriter) Resume() (offset int64, err error) {
+	if f.Journal == nil || f.totalBytes != 0 || f.buffer.Len() != 0 || len(f.partialBids) != 0 {
+		return 0, ErrJournalMismatch
+	}
+	parts, err := f.Journal.Parts()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, part := range parts {
+		if !f.ContentDefined && part.Size != int64(f.Config.chunkSize()) {
+			return 0, ErrJournalMismatch
+		}
+		exists, err := f.storage().Exists(part.Bid)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			if err = f.Journal.Truncate(i); err != nil {
+				return 0, err
+			}
+			break
+		}
+		f.addPartialBlob(part.Bid, part.Key, part.Size)
+		f.totalBytes += part.Size
+	}
+	return f.totalBytes, nil
+}
@@ -0,0 +1,93 @@
+package blobstore
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeUpload(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	limits := Limits{CDCMinSize: 4096, CDCMaxSize: 16384, CDCMaskBits: 13}
+	storage := NewMemoryBlobStorage()
+
+	full := FileBlobWriter{Storage: storage, ContentDefined: true, ChunkLimits: &limits}
+	full.Write(data)
+	expected, err := full.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupted upload, the writer is abandoned
+	storage = NewMemoryBlobStorage()
+	journalPath := filepath.Join(dir, "journal")
+	journal := OpenFileJournal(journalPath)
+	first := FileBlobWriter{Storage: storage, ContentDefined: true, ChunkLimits: &limits, Journal: journal}
+	first.Write(data[:len(data)/2])
+	parts, err := journal.Parts()
+	if err != nil || len(parts) < 2 {
+		t.Fatalf("Partial blobs not recorded: %v, %v", parts, err)
+	}
+
+	// Incomplete lines are ignored, blobs missing in the storage are dropped
+	file, _ := os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0)
+	file.WriteString("123 bid")
+	file.Close()
+	if err = storage.Delete(parts[len(parts)-1].Bid); err != nil {
+		t.Fatal(err)
+	}
+
+	second := FileBlobWriter{Storage: storage, ContentDefined: true, ChunkLimits: &limits, Journal: journal}
+	offset, err := second.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOffset := int64(0)
+	for _, part := range parts[:len(parts)-1] {
+		expectedOffset += part.Size
+	}
+	if offset != expectedOffset {
+		t.Fatalf("Invalid offset of the resumed upload: %v, expected %v", offset, expectedOffset)
+	}
+	if recorded, _ := journal.Parts(); len(recorded) != len(parts)-1 {
+		t.Fatalf("Journal not truncated: %v parts", len(recorded))
+	}
+
+	second.Write(data[offset:])
+	result, err := second.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BlobReference != expected.BlobReference {
+		t.Fatal("Resumed upload produced different blob")
+	}
+	if err = ValidateBlob(result.Bid, result.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resuming requires an empty writer splitting the file the same way
+	if _, err = second.Resume(); err != ErrJournalMismatch {
+		t.Fatalf("Invalid error for resuming non-empty writer: %v", err)
+	}
+	fixed := FileBlobWriter{Storage: storage, Journal: journal}
+	if _, err = fixed.Resume(); err != ErrJournalMismatch {
+		t.Fatalf("Invalid error for resuming with different chunking: %v", err)
+	}
+
+	if err = journal.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if parts, err = journal.Parts(); err != nil || len(parts) != 0 {
+		t.Fatalf("Journal not removed: %v, %v", parts, err)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"github.com/cinode/golib/utils"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key-value store blobs can be kept in, i.e. an embedded database such as
+// Badger or LevelDB. With millions of small blobs a database scales better
+// than a file per blob. Stores must be safe for concurrent use.
+type KeyValueStore interface {
+
+	// Get the value of the key, found is false if there's no such key
+	Get(key []byte) (value []byte, found bool, err error)
+
+	// Set the value of the key, readers must see either the old
+	// or the new value even if the process is killed
+	Put(key, value []byte) error
+
+	// Remove the key, removing missing keys is not an error
+	Delete(key []byte) error
+
+	// Call fn with keys not lower than start in the ascending order
+	// together with sizes of their values, until fn returns false
+	Scan(start []byte, fn func(key []byte, size int64) bool) error
+}
+
+// Optional interface of key-value stores checking keys without reading
+// their values, stores not implementing it are checked with Scan
+type KeyChecker interface {
+	Has(key []byte) (bool, error)
+}
+
+// Prefix of keys of blobs, other keys of the store are ignored
+const kvBlobPrefix = "blob/"
+
+// Blob storage kept in the key-value store. The content of each blob is
+// a single value, blobs are buffered in memory until they're finalized.
+type kvBlobStorage struct {
+	kv KeyValueStore
+
+	// Source of the time blobs expire at
+	clock utils.Clock
+
+	// Serializes checks of existing blobs with their replacement
+	lock sync.Mutex
+}
+
+// Options of the key-value blob storage
+type KeyValueBlobStorageOptions struct {
+
+	// Source of the time blobs expire at, the system clock if nil
+	Clock utils.Clock
+}
+
+// Create the storage keeping blobs in the key-value store
+func NewKeyValueBlobStorage(kv KeyValueStore) BlobStorage {
+	return NewKeyValueBlobStorageWithOptions(kv, KeyValueBlobStorageOptions{})
+}
+
+func NewKeyValueBlobStorageWithOptions(kv KeyValueStore, options KeyValueBlobStorageOptions) BlobStorage {
+	if options.Clock == nil {
+		options.Clock = utils.SystemClock
+	}
+	return &kvBlobStorage{kv: kv, clock: options.Clock}
+}
+
+func kvBlobKey(blobId string) []byte {
+	return []byte(kvBlobPrefix + blobId)
+}
+
+type kvBlobWriter struct {
+	storage *kvBlobStorage
+	buffer  bytes.Buffer
+	bid     string
+	ttl     time.Duration
+}
+
+func (w *kvBlobWriter) Write(p []byte) (n int, err error) {
+	return w.buffer.Write(p)
+}
+
+func (w *kvBlobWriter) Finalize() (duplicate bool, err error) {
+	w.storage.lock.Lock()
+	defer w.storage.lock.Unlock()
+
+	if duplicate, err = w.store(); err != nil {
+		return false, err
+	}
+	if err = w.storage.updateExpiry(w.bid, w.ttl, duplicate); err != nil {
+		return false, err
+	}
+	return duplicate, nil
+}
+
+func (w *kvBlobWriter) store() (duplicate bool, err error) {
+
+	key := kvBlobKey(w.bid)
+	previous, exists, err := w.storage.kv.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, w.storage.kv.Put(key, w.buffer.Bytes())
+	}
+	if bytes.Equal(previous, w.buffer.Bytes()) {
+		return true, nil
+	}
+
+	// Only signed blobs can be updated, with newer versions, other
+	// blobs are only replaced if the stored copy is invalid
+	var replace bool
+	if len(previous) == 0 || previous[0] != validationMethodSign {
+		replace, err = replacesCollidingBlob(w.bid, bytes.NewReader(previous), bytes.NewReader(w.buffer.Bytes()))
+	} else {
+		replace, err = shouldReplaceSignedBlob(w.bid, bytes.NewReader(previous), bytes.NewReader(w.buffer.Bytes()))
+	}
+	if err != nil {
+		return false, err
+	}
+	if !replace {
+		return true, nil
+	}
+	return false, w.storage.kv.Put(key, w.buffer.Bytes())
+}
+
+func (w *kvBlobWriter) Cancel() error {
+	w.buffer.Reset()
+	return nil
+}
+
+func (w *kvBlobWriter) Close() error {
+	return w.Cancel()
+}
+
+func (s *kvBlobStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	return &kvBlobWriter{storage: s, bid: blobId}, nil
+}
+
+func (s *kvBlobStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	blob, found, err := s.kv.Get(kvBlobKey(blobId))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrBIDNotFound
+	}
+	return bytes.NewReader(blob), nil
+}
+
+func (s *kvBlobStorage) Exists(blobId string) (bool, error) {
+	return s.has(kvBlobKey(blobId))
+}
+
+// Check whether the key exists without reading its value
+func (s *kvBlobStorage) has(key []byte) (bool, error) {
+	if checker, ok := s.kv.(KeyChecker); ok {
+		return checker.Has(key)
+	}
+	found := false
+	err := s.kv.Scan(key, func(k []byte, size int64) bool {
+		found = bytes.Equal(k, key)
+		return false
+	})
+	return found, err
+}
+
+// The size is taken from the scan of the store, the value is not read.
+// Modification times are not tracked.
+func (s *kvBlobStorage) Stat(blobId string) (BlobStat, error) {
+	key := kvBlobKey(blobId)
+	stat, found := BlobStat{}, false
+	err := s.kv.Scan(key, func(k []byte, size int64) bool {
+		stat.Size, found = size, bytes.Equal(k, key)
+		return false
+	})
+	if err != nil {
+		return BlobStat{}, err
+	}
+	if !found {
+		return BlobStat{}, ErrBIDNotFound
+	}
+	return stat, nil
+}
+
+func (s *kvBlobStorage) Delete(blobId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := kvBlobKey(blobId)
+	found, err := s.has(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrBIDNotFound
+	}
+	if err = s.kv.Delete(key); err != nil {
+		return err
+	}
+	return s.updateExpiry(blobId, 0, false)
+}
+
+// Blobs are listed straight from the ordered keys of the store
+func (s *kvBlobStorage) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	start := kvBlobPrefix + prefix
+	if cursor != "" && cursor >= prefix {
+		start = kvBlobPrefix + cursor + "\x00"
+	}
+
+	var (
+		blobs []StoredBlob
+		next  string
+	)
+	err := s.kv.Scan([]byte(start), func(key []byte, size int64) bool {
+		bid := strings.TrimPrefix(string(key), kvBlobPrefix)
+		if !strings.HasPrefix(string(key), kvBlobPrefix) || !strings.HasPrefix(bid, prefix) {
+			return false
+		}
+		if limit > 0 && len(blobs) == limit {
+			next = blobs[limit-1].Bid
+			return false
+		}
+		blobs = append(blobs, StoredBlob{Bid: bid, Size: size})
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return blobs, next, nil
+}
+
+func (s *kvBlobStorage) Capabilities() Capabilities {
+	return CapabilityList | CapabilityWrite | CapabilityDelete | CapabilityAtomicWrites | CapabilityExpiry
+}
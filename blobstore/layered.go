@@ -0,0 +1,390 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/cinode/golib/utils"
+	"io"
+	"sync"
+	"time"
+)
+
+// LayeredBlobStorage puts a fast local storage (the cache) in front of a slow
+// remote one. Reads check the cache first, blobs read from the remote storage
+// are copied into the cache on the fly. Writes go through to both storages.
+// Once the size of cached blobs exceeds the limit, least recently used blobs
+// are removed from the cache.
+//
+// The cache storage must be dedicated to the layered storage, blobs it
+// contains upfront are not accounted. Signed blobs updated directly
+// in the remote storage may be served from the cache in older versions.
+type LayeredBlobStorage struct {
+	cache, remote BlobStorage
+	maxCacheBytes int64
+
+	// Verify blobs read from the cache, corrupted copies are replaced
+	// with the remote ones if those pass the verification
+	ReadRepair bool
+
+	// Remember blobs missing in the remote storage for this long, repeated
+	// lookups of them don't reach the remote storage. Blobs written through
+	// the layered storage are visible immediately, blobs written to the
+	// remote storage directly only once the entry expires. Deleted blobs,
+	// i.e. by the garbage collector, are remembered as missing.
+	// Zero disables caching of missing blobs.
+	NegativeTTL time.Duration
+
+	// Source of time for expiring missing blobs
+	Clock utils.Clock
+
+	lock        sync.Mutex
+	lru         *list.List               // Cached blobs, most recently used first
+	cached      map[string]*list.Element // Elements of the lru list by blob id
+	cachedBytes int64                    // Size of all cached blobs
+	missing     map[string]time.Time     // Expiry of blobs known to be missing
+	writes      uint64                   // Number of blobs written so far
+}
+
+// Maximum number of remembered missing blobs
+const maxMissingBlobs = 64 * 1024
+
+// Blob kept in the cache
+type cachedBlob struct {
+	bid  string
+	size int64
+}
+
+// Create new layered storage, cached blobs won't take more than maxCacheBytes
+func NewLayeredBlobStorage(cache, remote BlobStorage, maxCacheBytes int64) *LayeredBlobStorage {
+	return &LayeredBlobStorage{
+		cache:         cache,
+		remote:        remote,
+		maxCacheBytes: maxCacheBytes,
+		Clock:         utils.SystemClock,
+		lru:           list.New(),
+		cached:        make(map[string]*list.Element),
+		missing:       make(map[string]time.Time),
+	}
+}
+
+// Get the total size of cached blobs
+func (l *LayeredBlobStorage) CachedBytes() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.cachedBytes
+}
+
+func (l *LayeredBlobStorage) NewBlobWriter(blobId string) (writer WriteFinalizeCanceler, err error) {
+	remote, err := l.remote.NewBlobWriter(blobId)
+	if err != nil {
+		return nil, err
+	}
+	return &layeredWriter{storage: l, bid: blobId, remote: remote}, nil
+}
+
+func (l *LayeredBlobStorage) NewBlobReader(blobId string) (reader io.Reader, err error) {
+	if l.touch(blobId) {
+		if l.ReadRepair {
+			if data, err := readVerifiedBlob(l.cache, blobId); err == nil {
+				return bytes.NewReader(data), nil
+			}
+
+			// Corrupted copy is removed, the remote one is cached once read
+			l.uncache(blobId)
+		} else if reader, err = l.cache.NewBlobReader(blobId); err == nil {
+			return reader, nil
+		} else {
+
+			// Blob vanished from the cache, fall back to the remote storage
+			l.forget(blobId)
+		}
+	}
+
+	if l.knownMissing(blobId) {
+		return nil, ErrBIDNotFound
+	}
+	writes := l.writesSoFar()
+	if reader, err = l.remote.NewBlobReader(blobId); err != nil {
+		if err == ErrBIDNotFound {
+			l.rememberMissing(blobId, writes)
+		}
+		return nil, err
+	}
+	return &cachingReader{storage: l, bid: blobId, reader: reader}, nil
+}
+
+func (l *LayeredBlobStorage) Exists(blobId string) (bool, error) {
+	if l.touch(blobId) {
+		return true, nil
+	}
+	if l.knownMissing(blobId) {
+		return false, nil
+	}
+	writes := l.writesSoFar()
+	exists, err := l.remote.Exists(blobId)
+	if err == nil && !exists {
+		l.rememberMissing(blobId, writes)
+	}
+	return exists, err
+}
+
+// Cached blobs are checked in the cache, the modification
+// time is the one of the cached copy then
+func (l *LayeredBlobStorage) Stat(blobId string) (BlobStat, error) {
+	if l.touch(blobId) {
+		if stat, err := Stat(l.cache, blobId); err == nil {
+			return stat, nil
+		}
+		l.forget(blobId)
+	}
+	if l.knownMissing(blobId) {
+		return BlobStat{}, ErrBIDNotFound
+	}
+	writes := l.writesSoFar()
+	stat, err := Stat(l.remote, blobId)
+	if err == ErrBIDNotFound {
+		l.rememberMissing(blobId, writes)
+	}
+	return stat, err
+}
+
+func (l *LayeredBlobStorage) Delete(blobId string) error {
+	writes := l.writesSoFar()
+	l.uncache(blobId)
+	err := l.remote.Delete(blobId)
+	if err == nil || err == ErrBIDNotFound {
+		l.rememberMissing(blobId, writes)
+	}
+	return err
+}
+
+// Check whether the blob is known to be missing in the remote storage
+func (l *LayeredBlobStorage) knownMissing(blobId string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	expiry, ok := l.missing[blobId]
+	if ok && !l.Clock.Now().Before(expiry) {
+		delete(l.missing, blobId)
+		return false
+	}
+	return ok
+}
+
+// Get the number of blobs written so far, lookups started before
+// a write must not remember the blob as missing
+func (l *LayeredBlobStorage) writesSoFar() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.writes
+}
+
+// Remember the blob as missing unless any blob was written since
+// the lookup started
+func (l *LayeredBlobStorage) rememberMissing(blobId string, writes uint64) {
+	if l.NegativeTTL <= 0 {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.writes != writes {
+		return
+	}
+	now := l.Clock.Now()
+	if len(l.missing) >= maxMissingBlobs {
+		for bid, expiry := range l.missing {
+			if !now.Before(expiry) {
+				delete(l.missing, bid)
+			}
+		}
+		if len(l.missing) >= maxMissingBlobs {
+			l.missing = make(map[string]time.Time)
+		}
+	}
+	l.missing[blobId] = now.Add(l.NegativeTTL)
+}
+
+// Forget the blob was missing once it's written
+func (l *LayeredBlobStorage) written(blobId string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.missing, blobId)
+	l.writes++
+}
+
+// Mark the blob as recently used, returns false if it's not cached
+func (l *LayeredBlobStorage) touch(blobId string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	elem, ok := l.cached[blobId]
+	if ok {
+		l.lru.MoveToFront(elem)
+	}
+	return ok
+}
+
+// Remove the blob from the accounting, the cache storage is not touched
+func (l *LayeredBlobStorage) forget(blobId string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	elem, ok := l.cached[blobId]
+	if ok {
+		l.cachedBytes -= elem.Value.(*cachedBlob).size
+		l.lru.Remove(elem)
+		delete(l.cached, blobId)
+	}
+	return ok
+}
+
+// Remove the blob from the cache
+func (l *LayeredBlobStorage) uncache(blobId string) {
+	if l.forget(blobId) {
+		l.cache.Delete(blobId)
+	}
+}
+
+// Check whether the blob of given size may be cached
+func (l *LayeredBlobStorage) fits(size int64) bool {
+	return size <= l.maxCacheBytes
+}
+
+// Store the blob in the cache, least recently used blobs are evicted
+// to make room for it
+func (l *LayeredBlobStorage) cacheBlob(blobId string, data []byte) {
+	size := int64(len(data))
+	if !l.fits(size) {
+		return
+	}
+
+	writer, err := l.cache.NewBlobWriter(blobId)
+	if err != nil {
+		return
+	}
+	writer.Write(data)
+	if _, err = writer.Finalize(); err != nil {
+
+		// Outdated copy of a signed blob, let the next read refresh it
+		l.cache.Delete(blobId)
+		l.forget(blobId)
+		return
+	}
+
+	l.lock.Lock()
+	if elem, ok := l.cached[blobId]; ok {
+		l.cachedBytes -= elem.Value.(*cachedBlob).size
+		l.lru.Remove(elem)
+	}
+	l.cached[blobId] = l.lru.PushFront(&cachedBlob{bid: blobId, size: size})
+	l.cachedBytes += size
+
+	var evicted []string
+	for l.cachedBytes > l.maxCacheBytes {
+		blob := l.lru.Remove(l.lru.Back()).(*cachedBlob)
+		delete(l.cached, blob.bid)
+		l.cachedBytes -= blob.size
+		evicted = append(evicted, blob.bid)
+	}
+	l.lock.Unlock()
+
+	for _, bid := range evicted {
+		l.cache.Delete(bid)
+	}
+}
+
+// Reader of the remote blob collecting the data for the cache, the blob
+// is cached once it's read completely
+type cachingReader struct {
+	storage *LayeredBlobStorage
+	bid     string
+	reader  io.Reader
+	data    []byte
+	skip    bool // The blob is too large to be cached
+}
+
+func (c *cachingReader) Read(p []byte) (n int, err error) {
+	n, err = c.reader.Read(p)
+	if !c.skip && n > 0 {
+		if c.storage.fits(int64(len(c.data) + n)) {
+			c.data = append(c.data, p[:n]...)
+		} else {
+			c.skip, c.data = true, nil
+		}
+	}
+	if err == io.EOF && !c.skip {
+
+		// Corrupted remote copies are not cached when repairing
+		if !c.storage.ReadRepair || VerifyBlob(c.bid, bytes.NewReader(c.data)) == nil {
+			c.storage.cacheBlob(c.bid, c.data)
+		}
+		c.skip, c.data = true, nil
+	}
+	return
+}
+
+func (c *cachingReader) Close() error {
+	c.skip, c.data = true, nil
+	if closer, ok := c.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Writer storing the blob in the remote storage, the blob is put
+// into the cache once it's successfully written
+type layeredWriter struct {
+	storage *LayeredBlobStorage
+	bid     string
+	remote  WriteFinalizeCanceler
+	data    []byte
+	skip    bool // The blob is too large to be cached
+}
+
+func (w *layeredWriter) Write(p []byte) (n int, err error) {
+	if n, err = w.remote.Write(p); err != nil {
+		return
+	}
+	if !w.skip {
+		if w.storage.fits(int64(len(w.data) + n)) {
+			w.data = append(w.data, p[:n]...)
+		} else {
+			w.skip, w.data = true, nil
+		}
+	}
+	return
+}
+
+func (w *layeredWriter) Finalize() (duplicate bool, err error) {
+	if duplicate, err = w.remote.Finalize(); err != nil {
+		return false, err
+	}
+	w.storage.written(w.bid)
+	if w.skip {
+
+		// The cached copy, if any, might have been replaced
+		w.storage.uncache(w.bid)
+	} else {
+		w.storage.cacheBlob(w.bid, w.data)
+	}
+	w.data = nil
+	return duplicate, nil
+}
+
+func (w *layeredWriter) Cancel() error {
+	w.data = nil
+	return w.remote.Cancel()
+}
+
+func (w *layeredWriter) Close() error {
+	w.data = nil
+	return w.remote.Close()
+}
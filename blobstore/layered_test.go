@@ -0,0 +1,149 @@
+package blobstore
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cinode/golib/utils"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLayeredBlobStorage(t *testing.T) {
+
+	cache := NewMemoryBlobStorage()
+	remote := NewAccessTracker(NewMemoryBlobStorage())
+	layered := NewLayeredBlobStorage(cache, remote, 300)
+
+	readBlob := func(bid string) []byte {
+		reader, err := layered.NewBlobReader(bid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	isCached := func(bid string) bool {
+		exists, _ := cache.Exists(bid)
+		return exists
+	}
+
+	// Blobs of 100 bytes each, only three fit in the cache
+	bids := make([]string, 4)
+	for i := range bids {
+		bids[i] = fmt.Sprintf("blob%d", i)
+		putBlob(remote, bids[i], bytes.Repeat([]byte{byte(i)}, 100))
+	}
+
+	// Read from the remote storage populates the cache
+	for i := 0; i < 3; i++ {
+		if data := readBlob(bids[i]); !bytes.Equal(data, bytes.Repeat([]byte{byte(i)}, 100)) {
+			t.Fatalf("Invalid content of blob %v", i)
+		}
+	}
+	remote.Reset()
+	readBlob(bids[0])
+	if stats, _ := remote.Stats(bids[0]); stats.Reads != 0 {
+		t.Fatal("Cached blob read from the remote storage")
+	}
+	if layered.CachedBytes() != 300 {
+		t.Fatalf("Invalid size of cached blobs: %v", layered.CachedBytes())
+	}
+
+	// Least recently used blob is evicted
+	readBlob(bids[3])
+	if isCached(bids[1]) || !isCached(bids[0]) || !isCached(bids[2]) || !isCached(bids[3]) {
+		t.Fatal("Invalid blob evicted from the cache")
+	}
+	if layered.CachedBytes() != 300 {
+		t.Fatalf("Invalid size of cached blobs: %v", layered.CachedBytes())
+	}
+
+	// Writes go to both storages, blobs larger than the cache are not cached
+	putBlob(layered, "written", []byte("data"))
+	putBlob(layered, "large", make([]byte, 400))
+	if exists, _ := remote.Exists("written"); !exists || !isCached("written") {
+		t.Fatal("Written blob not stored in both storages")
+	}
+	if exists, _ := remote.Exists("large"); !exists || isCached("large") {
+		t.Fatal("Large blob stored incorrectly")
+	}
+	if data := readBlob("large"); len(data) != 400 || isCached("large") {
+		t.Fatal("Large blob read incorrectly")
+	}
+
+	if err := layered.Delete("written"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := layered.Exists("written"); exists || isCached("written") {
+		t.Fatal("Deleted blob still exists")
+	}
+	if _, err := layered.NewBlobReader("missing"); err != ErrBIDNotFound {
+		t.Fatalf("Invalid error for missing blob: %v", err)
+	}
+}
+
+// Storage counting lookups of blobs
+type lookupCounter struct {
+	BlobStorage
+	lookups int
+}
+
+func (l *lookupCounter) NewBlobReader(blobId string) (io.Reader, error) {
+	l.lookups++
+	return l.BlobStorage.NewBlobReader(blobId)
+}
+
+func (l *lookupCounter) Exists(blobId string) (bool, error) {
+	l.lookups++
+	return l.BlobStorage.Exists(blobId)
+}
+
+func TestLayeredNegativeCache(t *testing.T) {
+
+	backend := NewMemoryBlobStorage()
+	remote := &lookupCounter{BlobStorage: backend}
+	layered := NewLayeredBlobStorage(NewMemoryBlobStorage(), remote, 300)
+	clock := utils.NewManualClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	layered.Clock = clock
+	layered.NegativeTTL = time.Minute
+
+	for i := 0; i < 3; i++ {
+		if exists, _ := layered.Exists("missing"); exists {
+			t.Fatal("Missing blob reported as existing")
+		}
+		if _, err := layered.NewBlobReader("missing"); err != ErrBIDNotFound {
+			t.Fatalf("Invalid error for missing blob: %v", err)
+		}
+	}
+	if remote.lookups != 1 {
+		t.Fatalf("Missing blob looked up %v times", remote.lookups)
+	}
+
+	// Writes are visible immediately
+	putBlob(layered, "missing", []byte("data"))
+	if exists, _ := layered.Exists("missing"); !exists {
+		t.Fatal("Written blob not found")
+	}
+
+	// Deleted blobs are remembered as missing
+	remote.lookups = 0
+	layered.Delete("missing")
+	if exists, _ := layered.Exists("missing"); exists || remote.lookups != 0 {
+		t.Fatalf("Deleted blob not remembered: %v, %v lookups", exists, remote.lookups)
+	}
+
+	// Blobs written directly to the remote storage are seen once entries expire
+	putBlob(backend, "missing", []byte("data"))
+	if exists, _ := layered.Exists("missing"); exists {
+		t.Fatal("Entry of missing blob expired too early")
+	}
+	clock.Advance(time.Minute)
+	if exists, _ := layered.Exists("missing"); !exists {
+		t.Fatal("Entry of missing blob not expired")
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidLimits = errors.New("Invalid resource limits")
+)
+
+// Limits of resources used by the library
+type Limits struct {
+
+	// Encrypted data kept in memory while a blob is created for storages
+	// which need the blob id upfront, more data is moved to a temporary file
+	SpillThreshold int
+
+	// Size of the buffer used to read streams
+	StreamBufferSize int
+
+	// Use content-defined chunking for files stored by the library,
+	// fixed-size partial blobs need 16MiB of memory each
+	ContentDefined bool
+
+	// Minimum and maximum sizes of content-defined chunks, boundaries are
+	// placed where the rolling hash has CDCMaskBits lowest bits cleared
+	// thus the average chunk size is around 2^CDCMaskBits bytes
+	CDCMinSize, CDCMaxSize int
+	CDCMaskBits            uint
+}
+
+// Limits used by default
+var DefaultLimits = Limits{
+	SpillThreshold:   1024 * 1024,
+	StreamBufferSize: 64 * 1024,
+	ContentDefined:   false,
+	CDCMinSize:       512 * 1024,
+	CDCMaxSize:       8 * 1024 * 1024,
+	CDCMaskBits:      21,
+}
+
+// Limits for devices with little memory such as routers
+// or single-board computers
+var ConstrainedLimits = Limits{
+	SpillThreshold:   64 * 1024,
+	StreamBufferSize: 16 * 1024,
+	ContentDefined:   true,
+	CDCMinSize:       64 * 1024,
+	CDCMaxSize:       1024 * 1024,
+	CDCMaskBits:      18,
+}
+
+// Check whether the limits can be used by writers
+func (l *Limits) validate() error {
+	if l.SpillThreshold < 0 || l.StreamBufferSize <= 0 ||
+		l.CDCMinSize <= cdcWindow || l.CDCMaxSize < l.CDCMinSize ||
+		l.CDCMaxSize > maxSimpleFileDataSize || l.CDCMaskBits < 1 || l.CDCMaskBits > 31 {
+		return ErrInvalidLimits
+	}
+	return nil
+}
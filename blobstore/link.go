@@ -0,0 +1,107 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"io"
+)
+
+// Maximum number of links followed by ResolveLinks
+const MaxLinkDepth = 16
+
+// Link to another blob. Links add a level of indirection: directory entries
+// and signed blobs can point to the link while the blob it points to is
+// described by the link, i.e. a signed blob pointing to a link can later be
+// repointed to another link without changing its blob id. Links are
+// hash-validated blobs, the target can only be read with the key of the link.
+type Link struct {
+	Target   BlobReference // Blob the link points to
+	MimeType string        // MIME type of the target, empty if not known
+	Name     string        // Name of the target, i.e. the file name, optional
+}
+
+// Store the link blob
+func CreateLinkBlob(link Link, storage BlobStorage) (BlobReference, error) {
+	switch {
+	case link.Target.Bid == "":
+		return BlobReference{}, ErrMalformedLinkTarget
+	case len(link.MimeType) > maxSaneMimeTypeLength, len(link.Name) > maxSaneNameLenght:
+		return BlobReference{}, ErrInvalidLink
+	}
+
+	var buffer bytes.Buffer
+	serializeString(link.Target.Bid, &buffer)
+	serializeString(link.Target.Key, &buffer)
+	serializeString(link.MimeType, &buffer)
+	serializeString(link.Name, &buffer)
+
+	bid, key, err := CreateTypedBlob(blobTypeLink, buffer.Bytes(), storage)
+	return BlobReference{Bid: bid, Key: key}, err
+}
+
+// Read the link blob
+func OpenLinkBlob(bid, key string, storage BlobStorage) (*Link, error) {
+	reader := baseBlobReader{storage: storage}
+	content, blobType, err := reader.openInternal(bid, key, validationMethodHash)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader(content)
+	if blobType != blobTypeLink {
+		return nil, ErrInvalidLinkBlobType
+	}
+	return readLinkData(content)
+}
+
+// Follow links starting at the reference until the blob which is not a link
+// is found, the reference is returned as it is if it's not a link. The last
+// link followed is returned along with its target, nil if there was none.
+// ErrTooManyLinks is returned if there are more than MaxLinkDepth links.
+func ResolveLinks(ref BlobReference, storage BlobStorage) (BlobReference, *Link, error) {
+	var last *Link
+	for depth := 0; ; depth++ {
+		info, err := InspectBlobWithKey(ref.Bid, ref.Key, storage)
+		if err != nil {
+			return BlobReference{}, nil, err
+		}
+		if !info.IsLink() {
+			return ref, last, nil
+		}
+		if depth == MaxLinkDepth {
+			return BlobReference{}, nil, ErrTooManyLinks
+		}
+		if last, err = OpenLinkBlob(ref.Bid, ref.Key, storage); err != nil {
+			return BlobReference{}, nil, err
+		}
+		ref = last.Target
+	}
+}
+
+// Read the content of the link blob, the reader must
+// be positioned right after the blob type
+func readLinkData(content io.Reader) (link *Link, err error) {
+	link = &Link{}
+	if link.Target.Bid, err = deserializeString(content, maxSaneBidLength); err != nil {
+		return nil, err
+	}
+	if link.Target.Bid == "" {
+		return nil, ErrMalformedLinkTarget
+	}
+	if link.Target.Key, err = deserializeString(content, maxSaneKeyLength); err != nil {
+		return nil, err
+	}
+	if link.MimeType, err = deserializeString(content, maxSaneMimeTypeLength); err != nil {
+		return nil, err
+	}
+	if link.Name, err = deserializeString(content, maxSaneNameLenght); err != nil {
+		return nil, err
+	}
+
+	if err = checkEOF(content, ErrMalformedLinkExtraData); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
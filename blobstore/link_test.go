@@ -0,0 +1,110 @@
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	file, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := Link{Target: file.BlobReference, MimeType: "text/plain", Name: "hello.txt"}
+	ref, err := CreateLinkBlob(link, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := OpenLinkBlob(ref.Bid, ref.Key, storage)
+	if err != nil || !reflect.DeepEqual(*read, link) {
+		t.Fatalf("Invalid link read: %+v %v", read, err)
+	}
+
+	refs, err := GetBlobReferences(ref.Bid, ref.Key, storage)
+	if err != nil || !reflect.DeepEqual(refs, []BlobReference{file.BlobReference}) {
+		t.Fatalf("Invalid references of the link: %v %v", refs, err)
+	}
+	if err = ValidateBlob(ref.Bid, ref.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = StrictDecodeBlob(ref.Bid, ref.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := InspectBlobWithKey(ref.Bid, ref.Key, storage); !info.IsLink() || info.IsFile() {
+		t.Fatalf("Invalid information about the link: %+v", info)
+	}
+
+	// Chains of links are followed up to the limit
+	chain := ref
+	for i := 0; i < MaxLinkDepth-1; i++ {
+		if chain, err = CreateLinkBlob(Link{Target: chain}, storage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target, last, err := ResolveLinks(chain, storage)
+	if err != nil || target != file.BlobReference || !reflect.DeepEqual(*last, link) {
+		t.Fatalf("Invalid resolved link: %v %+v %v", target, last, err)
+	}
+	if target, last, err = ResolveLinks(file.BlobReference, storage); err != nil || target != file.BlobReference || last != nil {
+		t.Fatalf("Reference which is not a link must be returned as it is: %v %+v %v", target, last, err)
+	}
+	if chain, err = CreateLinkBlob(Link{Target: chain}, storage); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ResolveLinks(chain, storage); err != ErrTooManyLinks {
+		t.Fatalf("Invalid error for too long chain of links: %v", err)
+	}
+
+	if _, err = OpenLinkBlob(file.Bid, file.Key, storage); err != ErrInvalidLinkBlobType {
+		t.Fatalf("Invalid error for file blob: %v", err)
+	}
+	if _, err = CreateLinkBlob(Link{}, storage); err != ErrMalformedLinkTarget {
+		t.Fatalf("Invalid error for link without the target: %v", err)
+	}
+	if _, err = CreateLinkBlob(Link{Target: file.BlobReference, MimeType: string(make([]byte, maxSaneMimeTypeLength+1))}, storage); err != ErrInvalidLink {
+		t.Fatalf("Invalid error for too long MIME type: %v", err)
+	}
+}
+
+func TestMalformedLink(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	build := func(target string, extra string) BlobReference {
+		var b bytes.Buffer
+		serializeString(target, &b)
+		serializeString("key", &b)
+		serializeString("", &b)
+		serializeString("name", &b)
+		b.WriteString(extra)
+		bid, key, err := CreateTypedBlob(BlobTypeLink, b.Bytes(), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return BlobReference{Bid: bid, Key: key}
+	}
+
+	for _, d := range []struct {
+		ref BlobReference
+		err error
+	}{
+		{build("", ""), ErrMalformedLinkTarget},
+		{build("bid", "x"), ErrMalformedLinkExtraData},
+	} {
+		if _, err := OpenLinkBlob(d.ref.Bid, d.ref.Key, storage); err != d.err {
+			t.Fatalf("Invalid error reading malformed link: %v, expected %v", err, d.err)
+		}
+		if err := ValidateBlob(d.ref.Bid, d.ref.Key, storage); !errors.Is(err, d.err) {
+			t.Fatalf("Invalid error validating malformed link: %v, expected %v", err, d.err)
+		}
+		if _, err := StrictDecodeBlob(d.ref.Bid, d.ref.Key, storage); !errors.Is(err, d.err) {
+			t.Fatalf("Invalid error decoding malformed link: %v, expected %v", err, d.err)
+		}
+	}
+}
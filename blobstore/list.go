@@ -0,0 +1,173 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrListNotSupported = errors.New("Blob storage does not support listing blobs")
+)
+
+// Blob kept in the storage
+type StoredBlob struct {
+	Bid  string
+	Size int64
+}
+
+// Optional interface of the blob storage that can enumerate its blobs
+type Lister interface {
+
+	// Get the page of blobs with ids starting with the prefix, sorted
+	// by the blob id. Only blobs with ids greater than the cursor are
+	// returned, at most limit of them if it is positive. The returned
+	// cursor continues the listing, it is empty after the last page.
+	ListBlobs(prefix, cursor string, limit int) (blobs []StoredBlob, next string, err error)
+}
+
+// Number of blobs fetched at once by WalkBlobs
+const walkPageSize = 1000
+
+// List the page of blobs of the storage if it does implement Lister
+func ListBlobs(storage BlobStorage, prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	if lister, ok := storage.(Lister); ok {
+		return lister.ListBlobs(prefix, cursor, limit)
+	}
+	return nil, "", ErrListNotSupported
+}
+
+// Call fn for every blob with the id starting with the prefix, blobs are
+// fetched page by page in the order of their ids. Iteration stops at the
+// first error returned by fn.
+func WalkBlobs(storage BlobStorage, prefix string, fn func(StoredBlob) error) error {
+	for cursor := ""; ; {
+		blobs, next, err := ListBlobs(storage, prefix, cursor, walkPageSize)
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			if err = fn(blob); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Get all blobs of the storage, sorted by the blob id
+func ListAllBlobs(storage BlobStorage) ([]StoredBlob, error) {
+	var blobs []StoredBlob
+	err := WalkBlobs(storage, "", func(blob StoredBlob) error {
+		blobs = append(blobs, blob)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// Check whether the blob id belongs to the requested page
+func listMatches(bid, prefix, cursor string) bool {
+	return strings.HasPrefix(bid, prefix) && bid > cursor
+}
+
+// Cut sorted blobs to the page limit, the cursor of the next page is
+// returned if anything was left out
+func listPage(blobs []StoredBlob, limit int) ([]StoredBlob, string) {
+	if limit <= 0 || len(blobs) <= limit {
+		return blobs, ""
+	}
+	blobs = blobs[:limit]
+	return blobs, blobs[limit-1].Bid
+}
+
+// Sort helper for stored blobs
+type storedBlobsByBid []StoredBlob
+
+func (s storedBlobsByBid) Len() int {
+	return len(s)
+}
+
+func (s storedBlobsByBid) Less(i, j int) bool {
+	return s[i].Bid < s[j].Bid
+}
+
+func (s storedBlobsByBid) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s *memoryBlobStorage) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var blobs []StoredBlob
+	for bid, blob := range s.blobs {
+		if bidStr := bidString(bid); listMatches(bidStr, prefix, cursor) {
+			blobs = append(blobs, StoredBlob{Bid: bidStr, Size: int64(len(blob))})
+		}
+	}
+	for bid, blob := range s.other {
+		if listMatches(bid, prefix, cursor) {
+			blobs = append(blobs, StoredBlob{Bid: bid, Size: int64(len(blob))})
+		}
+	}
+	sort.Sort(storedBlobsByBid(blobs))
+	blobs, next := listPage(blobs, limit)
+	return blobs, next, nil
+}
+
+func (s *fileBlobStorage) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	dir, err := os.Open(s.path)
+	if err != nil {
+		return nil, "", err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Only names are sorted, files are inspected up to the page limit
+	matching := names[:0]
+	for _, name := range names {
+		if !internalFileName(name) && listMatches(name, prefix, cursor) {
+			matching = append(matching, name)
+		}
+	}
+	sort.Strings(matching)
+
+	var blobs []StoredBlob
+	for _, name := range matching {
+		if limit > 0 && len(blobs) == limit {
+			return blobs, blobs[limit-1].Bid, nil
+		}
+		info, err := os.Stat(filepath.Join(s.path, name))
+		if os.IsNotExist(err) {
+			// Deleted while listing
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if info.IsDir() {
+			continue
+		}
+		blobs = append(blobs, StoredBlob{Bid: name, Size: info.Size()})
+	}
+	return blobs, "", nil
+}
+
+func (a *AccessTracker) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	return ListBlobs(a.BlobStorage, prefix, cursor, limit)
+}
@@ -0,0 +1,158 @@
+package blobstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Key-value store kept in a map
+type mapKeyValueStore struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func (m *mapKeyValueStore) Get(key []byte) ([]byte, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	value, found := m.values[string(key)]
+	return value, found, nil
+}
+
+func (m *mapKeyValueStore) Put(key, value []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.values == nil {
+		m.values = make(map[string][]byte)
+	}
+	m.values[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *mapKeyValueStore) Delete(key []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.values, string(key))
+	return nil
+}
+
+func (m *mapKeyValueStore) Scan(start []byte, fn func(key []byte, size int64) bool) error {
+	m.lock.Lock()
+	var keys []string
+	sizes := make(map[string]int64)
+	for key, value := range m.values {
+		if key >= string(start) {
+			keys = append(keys, key)
+			sizes[key] = int64(len(value))
+		}
+	}
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn([]byte(key), sizes[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestListBlobs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	canonical := "82aeef202165cf11930ea44a9ad8337aea355d63751a7260552e3e014ad6313bca69c83fa4e3555531d44a1025708183784af0e2002562b7260559ce0e7af262"
+
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+		NewAccessTracker(NewMemoryBlobStorage()),
+		NewKeyValueBlobStorage(&mapKeyValueStore{}),
+	} {
+		putBlob(storage, canonical, []byte("abc"))
+		putBlob(storage, "f0", []byte("abcde"))
+
+		// Blobs being written are not listed
+		writer, _ := storage.NewBlobWriter("0d")
+		writer.Write([]byte("data"))
+
+		blobs, err := ListAllBlobs(storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blobs) != 2 ||
+			blobs[0] != (StoredBlob{Bid: canonical, Size: 3}) ||
+			blobs[1] != (StoredBlob{Bid: "f0", Size: 5}) {
+			t.Fatalf("Invalid list of blobs: %v", blobs)
+		}
+		writer.Cancel()
+	}
+
+	if _, _, err = ListBlobs(struct{ BlobStorage }{NewMemoryBlobStorage()}, "", "", 0); err != ErrListNotSupported {
+		t.Fatalf("Invalid error for storage without listing: %v", err)
+	}
+}
+
+func TestListBlobsPages(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+		NewKeyValueBlobStorage(&mapKeyValueStore{}),
+	} {
+		for _, bid := range []string{"b3", "a1", "b1", "c1", "b2"} {
+			putBlob(storage, bid, []byte(bid))
+		}
+
+		// Pages of the prefix are consecutive
+		var bids []string
+		for cursor, pages := "", 0; ; pages++ {
+			blobs, next, err := ListBlobs(storage, "b", cursor, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, blob := range blobs {
+				bids = append(bids, blob.Bid)
+			}
+			if next == "" {
+				if pages != 1 {
+					t.Fatalf("Invalid number of pages: %v", pages+1)
+				}
+				break
+			}
+			cursor = next
+		}
+		if strings.Join(bids, ",") != "b1,b2,b3" {
+			t.Fatalf("Invalid listing of the prefix: %v", bids)
+		}
+
+		// Cursor does not need to be an existing blob id
+		blobs, next, err := ListBlobs(storage, "", "b25", 0)
+		if err != nil || next != "" || len(blobs) != 2 || blobs[0].Bid != "b3" || blobs[1].Bid != "c1" {
+			t.Fatalf("Invalid listing after the cursor: %v, %v, %v", blobs, next, err)
+		}
+
+		// Walking stops at the first error
+		errStop, visited := errors.New("stop"), 0
+		err = WalkBlobs(storage, "", func(blob StoredBlob) error {
+			visited++
+			return errStop
+		})
+		if err != errStop || visited != 1 {
+			t.Fatalf("Walking not stopped: %v, %v", visited, err)
+		}
+	}
+}
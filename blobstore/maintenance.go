@@ -0,0 +1,106 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	ErrReadOnlyMaintenance = errors.New("Blob storage is in maintenance mode, writes are rejected")
+)
+
+// MaintenanceStorage can temporarily reject writes and deletions of the
+// wrapped storage while still serving reads, so that compaction, migration
+// or backups can work with the quiescent storage.
+type MaintenanceStorage struct {
+	BlobStorage
+
+	lock        sync.RWMutex // Held for reading by writes in progress
+	maintenance bool
+}
+
+// Get the wrapped storage
+func (m *MaintenanceStorage) Unwrap() BlobStorage {
+	return m.BlobStorage
+}
+
+// Wrap the storage with the maintenance mode toggle, the mode is off
+func NewMaintenanceStorage(storage BlobStorage) *MaintenanceStorage {
+	return &MaintenanceStorage{BlobStorage: storage}
+}
+
+// Turn the maintenance mode on or off. Turning it on waits for blobs being
+// finalized and deleted at the moment, once it returns the storage is not
+// modified until the mode is turned off. Blob writers created before are
+// cancelled on finalization.
+func (m *MaintenanceStorage) SetMaintenance(on bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.maintenance = on
+}
+
+// Check whether the storage is in the maintenance mode
+func (m *MaintenanceStorage) InMaintenance() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.maintenance
+}
+
+func (m *MaintenanceStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	if m.InMaintenance() {
+		return nil, ErrReadOnlyMaintenance
+	}
+	writer, err := m.BlobStorage.NewBlobWriter(blobId)
+	if err != nil {
+		return nil, err
+	}
+	return &maintenanceWriter{WriteFinalizeCanceler: writer, storage: m}, nil
+}
+
+func (m *MaintenanceStorage) Delete(blobId string) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.maintenance {
+		return ErrReadOnlyMaintenance
+	}
+	return m.BlobStorage.Delete(blobId)
+}
+
+func (m *MaintenanceStorage) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	return ListBlobs(m.BlobStorage, prefix, cursor, limit)
+}
+
+func (m *MaintenanceStorage) ExistsBatch(blobIds []string) (map[string]bool, error) {
+	return ExistsBatch(m.BlobStorage, blobIds)
+}
+
+func (m *MaintenanceStorage) Stat(blobId string) (BlobStat, error) {
+	return Stat(m.BlobStorage, blobId)
+}
+
+func (m *MaintenanceStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	return NewBlobReaderRange(m.BlobStorage, blobId, offset, length)
+}
+
+// Writer finalizing blobs only outside of the maintenance mode
+type maintenanceWriter struct {
+	WriteFinalizeCanceler
+	storage *MaintenanceStorage
+}
+
+func (w *maintenanceWriter) Finalize() (duplicate bool, err error) {
+	w.storage.lock.RLock()
+	defer w.storage.lock.RUnlock()
+
+	if w.storage.maintenance {
+		w.WriteFinalizeCanceler.Cancel()
+		return false, ErrReadOnlyMaintenance
+	}
+	return w.WriteFinalizeCanceler.Finalize()
+}
@@ -0,0 +1,54 @@
+package blobstore
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMaintenanceStorage(t *testing.T) {
+
+	storage := NewMaintenanceStorage(NewMemoryBlobStorage())
+	putBlob(storage, "bid", []byte("data"))
+
+	pending, err := storage.NewBlobWriter("pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending.Write([]byte("data"))
+
+	storage.SetMaintenance(true)
+	if !storage.InMaintenance() {
+		t.Fatal("Maintenance mode not turned on")
+	}
+
+	// Reads are still served
+	reader, err := storage.NewBlobReader("bid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(reader); string(data) != "data" {
+		t.Fatalf("Invalid blob content: %q", data)
+	}
+	if blobs, err := ListAllBlobs(storage); err != nil || len(blobs) != 1 {
+		t.Fatalf("Invalid listing in maintenance mode: %v, %v", blobs, err)
+	}
+
+	if _, err = storage.NewBlobWriter("other"); err != ErrReadOnlyMaintenance {
+		t.Fatalf("Invalid error of write in maintenance mode: %v", err)
+	}
+	if _, err = pending.Finalize(); err != ErrReadOnlyMaintenance {
+		t.Fatalf("Invalid error of finalize in maintenance mode: %v", err)
+	}
+	if err = storage.Delete("bid"); err != ErrReadOnlyMaintenance {
+		t.Fatalf("Invalid error of delete in maintenance mode: %v", err)
+	}
+	if exists, _ := storage.Exists("pending"); exists {
+		t.Fatal("Blob finalized in maintenance mode")
+	}
+
+	storage.SetMaintenance(false)
+	putBlob(storage, "other", []byte("data"))
+	if err = storage.Delete("bid"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Write the content of the directory blob to the local path, the path
+// is created if it does not exist and existing files are overwritten.
+// The progress function, if not nil, is called after each file is written
+// with its local path and size. Permission bits and modification times
+// are restored if the entries record them, otherwise files are created
+// with default permissions and the current time. Entries and blobs of kinds
+// unknown to this version of the library are skipped.
+func MaterializeDirectory(bid, key string, storage BlobStorage, path string, progress func(path string, size int64)) error {
+	return materializeDirectory(bid, key, storage, path, &materializer{fileDone: progress})
+}
+
+// Options of writing directory blobs to local paths
+type MaterializeOptions struct {
+
+	// Called with the number of bytes of files written so far and the local
+	// path of the file being written, nil if not needed. The total is not
+	// known before the whole directory is written.
+	Progress ProgressFunc
+
+	// Minimum time between calls of the progress function,
+	// DefaultProgressInterval if 0
+	ProgressInterval time.Duration
+}
+
+// Write the content of the directory blob to the local path the way
+// requested by options, see MaterializeDirectory
+func MaterializeDirectoryWithOptions(bid, key string, storage BlobStorage, path string, options MaterializeOptions) error {
+	m := &materializer{progress: NewProgressCounter(options.Progress, options.ProgressInterval, nil, BlobInfoUnknown)}
+	if err := materializeDirectory(bid, key, storage, path, m); err != nil {
+		return err
+	}
+	m.progress.Finish()
+	return nil
+}
+
+// Reporting of the progress of materialization
+type materializer struct {
+	fileDone func(path string, size int64) // Called after each file
+	progress *ProgressCounter              // Bytes of files written
+}
+
+func materializeDirectory(bid, key string, storage BlobStorage, path string, m *materializer) error {
+
+	if err := os.MkdirAll(path, 0777); err != nil {
+		return err
+	}
+
+	reader, err := OpenDirBlob(bid, key, storage)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	entries, err := reader.Entries()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+
+		// Names come from the blob, they must not escape the target directory
+		if entry.Name == "" || entry.Name == "." || entry.Name == ".." ||
+			strings.ContainsAny(entry.Name, "/\\\x00") {
+			return ErrInvalidEntryName
+		}
+		entryPath := filepath.Join(path, entry.Name)
+
+		switch entry.Type {
+		case EntryTypeBlob:
+		case EntryTypeSymlink:
+			if err = materializeSymlink(entry.Target, entryPath); err != nil {
+				return err
+			}
+			continue
+		default:
+			// Entry kinds unknown to this version can't be materialized
+			continue
+		}
+
+		info, err := InspectBlobWithKey(entry.Bid, entry.Key, storage)
+		if err != nil {
+			return err
+		}
+
+		// Blobs of formats introduced later can't be materialized
+		if !info.IsDir() && !info.IsFile() {
+			continue
+		}
+
+		if info.IsDir() {
+			err = materializeDirectory(entry.Bid, entry.Key, storage, entryPath, m)
+		} else {
+			err = materializeFile(entry.Bid, entry.Key, storage, entryPath, m)
+		}
+		if err != nil {
+			return err
+		}
+		if err = restoreMetadata(entryPath, &entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply permission bits and the modification time recorded in the entry,
+// directories must be restored after their content
+func restoreMetadata(path string, entry *DirEntry) error {
+	if entry.Mode != 0 {
+		if err := os.Chmod(path, entry.Mode); err != nil {
+			return err
+		}
+	}
+	if !entry.ModTime.IsZero() {
+		if err := os.Chtimes(path, entry.ModTime, entry.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func materializeSymlink(target, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, path)
+}
+
+func materializeFile(bid, key string, storage BlobStorage, path string, m *materializer) error {
+
+	reader, err := OpenFileBlob(bid, key, storage)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	var writer io.Writer = file
+	if m.progress != nil {
+		writer = &progressWriter{writer: file, counter: m.progress, item: path}
+	}
+	size, err := io.Copy(writer, reader)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if m.fileDone != nil {
+		m.fileDone(path, size)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package blobstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaterializeDirectory(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-materialize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(filepath.Join(source, "sub", "empty"), 0777)
+	ioutil.WriteFile(filepath.Join(source, "hello.txt"), []byte("Hello World!"), 0666)
+	ioutil.WriteFile(filepath.Join(source, "sub", "data.bin"), []byte{1, 2, 3}, 0666)
+
+	storage := NewMemoryBlobStorage()
+	bid, key, err := UploadDirectory(source, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "target")
+	written := make(map[string]int64)
+	err = MaterializeDirectory(bid, key, storage, target, func(path string, size int64) {
+		written[path] = size
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{
+		"hello.txt":                      []byte("Hello World!"),
+		filepath.Join("sub", "data.bin"): {1, 2, 3},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(target, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Fatalf("Invalid content of %v", name)
+		}
+		if written[filepath.Join(target, name)] != int64(len(content)) {
+			t.Fatalf("Invalid progress reported for %v", name)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(target, "sub", "empty")); err != nil || !info.IsDir() {
+		t.Fatal("Empty directory not created")
+	}
+
+	// Entries must not escape the target directory
+	dw := DirBlobWriter{Storage: storage}
+	dw.AddEntry(DirEntry{Name: "..", Bid: bid, Key: key})
+	evilRef, _ := dw.Finalize()
+	evilBid, evilKey := evilRef.Bid, evilRef.Key
+	if err = MaterializeDirectory(evilBid, evilKey, storage, target, nil); err != ErrInvalidEntryName {
+		t.Fatalf("Invalid error for malicious entry name: %v", err)
+	}
+}
+
+func TestMaterializeMetadata(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-materialize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Unix(1400000000, 123456789)
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(filepath.Join(source, "sub"), 0777)
+	ioutil.WriteFile(filepath.Join(source, "sub", "script.sh"), []byte("#!/bin/sh"), 0666)
+	os.Chmod(filepath.Join(source, "sub", "script.sh"), 0750)
+	os.Chtimes(filepath.Join(source, "sub", "script.sh"), mtime, mtime)
+	os.Chtimes(filepath.Join(source, "sub"), mtime.Add(time.Hour), mtime.Add(time.Hour))
+
+	storage := NewMemoryBlobStorage()
+	bid, key, err := UploadDirectoryWithOptions(source, storage, UploadOptions{Metadata: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plainBid, _, _ := UploadDirectory(source, storage); plainBid == bid {
+		t.Fatal("Metadata not recorded")
+	}
+
+	target := filepath.Join(dir, "target")
+	if err = MaterializeDirectory(bid, key, storage, target, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}{
+		{filepath.Join(target, "sub", "script.sh"), 0750, mtime},
+		{filepath.Join(target, "sub"), 0777 &^ umask(t, dir), mtime.Add(time.Hour)},
+	} {
+		info, err := os.Stat(d.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != d.mode || !info.ModTime().Equal(d.mtime) {
+			t.Fatalf("Invalid metadata of %v: %v %v, expected %v %v", d.path, info.Mode().Perm(), info.ModTime(), d.mode, d.mtime)
+		}
+	}
+}
+
+// Find permission bits cleared by the umask
+func umask(t *testing.T, dir string) os.FileMode {
+	path := filepath.Join(dir, "umask")
+	if err := os.Mkdir(path, 0777); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0777 &^ info.Mode().Perm()
+}
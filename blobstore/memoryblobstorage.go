@@ -4,42 +4,112 @@
 
 package blobstore
 
-// TODO: Support for duplicates (let write the blob with same id as long as the content does match)
-
 import (
 	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
 	"io"
+	"os"
+	"sync"
 )
 
 func NewMemoryBlobStorage() BlobStorage {
 	return &memoryBlobStorage{
-		blobs: make(map[string][]byte)}
+		blobs: make(map[memoryBid][]byte),
+		other: make(map[string][]byte)}
+}
+
+// Create memory blob storage which does share the memory between blobs
+// with equal content. This is useful when the same content is stored
+// under many blob ids, i.e. in caches. The cost is one hash calculation
+// per stored blob.
+func NewInterningMemoryBlobStorage() BlobStorage {
+	return &memoryBlobStorage{
+		blobs:    make(map[memoryBid][]byte),
+		other:    make(map[string][]byte),
+		interned: make(map[memoryBid][]byte),
+		refs:     make(map[memoryBid]int)}
+}
+
+// Binary form of the blob id, blob ids are hex-encoded SHA-512 hashes thus
+// keeping them in binary form halves the memory used by the map keys
+type memoryBid [sha512.Size]byte
+
+// Convert the blob id into binary form, this is only possible if the id
+// is in canonical form - lowercase hex string of the proper length
+func parseMemoryBid(blobId string) (bid memoryBid, ok bool) {
+	if len(blobId) != 2*len(bid) {
+		return
+	}
+	if _, err := hex.Decode(bid[:], []byte(blobId)); err != nil {
+		return
+	}
+	if bidString(bid) != blobId {
+		return
+	}
+	return bid, true
+}
+
+// Convert the binary blob id back to its string form
+func bidString(bid memoryBid) string {
+	return hex.EncodeToString(bid[:])
 }
 
 type memoryBlobStorage struct {
-	blobs map[string][]byte
+	lock     sync.RWMutex
+	blobs    map[memoryBid][]byte // Blobs with canonical ids
+	other    map[string][]byte    // Blobs with non-canonical ids
+	interned map[memoryBid][]byte // Content of blobs by its hash, nil if interning is disabled
+	refs     map[memoryBid]int    // Number of blobs sharing the interned content
 }
 
 type memoryBlobWriter struct {
-	storage *memoryBlobStorage
+	storage *memoryBlobStorage // Nil once the writer is done
 	buffer  bytes.Buffer
 	bid     string
 }
 
 func (f *memoryBlobWriter) Write(p []byte) (n int, err error) {
+	if f.storage == nil {
+		return 0, os.ErrClosed
+	}
 	return f.buffer.Write(p)
 }
 
-func (f *memoryBlobWriter) Finalize() error {
-	previous, exists := f.storage.blobs[f.bid]
-	if exists {
-		if !bytes.Equal(previous, f.buffer.Bytes()) {
-			return ErrBIDCollision
-		}
+func (f *memoryBlobWriter) Finalize() (duplicate bool, err error) {
+	if f.storage == nil {
+		return false, os.ErrClosed
+	}
+	f.storage.lock.Lock()
+	defer f.storage.lock.Unlock()
+	defer func() { f.storage = nil }()
+
+	previous, exists := f.storage.lookup(f.bid)
+	if !exists {
+		f.storage.store(f.bid, f.buffer.Bytes())
+		return false, nil
+	}
+	if bytes.Equal(previous, f.buffer.Bytes()) {
+		return true, nil
+	}
+
+	// Only signed blobs can be updated, with newer versions, other
+	// blobs are only replaced if the stored copy is invalid
+	var replace bool
+	if len(previous) == 0 || previous[0] != validationMethodSign {
+		replace, err = replacesCollidingBlob(f.bid, bytes.NewReader(previous), bytes.NewReader(f.buffer.Bytes()))
 	} else {
-		f.storage.blobs[f.bid] = f.buffer.Bytes()
+		replace, err = shouldReplaceSignedBlob(f.bid, bytes.NewReader(previous), bytes.NewReader(f.buffer.Bytes()))
 	}
-	return nil
+	if err != nil {
+		return false, err
+	}
+	if !replace {
+		return true, nil
+	}
+	f.storage.remove(f.bid, previous)
+	f.storage.store(f.bid, f.buffer.Bytes())
+	return false, nil
 }
 
 func (f *memoryBlobWriter) Cancel() error {
@@ -48,6 +118,10 @@ func (f *memoryBlobWriter) Cancel() error {
 	return nil
 }
 
+func (f *memoryBlobWriter) Close() error {
+	return f.Cancel()
+}
+
 func (s *memoryBlobStorage) NewBlobWriter(blobId string) (writer WriteFinalizeCanceler, err error) {
 	return &memoryBlobWriter{
 			storage: s,
@@ -56,10 +130,132 @@ func (s *memoryBlobStorage) NewBlobWriter(blobId string) (writer WriteFinalizeCa
 }
 
 func (s *memoryBlobStorage) NewBlobReader(blobId string) (reader io.Reader, err error) {
-	blob, ok := s.blobs[blobId]
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	blob, ok := s.lookup(blobId)
 	if !ok {
 		return nil, ErrBIDNotFound
 	}
 
 	return bytes.NewReader(blob), nil
 }
+
+func (s *memoryBlobStorage) ValidationMethod(blobId string) (method int64, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	blob, ok := s.lookup(blobId)
+	if !ok {
+		return 0, ErrBIDNotFound
+	}
+
+	return deserializeInt(bytes.NewReader(blob))
+}
+
+func (s *memoryBlobStorage) Exists(blobId string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.lookup(blobId)
+	return ok, nil
+}
+
+// Blobs kept in memory have no modification time
+func (s *memoryBlobStorage) Stat(blobId string) (BlobStat, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	blob, ok := s.lookup(blobId)
+	if !ok {
+		return BlobStat{}, ErrBIDNotFound
+	}
+	return BlobStat{Size: int64(len(blob))}, nil
+}
+
+func (s *memoryBlobStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	if offset < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	blob, ok := s.lookup(blobId)
+	if !ok {
+		return nil, ErrBIDNotFound
+	}
+	if offset > int64(len(blob)) {
+		offset = int64(len(blob))
+	}
+	blob = blob[offset:]
+	if length >= 0 && length < int64(len(blob)) {
+		blob = blob[:length]
+	}
+	return bytes.NewReader(blob), nil
+}
+
+func (s *memoryBlobStorage) Delete(blobId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	blob, ok := s.lookup(blobId)
+	if !ok {
+		return ErrBIDNotFound
+	}
+	s.remove(blobId, blob)
+	return nil
+}
+
+// Remove the blob with given content, the lock must be held
+func (s *memoryBlobStorage) remove(blobId string, blob []byte) {
+	if bid, canonical := parseMemoryBid(blobId); canonical {
+		delete(s.blobs, bid)
+	} else {
+		delete(s.other, blobId)
+	}
+
+	// Release interned content no longer used by any blob
+	if s.interned != nil {
+		hash := memoryBid(sha512.Sum512(blob))
+		if s.refs[hash]--; s.refs[hash] == 0 {
+			delete(s.refs, hash)
+			delete(s.interned, hash)
+		}
+	}
+}
+
+// Find the content of the blob, the lock must be held
+func (s *memoryBlobStorage) lookup(blobId string) (blob []byte, ok bool) {
+	if bid, canonical := parseMemoryBid(blobId); canonical {
+		blob, ok = s.blobs[bid]
+	} else {
+		blob, ok = s.other[blobId]
+	}
+	return
+}
+
+// Save the content of the blob, the lock must be held
+func (s *memoryBlobStorage) store(blobId string, blob []byte) {
+
+	// Don't keep the spare capacity of the write buffer
+	if cap(blob) != len(blob) {
+		blob = append(make([]byte, 0, len(blob)), blob...)
+	}
+
+	if s.interned != nil {
+		hash := memoryBid(sha512.Sum512(blob))
+		if existing, ok := s.interned[hash]; ok {
+			blob = existing
+		} else {
+			s.interned[hash] = blob
+		}
+		s.refs[hash]++
+	}
+
+	if bid, canonical := parseMemoryBid(blobId); canonical {
+		s.blobs[bid] = blob
+	} else {
+		s.other[blobId] = blob
+	}
+}
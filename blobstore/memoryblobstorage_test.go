@@ -0,0 +1,162 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestMemoryBlobStorageBids(t *testing.T) {
+
+	for _, storage := range []BlobStorage{NewMemoryBlobStorage(), NewInterningMemoryBlobStorage()} {
+
+		canonical := hex.EncodeToString(make([]byte, sha512.Size))
+		upper := "AB" + canonical[2:]
+
+		for _, bid := range []string{canonical, upper, "short", ""} {
+			putBlob(storage, bid, []byte(bid))
+		}
+
+		for _, bid := range []string{canonical, upper, "short", ""} {
+			reader, err := storage.NewBlobReader(bid)
+			if err != nil {
+				t.Fatalf("Couldn't open blob %q: %v", bid, err)
+			}
+			if data, _ := ioutil.ReadAll(reader); string(data) != bid {
+				t.Fatalf("Invalid content of blob %q: %q", bid, data)
+			}
+		}
+
+		// Binary form must not hide different ids
+		if _, err := storage.NewBlobReader("ab" + canonical[2:]); err != ErrBIDNotFound {
+			t.Fatalf("Found blob which was never stored: %v", err)
+		}
+	}
+}
+
+func TestMemoryBlobWriterDone(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+
+	canceled, _ := storage.NewBlobWriter("a")
+	canceled.Write([]byte("a"))
+	canceled.Cancel()
+	if _, err := canceled.Write([]byte("a")); err != os.ErrClosed {
+		t.Fatalf("Invalid error of the write after cancel: %v", err)
+	}
+	if _, err := canceled.Finalize(); err != os.ErrClosed {
+		t.Fatalf("Invalid error of the finalization after cancel: %v", err)
+	}
+	if _, err := storage.NewBlobReader("a"); err != ErrBIDNotFound {
+		t.Fatalf("Canceled blob stored: %v", err)
+	}
+
+	finalized, _ := storage.NewBlobWriter("b")
+	finalized.Write([]byte("b"))
+	if _, err := finalized.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := finalized.Finalize(); err != os.ErrClosed {
+		t.Fatalf("Invalid error of the second finalization: %v", err)
+	}
+	finalized.Close()
+	if reader, err := storage.NewBlobReader("b"); err != nil {
+		t.Fatal(err)
+	} else if data, _ := ioutil.ReadAll(reader); string(data) != "b" {
+		t.Fatalf("Invalid content of the finalized blob: %q", data)
+	}
+}
+
+func TestMemoryBlobStorageInterning(t *testing.T) {
+
+	storage := NewInterningMemoryBlobStorage()
+	content := bytes.Repeat([]byte{0x01}, 1024)
+
+	putBlob(storage, "a", content)
+	putBlob(storage, "b", content)
+	putBlob(storage, "c", content[1:])
+
+	memory := storage.(*memoryBlobStorage)
+	a, _ := memory.lookup("a")
+	b, _ := memory.lookup("b")
+	c, _ := memory.lookup("c")
+
+	if &a[0] != &b[0] {
+		t.Fatal("Equal content was not interned")
+	}
+	if &a[0] == &c[0] {
+		t.Fatal("Different content was interned")
+	}
+}
+
+func benchmarkBids(n int) []string {
+	bids := make([]string, n)
+	for i := range bids {
+		hash := sha512.Sum512([]byte{byte(i), byte(i >> 8), byte(i >> 16), byte(i >> 24)})
+		bids[i] = hex.EncodeToString(hash[:])
+	}
+	return bids
+}
+
+func heapInUse() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// Memory used per small blob, compare with BenchmarkStringKeysFootprint
+func BenchmarkMemoryBlobStorageFootprint(b *testing.B) {
+	bids, content := benchmarkBids(b.N), []byte{0x01, 0xeb}
+	before := heapInUse()
+	b.ResetTimer()
+
+	storage := NewMemoryBlobStorage()
+	for _, bid := range bids {
+		putBlob(storage, bid, content)
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(int64(heapInUse())-int64(before))/float64(b.N), "heap-bytes/blob")
+	runtime.KeepAlive(storage)
+	runtime.KeepAlive(bids)
+}
+
+// Memory used per small blob in a map keyed by hex strings,
+// this is how the memory storage used to keep blobs
+func BenchmarkStringKeysFootprint(b *testing.B) {
+	bids, content := benchmarkBids(b.N), []byte{0x01, 0xeb}
+	before := heapInUse()
+	b.ResetTimer()
+
+	storage := make(map[string][]byte)
+	for _, bid := range bids {
+		var buffer bytes.Buffer
+		buffer.Write(content)
+		storage[string([]byte(bid))] = buffer.Bytes()
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(int64(heapInUse())-int64(before))/float64(b.N), "heap-bytes/blob")
+	runtime.KeepAlive(storage)
+	runtime.KeepAlive(bids)
+}
+
+func BenchmarkMemoryBlobStorageRead(b *testing.B) {
+	bids := benchmarkBids(1024)
+	storage := NewMemoryBlobStorage()
+	for _, bid := range bids {
+		putBlob(storage, bid, []byte{0x01, 0xeb})
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := storage.NewBlobReader(bids[i%len(bids)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
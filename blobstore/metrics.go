@@ -0,0 +1,317 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"expvar"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operation of the storage recorded by metrics
+type Operation int
+
+const (
+	OperationRead   Operation = iota // Reading the blob till its end
+	OperationWrite                   // Writing the blob till it's finalized
+	OperationExists                  // Checking whether blobs exist
+	OperationDelete                  // Deleting the blob
+	OperationList                    // Listing the page of blobs
+	operationCount
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OperationRead:
+		return "read"
+	case OperationWrite:
+		return "write"
+	case OperationExists:
+		return "exists"
+	case OperationDelete:
+		return "delete"
+	case OperationList:
+		return "list"
+	}
+	return "unknown"
+}
+
+// Receiver of finished storage operations, it's called synchronously
+// thus it must be cheap. Bytes is the size of the blob content
+// transferred by reads and writes.
+type MetricsHook interface {
+	Observe(backend string, op Operation, duration time.Duration, bytes int64, err error)
+}
+
+// Storage wrapper reporting operations of the storage to the hook. Reads
+// are reported once the reader reaches the end of the blob, fails or is
+// closed, writes once they're finalized. Cancelled writes are not reported.
+type InstrumentedStorage struct {
+	BlobStorage
+
+	// Name of the backend passed to the hook
+	Name string
+
+	Hook MetricsHook
+}
+
+// Get the wrapped storage
+func (i *InstrumentedStorage) Unwrap() BlobStorage {
+	return i.BlobStorage
+}
+
+// Wrap the storage reporting its operations under given name
+func NewInstrumentedStorage(storage BlobStorage, name string, hook MetricsHook) *InstrumentedStorage {
+	return &InstrumentedStorage{BlobStorage: storage, Name: name, Hook: hook}
+}
+
+func (i *InstrumentedStorage) observe(op Operation, start time.Time, bytes int64, err error) {
+	i.Hook.Observe(i.Name, op, time.Since(start), bytes, err)
+}
+
+func (i *InstrumentedStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	start := time.Now()
+	reader, err := i.BlobStorage.NewBlobReader(blobId)
+	if err != nil {
+		i.observe(OperationRead, start, 0, err)
+		return nil, err
+	}
+	return &instrumentedReader{reader: reader, storage: i, start: start}, nil
+}
+
+// Range reads are reported as reads
+func (i *InstrumentedStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	start := time.Now()
+	reader, err := NewBlobReaderRange(i.BlobStorage, blobId, offset, length)
+	if err != nil {
+		i.observe(OperationRead, start, 0, err)
+		return nil, err
+	}
+	return &instrumentedReader{reader: reader, storage: i, start: start}, nil
+}
+
+func (i *InstrumentedStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	start := time.Now()
+	writer, err := i.BlobStorage.NewBlobWriter(blobId)
+	if err != nil {
+		i.observe(OperationWrite, start, 0, err)
+		return nil, err
+	}
+	return &instrumentedWriter{writer: writer, storage: i, start: start}, nil
+}
+
+func (i *InstrumentedStorage) Exists(blobId string) (bool, error) {
+	start := time.Now()
+	exists, err := i.BlobStorage.Exists(blobId)
+	i.observe(OperationExists, start, 0, err)
+	return exists, err
+}
+
+func (i *InstrumentedStorage) Delete(blobId string) error {
+	start := time.Now()
+	err := i.BlobStorage.Delete(blobId)
+	i.observe(OperationDelete, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedStorage) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	start := time.Now()
+	blobs, next, err := ListBlobs(i.BlobStorage, prefix, cursor, limit)
+	i.observe(OperationList, start, 0, err)
+	return blobs, next, err
+}
+
+// The batch is reported as a single operation
+func (i *InstrumentedStorage) ExistsBatch(blobIds []string) (map[string]bool, error) {
+	start := time.Now()
+	existing, err := ExistsBatch(i.BlobStorage, blobIds)
+	i.observe(OperationExists, start, 0, err)
+	return existing, err
+}
+
+// Metadata lookups are reported as existence checks
+func (i *InstrumentedStorage) Stat(blobId string) (BlobStat, error) {
+	start := time.Now()
+	stat, err := Stat(i.BlobStorage, blobId)
+	i.observe(OperationExists, start, 0, err)
+	return stat, err
+}
+
+func (i *InstrumentedStorage) Capabilities() Capabilities {
+	return StorageCapabilities(i.BlobStorage)
+}
+
+type instrumentedReader struct {
+	reader   io.Reader
+	storage  *InstrumentedStorage
+	start    time.Time
+	bytes    int64
+	reported bool
+}
+
+func (r *instrumentedReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.bytes += int64(n)
+	if err == io.EOF {
+		r.report(nil)
+	} else if err != nil {
+		r.report(err)
+	}
+	return
+}
+
+func (r *instrumentedReader) report(err error) {
+	if !r.reported {
+		r.reported = true
+		r.storage.observe(OperationRead, r.start, r.bytes, err)
+	}
+}
+
+// Readers closed before the end of the blob are reported with bytes read
+func (r *instrumentedReader) Close() error {
+	r.report(nil)
+	if c, ok := r.reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type instrumentedWriter struct {
+	writer  WriteFinalizeCanceler
+	storage *InstrumentedStorage
+	start   time.Time
+	bytes   int64
+}
+
+func (w *instrumentedWriter) Write(p []byte) (n int, err error) {
+	n, err = w.writer.Write(p)
+	w.bytes += int64(n)
+	return
+}
+
+func (w *instrumentedWriter) Finalize() (bool, error) {
+	duplicate, err := w.writer.Finalize()
+	w.storage.observe(OperationWrite, w.start, w.bytes, err)
+	return duplicate, err
+}
+
+func (w *instrumentedWriter) Cancel() error {
+	return w.writer.Cancel()
+}
+
+func (w *instrumentedWriter) Close() error {
+	return w.writer.Close()
+}
+
+// Aggregated operations of one kind
+type OperationStats struct {
+	Count  int64         // Number of finished operations
+	Misses int64         // Operations failed with ErrBIDNotFound
+	Errors int64         // Operations failed with other errors
+	Bytes  int64         // Bytes of blob content transferred
+	Total  time.Duration // Total time of operations
+	Max    time.Duration // Longest operation
+}
+
+// Collector of storage operations, it can be used as the hook of many
+// instrumented storages. Timings of the blob creation are collected too
+// once its Record method is set as the telemetry hook.
+type Metrics struct {
+	TelemetryStats
+
+	// Decrypted content cache reported together with the metrics, optional
+	Cache *DecryptedCache
+
+	lock     sync.Mutex
+	backends map[string]*[operationCount]OperationStats
+}
+
+func (m *Metrics) Observe(backend string, op Operation, duration time.Duration, bytes int64, err error) {
+	if op < 0 || op >= operationCount {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.backends == nil {
+		m.backends = make(map[string]*[operationCount]OperationStats)
+	}
+	ops, found := m.backends[backend]
+	if !found {
+		ops = new([operationCount]OperationStats)
+		m.backends[backend] = ops
+	}
+
+	s := &ops[op]
+	s.Count++
+	s.Bytes += bytes
+	s.Total += duration
+	if duration > s.Max {
+		s.Max = duration
+	}
+	if errors.Is(err, ErrBIDNotFound) {
+		s.Misses++
+	} else if err != nil {
+		s.Errors++
+	}
+}
+
+// Get names of backends with recorded operations, sorted
+func (m *Metrics) Backends() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	backends := make([]string, 0, len(m.backends))
+	for backend := range m.backends {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	return backends
+}
+
+// Get operations of the backend recorded so far
+func (m *Metrics) Operation(backend string, op Operation) OperationStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ops, found := m.backends[backend]
+	if !found || op < 0 || op >= operationCount {
+		return OperationStats{}
+	}
+	return ops[op]
+}
+
+// Get the variable exposing collected metrics together with statistics
+// of the decrypted content cache if it's set, publish it with expvar.Publish
+// to serve it on /debug/vars
+func (m *Metrics) Var() expvar.Var {
+	return expvar.Func(func() interface{} {
+		backends := make(map[string]map[string]OperationStats)
+		for _, backend := range m.Backends() {
+			ops := make(map[string]OperationStats)
+			for op := Operation(0); op < operationCount; op++ {
+				ops[op.String()] = m.Operation(backend, op)
+			}
+			backends[backend] = ops
+		}
+		stages := make(map[string]StageStats)
+		for stage := Stage(0); stage < stageCount; stage++ {
+			stages[stage.String()] = m.Stage(stage)
+		}
+
+		vars := map[string]interface{}{
+			"backends": backends,
+			"stages":   stages,
+		}
+		if m.Cache != nil {
+			vars["decryptedCache"] = m.Cache.Stats()
+		}
+		return vars
+	})
+}
@@ -0,0 +1,86 @@
+package blobstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestInstrumentedStorage(t *testing.T) {
+
+	metrics := &Metrics{}
+	storage := NewInstrumentedStorage(NewMemoryBlobStorage(), "memory", metrics)
+
+	putBlob(storage, "blob", []byte("Hello World!"))
+	putBlob(storage, "blob", []byte("Hello World!"))
+	writer, _ := storage.NewBlobWriter("cancelled")
+	writer.Write([]byte("data"))
+	writer.Cancel()
+
+	reader, _ := storage.NewBlobReader("blob")
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "Hello World!" {
+		t.Fatalf("Invalid blob content: %q %v", data, err)
+	}
+	if _, err := storage.NewBlobReader("missing"); err != ErrBIDNotFound {
+		t.Fatalf("Invalid error of the missing blob: %v", err)
+	}
+
+	// Partially read blob is reported once closed
+	reader, _ = storage.NewBlobReader("blob")
+	reader.Read(make([]byte, 5))
+	closeReader(reader)
+	closeReader(reader)
+
+	storage.Exists("blob")
+	ExistsBatch(storage, []string{"blob", "missing"})
+	storage.Delete("missing")
+	ListBlobs(storage, "", "", 0)
+
+	if s := metrics.Operation("memory", OperationWrite); s.Count != 2 || s.Bytes != 24 || s.Errors != 0 || s.Max > s.Total {
+		t.Fatalf("Invalid write stats: %+v", s)
+	}
+	if s := metrics.Operation("memory", OperationRead); s.Count != 3 || s.Bytes != 17 || s.Misses != 1 {
+		t.Fatalf("Invalid read stats: %+v", s)
+	}
+	if s := metrics.Operation("memory", OperationExists); s.Count != 2 {
+		t.Fatalf("Invalid exists stats: %+v", s)
+	}
+	if s := metrics.Operation("memory", OperationDelete); s.Count != 1 || s.Misses != 1 {
+		t.Fatalf("Invalid delete stats: %+v", s)
+	}
+	if s := metrics.Operation("memory", OperationList); s.Count != 1 {
+		t.Fatalf("Invalid list stats: %+v", s)
+	}
+	if backends := metrics.Backends(); len(backends) != 1 || backends[0] != "memory" {
+		t.Fatalf("Invalid backends: %v", backends)
+	}
+	if StorageCapabilities(storage) != StorageCapabilities(NewMemoryBlobStorage()) {
+		t.Fatalf("Capabilities of the backend not passed through")
+	}
+}
+
+func TestMetricsVar(t *testing.T) {
+
+	defer SetTelemetry(nil, 0)
+
+	metrics := &Metrics{Cache: NewDecryptedCache(1 << 20)}
+	SetTelemetry(metrics.Record, 1)
+
+	fw := FileBlobWriter{Storage: NewInstrumentedStorage(NewMemoryBlobStorage(), "memory", metrics)}
+	fw.Write([]byte("data"))
+	if _, err := fw.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	var vars struct {
+		Backends map[string]map[string]OperationStats
+		Stages   map[string]StageStats
+		Cache    *DecryptedCacheStats `json:"decryptedCache"`
+	}
+	if err := json.Unmarshal([]byte(metrics.Var().String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Backends["memory"]["write"].Count != 1 || vars.Stages["encrypt"].Samples != 1 || vars.Cache == nil {
+		t.Fatalf("Invalid exported metrics: %+v", vars)
+	}
+}
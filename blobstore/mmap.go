@@ -0,0 +1,55 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+)
+
+// Reader of the blob file mapped into memory, the mapping is released
+// when the reader is closed or, if it's not closed, garbage collected
+type mappedBlobReader struct {
+	bytes.Reader
+	data []byte // Mapped content, nil for empty files
+}
+
+// Map the whole blob file into memory, the file is closed if it's mapped.
+// False is returned if the file can't be mapped, it's still open then.
+func mapBlobFile(file *os.File) (*mappedBlobReader, bool) {
+	info, err := file.Stat()
+	if err != nil || info.Size() != int64(int(info.Size())) {
+		return nil, false
+	}
+
+	// Empty files can't be mapped, there's nothing to read anyway
+	var data []byte
+	if info.Size() > 0 {
+		if data, err = mmapFile(file, int(info.Size())); err != nil {
+			return nil, false
+		}
+	}
+	file.Close()
+
+	reader := &mappedBlobReader{data: data}
+	reader.Reset(data)
+	if data != nil {
+		runtime.SetFinalizer(reader, (*mappedBlobReader).Close)
+	}
+	return reader, true
+}
+
+// Release the mapping, the reader must not be used afterwards
+func (m *mappedBlobReader) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	m.Reset(nil)
+	runtime.SetFinalizer(m, nil)
+	return munmap(data)
+}
@@ -0,0 +1,23 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package blobstore
+
+import (
+	"errors"
+	"os"
+)
+
+var errMmapUnsupported = errors.New("Memory mapping is not supported on this platform")
+
+// Files are always read through buffered I/O
+func mmapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
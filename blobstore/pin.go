@@ -0,0 +1,244 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bufio"
+	"errors"
+	"github.com/cinode/golib/cipherfactory"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrPinningNotSupported = errors.New("Blob storage does not support pinning blobs")
+	ErrBlobPinned          = errors.New("Blob is pinned")
+	ErrInvalidPinFile      = errors.New("Invalid pin file")
+	ErrPinKeyRequired      = errors.New("Key of the blob is required to pin it")
+)
+
+// Optional interface of the blob storage keeping the set of pinned blobs.
+// The garbage collector treats pinned blobs as roots, they're kept together
+// with all blobs reachable from them.
+type Pinner interface {
+
+	// Pin the blob, the key is required so that the garbage
+	// collector keeps blobs referenced by the pinned one too
+	Pin(ref BlobReference) error
+
+	// Unpin the blob, unpinning blobs which are not pinned is not an error
+	Unpin(blobId string) error
+
+	// Get all pinned blobs sorted by their ids
+	ListPins() ([]BlobReference, error)
+}
+
+// Get pinned blobs of the first storage of the chain of wrappers which
+// implements Pinner, ErrPinningNotSupported is returned if there's none
+func ListPins(storage BlobStorage) ([]BlobReference, error) {
+	pinner := FindStorage(storage, func(s BlobStorage) bool {
+		_, ok := s.(Pinner)
+		return ok
+	})
+	if pinner == nil {
+		return nil, ErrPinningNotSupported
+	}
+	return pinner.(Pinner).ListPins()
+}
+
+// Storage wrapper keeping the pin set, pinned blobs can't be deleted
+// through it. The pin set is kept in a local file replaced atomically
+// on each change, or in memory only if the path is empty. Keys of pinned
+// blobs are wrapped with the master key in the file, see
+// cipherfactory.WrapKey.
+type PinningStorage struct {
+	BlobStorage
+
+	path      string
+	masterKey []byte
+	lock      sync.RWMutex
+	pins      map[string]string // Keys of pinned blobs by their ids
+}
+
+// Get the wrapped storage
+func (p *PinningStorage) Unwrap() BlobStorage {
+	return p.BlobStorage
+}
+
+// Wrap the storage keeping the pin set in given file, pins are loaded
+// from the file if it exists. The master key of cipherfactory.MasterKeySize
+// bytes is required if the path is given, it's not used otherwise.
+func NewPinningStorage(storage BlobStorage, path string, masterKey []byte) (*PinningStorage, error) {
+	if path != "" && len(masterKey) != cipherfactory.MasterKeySize {
+		return nil, cipherfactory.ErrInvalidMasterKey
+	}
+	p := &PinningStorage{BlobStorage: storage, path: path, masterKey: masterKey, pins: make(map[string]string)}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Pin the blob, it must exist in the storage. Blobs can't be pinned without
+// their keys, the key is checked by reading references of the blob.
+func (p *PinningStorage) Pin(ref BlobReference) error {
+	if ref.Key == "" {
+		return ErrPinKeyRequired
+	}
+	info, err := InspectBlob(ref.Bid, p.BlobStorage)
+	if err != nil {
+		return err
+	}
+	if !info.IsSigned() {
+		if _, err = GetBlobReferences(ref.Bid, ref.Key, p.BlobStorage); err != nil {
+			return err
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	previous, found := p.pins[ref.Bid]
+	if found && previous == ref.Key {
+		return nil
+	}
+	p.pins[ref.Bid] = ref.Key
+	if err = p.save(); err != nil {
+		if found {
+			p.pins[ref.Bid] = previous
+		} else {
+			delete(p.pins, ref.Bid)
+		}
+	}
+	return err
+}
+
+func (p *PinningStorage) Unpin(blobId string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	key, found := p.pins[blobId]
+	if !found {
+		return nil
+	}
+	delete(p.pins, blobId)
+	if err := p.save(); err != nil {
+		p.pins[blobId] = key
+		return err
+	}
+	return nil
+}
+
+func (p *PinningStorage) ListPins() ([]BlobReference, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	pins := make([]BlobReference, 0, len(p.pins))
+	for bid, key := range p.pins {
+		pins = append(pins, BlobReference{Bid: bid, Key: key})
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Bid < pins[j].Bid })
+	return pins, nil
+}
+
+// Check whether the blob is pinned
+func (p *PinningStorage) IsPinned(blobId string) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	_, found := p.pins[blobId]
+	return found
+}
+
+func (p *PinningStorage) Delete(blobId string) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if _, found := p.pins[blobId]; found {
+		return ErrBlobPinned
+	}
+	return p.BlobStorage.Delete(blobId)
+}
+
+func (p *PinningStorage) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	return ListBlobs(p.BlobStorage, prefix, cursor, limit)
+}
+
+func (p *PinningStorage) ExistsBatch(blobIds []string) (map[string]bool, error) {
+	return ExistsBatch(p.BlobStorage, blobIds)
+}
+
+func (p *PinningStorage) Stat(blobId string) (BlobStat, error) {
+	return Stat(p.BlobStorage, blobId)
+}
+
+func (p *PinningStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	return NewBlobReaderRange(p.BlobStorage, blobId, offset, length)
+}
+
+// Write the pin file, one "bid envelope" line per pin where
+// the envelope is the key wrapped with the master key
+func (p *PinningStorage) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	pins := make([]string, 0, len(p.pins))
+	for bid, key := range p.pins {
+		envelope, err := cipherfactory.WrapKey(key, p.masterKey)
+		if err != nil {
+			return err
+		}
+		pins = append(pins, bid+" "+envelope+"\n")
+	}
+	sort.Strings(pins)
+
+	file, err := ioutil.TempFile(filepath.Dir(p.path), ".pins")
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(strings.Join(pins, ""))
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), p.path)
+}
+
+// Read the pin file
+func (p *PinningStorage) load() error {
+	if p.path == "" {
+		return nil
+	}
+
+	file, err := os.Open(p.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return ErrInvalidPinFile
+		}
+		key, err := cipherfactory.UnwrapKey(fields[1], p.masterKey)
+		if err != nil {
+			return err
+		}
+		p.pins[fields[0]] = key
+	}
+	return scanner.Err()
+}
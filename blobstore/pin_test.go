@@ -0,0 +1,104 @@
+package blobstore
+
+import (
+	"bytes"
+	"github.com/cinode/golib/cipherfactory"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPinningStorage(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := NewMemoryBlobStorage()
+	path := filepath.Join(dir, "pins")
+	masterKey := bytes.Repeat([]byte{0x42}, cipherfactory.MasterKeySize)
+	if _, err = NewPinningStorage(backend, path, nil); err != cipherfactory.ErrInvalidMasterKey {
+		t.Fatalf("Invalid error for the pin file without the master key: %v", err)
+	}
+	storage, err := NewPinningStorage(backend, path, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bid1, key1, _ := CreateTypedBlob(blobTypeSimpleStaticFile, []byte("data1"), backend)
+	bid2, key2, _ := CreateTypedBlob(blobTypeSimpleStaticFile, []byte("data2"), backend)
+	if err = storage.Pin(BlobReference{Bid: bid1, Key: key1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Pin(BlobReference{Bid: bid2, Key: key2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Pin(BlobReference{Bid: bid2}); err != ErrPinKeyRequired {
+		t.Fatalf("Invalid error for pinning without the key: %v", err)
+	}
+	if err = storage.Pin(BlobReference{Bid: bid2, Key: key1}); err == nil {
+		t.Fatal("Blob pinned with invalid key")
+	}
+	if err = storage.Pin(BlobReference{Bid: "missing", Key: key1}); err != ErrBIDNotFound {
+		t.Fatalf("Invalid error for pinning missing blob: %v", err)
+	}
+	if err = storage.Delete(bid1); err != ErrBlobPinned {
+		t.Fatalf("Invalid error for deleting pinned blob: %v", err)
+	}
+
+	// Keys are not written to the pin file
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(key1)) || bytes.Contains(data, []byte(key2)) {
+		t.Fatalf("Plaintext keys found in the pin file: %s", data)
+	}
+
+	// Pins survive reopening
+	if _, err = NewPinningStorage(backend, path, bytes.Repeat([]byte{0x24}, cipherfactory.MasterKeySize)); err == nil {
+		t.Fatal("Pin file opened with other master key")
+	}
+	reopened, err := NewPinningStorage(backend, path, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins, err := ListPins(reopened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []BlobReference{{Bid: bid1, Key: key1}, {Bid: bid2, Key: key2}}
+	if bid2 < bid1 {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if len(pins) != 2 || pins[0] != expected[0] || pins[1] != expected[1] {
+		t.Fatalf("Invalid pins: %v", pins)
+	}
+	if pins, err = ListPins(NewReadOnlyStorage(NewMaintenanceStorage(reopened))); err != nil || len(pins) != 2 {
+		t.Fatalf("Pins not found through wrappers: %v %v", pins, err)
+	}
+
+	if err = reopened.Unpin(bid1); err != nil {
+		t.Fatal(err)
+	}
+	if err = reopened.Unpin(bid1); err != nil {
+		t.Fatal(err)
+	}
+	if reopened.IsPinned(bid1) || !reopened.IsPinned(bid2) {
+		t.Fatal("Invalid pins after unpinning")
+	}
+	if err = reopened.Delete(bid1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ListPins(backend); err != ErrPinningNotSupported {
+		t.Fatalf("Invalid error for storage without pins: %v", err)
+	}
+	ioutil.WriteFile(path, []byte("bid-without-key\n"), 0600)
+	if _, err = NewPinningStorage(backend, path, masterKey); err != ErrInvalidPinFile {
+		t.Fatalf("Invalid error for malformed pin file: %v", err)
+	}
+}
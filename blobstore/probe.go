@@ -0,0 +1,47 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+// Optional interface of the blob storage that can quickly tell
+// the validation method of a blob without opening it.
+//
+// The validation method of given blob id never changes - the blob id
+// is either a hash of the content or a hash of the public key,
+// storages are free to cache this information in their indexes.
+type ValidationMethodProber interface {
+
+	// Get the validation method of the blob
+	ValidationMethod(blobId string) (method int64, err error)
+}
+
+// Get the validation method of the blob, if the storage does implement
+// ValidationMethodProber, it's used, otherwise the first bytes of the blob
+// are read
+func ProbeValidationMethod(storage BlobStorage, blobId string) (method int64, err error) {
+
+	if prober, ok := storage.(ValidationMethodProber); ok {
+		return prober.ValidationMethod(blobId)
+	}
+
+	return readValidationMethod(storage, blobId)
+}
+
+// Check whether the raw blob starting with given bytes is hash-validated.
+// Content of such blobs is determined by their ids, writers can skip
+// blobs already stored without sending their content.
+func IsHashValidatedBlob(prefix []byte) bool {
+	return len(prefix) > 0 && prefix[0] == validationMethodHash
+}
+
+// Read the validation method from the beginning of the blob
+func readValidationMethod(storage BlobStorage, blobId string) (method int64, err error) {
+	reader, err := storage.NewBlobReader(blobId)
+	if err != nil {
+		return
+	}
+	defer closeReader(reader)
+
+	return deserializeInt(reader)
+}
@@ -0,0 +1,49 @@
+package blobstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Storage hiding optional interfaces of the wrapped one
+type plainStorage struct {
+	BlobStorage
+}
+
+func TestProbeValidationMethod(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory := NewMemoryBlobStorage()
+	for _, storage := range []BlobStorage{
+		memory,
+		plainStorage{memory},
+		NewFileBlobStorage(dir),
+		NewFileBlobStorage(dir), // Empty cache, existing blobs
+	} {
+		putBlob(storage, "0a01", []byte{validationMethodHash, 0x01})
+		putBlob(storage, "0a02", []byte{validationMethodSign, 0x02})
+
+		for bid, expected := range map[string]int64{
+			"0a01": validationMethodHash,
+			"0a02": validationMethodSign,
+		} {
+			method, err := ProbeValidationMethod(storage, bid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if method != expected {
+				t.Fatalf("Invalid validation method of blob %v: %v", bid, method)
+			}
+		}
+
+		if _, err := ProbeValidationMethod(storage, "0a03"); err == nil {
+			t.Fatal("Did probe missing blob")
+		}
+	}
+}
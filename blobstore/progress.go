@@ -0,0 +1,131 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"github.com/cinode/golib/utils"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Minimum time between calls of progress functions used if not configured
+const DefaultProgressInterval = 100 * time.Millisecond
+
+// Function called during long operations with the number of bytes done so
+// far, the total number of bytes, BlobInfoUnknown if not known, and the item
+// being processed. The last call of the successful operation reports done
+// equal to total.
+type ProgressFunc func(done, total int64, item string)
+
+// Get the function passing calls to f at most once per interval,
+// DefaultProgressInterval if not positive. Calls reporting completion
+// are always passed. Calls are serialized, the returned function can be
+// used by concurrent goroutines. Nil is returned if f is nil.
+func (f ProgressFunc) Throttle(interval time.Duration) ProgressFunc {
+	return f.ThrottleClock(interval, utils.SystemClock)
+}
+
+// Throttle calls like Throttle measuring the interval with the clock
+func (f ProgressFunc) ThrottleClock(interval time.Duration, clock utils.Clock) ProgressFunc {
+	if f == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+
+	var lock sync.Mutex
+	var last time.Time
+	return func(done, total int64, item string) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		now := clock.Now()
+		if done != total && now.Sub(last) < interval {
+			return
+		}
+		last = now
+		f(done, total, item)
+	}
+}
+
+// Counter of bytes of the operation reported to the throttled progress
+// function, methods of the nil counter do nothing
+type ProgressCounter struct {
+	report ProgressFunc
+	done   atomic.Int64
+	total  int64
+}
+
+// Create the counter of the operation with given total number of bytes,
+// BlobInfoUnknown if not known. Nil is returned if f is nil, the clock
+// measures intervals of the throttled calls, system clock if nil.
+func NewProgressCounter(f ProgressFunc, interval time.Duration, clock utils.Clock, total int64) *ProgressCounter {
+	if f == nil {
+		return nil
+	}
+	if clock == nil {
+		clock = utils.SystemClock
+	}
+	return &ProgressCounter{report: f.ThrottleClock(interval, clock), total: total}
+}
+
+// Add bytes done with the item
+func (c *ProgressCounter) Add(n int64, item string) {
+	if c == nil {
+		return
+	}
+	c.report(c.done.Add(n), c.total, item)
+}
+
+// Report the end of the operation, all bytes are done then
+func (c *ProgressCounter) Finish() {
+	if c == nil {
+		return
+	}
+	done := c.done.Load()
+	c.report(done, done, "")
+}
+
+// Get the reader adding bytes read to the counter, the reader
+// is returned as it is by the nil counter
+func (c *ProgressCounter) Reader(reader io.Reader, item string) io.Reader {
+	if c == nil {
+		return reader
+	}
+	return &progressReader{reader: reader, counter: c, item: item}
+}
+
+// Reader adding bytes read to the progress counter
+type progressReader struct {
+	reader  io.Reader
+	counter *ProgressCounter
+	item    string
+}
+
+func (p *progressReader) Read(b []byte) (n int, err error) {
+	n, err = p.reader.Read(b)
+	if n > 0 {
+		p.counter.Add(int64(n), p.item)
+	}
+	return
+}
+
+// Writer adding bytes written to the progress counter
+type progressWriter struct {
+	writer  io.Writer
+	counter *ProgressCounter
+	item    string
+}
+
+func (p *progressWriter) Write(b []byte) (n int, err error) {
+	n, err = p.writer.Write(b)
+	if n > 0 {
+		p.counter.Add(int64(n), p.item)
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package blobstore
+
+import (
+	"crypto/rand"
+	"github.com/cinode/golib/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Progress function recording its calls
+type progressRecorder struct {
+	calls       int
+	done, total int64
+	items       map[string]bool
+}
+
+func (p *progressRecorder) report(done, total int64, item string) {
+	if done < p.done {
+		panic("Progress went back")
+	}
+	p.calls++
+	p.done, p.total = done, total
+	if p.items == nil {
+		p.items = make(map[string]bool)
+	}
+	p.items[item] = true
+}
+
+func TestProgressThrottle(t *testing.T) {
+
+	var nilFunc ProgressFunc
+	if nilFunc.Throttle(time.Second) != nil {
+		t.Fatal("Nil progress function throttled")
+	}
+
+	var p progressRecorder
+	throttled := ProgressFunc(p.report).Throttle(time.Hour)
+	for i := int64(1); i <= 10; i++ {
+		throttled(i, 10, "")
+	}
+	if p.calls != 2 || p.done != 10 || p.total != 10 {
+		t.Fatalf("Invalid throttled calls: %+v", p)
+	}
+
+	// Calls are passed again once the interval passes
+	clock := utils.NewManualClock(time.Unix(0, 0))
+	p = progressRecorder{}
+	throttled = ProgressFunc(p.report).ThrottleClock(time.Second, clock)
+	for i := int64(1); i <= 5; i++ {
+		throttled(i, 10, "")
+		clock.Advance(time.Second / 2)
+	}
+	if p.calls != 3 || p.done != 5 {
+		t.Fatalf("Invalid calls throttled with the clock: %+v", p)
+	}
+}
+
+func TestFileBlobWriterProgress(t *testing.T) {
+
+	data := make([]byte, 10000)
+	rand.Read(data)
+
+	var p progressRecorder
+	fw := FileBlobWriter{
+		Storage:          NewMemoryBlobStorage(),
+		Config:           &WriterConfig{ChunkSize: 4000},
+		Progress:         p.report,
+		ProgressInterval: time.Nanosecond,
+	}
+	for i := 0; i < len(data); i += 1000 {
+		fw.Write(data[i : i+1000])
+		if p.done != int64(i+1000) || p.total != BlobInfoUnknown {
+			t.Fatalf("Invalid progress after %v bytes: %+v", i+1000, p)
+		}
+	}
+	if _, err := fw.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if p.done != int64(len(data)) || p.total != int64(len(data)) {
+		t.Fatalf("Invalid final progress: %+v", p)
+	}
+}
+
+func TestUploadAndMaterializeProgress(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(filepath.Join(source, "sub"), 0777)
+	ioutil.WriteFile(filepath.Join(source, "a.txt"), make([]byte, 3000), 0666)
+	ioutil.WriteFile(filepath.Join(source, "sub", "b.txt"), make([]byte, 5000), 0666)
+
+	storage := NewMemoryBlobStorage()
+	var upload progressRecorder
+	bid, key, err := UploadDirectoryWithOptions(source, storage, UploadOptions{
+		Progress:         upload.report,
+		ProgressInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.done != 8000 || upload.total != 8000 ||
+		!upload.items[filepath.Join(source, "a.txt")] || !upload.items[filepath.Join(source, "sub", "b.txt")] {
+		t.Fatalf("Invalid progress of the upload: %+v", upload)
+	}
+
+	var file progressRecorder
+	if _, _, err = UploadFileWithOptions(filepath.Join(source, "a.txt"), storage, UploadOptions{Progress: file.report}); err != nil {
+		t.Fatal(err)
+	}
+	if file.done != 3000 || file.total != 3000 {
+		t.Fatalf("Invalid progress of the file upload: %+v", file)
+	}
+
+	target := filepath.Join(dir, "target")
+	var export progressRecorder
+	err = MaterializeDirectoryWithOptions(bid, key, storage, target, MaterializeOptions{
+		Progress:         export.report,
+		ProgressInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.done != 8000 || export.total != 8000 || !export.items[filepath.Join(target, "sub", "b.txt")] {
+		t.Fatalf("Invalid progress of the materialization: %+v", export)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(target, "sub", "b.txt")); err != nil || len(data) != 5000 {
+		t.Fatalf("Invalid materialized file: %v", err)
+	}
+}
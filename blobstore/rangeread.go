@@ -0,0 +1,69 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	ErrInvalidRange = errors.New("Invalid range of the blob")
+)
+
+// Optional interface of the blob storage that can read a part of the blob
+// without fetching the data before it
+type RangeReader interface {
+
+	// Get the reader of length bytes of the raw blob starting at given
+	// offset, the rest of the blob is read if the length is negative.
+	// Ranges reaching past the end of the blob are cut at the end,
+	// ErrBIDNotFound is returned if there's no such blob.
+	NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error)
+}
+
+// Get the reader of the part of the raw blob, if the storage does not
+// implement RangeReader the blob is read and the data before the offset
+// is dropped
+func NewBlobReaderRange(storage BlobStorage, blobId string, offset, length int64) (io.Reader, error) {
+	if offset < 0 {
+		return nil, ErrInvalidRange
+	}
+	if ranger, ok := storage.(RangeReader); ok {
+		return ranger.NewBlobReaderRange(blobId, offset, length)
+	}
+
+	reader, err := storage.NewBlobReader(blobId)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.CopyN(ioutil.Discard, reader, offset); err != nil && err != io.EOF {
+		closeReader(reader)
+		return nil, err
+	}
+	return limitRange(reader, length), nil
+}
+
+// Reader of the part of the blob, the underlying reader is closed with it
+type rangeReader struct {
+	io.Reader
+	closer io.Reader
+}
+
+func (r *rangeReader) Close() error {
+	if closer, ok := r.closer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Limit the reader to length bytes unless the length is negative
+func limitRange(reader io.Reader, length int64) io.Reader {
+	if length < 0 {
+		return reader
+	}
+	return &rangeReader{Reader: io.LimitReader(reader, length), closer: reader}
+}
@@ -0,0 +1,127 @@
+package blobstore
+
+import (
+	"bytes"
+	"github.com/cinode/golib/cipherfactory"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewBlobReaderRange(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+		NewFileBlobStorageWithOptions(filepath.Join(dir, "mmap"), FileBlobStorageOptions{MemoryMapped: true}),
+		NewReadOnlyStorage(NewMemoryBlobStorage()),
+		plainStorage{NewMemoryBlobStorage()},
+	} {
+		if ro, ok := storage.(*ReadOnlyStorage); ok {
+			putBlob(ro.BlobStorage, "b10b", []byte("0123456789"))
+		} else {
+			putBlob(storage, "b10b", []byte("0123456789"))
+		}
+
+		for _, d := range []struct {
+			offset, length int64
+			data           string
+		}{
+			{0, -1, "0123456789"},
+			{3, 4, "3456"},
+			{8, 10, "89"},
+			{5, 0, ""},
+			{10, -1, ""},
+			{12, 2, ""},
+		} {
+			reader, err := NewBlobReaderRange(storage, "b10b", d.offset, d.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(reader)
+			closeReader(reader)
+			if err != nil || string(data) != d.data {
+				t.Fatalf("Invalid range %v+%v of %T: %q %v", d.offset, d.length, storage, data, err)
+			}
+		}
+
+		if _, err := NewBlobReaderRange(storage, "0a03", 0, -1); err != ErrBIDNotFound {
+			t.Fatalf("Invalid error of the missing blob in %T: %v", storage, err)
+		}
+		if _, err := NewBlobReaderRange(storage, "b10b", -1, -1); err != ErrInvalidRange {
+			t.Fatalf("Invalid error of the negative offset in %T: %v", storage, err)
+		}
+	}
+}
+
+func TestFileBlobRangeSeek(t *testing.T) {
+
+	data := make([]byte, 1000000)
+	for i := range data {
+		data[i] = byte(i ^ (i >> 8))
+	}
+
+	for _, d := range []struct {
+		algorithm string
+		ranged    bool
+	}{
+		{cipherfactory.AlgorithmAES256GCM, true},
+		{cipherfactory.AlgorithmAES256CTR, false},
+		{cipherfactory.AlgorithmAES256CFB, false},
+	} {
+		metrics := &Metrics{}
+		storage := NewInstrumentedStorage(NewMemoryBlobStorage(), "memory", metrics)
+		writer := FileBlobWriter{Storage: storage, Config: &WriterConfig{ChunkSize: 400000, CipherAlgorithm: d.algorithm}}
+		writer.Write(data)
+		ref, err := writer.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rdr, err := OpenFileBlob(ref.Bid, ref.Key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened := metrics.Operation("memory", OperationRead).Bytes
+
+		// The seek crosses the partial blob border and lands in the middle
+		// of the next partial blob
+		for _, pos := range []int64{700000, 399990, 999990} {
+			if _, err = rdr.Seek(pos, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			buff := make([]byte, 20)
+			n, err := io.ReadFull(rdr, buff)
+			if pos+20 <= int64(len(data)) && err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buff[:n], data[pos:pos+int64(n)]) {
+				t.Fatalf("Invalid data read at position %v with %v", pos, d.algorithm)
+			}
+		}
+
+		// Partial blobs are fetched from the seek position only if
+		// they're encrypted with authenticated seekable ciphers
+		fetched := metrics.Operation("memory", OperationRead).Bytes - opened
+		if ranged := fetched < int64(len(data))/2; ranged != d.ranged {
+			t.Fatalf("Invalid number of bytes fetched with %v: %v", d.algorithm, fetched)
+		}
+
+		// Reading from the beginning of the partial blob is validated
+		if _, err = rdr.Seek(400000, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		rest, err := ioutil.ReadAll(rdr)
+		if err != nil || !bytes.Equal(rest, data[400000:]) {
+			t.Fatalf("Invalid data read from the partial blob border with %v: %v", d.algorithm, err)
+		}
+	}
+}
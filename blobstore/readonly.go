@@ -0,0 +1,58 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrReadOnly = errors.New("Blob storage is read-only")
+)
+
+// Storage wrapper rejecting writes and deletions, i.e. to share
+// the storage with code which must not modify it
+type ReadOnlyStorage struct {
+	BlobStorage
+}
+
+// Get the wrapped storage
+func (r *ReadOnlyStorage) Unwrap() BlobStorage {
+	return r.BlobStorage
+}
+
+// Wrap the storage rejecting all modifications
+func NewReadOnlyStorage(storage BlobStorage) *ReadOnlyStorage {
+	return &ReadOnlyStorage{BlobStorage: storage}
+}
+
+func (r *ReadOnlyStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	return nil, ErrReadOnly
+}
+
+func (r *ReadOnlyStorage) Delete(blobId string) error {
+	return ErrReadOnly
+}
+
+func (r *ReadOnlyStorage) ListBlobs(prefix, cursor string, limit int) ([]StoredBlob, string, error) {
+	return ListBlobs(r.BlobStorage, prefix, cursor, limit)
+}
+
+func (r *ReadOnlyStorage) ExistsBatch(blobIds []string) (map[string]bool, error) {
+	return ExistsBatch(r.BlobStorage, blobIds)
+}
+
+func (r *ReadOnlyStorage) Stat(blobId string) (BlobStat, error) {
+	return Stat(r.BlobStorage, blobId)
+}
+
+func (r *ReadOnlyStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	return NewBlobReaderRange(r.BlobStorage, blobId, offset, length)
+}
+
+func (r *ReadOnlyStorage) Capabilities() Capabilities {
+	return StorageCapabilities(r.BlobStorage) &^ (CapabilityWrite | CapabilityDelete | CapabilityAtomicWrites | CapabilityExpiry)
+}
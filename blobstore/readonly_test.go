@@ -0,0 +1,67 @@
+package blobstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadOnlyStorage(t *testing.T) {
+
+	backend := NewMemoryBlobStorage()
+	putBlob(backend, "bid", []byte("data"))
+	storage := NewReadOnlyStorage(backend)
+
+	if _, err := storage.NewBlobWriter("other"); err != ErrReadOnly {
+		t.Fatalf("Invalid error of write: %v", err)
+	}
+	if err := storage.Delete("bid"); err != ErrReadOnly {
+		t.Fatalf("Invalid error of delete: %v", err)
+	}
+	if exists, _ := backend.Exists("bid"); !exists {
+		t.Fatal("Blob deleted through read-only storage")
+	}
+
+	reader, err := storage.NewBlobReader("bid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(reader); string(data) != "data" {
+		t.Fatalf("Invalid blob content: %q", data)
+	}
+	if blobs, err := ListAllBlobs(storage); err != nil || len(blobs) != 1 {
+		t.Fatalf("Invalid listing: %v, %v", blobs, err)
+	}
+}
+
+func TestStorageCapabilities(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	all := CapabilityList | CapabilityWrite | CapabilityDelete | CapabilityAtomicWrites | CapabilityRangeReads
+	for _, d := range []struct {
+		storage      BlobStorage
+		capabilities Capabilities
+	}{
+		{NewMemoryBlobStorage(), all},
+		{NewFileBlobStorage(dir), all | CapabilityExpiry},
+		{NewMaintenanceStorage(NewFileBlobStorage(dir)), all},
+		{NewReadOnlyStorage(NewFileBlobStorage(dir)), CapabilityList | CapabilityRangeReads},
+		{NewMaintenanceStorage(NewMemoryBlobStorage()), all},
+		{NewReadOnlyStorage(NewMemoryBlobStorage()), CapabilityList | CapabilityRangeReads},
+		{NewReadOnlyStorage(plainStorage{NewMemoryBlobStorage()}), 0},
+		{plainStorage{NewMemoryBlobStorage()}, CapabilityWrite | CapabilityDelete},
+	} {
+		if capabilities := StorageCapabilities(d.storage); capabilities != d.capabilities {
+			t.Fatalf("Invalid capabilities of %T: %b, expected %b", d.storage, capabilities, d.capabilities)
+		}
+	}
+
+	if !all.Has(CapabilityList|CapabilityDelete) || CapabilityList.Has(CapabilityList|CapabilityDelete) {
+		t.Fatal("Invalid check of capabilities")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"github.com/cinode/golib/cipherfactory"
+	"strings"
+)
+
+var (
+	ErrInvalidBID = errors.New("Invalid blob id")
+)
+
+// Blob id checked when it's created, the zero value is not a valid id.
+// Blob ids and keys are distinct types so that they can't be swapped
+// by mistake.
+type BID struct {
+	value     string // Canonical hex form
+	algorithm string // Hash algorithm of the id
+}
+
+// Create the blob id from its form in any encoding, the id must name
+// a known hash algorithm
+func NewBID(s string) (BID, error) {
+	canonical, err := ParseBID(s)
+	if err != nil {
+		return BID{}, ErrInvalidBID
+	}
+	canonical = strings.ToLower(canonical)
+	algorithm, err := BidHashAlgorithm(canonical)
+	if err != nil {
+		return BID{}, ErrInvalidBID
+	}
+	return BID{value: canonical, algorithm: algorithm}, nil
+}
+
+// Get the canonical hex form of the blob id
+func (b BID) String() string {
+	return b.value
+}
+
+// Get the blob id in given encoding
+func (b BID) Format(encoding Encoding) string {
+	if b.IsZero() {
+		return ""
+	}
+	s, _ := FormatBID(b.value, encoding)
+	return s
+}
+
+// Get the hash algorithm of the blob id, see WriterConfig.HashAlgorithm
+func (b BID) HashAlgorithm() string {
+	return b.algorithm
+}
+
+// Check whether this is the zero value
+func (b BID) IsZero() bool {
+	return b.value == ""
+}
+
+func (b BID) MarshalText() ([]byte, error) {
+	return []byte(b.value), nil
+}
+
+// Blob ids in any encoding are accepted, the empty text gives the zero value
+func (b *BID) UnmarshalText(text []byte) (err error) {
+	if len(text) == 0 {
+		*b = BID{}
+		return nil
+	}
+	*b, err = NewBID(string(text))
+	return
+}
+
+// Key of the blob checked when it's created, the zero value is not a valid key
+type KeyInfo struct {
+	value     string // Canonical hex form
+	algorithm string // Cipher algorithm of the key
+}
+
+// Create the key from its form in any encoding, the key must be one
+// of a known cipher algorithm
+func NewKeyInfo(s string) (KeyInfo, error) {
+	canonical, err := ParseKey(s)
+	if err != nil {
+		return KeyInfo{}, ErrInvalidKey
+	}
+	canonical = strings.ToLower(canonical)
+	algorithm, err := cipherfactory.KeyAlgorithm(canonical)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return KeyInfo{value: canonical, algorithm: algorithm}, nil
+}
+
+// Get the canonical hex form of the key
+func (k KeyInfo) String() string {
+	return k.value
+}
+
+// Get the key in given encoding
+func (k KeyInfo) Format(encoding Encoding) string {
+	if k.IsZero() {
+		return ""
+	}
+	s, _ := FormatKey(k.value, encoding)
+	return s
+}
+
+// Get the cipher algorithm of the key, see WriterConfig.CipherAlgorithm
+func (k KeyInfo) CipherAlgorithm() string {
+	return k.algorithm
+}
+
+// Check whether this is the zero value
+func (k KeyInfo) IsZero() bool {
+	return k.value == ""
+}
+
+func (k KeyInfo) MarshalText() ([]byte, error) {
+	return []byte(k.value), nil
+}
+
+// Keys in any encoding are accepted, the empty text gives the zero value
+func (k *KeyInfo) UnmarshalText(text []byte) (err error) {
+	if len(text) == 0 {
+		*k = KeyInfo{}
+		return nil
+	}
+	*k, err = NewKeyInfo(string(text))
+	return
+}
+
+// Create the reference of the blob
+func NewBlobReference(bid BID, key KeyInfo) BlobReference {
+	return BlobReference{Bid: bid.String(), Key: key.String()}
+}
+
+// Get the checked blob id and key of the reference
+func (r BlobReference) Parse() (BID, KeyInfo, error) {
+	bid, err := NewBID(r.Bid)
+	if err != nil {
+		return BID{}, KeyInfo{}, err
+	}
+	key, err := NewKeyInfo(r.Key)
+	if err != nil {
+		return BID{}, KeyInfo{}, err
+	}
+	return bid, key, nil
+}
+
+// Typed forms of functions reading blobs, meant for blob ids and keys coming
+// from outside of the library such as command line arguments. The zero blob
+// id or key is rejected with ErrInvalidBID or ErrInvalidKey.
+//
+// Blob ids and keys within the library, in writer results, directory entries
+// and blob references, stay in their canonical string forms they are stored
+// in, BlobReference.Parse gives the checked values. Functions taking strings
+// are not deprecated, the typed ones check their arguments and call them.
+
+func checkReference(bid BID, key KeyInfo) error {
+	switch {
+	case bid.IsZero():
+		return ErrInvalidBID
+	case key.IsZero():
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// Open the file blob, see OpenFileBlob
+func OpenFile(bid BID, key KeyInfo, storage BlobStorage) (FileBlobReader, error) {
+	if err := checkReference(bid, key); err != nil {
+		return nil, err
+	}
+	return OpenFileBlob(bid.String(), key.String(), storage)
+}
+
+// Open the directory blob, see OpenDirBlob
+func OpenDir(bid BID, key KeyInfo, storage BlobStorage) (DirBlobReader, error) {
+	if err := checkReference(bid, key); err != nil {
+		return nil, err
+	}
+	return OpenDirBlob(bid.String(), key.String(), storage)
+}
+
+// Inspect the blob having its key, see InspectBlobWithKey
+func Inspect(bid BID, key KeyInfo, storage BlobStorage) (*BlobInfo, error) {
+	if err := checkReference(bid, key); err != nil {
+		return nil, err
+	}
+	return InspectBlobWithKey(bid.String(), key.String(), storage)
+}
+
+// Validate the blob, see ValidateBlob
+func Validate(bid BID, key KeyInfo, storage BlobStorage) error {
+	if err := checkReference(bid, key); err != nil {
+		return err
+	}
+	return ValidateBlob(bid.String(), key.String(), storage)
+}
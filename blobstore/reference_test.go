@@ -0,0 +1,111 @@
+package blobstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cinode/golib/cipherfactory"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBIDAndKeyInfo(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bid, err := NewBID(ref.Bid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bid.String() != ref.Bid || bid.HashAlgorithm() != cipherfactory.DefaultHashAlgorithm {
+		t.Fatalf("Invalid blob id: %v %v", bid, bid.HashAlgorithm())
+	}
+	key, err := NewKeyInfo(ref.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.String() != ref.Key || key.CipherAlgorithm() != cipherfactory.DefaultAlgorithm {
+		t.Fatalf("Invalid key: %v %v", key, key.CipherAlgorithm())
+	}
+
+	// Encoded forms give the same values
+	if encoded, err := NewBID(bid.Format(EncodingBase58)); err != nil || encoded != bid {
+		t.Fatalf("Invalid blob id from the encoded form: %v %v", encoded, err)
+	}
+	if encoded, err := NewKeyInfo(key.Format(EncodingBase32)); err != nil || encoded != key {
+		t.Fatalf("Invalid key from the encoded form: %v %v", encoded, err)
+	}
+
+	// Swapped blob ids and keys are rejected
+	if _, err = NewBID(ref.Key); err != ErrInvalidBID {
+		t.Fatalf("Key accepted as the blob id: %v", err)
+	}
+	if _, err = NewKeyInfo(ref.Bid); err == nil {
+		t.Fatal("Blob id accepted as the key")
+	}
+	for _, s := range []string{"", "xyz", "00"} {
+		if _, err = NewBID(s); err != ErrInvalidBID {
+			t.Fatalf("Invalid error for blob id %q: %v", s, err)
+		}
+		if _, err = NewKeyInfo(s); err == nil {
+			t.Fatalf("Invalid key %q accepted", s)
+		}
+	}
+
+	reader, err := OpenFile(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(data, []byte("Hello")) {
+		t.Fatalf("Invalid data read: %q %v", data, err)
+	}
+	if err = Validate(bid, key, storage); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := Inspect(bid, key, storage); err != nil || !info.IsFile() {
+		t.Fatalf("Invalid blob info: %v %v", info, err)
+	}
+	if _, err = OpenFile(BID{}, key, storage); err != ErrInvalidBID {
+		t.Fatalf("Invalid error for the zero blob id: %v", err)
+	}
+	if _, err = OpenDir(bid, KeyInfo{}, storage); err != ErrInvalidKey {
+		t.Fatalf("Invalid error for the zero key: %v", err)
+	}
+
+	// References
+	if parsedBid, parsedKey, err := NewBlobReference(bid, key).Parse(); err != nil || parsedBid != bid || parsedKey != key {
+		t.Fatalf("Invalid parsed reference: %v %v %v", parsedBid, parsedKey, err)
+	}
+	if _, _, err = (BlobReference{Bid: ref.Key, Key: ref.Bid}).Parse(); err != ErrInvalidBID {
+		t.Fatalf("Invalid error for the swapped reference: %v", err)
+	}
+
+	// Text marshaling
+	type pair struct {
+		Bid BID
+		Key KeyInfo
+	}
+	encoded, err := json.Marshal(pair{bid, key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded pair
+	if err = json.Unmarshal(encoded, &decoded); err != nil || decoded.Bid != bid || decoded.Key != key {
+		t.Fatalf("Invalid unmarshaled values: %v %v", decoded, err)
+	}
+	if err = json.Unmarshal([]byte(`{"Bid":"`+ref.Key+`"}`), &decoded); err == nil {
+		t.Fatal("Invalid blob id unmarshaled")
+	}
+	if err = json.Unmarshal([]byte(`{"Bid":"","Key":""}`), &decoded); err != nil || !decoded.Bid.IsZero() || !decoded.Key.IsZero() {
+		t.Fatalf("Empty values not unmarshaled as zero values: %v %v", decoded, err)
+	}
+
+	if _, err = NewKeyInfo("ff" + ref.Key[2:]); err != cipherfactory.ErrUnknownKeyType {
+		t.Fatalf("Invalid error for the unknown key type: %v", err)
+	}
+}
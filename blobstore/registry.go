@@ -0,0 +1,383 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+var (
+	ErrBlobTypeAlreadyRegistered = errors.New("Blob type has already been registered")
+	ErrUnknownBlobType           = errors.New("Unknown blob type")
+)
+
+// Reference to another blob
+type BlobReference struct {
+	Bid, Key string
+}
+
+// Handler of one blob type. Blob types are stored as the first value
+// of hash-validated blobs content, a handler is responsible for
+// interpreting the rest of the content.
+type BlobTypeHandler interface {
+
+	// Human-readable name of the blob type
+	Name() string
+
+	// Extract references to other blobs, content reader is positioned
+	// right after the blob type. Blobs reachable through references
+	// are considered alive by the garbage collector and are transferred
+	// together with this blob during synchronization.
+	References(content io.Reader) (refs []BlobReference, err error)
+
+	// Make sure the content of the blob is valid, content reader
+	// is positioned right after the blob type
+	Validate(content io.Reader) error
+}
+
+type registeredBlobType struct {
+	format  BlobFormat
+	handler BlobTypeHandler
+}
+
+var (
+	blobTypes     = make(map[int64]registeredBlobType)
+	blobTypesLock sync.RWMutex
+)
+
+// Register a handler for new blob type, the format is named after
+// the handler and gets the first version
+func RegisterBlobType(blobType int64, handler BlobTypeHandler) error {
+	return RegisterBlobFormat(BlobFormat{Type: blobType}, handler)
+}
+
+// Register a handler for the new blob format, the name of the handler
+// is used if the format has no name
+func RegisterBlobFormat(format BlobFormat, handler BlobTypeHandler) error {
+	blobTypesLock.Lock()
+	defer blobTypesLock.Unlock()
+
+	if _, exists := blobTypes[format.Type]; exists {
+		return ErrBlobTypeAlreadyRegistered
+	}
+
+	if format.Name == "" {
+		format.Name = handler.Name()
+	}
+	if format.Version == 0 {
+		format.Version = 1
+	}
+	format.Known = true
+	blobTypes[format.Type] = registeredBlobType{format: format, handler: handler}
+	return nil
+}
+
+// Find the handler for given blob type
+func LookupBlobType(blobType int64) (handler BlobTypeHandler, ok bool) {
+	blobTypesLock.RLock()
+	defer blobTypesLock.RUnlock()
+
+	registered, ok := blobTypes[blobType]
+	return registered.handler, ok
+}
+
+// Create a new hash-validated blob of given type
+func CreateTypedBlob(blobType int64, content []byte, storage BlobStorage) (bid, key string, err error) {
+	var hdr bytes.Buffer
+	serializeInt(blobType, &hdr)
+
+	return createHashValidatedBlobFromReaderGenerator(
+		func() io.Reader {
+			return io.MultiReader(
+				bytes.NewReader(hdr.Bytes()),
+				bytes.NewReader(content))
+		},
+		storage, nil, nil)
+}
+
+// Open a hash-validated blob, the returned reader is positioned right after the blob type.
+// The content of the blob is validated once the reader reaches EOF. The reader
+// implements io.Closer, it must be closed to release the storage reader.
+func OpenTypedBlob(bid, key string, storage BlobStorage) (blobType int64, content io.Reader, err error) {
+	reader := baseBlobReader{storage: storage}
+	content, blobType, err = reader.openInternal(bid, key, validationMethodHash)
+	return
+}
+
+// Get references to other blobs from the blob with given bid and key
+func GetBlobReferences(bid, key string, storage BlobStorage) (refs []BlobReference, err error) {
+	blobType, content, err := OpenTypedBlob(bid, key, storage)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader(content)
+
+	handler, ok := LookupBlobType(blobType)
+	if !ok {
+		return nil, ErrUnknownBlobType
+	}
+
+	return handler.References(content)
+}
+
+// Validate the blob with given bid and key, errors reporting invalid
+// content are returned as BlobCorruptedError. The blob is always read
+// from the storage, the decrypted content cache is not used.
+func ValidateBlob(bid, key string, storage BlobStorage) error {
+	reader := baseBlobReader{storage: storage, uncached: true}
+	content, blobType, err := reader.openInternal(bid, key, validationMethodHash)
+	if err != nil {
+		return blobCorrupted(bid, err)
+	}
+	defer closeReader(content)
+
+	handler, ok := LookupBlobType(blobType)
+	if !ok {
+		return ErrUnknownBlobType
+	}
+
+	return blobCorrupted(bid, handler.Validate(content))
+}
+
+// Handler of simple static file blobs
+type simpleFileHandler struct{}
+
+func (simpleFileHandler) Name() string {
+	return "simple static file"
+}
+
+func (simpleFileHandler) References(content io.Reader) ([]BlobReference, error) {
+	return nil, nil
+}
+
+func (simpleFileHandler) Validate(content io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, content)
+	return err
+}
+
+// Handler of compressed static file blobs
+type compressedFileHandler struct{}
+
+func (compressedFileHandler) Name() string {
+	return "compressed static file"
+}
+
+func (compressedFileHandler) References(content io.Reader) ([]BlobReference, error) {
+	return nil, nil
+}
+
+func (compressedFileHandler) Validate(content io.Reader) error {
+	reader, err := newDecompressingReader(content)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, reader)
+	return err
+}
+
+// Handler of split static file blobs
+type splitFileHandler struct{}
+
+func (splitFileHandler) Name() string {
+	return "split static file"
+}
+
+func (splitFileHandler) References(content io.Reader) ([]BlobReference, error) {
+	_, bids, keys, err := readSplitFileData(content)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]BlobReference, len(bids))
+	for i := range bids {
+		refs[i] = BlobReference{Bid: bids[i], Key: keys[i]}
+	}
+	return refs, nil
+}
+
+func (splitFileHandler) Validate(content io.Reader) error {
+	_, _, _, err := readSplitFileData(content)
+	return err
+}
+
+// Handler of chunked static file blobs
+type chunkedFileHandler struct{}
+
+func (chunkedFileHandler) Name() string {
+	return "chunked static file"
+}
+
+func (chunkedFileHandler) References(content io.Reader) ([]BlobReference, error) {
+	_, _, bids, keys, err := readChunkedFileData(content)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]BlobReference, len(bids))
+	for i := range bids {
+		refs[i] = BlobReference{Bid: bids[i], Key: keys[i]}
+	}
+	return refs, nil
+}
+
+func (chunkedFileHandler) Validate(content io.Reader) error {
+	_, _, _, _, err := readChunkedFileData(content)
+	return err
+}
+
+// Handler of simple static directory blobs
+type simpleDirHandler struct {
+	extended bool // Entries are typed
+}
+
+func (h simpleDirHandler) Name() string {
+	if h.extended {
+		return "simple static directory v2"
+	}
+	return "simple static directory"
+}
+
+func (h simpleDirHandler) References(content io.Reader) ([]BlobReference, error) {
+	entries, err := readSimpleDirData(content, h.extended)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only blob entries reference other blobs
+	refs := make([]BlobReference, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsBlob() {
+			refs = append(refs, BlobReference{Bid: entry.Bid, Key: entry.Key})
+		}
+	}
+	return refs, nil
+}
+
+func (h simpleDirHandler) Validate(content io.Reader) error {
+	_, err := readSimpleDirData(content, h.extended)
+	return err
+}
+
+// Handler of split static directory blobs
+type splitDirHandler struct{}
+
+func (splitDirHandler) Name() string {
+	return "split static directory"
+}
+
+func (splitDirHandler) References(content io.Reader) ([]BlobReference, error) {
+	_, parts, err := readSplitDirData(content)
+	return parts, err
+}
+
+func (splitDirHandler) Validate(content io.Reader) error {
+	_, _, err := readSplitDirData(content)
+	return err
+}
+
+// Handler of chunked static directory blobs
+type chunkedDirHandler struct{}
+
+func (chunkedDirHandler) Name() string {
+	return "chunked static directory"
+}
+
+func (chunkedDirHandler) References(content io.Reader) ([]BlobReference, error) {
+	_, _, parts, err := readChunkedDirData(content)
+	return parts, err
+}
+
+func (chunkedDirHandler) Validate(content io.Reader) error {
+	_, _, _, err := readChunkedDirData(content)
+	return err
+}
+
+// Handler of commit blobs
+type commitHandler struct{}
+
+func (commitHandler) Name() string {
+	return "commit"
+}
+
+func (commitHandler) References(content io.Reader) ([]BlobReference, error) {
+	commit, err := readCommitData(content)
+	if err != nil {
+		return nil, err
+	}
+	return append([]BlobReference{commit.Root}, commit.Parents...), nil
+}
+
+func (commitHandler) Validate(content io.Reader) error {
+	_, err := readCommitData(content)
+	return err
+}
+
+// Handler of link blobs
+type linkHandler struct{}
+
+func (linkHandler) Name() string {
+	return "link"
+}
+
+func (linkHandler) References(content io.Reader) ([]BlobReference, error) {
+	link, err := readLinkData(content)
+	if err != nil {
+		return nil, err
+	}
+	return []BlobReference{link.Target}, nil
+}
+
+func (linkHandler) Validate(content io.Reader) error {
+	_, err := readLinkData(content)
+	return err
+}
+
+// Read all entries of the simple directory blob, the reader
+// must be positioned right after the blob type
+func readSimpleDirData(content io.Reader, extended bool) (entries []DirEntry, err error) {
+	count, err := deserializeInt(content)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || count > maxSimpleDirEntries {
+		return nil, ErrMalformedDirInvalidEntriesCount
+	}
+
+	entries = make([]DirEntry, count)
+	for i := range entries {
+		if extended {
+			err = entries[i].deserializeExtended(content)
+		} else {
+			err = entries[i].deserialize(content)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = checkEOF(content, ErrMalformedDirExtraData); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func init() {
+	RegisterBlobFormat(BlobFormat{Type: blobTypeSimpleStaticFile, Kind: BlobKindFile}, simpleFileHandler{})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeSplitStaticFile, Kind: BlobKindFile}, splitFileHandler{})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeChunkedStaticFile, Kind: BlobKindFile}, chunkedFileHandler{})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeCompressedStaticFile, Kind: BlobKindFile}, compressedFileHandler{})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeSimpleStaticDir, Kind: BlobKindDir}, simpleDirHandler{})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeSimpleStaticDirV2, Name: "simple static directory", Kind: BlobKindDir, Version: 2},
+		simpleDirHandler{extended: true})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeSplitStaticDir, Kind: BlobKindDir}, splitDirHandler{})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeChunkedStaticDir, Kind: BlobKindDir}, chunkedDirHandler{})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeCommit, Kind: BlobKindCommit}, commitHandler{})
+	RegisterBlobFormat(BlobFormat{Type: blobTypeLink, Kind: BlobKindLink}, linkHandler{})
+}
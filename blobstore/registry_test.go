@@ -0,0 +1,139 @@
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+var errTestInvalidLink = errors.New("Invalid test link")
+
+// Test blob type holding a single reference to other blob
+type testLinkHandler struct{}
+
+func (testLinkHandler) Name() string {
+	return "test link"
+}
+
+func (testLinkHandler) References(content io.Reader) ([]BlobReference, error) {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	parts := bytes.SplitN(data, []byte{':'}, 2)
+	if len(parts) != 2 {
+		return nil, errTestInvalidLink
+	}
+	return []BlobReference{{Bid: string(parts[0]), Key: string(parts[1])}}, nil
+}
+
+func (h testLinkHandler) Validate(content io.Reader) error {
+	_, err := h.References(content)
+	return err
+}
+
+const testLinkBlobType = 0x7F
+
+func TestCustomBlobType(t *testing.T) {
+
+	if err := RegisterBlobType(testLinkBlobType, testLinkHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterBlobType(testLinkBlobType, testLinkHandler{}); err != ErrBlobTypeAlreadyRegistered {
+		t.Fatalf("Invalid error for duplicated registration: %v", err)
+	}
+	if err := RegisterBlobType(blobTypeSimpleStaticFile, testLinkHandler{}); err != ErrBlobTypeAlreadyRegistered {
+		t.Fatalf("Built-in blob type overwritten: %v", err)
+	}
+
+	storage := NewMemoryBlobStorage()
+	bid, key, err := CreateTypedBlob(testLinkBlobType, []byte("target:targetkey"), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobType, content, err := OpenTypedBlob(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blobType != testLinkBlobType {
+		t.Fatalf("Invalid blob type: %v", blobType)
+	}
+	if data, _ := ioutil.ReadAll(content); string(data) != "target:targetkey" {
+		t.Fatalf("Invalid blob content: %v", string(data))
+	}
+
+	refs, err := GetBlobReferences(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Bid != "target" || refs[0].Key != "targetkey" {
+		t.Fatalf("Invalid references: %v", refs)
+	}
+
+	if err = ValidateBlob(bid, key, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	bid, key, err = CreateTypedBlob(testLinkBlobType, []byte("invalid"), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateBlob(bid, key, storage); err != errTestInvalidLink {
+		t.Fatalf("Invalid blob validated: %v", err)
+	}
+
+	bid, key, err = CreateTypedBlob(0x7E, nil, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetBlobReferences(bid, key, storage); err != ErrUnknownBlobType {
+		t.Fatalf("Invalid error for unknown blob type: %v", err)
+	}
+}
+
+func TestBuiltinBlobReferences(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	fileRef, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileBid, fileKey := fileRef.Bid, fileRef.Key
+
+	refs, err := GetBlobReferences(fileBid, fileKey, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 0 {
+		t.Fatalf("Simple file must not contain references: %v", refs)
+	}
+
+	dw := DirBlobWriter{Storage: storage}
+	dw.AddEntry(DirEntry{Name: "a", Bid: "bid-a", Key: "key-a"})
+	dw.AddEntry(DirEntry{Name: "b", Bid: "bid-b", Key: "key-b"})
+	dirRef, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirBid, dirKey := dirRef.Bid, dirRef.Key
+
+	refs, err = GetBlobReferences(dirBid, dirKey, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Bid != "bid-a" || refs[1].Key != "key-b" {
+		t.Fatalf("Invalid directory references: %v", refs)
+	}
+
+	for _, ref := range []BlobReference{{fileBid, fileKey}, {dirBid, dirKey}} {
+		if err = ValidateBlob(ref.Bid, ref.Key, storage); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
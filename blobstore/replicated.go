@@ -0,0 +1,182 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"io"
+)
+
+// ReplicatedBlobStorage keeps copies of blobs in several storages. Writes
+// go to all replicas, reads are served by the first replica holding the blob.
+//
+// With read repair enabled, blobs are read as a whole and verified. Replicas
+// holding corrupted copies or missing the blob get the first valid copy
+// written back, turning every read into an opportunistic repair.
+type ReplicatedBlobStorage struct {
+	replicas []BlobStorage
+
+	// Verify blobs read from replicas and rewrite failing copies
+	ReadRepair bool
+}
+
+// Create new replicated storage, replicas are read in the given order
+func NewReplicatedBlobStorage(replicas ...BlobStorage) *ReplicatedBlobStorage {
+	return &ReplicatedBlobStorage{replicas: replicas}
+}
+
+func (r *ReplicatedBlobStorage) NewBlobWriter(blobId string) (writer WriteFinalizeCanceler, err error) {
+	w := &replicatedWriter{}
+	for _, replica := range r.replicas {
+		writer, err := replica.NewBlobWriter(blobId)
+		if err != nil {
+			w.Cancel()
+			return nil, err
+		}
+		w.writers = append(w.writers, writer)
+	}
+	return w, nil
+}
+
+func (r *ReplicatedBlobStorage) NewBlobReader(blobId string) (reader io.Reader, err error) {
+	if r.ReadRepair {
+		return r.readRepair(blobId)
+	}
+
+	err = ErrBIDNotFound
+	for _, replica := range r.replicas {
+		if reader, err = replica.NewBlobReader(blobId); err == nil {
+			return reader, nil
+		}
+	}
+	return nil, err
+}
+
+// Find the first valid copy of the blob and write it to replicas which failed
+func (r *ReplicatedBlobStorage) readRepair(blobId string) (reader io.Reader, err error) {
+
+	var failed []BlobStorage
+	err = ErrBIDNotFound
+	for _, replica := range r.replicas {
+		var data []byte
+		if data, err = readVerifiedBlob(replica, blobId); err != nil {
+			failed = append(failed, replica)
+			continue
+		}
+
+		// Repair is best-effort, the read succeeds anyway
+		for _, f := range failed {
+			f.Delete(blobId)
+			writeBlob(f, blobId, data)
+		}
+		return bytes.NewReader(data), nil
+	}
+	return nil, err
+}
+
+func (r *ReplicatedBlobStorage) Exists(blobId string) (bool, error) {
+	var lastErr error
+	for _, replica := range r.replicas {
+		exists, err := replica.Exists(blobId)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if exists {
+			return true, nil
+		}
+	}
+	return false, lastErr
+}
+
+// Metadata of the first replica holding the blob is returned
+func (r *ReplicatedBlobStorage) Stat(blobId string) (BlobStat, error) {
+	lastErr := ErrBIDNotFound
+	for _, replica := range r.replicas {
+		stat, err := Stat(replica, blobId)
+		if err == nil {
+			return stat, nil
+		}
+		if err != ErrBIDNotFound {
+			lastErr = err
+		}
+	}
+	return BlobStat{}, lastErr
+}
+
+func (r *ReplicatedBlobStorage) Delete(blobId string) error {
+	err := ErrBIDNotFound
+	for _, replica := range r.replicas {
+		switch e := replica.Delete(blobId); e {
+		case nil:
+			if err == ErrBIDNotFound {
+				err = nil
+			}
+		case ErrBIDNotFound:
+		default:
+			err = e
+		}
+	}
+	return err
+}
+
+// Writer storing the blob in all replicas
+type replicatedWriter struct {
+	writers []WriteFinalizeCanceler
+}
+
+func (w *replicatedWriter) Write(p []byte) (n int, err error) {
+	for _, writer := range w.writers {
+		if n, err = writer.Write(p); err != nil {
+			return
+		}
+	}
+	return len(p), nil
+}
+
+// The blob is a duplicate only if all replicas had it already
+func (w *replicatedWriter) Finalize() (duplicate bool, err error) {
+	duplicate = true
+	for _, writer := range w.writers {
+		dup, e := writer.Finalize()
+		if e != nil && err == nil {
+			err = e
+		}
+		duplicate = duplicate && dup
+	}
+	if err != nil {
+		return false, err
+	}
+	return duplicate, nil
+}
+
+func (w *replicatedWriter) Cancel() error {
+	for _, writer := range w.writers {
+		writer.Cancel()
+	}
+	return nil
+}
+
+// Replicas failed to finalize are canceled, finalized ones are kept
+func (w *replicatedWriter) Close() error {
+	for _, writer := range w.writers {
+		writer.Close()
+	}
+	return nil
+}
+
+// Store the blob from memory
+func writeBlob(storage BlobStorage, blobId string, data []byte) error {
+	writer, err := storage.NewBlobWriter(blobId)
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(data); err != nil {
+		writer.Cancel()
+		return err
+	}
+	_, err = writer.Finalize()
+	return err
+}
@@ -0,0 +1,126 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestVerifyBlob(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	hashBid, _, err := createHashValidatedBlobFromReaderGenerator(func() io.Reader {
+		return bytes.NewReader([]byte("Hello"))
+	}, storage, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, privKey, _ := ed25519.GenerateKey(rand.Reader)
+	signedBid, _, err := CreateSignedBlob(privKey, 1, []byte("Hello"), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bid := range []string{hashBid, signedBid} {
+		reader, _ := storage.NewBlobReader(bid)
+		if err = VerifyBlob(bid, reader); err != nil {
+			t.Fatalf("Valid blob not verified: %v", err)
+		}
+		corruptBlobAt(t, storage, bid, -1)
+		reader, _ = storage.NewBlobReader(bid)
+		if err = VerifyBlob(bid, reader); err == nil {
+			t.Fatal("Corrupted blob verified")
+		}
+	}
+}
+
+func TestReplicatedReadRepair(t *testing.T) {
+
+	replicas := []BlobStorage{NewMemoryBlobStorage(), NewMemoryBlobStorage(), NewMemoryBlobStorage()}
+	replicated := NewReplicatedBlobStorage(replicas...)
+
+	fw := FileBlobWriter{Storage: replicated}
+	fw.Write([]byte("Hello World!"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+	for _, replica := range replicas {
+		if exists, _ := replica.Exists(bid); !exists {
+			t.Fatal("Blob not written to all replicas")
+		}
+	}
+
+	// Corrupted and missing copies are repaired only when enabled
+	corruptBlobAt(t, replicas[0], bid, -1)
+	replicas[1].Delete(bid)
+	raw, err := replicated.NewBlobReader(bid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyBlob(bid, raw) == nil {
+		t.Fatal("Corrupted copy not served without read repair")
+	}
+
+	replicated.ReadRepair = true
+	reader, err := OpenFileBlob(bid, key, replicated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "Hello World!" {
+		t.Fatalf("Invalid data read: %q, %v", data, err)
+	}
+	for i, replica := range replicas {
+		reader, err := replica.NewBlobReader(bid)
+		if err != nil {
+			t.Fatalf("Blob not repaired in replica %v: %v", i, err)
+		}
+		if err = VerifyBlob(bid, reader); err != nil {
+			t.Fatalf("Invalid blob in replica %v: %v", i, err)
+		}
+	}
+
+	if err = replicated.Delete(bid); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := replicated.Exists(bid); exists {
+		t.Fatal("Blob not deleted")
+	}
+	if err = replicated.Delete(bid); err != ErrBIDNotFound {
+		t.Fatalf("Invalid error for deleting missing blob: %v", err)
+	}
+}
+
+func TestLayeredReadRepair(t *testing.T) {
+
+	cache := NewMemoryBlobStorage()
+	remote := NewMemoryBlobStorage()
+	layered := NewLayeredBlobStorage(cache, remote, 1000)
+	layered.ReadRepair = true
+
+	fw := FileBlobWriter{Storage: layered}
+	fw.Write([]byte("Hello World!"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+
+	corruptBlobAt(t, cache, bid, -1)
+	reader, err := OpenFileBlob(bid, key, layered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "Hello World!" {
+		t.Fatalf("Invalid data read: %q, %v", data, err)
+	}
+
+	reader2, _ := cache.NewBlobReader(bid)
+	if err = VerifyBlob(bid, reader2); err != nil {
+		t.Fatalf("Cached copy not repaired: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNotDirectory = errors.New("Path component is not a directory")
+)
+
+// Error of the path resolution, the path ends with the component
+// which couldn't be resolved
+type ResolveError struct {
+	Path string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// Find the entry at the slash-separated path within the directory blob
+// tree, empty components are ignored and the root directory is returned
+// as an entry with an empty name for an empty path. Directories are
+// searched with Lookup, only blobs on the path are read. Symbolic links
+// are not followed. Failures are reported as *ResolveError wrapping
+// ErrDirEntryNotFound for missing components, ErrNotDirectory for files
+// and links in the middle of the path or the error of the storage.
+func Resolve(bid, key, path string, storage BlobStorage) (DirEntry, error) {
+	entry := DirEntry{Bid: bid, Key: key}
+	reader := NewDirBlobReader(storage)
+	defer reader.Close()
+
+	var resolved []string
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		resolved = append(resolved, name)
+		fail := func(err error) (DirEntry, error) {
+			return DirEntry{}, &ResolveError{Path: strings.Join(resolved, "/"), Err: err}
+		}
+
+		if !entry.IsBlob() {
+			return fail(ErrNotDirectory)
+		}
+		err := reader.Open(entry.Bid, entry.Key)
+		if err == ErrInvalidFileBlobType {
+			err = ErrNotDirectory
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if entry, err = reader.Lookup(name); err != nil {
+			return fail(err)
+		}
+	}
+	return entry, nil
+}
@@ -0,0 +1,73 @@
+package blobstore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	file, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := DirBlobWriter{Storage: storage}
+	c.AddEntry(DirEntry{Name: "c.txt", Bid: file.Bid, Key: file.Key})
+	cRef, _ := c.Finalize()
+
+	b := DirBlobWriter{Storage: storage}
+	for _, entry := range genEntries(maxSimpleDirEntries + 1) {
+		b.AddEntry(entry)
+	}
+	b.AddEntry(DirEntry{Name: "b", Bid: cRef.Bid, Key: cRef.Key})
+	b.AddEntry(DirEntry{Name: "link", Type: EntryTypeSymlink, Target: "b"})
+	bRef, _ := b.Finalize()
+
+	a := DirBlobWriter{Storage: storage}
+	a.AddEntry(DirEntry{Name: "a", Bid: bRef.Bid, Key: bRef.Key})
+	root, err := a.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []struct {
+		path, name, bid string
+	}{
+		{"", "", root.Bid},
+		{"/", "", root.Bid},
+		{"a", "a", bRef.Bid},
+		{"a/b/", "b", cRef.Bid},
+		{"/a//b/c.txt", "c.txt", file.Bid},
+		{"a/file00007.txt", "file00007.txt", "bid7"},
+		{"a/link", "link", ""},
+	} {
+		entry, err := Resolve(root.Bid, root.Key, d.path, storage)
+		if err != nil {
+			t.Fatalf("Couldn't resolve %q: %v", d.path, err)
+		}
+		if entry.Name != d.name || entry.Bid != d.bid {
+			t.Fatalf("Invalid entry at %q: %+v", d.path, entry)
+		}
+	}
+
+	for _, d := range []struct {
+		path, failed string
+		err          error
+	}{
+		{"b", "b", ErrDirEntryNotFound},
+		{"a/b/missing/c.txt", "a/b/missing", ErrDirEntryNotFound},
+		{"a/b/c.txt/d", "a/b/c.txt/d", ErrNotDirectory},
+		{"a/link/c.txt", "a/link/c.txt", ErrNotDirectory},
+		{"a/file00007.txt/x", "a/file00007.txt/x", ErrBIDNotFound},
+	} {
+		_, err := Resolve(root.Bid, root.Key, d.path, storage)
+		var resolveErr *ResolveError
+		if !errors.As(err, &resolveErr) || resolveErr.Path != d.failed || !errors.Is(err, d.err) {
+			t.Fatalf("Invalid error resolving %q: %v", d.path, err)
+		}
+	}
+}
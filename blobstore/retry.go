@@ -0,0 +1,360 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/cinode/golib/utils"
+	"io"
+	"time"
+)
+
+// Policy of retrying failed operations of the storage. Delays between
+// attempts grow exponentially from InitialDelay up to MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts  int           // Attempts of each operation including the first one, 3 if 0
+	InitialDelay time.Duration // Delay before the first retry, 100ms if 0
+	MaxDelay     time.Duration // Upper bound of the delay, 10s if 0
+	Multiplier   float64       // Growth of the delay after each retry, 2 if 0
+
+	// Check whether the operation failing with the error may succeed once
+	// it's retried, errors matching ErrStorageUnavailable are retried if nil
+	Retryable func(err error) bool
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return 3
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) initialDelay() time.Duration {
+	if p.InitialDelay <= 0 {
+		return 100 * time.Millisecond
+	}
+	return p.InitialDelay
+}
+
+func (p *RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay <= 0 {
+		return 10 * time.Second
+	}
+	return p.MaxDelay
+}
+
+// Get the delay following given one
+func (p *RetryPolicy) nextDelay(delay time.Duration) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	delay = time.Duration(float64(delay) * multiplier)
+	if delay > p.maxDelay() {
+		delay = p.maxDelay()
+	}
+	return delay
+}
+
+// Get the delay before retrying the operation which failed with the error,
+// the delay requested by the error is kept even if it exceeds MaxDelay
+func (p *RetryPolicy) retryDelay(delay time.Duration, err error) time.Duration {
+	var delayer RetryDelayer
+	if errors.As(err, &delayer) && delayer.RetryDelay() > delay {
+		return delayer.RetryDelay()
+	}
+	return delay
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return errors.Is(err, ErrStorageUnavailable)
+}
+
+// Optional interface of errors of storages asking to wait before the
+// operation is retried, i.e. of overloaded servers
+type RetryDelayer interface {
+
+	// Get the minimum delay before the next attempt
+	RetryDelay() time.Duration
+}
+
+// Storage wrapper retrying operations which failed with transient errors,
+// i.e. of remote storages which could not be reached. Readers failing in
+// the middle of the blob are reopened at the position reached, through
+// range reads if the storage supports them. Content of blobs being written
+// is kept in memory until they're finalized so that it can be sent again.
+// Retried deletions may report ErrBIDNotFound if the blob was deleted by
+// the failed attempt. Delays requested by errors, see RetryDelayer,
+// are respected.
+type RetryingStorage struct {
+	BlobStorage
+
+	Policy RetryPolicy
+
+	// Source of time for delays between attempts
+	Clock utils.Clock
+
+	// Context interrupting delays, see WithContext
+	ctx context.Context
+}
+
+// Get the wrapped storage
+func (r *RetryingStorage) Unwrap() BlobStorage {
+	return r.BlobStorage
+}
+
+// Wrap the storage retrying its operations according to the policy
+func NewRetryingStorage(storage BlobStorage, policy RetryPolicy) *RetryingStorage {
+	return &RetryingStorage{BlobStorage: storage, Policy: policy, Clock: utils.SystemClock}
+}
+
+// Run the operation until it succeeds, fails with the error which is not
+// transient or runs out of attempts, the last error is returned. The context
+// error is returned if the context is done while waiting for the retry.
+func (r *RetryingStorage) retry(op func() error) error {
+	delay := r.Policy.initialDelay()
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= r.Policy.maxAttempts() || !r.Policy.retryable(err) {
+			return err
+		}
+		if err = r.sleep(r.Policy.retryDelay(delay, err)); err != nil {
+			return err
+		}
+		delay = r.Policy.nextDelay(delay)
+	}
+}
+
+// Wait before the next attempt until the context is done
+func (r *RetryingStorage) sleep(delay time.Duration) error {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return r.Clock.Sleep(ctx, delay)
+}
+
+func (r *RetryingStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	var reader io.Reader
+	err := r.retry(func() (err error) {
+		reader, err = r.BlobStorage.NewBlobReader(blobId)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &retryingReader{storage: r, bid: blobId, reader: reader, length: -1}, nil
+}
+
+func (r *RetryingStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	var reader io.Reader
+	err := r.retry(func() (err error) {
+		reader, err = NewBlobReaderRange(r.BlobStorage, blobId, offset, length)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &retryingReader{storage: r, bid: blobId, reader: reader, offset: offset, length: length}, nil
+}
+
+func (r *RetryingStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	var writer WriteFinalizeCanceler
+	err := r.retry(func() (err error) {
+		writer, err = r.BlobStorage.NewBlobWriter(blobId)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &retryingWriter{storage: r, bid: blobId, writer: writer}, nil
+}
+
+func (r *RetryingStorage) Exists(blobId string) (exists bool, err error) {
+	err = r.retry(func() (err error) {
+		exists, err = r.BlobStorage.Exists(blobId)
+		return
+	})
+	return
+}
+
+func (r *RetryingStorage) Delete(blobId string) error {
+	return r.retry(func() error {
+		return r.BlobStorage.Delete(blobId)
+	})
+}
+
+func (r *RetryingStorage) ListBlobs(prefix, cursor string, limit int) (blobs []StoredBlob, next string, err error) {
+	err = r.retry(func() (err error) {
+		blobs, next, err = ListBlobs(r.BlobStorage, prefix, cursor, limit)
+		return
+	})
+	return
+}
+
+func (r *RetryingStorage) ExistsBatch(blobIds []string) (existing map[string]bool, err error) {
+	err = r.retry(func() (err error) {
+		existing, err = ExistsBatch(r.BlobStorage, blobIds)
+		return
+	})
+	return
+}
+
+func (r *RetryingStorage) Stat(blobId string) (stat BlobStat, err error) {
+	err = r.retry(func() (err error) {
+		stat, err = Stat(r.BlobStorage, blobId)
+		return
+	})
+	return
+}
+
+func (r *RetryingStorage) Capabilities() Capabilities {
+	return StorageCapabilities(r.BlobStorage)
+}
+
+// Operations of the wrapped storage are bound to the context, they're
+// not retried once the context is done
+func (r *RetryingStorage) WithContext(ctx context.Context) BlobStorage {
+	bound := *r
+	bound.BlobStorage = WithContext(ctx, r.BlobStorage)
+	bound.ctx = ctx
+	return &bound
+}
+
+// Reader of the blob reopened at the position reached once it fails
+type retryingReader struct {
+	storage        *RetryingStorage
+	bid            string
+	reader         io.Reader
+	offset, length int64         // Range of the blob left to read, negative length up to the end
+	failures       int           // Number of failures since the data was read last time
+	delay          time.Duration // Delay before reopening the blob
+}
+
+func (r *retryingReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.offset += int64(n)
+	if r.length >= 0 {
+		r.length -= int64(n)
+	}
+	if n > 0 {
+		r.failures = 0
+	}
+	if err == nil || err == io.EOF {
+		return
+	}
+
+	if err = r.reopen(err); err != nil || n > 0 {
+		return n, err
+	}
+	return r.Read(p)
+}
+
+// Reopen the blob at the position reached, the failed read counts as
+// an attempt as well as each read failing before any data is read
+func (r *retryingReader) reopen(err error) error {
+	closeReader(r.reader)
+	if r.failures == 0 {
+		r.delay = r.storage.Policy.initialDelay()
+	}
+	for {
+		r.failures++
+		if r.failures >= r.storage.Policy.maxAttempts() || !r.storage.Policy.retryable(err) {
+			r.reader = &failedReader{err: err}
+			return err
+		}
+		if err = r.storage.sleep(r.storage.Policy.retryDelay(r.delay, err)); err != nil {
+			r.reader = &failedReader{err: err}
+			return err
+		}
+		r.delay = r.storage.Policy.nextDelay(r.delay)
+		if r.reader, err = NewBlobReaderRange(r.storage.BlobStorage, r.bid, r.offset, r.length); err == nil {
+			return nil
+		}
+	}
+}
+
+func (r *retryingReader) Close() error {
+	if closer, ok := r.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Reader failing with the same error every time
+type failedReader struct {
+	err error
+}
+
+func (f *failedReader) Read(p []byte) (int, error) {
+	return 0, f.err
+}
+
+// Writer keeping the content of the blob so that it can be written
+// again if the write fails
+type retryingWriter struct {
+	storage *RetryingStorage
+	bid     string
+	writer  WriteFinalizeCanceler // Writer of the current attempt, nil if it failed
+	buffer  bytes.Buffer
+}
+
+func (w *retryingWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	if w.writer == nil {
+		return len(p), nil
+	}
+	if _, err := w.writer.Write(p); err != nil {
+		w.writer.Cancel()
+		w.writer = nil
+		if !w.storage.Policy.retryable(err) {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *retryingWriter) Finalize() (duplicate bool, err error) {
+	err = w.storage.retry(func() (err error) {
+		if w.writer == nil {
+			if w.writer, err = w.storage.BlobStorage.NewBlobWriter(w.bid); err != nil {
+				return
+			}
+			if _, err = w.writer.Write(w.buffer.Bytes()); err != nil {
+				w.writer.Cancel()
+				w.writer = nil
+				return
+			}
+		}
+		if duplicate, err = w.writer.Finalize(); err != nil {
+			w.writer.Cancel()
+			w.writer = nil
+		}
+		return
+	})
+	w.buffer = bytes.Buffer{}
+	w.writer = nil
+	return
+}
+
+func (w *retryingWriter) Cancel() error {
+	w.buffer = bytes.Buffer{}
+	if w.writer == nil {
+		return nil
+	}
+	err := w.writer.Cancel()
+	w.writer = nil
+	return err
+}
+
+// Writers of attempts are released by Finalize, there's nothing left then
+func (w *retryingWriter) Close() error {
+	return w.Cancel()
+}
@@ -0,0 +1,183 @@
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cinode/golib/utils"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+var errFlaky = &StorageUnavailableError{Err: errors.New("connection reset")}
+
+// Storage failing the given number of operations before passing them through,
+// readers fail after sending readLimit bytes or at once if it's negative
+type flakyStorage struct {
+	BlobStorage
+	failures  int
+	readLimit int
+	opens     int
+}
+
+func (f *flakyStorage) fail() error {
+	if f.failures > 0 {
+		f.failures--
+		return errFlaky
+	}
+	return nil
+}
+
+func (f *flakyStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	return f.NewBlobReaderRange(blobId, 0, -1)
+}
+
+func (f *flakyStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	f.opens++
+	reader, err := NewBlobReaderRange(f.BlobStorage, blobId, offset, length)
+	if err != nil || f.readLimit == 0 {
+		return reader, err
+	}
+	if f.readLimit < 0 {
+		closeReader(reader)
+		return &failedReader{err: errFlaky}, nil
+	}
+	return &flakyReader{reader: reader, left: f.readLimit}, nil
+}
+
+// Reader failing once the limit is reached before the end of the blob
+type flakyReader struct {
+	reader io.Reader
+	left   int
+}
+
+func (f *flakyReader) Read(p []byte) (int, error) {
+	if f.left == 0 {
+		if n, _ := f.reader.Read(make([]byte, 1)); n > 0 {
+			return 0, errFlaky
+		}
+		return 0, io.EOF
+	}
+	if len(p) > f.left {
+		p = p[:f.left]
+	}
+	n, err := f.reader.Read(p)
+	f.left -= n
+	return n, err
+}
+
+func (f *flakyStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	writer, err := f.BlobStorage.NewBlobWriter(blobId)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyWriter{WriteFinalizeCanceler: writer, storage: f}, nil
+}
+
+func (f *flakyStorage) Exists(blobId string) (bool, error) {
+	if err := f.fail(); err != nil {
+		return false, err
+	}
+	return f.BlobStorage.Exists(blobId)
+}
+
+type flakyWriter struct {
+	WriteFinalizeCanceler
+	storage *flakyStorage
+}
+
+func (w *flakyWriter) Finalize() (bool, error) {
+	if err := w.storage.fail(); err != nil {
+		w.Cancel()
+		return false, err
+	}
+	return w.WriteFinalizeCanceler.Finalize()
+}
+
+func TestRetryingStorage(t *testing.T) {
+
+	backend := &flakyStorage{BlobStorage: NewMemoryBlobStorage()}
+	clock := utils.NewManualClock(time.Unix(0, 0))
+	storage := NewRetryingStorage(backend, RetryPolicy{MaxAttempts: 4, InitialDelay: time.Second, MaxDelay: 3 * time.Second})
+	storage.Clock = clock
+
+	// Delays grow exponentially up to the limit
+	backend.failures = 3
+	writer, err := storage.NewBlobWriter("blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed != 6*time.Second {
+		t.Fatalf("Invalid delays between attempts: %v", elapsed)
+	}
+
+	// Content of the blob is written again once finalizing fails
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	writer.Write(data)
+	backend.failures = 2
+	if duplicate, err := writer.Finalize(); err != nil || duplicate {
+		t.Fatalf("Blob not written after retries: %v %v", duplicate, err)
+	}
+
+	// Readers continue from the position reached
+	backend.readLimit, backend.opens = 300, 0
+	reader, err := storage.NewBlobReader("blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("Invalid data read with retries: %v", err)
+	}
+	if backend.opens != 4 {
+		t.Fatalf("Invalid number of reopened readers: %v", backend.opens)
+	}
+
+	reader, err = storage.NewBlobReaderRange("blob", 100, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err = ioutil.ReadAll(reader); err != nil || !bytes.Equal(read, data[100:600]) {
+		t.Fatalf("Invalid range read with retries: %v", err)
+	}
+	backend.readLimit = 0
+
+	// Attempts are limited
+	backend.failures = 4
+	if _, err = storage.Exists("blob"); err != errFlaky {
+		t.Fatalf("Invalid error once attempts are exhausted: %v", err)
+	}
+	backend.failures = 3
+	if exists, err := storage.Exists("blob"); err != nil || !exists {
+		t.Fatalf("Invalid existence after retries: %v %v", exists, err)
+	}
+
+	// Readers failing without progress run out of attempts too
+	backend.readLimit = -1
+	reader, _ = storage.NewBlobReader("blob")
+	if _, err = ioutil.ReadAll(reader); err != errFlaky {
+		t.Fatalf("Invalid error of the failing reader: %v", err)
+	}
+	backend.readLimit = 0
+
+	// Other errors are not retried
+	backend.failures = 1
+	storage.Policy.Retryable = func(err error) bool { return false }
+	if _, err = storage.Exists("blob"); err != errFlaky {
+		t.Fatalf("Not retryable error retried: %v", err)
+	}
+	storage.Policy.Retryable = nil
+	if _, err = storage.NewBlobReader("missing"); err != ErrBIDNotFound {
+		t.Fatalf("Invalid error of the missing blob: %v", err)
+	}
+	if StorageCapabilities(storage) != StorageCapabilities(backend) {
+		t.Fatal("Capabilities of the backend not passed through")
+	}
+}
@@ -6,30 +6,29 @@ package blobstore
 
 import (
 	"bytes"
-	"errors"
+	"github.com/cinode/golib/blobstore/wire"
 	"io"
-	"unicode/utf8"
 )
 
 var (
-	ErrDeserializeStringToLarge = errors.New("Could not deserialize string value due to invalid length")
-	ErrDeserializeStringNotUTF8 = errors.New("Could not deserialize string value - not a UTF-8 sequence")
+	ErrDeserializeStringToLarge = corruption("Could not deserialize string value due to invalid length")
+	ErrDeserializeStringNotUTF8 = corruption("Could not deserialize string value - not a UTF-8 sequence")
+	ErrDeserializeIntOverflow   = corruption("Could not deserialize integer value - it does not fit in 64 bits")
 )
 
-func serializeInt(v int64, buff *bytes.Buffer) {
-	for {
-		b := byte(v & 0x7F)
-		v = v >> 7
-		if v != 0 {
-			b |= 0x80
-		}
+// Values are encoded with the wire package, errors of the wire package
+// are reported as corruption of the blob
 
-		buff.WriteByte(b)
+func serializeInt(v int64, buff *bytes.Buffer) {
+	var b [wire.MaxIntLen]byte
+	buff.Write(wire.AppendInt(b[:0], v))
+}
 
-		if v == 0 {
-			break
-		}
-	}
+// Serialize the signed integer, it's zigzag-encoded
+// so that small negative values stay short
+func serializeVarint(v int64, buff *bytes.Buffer) {
+	var b [wire.MaxIntLen]byte
+	buff.Write(wire.AppendVarint(b[:0], v))
 }
 
 func serializeBuffer(data []byte, buff *bytes.Buffer) {
@@ -38,58 +37,38 @@ func serializeBuffer(data []byte, buff *bytes.Buffer) {
 }
 
 func serializeString(s string, buff *bytes.Buffer) {
-	serializeBuffer([]byte(s), buff)
+	serializeInt(int64(len(s)), buff)
+	buff.WriteString(s)
 }
 
-func deserializeInt(r io.Reader) (v int64, err error) {
-	// TODO: Overflows
-	v, s := 0, uint(0)
-	buff := []byte{0}
-	for ; ; s += 7 {
-
-		// Get next byte
-		if _, err = r.Read(buff); err != nil {
-			return
-		}
-
-		// Fill in the data in returned value
-		v |= int64(buff[0]&0x7F) << s
-
-		if (buff[0] & 0x80) == 0 {
-			break
-		}
-	}
-	return
+func deserializeInt(r io.Reader) (int64, error) {
+	v, err := wire.ReadInt(r)
+	return v, wireError(err)
 }
 
-func deserializeBuffer(r io.Reader, maxLength int64) (data []byte, err error) {
-	length, err := deserializeInt(r)
-	if err != nil {
-		return
-	}
-
-	if (length < 0) || (length > maxLength) {
-		return nil, ErrDeserializeStringToLarge
-	}
-
-	buffer := make([]byte, length)
-	if _, err = io.ReadFull(r, buffer); err != nil {
-		return
-	}
-
-	return buffer, nil
+func deserializeVarint(r io.Reader) (int64, error) {
+	v, err := wire.ReadVarint(r)
+	return v, wireError(err)
 }
 
-func deserializeString(r io.Reader, maxLength int64) (s string, err error) {
+func deserializeBuffer(r io.Reader, maxLength int64) ([]byte, error) {
+	data, err := wire.ReadBuffer(r, maxLength)
+	return data, wireError(err)
+}
 
-	buffer, err := deserializeBuffer(r, maxLength)
-	if err != nil {
-		return
-	}
+func deserializeString(r io.Reader, maxLength int64) (string, error) {
+	s, err := wire.ReadString(r, maxLength)
+	return s, wireError(err)
+}
 
-	if !utf8.Valid(buffer) {
-		return "", ErrDeserializeStringNotUTF8
+func wireError(err error) error {
+	switch err {
+	case wire.ErrOverflow:
+		return ErrDeserializeIntOverflow
+	case wire.ErrTooLarge:
+		return ErrDeserializeStringToLarge
+	case wire.ErrNotUTF8:
+		return ErrDeserializeStringNotUTF8
 	}
-
-	return string(buffer), nil
+	return err
 }
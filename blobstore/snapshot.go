@@ -0,0 +1,31 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+)
+
+var (
+	ErrSnapshotNotSupported = errors.New("Blob storage does not support snapshots")
+)
+
+// Optional interface of the blob storage that can create point-in-time
+// copies of itself. The snapshot is consistent even if blobs are written
+// or deleted concurrently, it's safe to back it up while the storage is used.
+type Snapshotter interface {
+
+	// Create the snapshot in the destination directory, the directory
+	// must not exist. The snapshot can be opened as a regular storage.
+	SnapshotStore(dest string) error
+}
+
+// Create the snapshot of the storage if it does implement Snapshotter
+func SnapshotStore(storage BlobStorage, dest string) error {
+	if snapshotter, ok := storage.(Snapshotter); ok {
+		return snapshotter.SnapshotStore(dest)
+	}
+	return ErrSnapshotNotSupported
+}
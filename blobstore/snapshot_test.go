@@ -0,0 +1,67 @@
+package blobstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := NewFileBlobStorage(filepath.Join(dir, "store"))
+	putBlob(storage, "0a", []byte("a"))
+	putBlob(storage, "0b", []byte("b"))
+
+	// Unfinished blob is not a part of the snapshot
+	pending, err := storage.NewBlobWriter("0d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending.Write([]byte("pending"))
+	if _, err = storage.NewBlobReader("0d"); err == nil {
+		t.Fatal("Unfinished blob is visible in the storage")
+	}
+
+	snapshotDir := filepath.Join(dir, "snapshot")
+	if err = SnapshotStore(storage, snapshotDir); err != nil {
+		t.Fatal(err)
+	}
+	if err = SnapshotStore(storage, snapshotDir); err == nil {
+		t.Fatal("Snapshot overwrote existing directory")
+	}
+
+	// Changes in the storage don't affect the snapshot
+	pending.Finalize()
+	storage.Delete("0a")
+	putBlob(storage, "0b", []byte("changed"))
+
+	files, _ := ioutil.ReadDir(snapshotDir)
+	if len(files) != 2 {
+		t.Fatalf("Invalid number of files in the snapshot: %v", len(files))
+	}
+
+	snapshot := NewFileBlobStorage(snapshotDir)
+	for bid, content := range map[string]string{"0a": "a", "0b": "b"} {
+		reader, err := snapshot.NewBlobReader(bid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(reader)
+		closeReader(reader)
+		if !bytes.Equal(data, []byte(content)) {
+			t.Fatalf("Invalid content of blob %v in the snapshot: %s", bid, data)
+		}
+	}
+
+	if err = SnapshotStore(NewMemoryBlobStorage(), filepath.Join(dir, "memory")); err != ErrSnapshotNotSupported {
+		t.Fatalf("Invalid error for storage without snapshots: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Metadata of the stored blob
+type BlobStat struct {
+	Size    int64     // Size of the raw blob in bytes
+	ModTime time.Time // Time the blob was stored, zero if the storage does not track it
+}
+
+// Optional interface of the blob storage that can tell
+// the size of a blob without reading it
+type Statter interface {
+
+	// Get metadata of the blob, ErrBIDNotFound is returned
+	// if there's no such blob
+	Stat(blobId string) (BlobStat, error)
+}
+
+// Get metadata of the blob, if the storage does not implement Statter
+// the blob is read to find its size
+func Stat(storage BlobStorage, blobId string) (BlobStat, error) {
+	if statter, ok := storage.(Statter); ok {
+		return statter.Stat(blobId)
+	}
+
+	reader, err := storage.NewBlobReader(blobId)
+	if err != nil {
+		return BlobStat{}, err
+	}
+	defer closeReader(reader)
+	size, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		return BlobStat{}, err
+	}
+	return BlobStat{Size: size}, nil
+}
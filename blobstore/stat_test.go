@@ -0,0 +1,57 @@
+package blobstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStat(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory := NewMemoryBlobStorage()
+	for _, storage := range []BlobStorage{
+		memory,
+		plainStorage{memory},
+		NewFileBlobStorage(dir),
+		NewKeyValueBlobStorage(&mapKeyValueStore{}),
+		NewReadOnlyStorage(memory),
+		NewInstrumentedStorage(memory, "memory", &Metrics{}),
+		WithContext(context.Background(), memory),
+		NewLayeredBlobStorage(NewMemoryBlobStorage(), memory, 1024),
+		NewReplicatedBlobStorage(NewMemoryBlobStorage(), memory),
+	} {
+		putBlob(memory, "b10b", []byte("abcde"))
+		if StorageCapabilities(storage).Has(CapabilityWrite) {
+			putBlob(storage, "b10b", []byte("abcde"))
+			putBlob(storage, "b10b00", []byte("abc"))
+		}
+
+		stat, err := Stat(storage, "b10b")
+		if err != nil || stat.Size != 5 {
+			t.Fatalf("Invalid stat of %T: %+v %v", storage, stat, err)
+		}
+		if _, err = Stat(storage, "b1"); err != ErrBIDNotFound {
+			t.Fatalf("Invalid error for missing blob in %T: %v", storage, err)
+		}
+	}
+
+	before := time.Now().Add(-time.Minute)
+	stat, err := Stat(NewFileBlobStorage(dir), "b10b")
+	if err != nil || stat.ModTime.Before(before) {
+		t.Fatalf("Invalid modification time of the file blob: %+v %v", stat, err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = Stat(WithContext(cancelled, memory), "b10b"); err != context.Canceled {
+		t.Fatalf("Invalid error for cancelled context: %v", err)
+	}
+}
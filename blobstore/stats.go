@@ -0,0 +1,75 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+// Statistics of blobs stored by a writer. Blobs which were already
+// in the storage are not transferred again and are counted as skipped,
+// sizes include the blob headers and the encryption overhead.
+type UploadStats struct {
+	BytesWritten      int64 // Size of newly stored blobs
+	BytesDeduplicated int64 // Size of blobs which were already in the storage
+	BlobsWritten      int   // Number of newly stored blobs
+	BlobsSkipped      int   // Number of blobs which were already in the storage
+}
+
+// Account the stored blob, nil stats are ignored
+func (s *UploadStats) record(size int64, duplicate bool) {
+	if s == nil {
+		return
+	}
+	if duplicate {
+		s.BytesDeduplicated += size
+		s.BlobsSkipped++
+	} else {
+		s.BytesWritten += size
+		s.BlobsWritten++
+	}
+}
+
+// Add statistics of another writer
+func (s *UploadStats) Add(other UploadStats) {
+	s.BytesWritten += other.BytesWritten
+	s.BytesDeduplicated += other.BytesDeduplicated
+	s.BlobsWritten += other.BlobsWritten
+	s.BlobsSkipped += other.BlobsSkipped
+}
+
+// Overall size of accounted blobs
+func (s UploadStats) storedBytes() int64 {
+	return s.BytesWritten + s.BytesDeduplicated
+}
+
+// Overall number of accounted blobs
+func (s UploadStats) blobs() int {
+	return s.BlobsWritten + s.BlobsSkipped
+}
+
+// Result of finalizing the file or directory blob
+type FinalizeResult struct {
+	BlobReference
+
+	// Logical size, the length of the file content or the size
+	// of the serialized directory listing
+	Size int64
+
+	// Size of all blobs the file or directory is made of, including
+	// the blob headers and the encryption overhead. The ratio to the
+	// logical size is the storage amplification.
+	StoredSize int64
+
+	// Number of blobs the file or directory is made of, entries
+	// of the directory are not included
+	Blobs int
+}
+
+// Writer counting the number of bytes written through it
+type countingWriter struct {
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	c.count += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,133 @@
+package blobstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFinalizeDuplicate(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-duplicate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+	} {
+		for i, expected := range []bool{false, true} {
+			writer, err := storage.NewBlobWriter("0b1d")
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer.Write([]byte("content"))
+			duplicate, err := writer.Finalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if duplicate != expected {
+				t.Fatalf("Invalid duplicate flag of write %v: %v", i, duplicate)
+			}
+		}
+	}
+}
+
+func TestUploadStats(t *testing.T) {
+
+	data := make([]byte, 3*maxSimpleFileDataSize)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+
+	storage := NewMemoryBlobStorage()
+	first := FileBlobWriter{Storage: storage}
+	first.Write(data)
+	if _, err := first.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	stats := first.Stats()
+	if stats.BlobsWritten != 4 || stats.BlobsSkipped != 0 || stats.BytesDeduplicated != 0 {
+		t.Fatalf("Invalid stats of the first upload: %+v", stats)
+	}
+	if stats.BytesWritten <= int64(len(data)) {
+		t.Fatalf("Invalid number of bytes written: %v", stats.BytesWritten)
+	}
+
+	// Same content is not stored again
+	second := FileBlobWriter{Storage: storage}
+	second.Write(data)
+	if _, err := second.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if s := second.Stats(); s.BlobsWritten != 0 || s.BlobsSkipped != 4 ||
+		s.BytesWritten != 0 || s.BytesDeduplicated != stats.BytesWritten {
+		t.Fatalf("Invalid stats of the second upload: %+v", s)
+	}
+
+	// Changed tail shares leading partial blobs
+	third := FileBlobWriter{Storage: storage}
+	third.Write(data[:2*maxSimpleFileDataSize])
+	third.Write(bytes.Repeat([]byte{1}, 10))
+	third.Finalize()
+	if s := third.Stats(); s.BlobsWritten != 2 || s.BlobsSkipped != 2 {
+		t.Fatalf("Invalid stats of the modified upload: %+v", s)
+	}
+
+	var dir DirBlobWriter
+	dir.Storage = storage
+	dir.AddEntry(DirEntry{Name: "a", MimeType: "text/plain", Bid: "bid", Key: "key"})
+	dir.Finalize()
+	dir.Finalize()
+	total := first.Stats()
+	total.Add(dir.Stats())
+	if total.BlobsWritten != 5 || total.BlobsSkipped != 1 {
+		t.Fatalf("Invalid total stats: %+v", total)
+	}
+}
+
+func TestFinalizeResult(t *testing.T) {
+
+	data := make([]byte, 2*maxSimpleFileDataSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	storage := NewMemoryBlobStorage()
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write(data)
+	result, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != int64(len(data)) || result.Blobs != 4 ||
+		result.StoredSize != fw.Stats().BytesWritten {
+		t.Fatalf("Invalid result of the split file: %+v", result)
+	}
+	if info, _ := InspectBlobWithKey(result.Bid, result.Key, storage); info.FileSize != result.Size {
+		t.Fatalf("Invalid size of the stored file: %v", info.FileSize)
+	}
+
+	// Repeated finalization accounts for the whole file
+	fw.Write([]byte("more"))
+	again, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Size != result.Size+4 || again.Blobs != 4 || again.StoredSize <= result.StoredSize {
+		t.Fatalf("Invalid result of the repeated finalization: %+v", again)
+	}
+
+	dw := DirBlobWriter{Storage: storage}
+	dw.AddEntry(DirEntry{Name: "file", Bid: result.Bid, Key: result.Key})
+	dir, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.Blobs != 1 || dir.Size <= 0 || dir.StoredSize != dw.Stats().BytesWritten {
+		t.Fatalf("Invalid result of the directory: %+v", dir)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"context"
+	"io"
+)
+
+// Store the whole stream as a file blob, returning its bid, key and the number
+// of bytes read. The length of the stream does not have to be known upfront,
+// data is cut into partial blobs as it arrives thus at most one partial blob
+// is kept in memory. Buffer sizes and chunking follow default limits.
+// Once the context is done, the operation is aborted and the context
+// error is returned. Partial blobs already stored are not removed
+// on failure.
+func StoreStream(ctx context.Context, r io.Reader, storage BlobStorage) (bid, key string, size int64, err error) {
+	return StoreStreamWithConfig(ctx, r, storage, nil)
+}
+
+// Store the whole stream as a file blob created as set in the config,
+// buffer sizes and chunking follow its limits
+func StoreStreamWithConfig(ctx context.Context, r io.Reader, storage BlobStorage, config *WriterConfig) (bid, key string, size int64, err error) {
+
+	if err = config.validate(); err != nil {
+		return "", "", 0, err
+	}
+	l := config.limits()
+	writer := FileBlobWriter{Storage: WithContext(ctx, storage), ContentDefined: l.ContentDefined, Config: config}
+	buff := make([]byte, l.StreamBufferSize)
+
+	for {
+		if err = ctx.Err(); err != nil {
+			writer.Cancel()
+			return "", "", 0, err
+		}
+
+		n, rerr := r.Read(buff)
+		if n > 0 {
+			if _, err = writer.Write(buff[:n]); err != nil {
+				return "", "", 0, err
+			}
+			size += int64(n)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			writer.Cancel()
+			return "", "", 0, rerr
+		}
+	}
+
+	result, err := writer.Finalize()
+	if err != nil {
+		return "", "", 0, err
+	}
+	return result.Bid, result.Key, size, nil
+}
@@ -0,0 +1,91 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// Reader failing after returning given data
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestStoreStream(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+
+	for _, size := range []int{0, 100, maxSimpleFileDataSize + 10} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i ^ (i >> 8))
+		}
+
+		// Stream of unknown length
+		bid, key, n, err := StoreStream(context.Background(), io.MultiReader(bytes.NewReader(data)), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(size) {
+			t.Fatalf("Invalid stream size: %v, expected %v", n, size)
+		}
+
+		// Must be equal to the blob created by the writer
+		fw := FileBlobWriter{Storage: storage}
+		fw.Write(data)
+		if ref, _ := fw.Finalize(); ref.Bid != bid || ref.Key != key {
+			t.Fatal("Stream stored differently than with the writer")
+		}
+
+		rdr, _ := OpenFileBlob(bid, key, storage)
+		read, err := ioutil.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, data) {
+			t.Fatal("Invalid content of the stored stream")
+		}
+	}
+
+	readErr := errors.New("Read error")
+	if _, _, _, err := StoreStream(context.Background(), &failingReader{data: []byte("abc"), err: readErr}, storage); err != readErr {
+		t.Fatalf("Invalid error for failing stream: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, err := StoreStream(ctx, bytes.NewReader([]byte("abc")), storage); err != context.Canceled {
+		t.Fatalf("Invalid error for cancelled context: %v", err)
+	}
+
+	// Chunking follows limits of the configuration
+	data := make([]byte, 300*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	config := &WriterConfig{Limits: &ConstrainedLimits}
+	bid, key, _, err := StoreStreamWithConfig(context.Background(), bytes.NewReader(data), storage, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := FileBlobWriter{Storage: storage, ContentDefined: true, Config: config}
+	fw.Write(data)
+	if ref, _ := fw.Finalize(); ref.Bid != bid || ref.Key != key {
+		t.Fatal("Stream not chunked with configured limits")
+	}
+	if _, _, _, err = StoreStreamWithConfig(context.Background(), bytes.NewReader(data), storage, &WriterConfig{ChunkSize: -1}); err != ErrInvalidWriterConfig {
+		t.Fatalf("Invalid error for invalid configuration: %v", err)
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+	"unicode/utf8"
+)
+
+// FormatError describes a blob format violation found by the strict decoder
+type FormatError struct {
+	Offset   int64  // Offset in the unencrypted blob content where the field starts
+	Field    string // Name of the field
+	Expected string // Description of the expected value
+	Found    string // Description of the value found
+	Err      error  // Underlying error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("Invalid blob format at offset %d, field %q: expected %s, found %s (%v)",
+		e.Offset, e.Field, e.Expected, e.Found, e.Err)
+}
+
+// Get the underlying error
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// One field decoded by the strict decoder
+type DecodedField struct {
+	Offset int64  // Offset in the unencrypted blob content
+	Field  string // Name of the field
+	Value  string // Human-readable value
+}
+
+// Decode the blob strictly, each decoded field is reported in the returned list.
+// On failure, fields decoded so far are returned along with the *FormatError
+// describing the problem. Unlike regular readers, the strict decoder reads
+// the whole blob, including the end of the stream where the content is validated.
+func StrictDecodeBlob(bid, key string, storage BlobStorage) (fields []DecodedField, err error) {
+
+	reader, err := storage.NewBlobReader(bid)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader(reader)
+
+	d := &strictDecoder{reader: reader}
+
+	// Only hash-validated blobs contain structured data
+	if _, err = d.readInt("validation method", validationMethodHash, validationMethodHash, ErrInvalidValidationMethod); err != nil {
+		return d.fields, err
+	}
+
+	content, err := createReaderForHashBlobData(d.reader, bid, key)
+	if err != nil {
+		return d.fields, err
+	}
+
+	// Offsets of the unencrypted content continue after the validation method
+	d.reader = content
+
+	blobType, err := d.readInt("blob type", 0, maxSaneBlobType, ErrUnknownBlobType)
+	if err != nil {
+		return d.fields, err
+	}
+
+	switch blobType {
+	case blobTypeSimpleStaticFile:
+		d.skipRest("file data")
+		err = d.err
+	case blobTypeCompressedStaticFile:
+		if _, err = d.readInt("compression method", compressionDeflate, compressionDeflate, ErrUnknownCompressionMethod); err == nil {
+			d.skipRest("compressed data")
+			err = d.err
+		}
+	case blobTypeSplitStaticFile:
+		err = d.decodeSplitFile()
+	case blobTypeChunkedStaticFile:
+		err = d.decodeChunkedFile()
+	case blobTypeSimpleStaticDir:
+		err = d.decodeSimpleDir(false)
+	case blobTypeSimpleStaticDirV2:
+		err = d.decodeSimpleDir(true)
+	case blobTypeSplitStaticDir:
+		err = d.decodeSplitDir()
+	case blobTypeChunkedStaticDir:
+		err = d.decodeChunkedDir()
+	case blobTypeCommit:
+		err = d.decodeCommit()
+	case blobTypeLink:
+		err = d.decodeLink()
+	default:
+		err = d.fail("blob type", "known blob type", fmt.Sprintf("0x%02x", blobType), ErrUnknownBlobType)
+	}
+
+	return d.fields, err
+}
+
+// Maximum value of the blob type considered sane
+const maxSaneBlobType = 0xFFFF
+
+type strictDecoder struct {
+	reader io.Reader      // Source of data
+	offset int64          // Number of bytes read so far
+	fields []DecodedField // Fields decoded so far
+	err    error          // First error found
+}
+
+func (d *strictDecoder) Read(p []byte) (n int, err error) {
+	n, err = d.reader.Read(p)
+	d.offset += int64(n)
+	return
+}
+
+func (d *strictDecoder) fail(field, expected, found string, err error) error {
+	if d.err == nil {
+		d.err = &FormatError{
+			Offset:   d.offset,
+			Field:    field,
+			Expected: expected,
+			Found:    found,
+			Err:      err,
+		}
+	}
+	return d.err
+}
+
+func (d *strictDecoder) record(offset int64, field, value string) {
+	d.fields = append(d.fields, DecodedField{Offset: offset, Field: field, Value: value})
+}
+
+func (d *strictDecoder) readInt(field string, min, max int64, rangeErr error) (int64, error) {
+	offset := d.offset
+	v, err := deserializeInt(d)
+	if err != nil {
+		d.offset = offset
+		return 0, d.fail(field, "integer", intErrorFound(err), err)
+	}
+	if v < min || v > max {
+		d.offset = offset
+		return 0, d.fail(field, fmt.Sprintf("value in range %d..%d", min, max), fmt.Sprint(v), rangeErr)
+	}
+	d.record(offset, field, fmt.Sprint(v))
+	return v, nil
+}
+
+// Describe what was found instead of the integer
+func intErrorFound(err error) string {
+	if err == ErrDeserializeIntOverflow {
+		return "integer exceeding 64 bits"
+	}
+	return "end of data"
+}
+
+func (d *strictDecoder) readString(field string, maxLength int64) (string, error) {
+	offset := d.offset
+	buffer, err := d.readBytes(field, maxLength)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(buffer) {
+		d.offset = offset
+		return "", d.fail(field, "UTF-8 string", "invalid UTF-8 sequence", ErrDeserializeStringNotUTF8)
+	}
+	d.record(offset, field, fmt.Sprintf("%q", buffer))
+	return string(buffer), nil
+}
+
+// Read the length-prefixed binary value, it's recorded in hex
+func (d *strictDecoder) readBuffer(field string, maxLength int64) ([]byte, error) {
+	offset := d.offset
+	buffer, err := d.readBytes(field, maxLength)
+	if err != nil {
+		return nil, err
+	}
+	d.record(offset, field, hex.EncodeToString(buffer))
+	return buffer, nil
+}
+
+func (d *strictDecoder) readBytes(field string, maxLength int64) ([]byte, error) {
+	offset := d.offset
+	length, err := deserializeInt(d)
+	if err != nil {
+		d.offset = offset
+		return nil, d.fail(field, "string length", intErrorFound(err), err)
+	}
+	if length < 0 || length > maxLength {
+		d.offset = offset
+		return nil, d.fail(field, fmt.Sprintf("string length up to %d", maxLength), fmt.Sprint(length), ErrDeserializeStringToLarge)
+	}
+	buffer := make([]byte, length)
+	if n, err := io.ReadFull(d, buffer); err != nil {
+		d.offset = offset
+		return nil, d.fail(field, fmt.Sprintf("%d bytes of string", length), fmt.Sprintf("%d bytes", n), err)
+	}
+	return buffer, nil
+}
+
+// Read the rest of data, this does validate the blob content
+func (d *strictDecoder) skipRest(field string) {
+	offset := d.offset
+	n, err := io.Copy(ioutil.Discard, d)
+	if err != nil {
+		d.fail(field, "valid blob content", "invalid content", err)
+		return
+	}
+	d.record(offset, field, fmt.Sprintf("%d bytes", n))
+}
+
+// Make sure we're at the end of data, this does validate the blob content
+func (d *strictDecoder) expectEOF(extraDataErr error) error {
+	var buff [1]byte
+	for {
+		n, err := d.Read(buff[:])
+		if n > 0 {
+			d.offset -= int64(n)
+			return d.fail("end of blob", "end of data", "extra data", extraDataErr)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return d.fail("end of blob", "valid blob content", "invalid content", err)
+		}
+	}
+}
+
+func (d *strictDecoder) decodeSplitFile() error {
+
+	totalSize, err := d.readInt("file size", 0, maxSaneSplitFileParts*maxSimpleFileDataSize, ErrInvalidSplitFileSize)
+	if err != nil {
+		return err
+	}
+
+	offset := d.offset
+	count, err := d.readInt("parts count", 2, maxSaneSplitFileParts, ErrMalformedSplitFileSizePartsCount)
+	if err != nil {
+		return err
+	}
+
+	maxSize := count * maxSimpleFileDataSize
+	minSize := maxSize - maxSimpleFileDataSize + 1
+	if totalSize < minSize || totalSize > maxSize {
+		d.offset = offset
+		return d.fail("parts count",
+			fmt.Sprintf("count matching file size %d", totalSize),
+			fmt.Sprint(count),
+			ErrInvalidSplitFileSize)
+	}
+
+	for i := int64(0); i < count; i++ {
+		if _, err = d.readString(fmt.Sprintf("part[%d].bid", i), maxSaneBidLength); err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("part[%d].key", i), maxSaneKeyLength); err != nil {
+			return err
+		}
+	}
+
+	return d.expectEOF(ErrMalformedSplitFileExtraData)
+}
+
+func (d *strictDecoder) decodeChunkedFile() error {
+
+	totalSize, err := d.readInt("file size", 0, maxSaneSplitFileParts*maxSimpleFileDataSize, ErrInvalidSplitFileSize)
+	if err != nil {
+		return err
+	}
+
+	count, err := d.readInt("parts count", 2, maxSaneSplitFileParts, ErrMalformedSplitFileSizePartsCount)
+	if err != nil {
+		return err
+	}
+
+	sum := int64(0)
+	for i := int64(0); i < count; i++ {
+		size, err := d.readInt(fmt.Sprintf("part[%d].size", i), 1, maxSimpleFileDataSize, ErrInvalidSplitFileSize)
+		if err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("part[%d].bid", i), maxSaneBidLength); err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("part[%d].key", i), maxSaneKeyLength); err != nil {
+			return err
+		}
+		sum += size
+	}
+	if sum != totalSize {
+		return d.fail("parts sizes",
+			fmt.Sprintf("sizes summing up to file size %d", totalSize),
+			fmt.Sprint(sum),
+			ErrInvalidSplitFileSize)
+	}
+
+	return d.expectEOF(ErrMalformedSplitFileExtraData)
+}
+
+func (d *strictDecoder) decodeSimpleDir(extended bool) error {
+
+	count, err := d.readInt("entries count", 0, maxSimpleDirEntries, ErrMalformedDirInvalidEntriesCount)
+	if err != nil {
+		return err
+	}
+
+	for i := int64(0); i < count; i++ {
+		if extended {
+			if _, err = d.readInt(fmt.Sprintf("entry[%d].type", i), 0, maxSaneEntryType, ErrInvalidEntryType); err != nil {
+				return err
+			}
+		}
+		if _, err = d.readString(fmt.Sprintf("entry[%d].name", i), maxSaneNameLenght); err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("entry[%d].mimetype", i), maxSaneMimeTypeLength); err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("entry[%d].bid", i), maxSaneBidLength); err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("entry[%d].key", i), maxSaneKeyLength); err != nil {
+			return err
+		}
+		if extended {
+			if _, err = d.readString(fmt.Sprintf("entry[%d].target", i), maxSaneTargetLength); err != nil {
+				return err
+			}
+			fields, err := d.readInt(fmt.Sprintf("entry[%d].fields", i), 0, maxSaneEntryFields, ErrInvalidEntryFields)
+			if err != nil {
+				return err
+			}
+			for j := int64(0); j < fields; j++ {
+				if _, err = d.readInt(fmt.Sprintf("entry[%d].field[%d].tag", i, j), 0, maxSaneEntryFieldTag, ErrInvalidEntryFields); err != nil {
+					return err
+				}
+				if _, err = d.readBuffer(fmt.Sprintf("entry[%d].field[%d].value", i, j), maxSaneEntryFieldLength); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return d.expectEOF(ErrMalformedDirExtraData)
+}
+
+func (d *strictDecoder) decodeSplitDir() error {
+
+	totalEntries, err := d.readInt("entries count", 0, maxSaneSplitDirParts*maxSimpleDirEntries, ErrMalformedDirInvalidEntriesCount)
+	if err != nil {
+		return err
+	}
+
+	offset := d.offset
+	count, err := d.readInt("parts count", 2, maxSaneSplitDirParts, ErrMalformedSplitDirPartsCount)
+	if err != nil {
+		return err
+	}
+
+	maxEntries := count * maxSimpleDirEntries
+	minEntries := maxEntries - maxSimpleDirEntries + 1
+	if totalEntries < minEntries || totalEntries > maxEntries {
+		d.offset = offset
+		return d.fail("parts count",
+			fmt.Sprintf("count matching entries count %d", totalEntries),
+			fmt.Sprint(count),
+			ErrMalformedDirInvalidEntriesCount)
+	}
+
+	for i := int64(0); i < count; i++ {
+		if _, err = d.readString(fmt.Sprintf("part[%d].bid", i), maxSaneBidLength); err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("part[%d].key", i), maxSaneKeyLength); err != nil {
+			return err
+		}
+	}
+
+	return d.expectEOF(ErrMalformedDirExtraData)
+}
+
+func (d *strictDecoder) decodeChunkedDir() error {
+
+	totalEntries, err := d.readInt("entries count", 0, maxSaneSplitDirParts*maxSimpleDirEntries, ErrMalformedDirInvalidEntriesCount)
+	if err != nil {
+		return err
+	}
+
+	count, err := d.readInt("parts count", 2, maxSaneSplitDirParts, ErrMalformedSplitDirPartsCount)
+	if err != nil {
+		return err
+	}
+
+	sum := int64(0)
+	for i := int64(0); i < count; i++ {
+		entries, err := d.readInt(fmt.Sprintf("part[%d].entries", i), 1, maxSimpleDirEntries, ErrMalformedDirInvalidEntriesCount)
+		if err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("part[%d].bid", i), maxSaneBidLength); err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("part[%d].key", i), maxSaneKeyLength); err != nil {
+			return err
+		}
+		sum += entries
+	}
+	if sum != totalEntries {
+		return d.fail("parts entries",
+			fmt.Sprintf("counts summing up to entries count %d", totalEntries),
+			fmt.Sprint(sum),
+			ErrMalformedDirInvalidEntriesCount)
+	}
+
+	return d.expectEOF(ErrMalformedDirExtraData)
+}
+
+func (d *strictDecoder) decodeCommit() error {
+
+	root, err := d.readString("root.bid", maxSaneBidLength)
+	if err != nil {
+		return err
+	}
+	if root == "" {
+		return d.fail("root.bid", "blob id", "empty string", ErrMalformedCommitRoot)
+	}
+	if _, err = d.readString("root.key", maxSaneKeyLength); err != nil {
+		return err
+	}
+
+	offset := d.offset
+	nsec, err := deserializeVarint(d)
+	if err != nil {
+		d.offset = offset
+		return d.fail("time", "signed integer", "end of data", err)
+	}
+	d.record(offset, "time", time.Unix(0, nsec).UTC().Format(time.RFC3339Nano))
+
+	if _, err = d.readString("message", maxSaneCommitMessage); err != nil {
+		return err
+	}
+
+	count, err := d.readInt("parents count", 0, maxSaneCommitParents, ErrMalformedCommitParents)
+	if err != nil {
+		return err
+	}
+	for i := int64(0); i < count; i++ {
+		if _, err = d.readString(fmt.Sprintf("parent[%d].bid", i), maxSaneBidLength); err != nil {
+			return err
+		}
+		if _, err = d.readString(fmt.Sprintf("parent[%d].key", i), maxSaneKeyLength); err != nil {
+			return err
+		}
+	}
+
+	return d.expectEOF(ErrMalformedCommitExtraData)
+}
+
+func (d *strictDecoder) decodeLink() error {
+
+	target, err := d.readString("target.bid", maxSaneBidLength)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return d.fail("target.bid", "blob id", "empty string", ErrMalformedLinkTarget)
+	}
+	if _, err = d.readString("target.key", maxSaneKeyLength); err != nil {
+		return err
+	}
+	if _, err = d.readString("mimetype", maxSaneMimeTypeLength); err != nil {
+		return err
+	}
+	if _, err = d.readString("name", maxSaneNameLenght); err != nil {
+		return err
+	}
+
+	return d.expectEOF(ErrMalformedLinkExtraData)
+}
@@ -0,0 +1,97 @@
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestStrictDecodeValidBlobs(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	fileRef, _ := fw.Finalize()
+	fileBid, fileKey := fileRef.Bid, fileRef.Key
+
+	fields, err := StrictDecodeBlob(fileBid, fileKey, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 3 || fields[2].Field != "file data" || fields[2].Value != "12 bytes" {
+		t.Fatalf("Invalid fields decoded: %v", fields)
+	}
+
+	dw := DirBlobWriter{Storage: storage}
+	dw.AddEntry(DirEntry{Name: "hello.txt", MimeType: "text/plain", Bid: fileBid, Key: fileKey})
+	dirRef, _ := dw.Finalize()
+	dirBid, dirKey := dirRef.Bid, dirRef.Key
+
+	fields, err = StrictDecodeBlob(dirBid, dirKey, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 7 || fields[3].Field != "entry[0].name" || fields[3].Offset != 3 {
+		t.Fatalf("Invalid fields decoded: %v", fields)
+	}
+}
+
+func TestStrictDecodeFormatErrors(t *testing.T) {
+
+	var oneEntry bytes.Buffer
+	serializeInt(1, &oneEntry)
+	(&DirEntry{Name: "a"}).serialize(&oneEntry)
+
+	for _, test := range []struct {
+		blobType int64
+		content  []byte
+		offset   int64
+		field    string
+		err      error
+	}{
+		{blobTypeSimpleStaticDir, []byte{}, 2, "entries count", nil},
+		{blobTypeSimpleStaticDir, []byte{0x80, 0x10}, 2, "entries count", ErrMalformedDirInvalidEntriesCount},
+		{blobTypeSimpleStaticDir, []byte{0x01}, 3, "entry[0].name", nil},
+		{blobTypeSimpleStaticDir, []byte{0x01, 0x05, 'a'}, 3, "entry[0].name", nil},
+		{blobTypeSimpleStaticDir, []byte{0x01, 0x01, 0xFF}, 3, "entry[0].name", ErrDeserializeStringNotUTF8},
+		{blobTypeSimpleStaticDir, append(oneEntry.Bytes(), 0x00), 8, "end of blob", ErrMalformedDirExtraData},
+		{blobTypeSplitStaticFile, []byte{0x05, 0x02}, 3, "parts count", ErrInvalidSplitFileSize},
+		{0x7D, []byte{}, 2, "blob type", ErrUnknownBlobType},
+	} {
+		storage := NewMemoryBlobStorage()
+		bid, key, err := CreateTypedBlob(test.blobType, test.content, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = StrictDecodeBlob(bid, key, storage)
+		formatErr, ok := err.(*FormatError)
+		if !ok {
+			t.Fatalf("Did not get format error for %x: %v", test.content, err)
+		}
+		if formatErr.Offset != test.offset || formatErr.Field != test.field {
+			t.Errorf("Invalid format error for %x: %v", test.content, formatErr)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("Invalid underlying error for %x: %v", test.content, formatErr.Err)
+		}
+	}
+}
+
+func TestStrictDecodeCorruptedBlob(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	bid, key, err := CreateTypedBlob(blobTypeSimpleStaticFile, []byte("Hello World!"), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, _ := storage.(*memoryBlobStorage).lookup(bid)
+	blob[len(blob)-1] ^= 0x01
+
+	_, err = StrictDecodeBlob(bid, key, storage)
+	if !errors.Is(err, ErrInvalidHashBlobContent) {
+		t.Fatalf("Corrupted blob not detected: %v", err)
+	}
+}
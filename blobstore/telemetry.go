@@ -0,0 +1,125 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage of the blob creation measured by the telemetry
+type Stage int
+
+const (
+	StageHash    Stage = iota // Hashing the content to get the encryption key
+	StageEncrypt              // Encrypting the content and calculating the blob id
+	StageWrite                // Finalizing the blob in the storage
+	stageCount
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageHash:
+		return "hash"
+	case StageEncrypt:
+		return "encrypt"
+	case StageWrite:
+		return "write"
+	}
+	return "unknown"
+}
+
+// Receiver of sampled timings, it's called synchronously from
+// the hot path thus it must be cheap
+type TelemetryHook func(stage Stage, duration time.Duration, bytes int64)
+
+type telemetryConfig struct {
+	hook TelemetryHook
+	rate uint64
+}
+
+var (
+	telemetry        atomic.Value // Current *telemetryConfig, nil if disabled
+	telemetryCounter uint64       // Number of operations seen so far
+)
+
+// Enable sampled timings of the blob creation, one of every rate operations
+// is measured. Telemetry is disabled with nil hook or zero rate, the cost
+// of disabled telemetry is a single atomic load per blob.
+func SetTelemetry(hook TelemetryHook, rate int) {
+	if hook == nil || rate <= 0 {
+		telemetry.Store((*telemetryConfig)(nil))
+		return
+	}
+	telemetry.Store(&telemetryConfig{hook: hook, rate: uint64(rate)})
+}
+
+// Timing of a single sampled operation, the zero value measures nothing
+type probe struct {
+	config *telemetryConfig
+	start  time.Time
+}
+
+// Decide whether the operation is sampled
+func startProbe() probe {
+	config, _ := telemetry.Load().(*telemetryConfig)
+	if config == nil || atomic.AddUint64(&telemetryCounter, 1)%config.rate != 0 {
+		return probe{}
+	}
+	return probe{config: config, start: time.Now()}
+}
+
+// Report the stage finished since the previous one
+func (p *probe) lap(stage Stage, bytes int64) {
+	if p.config == nil {
+		return
+	}
+	now := time.Now()
+	p.config.hook(stage, now.Sub(p.start), bytes)
+	p.start = now
+}
+
+// Aggregated timings of one stage
+type StageStats struct {
+	Samples int64         // Number of measured operations
+	Bytes   int64         // Bytes processed by measured operations
+	Total   time.Duration // Total time of measured operations
+	Max     time.Duration // Longest measured operation
+}
+
+// Collector of sampled timings, its Record method can be used as the hook
+type TelemetryStats struct {
+	lock   sync.Mutex
+	stages [stageCount]StageStats
+}
+
+func (t *TelemetryStats) Record(stage Stage, duration time.Duration, bytes int64) {
+	if stage < 0 || stage >= stageCount {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s := &t.stages[stage]
+	s.Samples++
+	s.Bytes += bytes
+	s.Total += duration
+	if duration > s.Max {
+		s.Max = duration
+	}
+}
+
+// Get timings of the stage collected so far
+func (t *TelemetryStats) Stage(stage Stage) StageStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if stage < 0 || stage >= stageCount {
+		return StageStats{}
+	}
+	return t.stages[stage]
+}
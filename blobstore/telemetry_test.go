@@ -0,0 +1,39 @@
+package blobstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTelemetry(t *testing.T) {
+
+	defer SetTelemetry(nil, 0)
+
+	var stats TelemetryStats
+	SetTelemetry(stats.Record, 2)
+
+	storage := NewMemoryBlobStorage()
+	for i := 0; i < 10; i++ {
+		fw := FileBlobWriter{Storage: storage}
+		fw.Write(bytes.Repeat([]byte{byte(i)}, 1000))
+		if _, err := fw.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every other blob is measured
+	for _, stage := range []Stage{StageEncrypt, StageWrite} {
+		s := stats.Stage(stage)
+		if s.Samples != 5 || s.Bytes <= 5*1000 || s.Max > s.Total {
+			t.Fatalf("Invalid stats of the %v stage: %+v", stage, s)
+		}
+	}
+
+	SetTelemetry(nil, 0)
+	fw := FileBlobWriter{Storage: storage}
+	fw.Write([]byte("data"))
+	fw.Finalize()
+	if s := stats.Stage(StageWrite); s.Samples != 5 {
+		t.Fatalf("Blob measured with disabled telemetry: %+v", s)
+	}
+}
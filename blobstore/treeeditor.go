@@ -0,0 +1,276 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrMoveIntoItself = errors.New("Directory can't be moved into itself")
+)
+
+// Editor of the directory blob tree. Edits are applied in memory, Commit
+// stores new blobs of modified directories and of directories above them
+// only, all other blobs are reused by the new tree. Directories are read
+// when an edit reaches them for the first time. Paths are slash-separated
+// and relative to the root, empty components are ignored. Path failures
+// are reported as *ResolveError. The editor is not safe for concurrent use.
+type TreeEditor struct {
+
+	// Storage Object
+	Storage BlobStorage
+
+	// Layout of created blobs, the default one is used if nil
+	Config *WriterConfig
+
+	root *editedDir
+}
+
+// Directory of the edited tree
+type editedDir struct {
+	ref      BlobReference         // Blob of the directory, empty for new ones
+	entries  map[string]DirEntry   // Entries by their names, nil until loaded
+	subdirs  map[string]*editedDir // Subdirectories reached by edits
+	modified bool                  // Entries changed since the directory was stored
+}
+
+// Create the editor of the tree with the root directory blob, the empty
+// reference starts a new tree
+func NewTreeEditor(root BlobReference, storage BlobStorage) *TreeEditor {
+	return &TreeEditor{Storage: storage, root: &editedDir{ref: root}}
+}
+
+// Store the file content at the path replacing any existing entry, the
+// parent directory must exist
+func (t *TreeEditor) PutFile(path string, content io.Reader) error {
+	dir, name, err := t.parent(path)
+	if err != nil {
+		return err
+	}
+
+	fw := FileBlobWriter{Storage: t.Storage, Config: t.Config}
+	if _, err = io.Copy(&fw, content); err != nil {
+		return err
+	}
+	result, err := fw.Finalize()
+	if err != nil {
+		return err
+	}
+
+	dir.put(DirEntry{Name: name, Bid: result.Bid, Key: result.Key})
+	return nil
+}
+
+// Put the entry at the path replacing any existing one, the name of the
+// entry is set from the path. The parent directory must exist.
+func (t *TreeEditor) PutEntry(path string, entry DirEntry) error {
+	dir, name, err := t.parent(path)
+	if err != nil {
+		return err
+	}
+	entry.Name = name
+	dir.put(entry)
+	return nil
+}
+
+// Create the empty directory, the parent directory must exist
+func (t *TreeEditor) Mkdir(path string) error {
+	dir, name, err := t.parent(path)
+	if err != nil {
+		return err
+	}
+	if _, exists := dir.entries[name]; exists {
+		return &ResolveError{Path: cleanTreePath(path), Err: ErrDuplicateEntry}
+	}
+	dir.put(DirEntry{Name: name})
+	dir.subdirs[name] = &editedDir{
+		entries:  make(map[string]DirEntry),
+		subdirs:  make(map[string]*editedDir),
+		modified: true,
+	}
+	return nil
+}
+
+// Remove the entry, directories are removed with their content
+func (t *TreeEditor) Delete(path string) error {
+	dir, name, err := t.parent(path)
+	if err != nil {
+		return err
+	}
+	if _, exists := dir.entries[name]; !exists {
+		return &ResolveError{Path: cleanTreePath(path), Err: ErrDirEntryNotFound}
+	}
+	delete(dir.entries, name)
+	delete(dir.subdirs, name)
+	dir.modified = true
+	return nil
+}
+
+// Move the entry to the new path, there must be no entry there
+// and its parent directory must exist
+func (t *TreeEditor) Rename(from, to string) error {
+	if to = cleanTreePath(to); strings.HasPrefix(to+"/", cleanTreePath(from)+"/") && to != cleanTreePath(from) {
+		return &ResolveError{Path: to, Err: ErrMoveIntoItself}
+	}
+
+	src, srcName, err := t.parent(from)
+	if err != nil {
+		return err
+	}
+	entry, exists := src.entries[srcName]
+	if !exists {
+		return &ResolveError{Path: cleanTreePath(from), Err: ErrDirEntryNotFound}
+	}
+	dst, dstName, err := t.parent(to)
+	if err != nil {
+		return err
+	}
+	if _, exists = dst.entries[dstName]; exists {
+		return &ResolveError{Path: to, Err: ErrDuplicateEntry}
+	}
+
+	subdir := src.subdirs[srcName]
+	delete(src.entries, srcName)
+	delete(src.subdirs, srcName)
+	src.modified = true
+
+	entry.Name = dstName
+	dst.put(entry)
+	if subdir != nil {
+		dst.subdirs[dstName] = subdir
+	}
+	return nil
+}
+
+// Store blobs of modified directories, the root of the new tree is
+// returned. Editing can continue, next commits store directories
+// modified since the last one.
+func (t *TreeEditor) Commit() (BlobReference, error) {
+	if err := t.root.load(t.Storage); err != nil {
+		return BlobReference{}, err
+	}
+	ref, _, err := t.commit(t.root)
+	return ref, err
+}
+
+func (t *TreeEditor) commit(dir *editedDir) (ref BlobReference, changed bool, err error) {
+	changed = dir.modified
+	for name, subdir := range dir.subdirs {
+		subRef, subChanged, err := t.commit(subdir)
+		if err != nil {
+			return BlobReference{}, false, err
+		}
+		if subChanged {
+			entry := dir.entries[name]
+			entry.Bid, entry.Key = subRef.Bid, subRef.Key
+			dir.entries[name] = entry
+			changed = true
+		}
+	}
+	if !changed {
+		return dir.ref, false, nil
+	}
+
+	dw := DirBlobWriter{Storage: t.Storage, Config: t.Config}
+	for _, entry := range dir.entries {
+		dw.AddEntry(entry)
+	}
+	result, err := dw.Finalize()
+	if err != nil {
+		return BlobReference{}, false, err
+	}
+	dir.ref, dir.modified = result.BlobReference, false
+	return dir.ref, true, nil
+}
+
+// Find the loaded parent directory of the path and the name of the entry
+func (t *TreeEditor) parent(path string) (*editedDir, string, error) {
+	names := strings.Split(cleanTreePath(path), "/")
+	if names[0] == "" {
+		return nil, "", &ResolveError{Path: path, Err: ErrInvalidEntryName}
+	}
+
+	dir := t.root
+	for i, name := range names {
+		fail := func(err error) (*editedDir, string, error) {
+			return nil, "", &ResolveError{Path: strings.Join(names[:i+1], "/"), Err: err}
+		}
+		if name == "." || name == ".." {
+			return fail(ErrInvalidEntryName)
+		}
+		if err := dir.load(t.Storage); err != nil {
+			return fail(err)
+		}
+		if i == len(names)-1 {
+			break
+		}
+
+		subdir := dir.subdirs[name]
+		if subdir == nil {
+			entry, exists := dir.entries[name]
+			switch {
+			case !exists:
+				return fail(ErrDirEntryNotFound)
+			case !entry.IsBlob():
+				return fail(ErrNotDirectory)
+			}
+			subdir = &editedDir{ref: BlobReference{Bid: entry.Bid, Key: entry.Key}}
+			dir.subdirs[name] = subdir
+		}
+		dir = subdir
+	}
+	return dir, names[len(names)-1], nil
+}
+
+// Read entries of the directory unless they're loaded already
+func (d *editedDir) load(storage BlobStorage) error {
+	if d.entries != nil {
+		return nil
+	}
+
+	entries := make(map[string]DirEntry)
+	if d.ref.Bid != "" {
+		reader, err := OpenDirBlob(d.ref.Bid, d.ref.Key, storage)
+		if err == ErrInvalidFileBlobType {
+			err = ErrNotDirectory
+		}
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		list, err := reader.Entries()
+		if err != nil {
+			return err
+		}
+		for _, entry := range list {
+			entries[entry.Name] = entry
+		}
+	} else {
+		d.modified = true
+	}
+	d.entries, d.subdirs = entries, make(map[string]*editedDir)
+	return nil
+}
+
+// Add or replace the entry of the loaded directory
+func (d *editedDir) put(entry DirEntry) {
+	d.entries[entry.Name] = entry
+	delete(d.subdirs, entry.Name)
+	d.modified = true
+}
+
+// Get the path without empty components
+func cleanTreePath(path string) string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "/")
+}
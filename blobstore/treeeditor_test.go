@@ -0,0 +1,141 @@
+package blobstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readTreeFile(t *testing.T, root BlobReference, path string, storage BlobStorage) string {
+	entry, err := Resolve(root.Bid, root.Key, path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := OpenFileBlob(entry.Bid, entry.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTreeEditor(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	e := NewTreeEditor(BlobReference{}, storage)
+	for _, path := range []string{"a", "a/b", "c"} {
+		if err := e.Mkdir(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"a/b/x.txt", "c/y.txt", "/z.txt"} {
+		if err := e.PutFile(path, strings.NewReader(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := e.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readTreeFile(t, root, "a/b/x.txt", storage); data != "a/b/x.txt" {
+		t.Fatalf("Invalid file content: %q", data)
+	}
+
+	// Only directories on edited paths are stored again
+	aBefore, _ := Resolve(root.Bid, root.Key, "a", storage)
+	cBefore, _ := Resolve(root.Bid, root.Key, "c", storage)
+	e = NewTreeEditor(root, storage)
+	if err = e.PutFile("c/y.txt", strings.NewReader("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Rename("a/b/x.txt", "c/moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Rename("c", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Delete("z.txt"); err != nil {
+		t.Fatal(err)
+	}
+	edited, err := e.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readTreeFile(t, edited, "d/y.txt", storage); data != "changed" {
+		t.Fatalf("Invalid file content: %q", data)
+	}
+	if data := readTreeFile(t, edited, "d/moved.txt", storage); data != "a/b/x.txt" {
+		t.Fatalf("Invalid file content: %q", data)
+	}
+	for _, path := range []string{"c", "z.txt", "a/b/x.txt"} {
+		if _, err = Resolve(edited.Bid, edited.Key, path, storage); !errors.Is(err, ErrDirEntryNotFound) {
+			t.Fatalf("Entry %q not removed: %v", path, err)
+		}
+	}
+	if a, _ := Resolve(edited.Bid, edited.Key, "a", storage); a.Bid == aBefore.Bid {
+		t.Fatalf("Edited directory not stored again")
+	}
+	if d, _ := Resolve(edited.Bid, edited.Key, "d", storage); d.Bid == cBefore.Bid {
+		t.Fatalf("Edited directory not stored again")
+	}
+
+	// The original tree is not changed
+	if data := readTreeFile(t, root, "c/y.txt", storage); data != "c/y.txt" {
+		t.Fatalf("Original tree changed: %q", data)
+	}
+
+	// Committing without edits gives the same tree
+	if again, err := e.Commit(); err != nil || again != edited {
+		t.Fatalf("Invalid tree committed without edits: %v %v", again, err)
+	}
+	e = NewTreeEditor(edited, storage)
+	e.PutEntry("a/link", DirEntry{Type: EntryTypeSymlink, Target: "b"})
+	e.Delete("a/link")
+	if again, err := e.Commit(); err != nil || again != edited {
+		t.Fatalf("Invalid tree committed after reverted edits: %v %v", again, err)
+	}
+}
+
+func TestTreeEditorErrors(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	e := NewTreeEditor(BlobReference{}, storage)
+	e.Mkdir("a")
+	e.PutFile("a/file", strings.NewReader("data"))
+	e.PutEntry("a/link", DirEntry{Type: EntryTypeSymlink, Target: "."})
+
+	for _, d := range []struct {
+		op  func() error
+		err error
+	}{
+		{func() error { return e.Mkdir("a") }, ErrDuplicateEntry},
+		{func() error { return e.Mkdir("/") }, ErrInvalidEntryName},
+		{func() error { return e.Mkdir("a/../b") }, ErrInvalidEntryName},
+		{func() error { return e.Mkdir("b/c") }, ErrDirEntryNotFound},
+		{func() error { return e.PutFile("a/file/x", strings.NewReader("")) }, ErrNotDirectory},
+		{func() error { return e.PutEntry("a/link/x", DirEntry{}) }, ErrNotDirectory},
+		{func() error { return e.Delete("a/missing") }, ErrDirEntryNotFound},
+		{func() error { return e.Rename("a/missing", "b") }, ErrDirEntryNotFound},
+		{func() error { return e.Rename("a/file", "a/link") }, ErrDuplicateEntry},
+		{func() error { return e.Rename("a", "a/b") }, ErrMoveIntoItself},
+	} {
+		var resolveErr *ResolveError
+		if err := d.op(); !errors.As(err, &resolveErr) || !errors.Is(err, d.err) {
+			t.Fatalf("Invalid error: %v, expected %v", err, d.err)
+		}
+	}
+
+	// The file is not a directory in the stored tree either
+	root, err := e.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = NewTreeEditor(root, storage)
+	if err = e.Mkdir("a/file/x"); !errors.Is(err, ErrNotDirectory) {
+		t.Fatalf("Invalid error: %v", err)
+	}
+}
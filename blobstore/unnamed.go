@@ -0,0 +1,127 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+)
+
+// Writer of the blob whose id is only known once all the data is written
+type UnnamedBlobWriter interface {
+	io.Writer
+
+	// Finalize the blob, storing it under given blob id. The duplicate
+	// flag indicates whether the same blob was already in the storage.
+	FinalizeAs(blobId string) (duplicate bool, err error)
+
+	// Cancel the blob generation, Close cancels the writer unless
+	// it's finalized, see WriteFinalizeCanceler
+	Cancel() error
+	io.Closer
+}
+
+// Optional interface of the blob storage accepting blobs before their ids are
+// known. Hash-validated blobs are streamed to such storages directly, other
+// storages get the data once it's encrypted and kept in a spill buffer.
+type UnnamedBlobStorage interface {
+
+	// Create new writer for blob with unknown id
+	NewUnnamedBlobWriter() (writer UnnamedBlobWriter, err error)
+}
+
+// Unnamed writer for storages that need the blob id upfront, the data
+// is kept in memory or in a temporary file until the blob id is known
+type spillWriter struct {
+	storage   BlobStorage
+	threshold int // Size of data kept in memory
+	buffer    bytes.Buffer
+	file      *os.File
+}
+
+func (s *spillWriter) Write(p []byte) (n int, err error) {
+	if s.file == nil && s.buffer.Len()+len(p) > s.threshold {
+		if s.file, err = ioutil.TempFile("", "cinode-spill-"); err != nil {
+			return 0, err
+		}
+		runtime.SetFinalizer(s, (*spillWriter).Cancel)
+		if _, err = s.buffer.WriteTo(s.file); err != nil {
+			return 0, err
+		}
+	}
+	if s.file != nil {
+		return s.file.Write(p)
+	}
+	return s.buffer.Write(p)
+}
+
+func (s *spillWriter) FinalizeAs(blobId string) (duplicate bool, err error) {
+	defer s.Cancel()
+
+	// Hash-validated blob with the same id has the same content, there's
+	// no need to transfer the data unless the blob expires - it's written
+	// again to be kept until deleted
+	if exists, err := existsWithoutExpiry(s.storage, blobId); err == nil && exists {
+		return true, nil
+	}
+
+	var data io.Reader = &s.buffer
+	if s.file != nil {
+		if _, err = s.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		data = s.file
+	}
+
+	writer, err := s.storage.NewBlobWriter(blobId)
+	if err != nil {
+		return false, err
+	}
+	if _, err = io.Copy(writer, data); err != nil {
+		writer.Cancel()
+		return false, err
+	}
+	return writer.Finalize()
+}
+
+func (s *spillWriter) Cancel() error {
+	s.buffer.Reset()
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+		runtime.SetFinalizer(s, nil)
+	}
+	return nil
+}
+
+func (s *spillWriter) Close() error {
+	return s.Cancel()
+}
+
+func (s *memoryBlobStorage) NewUnnamedBlobWriter() (writer UnnamedBlobWriter, err error) {
+	return &memoryBlobWriter{storage: s}, nil
+}
+
+func (f *memoryBlobWriter) FinalizeAs(blobId string) (duplicate bool, err error) {
+	f.bid = blobId
+	return f.Finalize()
+}
+
+func (s *fileBlobStorage) NewUnnamedBlobWriter() (writer UnnamedBlobWriter, err error) {
+	w, err := newFileBlobWriter(s, "")
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (f *fileBlobWriter) FinalizeAs(blobId string) (duplicate bool, err error) {
+	f.bid = blobId
+	return f.Finalize()
+}
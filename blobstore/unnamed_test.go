@@ -0,0 +1,72 @@
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestHashBlobStreaming(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-unnamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Force the spill to a temporary file
+	l := DefaultLimits
+	l.SpillThreshold = 1024
+	config := &WriterConfig{Limits: &l}
+
+	content := make([]byte, 100*1024)
+	for i := range content {
+		content[i] = byte(i ^ (i >> 8))
+	}
+
+	var expectedBid, expectedKey string
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+		NewAccessTracker(NewMemoryBlobStorage()),
+	} {
+		reads := 0
+		bid, key, err := createHashValidatedBlobFromReaderGenerator(func() io.Reader {
+			reads++
+			return bytes.NewReader(content)
+		}, storage, config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Once for the key, once for the encryption
+		if reads != 2 {
+			t.Fatalf("Content read %v times", reads)
+		}
+		if expectedBid == "" {
+			expectedBid, expectedKey = bid, key
+		}
+		if bid != expectedBid || key != expectedKey {
+			t.Fatal("Blob depends on the storage")
+		}
+
+		reader, err := createReaderForHashBlob(bid, key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Fatal("Invalid blob content")
+		}
+	}
+
+	// No temporary files are left
+	if names, _ := ioutil.ReadDir(dir); len(names) != 1 {
+		t.Fatalf("Invalid files in the storage: %v", len(names))
+	}
+}
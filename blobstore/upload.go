@@ -0,0 +1,264 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"github.com/cinode/golib/cipherfactory"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIrreproducibleCipher = errors.New("Reproducible upload requires the default cipher and hash algorithms")
+)
+
+// Options of storing local files
+type UploadOptions struct {
+
+	// Store the content the same way on every machine, identical trees
+	// get identical blob ids and independent uploads deduplicate. Files are
+	// split with content-defined chunking of fixed parameters regardless
+	// of configured limits, mime types come from the built-in table only and
+	// the default cipher and hash algorithms must be configured.
+	Reproducible bool
+
+	// Index of chunks uploaded before, files are checked against it
+	// to skip encrypting already stored content, nil if not used
+	ChunkIndex ChunkIndex
+
+	// Record permission bits and modification times of files and
+	// directories, identical trees copied at different times get
+	// different blob ids then
+	Metadata bool
+
+	// Journal of the single file upload, the upload is resumed from
+	// the end of recorded partial blobs. Directory uploads ignore it.
+	Journal UploadJournal
+
+	// Compress files before encryption, already compressed data is
+	// detected and stored as is. Reproducible uploads never compress,
+	// compressed output may change with the compressor implementation.
+	Compress bool
+
+	// Layout of created blobs and limits of writers, defaults are used
+	// if nil. Reproducible uploads always use the default layout.
+	Config *WriterConfig
+
+	// Called with the number of bytes of local files read so far, the total
+	// size of files to upload and the path of the file being read, nil if
+	// not needed. Resumed uploads count bytes recorded in the journal
+	// as done.
+	Progress ProgressFunc
+
+	// Minimum time between calls of the progress function,
+	// DefaultProgressInterval if 0
+	ProgressInterval time.Duration
+
+	// Progress of the whole upload
+	progress *ProgressCounter
+}
+
+// Chunking parameters of reproducible uploads, they must never change
+// since blob ids of uploaded files depend on them
+var reproducibleLimits = Limits{
+	ContentDefined: true,
+	CDCMinSize:     512 * 1024,
+	CDCMaxSize:     8 * 1024 * 1024,
+	CDCMaskBits:    21,
+}
+
+// Mime types of reproducible uploads by extensions, the table must
+// not change since blob ids of directories depend on it
+var reproducibleMimeTypes = map[string]string{
+	".css":  "text/css; charset=utf-8",
+	".gif":  "image/gif",
+	".htm":  "text/html; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".js":   "text/javascript; charset=utf-8",
+	".json": "application/json",
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".txt":  "text/plain; charset=utf-8",
+	".wasm": "application/wasm",
+	".webp": "image/webp",
+	".xml":  "text/xml; charset=utf-8",
+}
+
+// Store the local directory with all its content, bid and key of the root
+// directory blob are returned. Mime types of files are guessed from their
+// extensions, subdirectories have empty mime type. Only regular files and
+// directories are stored, symlinks and special files are skipped.
+func UploadDirectory(path string, storage BlobStorage) (bid, key string, err error) {
+	return UploadDirectoryWithOptions(path, storage, UploadOptions{})
+}
+
+// Store the local directory the way requested by options
+func UploadDirectoryWithOptions(path string, storage BlobStorage, options UploadOptions) (bid, key string, err error) {
+	if err = options.check(); err != nil {
+		return "", "", err
+	}
+	options.Journal = nil
+	if options.Progress != nil {
+		total, err := treeSize(path)
+		if err != nil {
+			return "", "", err
+		}
+		options.progress = NewProgressCounter(options.Progress, options.ProgressInterval, nil, total)
+	}
+	if bid, key, err = uploadDirectory(path, storage, options); err == nil {
+		options.progress.Finish()
+	}
+	return
+}
+
+// Get the size of regular files of the local directory tree
+func treeSize(path string) (size int64, err error) {
+	err = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return err
+	})
+	return
+}
+
+func uploadDirectory(path string, storage BlobStorage, options UploadOptions) (bid, key string, err error) {
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	writer := DirBlobWriter{Storage: storage, Config: options.writerConfig()}
+	for _, info := range infos {
+		entry := DirEntry{Name: info.Name()}
+		entryPath := filepath.Join(path, info.Name())
+
+		switch {
+		case info.IsDir():
+			entry.Bid, entry.Key, err = uploadDirectory(entryPath, storage, options)
+		case info.Mode().IsRegular():
+			entry.MimeType = options.mimeType(info.Name())
+			entry.Bid, entry.Key, err = uploadFile(entryPath, storage, options)
+		default:
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if options.Metadata {
+			entry.Mode, entry.ModTime = info.Mode().Perm(), info.ModTime()
+		}
+
+		if err = writer.AddEntry(entry); err != nil {
+			return "", "", err
+		}
+	}
+
+	result, err := writer.Finalize()
+	return result.Bid, result.Key, err
+}
+
+// Store the local file, bid and key of the file blob are returned.
+// Buffer sizes and chunking follow default limits.
+func UploadFile(path string, storage BlobStorage) (bid, key string, err error) {
+	return UploadFileWithOptions(path, storage, UploadOptions{})
+}
+
+// Store the local file the way requested by options
+func UploadFileWithOptions(path string, storage BlobStorage, options UploadOptions) (bid, key string, err error) {
+	if err = options.check(); err != nil {
+		return "", "", err
+	}
+	if options.Progress != nil {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", "", err
+		}
+		options.progress = NewProgressCounter(options.Progress, options.ProgressInterval, nil, info.Size())
+	}
+	if bid, key, err = uploadFile(path, storage, options); err == nil {
+		options.progress.Finish()
+	}
+	return
+}
+
+func uploadFile(path string, storage BlobStorage, options UploadOptions) (bid, key string, err error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	l := options.Config.limits()
+	writer := FileBlobWriter{
+		Storage:        storage,
+		ContentDefined: l.ContentDefined,
+		ChunkIndex:     options.ChunkIndex,
+		Journal:        options.Journal,
+		Compress:       options.Compress && !options.Reproducible,
+		Config:         options.writerConfig(),
+	}
+	if options.Reproducible {
+		writer.ContentDefined, writer.ChunkLimits = true, &reproducibleLimits
+	}
+	if options.Journal != nil {
+		offset, err := writer.Resume()
+		if err != nil {
+			return "", "", err
+		}
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			return "", "", err
+		}
+		options.progress.Add(offset, path)
+	}
+	reader := options.progress.Reader(file, path)
+	if _, err = io.CopyBuffer(&writer, reader, make([]byte, l.StreamBufferSize)); err != nil {
+		writer.Cancel()
+		return "", "", err
+	}
+	result, err := writer.Finalize()
+	return result.Bid, result.Key, err
+}
+
+// Check whether the upload can be done the requested way
+func (o UploadOptions) check() error {
+	if o.Reproducible && (o.Config.cipherAlgorithm() != cipherfactory.DefaultAlgorithm ||
+		o.Config.hashAlgorithm() != cipherfactory.DefaultHashAlgorithm) {
+		return ErrIrreproducibleCipher
+	}
+	return o.Config.validate()
+}
+
+// Get the layout of created blobs, reproducible uploads
+// keep only limits of the configuration
+func (o UploadOptions) writerConfig() *WriterConfig {
+	if o.Reproducible {
+		if o.Config == nil {
+			return nil
+		}
+		return &WriterConfig{Limits: o.Config.Limits}
+	}
+	return o.Config
+}
+
+// Get the mime type of the file by its name
+func (o UploadOptions) mimeType(name string) string {
+	ext := filepath.Ext(name)
+	if o.Reproducible {
+		return reproducibleMimeTypes[strings.ToLower(ext)]
+	}
+	return mime.TypeByExtension(ext)
+}
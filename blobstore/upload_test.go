@@ -0,0 +1,119 @@
+package blobstore
+
+import (
+	"bytes"
+	"github.com/cinode/golib/cipherfactory"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadDirectory(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "sub", "empty"), 0777)
+	ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("Hello World!"), 0666)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "data.bin"), []byte{1, 2, 3}, 0666)
+	os.Symlink("hello.txt", filepath.Join(dir, "link"))
+
+	storage := NewMemoryBlobStorage()
+	bid, key, err := UploadDirectory(dir, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := OpenDirBlob(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := rdr.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "hello.txt" || entries[1].Name != "sub" {
+		t.Fatalf("Invalid root entries: %v", entries)
+	}
+	if entries[0].MimeType != "text/plain; charset=utf-8" {
+		t.Fatalf("Invalid mime type: %v", entries[0].MimeType)
+	}
+
+	file, err := OpenFileBlob(entries[0].Bid, entries[0].Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(file); !bytes.Equal(data, []byte("Hello World!")) {
+		t.Fatal("Invalid file content")
+	}
+
+	rdr, err = OpenDirBlob(entries[1].Bid, entries[1].Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub, _ := rdr.Entries(); len(sub) != 2 || sub[0].Name != "data.bin" || sub[1].Name != "empty" {
+		t.Fatalf("Invalid subdirectory entries: %v", sub)
+	}
+
+	if _, _, err = UploadDirectory(filepath.Join(dir, "missing"), storage); !os.IsNotExist(err) {
+		t.Fatalf("Invalid error for missing directory: %v", err)
+	}
+}
+
+func TestUploadReproducible(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 2*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	ioutil.WriteFile(filepath.Join(dir, "data.bin"), data, 0666)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("Hello"), 0666)
+
+	upload := func(l Limits, options UploadOptions) string {
+		options.Config = &WriterConfig{Limits: &l}
+		bid, _, err := UploadDirectoryWithOptions(dir, NewMemoryBlobStorage(), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bid
+	}
+
+	// Configured limits change the chunking unless the upload is reproducible
+	if upload(DefaultLimits, UploadOptions{}) == upload(ConstrainedLimits, UploadOptions{}) {
+		t.Fatal("Chunking does not depend on limits")
+	}
+	reproducible := UploadOptions{Reproducible: true}
+	if upload(DefaultLimits, reproducible) != upload(ConstrainedLimits, reproducible) {
+		t.Fatal("Reproducible upload depends on limits")
+	}
+
+	storage := NewMemoryBlobStorage()
+	bid, key, err := UploadDirectoryWithOptions(dir, storage, reproducible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, _ := OpenDirBlob(bid, key, storage)
+	if entries, _ := rdr.Entries(); len(entries) != 2 || entries[0].MimeType != "" ||
+		entries[1].MimeType != "" {
+		t.Fatalf("Mime types not normalized: %v", entries)
+	}
+
+	reproducible.Config = &WriterConfig{CipherAlgorithm: cipherfactory.AlgorithmAES256GCM}
+	if _, _, err = UploadDirectoryWithOptions(dir, storage, reproducible); err != ErrIrreproducibleCipher {
+		t.Fatalf("Invalid error for non-default cipher: %v", err)
+	}
+
+	reproducible.Config = &WriterConfig{HashAlgorithm: cipherfactory.HashBLAKE3}
+	if _, _, err = UploadDirectoryWithOptions(dir, storage, reproducible); err != ErrIrreproducibleCipher {
+		t.Fatalf("Invalid error for non-default hash: %v", err)
+	}
+}
@@ -5,60 +5,115 @@
 package blobstore
 
 import (
-	"bytes"
 	"crypto/sha512"
-	"encoding/hex"
+	"github.com/cinode/golib/cipherfactory"
+	"hash"
 	"io"
 )
 
-func createHashValidatedBlobFromReaderGenerator(readerGenerator func() io.Reader, storage BlobStorage) (bid string, key string, err error) {
+func createHashValidatedBlobFromReaderGenerator(readerGenerator func() io.Reader, storage BlobStorage, config *WriterConfig, stats *UploadStats) (bid string, key string, err error) {
 
 	// Generate the key
+	probe := startProbe()
 	hasher := sha512.New()
-	io.Copy(hasher, readerGenerator())
-	keySource := hasher.Sum(nil)
+	n, _ := io.Copy(hasher, readerGenerator())
+	probe.lap(StageHash, n)
 
-	// Generate the encrypted content
-	encryptedBuffer := bytes.Buffer{}
-	encryptedWriter, key, err := createEncryptor(keySource, nil, &encryptedBuffer)
-	if err != nil {
-		return
-	}
-	io.Copy(encryptedWriter, readerGenerator())
+	return createHashValidatedBlobWithKeySource(hasher.Sum(nil), readerGenerator, storage, config, stats)
+}
 
-	// Generate blob id
-	hasher.Reset()
-	io.Copy(hasher, bytes.NewReader(encryptedBuffer.Bytes()))
-	bid = hex.EncodeToString(hasher.Sum(nil))
+// Create hash-validated blob if the hash of the content (used as the key source)
+// is already known, this way the content does not have to be hashed again.
+// The content is read once more to encrypt it, the blob id is only known once
+// all the encrypted data is hashed. Storages implementing UnnamedBlobStorage
+// receive the data directly, otherwise it's kept in a spill buffer.
+// Algorithms and the spill threshold are taken from the config.
+// The stored blob is accounted in stats unless it's nil.
+func createHashValidatedBlobWithKeySource(keySource []byte, readerGenerator func() io.Reader, storage BlobStorage, config *WriterConfig, stats *UploadStats) (bid string, key string, err error) {
 
-	// Finally generate the blob itself
-	blobWriter, err := storage.NewBlobWriter(bid)
-	if err != nil {
-		return
+	probe := startProbe()
+
+	var output UnnamedBlobWriter
+	if unnamed, ok := storage.(UnnamedBlobStorage); ok {
+		if output, err = unnamed.NewUnnamedBlobWriter(); err != nil {
+			return
+		}
+	} else {
+		output = &spillWriter{storage: storage, threshold: config.limits().SpillThreshold}
 	}
 	defer func() {
 		if err != nil {
-			blobWriter.Cancel()
+			output.Cancel()
 		}
 	}()
-	if _, err = blobWriter.Write([]byte{validationMethodHash}); err != nil {
+
+	if _, err = output.Write([]byte{validationMethodHash}); err != nil {
+		return
+	}
+
+	// Encrypt the content, the blob id is calculated along the way
+	hashAlgorithm := config.hashAlgorithm()
+	hasher, err := cipherfactory.CreateHasher(hashAlgorithm)
+	if err != nil {
+		return
+	}
+	counter := countingWriter{count: 1} // The validation method is already written
+	encryptedWriter, key, err := createEncryptor(config, keySource, nil, io.MultiWriter(hasher, &counter, output))
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(encryptedWriter, readerGenerator()); err != nil {
 		return
 	}
-	if _, err = io.Copy(blobWriter, &encryptedBuffer); err != nil {
+	if err = encryptedWriter.Close(); err != nil {
 		return
 	}
-	if err = blobWriter.Finalize(); err != nil {
+	bid = formatBid(hashAlgorithm, hasher.Sum(nil))
+	probe.lap(StageEncrypt, counter.count)
+
+	duplicate, err := output.FinalizeAs(bid)
+	if err != nil {
 		return
 	}
+	probe.lap(StageWrite, counter.count)
+	stats.record(counter.count, duplicate)
 
 	// Ok, we're done here
 	return
 }
 
+// Reader validating the hash of data read through it, the validation
+// is done once the end of the stream is reached
+type hashValidatingReader struct {
+	reader io.Reader // Source of data
+	hasher hash.Hash // Hash of data read so far
+	bid    string    // Expected blob id
+}
+
+func (h *hashValidatingReader) Read(p []byte) (n int, err error) {
+	n, err = h.reader.Read(p)
+	h.hasher.Write(p[:n])
+	if err == io.EOF && !bidMatchesSum(h.bid, h.hasher.Sum(nil)) {
+		err = ErrInvalidHashBlobContent
+	}
+	return
+}
+
 func createReaderForHashBlobData(reader io.Reader, bid, key string) (rawReader io.Reader, err error) {
-	// TODO: We could validate the content while it's being read - generate the hash
-	// and throw some error when reaching EOF and having invalid hash
-	return createDecryptor(key, nil, reader)
+
+	// Content of the blob with the id not naming any hash can't be valid
+	hasher, err := createBidHasher(bid)
+	if err != nil {
+		return nil, ErrInvalidHashBlobContent
+	}
+
+	// The content is validated while it's being read, an error
+	// is returned when reaching EOF and having invalid hash
+	return createDecryptor(key, nil, &hashValidatingReader{
+		reader: reader,
+		hasher: hasher,
+		bid:    bid,
+	})
 }
 
 func createReaderForHashBlob(bid string, key string, storage BlobStorage) (rawReader io.Reader, err error) {
@@ -3,13 +3,57 @@ package blobstore
 import (
 	"bytes"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/sha512"
 	"crypto/x509"
 	"encoding/hex"
+	"errors"
+	"hash"
 	"io"
+	"io/ioutil"
 )
 
-type privateKey *rsa.PrivateKey
+// Private key used to sign blobs, *rsa.PrivateKey and ed25519.PrivateKey are supported
+type privateKey crypto.Signer
+
+// Create new version of the signed blob. The blob id depends on the public
+// key only, newer versions replace older ones in storages which makes
+// signed blobs usable as mutable pointers, i.e. to roots of directory trees.
+func CreateSignedBlob(privKey crypto.Signer, version int64, content []byte, storage BlobStorage) (bid, key string, err error) {
+	return createSignValidatedBlobFromReaderGenerator(
+		func() io.Reader { return bytes.NewReader(content) },
+		privKey, version, storage)
+}
+
+// Get the blob id of signed blobs created with the private key
+// of given public key
+func SignedBlobId(pubKey crypto.PublicKey) (string, error) {
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(createDataHash(pubKeyBytes)), nil
+}
+
+// Open the signed blob, the signature is verified once the content reaches EOF
+func OpenSignedBlob(bid, key string, storage BlobStorage) (version int64, content io.Reader, err error) {
+	bid, key = canonicalForm(bid), canonicalForm(key)
+	reader, err := storage.NewBlobReader(bid)
+	if err != nil {
+		return
+	}
+
+	validationType, err := deserializeInt(reader)
+	if err != nil {
+		return
+	}
+	if validationType != validationMethodSign {
+		return 0, nil, ErrInvalidValidationMethod
+	}
+
+	return createReaderForSignedBlobData(reader, bid, key)
+}
 
 func createSignValidatedBlobFromReaderGenerator(
 	readerGenerator func() io.Reader,
@@ -24,30 +68,48 @@ func createSignValidatedBlobFromReaderGenerator(
 ) {
 
 	// We're using hash of the private key to create the encryption data key
-	dataKey := createDataHash(x509.MarshalPKCS1PrivateKey(privKey))
+	privKeyBytes, err := marshalPrivateKey(privKey)
+	if err != nil {
+		return
+	}
+	dataKey := createDataHash(privKeyBytes)
 
-	// Version + encrypted data buffer
+	// Version + IV source + encrypted data buffer
 	verDataBuffer := bytes.Buffer{}
 	serializeInt(dataVersion, &verDataBuffer)
 
-	// Encrypt the data, note we're using the version bytes as IV
-	encryptedWriter, key, err := createEncryptor(dataKey, verDataBuffer.Bytes(), &verDataBuffer)
+	// The key is the same for all versions, the IV source is the hash of the
+	// version and the content keyed with the data key so that different
+	// content never shares the IV, even if signed with the same version
+	ivHasher := createDataHasher()
+	ivHasher.Write(dataKey)
+	ivHasher.Write(verDataBuffer.Bytes())
+	if _, err = io.Copy(ivHasher, readerGenerator()); err != nil {
+		return
+	}
+	ivSource := ivHasher.Sum(nil)[:signedIVSourceSize]
+	serializeBuffer(ivSource, &verDataBuffer)
+
+	// Encrypt the data
+	encryptedWriter, key, err := createEncryptor(nil, dataKey, ivSource, &verDataBuffer)
 	if err != nil {
 		return
 	}
-	io.Copy(encryptedWriter, readerGenerator())
+	if _, err = io.Copy(encryptedWriter, readerGenerator()); err != nil {
+		return
+	}
+	if err = encryptedWriter.Close(); err != nil {
+		return
+	}
 
-	// Calculate the signature of version + encrypted data blob
-	signature, err := rsa.SignPKCS1v15(
-		nil, privKey,
-		crypto.SHA512,
-		createDataHash(verDataBuffer.Bytes()))
+	// Calculate the signature of version + IV source + encrypted data blob
+	signature, err := privKey.Sign(nil, createDataHash(verDataBuffer.Bytes()), signerOpts(privKey))
 	if err != nil {
 		return
 	}
 
 	// Generate the public key blob
-	pubKey, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	pubKey, err := x509.MarshalPKIXPublicKey(privKey.Public())
 	if err != nil {
 		return
 	}
@@ -82,7 +144,7 @@ func createSignValidatedBlobFromReaderGenerator(
 	}
 
 	// Finalize the blob
-	if err = blobWriter.Finalize(); err != nil {
+	if _, err = blobWriter.Finalize(); err != nil {
 		return
 	}
 
@@ -90,7 +152,37 @@ func createSignValidatedBlobFromReaderGenerator(
 	return bid, key, nil
 }
 
-func createReaderForSignedBlobData(reader io.Reader, bid, key string) (rawReader io.Reader, err error) {
+// Get the private key bytes used as the source of the data key
+func marshalPrivateKey(privKey privateKey) ([]byte, error) {
+	if rsaKey, ok := privKey.(*rsa.PrivateKey); ok {
+		return x509.MarshalPKCS1PrivateKey(rsaKey), nil
+	}
+	return x509.MarshalPKCS8PrivateKey(privKey)
+}
+
+// Options of the signature, both RSA and Ed25519 keys sign the SHA-512 hash of the data
+func signerOpts(privKey privateKey) crypto.SignerOpts {
+	if _, ok := privKey.(ed25519.PrivateKey); ok {
+		return &ed25519.Options{Hash: crypto.SHA512}
+	}
+	return crypto.SHA512
+}
+
+func createReaderForSignedBlobData(reader io.Reader, bid, key string) (version int64, rawReader io.Reader, err error) {
+
+	version, ivSource, validating, err := createValidatingReaderForSignedBlobData(reader, bid)
+	if err != nil {
+		return
+	}
+
+	// Create the decryptor of the content
+	rawReader, err = createDecryptor(key, ivSource, validating)
+	return
+}
+
+// Parse the header of the signed blob, the returned reader gives the encrypted
+// content and checks the signature once the whole content is read
+func createValidatingReaderForSignedBlobData(reader io.Reader, bid string) (version int64, ivSource []byte, validating io.Reader, err error) {
 
 	// Grab the public key blob
 	pubkey, err := deserializeBuffer(reader, maxSanePubKeyLength)
@@ -100,17 +192,18 @@ func createReaderForSignedBlobData(reader io.Reader, bid, key string) (rawReader
 
 	// Validate blob id agains public key
 	if hex.EncodeToString(createDataHash(pubkey)) != bid {
-		return nil, ErrInvalidPublicKeyBid
+		return 0, nil, nil, ErrInvalidPublicKeyBid
 	}
 
 	// Parse the public key
-	pubKeyParsedRaw, err := x509.ParsePKIXPublicKey(pubkey)
+	pubKeyParsed, err := x509.ParsePKIXPublicKey(pubkey)
 	if err != nil {
 		return
 	}
-	pubKeyParsed, ok := pubKeyParsedRaw.(*rsa.PublicKey)
-	if !ok {
-		return nil, ErrUnknownPublicKeyType
+	switch pubKeyParsed.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return 0, nil, nil, ErrUnknownPublicKeyType
 	}
 
 	// Read the signature
@@ -119,39 +212,117 @@ func createReaderForSignedBlobData(reader io.Reader, bid, key string) (rawReader
 		return
 	}
 
-	// Read the version
-	version, err := deserializeInt(reader)
-	if err != nil {
+	// Read the version and the IV source
+	if version, err = deserializeInt(reader); err != nil {
 		return
 	}
+	if ivSource, err = deserializeBuffer(reader, signedIVSourceSize); err != nil {
+		return
+	}
+	if len(ivSource) != signedIVSourceSize {
+		return 0, nil, nil, ErrInvalidSignedIVSource
+	}
 
-	// TODO: Create validating reader that will check the signature when
-	//       we reach EOF
-	_, _ = signature, pubKeyParsed
-
-	// Create the decryptor of the content
+	// The signature covers the version, the IV source and the encrypted
+	// data, it's checked once the whole content is read
 	verBuffer := bytes.Buffer{}
 	serializeInt(version, &verBuffer)
-	return createDecryptor(key, verBuffer.Bytes(), reader)
+	serializeBuffer(ivSource, &verBuffer)
+	validator := &signatureValidatingReader{
+		reader:    reader,
+		hasher:    createDataHasher(),
+		pubKey:    pubKeyParsed,
+		signature: signature,
+	}
+	validator.hasher.Write(verBuffer.Bytes())
+
+	return version, ivSource, validator, nil
+}
+
+// Verify the signed blob positioned right after the validation method,
+// the public key must match the blob id and the signature must be valid
+func verifySignedBlob(bid string, reader io.Reader) (version int64, err error) {
+	version, _, validating, err := createValidatingReaderForSignedBlobData(reader, bid)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = io.Copy(ioutil.Discard, validating); err != nil {
+		return 0, err
+	}
+	return version, nil
 }
 
 func createReaderForSignedBlob(bid string, key string, storage BlobStorage) (rawReader io.Reader, err error) {
+	_, rawReader, err = OpenSignedBlob(bid, key, storage)
+	return
+}
 
-	// Get the reader
-	encryptedReader, err := storage.NewBlobReader(bid)
-	if err != nil {
-		return
+// Reader checking the signature of the data when EOF is reached
+type signatureValidatingReader struct {
+	reader    io.Reader
+	hasher    hash.Hash
+	pubKey    interface{}
+	signature []byte
+}
+
+func (s *signatureValidatingReader) Read(p []byte) (n int, err error) {
+	n, err = s.reader.Read(p)
+	s.hasher.Write(p[:n])
+	if err == io.EOF && !verifySignature(s.pubKey, s.hasher.Sum(nil), s.signature) {
+		return n, ErrInvalidSignature
+	}
+	return
+}
+
+func verifySignature(pubKey interface{}, digest, signature []byte) bool {
+	switch k := pubKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA512, digest, signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.VerifyWithOptions(k, digest, signature, &ed25519.Options{Hash: crypto.SHA512}) == nil
 	}
+	return false
+}
 
-	// Test the validation method
-	validationType, err := deserializeInt(encryptedReader)
+// Decide whether the updated signed blob should replace the existing one,
+// newer versions replace older ones and the existing blob is kept if it's
+// the same. Other content of the same version is rejected with
+// ErrSignedBlobConflict. The updated blob must be signed with the key of
+// the blob id, blobs failing the verification are rejected as corrupted so
+// that forged blobs with high versions can't block updates. Existing blobs
+// failing the verification are replaced.
+func shouldReplaceSignedBlob(bid string, existing, updated io.Reader) (bool, error) {
+	updatedHash, existingHash := sha512.New(), sha512.New()
+	updatedVersion, err := readVerifiedSignedBlob(bid, io.TeeReader(updated, updatedHash))
 	if err != nil {
-		return
+		return false, err
 	}
-	if validationType != validationMethodSign {
-		return nil, ErrInvalidValidationMethod
+	existingVersion, err := readVerifiedSignedBlob(bid, io.TeeReader(existing, existingHash))
+	if err != nil {
+		return true, nil
+	}
+	switch {
+	case updatedVersion < existingVersion:
+		return false, ErrSignedBlobOutdated
+	case updatedVersion == existingVersion && !bytes.Equal(updatedHash.Sum(nil), existingHash.Sum(nil)):
+		return false, ErrSignedBlobConflict
 	}
+	return updatedVersion > existingVersion, nil
+}
 
-	// Get the encryptor
-	return createReaderForSignedBlobData(encryptedReader, bid, key)
+// Read the version of the raw signed blob verifying the blob, verification
+// failures are reported as corruption of the blob
+func readVerifiedSignedBlob(bid string, reader io.Reader) (int64, error) {
+	method, err := deserializeInt(reader)
+	if err == nil && method != validationMethodSign {
+		err = ErrInvalidValidationMethod
+	}
+	var version int64
+	if err == nil {
+		version, err = verifySignedBlob(bid, reader)
+	}
+	if err != nil && !errors.Is(err, ErrBlobCorrupted) {
+		err = ErrMalformedSignedBlob
+	}
+	return version, blobCorrupted(bid, err)
 }
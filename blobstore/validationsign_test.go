@@ -2,11 +2,15 @@ package blobstore
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	//"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"os"
 	"testing"
 )
 
@@ -45,3 +49,206 @@ func TestSimpleWriteReadCycle(t *testing.T) {
 		t.Fatal("Invalid data read from the blob", data, testData)
 	}
 }
+
+func TestEd25519SignedBlob(t *testing.T) {
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewMemoryBlobStorage()
+	bid, key, err := CreateSignedBlob(privKey, 1, []byte("Hello world!"), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, reader, err := OpenSignedBlob(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || !bytes.Equal(data, []byte("Hello world!")) {
+		t.Fatalf("Invalid signed blob content: %v, %s", version, data)
+	}
+
+	// Any modification of the data must be detected
+	blob, _ := storage.(*memoryBlobStorage).lookup(bid)
+	for _, pos := range []int{len(blob) - 1, len(blob) - 12} {
+		corrupted := append([]byte{}, blob...)
+		corrupted[pos] ^= 0x01
+		storage.(*memoryBlobStorage).store(bid, corrupted)
+
+		_, reader, err = OpenSignedBlob(bid, key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ioutil.ReadAll(reader); err != ErrInvalidSignature {
+			t.Fatalf("Invalid error for corrupted signed blob: %v", err)
+		}
+	}
+}
+
+func TestSignedBlobUpdates(t *testing.T) {
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "cinode-signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+	} {
+		bid, key, err := CreateSignedBlob(privKey, 2, []byte("second"), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, d := range []struct {
+			version  int64
+			content  string
+			err      error
+			expected string
+		}{
+			{1, "first", ErrSignedBlobOutdated, "second"},
+			{2, "other", ErrSignedBlobConflict, "second"},
+			{2, "second", nil, "second"},
+			{3, "third", nil, "third"},
+		} {
+			bid2, key2, err := CreateSignedBlob(privKey, d.version, []byte(d.content), storage)
+			if err != d.err {
+				t.Fatalf("Invalid error when writing version %v: %v", d.version, err)
+			}
+			if err == nil && (bid2 != bid || key2 != key) {
+				t.Fatal("Bid or key of the signed blob changed between versions")
+			}
+
+			_, reader, err := OpenSignedBlob(bid, key, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(reader)
+			closeReader(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != d.expected {
+				t.Fatalf("Invalid content after writing version %v: %s", d.version, data)
+			}
+		}
+	}
+}
+
+func TestForgedSignedBlobUpdate(t *testing.T) {
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "cinode-signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []BlobStorage{
+		NewMemoryBlobStorage(),
+		NewFileBlobStorage(dir),
+		NewKeyValueBlobStorage(&mapKeyValueStore{}),
+	} {
+		bid, key, err := CreateSignedBlob(privKey, 1, []byte("first"), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Blob of other key with the highest version stored under the blob id
+		other := NewMemoryBlobStorage()
+		otherBid, _, err := CreateSignedBlob(otherKey, math.MaxInt64, []byte("forged"), other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged, _ := other.(*memoryBlobStorage).lookup(otherBid)
+
+		// Signature not matching the content
+		tampered, err := storage.NewBlobReader(bid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid, _ := ioutil.ReadAll(tampered)
+		closeReader(tampered)
+		tamperedData := append([]byte{}, valid...)
+		tamperedData[len(tamperedData)-1] ^= 0x01
+
+		for _, data := range [][]byte{forged, tamperedData, {validationMethodSign}} {
+			w, err := storage.NewBlobWriter(bid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data)
+			if _, err = w.Finalize(); !errors.Is(err, ErrBlobCorrupted) {
+				t.Fatalf("Forged signed blob must be rejected as corrupted, got: %v", err)
+			}
+		}
+
+		if _, _, err = CreateSignedBlob(privKey, 2, []byte("second"), storage); err != nil {
+			t.Fatalf("Update blocked by the forged blob: %v", err)
+		}
+		_, reader, err := OpenSignedBlob(bid, key, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		closeReader(reader)
+		if err != nil || string(data) != "second" {
+			t.Fatalf("Invalid content after the update: %q %v", data, err)
+		}
+	}
+}
+
+func TestSignedBlobIVDependsOnContent(t *testing.T) {
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Different content of the same version must not share the IV
+	ivSource := func(content string) []byte {
+		storage := NewMemoryBlobStorage()
+		bid, _, err := CreateSignedBlob(privKey, 5, []byte(content), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob, _ := storage.(*memoryBlobStorage).lookup(bid)
+		reader := bytes.NewReader(blob[1:])
+		deserializeBuffer(reader, maxSanePubKeyLength)
+		deserializeBuffer(reader, maxSaneSignatureLength)
+		deserializeInt(reader)
+		iv, err := deserializeBuffer(reader, signedIVSourceSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iv
+	}
+	if a, b := ivSource("first"), ivSource("second"); bytes.Equal(a, b) {
+		t.Fatal("Versions of different content are encrypted with the same IV")
+	}
+	if a, b := ivSource("same"), ivSource("same"); !bytes.Equal(a, b) {
+		t.Fatal("Signed blobs must be deterministic")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Check the integrity of the raw blob as stored in a storage, the key is not
+// needed. Content of hash-validated blobs must match the blob id, signed
+// blobs must be signed with the public key matching the blob id.
+func VerifyBlob(bid string, reader io.Reader) error {
+
+	method, err := deserializeInt(reader)
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case validationMethodHash:
+		hasher, err := createBidHasher(bid)
+		if err != nil {
+			return ErrInvalidHashBlobContent
+		}
+		if _, err = io.Copy(hasher, reader); err != nil {
+			return err
+		}
+		if !bidMatchesSum(bid, hasher.Sum(nil)) {
+			return ErrInvalidHashBlobContent
+		}
+		return nil
+
+	case validationMethodSign:
+		_, err := verifySignedBlob(bid, reader)
+		return err
+	}
+
+	return ErrInvalidValidationMethod
+}
+
+// Decide whether the written blob replaces the stored one of the same id but
+// different content. Both can't be valid, the stored blob is replaced if it
+// fails the verification and the written one is rejected if it does. The
+// collision is reported if both are invalid, i.e. if the blob id is not
+// a cinode one.
+func replacesCollidingBlob(bid string, stored, written io.Reader) (bool, error) {
+	if err := VerifyBlob(bid, written); err != nil {
+		if VerifyBlob(bid, stored) != nil {
+			return false, blobCorrupted(bid, ErrBIDCollision)
+		}
+		if !errors.Is(err, ErrBlobCorrupted) {
+			err = &BlobCorruptedError{Bid: bid, Err: err}
+		}
+		return false, blobCorrupted(bid, err)
+	}
+	if VerifyBlob(bid, stored) != nil {
+		return true, nil
+	}
+	return false, blobCorrupted(bid, ErrBIDCollision)
+}
+
+// Writer verifying the raw blob written to it with VerifyBlob
+type BlobVerifier struct {
+	pipe   *io.PipeWriter
+	result chan error
+	err    error
+}
+
+// Create the writer verifying the blob with given id in the background, the
+// result is returned by Close. Writes fail once the verification does, blobs
+// failing it are reported as corrupted.
+func NewBlobVerifier(bid string) *BlobVerifier {
+	reader, writer := io.Pipe()
+	v := &BlobVerifier{pipe: writer, result: make(chan error, 1)}
+	go func() {
+		err := VerifyBlob(bid, reader)
+		if err != nil && !errors.Is(err, ErrBlobCorrupted) {
+			err = &BlobCorruptedError{Bid: bid, Err: err}
+		}
+		err = blobCorrupted(bid, err)
+		reader.CloseWithError(err)
+		v.result <- err
+	}()
+	return v
+}
+
+func (v *BlobVerifier) Write(p []byte) (int, error) {
+	return v.pipe.Write(p)
+}
+
+// Finish the verification, nil is returned if the blob is valid
+func (v *BlobVerifier) Close() error {
+	if v.result != nil {
+		v.pipe.Close()
+		v.err = <-v.result
+		v.result = nil
+	}
+	return v.err
+}
+
+// Read the whole blob from the storage and verify it
+func readVerifiedBlob(storage BlobStorage, blobId string) ([]byte, error) {
+	reader, err := storage.NewBlobReader(blobId)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader(reader)
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err = VerifyBlob(blobId, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrSkipDir = errors.New("Skip the directory")
+)
+
+// Entry of the directory blob tree visited by WalkTree
+type TreeEntry struct {
+	DirEntry
+	Path string    // Slash-separated path of the entry within the tree
+	Info *BlobInfo // Information about the blob of the entry, nil if not a blob entry
+}
+
+// Walk the directory blob tree depth-first calling fn for every entry as
+// soon as it's read, entries of each directory are visited in the order of
+// their names. Directory blobs are read entry by entry, memory used by the
+// walk depends on the depth of the tree only. If fn returns ErrSkipDir
+// for a directory, its content is not visited, any other error stops
+// the walk and is returned.
+func WalkTree(bid, key string, storage BlobStorage, fn func(TreeEntry) error) error {
+	return walkTree("", bid, key, storage, fn)
+}
+
+func walkTree(prefix, bid, key string, storage BlobStorage, fn func(TreeEntry) error) error {
+
+	reader, err := OpenDirBlob(bid, key, storage)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var info *BlobInfo
+		if entry.IsBlob() {
+			if info, err = InspectBlobWithKey(entry.Bid, entry.Key, storage); err != nil {
+				return err
+			}
+		}
+
+		path := prefix + entry.Name
+		err = fn(TreeEntry{DirEntry: entry, Path: path, Info: info})
+		switch {
+		case err == ErrSkipDir:
+			continue
+		case err != nil:
+			return err
+		}
+
+		if info != nil && info.IsDir() {
+			if err = walkTree(path+"/", entry.Bid, entry.Key, storage, fn); err != nil {
+				return err
+			}
+		}
+	}
+}
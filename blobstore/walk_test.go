@@ -0,0 +1,71 @@
+package blobstore
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWalkTree(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	file := func(content string) DirEntry {
+		fw := FileBlobWriter{Storage: storage}
+		fw.Write([]byte(content))
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return DirEntry{Bid: ref.Bid, Key: ref.Key}
+	}
+	dir := func(entries map[string]DirEntry) DirEntry {
+		dw := DirBlobWriter{Storage: storage}
+		for name, entry := range entries {
+			entry.Name = name
+			dw.AddEntry(entry)
+		}
+		ref, err := dw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return DirEntry{Bid: ref.Bid, Key: ref.Key}
+	}
+
+	root := dir(map[string]DirEntry{
+		"b.txt": file("b"),
+		"a": dir(map[string]DirEntry{
+			"y.txt": file("y"),
+			"x":     dir(map[string]DirEntry{"z.txt": file("z")}),
+		}),
+		"c": dir(nil),
+	})
+
+	var paths []string
+	err := WalkTree(root.Bid, root.Key, storage, func(entry TreeEntry) error {
+		path := entry.Path
+		if entry.Info.IsDir() {
+			path += "/"
+		}
+		paths = append(paths, path)
+		if entry.Path == "a/x" {
+			return ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(paths, " ") != "a/ a/x/ a/y.txt b.txt c/" {
+		t.Fatalf("Invalid walk: %v", paths)
+	}
+
+	errStop := errors.New("stop")
+	visited := 0
+	err = WalkTree(root.Bid, root.Key, storage, func(entry TreeEntry) error {
+		visited++
+		return errStop
+	})
+	if err != errStop || visited != 1 {
+		t.Fatalf("Walk not stopped: %v, %v", visited, err)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wire encodes values the way they're stored in the unencrypted
+// content of blobs. Integers are unsigned LEB128 varints: 7 bits per byte,
+// least significant group first, the high bit set on all bytes but the
+// last one. Signed integers are zigzag-encoded first so that small negative
+// values stay short. Buffers and strings are prefixed with their length.
+//
+// Decoders accept non-minimal encodings of integers, writers never
+// produce them.
+package wire
+
+import (
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+var (
+	ErrOverflow = errors.New("Integer does not fit in 64 bits")
+	ErrTooLarge = errors.New("Length of the value exceeds the limit")
+	ErrNotUTF8  = errors.New("String is not a UTF-8 sequence")
+)
+
+// Maximum number of bytes of the encoded integer
+const MaxIntLen = 10
+
+// Append the integer, negative values are encoded as their
+// two's complement and take MaxIntLen bytes
+func AppendInt(b []byte, v int64) []byte {
+	return AppendUint(b, uint64(v))
+}
+
+// Append the unsigned integer
+func AppendUint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// Append the zigzag-encoded signed integer
+func AppendVarint(b []byte, v int64) []byte {
+	return AppendUint(b, uint64(v<<1)^uint64(v>>63))
+}
+
+// Append the buffer prefixed with its length
+func AppendBuffer(b []byte, data []byte) []byte {
+	return append(AppendInt(b, int64(len(data))), data...)
+}
+
+// Append the string prefixed with its length
+func AppendString(b []byte, s string) []byte {
+	return append(AppendInt(b, int64(len(s))), s...)
+}
+
+// Get the size of the encoded integer
+func IntLen(v int64) int {
+	n := 1
+	for u := uint64(v); u >= 0x80; u >>= 7 {
+		n++
+	}
+	return n
+}
+
+// Read the integer appended with AppendInt, the reader is read one byte
+// at a time so that no data following the integer is consumed. io.EOF
+// is returned if the data ends before the integer is complete.
+func ReadInt(r io.Reader) (int64, error) {
+	v, err := ReadUint(r)
+	return int64(v), err
+}
+
+// Read the unsigned integer appended with AppendUint
+func ReadUint(r io.Reader) (uint64, error) {
+	var buff [1]byte
+	var v uint64
+	for i, s := 0, uint(0); ; i, s = i+1, s+7 {
+		if _, err := io.ReadFull(r, buff[:]); err != nil {
+			return 0, err
+		}
+		b := buff[0]
+		if i == MaxIntLen-1 && b > 1 {
+			return 0, ErrOverflow
+		}
+		v |= uint64(b&0x7F) << s
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+}
+
+// Read the signed integer appended with AppendVarint
+func ReadVarint(r io.Reader) (int64, error) {
+	u, err := ReadUint(r)
+	if err != nil {
+		return 0, err
+	}
+	v := int64(u >> 1)
+	if u&1 != 0 {
+		v = ^v
+	}
+	return v, nil
+}
+
+// Read the buffer appended with AppendBuffer, ErrTooLarge is returned if
+// it's longer than maxLength bytes
+func ReadBuffer(r io.Reader, maxLength int64) ([]byte, error) {
+	length, err := ReadInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > maxLength {
+		return nil, ErrTooLarge
+	}
+	data := make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Read the string appended with AppendString, ErrNotUTF8 is returned
+// if it's not a valid UTF-8 sequence
+func ReadString(r io.Reader, maxLength int64) (string, error) {
+	data, err := ReadBuffer(r, maxLength)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", ErrNotUTF8
+	}
+	return string(data), nil
+}
@@ -0,0 +1,147 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+// Values around every power of two and all 16-bit values
+func testInts() []int64 {
+	var values []int64
+	for i := int64(0); i <= 0xFFFF; i++ {
+		values = append(values, i)
+	}
+	for s := uint(16); s < 64; s++ {
+		v := uint64(1) << s
+		values = append(values, int64(v-1), int64(v), int64(v+1))
+	}
+	return append(values, -1, -2, -64, -65, math.MaxInt64, math.MinInt64, math.MinInt64+1)
+}
+
+func TestIntRoundTrip(t *testing.T) {
+	for _, v := range testInts() {
+		data := AppendInt(nil, v)
+		if len(data) != IntLen(v) || len(data) > MaxIntLen {
+			t.Fatalf("Invalid length of encoded %d: %d, expected %d", v, len(data), IntLen(v))
+		}
+		if expected := binary.AppendUvarint(nil, uint64(v)); !bytes.Equal(data, expected) {
+			t.Fatalf("Invalid encoding of %d: %x, expected %x", v, data, expected)
+		}
+		r := bytes.NewReader(append(data, 0xAA))
+		decoded, err := ReadInt(r)
+		if err != nil || decoded != v {
+			t.Fatalf("Invalid decoding of %d: %d, error %v", v, decoded, err)
+		}
+		if r.Len() != 1 {
+			t.Fatalf("Data following the integer %d was consumed", v)
+		}
+	}
+}
+
+func TestVarintRoundTrip(t *testing.T) {
+	for _, v := range testInts() {
+		for _, v := range []int64{v, -v} {
+			data := AppendVarint(nil, v)
+			if expected := binary.AppendVarint(nil, v); !bytes.Equal(data, expected) {
+				t.Fatalf("Invalid encoding of %d: %x, expected %x", v, data, expected)
+			}
+			decoded, err := ReadVarint(bytes.NewReader(data))
+			if err != nil || decoded != v {
+				t.Fatalf("Invalid decoding of %d: %d, error %v", v, decoded, err)
+			}
+		}
+	}
+}
+
+func TestReadIntErrors(t *testing.T) {
+	for _, d := range []struct {
+		data []byte
+		err  error
+	}{
+		{nil, io.EOF},
+		{[]byte{0x80}, io.EOF},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x02}, ErrOverflow},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x81, 0x00}, ErrOverflow},
+	} {
+		if _, err := ReadInt(bytes.NewReader(d.data)); err != d.err {
+			t.Fatalf("Invalid error for %x: %v, expected %v", d.data, err, d.err)
+		}
+	}
+
+	// Non-minimal encodings are accepted
+	if v, err := ReadInt(bytes.NewReader([]byte{0x81, 0x80, 0x00})); err != nil || v != 1 {
+		t.Fatalf("Invalid decoding of padded integer: %d, error %v", v, err)
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "a", "Zażółć gęślą jaźń", string(make([]byte, 200)), string(bytes.Repeat([]byte("x"), 20000))} {
+		data := AppendString(nil, s)
+		if !bytes.Equal(data, AppendBuffer(nil, []byte(s))) {
+			t.Fatalf("Strings and buffers must be encoded the same way")
+		}
+		r := bytes.NewReader(data)
+		decoded, err := ReadString(r, int64(len(s)))
+		if err != nil || decoded != s || r.Len() != 0 {
+			t.Fatalf("Invalid decoding of string of %d bytes: %v", len(s), err)
+		}
+		if len(s) > 0 {
+			if _, err = ReadString(bytes.NewReader(data), int64(len(s)-1)); err != ErrTooLarge {
+				t.Fatalf("Too long string must be rejected, got: %v", err)
+			}
+			if _, err = ReadBuffer(bytes.NewReader(data[:len(data)-1]), int64(len(s))); err != io.EOF && err != io.ErrUnexpectedEOF {
+				t.Fatalf("Truncated string must be rejected, got: %v", err)
+			}
+		}
+	}
+
+	if _, err := ReadString(bytes.NewReader(AppendBuffer(nil, []byte{0xFF})), 10); err != ErrNotUTF8 {
+		t.Fatalf("Invalid UTF-8 sequence must be rejected, got: %v", err)
+	}
+	if _, err := ReadBuffer(bytes.NewReader(AppendInt(nil, -1)), 10); err != ErrTooLarge {
+		t.Fatalf("Negative length must be rejected, got: %v", err)
+	}
+}
+
+func FuzzReadInt(f *testing.F) {
+	f.Add([]byte{0x00})
+	f.Add([]byte{0x80, 0x01})
+	f.Add(AppendInt(nil, -1))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, err := ReadInt(bytes.NewReader(data))
+		expected, n := binary.Uvarint(data)
+		if n <= 0 {
+			if err == nil {
+				t.Fatalf("Invalid integer %x decoded as %d", data, v)
+			}
+			return
+		}
+		if err != nil || v != int64(expected) {
+			t.Fatalf("Invalid decoding of %x: %d, error %v, expected %d", data, v, err, expected)
+		}
+		if decoded, err := ReadInt(bytes.NewReader(AppendInt(nil, v))); err != nil || decoded != v {
+			t.Fatalf("Invalid round trip of %d: %d, error %v", v, decoded, err)
+		}
+	})
+}
+
+func FuzzStringRoundTrip(f *testing.F) {
+	f.Add("", int64(0))
+	f.Add("Hello World!", int64(-5))
+	f.Fuzz(func(t *testing.T, s string, v int64) {
+		data := AppendVarint(AppendString(AppendInt(nil, v), s), v)
+		r := bytes.NewReader(data)
+		if decoded, err := ReadInt(r); err != nil || decoded != v {
+			t.Fatalf("Invalid decoding of %d: %d, error %v", v, decoded, err)
+		}
+		if decoded, err := ReadBuffer(r, int64(len(s))); err != nil || string(decoded) != s {
+			t.Fatalf("Invalid decoding of %q: %q, error %v", s, decoded, err)
+		}
+		if decoded, err := ReadVarint(r); err != nil || decoded != v || r.Len() != 0 {
+			t.Fatalf("Invalid decoding of %d: %d, error %v", v, decoded, err)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"errors"
+	"github.com/cinode/golib/cipherfactory"
+)
+
+var (
+	ErrInvalidWriterConfig = errors.New("Invalid configuration of the blob writer")
+)
+
+// Layout of blobs created by file and directory writers and resources used
+// to create them. Blob ids depend on the layout, the zero value keeps the
+// default one and thus blob ids of content stored before.
+type WriterConfig struct {
+
+	// Size of partial blobs of files split at fixed offsets, up to 16MiB
+	// which is used if 0. Files split at other sizes list sizes of their
+	// partial blobs. Not used with content-defined chunking.
+	ChunkSize int
+
+	// Maximum number of entries of a single directory blob, up to 1024
+	// which is used if 0. Directories split at other counts list numbers
+	// of entries of their partial blobs.
+	MaxDirEntries int
+
+	// Maximum size of a single stored blob, the writer fails with
+	// BlobTooLargeError instead of storing larger ones. Unlimited if 0.
+	MaxBlobSize int64
+
+	// Cipher algorithm encrypting created blobs, one of
+	// cipherfactory.Algorithms(), DefaultAlgorithm if empty. Blobs are
+	// always decrypted with the algorithm recorded in their keys.
+	// Authenticated algorithms detect tampered content as soon as the
+	// damaged chunk is read instead of once the whole blob is hashed.
+	CipherAlgorithm string
+
+	// Hash algorithm of blob ids of created hash-validated blobs, one of
+	// cipherfactory.HashAlgorithms(), DefaultHashAlgorithm if empty. Blob
+	// ids of algorithms other than SHA-512 start with the multihash tag of
+	// the algorithm. Keys of blobs do not depend on the algorithm.
+	HashAlgorithm string
+
+	// Resource limits of the writer, DefaultLimits if nil
+	Limits *Limits
+}
+
+func (c *WriterConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.ChunkSize < 0 || c.ChunkSize > maxSimpleFileDataSize ||
+		c.MaxDirEntries < 0 || c.MaxDirEntries > maxSimpleDirEntries || c.MaxBlobSize < 0 {
+		return ErrInvalidWriterConfig
+	}
+	if _, err := cipherfactory.CreateAlgorithm(c.cipherAlgorithm()); err != nil {
+		return err
+	}
+	if _, err := cipherfactory.HashTag(c.hashAlgorithm()); err != nil {
+		return err
+	}
+	if c.Limits != nil {
+		return c.Limits.validate()
+	}
+	return nil
+}
+
+func (c *WriterConfig) chunkSize() int {
+	if c == nil || c.ChunkSize == 0 {
+		return maxSimpleFileDataSize
+	}
+	return c.ChunkSize
+}
+
+func (c *WriterConfig) maxDirEntries() int {
+	if c == nil || c.MaxDirEntries == 0 {
+		return maxSimpleDirEntries
+	}
+	return c.MaxDirEntries
+}
+
+func (c *WriterConfig) cipherAlgorithm() string {
+	if c == nil || c.CipherAlgorithm == "" {
+		return cipherfactory.DefaultAlgorithm
+	}
+	return c.CipherAlgorithm
+}
+
+func (c *WriterConfig) hashAlgorithm() string {
+	if c == nil || c.HashAlgorithm == "" {
+		return cipherfactory.DefaultHashAlgorithm
+	}
+	return c.HashAlgorithm
+}
+
+func (c *WriterConfig) limits() Limits {
+	if c == nil || c.Limits == nil {
+		return DefaultLimits
+	}
+	return *c.Limits
+}
+
+// Wrap the storage rejecting blobs larger than MaxBlobSize
+func (c *WriterConfig) limitStorage(storage BlobStorage) BlobStorage {
+	if c == nil || c.MaxBlobSize == 0 {
+		return storage
+	}
+	return &sizeLimitedStorage{BlobStorage: storage, limit: c.MaxBlobSize}
+}
+
+type sizeLimitedStorage struct {
+	BlobStorage
+	limit int64
+}
+
+// Get the wrapped storage
+func (s *sizeLimitedStorage) Unwrap() BlobStorage {
+	return s.BlobStorage
+}
+
+func (s *sizeLimitedStorage) NewBlobWriter(blobId string) (WriteFinalizeCanceler, error) {
+	writer, err := s.BlobStorage.NewBlobWriter(blobId)
+	if err != nil {
+		return nil, err
+	}
+	return &sizeLimitedWriter{WriteFinalizeCanceler: writer, bid: blobId, left: s.limit, limit: s.limit}, nil
+}
+
+type sizeLimitedWriter struct {
+	WriteFinalizeCanceler
+	bid         string
+	left, limit int64
+}
+
+func (w *sizeLimitedWriter) Write(p []byte) (n int, err error) {
+	if int64(len(p)) > w.left {
+		return 0, &BlobTooLargeError{Bid: w.bid, Limit: w.limit}
+	}
+	w.left -= int64(len(p))
+	return w.WriteFinalizeCanceler.Write(p)
+}
@@ -0,0 +1,169 @@
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriterConfigDefaults(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	data := bytes.Repeat([]byte("data"), 1000)
+
+	var refs []BlobReference
+	for _, config := range []*WriterConfig{nil, {}, {ChunkSize: maxSimpleFileDataSize, MaxDirEntries: maxSimpleDirEntries}} {
+		fw := FileBlobWriter{Storage: storage, Config: config}
+		fw.Write(data)
+		file, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dw := DirBlobWriter{Storage: storage, Config: config}
+		for _, entry := range genEntries(maxSimpleDirEntries + 1) {
+			dw.AddEntry(entry)
+		}
+		dir, err := dw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, file.BlobReference, dir.BlobReference)
+	}
+	for i := 2; i < len(refs); i++ {
+		if refs[i] != refs[i%2] {
+			t.Fatalf("Default layout changed blob ids")
+		}
+	}
+}
+
+func TestWriterConfigChunkSize(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	data := bytes.Repeat([]byte("0123456789"), 250)
+
+	fw := FileBlobWriter{Storage: storage, Config: &WriterConfig{ChunkSize: 1000}}
+	fw.Write(data)
+	result, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 4 {
+		t.Fatalf("Invalid number of blobs: %v", result.Blobs)
+	}
+
+	info, err := InspectBlobWithKey(result.Bid, result.Key, storage)
+	if err != nil || info.BlobType != blobTypeChunkedStaticFile || info.PartsCount != 3 {
+		t.Fatalf("Invalid file blob: %+v %v", info, err)
+	}
+	if _, err = StrictDecodeBlob(result.Bid, result.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+	reader, _ := OpenFileBlob(result.Bid, result.Key, storage)
+	if read, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("Invalid file content: %v", err)
+	}
+}
+
+func TestWriterConfigMaxDirEntries(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	dw := DirBlobWriter{Storage: storage, Config: &WriterConfig{MaxDirEntries: 2}}
+	for _, entry := range genEntries(5) {
+		dw.AddEntry(entry)
+	}
+	result, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := InspectBlobWithKey(result.Bid, result.Key, storage)
+	if err != nil || info.BlobType != blobTypeChunkedStaticDir || info.EntriesCount != 5 || info.PartsCount != 3 || !info.IsDir() {
+		t.Fatalf("Invalid directory blob: %+v %v", info, err)
+	}
+	if _, err = StrictDecodeBlob(result.Bid, result.Key, storage); err != nil {
+		t.Fatal(err)
+	}
+	if refs, err := GetBlobReferences(result.Bid, result.Key, storage); err != nil || len(refs) != 3 {
+		t.Fatalf("Invalid references: %v %v", refs, err)
+	}
+	reader, _ := OpenDirBlob(result.Bid, result.Key, storage)
+	entries, err := reader.Entries()
+	if err != nil || len(entries) != 5 || entries[4].Name != genEntries(5)[4].Name {
+		t.Fatalf("Invalid entries: %v %v", entries, err)
+	}
+
+	// Numbers of entries must match partial blobs
+	short := DirBlobWriter{Storage: storage}
+	short.AddEntry(genEntries(1)[0])
+	part, _ := short.Finalize()
+	for _, d := range []struct {
+		total  int64
+		counts []int64
+		err    error
+	}{
+		{3, []int64{1, 1}, ErrMalformedDirInvalidEntriesCount},
+		{2, []int64{0, 2}, ErrMalformedDirInvalidEntriesCount},
+		{1, []int64{1}, ErrMalformedSplitDirPartsCount},
+	} {
+		var b bytes.Buffer
+		serializeInt(d.total, &b)
+		serializeInt(int64(len(d.counts)), &b)
+		for _, count := range d.counts {
+			serializeInt(count, &b)
+			serializeString(part.Bid, &b)
+			serializeString(part.Key, &b)
+		}
+		bid, key, _ := CreateTypedBlob(blobTypeChunkedStaticDir, b.Bytes(), storage)
+		if _, err := OpenDirBlob(bid, key, storage); err != d.err {
+			t.Fatalf("Invalid error of malformed directory: %v, expected %v", err, d.err)
+		}
+	}
+
+	// Partial blobs must hold listed numbers of entries
+	var b bytes.Buffer
+	serializeInt(3, &b)
+	serializeInt(2, &b)
+	for _, count := range []int64{2, 1} {
+		serializeInt(count, &b)
+		serializeString(part.Bid, &b)
+		serializeString(part.Key, &b)
+	}
+	bid, key, _ := CreateTypedBlob(blobTypeChunkedStaticDir, b.Bytes(), storage)
+	reader, err = OpenDirBlob(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.Entries(); err != ErrMalformedDirInvalidEntriesCount {
+		t.Fatalf("Invalid error of the partial blob: %v", err)
+	}
+}
+
+func TestWriterConfigMaxBlobSize(t *testing.T) {
+
+	storage := NewMemoryBlobStorage()
+	fw := FileBlobWriter{Storage: storage, Config: &WriterConfig{MaxBlobSize: 1000}}
+	fw.Write(make([]byte, 2000))
+	_, err := fw.Finalize()
+	var tooLarge *BlobTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != 1000 {
+		t.Fatalf("Invalid error of the too large blob: %v", err)
+	}
+
+	fw = FileBlobWriter{Storage: storage, Config: &WriterConfig{MaxBlobSize: 1000, ChunkSize: 500}}
+	fw.Write(make([]byte, 2000))
+	if _, err = fw.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, config := range []*WriterConfig{{ChunkSize: -1}, {ChunkSize: maxSimpleFileDataSize + 1}, {MaxDirEntries: maxSimpleDirEntries + 1}} {
+		fw = FileBlobWriter{Storage: storage, Config: config}
+		if _, err = fw.Write([]byte("data")); err != ErrInvalidWriterConfig {
+			t.Fatalf("Invalid config accepted: %+v", config)
+		}
+		dw := DirBlobWriter{Storage: storage, Config: config}
+		if _, err = dw.Finalize(); err != ErrInvalidWriterConfig {
+			t.Fatalf("Invalid config accepted: %+v", config)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cinodefs
+
+import (
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidOffset = errors.New("Invalid file offset")
+)
+
+// Permission bits of entries not recording them
+const (
+	defaultFileMode fs.FileMode = 0444
+	defaultDirMode  fs.FileMode = 0555
+)
+
+// Information about the entry of the tree
+type fileInfo struct {
+	node *node
+	size int64
+}
+
+func (i *fileInfo) Name() string {
+	return i.node.entry.Name
+}
+
+func (i *fileInfo) Size() int64 {
+	return i.size
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	entry := &i.node.entry
+	switch {
+	case entry.Type == blobstore.EntryTypeSymlink:
+		return fs.ModeSymlink | 0777
+	case i.node.isDir && entry.Mode != 0:
+		return fs.ModeDir | entry.Mode
+	case i.node.isDir:
+		return fs.ModeDir | defaultDirMode
+	case entry.Mode != 0:
+		return entry.Mode
+	}
+	return defaultFileMode
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	return i.node.entry.ModTime
+}
+
+func (i *fileInfo) IsDir() bool {
+	return i.node.isDir
+}
+
+// The directory entry the information comes from
+func (i *fileInfo) Sys() interface{} {
+	return i.node.entry
+}
+
+func (i *fileInfo) Type() fs.FileMode {
+	return i.Mode().Type()
+}
+
+func (i *fileInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
+
+// Get the information about the node, sizes of files come from their
+// chunk maps, simple files are decrypted to find it
+func (f *FS) info(n *node) (*fileInfo, error) {
+	info := &fileInfo{node: n}
+	switch {
+	case n.isDir:
+		dir, err := f.readDir(n)
+		if err != nil {
+			return nil, err
+		}
+		info.size = int64(len(dir.entries))
+	case n.entry.Type == blobstore.EntryTypeSymlink:
+		info.size = int64(len(n.entry.Target))
+	default:
+		chunks, err := f.fileChunks(n)
+		if err != nil {
+			return nil, err
+		}
+		info.size = chunks.size
+	}
+	return info, nil
+}
+
+// Get entries of the directory sorted by names, sizes of files
+// are only known once their info is requested
+func (f *FS) dirEntries(dir *cachedDir) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(dir.entries))
+	for i, n := range dir.entries {
+		entries[i] = &dirEntry{fs: f, node: n}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+// Entry of the directory listing
+type dirEntry struct {
+	fs   *FS
+	node *node
+}
+
+func (d *dirEntry) Name() string {
+	return d.node.entry.Name
+}
+
+func (d *dirEntry) IsDir() bool {
+	return d.node.isDir
+}
+
+func (d *dirEntry) Type() fs.FileMode {
+	return (&fileInfo{node: d.node}).Mode().Type()
+}
+
+func (d *dirEntry) Info() (fs.FileInfo, error) {
+	return d.fs.info(d.node)
+}
+
+// Opened directory
+type dirHandle struct {
+	fs      *FS
+	node    *node
+	entries *cachedDir
+	listed  []fs.DirEntry // Entries not returned by ReadDir yet
+	started bool
+}
+
+func (d *dirHandle) Stat() (fs.FileInfo, error) {
+	return d.fs.info(d.node)
+}
+
+func (d *dirHandle) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.entry.Name, Err: errors.New("is a directory")}
+}
+
+func (d *dirHandle) Close() error {
+	return nil
+}
+
+func (d *dirHandle) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !d.started {
+		d.listed, d.started = d.fs.dirEntries(d.entries), true
+	}
+	if count <= 0 {
+		entries := d.listed
+		d.listed = nil
+		return entries, nil
+	}
+	if len(d.listed) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.listed) {
+		count = len(d.listed)
+	}
+	entries := d.listed[:count]
+	d.listed = d.listed[count:]
+	return entries, nil
+}
+
+// Opened file. Only chunks containing data being read are fetched and
+// decrypted, sequential reads continue decrypting the current chunk.
+// Reads are serialized, the file is safe to use from multiple goroutines.
+type File struct {
+	fs   *FS
+	node *node
+
+	lock     sync.Mutex
+	offset   int64                // Position of Read and Seek
+	chunk    *blobstore.FileChunk // Chunk being read, nil if none
+	reader   blobstore.FileBlobReader
+	position int64 // Position of the chunk reader in the file
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	return f.fs.info(f.node)
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		chunks, err := f.fs.fileChunks(f.node)
+		if err != nil {
+			return f.offset, err
+		}
+		offset += chunks.size
+	default:
+		return f.offset, ErrInvalidOffset
+	}
+	if offset < 0 {
+		return f.offset, ErrInvalidOffset
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.dropReader()
+	return nil
+}
+
+// Close the chunk reader, the lock must be held
+func (f *File) dropReader() {
+	if f.reader != nil {
+		f.reader.Close()
+	}
+	f.chunk, f.reader = nil, nil
+}
+
+func (f *File) readAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, ErrInvalidOffset
+	}
+	chunks, err := f.fs.fileChunks(f.node)
+	if err != nil {
+		return 0, err
+	}
+
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= chunks.size {
+			return n, io.EOF
+		}
+		if err = f.moveTo(chunks.chunks, pos); err != nil {
+			return n, err
+		}
+
+		// Don't read past the chunk, the next one is opened separately
+		limit := len(p)
+		if left := f.chunk.Offset + f.chunk.Length - pos; int64(limit-n) > left {
+			limit = n + int(left)
+		}
+		read, err := f.reader.Read(p[n:limit])
+		n += read
+		f.position += int64(read)
+		if err == io.EOF && read > 0 {
+			err = nil
+		}
+		if err != nil {
+			f.dropReader()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Position the chunk reader at given offset, the chunk reader is reused
+// when reading forward within the same chunk
+func (f *File) moveTo(chunks []blobstore.FileChunk, pos int64) error {
+	if f.chunk != nil && pos >= f.chunk.Offset && pos < f.chunk.Offset+f.chunk.Length && pos >= f.position {
+		if pos == f.position {
+			return nil
+		}
+		_, err := f.reader.Seek(pos-f.chunk.Offset, io.SeekStart)
+		f.position = pos
+		return err
+	}
+
+	f.dropReader()
+	i := sort.Search(len(chunks), func(i int) bool { return chunks[i].Offset+chunks[i].Length > pos })
+	chunk := chunks[i]
+	reader, err := blobstore.OpenFileBlob(chunk.Bid, chunk.Key, f.fs.storage)
+	if err != nil {
+		return err
+	}
+	if _, err = reader.Seek(pos-chunk.Offset, io.SeekStart); err != nil {
+		reader.Close()
+		return err
+	}
+	f.chunk, f.reader, f.position = &chunk, reader, pos
+	return nil
+}
@@ -0,0 +1,311 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cinodefs presents the directory blob tree as a read-only
+// filesystem. It implements io/fs interfaces and is the backend of mounts
+// exposing the tree to the operating system: lookups and attributes are
+// answered from cached listings, file content is fetched and decrypted
+// chunk by chunk only when it's read. Mount serves the tree through FUSE,
+// it's only built with the fuse build tag.
+package cinodefs
+
+import (
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooManySymlinks = errors.New("Too many levels of symbolic links")
+)
+
+// Maximum number of symbolic links followed while resolving a single path
+const maxSymlinkHops = 40
+
+// Default number of cached directory listings and file chunk maps
+const defaultCacheSize = 1024
+
+// Options of the filesystem
+type Options struct {
+
+	// Time after which cached attributes are read again, they're kept
+	// until evicted if not positive. Hash-validated blobs never change,
+	// the limit matters for trees with signed blobs only.
+	AttrTTL time.Duration
+
+	// Maximum number of cached directory listings and file chunk maps,
+	// the default is used if not positive
+	CacheSize int
+
+	// Source of the time for attribute expiry, the system clock if nil
+	Clock utils.Clock
+}
+
+// Read-only filesystem over the directory blob tree. It's safe to use
+// from multiple goroutines.
+type FS struct {
+	root    blobstore.BlobReference
+	storage blobstore.BlobStorage
+	options Options
+
+	lock   sync.Mutex
+	dirs   map[blobstore.BlobReference]*cachedDir
+	chunks map[blobstore.BlobReference]*cachedChunks
+}
+
+// Listing of the directory with attributes of its entries
+type cachedDir struct {
+	expires time.Time
+	entries []*node
+	byName  map[string]*node
+}
+
+// Chunk map of the file
+type cachedChunks struct {
+	expires time.Time
+	chunks  []blobstore.FileChunk
+	size    int64
+}
+
+// Entry of the tree with its inspected blob
+type node struct {
+	entry blobstore.DirEntry
+	isDir bool
+}
+
+// Create the filesystem with the root directory blob
+func New(root blobstore.BlobReference, storage blobstore.BlobStorage, options Options) *FS {
+	if options.CacheSize <= 0 {
+		options.CacheSize = defaultCacheSize
+	}
+	if options.Clock == nil {
+		options.Clock = utils.SystemClock
+	}
+	return &FS{
+		root:    root,
+		storage: storage,
+		options: options,
+		dirs:    make(map[blobstore.BlobReference]*cachedDir),
+		chunks:  make(map[blobstore.BlobReference]*cachedChunks),
+	}
+}
+
+// Drop all cached listings and chunk maps
+func (f *FS) Invalidate() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.dirs = make(map[blobstore.BlobReference]*cachedDir)
+	f.chunks = make(map[blobstore.BlobReference]*cachedChunks)
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	n, err := f.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir {
+		entries, err := f.readDir(n)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &dirHandle{fs: f, node: n, entries: entries}, nil
+	}
+	return &File{fs: f, node: n}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := f.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return f.info(n)
+}
+
+// Get the information about the entry without following the final
+// symbolic link
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := f.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return f.info(n)
+}
+
+// Get the target of the symbolic link
+func (f *FS) ReadLink(name string) (string, error) {
+	n, err := f.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.entry.Type != blobstore.EntryTypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.entry.Target, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := f.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := f.readDir(n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return f.dirEntries(entries), nil
+}
+
+// Find the node of the path, symbolic links are followed within the tree,
+// the final one only if requested
+func (f *FS) resolve(op, name string, followLast bool) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	root := &node{entry: blobstore.DirEntry{Name: ".", Bid: f.root.Bid, Key: f.root.Key}, isDir: true}
+	hops := 0
+	parts := strings.Split(name, "/")
+	if name == "." {
+		parts = nil
+	}
+
+	current, dir := root, ""
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		if !current.isDir {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		entries, err := f.readDir(current)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		next, found := entries.byName[part]
+		if !found {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+
+		if next.entry.Type != blobstore.EntryTypeSymlink || (len(parts) == 0 && !followLast) {
+			current, dir = next, path.Join(dir, part)
+			continue
+		}
+
+		// Restart from the root with the target in place of the link,
+		// targets leaving the tree don't exist in it
+		if hops++; hops > maxSymlinkHops {
+			return nil, &fs.PathError{Op: op, Path: name, Err: ErrTooManySymlinks}
+		}
+		target := next.entry.Target
+		if !path.IsAbs(target) {
+			target = path.Join(dir, target)
+		}
+		if path.IsAbs(target) || target == ".." || strings.HasPrefix(target, "../") {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if target != "." {
+			parts = append(strings.Split(target, "/"), parts...)
+		}
+		current, dir = root, ""
+	}
+	return current, nil
+}
+
+// Get the listing of the directory node
+func (f *FS) readDir(n *node) (*cachedDir, error) {
+	ref := blobstore.BlobReference{Bid: n.entry.Bid, Key: n.entry.Key}
+
+	f.lock.Lock()
+	cached, found := f.dirs[ref]
+	f.lock.Unlock()
+	if found && !f.expired(cached.expires) {
+		return cached, nil
+	}
+
+	reader, err := blobstore.OpenDirBlob(ref.Bid, ref.Key, f.storage)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	entries, err := reader.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	cached = &cachedDir{expires: f.expiry(), byName: make(map[string]*node)}
+	for _, entry := range entries {
+		n := &node{entry: entry}
+		switch entry.Type {
+		case blobstore.EntryTypeBlob:
+			info, err := blobstore.InspectBlobWithKey(entry.Bid, entry.Key, f.storage)
+			if err != nil {
+				return nil, err
+			}
+			n.isDir = info.IsDir()
+		case blobstore.EntryTypeSymlink:
+		default:
+			// Entry kinds unknown to this version are not shown
+			continue
+		}
+		cached.entries = append(cached.entries, n)
+		cached.byName[entry.Name] = n
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.dirs) >= f.options.CacheSize {
+		f.dirs = make(map[blobstore.BlobReference]*cachedDir)
+	}
+	f.dirs[ref] = cached
+	return cached, nil
+}
+
+// Get the chunk map of the file node
+func (f *FS) fileChunks(n *node) (*cachedChunks, error) {
+	ref := blobstore.BlobReference{Bid: n.entry.Bid, Key: n.entry.Key}
+
+	f.lock.Lock()
+	cached, found := f.chunks[ref]
+	f.lock.Unlock()
+	if found && !f.expired(cached.expires) {
+		return cached, nil
+	}
+
+	chunks, err := blobstore.FileChunks(ref.Bid, ref.Key, f.storage)
+	if err != nil {
+		return nil, err
+	}
+	cached = &cachedChunks{expires: f.expiry(), chunks: chunks}
+	if len(chunks) > 0 {
+		last := chunks[len(chunks)-1]
+		cached.size = last.Offset + last.Length
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.chunks) >= f.options.CacheSize {
+		f.chunks = make(map[blobstore.BlobReference]*cachedChunks)
+	}
+	f.chunks[ref] = cached
+	return cached, nil
+}
+
+func (f *FS) expiry() time.Time {
+	if f.options.AttrTTL <= 0 {
+		return time.Time{}
+	}
+	return f.options.Clock.Now().Add(f.options.AttrTTL)
+}
+
+func (f *FS) expired(expires time.Time) bool {
+	return !expires.IsZero() && !f.options.Clock.Now().Before(expires)
+}
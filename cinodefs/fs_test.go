@@ -0,0 +1,172 @@
+package cinodefs
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Count blob reads of the storage
+type countingStorage struct {
+	blobstore.BlobStorage
+	reads int
+}
+
+func (c *countingStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	c.reads++
+	return c.BlobStorage.NewBlobReader(blobId)
+}
+
+func storeFile(t *testing.T, storage blobstore.BlobStorage, data []byte) blobstore.DirEntry {
+	limits := blobstore.Limits{CDCMinSize: 1024, CDCMaxSize: 4096, CDCMaskBits: 11}
+	w := blobstore.FileBlobWriter{Storage: storage, ContentDefined: true, ChunkLimits: &limits}
+	w.Write(data)
+	ref, err := w.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobstore.DirEntry{Bid: ref.Bid, Key: ref.Key, MimeType: "application/octet-stream"}
+}
+
+func storeDir(t *testing.T, storage blobstore.BlobStorage, entries map[string]blobstore.DirEntry) blobstore.DirEntry {
+	w := blobstore.DirBlobWriter{Storage: storage}
+	for name, entry := range entries {
+		entry.Name = name
+		w.AddEntry(entry)
+	}
+	ref, err := w.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobstore.DirEntry{Bid: ref.Bid, Key: ref.Key}
+}
+
+func genTestTree(t *testing.T) (*countingStorage, blobstore.BlobReference, []byte) {
+	storage := &countingStorage{BlobStorage: blobstore.NewMemoryBlobStorage()}
+
+	big := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	hello := storeFile(t, storage, []byte("Hello World!"))
+	hello.Mode, hello.ModTime = 0640, time.Unix(1400000000, 0)
+	sub := storeDir(t, storage, map[string]blobstore.DirEntry{
+		"big.bin": storeFile(t, storage, big),
+		"empty":   storeFile(t, storage, nil),
+		"up":      {Type: blobstore.EntryTypeSymlink, Target: "../hello.txt"},
+	})
+	root := storeDir(t, storage, map[string]blobstore.DirEntry{
+		"hello.txt": hello,
+		"sub":       sub,
+		"link":      {Type: blobstore.EntryTypeSymlink, Target: "sub"},
+		"future":    {Type: 99, Bid: "bid", Key: "key"},
+	})
+	return storage, blobstore.BlobReference{Bid: root.Bid, Key: root.Key}, big
+}
+
+func TestFS(t *testing.T) {
+
+	storage, root, big := genTestTree(t)
+	fsys := New(root, storage, Options{})
+
+	if err := fstest.TestFS(fsys, "hello.txt", "sub/big.bin", "sub/empty", "sub/up"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "link/up")
+	if err != nil || string(data) != "Hello World!" {
+		t.Fatalf("Invalid content read through symlinks: %q, %v", data, err)
+	}
+	outside := storeDir(t, storage, map[string]blobstore.DirEntry{
+		"out": {Type: blobstore.EntryTypeSymlink, Target: "../secret"},
+	})
+	outsideFS := New(blobstore.BlobReference{Bid: outside.Bid, Key: outside.Key}, storage, Options{})
+	if _, err = outsideFS.Open("out"); !errorIs(err, fs.ErrNotExist) {
+		t.Fatalf("Invalid error for symlink leaving the tree: %v", err)
+	}
+	if _, err = fsys.Open("future"); !errorIs(err, fs.ErrNotExist) {
+		t.Fatalf("Invalid error for entry of unknown type: %v", err)
+	}
+	if target, err := fsys.ReadLink("link"); err != nil || target != "sub" {
+		t.Fatalf("Invalid symlink target: %v, %v", target, err)
+	}
+
+	info, err := fsys.Stat("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 12 || info.Mode() != 0640 || !info.ModTime().Equal(time.Unix(1400000000, 0)) {
+		t.Fatalf("Invalid attributes: %v %v %v", info.Size(), info.Mode(), info.ModTime())
+	}
+	if info, err = fsys.Stat("sub/big.bin"); err != nil || info.Size() != int64(len(big)) || info.Mode() != defaultFileMode {
+		t.Fatalf("Invalid attributes of the big file: %v, %v", info, err)
+	}
+
+	// Reading from the middle fetches only chunks containing the data
+	f, _ := fsys.Open("sub/big.bin")
+	file := f.(*File)
+	storage.reads = 0
+	buff := make([]byte, 100)
+	if _, err = file.ReadAt(buff, 40000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buff, big[40000:40100]) {
+		t.Fatal("Invalid data read from the middle of the file")
+	}
+	if storage.reads > 2 {
+		t.Fatalf("Too many blobs read: %v", storage.reads)
+	}
+	file.Seek(-10, io.SeekEnd)
+	if rest, err := ioutil.ReadAll(file); err != nil || !bytes.Equal(rest, big[len(big)-10:]) {
+		t.Fatalf("Invalid end of the file: %v", err)
+	}
+
+	// Listings and attributes are cached
+	storage.reads = 0
+	if _, err = fsys.Stat("sub/big.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if storage.reads != 0 {
+		t.Fatalf("Cached attributes read again: %v reads", storage.reads)
+	}
+}
+
+func TestFSAttrTTL(t *testing.T) {
+
+	storage, root, _ := genTestTree(t)
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	fsys := New(root, storage, Options{AttrTTL: time.Minute, Clock: clock})
+
+	if _, err := fsys.Stat("sub/empty"); err != nil {
+		t.Fatal(err)
+	}
+	storage.reads = 0
+	fsys.Stat("sub/empty")
+	if storage.reads != 0 {
+		t.Fatal("Attributes not cached")
+	}
+
+	clock.Advance(time.Minute)
+	fsys.Stat("sub/empty")
+	if storage.reads == 0 {
+		t.Fatal("Expired attributes not read again")
+	}
+
+	storage.reads = 0
+	fsys.Invalidate()
+	fsys.Stat("sub/empty")
+	if storage.reads == 0 {
+		t.Fatal("Invalidated attributes not read again")
+	}
+}
+
+func errorIs(err, target error) bool {
+	pathErr, ok := err.(*fs.PathError)
+	return ok && pathErr.Err == target
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build fuse && (darwin || freebsd || linux)
+
+package cinodefs
+
+import (
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+)
+
+// Mount the filesystem read-only at the directory through FUSE, the call
+// returns once the filesystem is unmounted. Mounting needs the fusermount
+// helper on Linux and the FUSE kernel extension on other systems.
+func Mount(f *FS, mountpoint string) error {
+	conn, err := fuse.Mount(mountpoint,
+		fuse.ReadOnly(),
+		fuse.FSName("cinode"),
+		fuse.Subtype("cinodefs"),
+		fuse.DefaultPermissions(),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = fusefs.Serve(conn, &mountedFS{fs: f}); err != nil {
+		return err
+	}
+	<-conn.Ready
+	return conn.MountError
+}
+
+// Unmount the filesystem mounted at the directory, Mount returns afterwards
+func Unmount(mountpoint string) error {
+	return fuse.Unmount(mountpoint)
+}
+
+// Filesystem served to the kernel, nodes are identified by their paths
+type mountedFS struct {
+	fs *FS
+}
+
+func (m *mountedFS) Root() (fusefs.Node, error) {
+	return &mountedNode{fs: m.fs, path: "."}, nil
+}
+
+// Entry of the tree, also the handle of opened directories
+type mountedNode struct {
+	fs   *FS
+	path string
+}
+
+func (n *mountedNode) Attr(ctx context.Context, a *fuse.Attr) error {
+	info, err := n.fs.Lstat(n.path)
+	if err != nil {
+		return mountError(err)
+	}
+	a.Mode = info.Mode()
+	a.Size = uint64(info.Size())
+	a.Mtime = info.ModTime()
+	return nil
+}
+
+// Symbolic links are not followed, the kernel resolves them
+func (n *mountedNode) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	child := &mountedNode{fs: n.fs, path: path.Join(n.path, name)}
+	if _, err := n.fs.Lstat(child.path); err != nil {
+		return nil, mountError(err)
+	}
+	return child, nil
+}
+
+func (n *mountedNode) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	target, err := n.fs.ReadLink(n.path)
+	return target, mountError(err)
+}
+
+func (n *mountedNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
+	if req.Dir {
+		return n, nil
+	}
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EROFS)
+	}
+	f, err := n.fs.Open(n.path)
+	if err != nil {
+		return nil, mountError(err)
+	}
+	file, ok := f.(*File)
+	if !ok {
+		f.Close()
+		return nil, fuse.Errno(syscall.EISDIR)
+	}
+	return &mountedFile{file: file}, nil
+}
+
+func (n *mountedNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	entries, err := n.fs.ReadDir(n.path)
+	if err != nil {
+		return nil, mountError(err)
+	}
+	dirents := make([]fuse.Dirent, len(entries))
+	for i, entry := range entries {
+		dirents[i] = fuse.Dirent{Name: entry.Name(), Type: fuse.DT_File}
+		switch {
+		case entry.IsDir():
+			dirents[i].Type = fuse.DT_Dir
+		case entry.Type()&fs.ModeSymlink != 0:
+			dirents[i].Type = fuse.DT_Link
+		}
+	}
+	return dirents, nil
+}
+
+// Opened file, reads at any offset fetch only chunks containing the data
+type mountedFile struct {
+	file *File
+}
+
+func (m *mountedFile) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	data := make([]byte, req.Size)
+	n, err := m.file.ReadAt(data, req.Offset)
+	if err != nil && err != io.EOF {
+		return mountError(err)
+	}
+	resp.Data = data[:n]
+	return nil
+}
+
+func (m *mountedFile) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return m.file.Close()
+}
+
+// Get the error reported to the kernel
+func mountError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return fuse.ENOENT
+	case errors.Is(err, ErrTooManySymlinks):
+		return fuse.Errno(syscall.ELOOP)
+	case errors.Is(err, fs.ErrInvalid):
+		return fuse.Errno(syscall.EINVAL)
+	case errors.Is(err, os.ErrPermission):
+		return fuse.EPERM
+	}
+	return fuse.EIO
+}
@@ -0,0 +1,83 @@
+//go:build fuse && (darwin || freebsd || linux)
+
+package cinodefs
+
+import (
+	"bazil.org/fuse"
+	"context"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMountedNodes(t *testing.T) {
+
+	storage, root, big := genTestTree(t)
+	mounted := &mountedFS{fs: New(root, storage, Options{})}
+	ctx := context.Background()
+
+	rootNode, err := mounted.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := rootNode.(*mountedNode)
+	dirents, err := dir.ReadDirAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]fuse.DirentType)
+	for _, d := range dirents {
+		types[d.Name] = d.Type
+	}
+	if len(types) != 3 || types["hello.txt"] != fuse.DT_File || types["sub"] != fuse.DT_Dir || types["link"] != fuse.DT_Link {
+		t.Fatalf("Invalid directory listing: %v", dirents)
+	}
+
+	// Symbolic links are left to the kernel
+	node, err := dir.Lookup(ctx, "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attr fuse.Attr
+	if err = node.Attr(ctx, &attr); err != nil || attr.Mode&os.ModeSymlink == 0 {
+		t.Fatalf("Invalid attributes of the symlink: %v %v", attr.Mode, err)
+	}
+	if target, err := node.(*mountedNode).Readlink(ctx, &fuse.ReadlinkRequest{}); err != nil || target != "sub" {
+		t.Fatalf("Invalid symlink target: %v %v", target, err)
+	}
+	if _, err = dir.Lookup(ctx, "missing"); err != fuse.ENOENT {
+		t.Fatalf("Invalid error of the missing entry: %v", err)
+	}
+
+	sub, err := dir.Lookup(ctx, "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err = sub.(*mountedNode).Lookup(ctx, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = node.Attr(ctx, &attr); err != nil || attr.Size != uint64(len(big)) || attr.Mode != defaultFileMode {
+		t.Fatalf("Invalid attributes of the file: %+v %v", attr, err)
+	}
+
+	handle, err := node.(*mountedNode).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := handle.(*mountedFile)
+	var resp fuse.ReadResponse
+	if err = file.Read(ctx, &fuse.ReadRequest{Offset: int64(len(big)) - 10, Size: 100}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Data) != string(big[len(big)-10:]) {
+		t.Fatalf("Invalid data read from the end of the file: %d bytes", len(resp.Data))
+	}
+	if err = file.Release(ctx, &fuse.ReleaseRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = node.(*mountedNode).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{}); err != fuse.Errno(syscall.EROFS) {
+		t.Fatalf("Invalid error of opening the file for writing: %v", err)
+	}
+}
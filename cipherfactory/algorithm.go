@@ -0,0 +1,238 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipherfactory
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownAlgorithm           = errors.New("Unknown cipher algorithm")
+	ErrAlgorithmAlreadyRegistered = errors.New("Cipher algorithm has already been registered")
+)
+
+// Names of built-in algorithms
+const (
+	AlgorithmAES256CFB        = "AES-256-CFB"
+	AlgorithmAES256CTR        = "AES-256-CTR"
+	AlgorithmAES256GCM        = "AES-256-GCM"
+	AlgorithmChaCha20Poly1305 = "ChaCha20-Poly1305"
+
+	// Algorithm used by default, kept so that the same content gets the same
+	// blob ids as before. AES-256-GCM and ChaCha20-Poly1305 authenticating
+	// each chunk of the data must be selected explicitly.
+	DefaultAlgorithm = AlgorithmAES256CFB
+)
+
+// Algorithm is a cipher selectable by name. The identifier of the algorithm
+// is the first byte of keys it produces, decryptors find the algorithm
+// by the key thus any registered algorithm can be decrypted by any factory.
+type Algorithm interface {
+
+	// Unique name of the algorithm
+	Name() string
+
+	// Unique identifier of the algorithm stored in keys
+	ID() byte
+
+	// Number of bytes of the raw key
+	KeySize() int
+
+	// Create the encryptor, writers implementing io.Closer must be
+	// closed to produce valid data
+	NewEncryptor(key, ivSource []byte, output io.Writer) (writer io.Writer, err error)
+
+	// Create the decryptor of data produced by the encryptor
+	NewDecryptor(key, ivSource []byte, input io.Reader) (reader io.Reader, err error)
+}
+
+// Algorithm authenticating the encrypted data, decryptors fail as soon
+// as the tampered part of the data is read
+type AuthenticatedAlgorithm interface {
+	Algorithm
+
+	// Marks the algorithm as authenticated, true is returned
+	Authenticated() bool
+}
+
+// Algorithm allowing random access to the plain data
+type SeekableAlgorithm interface {
+	Algorithm
+
+	// Create the decryptor of data produced by the encryptor, the input
+	// must be positioned at the beginning of encrypted data
+	NewSeekableDecryptor(key, ivSource []byte, input io.ReadSeeker) (reader SeekableDecryptor, err error)
+}
+
+var (
+	algorithms     = make(map[string]Algorithm)
+	algorithmIDs   = make(map[byte]Algorithm)
+	algorithmsLock sync.RWMutex
+)
+
+// Register new algorithm, both its name and identifier must be unique
+func RegisterAlgorithm(a Algorithm) error {
+	algorithmsLock.Lock()
+	defer algorithmsLock.Unlock()
+
+	if _, exists := algorithms[a.Name()]; exists {
+		return ErrAlgorithmAlreadyRegistered
+	}
+	if _, exists := algorithmIDs[a.ID()]; exists {
+		return ErrAlgorithmAlreadyRegistered
+	}
+	algorithms[a.Name()] = a
+	algorithmIDs[a.ID()] = a
+	return nil
+}
+
+// Find the algorithm with given name
+func LookupAlgorithm(name string) (a Algorithm, ok bool) {
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+
+	a, ok = algorithms[name]
+	return
+}
+
+// Find the algorithm with given identifier
+func lookupAlgorithmID(id byte) (a Algorithm, ok bool) {
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+
+	a, ok = algorithmIDs[id]
+	return
+}
+
+// Get sorted names of registered algorithms
+func Algorithms() []string {
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterAlgorithm(aesStreamAlgorithm{AlgorithmAES256CFB, cipherAES256, cipher.NewCFBEncrypter, cipher.NewCFBDecrypter})
+	RegisterAlgorithm(aesStreamAlgorithm{AlgorithmAES256CTR, cipherAES256CTR, cipher.NewCTR, cipher.NewCTR})
+	RegisterAlgorithm(aeadAlgorithm{AlgorithmAES256GCM, cipherAES256GCM, newAESGCM})
+	RegisterAlgorithm(aeadAlgorithm{AlgorithmChaCha20Poly1305, cipherChaCha20Poly1305, chacha20poly1305.New})
+}
+
+// AES-256 in one of stream modes
+type aesStreamAlgorithm struct {
+	name      string
+	id        byte
+	encrypter func(block cipher.Block, iv []byte) cipher.Stream
+	decrypter func(block cipher.Block, iv []byte) cipher.Stream
+}
+
+func (a aesStreamAlgorithm) Name() string {
+	return a.name
+}
+
+func (a aesStreamAlgorithm) ID() byte {
+	return a.id
+}
+
+func (a aesStreamAlgorithm) KeySize() int {
+	return cipherAES256KeySourceLength
+}
+
+func (a aesStreamAlgorithm) NewEncryptor(key, ivSource []byte, output io.Writer) (writer io.Writer, err error) {
+
+	// Normalize the iv
+	var iv [aes.BlockSize]byte
+	copy(iv[:], ivSource)
+
+	blobCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Close of the stream writer would close the output
+	return struct{ io.Writer }{&cipher.StreamWriter{S: a.encrypter(blobCipher, iv[:]), W: output}}, nil
+}
+
+func (a aesStreamAlgorithm) NewDecryptor(key, ivSource []byte, input io.Reader) (reader io.Reader, err error) {
+
+	// Normalize the iv
+	var iv [aes.BlockSize]byte
+	copy(iv[:], ivSource)
+
+	blobCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamDecryptor{stream: a.decrypter(blobCipher, iv[:]), input: input}, nil
+}
+
+// Cipher authenticating the data in chunks
+type aeadAlgorithm struct {
+	name    string
+	id      byte
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+func (a aeadAlgorithm) Name() string {
+	return a.name
+}
+
+func (a aeadAlgorithm) ID() byte {
+	return a.id
+}
+
+// Both AES-256 and ChaCha20 use 256-bit keys
+func (aeadAlgorithm) KeySize() int {
+	return cipherAES256KeySourceLength
+}
+
+func (a aeadAlgorithm) NewEncryptor(key, ivSource []byte, output io.Writer) (writer io.Writer, err error) {
+	aead, err := a.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return newChunkedAEADWriter(aead, ivSource, aeadDefaultChunkSize, output), nil
+}
+
+func (a aeadAlgorithm) NewDecryptor(key, ivSource []byte, input io.Reader) (reader io.Reader, err error) {
+	aead, err := a.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return newChunkedAEADReader(aead, ivSource, aeadDefaultChunkSize, input), nil
+}
+
+func (aeadAlgorithm) Authenticated() bool {
+	return true
+}
+
+func (a aeadAlgorithm) NewSeekableDecryptor(key, ivSource []byte, input io.ReadSeeker) (reader SeekableDecryptor, err error) {
+	aead, err := a.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return newSeekableChunkedAEADReader(aead, ivSource, aeadDefaultChunkSize, input)
+}
+
+// Create AES-256 in GCM mode
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	blobCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(blobCipher)
+}
@@ -0,0 +1,57 @@
+package cipherfactory
+
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func BenchmarkEncrypt(b *testing.B) {
+	data := make([]byte, 4*1024*1024)
+	rand.Read(data)
+
+	for _, algorithm := range allAlgorithms {
+		f, err := CreateAlgorithm(algorithm)
+		if err != nil {
+			b.Fatal(err)
+		}
+		key := make([]byte, f.GetMinKeySourceBytes())
+
+		b.Run("algorithm="+algorithm, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				enc, _, err := f.CreateEncryptor(key, []byte{1, 2, 3}, ioutil.Discard)
+				if err != nil {
+					b.Fatal(err)
+				}
+				enc.Write(data)
+				if closer, ok := enc.(io.Closer); ok {
+					closer.Close()
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	data := make([]byte, 4*1024*1024)
+	rand.Read(data)
+
+	for _, algorithm := range HashAlgorithms() {
+		hasher, err := CreateHasher(algorithm)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run("algorithm="+algorithm, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				hasher.Reset()
+				hasher.Write(data)
+				hasher.Sum(nil)
+			}
+		})
+	}
+}
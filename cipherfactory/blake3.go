@@ -0,0 +1,335 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipherfactory
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+// Portable implementation of the BLAKE3 hash function with the default
+// 32-byte output, keyed hashing and key derivation modes are not supported.
+// It follows the reference implementation: the input is split into 1KiB
+// chunks, each chunk is compressed in 64-byte blocks and chaining values of
+// chunks are merged into the binary tree as soon as its subtrees are complete.
+// Chunks of large writes are hashed in parallel when more CPUs are available.
+
+const (
+	blake3OutLen   = 32
+	blake3BlockLen = 64
+	blake3ChunkLen = 1024
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+
+	// Minimal number of chunks hashed in parallel, smaller
+	// writes would not gain anything
+	blake3ParallelChunks = 16
+)
+
+var blake3IV = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+// Create the BLAKE3 hasher producing 32-byte sums
+func newBLAKE3() hash.Hash {
+	h := &blake3Hasher{}
+	h.Reset()
+	return h
+}
+
+func blake3G(a, b, c, d, mx, my uint32) (uint32, uint32, uint32, uint32) {
+	a += b + mx
+	d = bits.RotateLeft32(d^a, -16)
+	c += d
+	b = bits.RotateLeft32(b^c, -12)
+	a += b + my
+	d = bits.RotateLeft32(d^a, -8)
+	c += d
+	b = bits.RotateLeft32(b^c, -7)
+	return a, b, c, d
+}
+
+func blake3Compress(cv *[8]uint32, m *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s0, s1, s2, s3, s4, s5, s6, s7 := cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7]
+	s8, s9, s10, s11 := blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3]
+	s12, s13, s14, s15 := uint32(counter), uint32(counter>>32), blockLen, flags
+
+	// Rounds are unrolled, the message schedule of each round is the
+	// permutation of the previous one
+	s0, s4, s8, s12 = blake3G(s0, s4, s8, s12, m[0], m[1])
+	s1, s5, s9, s13 = blake3G(s1, s5, s9, s13, m[2], m[3])
+	s2, s6, s10, s14 = blake3G(s2, s6, s10, s14, m[4], m[5])
+	s3, s7, s11, s15 = blake3G(s3, s7, s11, s15, m[6], m[7])
+	s0, s5, s10, s15 = blake3G(s0, s5, s10, s15, m[8], m[9])
+	s1, s6, s11, s12 = blake3G(s1, s6, s11, s12, m[10], m[11])
+	s2, s7, s8, s13 = blake3G(s2, s7, s8, s13, m[12], m[13])
+	s3, s4, s9, s14 = blake3G(s3, s4, s9, s14, m[14], m[15])
+
+	s0, s4, s8, s12 = blake3G(s0, s4, s8, s12, m[2], m[6])
+	s1, s5, s9, s13 = blake3G(s1, s5, s9, s13, m[3], m[10])
+	s2, s6, s10, s14 = blake3G(s2, s6, s10, s14, m[7], m[0])
+	s3, s7, s11, s15 = blake3G(s3, s7, s11, s15, m[4], m[13])
+	s0, s5, s10, s15 = blake3G(s0, s5, s10, s15, m[1], m[11])
+	s1, s6, s11, s12 = blake3G(s1, s6, s11, s12, m[12], m[5])
+	s2, s7, s8, s13 = blake3G(s2, s7, s8, s13, m[9], m[14])
+	s3, s4, s9, s14 = blake3G(s3, s4, s9, s14, m[15], m[8])
+
+	s0, s4, s8, s12 = blake3G(s0, s4, s8, s12, m[3], m[4])
+	s1, s5, s9, s13 = blake3G(s1, s5, s9, s13, m[10], m[12])
+	s2, s6, s10, s14 = blake3G(s2, s6, s10, s14, m[13], m[2])
+	s3, s7, s11, s15 = blake3G(s3, s7, s11, s15, m[7], m[14])
+	s0, s5, s10, s15 = blake3G(s0, s5, s10, s15, m[6], m[5])
+	s1, s6, s11, s12 = blake3G(s1, s6, s11, s12, m[9], m[0])
+	s2, s7, s8, s13 = blake3G(s2, s7, s8, s13, m[11], m[15])
+	s3, s4, s9, s14 = blake3G(s3, s4, s9, s14, m[8], m[1])
+
+	s0, s4, s8, s12 = blake3G(s0, s4, s8, s12, m[10], m[7])
+	s1, s5, s9, s13 = blake3G(s1, s5, s9, s13, m[12], m[9])
+	s2, s6, s10, s14 = blake3G(s2, s6, s10, s14, m[14], m[3])
+	s3, s7, s11, s15 = blake3G(s3, s7, s11, s15, m[13], m[15])
+	s0, s5, s10, s15 = blake3G(s0, s5, s10, s15, m[4], m[0])
+	s1, s6, s11, s12 = blake3G(s1, s6, s11, s12, m[11], m[2])
+	s2, s7, s8, s13 = blake3G(s2, s7, s8, s13, m[5], m[8])
+	s3, s4, s9, s14 = blake3G(s3, s4, s9, s14, m[1], m[6])
+
+	s0, s4, s8, s12 = blake3G(s0, s4, s8, s12, m[12], m[13])
+	s1, s5, s9, s13 = blake3G(s1, s5, s9, s13, m[9], m[11])
+	s2, s6, s10, s14 = blake3G(s2, s6, s10, s14, m[15], m[10])
+	s3, s7, s11, s15 = blake3G(s3, s7, s11, s15, m[14], m[8])
+	s0, s5, s10, s15 = blake3G(s0, s5, s10, s15, m[7], m[2])
+	s1, s6, s11, s12 = blake3G(s1, s6, s11, s12, m[5], m[3])
+	s2, s7, s8, s13 = blake3G(s2, s7, s8, s13, m[0], m[1])
+	s3, s4, s9, s14 = blake3G(s3, s4, s9, s14, m[6], m[4])
+
+	s0, s4, s8, s12 = blake3G(s0, s4, s8, s12, m[9], m[14])
+	s1, s5, s9, s13 = blake3G(s1, s5, s9, s13, m[11], m[5])
+	s2, s6, s10, s14 = blake3G(s2, s6, s10, s14, m[8], m[12])
+	s3, s7, s11, s15 = blake3G(s3, s7, s11, s15, m[15], m[1])
+	s0, s5, s10, s15 = blake3G(s0, s5, s10, s15, m[13], m[3])
+	s1, s6, s11, s12 = blake3G(s1, s6, s11, s12, m[0], m[10])
+	s2, s7, s8, s13 = blake3G(s2, s7, s8, s13, m[2], m[6])
+	s3, s4, s9, s14 = blake3G(s3, s4, s9, s14, m[4], m[7])
+
+	s0, s4, s8, s12 = blake3G(s0, s4, s8, s12, m[11], m[15])
+	s1, s5, s9, s13 = blake3G(s1, s5, s9, s13, m[5], m[0])
+	s2, s6, s10, s14 = blake3G(s2, s6, s10, s14, m[1], m[9])
+	s3, s7, s11, s15 = blake3G(s3, s7, s11, s15, m[8], m[6])
+	s0, s5, s10, s15 = blake3G(s0, s5, s10, s15, m[14], m[10])
+	s1, s6, s11, s12 = blake3G(s1, s6, s11, s12, m[2], m[12])
+	s2, s7, s8, s13 = blake3G(s2, s7, s8, s13, m[3], m[4])
+	s3, s4, s9, s14 = blake3G(s3, s4, s9, s14, m[7], m[13])
+
+	return [16]uint32{
+		s0 ^ s8, s1 ^ s9, s2 ^ s10, s3 ^ s11, s4 ^ s12, s5 ^ s13, s6 ^ s14, s7 ^ s15,
+		s8 ^ cv[0], s9 ^ cv[1], s10 ^ cv[2], s11 ^ cv[3], s12 ^ cv[4], s13 ^ cv[5], s14 ^ cv[6], s15 ^ cv[7],
+	}
+}
+
+func blake3Words(block []byte) (words [16]uint32) {
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(block[4*i:])
+	}
+	return
+}
+
+// Input of the compression producing either the chaining value
+// or the root output
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o blake3Output) chainingValue() (cv [8]uint32) {
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], s[:8])
+	return
+}
+
+func (o blake3Output) rootBytes(out []byte) []byte {
+	s := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	for _, w := range s[:blake3OutLen/4] {
+		out = binary.LittleEndian.AppendUint32(out, w)
+	}
+	return out
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	o := blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: blake3Parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+// State of the chunk being hashed
+type blake3Chunk struct {
+	cv               [8]uint32
+	counter          uint64
+	block            [blake3BlockLen]byte
+	blockLen         int
+	blocksCompressed int
+}
+
+func (c *blake3Chunk) len() int {
+	return c.blocksCompressed*blake3BlockLen + c.blockLen
+}
+
+func (c *blake3Chunk) startFlag() uint32 {
+	if c.blocksCompressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3Chunk) compress(block []byte) {
+	words := blake3Words(block)
+	s := blake3Compress(&c.cv, &words, c.counter, blake3BlockLen, c.startFlag())
+	copy(c.cv[:], s[:8])
+	c.blocksCompressed++
+}
+
+func (c *blake3Chunk) update(p []byte) {
+	for len(p) > 0 {
+
+		// The full block is compressed once more data comes,
+		// the last block of the chunk must be kept for output
+		if c.blockLen == blake3BlockLen {
+			c.compress(c.block[:])
+			c.block = [blake3BlockLen]byte{}
+			c.blockLen = 0
+		}
+
+		// Blocks of the input are compressed without copying them
+		for c.blockLen == 0 && len(p) > blake3BlockLen {
+			c.compress(p[:blake3BlockLen])
+			p = p[blake3BlockLen:]
+		}
+
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *blake3Chunk) output() blake3Output {
+	return blake3Output{
+		cv:       c.cv,
+		block:    blake3Words(c.block[:]),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+// blake3Hasher implements hash.Hash, chaining values of complete
+// subtrees are kept on the stack
+type blake3Hasher struct {
+	chunk blake3Chunk
+	stack [][8]uint32
+}
+
+func (h *blake3Hasher) Reset() {
+	h.chunk = blake3Chunk{cv: blake3IV}
+	h.stack = h.stack[:0]
+}
+
+func (h *blake3Hasher) Size() int {
+	return blake3OutLen
+}
+
+func (h *blake3Hasher) BlockSize() int {
+	return blake3BlockLen
+}
+
+// Merge the chaining value of the finished chunk with complete subtrees,
+// the number of trailing zero bits of the chunk count is the number of
+// subtrees completed by the chunk
+func (h *blake3Hasher) addChunk(cv [8]uint32, totalChunks uint64) {
+	for totalChunks&1 == 0 {
+		cv = blake3ParentOutput(h.stack[len(h.stack)-1], cv).chainingValue()
+		h.stack = h.stack[:len(h.stack)-1]
+		totalChunks >>= 1
+	}
+	h.stack = append(h.stack, cv)
+}
+
+func (h *blake3Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+
+		// The full chunk is finished once more data comes,
+		// the last chunk must be kept for the root output
+		if h.chunk.len() == blake3ChunkLen {
+			output := h.chunk.output()
+			totalChunks := h.chunk.counter + 1
+			h.addChunk(output.chainingValue(), totalChunks)
+			h.chunk = blake3Chunk{cv: blake3IV, counter: totalChunks}
+		}
+
+		// Complete chunks are independent of each other, large writes
+		// hash them in parallel
+		if h.chunk.len() == 0 && len(p) > blake3ParallelChunks*blake3ChunkLen && runtime.GOMAXPROCS(0) > 1 {
+			p = h.writeChunks(p)
+			continue
+		}
+
+		take := blake3ChunkLen - h.chunk.len()
+		if take > len(p) {
+			take = len(p)
+		}
+		h.chunk.update(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// Hash chunks of the data followed by more data in parallel, the current
+// chunk must be empty. The rest of the data is returned.
+func (h *blake3Hasher) writeChunks(p []byte) []byte {
+	cvs := make([][8]uint32, (len(p)-1)/blake3ChunkLen)
+	workers := runtime.GOMAXPROCS(0)
+	perWorker := (len(cvs) + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for first := 0; first < len(cvs); first += perWorker {
+		last := first + perWorker
+		if last > len(cvs) {
+			last = len(cvs)
+		}
+		wg.Add(1)
+		go func(first, last int) {
+			defer wg.Done()
+			for i := first; i < last; i++ {
+				chunk := blake3Chunk{cv: blake3IV, counter: h.chunk.counter + uint64(i)}
+				chunk.update(p[i*blake3ChunkLen : (i+1)*blake3ChunkLen])
+				cvs[i] = chunk.output().chainingValue()
+			}
+		}(first, last)
+	}
+	wg.Wait()
+
+	for i, cv := range cvs {
+		h.addChunk(cv, h.chunk.counter+uint64(i)+1)
+	}
+	h.chunk = blake3Chunk{cv: blake3IV, counter: h.chunk.counter + uint64(len(cvs))}
+	return p[len(cvs)*blake3ChunkLen:]
+}
+
+func (h *blake3Hasher) Sum(b []byte) []byte {
+	output := h.chunk.output()
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		output = blake3ParentOutput(h.stack[i], output.chainingValue())
+	}
+	return output.rootBytes(b)
+}
@@ -0,0 +1,316 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipherfactory
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrChunkAuthenticationFailed = errors.New("Encrypted chunk authentication failed")
+	ErrTruncatedData             = errors.New("Encrypted data is truncated")
+	ErrWriterClosed              = errors.New("Encrypted writer has already been closed")
+)
+
+// Chunked mode for AEAD ciphers
+//
+// AEAD ciphers can only authenticate a message as a whole. To keep the
+// memory usage bounded, the plain data is split into chunks of fixed size
+// and each chunk is sealed separately. The nonce of each chunk is derived
+// from the base nonce by xoring the big-endian chunk counter into its last
+// bytes. The last chunk is always shorter than the full chunk (it can be
+// empty) and is sealed with a different additional data so that truncation
+// at the chunk boundary can be detected.
+
+const (
+	// Default number of plain bytes in one chunk
+	aeadDefaultChunkSize = 64 * 1024
+)
+
+var (
+	aeadAdditionalDataChunk     = []byte{0x00}
+	aeadAdditionalDataLastChunk = []byte{0x01}
+)
+
+// Calculate the nonce for given chunk number
+func aeadChunkNonce(nonce, baseNonce []byte, chunkNo uint64) {
+	copy(nonce, baseNonce)
+	var cnt [8]byte
+	binary.BigEndian.PutUint64(cnt[:], chunkNo)
+	for i := 0; i < len(cnt) && i < len(nonce); i++ {
+		nonce[len(nonce)-1-i] ^= cnt[len(cnt)-1-i]
+	}
+}
+
+// chunkedAEADWriter seals data written to it in chunks of fixed size
+type chunkedAEADWriter struct {
+	aead      cipher.AEAD // Cipher used to seal chunks
+	baseNonce []byte      // Nonce for the first chunk
+	nonce     []byte      // Nonce of the current chunk
+	chunkNo   uint64      // Number of the current chunk
+	chunkSize int         // Number of plain bytes in one chunk
+	buffer    []byte      // Buffer for the current chunk, reused for the sealed data
+	output    io.Writer   // Destination of sealed chunks
+	closed    bool        // Set once the last chunk has been sealed
+}
+
+func newChunkedAEADWriter(aead cipher.AEAD, ivSource []byte, chunkSize int, output io.Writer) *chunkedAEADWriter {
+	baseNonce := make([]byte, aead.NonceSize())
+	copy(baseNonce, ivSource)
+	return &chunkedAEADWriter{
+		aead:      aead,
+		baseNonce: baseNonce,
+		nonce:     make([]byte, aead.NonceSize()),
+		chunkSize: chunkSize,
+		buffer:    make([]byte, 0, chunkSize+aead.Overhead()),
+		output:    output,
+	}
+}
+
+func (w *chunkedAEADWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+
+	for len(p) > 0 {
+
+		// Fill in the current chunk
+		partial := w.chunkSize - len(w.buffer)
+		if partial > len(p) {
+			partial = len(p)
+		}
+		w.buffer = append(w.buffer, p[:partial]...)
+		p = p[partial:]
+		n += partial
+
+		// The chunk is flushed only once there's more data to be written,
+		// otherwise we wouldn't know whether it's the last one
+		if len(w.buffer) == w.chunkSize && len(p) > 0 {
+			if err = w.flush(aeadAdditionalDataChunk); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Close seals the last chunk, it must be called to produce valid data
+func (w *chunkedAEADWriter) Close() error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	// Full chunk can not be the last one
+	if len(w.buffer) == w.chunkSize {
+		if err := w.flush(aeadAdditionalDataChunk); err != nil {
+			return err
+		}
+	}
+
+	w.closed = true
+	return w.flush(aeadAdditionalDataLastChunk)
+}
+
+func (w *chunkedAEADWriter) flush(additionalData []byte) error {
+	aeadChunkNonce(w.nonce, w.baseNonce, w.chunkNo)
+	sealed := w.aead.Seal(w.buffer[:0], w.nonce, w.buffer, additionalData)
+	w.chunkNo++
+	w.buffer = w.buffer[:0]
+	_, err := w.output.Write(sealed)
+	return err
+}
+
+// chunkedAEADReader opens chunks sealed by the chunkedAEADWriter,
+// only one chunk is kept in memory at a time
+type chunkedAEADReader struct {
+	aead      cipher.AEAD // Cipher used to open chunks
+	baseNonce []byte      // Nonce for the first chunk
+	nonce     []byte      // Nonce of the current chunk
+	chunkNo   uint64      // Number of the current chunk
+	chunkSize int         // Number of plain bytes in one chunk
+	buffer    []byte      // Buffer for the sealed chunk, reused for the plain data
+	plain     []byte      // Plain data not yet returned to the caller
+	lastChunk bool        // Set once the last chunk has been opened
+	err       error       // Sticky error
+	input     io.Reader   // Source of sealed chunks
+}
+
+func newChunkedAEADReader(aead cipher.AEAD, ivSource []byte, chunkSize int, input io.Reader) *chunkedAEADReader {
+	baseNonce := make([]byte, aead.NonceSize())
+	copy(baseNonce, ivSource)
+	return &chunkedAEADReader{
+		aead:      aead,
+		baseNonce: baseNonce,
+		nonce:     make([]byte, aead.NonceSize()),
+		chunkSize: chunkSize,
+		buffer:    make([]byte, chunkSize+aead.Overhead()),
+		input:     input,
+	}
+}
+
+func (r *chunkedAEADReader) Read(p []byte) (n int, err error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.lastChunk {
+			return 0, io.EOF
+		}
+		r.err = r.nextChunk()
+	}
+
+	n = copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// WriteTo writes opened chunks directly to the writer without copying
+// them through the buffer of the caller
+func (r *chunkedAEADReader) WriteTo(w io.Writer) (written int64, err error) {
+	for {
+		if len(r.plain) > 0 {
+			n, err := w.Write(r.plain)
+			written += int64(n)
+			r.plain = r.plain[n:]
+			if err != nil {
+				return written, err
+			}
+			if len(r.plain) > 0 {
+				return written, io.ErrShortWrite
+			}
+		}
+		if r.err != nil {
+			return written, r.err
+		}
+		if r.lastChunk {
+			return written, nil
+		}
+		r.err = r.nextChunk()
+	}
+}
+
+func (r *chunkedAEADReader) nextChunk() error {
+
+	// Only the last chunk may be shorter than the full one
+	n, err := io.ReadFull(r.input, r.buffer)
+	additionalData := aeadAdditionalDataChunk
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		if n < r.aead.Overhead() {
+			return ErrTruncatedData
+		}
+		additionalData = aeadAdditionalDataLastChunk
+		r.lastChunk = true
+	default:
+		return err
+	}
+
+	aeadChunkNonce(r.nonce, r.baseNonce, r.chunkNo)
+	plain, err := r.aead.Open(r.buffer[:0], r.nonce, r.buffer[:n], additionalData)
+	if err != nil {
+		return ErrChunkAuthenticationFailed
+	}
+	r.chunkNo++
+	r.plain = plain
+	return nil
+}
+
+// seekableChunkedAEADReader allows random access to data sealed by the
+// chunkedAEADWriter. The chunk containing the new position is opened from
+// its beginning, chunks before it are not read.
+type seekableChunkedAEADReader struct {
+	*chunkedAEADReader
+	input  io.ReadSeeker // Source of sealed chunks
+	base   int64         // Position of sealed data in the input
+	offset int64         // Current position in the plain data
+}
+
+func newSeekableChunkedAEADReader(aead cipher.AEAD, ivSource []byte, chunkSize int, input io.ReadSeeker) (*seekableChunkedAEADReader, error) {
+
+	// The sealed data starts at the current position of the input
+	base, err := input.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &seekableChunkedAEADReader{
+		chunkedAEADReader: newChunkedAEADReader(aead, ivSource, chunkSize, input),
+		input:             input,
+		base:              base,
+	}, nil
+}
+
+func (r *seekableChunkedAEADReader) Read(p []byte) (n int, err error) {
+	n, err = r.chunkedAEADReader.Read(p)
+	r.offset += int64(n)
+	return
+}
+
+func (r *seekableChunkedAEADReader) WriteTo(w io.Writer) (written int64, err error) {
+	written, err = r.chunkedAEADReader.WriteTo(w)
+	r.offset += written
+	return
+}
+
+func (r *seekableChunkedAEADReader) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		size, err := r.plainSize()
+		if err != nil {
+			return r.offset, err
+		}
+		offset += size
+	default:
+		return r.offset, ErrInvalidOffset
+	}
+
+	if offset < 0 {
+		return r.offset, ErrInvalidOffset
+	}
+
+	chunkNo := offset / int64(r.chunkSize)
+	sealedChunkSize := int64(r.chunkSize + r.aead.Overhead())
+	if _, err := r.input.Seek(r.base+chunkNo*sealedChunkSize, io.SeekStart); err != nil {
+		return r.offset, err
+	}
+	r.chunkNo, r.plain, r.lastChunk, r.err = uint64(chunkNo), nil, false, nil
+	r.offset = chunkNo * int64(r.chunkSize)
+
+	// The chunk is opened to skip the data before the position within it,
+	// positions past the end are kept without reading
+	if within := offset - r.offset; within > 0 {
+		if r.err = r.nextChunk(); r.err == nil {
+			if within > int64(len(r.plain)) {
+				within = int64(len(r.plain))
+			}
+			r.plain = r.plain[within:]
+		}
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Get the size of the plain data from the size of sealed data, the last
+// chunk is always shorter than the full one
+func (r *seekableChunkedAEADReader) plainSize() (int64, error) {
+	end, err := r.input.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	sealedChunkSize := int64(r.chunkSize + r.aead.Overhead())
+	sealed := end - r.base
+	if sealed%sealedChunkSize < int64(r.aead.Overhead()) {
+		return 0, ErrTruncatedData
+	}
+	return sealed - (sealed/sealedChunkSize+1)*int64(r.aead.Overhead()), nil
+}
@@ -0,0 +1,254 @@
+package cipherfactory
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func testAEAD(t *testing.T) cipher.AEAD {
+	c, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Couldn't create AES cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(c)
+	if err != nil {
+		t.Fatalf("Couldn't create GCM cipher: %v", err)
+	}
+	return aead
+}
+
+func chunkedEncrypt(t *testing.T, aead cipher.AEAD, chunkSize int, data []byte) []byte {
+	buff := &bytes.Buffer{}
+	w := newChunkedAEADWriter(aead, []byte{1, 2, 3}, chunkSize, buff)
+
+	// Write in small pieces to cross chunk borders at random places
+	for p := data; len(p) > 0; {
+		l := 7
+		if l > len(p) {
+			l = len(p)
+		}
+		if _, err := w.Write(p[:l]); err != nil {
+			t.Fatalf("Couldn't write to chunked encryptor: %v", err)
+		}
+		p = p[l:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Couldn't close chunked encryptor: %v", err)
+	}
+	return buff.Bytes()
+}
+
+func TestChunkedAEADEncryptDecrypt(t *testing.T) {
+
+	aead := testAEAD(t)
+
+	for _, size := range []int{0, 1, 15, 16, 17, 31, 32, 33, 160, 1089} {
+
+		data := make([]byte, size)
+		rand.Read(data)
+
+		encrypted := chunkedEncrypt(t, aead, 16, data)
+
+		// Last chunk is always shorter than the full one
+		expectedSize := (size/16+1)*aead.Overhead() + size
+		if len(encrypted) != expectedSize {
+			t.Fatalf("Invalid encrypted size for %v bytes, expected: %v, got: %v", size, expectedSize, len(encrypted))
+		}
+
+		r := newChunkedAEADReader(aead, []byte{1, 2, 3}, 16, bytes.NewReader(encrypted))
+		decrypted, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Couldn't decrypt %v bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("Invalid data decrypted for %v bytes", size)
+		}
+	}
+}
+
+func TestChunkedAEADTamperDetection(t *testing.T) {
+
+	aead := testAEAD(t)
+	data := make([]byte, 100)
+	encrypted := chunkedEncrypt(t, aead, 16, data)
+
+	// Flip a bit in the third chunk, first two chunks must still be readable
+	tampered := append([]byte{}, encrypted...)
+	tampered[2*(16+aead.Overhead())+3] ^= 0x01
+
+	r := newChunkedAEADReader(aead, []byte{1, 2, 3}, 16, bytes.NewReader(tampered))
+	buff := make([]byte, 32)
+	if _, err := io.ReadFull(r, buff); err != nil {
+		t.Fatalf("Couldn't read untampered chunks: %v", err)
+	}
+	if _, err := r.Read(buff); err != ErrChunkAuthenticationFailed {
+		t.Fatalf("Tampered chunk not detected, got error: %v", err)
+	}
+
+	// Truncation at the chunk boundary
+	for _, l := range []int{0, 16 + aead.Overhead(), 2 * (16 + aead.Overhead())} {
+		r = newChunkedAEADReader(aead, []byte{1, 2, 3}, 16, bytes.NewReader(encrypted[:l]))
+		if _, err := ioutil.ReadAll(r); err == nil {
+			t.Fatalf("Truncation to %v bytes not detected", l)
+		}
+	}
+
+	// Chunks must not be reordered
+	reordered := append([]byte{}, encrypted[16+aead.Overhead():2*(16+aead.Overhead())]...)
+	reordered = append(reordered, encrypted[:16+aead.Overhead()]...)
+	reordered = append(reordered, encrypted[2*(16+aead.Overhead()):]...)
+	r = newChunkedAEADReader(aead, []byte{1, 2, 3}, 16, bytes.NewReader(reordered))
+	if _, err := ioutil.ReadAll(r); err != ErrChunkAuthenticationFailed {
+		t.Fatalf("Reordered chunks not detected, got error: %v", err)
+	}
+}
+
+func TestSeekableChunkedAEAD(t *testing.T) {
+
+	aead := testAEAD(t)
+
+	for _, size := range []int{0, 1, 16, 17, 32, 100} {
+		data := make([]byte, size)
+		rand.Read(data)
+		encrypted := chunkedEncrypt(t, aead, 16, data)
+
+		// The data is preceded by other bytes of the input
+		input := bytes.NewReader(append([]byte{9, 9}, encrypted...))
+		input.Seek(2, io.SeekStart)
+		r, err := newSeekableChunkedAEADReader(aead, []byte{1, 2, 3}, 16, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, offset := range []int{size, size / 2, 0, 15, 16, 17, 33, size + 5} {
+			if pos, err := r.Seek(int64(offset), io.SeekStart); err != nil || pos != int64(offset) {
+				t.Fatalf("Couldn't seek to %v of %v bytes: %v %v", offset, size, pos, err)
+			}
+			rest, err := ioutil.ReadAll(r)
+			if offset > size {
+				if len(rest) != 0 {
+					t.Fatalf("Data read past the end of %v bytes: %v", size, len(rest))
+				}
+				continue
+			}
+			if err != nil || !bytes.Equal(rest, data[offset:]) {
+				t.Fatalf("Invalid data read from %v of %v bytes: %v", offset, size, err)
+			}
+		}
+
+		if pos, err := r.Seek(-1, io.SeekEnd); size > 0 && (err != nil || pos != int64(size-1)) {
+			t.Fatalf("Couldn't seek relative to the end of %v bytes: %v %v", size, pos, err)
+		}
+		if _, err := r.Seek(-1, io.SeekStart); err != ErrInvalidOffset {
+			t.Fatalf("Invalid error for negative offset: %v", err)
+		}
+	}
+
+	// Chunks read after the seek are authenticated
+	data := make([]byte, 100)
+	encrypted := chunkedEncrypt(t, aead, 16, data)
+	encrypted[3*(16+aead.Overhead())+1] ^= 0x01
+	r, _ := newSeekableChunkedAEADReader(aead, []byte{1, 2, 3}, 16, bytes.NewReader(encrypted))
+	r.Seek(50, io.SeekStart)
+	if _, err := ioutil.ReadAll(r); err != ErrChunkAuthenticationFailed {
+		t.Fatalf("Tampered chunk not detected after the seek, got error: %v", err)
+	}
+	r.Seek(70, io.SeekStart)
+	if rest, err := ioutil.ReadAll(r); err != nil || len(rest) != 30 {
+		t.Fatalf("Couldn't read untampered chunks after the seek: %v %v", len(rest), err)
+	}
+}
+
+func TestSeekableAuthenticatedKeys(t *testing.T) {
+
+	data := make([]byte, 200*1024)
+	rand.Read(data)
+	buff := &bytes.Buffer{}
+	w, key, err := mustCreate(t, AlgorithmAES256GCM).CreateEncryptor(make([]byte, 32), []byte{5}, buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.(io.Closer).Close()
+
+	if !IsAuthenticated(key) {
+		t.Fatal("Key of AES-256-GCM not authenticated")
+	}
+	r, err := Create().CreateSeekableDecryptor(key, []byte{5}, bytes.NewReader(buff.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Seek(150*1024, io.SeekStart)
+	if rest, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(rest, data[150*1024:]) {
+		t.Fatalf("Invalid data read after the seek: %v", err)
+	}
+
+	for _, algorithm := range []string{AlgorithmAES256CFB, AlgorithmAES256CTR} {
+		_, key, _ := mustCreate(t, algorithm).CreateEncryptor(make([]byte, 32), nil, ioutil.Discard)
+		if IsAuthenticated(key) {
+			t.Fatalf("Key of %v authenticated", algorithm)
+		}
+	}
+	if IsAuthenticated("") {
+		t.Fatal("Invalid key authenticated")
+	}
+}
+
+func TestChunkedAEADWriteAfterClose(t *testing.T) {
+	w := newChunkedAEADWriter(testAEAD(t), nil, 16, &bytes.Buffer{})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Couldn't close chunked encryptor: %v", err)
+	}
+	if _, err := w.Write([]byte{1}); err != ErrWriterClosed {
+		t.Fatalf("Invalid error when writing to closed encryptor: %v", err)
+	}
+	if err := w.Close(); err != ErrWriterClosed {
+		t.Fatalf("Invalid error for double close: %v", err)
+	}
+}
+
+// Reader producing an endless stream of bytes, remembers
+// the largest read request
+type endlessReader struct {
+	maxRead int
+	total   int64
+}
+
+func (e *endlessReader) Read(p []byte) (int, error) {
+	if len(p) > e.maxRead {
+		e.maxRead = len(p)
+	}
+	e.total += int64(len(p))
+	return len(p), nil
+}
+
+func TestDecryptorIsBounded(t *testing.T) {
+
+	f := Create()
+	input := &endlessReader{}
+
+	dec, err := f.CreateDecryptor(cipherAES256Hex+hex64Zeros, nil, input)
+	if err != nil {
+		t.Fatalf("Error creating decryptor: %v", err)
+	}
+
+	// Decrypt 64MB of data through a small buffer
+	buff, n := make([]byte, 4096), int64(0)
+	for n < 64*1024*1024 {
+		r, err := dec.Read(buff)
+		if err != nil {
+			t.Fatalf("Couldn't decrypt data: %v", err)
+		}
+		n += int64(r)
+	}
+	if input.maxRead > 4096 || input.total > n {
+		t.Fatalf("Decryptor did read ahead, max read: %v, total read: %v", input.maxRead, input.total)
+	}
+}
+
+const hex64Zeros = "0000000000000000000000000000000000000000000000000000000000000000"
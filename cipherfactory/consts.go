@@ -6,4 +6,16 @@ const (
 	cipherAES256                = 0x01
 	cipherAES256Hex             = "01"
 	cipherAES256KeySourceLength = 32
+
+	// AES-256 cipher in CTR mode identification
+	cipherAES256CTR    = 0x02
+	cipherAES256CTRHex = "02"
+
+	// AES-256 cipher in chunked GCM mode identification
+	cipherAES256GCM    = 0x03
+	cipherAES256GCMHex = "03"
+
+	// ChaCha20-Poly1305 cipher in chunked mode identification
+	cipherChaCha20Poly1305    = 0x04
+	cipherChaCha20Poly1305Hex = "04"
 )
@@ -0,0 +1,137 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipherfactory
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotSeekable   = errors.New("Decryptor for this key type is not seekable")
+	ErrInvalidOffset = errors.New("Invalid seek offset")
+)
+
+// SeekableDecryptor is a decryptor allowing random access to the plain data
+type SeekableDecryptor interface {
+	io.ReadSeeker
+}
+
+func (d *defaultFactory) CreateSeekableDecryptor(key string, ivSource []byte, input io.ReadSeeker) (reader SeekableDecryptor, err error) {
+	keyRaw, err := hex.DecodeString(key)
+	if err != nil || len(keyRaw) < 1 {
+		return nil, ErrInvalidKey
+	}
+
+	if keyRaw[0] != cipherAES256CTR {
+		algorithm, ok := lookupAlgorithmID(keyRaw[0])
+		if !ok {
+			return nil, ErrUnknownKeyType
+		}
+		seekable, ok := algorithm.(SeekableAlgorithm)
+		if !ok {
+			return nil, ErrNotSeekable
+		}
+		if len(keyRaw) != algorithm.KeySize()+1 {
+			return nil, ErrInvalidKey
+		}
+		return seekable.NewSeekableDecryptor(keyRaw[1:], ivSource, input)
+	}
+
+	if len(keyRaw) != cipherAES256KeySourceLength+1 {
+		return nil, ErrInvalidKey
+	}
+
+	blobCipher, err := aes.NewCipher(keyRaw[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	// The encrypted data starts at the current position of the input
+	base, err := input.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ctrSeekableDecryptor{
+		block: blobCipher,
+		input: input,
+		base:  base,
+	}
+	copy(s.iv[:], ivSource)
+	s.resetStream()
+
+	return s, nil
+}
+
+// ctrSeekableDecryptor decrypts data in CTR mode, the counter is recomputed
+// on each seek so no data before the new position has to be decrypted
+type ctrSeekableDecryptor struct {
+	block  cipher.Block        // Base cipher
+	iv     [aes.BlockSize]byte // Initial counter value
+	stream cipher.Stream       // Key stream for the current position
+	input  io.ReadSeeker       // Source of encrypted data
+	base   int64               // Position of encrypted data in the input
+	offset int64               // Current position in the plain data
+}
+
+func (s *ctrSeekableDecryptor) Read(p []byte) (n int, err error) {
+	n, err = s.input.Read(p)
+	s.stream.XORKeyStream(p[:n], p[:n])
+	s.offset += int64(n)
+	return
+}
+
+func (s *ctrSeekableDecryptor) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		end, err := s.input.Seek(0, io.SeekEnd)
+		if err != nil {
+			return s.offset, err
+		}
+		offset += end - s.base
+	default:
+		return s.offset, ErrInvalidOffset
+	}
+
+	if offset < 0 {
+		return s.offset, ErrInvalidOffset
+	}
+
+	if _, err := s.input.Seek(s.base+offset, io.SeekStart); err != nil {
+		return s.offset, err
+	}
+
+	s.offset = offset
+	s.resetStream()
+	return offset, nil
+}
+
+// Recreate the key stream for the current offset
+func (s *ctrSeekableDecryptor) resetStream() {
+
+	// Add the block number to the initial counter value
+	var counter [aes.BlockSize]byte
+	copy(counter[:], s.iv[:])
+	carry := uint64(s.offset / aes.BlockSize)
+	for i := len(counter) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(counter[i]) + (carry & 0xFF)
+		counter[i] = byte(sum)
+		carry = (carry >> 8) + (sum >> 8)
+	}
+
+	// Skip bytes within the block
+	s.stream = cipher.NewCTR(s.block, counter[:])
+	var skip [aes.BlockSize]byte
+	within := int(s.offset % aes.BlockSize)
+	s.stream.XORKeyStream(skip[:within], skip[:within])
+}
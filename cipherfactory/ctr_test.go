@@ -0,0 +1,119 @@
+package cipherfactory
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func ctrEncrypt(t *testing.T, iv, data []byte) (string, []byte) {
+	f := CreateCTR()
+	buff := &bytes.Buffer{}
+	key := make([]byte, f.GetMinKeySourceBytes())
+	rand.Read(key)
+
+	enc, keyStr, err := f.CreateEncryptor(key, iv, buff)
+	if err != nil {
+		t.Fatalf("Error creating encryptor: %v", err)
+	}
+	if _, err = enc.Write(data); err != nil {
+		t.Fatalf("Error writing to encryptor: %v", err)
+	}
+	return keyStr, buff.Bytes()
+}
+
+func TestCTREncryptorDecryptorPair(t *testing.T) {
+
+	data := make([]byte, 1089)
+	rand.Read(data)
+	iv := []byte{1, 2, 3, 4}
+
+	keyStr, encrypted := ctrEncrypt(t, iv, data)
+	if keyStr[:2] != cipherAES256CTRHex {
+		t.Fatalf("Invalid key type: %v", keyStr[:2])
+	}
+
+	// Both factories must be able to decrypt the data
+	for _, f := range []Factory{Create(), CreateCTR()} {
+		dec, err := f.CreateDecryptor(keyStr, iv, bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatalf("Error creating decryptor: %v", err)
+		}
+		decrypted, err := ioutil.ReadAll(dec)
+		if err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatal("Decryptor returned invalid data")
+		}
+	}
+}
+
+func TestCTRSeekableDecryptor(t *testing.T) {
+
+	data := make([]byte, 1089)
+	rand.Read(data)
+
+	// The iv with all bits set tests the counter overflow
+	iv := bytes.Repeat([]byte{0xFF}, 16)
+	keyStr, encrypted := ctrEncrypt(t, iv, data)
+
+	// Encrypted data is preceded by some header
+	input := bytes.NewReader(append([]byte("header"), encrypted...))
+	input.Seek(6, io.SeekStart)
+
+	dec, err := Create().CreateSeekableDecryptor(keyStr, iv, input)
+	if err != nil {
+		t.Fatalf("Error creating seekable decryptor: %v", err)
+	}
+
+	for _, pos := range []struct {
+		offset int64
+		whence int
+		result int64
+	}{
+		{0, io.SeekStart, 0},
+		{17, io.SeekStart, 17},
+		{15, io.SeekCurrent, 15 + 17 + 10},
+		{-1, io.SeekEnd, 1088},
+		{-100, io.SeekEnd, 989},
+		{512, io.SeekStart, 512},
+		{1089, io.SeekStart, 1089},
+	} {
+		n, err := dec.Seek(pos.offset, pos.whence)
+		if err != nil {
+			t.Fatalf("Couldn't seek: %v", err)
+		}
+		if n != pos.result {
+			t.Fatalf("Invalid position after seek, expected: %v, got: %v", pos.result, n)
+		}
+
+		buff := make([]byte, 10)
+		r, _ := io.ReadFull(dec, buff)
+		if !bytes.Equal(buff[:r], data[n:n+int64(r)]) {
+			t.Fatalf("Invalid data read at offset %v", n)
+		}
+	}
+
+	if _, err := dec.Seek(-1, io.SeekStart); err != ErrInvalidOffset {
+		t.Fatalf("Invalid error for negative offset: %v", err)
+	}
+}
+
+func TestSeekableDecryptorKeys(t *testing.T) {
+
+	f := Create()
+
+	keyStr, _ := ctrEncrypt(t, nil, nil)
+	if _, err := f.CreateSeekableDecryptor(cipherAES256Hex+keyStr[2:], nil, bytes.NewReader(nil)); err != ErrNotSeekable {
+		t.Errorf("Invalid error for non-seekable key: %v", err)
+	}
+
+	for _, ks := range []string{"", "a", "z", "FFABCDABCDABCDABCDABCDABCDABCDABCD", "020000000000000000"} {
+		if _, err := f.CreateSeekableDecryptor(ks, nil, bytes.NewReader(nil)); err == nil {
+			t.Error("Could create seekable decryptor although the key is invalid")
+		}
+	}
+}
@@ -1,9 +1,6 @@
 package cipherfactory
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/sha512"
 	"encoding/hex"
 	"errors"
 	"hash"
@@ -16,84 +13,80 @@ var (
 	ErrUnknownKeyType        = errors.New("Unknown key type")
 )
 
+// Factory encrypting data with one algorithm, data encrypted
+// with any registered algorithm can be decrypted
 type defaultFactory struct {
+	algorithm Algorithm
 }
 
 func (d *defaultFactory) GetMinKeySourceBytes() int {
-	return cipherAES256KeySourceLength
+	return d.algorithm.KeySize()
 }
 
 func (d *defaultFactory) CreateEncryptor(keySource, ivSource []byte, output io.Writer) (writer io.Writer, key string, err error) {
 
-	// Need at least 32 bytes of the key source
-	if len(keySource) < cipherAES256KeySourceLength {
+	// Need enough bytes of the key source
+	if len(keySource) < d.algorithm.KeySize() {
 		err = ErrInsufficientKeySource
 		return
 	}
 
-	// Create the iv
-	var iv [aes.BlockSize]byte
-	copy(iv[:], ivSource)
-
-	// Create AES-compatible key
-	keyRaw := keySource[:cipherAES256KeySourceLength]
-	key = cipherAES256Hex + hex.EncodeToString(keyRaw)
-
-	// Generate the encrypted content
-	blobCipher, err := aes.NewCipher(keyRaw)
-	if err != nil {
-		return
+	// The identifier of the algorithm goes first
+	keyRaw := keySource[:d.algorithm.KeySize()]
+	if writer, err = d.algorithm.NewEncryptor(keyRaw, ivSource, output); err != nil {
+		return nil, "", err
 	}
-
-	// Generate the writer
-	writer = &cipher.StreamWriter{
-		S: cipher.NewCFBEncrypter(
-			blobCipher,
-			iv[:]),
-		W: output}
+	key = hex.EncodeToString([]byte{d.algorithm.ID()}) + hex.EncodeToString(keyRaw)
 
 	return
 }
 
 func (d *defaultFactory) CreateDecryptor(key string, ivSource []byte, input io.Reader) (reader io.Reader, err error) {
-	keyRaw, err := hex.DecodeString(key)
-	if err != nil || len(keyRaw) < 1 {
-		return nil, ErrInvalidKey
+	algorithm, keyRaw, err := decodeKey(key)
+	if err != nil {
+		return nil, err
 	}
+	return algorithm.NewDecryptor(keyRaw[1:], ivSource, input)
+}
 
-	switch keyRaw[0] {
-	case cipherAES256:
-		return d.createDecryptorAES256(keyRaw[1:], ivSource, input)
+// Decode the key produced by CreateEncryptor, the raw key
+// starts with the identifier of the algorithm
+func decodeKey(key string) (algorithm Algorithm, keyRaw []byte, err error) {
+	keyRaw, err = hex.DecodeString(key)
+	if err != nil || len(keyRaw) < 1 {
+		return nil, nil, ErrInvalidKey
 	}
 
-	return nil, ErrUnknownKeyType
+	algorithm, ok := lookupAlgorithmID(keyRaw[0])
+	if !ok {
+		return nil, nil, ErrUnknownKeyType
+	}
+	if len(keyRaw) != algorithm.KeySize()+1 {
+		return nil, nil, ErrInvalidKey
+	}
+	return algorithm, keyRaw, nil
 }
 
-func (d *defaultFactory) createDecryptorAES256(key []byte, ivSource []byte, input io.Reader) (reader io.Reader, err error) {
-
-	if len(key) != cipherAES256KeySourceLength {
-		return nil, ErrInvalidKey
+// Get the name of the algorithm of the key produced by CreateEncryptor
+func KeyAlgorithm(key string) (string, error) {
+	algorithm, _, err := decodeKey(key)
+	if err != nil {
+		return "", err
 	}
+	return algorithm.Name(), nil
+}
 
-	// Normalize the iv
-	var iv [aes.BlockSize]byte
-	copy( iv[:], ivSource );
-
-	// Create new base cipher
-	blobCipher, err := aes.NewCipher(key)
+// Check whether the cipher of the key produced by CreateEncryptor
+// authenticates the data, see AuthenticatedAlgorithm
+func IsAuthenticated(key string) bool {
+	algorithm, _, err := decodeKey(key)
 	if err != nil {
-		return nil, err
+		return false
 	}
-
-	// Generate the reader in CFB mode
-	return &cipher.StreamReader{
-			S: cipher.NewCFBDecrypter(
-				blobCipher,
-				iv[:]),
-			R: input},
-		nil
+	authenticated, ok := algorithm.(AuthenticatedAlgorithm)
+	return ok && authenticated.Authenticated()
 }
 
 func (d *defaultFactory) CreateHasher() (hasher hash.Hash, err error) {
-	return sha512.New(), nil
+	return CreateHasher(DefaultHashAlgorithm)
 }
@@ -11,8 +11,9 @@ type Factory interface {
 	// Get minimum number of bytes in the key source
 	GetMinKeySourceBytes() int
 
-	// TODO: Shouldn't we operate on io.WriterCloser here ?
-	// Create io.Writer to encrypt data writter and save to provided writer,
+	// Create io.Writer to encrypt data writter and save to provided writer.
+	// Writers of authenticated algorithms implement io.Closer, they must be
+	// closed to produce valid data.
 	// Parameters:
 	//   keySource - byte blob used as source for the key computation
 	//   ivSource  - byte blob used as source for the iv computation
@@ -25,14 +26,43 @@ type Factory interface {
 
 	// Create a decryptor from key (returned from CreateEncryptor function) and iv source,
 	// Similarly to CreateEncryptor, this function returns reader that can be used to read
-	// plain data, one must provide source data reader that should allow reading encrypted data
+	// plain data, one must provide source data reader that should allow reading encrypted data.
+	//
+	// The decryptor is a stream - it uses a fixed-size internal buffer and never
+	// reads the whole message into memory. For chunked ciphers at most one chunk
+	// is buffered at a time.
 	CreateDecryptor(key string, ivSource []byte, input io.Reader) (reader io.Reader, err error)
 
-	// Create default hasher
+	// Create a decryptor allowing random access to the plain data. The input must
+	// be positioned at the beginning of encrypted data. Only keys of ciphers working
+	// in CTR mode and of SeekableAlgorithm ones can be used here, ErrNotSeekable is
+	// returned for other ciphers.
+	CreateSeekableDecryptor(key string, ivSource []byte, input io.ReadSeeker) (reader SeekableDecryptor, err error)
+
+	// Create the hasher of the default algorithm, see the package-level
+	// CreateHasher to select the algorithm
 	CreateHasher() (hasher hash.Hash, err error)
 }
 
-// Create default factory
+// Create default factory, data is encrypted with DefaultAlgorithm
 func Create() Factory {
-	return &defaultFactory{}
+	f, _ := CreateAlgorithm(DefaultAlgorithm)
+	return f
+}
+
+// Create factory using AES-256 in CTR mode, such encrypted data
+// can be decrypted with a seekable decryptor
+func CreateCTR() Factory {
+	f, _ := CreateAlgorithm(AlgorithmAES256CTR)
+	return f
+}
+
+// Create factory encrypting data with the named algorithm, the algorithm
+// is encoded in produced keys so that decryptors select the right cipher
+func CreateAlgorithm(name string) (Factory, error) {
+	a, ok := LookupAlgorithm(name)
+	if !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+	return &defaultFactory{algorithm: a}, nil
 }
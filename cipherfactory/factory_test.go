@@ -4,9 +4,18 @@ import (
 	"bytes"
 	"crypto/rand"
 	"io"
+	"io/ioutil"
 	"testing"
 )
 
+func mustCreate(t *testing.T, algorithm string) Factory {
+	f, err := CreateAlgorithm(algorithm)
+	if err != nil {
+		t.Fatalf("Couldn't create factory for %v: %v", algorithm, err)
+	}
+	return f
+}
+
 func TestFactoryCreation(t *testing.T) {
 
 	f := Create()
@@ -196,3 +205,102 @@ func TestFactoryHasher(t *testing.T) {
 		t.Fatalf("Invalid size of generated hash, at least 16 bytes is required")
 	}
 }
+
+func TestFactoryAlgorithms(t *testing.T) {
+
+	data := make([]byte, 200000)
+	rand.Read(data)
+	iv := []byte{1, 2, 3}
+
+	if _, err := CreateAlgorithm("unknown"); err != ErrUnknownAlgorithm {
+		t.Fatalf("Invalid error for unknown algorithm: %v", err)
+	}
+
+	decoder := Create()
+	for _, d := range []struct {
+		algorithm, prefix string
+	}{
+		{AlgorithmAES256CFB, cipherAES256Hex},
+		{AlgorithmAES256CTR, cipherAES256CTRHex},
+		{AlgorithmAES256GCM, cipherAES256GCMHex},
+		{AlgorithmChaCha20Poly1305, cipherChaCha20Poly1305Hex},
+	} {
+		f := mustCreate(t, d.algorithm)
+		buff := &bytes.Buffer{}
+		enc, keyStr, err := f.CreateEncryptor(make([]byte, f.GetMinKeySourceBytes()), iv, buff)
+		if err != nil {
+			t.Fatalf("Error creating %v encryptor: %v", d.algorithm, err)
+		}
+		if keyStr[:2] != d.prefix {
+			t.Fatalf("Invalid key type for %v: %v", d.algorithm, keyStr[:2])
+		}
+		enc.Write(data)
+		if closer, ok := enc.(io.Closer); ok {
+			closer.Close()
+		}
+
+		// The algorithm is selected by the key
+		dec, err := decoder.CreateDecryptor(keyStr, iv, buff)
+		if err != nil {
+			t.Fatalf("Error creating %v decryptor: %v", d.algorithm, err)
+		}
+		decrypted, err := ioutil.ReadAll(dec)
+		if err != nil {
+			t.Fatalf("Couldn't decrypt %v data: %v", d.algorithm, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("Invalid data decrypted with %v", d.algorithm)
+		}
+	}
+
+	if len(Algorithms()) < 4 {
+		t.Fatalf("Missing built-in algorithms: %v", Algorithms())
+	}
+	gcm, _ := LookupAlgorithm(AlgorithmAES256GCM)
+	if err := RegisterAlgorithm(gcm); err != ErrAlgorithmAlreadyRegistered {
+		t.Fatalf("Invalid error for duplicated algorithm: %v", err)
+	}
+}
+
+func FuzzCreateDecryptor(f *testing.F) {
+	iv := []byte{1, 2, 3}
+	for _, algorithm := range Algorithms() {
+		factory, err := CreateAlgorithm(algorithm)
+		if err != nil {
+			f.Fatal(err)
+		}
+		buff := &bytes.Buffer{}
+		enc, key, err := factory.CreateEncryptor(make([]byte, factory.GetMinKeySourceBytes()), iv, buff)
+		if err != nil {
+			f.Fatal(err)
+		}
+		enc.Write([]byte("Hello World!"))
+		if closer, ok := enc.(io.Closer); ok {
+			closer.Close()
+		}
+		f.Add(key, iv, buff.Bytes())
+	}
+	f.Add("", []byte(nil), []byte(nil))
+	f.Add("FFABCDABCDABCDABCDABCDABCDABCDABCD", []byte(nil), []byte{0})
+
+	decoder := Create()
+	f.Fuzz(func(t *testing.T, key string, iv, data []byte) {
+		dec, err := decoder.CreateDecryptor(key, iv, bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if _, err = KeyAlgorithm(key); err != nil {
+			t.Fatalf("Decryptor created for the key of unknown algorithm: %v", err)
+		}
+		io.Copy(ioutil.Discard, dec)
+
+		seekable, err := decoder.CreateSeekableDecryptor(key, iv, bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if _, err = seekable.Seek(int64(len(data)/2), io.SeekStart); err != nil {
+			t.Fatalf("Couldn't seek within the data: %v", err)
+		}
+		io.Copy(ioutil.Discard, seekable)
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipherfactory
+
+import (
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"sort"
+)
+
+var (
+	ErrUnknownHashAlgorithm = errors.New("Unknown hash algorithm")
+)
+
+// Names of built-in hash algorithms
+const (
+	HashSHA512 = "SHA-512"
+	HashBLAKE3 = "BLAKE3"
+
+	// Hash algorithm used by default, kept for compatibility with existing blob ids
+	DefaultHashAlgorithm = HashSHA512
+)
+
+// Hash algorithm with its code from the multihash table, the code
+// and the size of the sum tag hashes to make them self-describing
+type hashAlgorithm struct {
+	name string
+	code byte
+	size int
+	new  func() hash.Hash
+}
+
+var hashAlgorithms = []hashAlgorithm{
+	{HashSHA512, 0x13, sha512.Size, sha512.New},
+	{HashBLAKE3, 0x1e, blake3OutLen, newBLAKE3},
+}
+
+func lookupHash(name string) (hashAlgorithm, bool) {
+	for _, a := range hashAlgorithms {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return hashAlgorithm{}, false
+}
+
+// Create the hasher of the named algorithm
+func CreateHasher(algorithm string) (hash.Hash, error) {
+	a, ok := lookupHash(algorithm)
+	if !ok {
+		return nil, ErrUnknownHashAlgorithm
+	}
+	return a.new(), nil
+}
+
+// Get the multihash tag of sums of the named algorithm: the code of
+// the algorithm followed by the size of the sum
+func HashTag(algorithm string) ([]byte, error) {
+	a, ok := lookupHash(algorithm)
+	if !ok {
+		return nil, ErrUnknownHashAlgorithm
+	}
+	return []byte{a.code, byte(a.size)}, nil
+}
+
+// Find the algorithm of the sum starting with the multihash tag, the
+// size of the sum following the tag must match the algorithm
+func LookupHashTag(tagged []byte) (algorithm string, err error) {
+	if len(tagged) < 2 {
+		return "", ErrUnknownHashAlgorithm
+	}
+	for _, a := range hashAlgorithms {
+		if a.code == tagged[0] && a.size == int(tagged[1]) && len(tagged) == 2+a.size {
+			return a.name, nil
+		}
+	}
+	return "", ErrUnknownHashAlgorithm
+}
+
+// Get sorted names of hash algorithms
+func HashAlgorithms() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for _, a := range hashAlgorithms {
+		names = append(names, a.name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,215 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipherfactory
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	ErrInvalidMasterKey    = errors.New("Invalid master key - 32 bytes are required")
+	ErrInvalidKeyEnvelope  = errors.New("Invalid key envelope")
+	ErrInvalidRecipientKey = errors.New("Invalid recipient key - X25519 key is required")
+	ErrKeyUnwrapFailed     = errors.New("Key envelope can't be opened with given key")
+)
+
+// Size of master keys
+const MasterKeySize = 32
+
+const (
+	keyEnvelopeVersion = 0x01
+
+	// Methods of key wrapping
+	keyWrapMasterKey = 0x01 // AES-256-GCM under the master key
+	keyWrapX25519    = 0x02 // AES-256-GCM under the key agreed with an ephemeral X25519 key
+	keySealX25519    = 0x03 // Like keyWrapX25519 but for data other than keys
+
+	// Context of the key derived from the X25519 shared secret
+	keyWrapX25519Info = "cinode key envelope X25519"
+)
+
+// Encrypt the blob key under the master key of MasterKeySize bytes, the
+// envelope is a hex string like keys themselves. The key is authenticated,
+// the envelope can be stored or sent where the plaintext key can't.
+func WrapKey(key string, masterKey []byte) (string, error) {
+	if len(masterKey) != MasterKeySize {
+		return "", ErrInvalidMasterKey
+	}
+	_, keyRaw, err := decodeKey(key)
+	if err != nil {
+		return "", err
+	}
+	envelope, err := sealEnvelope(keyRaw, []byte{keyEnvelopeVersion, keyWrapMasterKey}, masterKey)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(envelope), nil
+}
+
+// Decrypt the blob key wrapped with WrapKey
+func UnwrapKey(envelope string, masterKey []byte) (string, error) {
+	if len(masterKey) != MasterKeySize {
+		return "", ErrInvalidMasterKey
+	}
+	data, err := hex.DecodeString(envelope)
+	if err != nil || checkEnvelope(data, keyWrapMasterKey, 0) != nil {
+		return "", ErrInvalidKeyEnvelope
+	}
+	keyRaw, err := openEnvelope(data, 2, masterKey)
+	if err != nil {
+		return "", err
+	}
+	return checkKey(keyRaw)
+}
+
+// Encrypt the blob key for the owner of the X25519 private key, a new
+// ephemeral key is used for each envelope. Recipient keys are created
+// with ecdh.X25519().GenerateKey.
+func WrapKeyForRecipient(key string, recipient *ecdh.PublicKey) (string, error) {
+	_, keyRaw, err := decodeKey(key)
+	if err != nil {
+		return "", err
+	}
+	envelope, err := sealForRecipient(keyRaw, keyWrapX25519, recipient)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(envelope), nil
+}
+
+// Decrypt the blob key wrapped with WrapKeyForRecipient
+func UnwrapKeyWithPrivateKey(envelope string, privKey *ecdh.PrivateKey) (string, error) {
+	data, err := hex.DecodeString(envelope)
+	if err != nil {
+		return "", ErrInvalidKeyEnvelope
+	}
+	keyRaw, err := openWithPrivateKey(data, keyWrapX25519, privKey)
+	if err != nil {
+		return "", err
+	}
+	return checkKey(keyRaw)
+}
+
+// Encrypt any data for the owner of the X25519 private key the same way
+// blob keys are wrapped with WrapKeyForRecipient
+func SealForRecipient(data []byte, recipient *ecdh.PublicKey) ([]byte, error) {
+	return sealForRecipient(data, keySealX25519, recipient)
+}
+
+// Decrypt the data sealed with SealForRecipient
+func OpenWithPrivateKey(sealed []byte, privKey *ecdh.PrivateKey) ([]byte, error) {
+	return openWithPrivateKey(sealed, keySealX25519, privKey)
+}
+
+func sealForRecipient(payload []byte, method byte, recipient *ecdh.PublicKey) ([]byte, error) {
+	if recipient == nil || recipient.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidRecipientKey
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte{keyEnvelopeVersion, method}, ephemeral.PublicKey().Bytes()...)
+	wrappingKey, err := x25519WrappingKey(ephemeral, recipient, header[2:])
+	if err != nil {
+		return nil, err
+	}
+	return sealEnvelope(payload, header, wrappingKey)
+}
+
+func openWithPrivateKey(data []byte, method byte, privKey *ecdh.PrivateKey) ([]byte, error) {
+	if privKey == nil || privKey.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidRecipientKey
+	}
+	if err := checkEnvelope(data, method, 32); err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(data[2:34])
+	if err != nil {
+		return nil, ErrInvalidKeyEnvelope
+	}
+	wrappingKey, err := x25519WrappingKey(privKey, ephemeral, data[2:34])
+	if err != nil {
+		return nil, err
+	}
+	return openEnvelope(data, 34, wrappingKey)
+}
+
+// Derive the wrapping key from the shared secret, the ephemeral
+// public key binds the derived key to the envelope
+func x25519WrappingKey(privKey *ecdh.PrivateKey, pubKey *ecdh.PublicKey, ephemeral []byte) ([]byte, error) {
+	secret, err := privKey.ECDH(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Key(sha256.New, secret, ephemeral, keyWrapX25519Info, MasterKeySize)
+}
+
+// Create the envelope, the header is authenticated together with the payload.
+// Envelope layout: header, nonce, encrypted payload with the GCM tag.
+func sealEnvelope(payload, header, wrappingKey []byte) ([]byte, error) {
+	aead, err := newKeyWrapAEAD(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	envelope := append(append([]byte(nil), header...), nonce...)
+	return aead.Seal(envelope, nonce, payload, header), nil
+}
+
+// Check the envelope is of given method, there must be at least
+// extraHeader bytes following the method identifier
+func checkEnvelope(data []byte, method byte, extraHeader int) error {
+	if len(data) < 2+extraHeader || data[0] != keyEnvelopeVersion || data[1] != method {
+		return ErrInvalidKeyEnvelope
+	}
+	return nil
+}
+
+// Open the envelope with the header of given size
+func openEnvelope(data []byte, headerSize int, wrappingKey []byte) ([]byte, error) {
+	aead, err := newKeyWrapAEAD(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerSize+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidKeyEnvelope
+	}
+
+	header, nonce := data[:headerSize], data[headerSize:headerSize+aead.NonceSize()]
+	payload, err := aead.Open(nil, nonce, data[headerSize+aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrKeyUnwrapFailed
+	}
+	return payload, nil
+}
+
+// Get the key from its unwrapped raw form
+func checkKey(keyRaw []byte) (string, error) {
+	key := hex.EncodeToString(keyRaw)
+	if _, _, err := decodeKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func newKeyWrapAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
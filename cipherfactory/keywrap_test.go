@@ -0,0 +1,140 @@
+package cipherfactory
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+func genBlobKey(t *testing.T) string {
+	var out bytes.Buffer
+	keySource := make([]byte, cipherAES256KeySourceLength)
+	rand.Read(keySource)
+	_, key, err := Create().CreateEncryptor(keySource, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Flip one bit of the hex-encoded envelope
+func corruptEnvelope(envelope string, pos int) string {
+	data, _ := hex.DecodeString(envelope)
+	data[pos] ^= 0x01
+	return hex.EncodeToString(data)
+}
+
+func TestWrapKey(t *testing.T) {
+
+	key := genBlobKey(t)
+	masterKey := make([]byte, MasterKeySize)
+	rand.Read(masterKey)
+
+	envelope, err := WrapKey(key, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := WrapKey(key, masterKey); other == envelope {
+		t.Fatal("Envelopes of the same key are equal")
+	}
+	unwrapped, err := UnwrapKey(envelope, masterKey)
+	if err != nil || unwrapped != key {
+		t.Fatalf("Invalid unwrapped key: %v %v", unwrapped, err)
+	}
+
+	otherKey := make([]byte, MasterKeySize)
+	for _, d := range []struct {
+		envelope  string
+		masterKey []byte
+		err       error
+	}{
+		{envelope, otherKey, ErrKeyUnwrapFailed},
+		{envelope, masterKey[:16], ErrInvalidMasterKey},
+		{corruptEnvelope(envelope, 10), masterKey, ErrKeyUnwrapFailed},
+		{corruptEnvelope(envelope, 0), masterKey, ErrInvalidKeyEnvelope},
+		{envelope[:40], masterKey, ErrInvalidKeyEnvelope},
+		{"zz", masterKey, ErrInvalidKeyEnvelope},
+	} {
+		if _, err = UnwrapKey(d.envelope, d.masterKey); err != d.err {
+			t.Fatalf("Invalid error: %v, expected %v", err, d.err)
+		}
+	}
+
+	if _, err = WrapKey("05"+key[2:], masterKey); err != ErrUnknownKeyType {
+		t.Fatalf("Invalid error for unknown key type: %v", err)
+	}
+	if _, err = WrapKey(key, nil); err != ErrInvalidMasterKey {
+		t.Fatalf("Invalid error for missing master key: %v", err)
+	}
+}
+
+func TestWrapKeyForRecipient(t *testing.T) {
+
+	key := genBlobKey(t)
+	privKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := WrapKeyForRecipient(key, privKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := UnwrapKeyWithPrivateKey(envelope, privKey)
+	if err != nil || unwrapped != key {
+		t.Fatalf("Invalid unwrapped key: %v %v", unwrapped, err)
+	}
+
+	otherKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	if _, err = UnwrapKeyWithPrivateKey(envelope, otherKey); err != ErrKeyUnwrapFailed {
+		t.Fatalf("Envelope opened with other key: %v", err)
+	}
+	if _, err = UnwrapKeyWithPrivateKey(corruptEnvelope(envelope, 5), privKey); err != ErrKeyUnwrapFailed {
+		t.Fatalf("Envelope with replaced ephemeral key opened: %v", err)
+	}
+
+	// Envelopes of one method can't be opened with the other one
+	masterKey := make([]byte, MasterKeySize)
+	if _, err = UnwrapKey(envelope, masterKey); err != ErrInvalidKeyEnvelope {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	wrapped, _ := WrapKey(key, masterKey)
+	if _, err = UnwrapKeyWithPrivateKey(wrapped, privKey); err != ErrInvalidKeyEnvelope {
+		t.Fatalf("Invalid error: %v", err)
+	}
+
+	p256, _ := ecdh.P256().GenerateKey(rand.Reader)
+	if _, err = WrapKeyForRecipient(key, p256.PublicKey()); err != ErrInvalidRecipientKey {
+		t.Fatalf("Invalid error for P-256 key: %v", err)
+	}
+}
+
+func TestSealForRecipient(t *testing.T) {
+
+	privKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	sealed, err := SealForRecipient([]byte("Hello World!"), privKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := OpenWithPrivateKey(sealed, privKey)
+	if err != nil || string(data) != "Hello World!" {
+		t.Fatalf("Invalid opened data: %q %v", data, err)
+	}
+
+	otherKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	if _, err = OpenWithPrivateKey(sealed, otherKey); err != ErrKeyUnwrapFailed {
+		t.Fatalf("Data opened with other key: %v", err)
+	}
+
+	// Sealed data is never taken for a wrapped key and the other way round
+	if _, err = UnwrapKeyWithPrivateKey(hex.EncodeToString(sealed), privKey); err != ErrInvalidKeyEnvelope {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	envelope, _ := WrapKeyForRecipient(genBlobKey(t), privKey.PublicKey())
+	raw, _ := hex.DecodeString(envelope)
+	if _, err = OpenWithPrivateKey(raw, privKey); err != ErrInvalidKeyEnvelope {
+		t.Fatalf("Invalid error: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipherfactory
+
+import (
+	"crypto/cipher"
+	"io"
+	"sync"
+)
+
+const (
+	// Size of buffers used to decrypt data copied with WriteTo
+	streamBufferSize = 32 * 1024
+)
+
+// Buffers of decryptors shared between WriteTo calls so that copying
+// many blobs does not allocate a new buffer for each one
+var streamBuffers = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, streamBufferSize)
+		return &buffer
+	},
+}
+
+// streamDecryptor decrypts data with the stream cipher, data is decrypted
+// in place in the buffer of the caller or in the pooled buffer if copied
+// with WriteTo
+type streamDecryptor struct {
+	stream cipher.Stream // Key stream for the current position
+	input  io.Reader     // Source of encrypted data
+}
+
+func (s *streamDecryptor) Read(p []byte) (n int, err error) {
+	n, err = s.input.Read(p)
+	s.stream.XORKeyStream(p[:n], p[:n])
+	return
+}
+
+// WriteTo decrypts the rest of the data into the writer, io.Copy uses it
+// instead of allocating its own buffer
+func (s *streamDecryptor) WriteTo(w io.Writer) (written int64, err error) {
+	buffer := streamBuffers.Get().(*[]byte)
+	defer streamBuffers.Put(buffer)
+
+	for {
+		n, rerr := s.input.Read(*buffer)
+		if n > 0 {
+			s.stream.XORKeyStream((*buffer)[:n], (*buffer)[:n])
+			nw, werr := w.Write((*buffer)[:n])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if nw != n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package cipherfactory
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+var allAlgorithms = []string{AlgorithmAES256CFB, AlgorithmAES256CTR, AlgorithmAES256GCM}
+
+func encryptWith(tb testing.TB, algorithm string, data []byte) (Factory, string, []byte) {
+	f, err := CreateAlgorithm(algorithm)
+	if err != nil {
+		tb.Fatalf("Couldn't create factory for %v: %v", algorithm, err)
+	}
+	buff := &bytes.Buffer{}
+	enc, key, err := f.CreateEncryptor(make([]byte, f.GetMinKeySourceBytes()), []byte{1, 2, 3}, buff)
+	if err != nil {
+		tb.Fatalf("Error creating %v encryptor: %v", algorithm, err)
+	}
+	enc.Write(data)
+	if closer, ok := enc.(io.Closer); ok {
+		closer.Close()
+	}
+	return f, key, buff.Bytes()
+}
+
+// Writer failing after accepting given number of bytes
+type failingWriter struct {
+	left int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > f.left {
+		n := f.left
+		f.left = 0
+		return n, errWriteFailed
+	}
+	f.left -= len(p)
+	return len(p), nil
+}
+
+func TestDecryptorWriteTo(t *testing.T) {
+
+	data := make([]byte, 200000)
+	rand.Read(data)
+
+	for _, algorithm := range allAlgorithms {
+		f, key, encrypted := encryptWith(t, algorithm, data)
+
+		// Part of the data is read first, the rest is written at once
+		dec, err := f.CreateDecryptor(key, []byte{1, 2, 3}, bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		head := make([]byte, 1000)
+		if _, err = io.ReadFull(dec, head); err != nil {
+			t.Fatalf("Couldn't read %v data: %v", algorithm, err)
+		}
+		if _, ok := dec.(io.WriterTo); !ok {
+			t.Fatalf("Decryptor of %v does not implement io.WriterTo", algorithm)
+		}
+		buff := &bytes.Buffer{}
+		n, err := io.Copy(buff, dec)
+		if err != nil || n != int64(len(data)-1000) {
+			t.Fatalf("Couldn't copy %v data: %v %v", algorithm, n, err)
+		}
+		if !bytes.Equal(append(head, buff.Bytes()...), data) {
+			t.Fatalf("Invalid data decrypted with %v", algorithm)
+		}
+
+		// Errors of the writer are reported with the number of bytes written
+		dec, _ = f.CreateDecryptor(key, []byte{1, 2, 3}, bytes.NewReader(encrypted))
+		n, err = io.Copy(&failingWriter{left: 100000}, dec)
+		if err != errWriteFailed || n != 100000 {
+			t.Fatalf("Invalid result of the failed write with %v: %v %v", algorithm, n, err)
+		}
+	}
+
+	// Authentication errors are not hidden
+	f, key, encrypted := encryptWith(t, AlgorithmAES256GCM, data)
+	encrypted[len(encrypted)-1] ^= 1
+	dec, _ := f.CreateDecryptor(key, []byte{1, 2, 3}, bytes.NewReader(encrypted))
+	if _, err := io.Copy(ioutil.Discard, dec); err != ErrChunkAuthenticationFailed {
+		t.Fatalf("Invalid error of tampered data: %v", err)
+	}
+}
+
+func benchmarkDecrypt(b *testing.B, algorithm string, writerTo bool) {
+	data := make([]byte, 4*1024*1024)
+	rand.Read(data)
+	f, key, encrypted := encryptWith(b, algorithm, data)
+	b.SetBytes(int64(len(data)))
+
+	// Hide io.ReaderFrom of the destination, it would provide its own buffer
+	output := struct{ io.Writer }{ioutil.Discard}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		dec, err := f.CreateDecryptor(key, []byte{1, 2, 3}, bytes.NewReader(encrypted))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !writerTo {
+			// Hide WriteTo so that io.Copy goes through Read
+			dec = struct{ io.Reader }{dec}
+		}
+		if _, err = io.Copy(output, dec); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecryptCFBRead(b *testing.B)    { benchmarkDecrypt(b, AlgorithmAES256CFB, false) }
+func BenchmarkDecryptCFBWriteTo(b *testing.B) { benchmarkDecrypt(b, AlgorithmAES256CFB, true) }
+func BenchmarkDecryptCTRRead(b *testing.B)    { benchmarkDecrypt(b, AlgorithmAES256CTR, false) }
+func BenchmarkDecryptCTRWriteTo(b *testing.B) { benchmarkDecrypt(b, AlgorithmAES256CTR, true) }
+func BenchmarkDecryptGCMRead(b *testing.B)    { benchmarkDecrypt(b, AlgorithmAES256GCM, false) }
+func BenchmarkDecryptGCMWriteTo(b *testing.B) { benchmarkDecrypt(b, AlgorithmAES256GCM, true) }
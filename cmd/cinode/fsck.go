@@ -0,0 +1,83 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"os"
+)
+
+func init() {
+	commands["fsck"] = command{
+		usage: "fsck [-repair] [-quarantine <path>] -store <path>",
+		run:   fsck,
+	}
+}
+
+func fsck(args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	store := flags.String("store", "", "path of the blob storage")
+	repair := flags.Bool("repair", false, "delete corrupted blobs")
+	quarantine := flags.String("quarantine", "", "move corrupted blobs to this storage instead of deleting them, requires -repair")
+	flags.Parse(args)
+
+	if *store == "" || flags.NArg() != 0 {
+		return errors.New("storage path is required")
+	}
+
+	options := blobstore.FsckOptions{}
+	switch {
+	case *quarantine != "" && !*repair:
+		return errors.New("quarantine requires repair")
+	case *quarantine != "":
+		options.Repair = blobstore.RepairQuarantine
+		options.Quarantine = blobstore.NewFileBlobStorage(*quarantine)
+	case *repair:
+		options.Repair = blobstore.RepairDelete
+	}
+
+	return checkStorage(os.Stdout, blobstore.NewFileBlobStorage(*store), options)
+}
+
+func checkStorage(w io.Writer, storage blobstore.BlobStorage, options blobstore.FsckOptions) error {
+
+	capabilities := blobstore.StorageCapabilities(storage)
+	if !capabilities.Has(blobstore.CapabilityList) {
+		return errors.New("storage can't be listed")
+	}
+	if options.Repair != blobstore.RepairNone && !capabilities.Has(blobstore.CapabilityDelete) {
+		return errors.New("storage does not support deleting blobs, can't repair it")
+	}
+
+	report, err := blobstore.Fsck(storage, options)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range report.Corrupted {
+		state := "corrupted"
+		if blob.Truncated {
+			state = "truncated"
+		}
+		if blob.Repaired {
+			state += ", repaired"
+		}
+		fmt.Fprintf(w, "%s: %s: %v\n", blob.Bid, state, blob.Err)
+	}
+	for _, blob := range report.Unreadable {
+		fmt.Fprintf(w, "%s: unreadable: %v\n", blob.Bid, blob.Err)
+	}
+	fmt.Fprintf(w, "checked %d blobs, %d bytes, %d corrupted, %d unreadable\n",
+		report.Checked, report.CheckedBytes, len(report.Corrupted), len(report.Unreadable))
+
+	if !report.Clean() {
+		return errors.New("corrupted or unreadable blobs found")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCheckStorage(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err = checkStorage(&out, storage, blobstore.FsckOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "checked 1 blobs") {
+		t.Fatalf("Invalid output:\n%s", out.String())
+	}
+
+	// Truncate the blob
+	reader, _ := storage.NewBlobReader(ref.Bid)
+	data, _ := ioutil.ReadAll(reader)
+	storage.Delete(ref.Bid)
+	w, _ := storage.NewBlobWriter(ref.Bid)
+	w.Write(data[:len(data)/2])
+	w.Finalize()
+
+	out.Reset()
+	if err = checkStorage(&out, storage, blobstore.FsckOptions{}); err == nil {
+		t.Fatal("Corrupted blob not reported")
+	}
+	if !strings.Contains(out.String(), ref.Bid+": corrupted") {
+		t.Fatalf("Invalid output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err = checkStorage(&out, storage, blobstore.FsckOptions{Repair: blobstore.RepairDelete}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "repaired") {
+		t.Fatalf("Invalid output:\n%s", out.String())
+	}
+}
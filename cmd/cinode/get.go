@@ -0,0 +1,104 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"os"
+)
+
+func init() {
+	commands["get"] = command{
+		usage: "get -store <store> <bid> <key> <dest>",
+		run:   get,
+	}
+}
+
+func get(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	store := flags.String("store", "", "blob storage: path, server URL or JSON specification")
+	flags.Parse(args)
+
+	if *store == "" || flags.NArg() != 3 {
+		return errors.New("storage, blob id, key and destination are required")
+	}
+	storage, err := openStore(*store)
+	if err != nil {
+		return err
+	}
+
+	return getBlob(os.Stdout, storage, flags.Arg(0), flags.Arg(1), flags.Arg(2))
+}
+
+// Write the file or directory blob to the destination path, the content
+// of files goes to the writer if the destination is "-". Blob ids and
+// keys are checked first thus swapped arguments are reported as such.
+// Links are followed to the blob they point to.
+func getBlob(w io.Writer, storage blobstore.BlobStorage, bidArg, keyArg, dest string) error {
+	bid, err := blobstore.NewBID(bidArg)
+	if err != nil {
+		return fmt.Errorf("blob id %q: %v", bidArg, err)
+	}
+	key, err := blobstore.NewKeyInfo(keyArg)
+	if err != nil {
+		return fmt.Errorf("key %q: %v", keyArg, err)
+	}
+
+	info, err := blobstore.Inspect(bid, key, storage)
+	if err != nil {
+		return err
+	}
+	if info.IsLink() {
+		target, _, err := blobstore.ResolveLinks(blobstore.BlobReference{Bid: bid.String(), Key: key.String()}, storage)
+		if err != nil {
+			return err
+		}
+		if bid, err = blobstore.NewBID(target.Bid); err != nil {
+			return err
+		}
+		if key, err = blobstore.NewKeyInfo(target.Key); err != nil {
+			return err
+		}
+		if info, err = blobstore.Inspect(bid, key, storage); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case info.IsDir() && dest == "-":
+		return errors.New("directory can't be written to the standard output")
+	case info.IsDir():
+		return blobstore.MaterializeDirectory(bid.String(), key.String(), storage, dest, nil)
+	case !info.IsFile():
+		return blobstore.ErrInvalidFileBlobType
+	}
+
+	reader, err := blobstore.OpenFile(bid, key, storage)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if dest == "-" {
+		_, err = io.Copy(w, reader)
+		return err
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"os"
+)
+
+func init() {
+	commands["inspect"] = command{
+		usage: "inspect [-debug] [-key <key>] -store <path> <bid>",
+		run:   inspect,
+	}
+}
+
+func inspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	store := flags.String("store", "", "path of the blob storage")
+	key := flags.String("key", "", "key of the blob")
+	debug := flags.Bool("debug", false, "decode the whole blob and report each field")
+	flags.Parse(args)
+
+	if *store == "" || flags.NArg() != 1 {
+		return errors.New("storage path and a single blob id are required")
+	}
+
+	bid, err := blobstore.NewBID(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("blob id %q: %v", flags.Arg(0), err)
+	}
+	if *key != "" {
+		blobKey, err := blobstore.NewKeyInfo(*key)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", *key, err)
+		}
+		*key = blobKey.String()
+	}
+
+	return inspectBlob(os.Stdout, blobstore.NewFileBlobStorage(*store), bid.String(), *key, *debug)
+}
+
+func inspectBlob(w io.Writer, storage blobstore.BlobStorage, bid, key string, debug bool) error {
+
+	var info *blobstore.BlobInfo
+	var err error
+	if key == "" {
+		info, err = blobstore.InspectBlob(bid, storage)
+	} else {
+		info, err = blobstore.InspectBlobWithKey(bid, key, storage)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "validation method: %d\n", info.ValidationMethod)
+	printKnown(w, "blob type", info.BlobType)
+	if format, ok := info.Format(); ok {
+		fmt.Fprintf(w, "format: %s v%d\n", format.Name, format.Version)
+	}
+	printKnown(w, "version", info.Version)
+	printKnown(w, "public key size", int64(info.PublicKeySize))
+	printKnown(w, "signature size", int64(info.SignatureSize))
+	printKnown(w, "file size", info.FileSize)
+	printKnown(w, "parts count", info.PartsCount)
+	printKnown(w, "entries count", info.EntriesCount)
+
+	if !debug {
+		return nil
+	}
+	if key == "" {
+		return errors.New("key is required to decode the blob")
+	}
+
+	fmt.Fprintln(w, "")
+	fields, err := blobstore.StrictDecodeBlob(bid, key, storage)
+	for _, field := range fields {
+		fmt.Fprintf(w, "%08x %s: %s\n", field.Offset, field.Field, field.Value)
+	}
+	if formatErr, ok := err.(*blobstore.FormatError); ok {
+		fmt.Fprintf(w, "%08x %s: ERROR\n", formatErr.Offset, formatErr.Field)
+		fmt.Fprintf(w, "  expected: %s\n", formatErr.Expected)
+		fmt.Fprintf(w, "  found:    %s\n", formatErr.Found)
+	}
+	return err
+}
+
+func printKnown(w io.Writer, name string, value int64) {
+	if value != blobstore.BlobInfoUnknown {
+		fmt.Fprintf(w, "%s: %d\n", name, value)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"strings"
+	"testing"
+)
+
+func TestInspectDebug(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	dw := blobstore.DirBlobWriter{Storage: storage}
+	dw.AddEntry(blobstore.DirEntry{Name: "a.txt", MimeType: "text/plain", Bid: "bid", Key: "key"})
+	ref, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+
+	var out bytes.Buffer
+	if err = inspectBlob(&out, storage, bid, key, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"validation method: 1\n",
+		"format: simple static directory v1\n",
+		"entries count: 1\n",
+		"00000003 entry[0].name: \"a.txt\"\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Missing line %q in the output:\n%s", line, out.String())
+		}
+	}
+
+	if err = inspectBlob(&out, storage, bid, "", true); err == nil {
+		t.Error("Decoded the blob without the key")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"os"
+	"sort"
+)
+
+func init() {
+	commands["ls"] = command{
+		usage: "ls [-R] [-stream] -store <store> <bid> <key>",
+		run:   ls,
+	}
+}
+
+func ls(args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	store := flags.String("store", "", "blob storage: path, server URL or JSON specification")
+	key := flags.String("key", "", "key of the directory blob, may be given after the blob id instead")
+	recursive := flags.Bool("R", false, "list subdirectories recursively")
+	stream := flags.Bool("stream", false, "print entries as they are read, depth-first, instead of sorting the whole listing")
+	flags.Parse(args)
+
+	if *key == "" && flags.NArg() == 2 {
+		*key = flags.Arg(1)
+	} else if flags.NArg() != 1 {
+		return errors.New("a single blob id is required")
+	}
+	if *store == "" || *key == "" {
+		return errors.New("storage and key are required")
+	}
+	bid, err := blobstore.NewBID(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("blob id %q: %v", flags.Arg(0), err)
+	}
+	dirKey, err := blobstore.NewKeyInfo(*key)
+	if err != nil {
+		return fmt.Errorf("key %q: %v", *key, err)
+	}
+	storage, err := openStore(*store)
+	if err != nil {
+		return err
+	}
+
+	return listTree(os.Stdout, storage, bid.String(), dirKey.String(), *recursive, *stream)
+}
+
+// List the directory blob, directories are suffixed with a slash and
+// symbolic links are followed by their targets. Unless
+// streaming, the whole listing is read first and sorted by paths.
+func listTree(w io.Writer, storage blobstore.BlobStorage, bid, key string, recursive, stream bool) error {
+
+	var lines []string
+	err := blobstore.WalkTree(bid, key, storage, func(entry blobstore.TreeEntry) error {
+		line := entry.Path
+		switch {
+		case entry.Type == blobstore.EntryTypeSymlink:
+			line += " -> " + entry.Target
+		case entry.Info != nil && entry.Info.IsDir():
+			line += "/"
+		}
+		if stream {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		} else {
+			lines = append(lines, line)
+		}
+		if !recursive {
+			return blobstore.ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
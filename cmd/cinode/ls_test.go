@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"testing"
+)
+
+func TestListTree(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello"))
+	file, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := blobstore.DirBlobWriter{Storage: storage}
+	sub.AddEntry(blobstore.DirEntry{Name: "x", Bid: file.Bid, Key: file.Key})
+	subRef, err := sub.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := blobstore.DirBlobWriter{Storage: storage}
+	root.AddEntry(blobstore.DirEntry{Name: "a", Bid: subRef.Bid, Key: subRef.Key})
+	root.AddEntry(blobstore.DirEntry{Name: "a-b", Bid: file.Bid, Key: file.Key})
+	rootRef, err := root.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		recursive, stream bool
+		expected          string
+	}{
+		{false, false, "a-b\na/\n"},
+		{false, true, "a/\na-b\n"},
+		{true, false, "a-b\na/\na/x\n"},
+		{true, true, "a/\na/x\na-b\n"},
+	} {
+		var out bytes.Buffer
+		if err = listTree(&out, storage, rootRef.Bid, rootRef.Key, c.recursive, c.stream); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.expected {
+			t.Errorf("Invalid listing (recursive: %v, stream: %v):\n%s", c.recursive, c.stream, out.String())
+		}
+	}
+}
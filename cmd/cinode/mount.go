@@ -0,0 +1,71 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build fuse && (darwin || freebsd || linux)
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/cinodefs"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func init() {
+	commands["mount"] = command{
+		usage: "mount -store <store> [-attr-ttl <duration>] <bid> <key> <mountpoint>",
+		run:   mount,
+	}
+}
+
+// Mount the directory blob read-only until the filesystem is unmounted
+// or the command is interrupted
+func mount(args []string) error {
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	store := flags.String("store", "", "blob storage: path, server URL or JSON specification")
+	attrTTL := flags.Duration("attr-ttl", time.Minute, "time after which attributes of signed blobs are read again")
+	flags.Parse(args)
+
+	if *store == "" || flags.NArg() != 3 {
+		return errors.New("storage, blob id, key and mount point are required")
+	}
+	storage, err := openStore(*store)
+	if err != nil {
+		return err
+	}
+
+	bid, err := blobstore.NewBID(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("blob id %q: %v", flags.Arg(0), err)
+	}
+	key, err := blobstore.NewKeyInfo(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("key %q: %v", flags.Arg(1), err)
+	}
+	info, err := blobstore.Inspect(bid, key, storage)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("only directories can be mounted")
+	}
+
+	root := blobstore.BlobReference{Bid: bid.String(), Key: key.String()}
+	fsys := cinodefs.New(root, storage, cinodefs.Options{AttrTTL: *attrTTL})
+
+	mountpoint := flags.Arg(2)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cinodefs.Unmount(mountpoint)
+	}()
+	return cinodefs.Mount(fsys, mountpoint)
+}
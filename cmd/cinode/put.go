@@ -0,0 +1,74 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"os"
+)
+
+func init() {
+	commands["put"] = command{
+		usage: "put [-compress] [-metadata] [-reproducible] [-encoding <encoding>] -store <store> <path>",
+		run:   put,
+	}
+}
+
+func put(args []string) error {
+	flags := flag.NewFlagSet("put", flag.ExitOnError)
+	store := flags.String("store", "", "blob storage: path, server URL or JSON specification")
+	metadata := flags.Bool("metadata", false, "record permissions and modification times")
+	reproducible := flags.Bool("reproducible", false, "store the content the same way on every machine")
+	compress := flags.Bool("compress", false, "compress files before encryption, ignored for reproducible uploads")
+	encodingName := flags.String("encoding", "hex", "encoding of the printed blob id and key: hex, base32 or base58")
+	flags.Parse(args)
+
+	if *store == "" || flags.NArg() != 1 {
+		return errors.New("storage and a single path are required")
+	}
+	encoding, err := blobstore.ParseEncoding(*encodingName)
+	if err != nil {
+		return err
+	}
+	storage, err := openStore(*store)
+	if err != nil {
+		return err
+	}
+
+	options := blobstore.UploadOptions{Metadata: *metadata, Reproducible: *reproducible, Compress: *compress}
+	return putPath(os.Stdout, storage, flags.Arg(0), options, encoding)
+}
+
+// Store the local file or directory, bid and key are printed
+// in given encoding separated with a space
+func putPath(w io.Writer, storage blobstore.BlobStorage, path string, options blobstore.UploadOptions, encoding blobstore.Encoding) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	var bid, key string
+	if info.IsDir() {
+		bid, key, err = blobstore.UploadDirectoryWithOptions(path, storage, options)
+	} else {
+		bid, key, err = blobstore.UploadFileWithOptions(path, storage, options)
+	}
+	if err != nil {
+		return err
+	}
+	if bid, err = blobstore.FormatBID(bid, encoding); err != nil {
+		return err
+	}
+	if key, err = blobstore.FormatKey(key, encoding); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s %s\n", bid, key)
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPutAndGet(t *testing.T) {
+
+	src, err := ioutil.TempDir("", "cinode-put")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	os.MkdirAll(filepath.Join(src, "sub"), 0777)
+	ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("Hello"), 0666)
+	ioutil.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("World"), 0666)
+
+	storage := blobstore.NewMemoryBlobStorage()
+	var out bytes.Buffer
+	if err = putPath(&out, storage, src, blobstore.UploadOptions{}, blobstore.EncodingHex); err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(out.String())
+	if len(fields) != 2 {
+		t.Fatalf("Invalid output of put: %q", out.String())
+	}
+
+	dest := filepath.Join(src, "restored")
+	if err = getBlob(&out, storage, fields[0], fields[1], dest); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dest, "sub", "b.txt")); string(data) != "World" {
+		t.Fatalf("Invalid restored file: %q", data)
+	}
+	if err = getBlob(&out, storage, fields[0], fields[1], "-"); err == nil {
+		t.Fatal("Directory written to the standard output")
+	}
+
+	out.Reset()
+	if err = putPath(&out, storage, filepath.Join(src, "a.txt"), blobstore.UploadOptions{}, blobstore.EncodingHex); err != nil {
+		t.Fatal(err)
+	}
+	fields = strings.Fields(out.String())
+
+	out.Reset()
+	if err = getBlob(&out, storage, fields[0], fields[1], "-"); err != nil || out.String() != "Hello" {
+		t.Fatalf("Invalid file content written to the output: %q, %v", out.String(), err)
+	}
+	if err = getBlob(&out, storage, fields[1], fields[0], "-"); err == nil {
+		t.Fatal("Swapped blob id and key accepted")
+	}
+	if err = getBlob(&out, storage, fields[0], fields[1], filepath.Join(src, "copy.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(src, "copy.txt")); string(data) != "Hello" {
+		t.Fatalf("Invalid file written to the destination: %q", data)
+	}
+
+	// Encoded references are accepted back
+	out.Reset()
+	if err = putPath(&out, storage, filepath.Join(src, "a.txt"), blobstore.UploadOptions{}, blobstore.EncodingBase58); err != nil {
+		t.Fatal(err)
+	}
+	if fields = strings.Fields(out.String()); len(fields) != 2 || fields[0][0] != 'z' || fields[1][0] != 'z' {
+		t.Fatalf("Invalid output of put with base58 encoding: %q", out.String())
+	}
+	out.Reset()
+	if err = getBlob(&out, storage, fields[0], fields[1], "-"); err != nil || out.String() != "Hello" {
+		t.Fatalf("Invalid file content read with encoded references: %q, %v", out.String(), err)
+	}
+
+	// Links are followed
+	bid, _ := blobstore.NewBID(fields[0])
+	key, _ := blobstore.NewKeyInfo(fields[1])
+	link, err := blobstore.CreateLinkBlob(blobstore.Link{Target: blobstore.BlobReference{Bid: bid.String(), Key: key.String()}}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err = getBlob(&out, storage, link.Bid, link.Key, "-"); err != nil || out.String() != "Hello" {
+		t.Fatalf("Invalid file content read through the link: %q, %v", out.String(), err)
+	}
+
+	if err = putPath(&out, storage, filepath.Join(src, "missing"), blobstore.UploadOptions{}, blobstore.EncodingHex); !os.IsNotExist(err) {
+		t.Fatalf("Invalid error for missing path: %v", err)
+	}
+}
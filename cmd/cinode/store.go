@@ -0,0 +1,26 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/config"
+	"github.com/cinode/golib/httpstore"
+	"strings"
+)
+
+// Open the storage given on the command line: URL of the blob server,
+// JSON storage specification as used in configuration files or path
+// of the local file storage
+func openStore(spec string) (blobstore.BlobStorage, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return httpstore.NewHTTPBlobStorage(spec, nil), nil
+	case strings.HasPrefix(spec, "{"):
+		return config.Build(json.RawMessage(spec))
+	}
+	return blobstore.NewFileBlobStorage(spec), nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	cinodesync "github.com/cinode/golib/sync"
+	"io"
+	"os"
+)
+
+func init() {
+	commands["sync"] = command{
+		usage: "sync [-parallel <n>] [-checkpoint <bid>] <src-store> <dst-store>",
+		run:   syncStores,
+	}
+}
+
+func syncStores(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	parallel := flags.Int("parallel", 4, "number of blobs copied at once")
+	checkpoint := flags.String("checkpoint", "", "resume the interrupted synchronization from the checkpoint")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return errors.New("source and destination storages are required")
+	}
+	src, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, err := openStore(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	return copyBlobs(os.Stdout, src, dst, cinodesync.Options{Parallelism: *parallel, Checkpoint: *checkpoint})
+}
+
+// Copy blobs missing in the destination and print the summary, the
+// checkpoint to resume from is printed if the synchronization fails
+func copyBlobs(w io.Writer, src, dst blobstore.BlobStorage, options cinodesync.Options) error {
+	if !blobstore.StorageCapabilities(src).Has(blobstore.CapabilityList) {
+		return errors.New("source storage can't be listed")
+	}
+	if !blobstore.StorageCapabilities(dst).Has(blobstore.CapabilityWrite) {
+		return errors.New("destination storage is read-only")
+	}
+
+	progress, err := cinodesync.Sync(src, dst, options)
+	if err != nil {
+		if progress.Checkpoint != "" {
+			fmt.Fprintf(w, "interrupted, resume with -checkpoint %s\n", progress.Checkpoint)
+		}
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "checked %d blobs, copied %d (%d bytes), skipped %d\n",
+		progress.Checked, progress.Copied, progress.BytesCopied, progress.Skipped)
+	return err
+}
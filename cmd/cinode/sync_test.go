@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	cinodesync "github.com/cinode/golib/sync"
+	"strings"
+	"testing"
+)
+
+func TestCopyBlobs(t *testing.T) {
+
+	src := blobstore.NewMemoryBlobStorage()
+	dst := blobstore.NewMemoryBlobStorage()
+	fw := blobstore.FileBlobWriter{Storage: src}
+	fw.Write([]byte("Hello World!"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err = copyBlobs(&out, src, dst, cinodesync.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "checked 1 blobs, copied 1 ") {
+		t.Fatalf("Invalid summary: %q", out.String())
+	}
+	if exists, _ := dst.Exists(ref.Bid); !exists {
+		t.Fatal("Blob not copied")
+	}
+
+	out.Reset()
+	if err = copyBlobs(&out, src, dst, cinodesync.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "skipped 1\n") {
+		t.Fatalf("Invalid summary of repeated synchronization: %q", out.String())
+	}
+
+	if err = copyBlobs(&out, src, blobstore.NewReadOnlyStorage(dst), cinodesync.Options{}); err == nil {
+		t.Fatal("Blobs copied to read-only storage")
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+
+	storage, err := openStore(`{"type": "memory"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storage.(blobstore.Lister); !ok {
+		t.Fatalf("Invalid storage built from the specification: %T", storage)
+	}
+	if _, err = openStore(`{"type": "unknown"}`); err == nil {
+		t.Fatal("Built storage of unknown type")
+	}
+}
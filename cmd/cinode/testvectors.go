@@ -0,0 +1,46 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/cinode/golib/testvectors"
+	"os"
+)
+
+func init() {
+	commands["testvectors"] = command{
+		usage: "testvectors [-o <file>]",
+		run:   genTestVectors,
+	}
+}
+
+func genTestVectors(args []string) error {
+	flags := flag.NewFlagSet("testvectors", flag.ExitOnError)
+	output := flags.String("o", "-", "output file, - for the standard output")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return errors.New("unexpected arguments")
+	}
+	vectors, err := testvectors.Generate()
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		return testvectors.WriteJSON(os.Stdout, vectors)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = testvectors.WriteJSON(file, vectors)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,200 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/httpstore"
+	"github.com/cinode/golib/kvstore"
+	"time"
+)
+
+func buildMemory(spec *Spec) (blobstore.BlobStorage, error) {
+	var params struct {
+		Interning bool `json:"interning"`
+	}
+	if err := spec.Decode(&params); err != nil {
+		return nil, err
+	}
+
+	if params.Interning {
+		return blobstore.NewInterningMemoryBlobStorage(), nil
+	}
+	return blobstore.NewMemoryBlobStorage(), nil
+}
+
+func buildFile(spec *Spec) (blobstore.BlobStorage, error) {
+	var params struct {
+		Path         string `json:"path"`
+		MemoryMapped bool   `json:"mmap"`
+	}
+	if err := spec.Decode(&params); err != nil {
+		return nil, err
+	}
+	if params.Path == "" {
+		return nil, ErrMissingParameter
+	}
+
+	return blobstore.NewFileBlobStorageWithOptions(params.Path,
+		blobstore.FileBlobStorageOptions{MemoryMapped: params.MemoryMapped}), nil
+}
+
+func buildKeyValue(spec *Spec) (blobstore.BlobStorage, error) {
+	var params struct {
+		Path       string `json:"path"`
+		SyncWrites bool   `json:"syncWrites"`
+	}
+	if err := spec.Decode(&params); err != nil {
+		return nil, err
+	}
+	if params.Path == "" {
+		return nil, ErrMissingParameter
+	}
+
+	kv, err := kvstore.Open(params.Path, kvstore.Options{SyncWrites: params.SyncWrites})
+	if err != nil {
+		return nil, err
+	}
+	return blobstore.NewKeyValueBlobStorage(kv), nil
+}
+
+func buildTracker(spec *Spec) (blobstore.BlobStorage, error) {
+	var params struct {
+		Backend json.RawMessage `json:"backend"`
+	}
+	if err := spec.Decode(&params); err != nil {
+		return nil, err
+	}
+
+	backend, err := Build(params.Backend)
+	if err != nil {
+		return nil, err
+	}
+	return blobstore.NewAccessTracker(backend), nil
+}
+
+func buildLayered(spec *Spec) (blobstore.BlobStorage, error) {
+	var params struct {
+		Cache         json.RawMessage `json:"cache"`
+		Remote        json.RawMessage `json:"remote"`
+		MaxCacheBytes int64           `json:"maxCacheBytes"`
+		ReadRepair    bool            `json:"readRepair"`
+		NegativeTTL   string          `json:"negativeTTL"`
+	}
+	if err := spec.Decode(&params); err != nil {
+		return nil, err
+	}
+	if params.MaxCacheBytes <= 0 {
+		return nil, ErrMissingParameter
+	}
+	var negativeTTL time.Duration
+	if params.NegativeTTL != "" {
+		var err error
+		if negativeTTL, err = time.ParseDuration(params.NegativeTTL); err != nil {
+			return nil, fmt.Errorf("Invalid negativeTTL of layered storage: %v", err)
+		}
+	}
+
+	cache, err := Build(params.Cache)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := Build(params.Remote)
+	if err != nil {
+		return nil, err
+	}
+	layered := blobstore.NewLayeredBlobStorage(cache, remote, params.MaxCacheBytes)
+	layered.ReadRepair = params.ReadRepair
+	layered.NegativeTTL = negativeTTL
+	return layered, nil
+}
+
+func buildReplicated(spec *Spec) (blobstore.BlobStorage, error) {
+	var params struct {
+		Replicas   []json.RawMessage `json:"replicas"`
+		ReadRepair bool              `json:"readRepair"`
+	}
+	if err := spec.Decode(&params); err != nil {
+		return nil, err
+	}
+	if len(params.Replicas) == 0 {
+		return nil, ErrMissingParameter
+	}
+
+	var replicas []blobstore.BlobStorage
+	for _, r := range params.Replicas {
+		replica, err := Build(r)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+	replicated := blobstore.NewReplicatedBlobStorage(replicas...)
+	replicated.ReadRepair = params.ReadRepair
+	return replicated, nil
+}
+
+func buildHTTP(spec *Spec) (blobstore.BlobStorage, error) {
+	var params struct {
+		URL string `json:"url"`
+	}
+	if err := spec.Decode(&params); err != nil {
+		return nil, err
+	}
+	if params.URL == "" {
+		return nil, ErrMissingParameter
+	}
+
+	return httpstore.NewHTTPBlobStorage(params.URL, nil), nil
+}
+
+func buildRetry(spec *Spec) (blobstore.BlobStorage, error) {
+	var params struct {
+		Backend      json.RawMessage `json:"backend"`
+		MaxAttempts  int             `json:"maxAttempts"`
+		InitialDelay string          `json:"initialDelay"`
+		MaxDelay     string          `json:"maxDelay"`
+		Multiplier   float64         `json:"multiplier"`
+	}
+	if err := spec.Decode(&params); err != nil {
+		return nil, err
+	}
+	policy := blobstore.RetryPolicy{MaxAttempts: params.MaxAttempts, Multiplier: params.Multiplier}
+	for _, d := range []struct {
+		name  string
+		value string
+		delay *time.Duration
+	}{
+		{"initialDelay", params.InitialDelay, &policy.InitialDelay},
+		{"maxDelay", params.MaxDelay, &policy.MaxDelay},
+	} {
+		if d.value == "" {
+			continue
+		}
+		var err error
+		if *d.delay, err = time.ParseDuration(d.value); err != nil {
+			return nil, fmt.Errorf("Invalid %s of retry storage: %v", d.name, err)
+		}
+	}
+
+	backend, err := Build(params.Backend)
+	if err != nil {
+		return nil, err
+	}
+	return blobstore.NewRetryingStorage(backend, policy), nil
+}
+
+func init() {
+	RegisterStorageType("memory", buildMemory)
+	RegisterStorageType("file", buildFile)
+	RegisterStorageType("kv", buildKeyValue)
+	RegisterStorageType("tracker", buildTracker)
+	RegisterStorageType("http", buildHTTP)
+	RegisterStorageType("layered", buildLayered)
+	RegisterStorageType("replicated", buildReplicated)
+	RegisterStorageType("retry", buildRetry)
+}
@@ -0,0 +1,172 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package config builds storage stacks from JSON documents, i.e.:
+//
+//	{
+//	    "storage": {
+//	        "type": "tracker",
+//	        "backend": {"type": "file", "path": "/var/lib/cinode"}
+//	    },
+//	    "server": {"listen": ":8080"},
+//	    "profile": "constrained"
+//	}
+//
+// Each storage type is created by a registered builder which decodes its
+// own parameters, wrapping storages build their backends recursively.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrStorageTypeAlreadyRegistered = errors.New("Storage type has already been registered")
+	ErrMissingStorage               = errors.New("Storage configuration is missing")
+	ErrMissingStorageType           = errors.New("Storage type is missing")
+	ErrMissingParameter             = errors.New("Required storage parameter is missing")
+)
+
+// Error of unknown storage type
+type UnknownStorageTypeError struct {
+	Type string
+}
+
+func (e *UnknownStorageTypeError) Error() string {
+	return fmt.Sprintf("Unknown storage type %q", e.Type)
+}
+
+// Complete configuration document
+type Config struct {
+	Storage json.RawMessage `json:"storage"` // Specification of the storage stack
+	Server  ServerConfig    `json:"server"`  // Options for applications serving the storage
+	Profile string          `json:"profile"` // Name of the resource limits profile, "default" if empty
+}
+
+// Options of the server exposing the storage, they're interpreted by applications
+type ServerConfig struct {
+	Listen   string `json:"listen,omitempty"`   // Address to listen on
+	ReadOnly bool   `json:"readonly,omitempty"` // Reject writes
+}
+
+// Builder creates the storage from its specification
+type Builder func(spec *Spec) (blobstore.BlobStorage, error)
+
+var (
+	builders     = make(map[string]Builder)
+	buildersLock sync.RWMutex
+)
+
+// Register builder of new storage type
+func RegisterStorageType(name string, builder Builder) error {
+	buildersLock.Lock()
+	defer buildersLock.Unlock()
+
+	if _, exists := builders[name]; exists {
+		return ErrStorageTypeAlreadyRegistered
+	}
+	builders[name] = builder
+	return nil
+}
+
+// Get names of registered storage types
+func StorageTypes() []string {
+	buildersLock.RLock()
+	defer buildersLock.RUnlock()
+
+	names := make([]string, 0, len(builders))
+	for name := range builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Read the configuration document
+func Load(r io.Reader) (*Config, error) {
+	var c Config
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Read the configuration document from the file
+func LoadFile(fileName string) (*Config, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Load(file)
+}
+
+// Build the storage stack described by the configuration
+func (c *Config) BuildStorage() (blobstore.BlobStorage, error) {
+	return Build(c.Storage)
+}
+
+// Build the storage from its JSON specification
+func Build(raw json.RawMessage) (blobstore.BlobStorage, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, ErrMissingStorage
+	}
+
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, err
+	}
+	if header.Type == "" {
+		return nil, ErrMissingStorageType
+	}
+
+	buildersLock.RLock()
+	builder, ok := builders[header.Type]
+	buildersLock.RUnlock()
+	if !ok {
+		return nil, &UnknownStorageTypeError{Type: header.Type}
+	}
+
+	return builder(&Spec{Type: header.Type, raw: raw})
+}
+
+// Specification of a single storage in the stack
+type Spec struct {
+	Type string
+	raw  json.RawMessage
+}
+
+// Decode parameters of the storage into the structure, fields
+// unknown to the structure are reported as errors
+func (s *Spec) Decode(params interface{}) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(s.raw, &fields); err != nil {
+		return err
+	}
+	delete(fields, "type")
+
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(stripped))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(params); err != nil {
+		return fmt.Errorf("Invalid parameters of %q storage: %v", s.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBuildStorageStack(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(fileName, []byte(`{
+		"storage": {
+			"type": "tracker",
+			"backend": {"type": "file", "path": "`+filepath.Join(dir, "blobs")+`", "mmap": true}
+		},
+		"server": {"listen": ":8080", "readonly": true}
+	}`), 0666)
+
+	c, err := LoadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Listen != ":8080" || !c.Server.ReadOnly {
+		t.Fatalf("Invalid server options: %+v", c.Server)
+	}
+
+	storage, err := c.BuildStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, ok := storage.(*blobstore.AccessTracker)
+	if !ok {
+		t.Fatalf("Invalid storage type: %T", storage)
+	}
+
+	w, _ := tracker.NewBlobWriter("b10b")
+	w.Write([]byte("data"))
+	if _, err = w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "blobs", "b10b")); err != nil {
+		t.Fatalf("Blob not written to the file backend: %v", err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+
+	for doc, expected := range map[string]string{
+		`{}`:                               ErrMissingStorage.Error(),
+		`{"storage": {}}`:                  ErrMissingStorageType.Error(),
+		`{"storage": {"type": "unknown"}}`: `Unknown storage type "unknown"`,
+		`{"storage": {"type": "file"}}`:    ErrMissingParameter.Error(),
+		`{"storage": {"type": "memory", "size": 1}}`:                               `unknown field "size"`,
+		`{"storage": {"type": "tracker"}}`:                                         ErrMissingStorage.Error(),
+		`{"storage": {"type": "kv", "syncWrites": true}}`:                          ErrMissingParameter.Error(),
+		`{"storage": {"type": "layered", "remote": {"type": "memory"}}}`:           ErrMissingParameter.Error(),
+		`{"storage": {"type": "replicated", "readRepair": true}}`:                  ErrMissingParameter.Error(),
+		`{"storage": {"type": "layered", "maxCacheBytes": 1, "negativeTTL": "1"}}`: "Invalid negativeTTL",
+		`{"storage": {"type": "memory"}, "other": 1}`:                              `unknown field "other"`,
+		`{"storage": {"type": "retry", "maxAttempts": 5}}`:                         ErrMissingStorage.Error(),
+		`{"storage": {"type": "retry", "maxDelay": "1"}}`:                          "Invalid maxDelay",
+	} {
+		c, err := Load(strings.NewReader(doc))
+		if err == nil {
+			_, err = c.BuildStorage()
+		}
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Invalid error for %v: %v", doc, err)
+		}
+	}
+
+	if err := RegisterStorageType("memory", buildMemory); err != ErrStorageTypeAlreadyRegistered {
+		t.Fatalf("Invalid error for duplicate storage type: %v", err)
+	}
+	types := StorageTypes()
+	if !sort.StringsAreSorted(types) || !strings.Contains(","+strings.Join(types, ",")+",", ",file,") {
+		t.Fatalf("Invalid storage types: %v", types)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+
+	c, err := Load(strings.NewReader(`{"storage": {"type": "memory"}, "profile": "constrained"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := c.LookupProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	constrained := profile
+	if *profile.WriterConfig().Limits != blobstore.ConstrainedLimits || profile.Admission.MaxInflight == 0 {
+		t.Fatalf("Invalid constrained profile: %+v", profile)
+	}
+	storage := blobstore.NewMemoryBlobStorage()
+	if profile.CacheDecrypted(storage) != storage {
+		t.Fatal("Decrypted cache enabled by the constrained profile")
+	}
+	request := httptest.NewRequest("PUT", "/", strings.NewReader("data"))
+	request.ContentLength = -1
+	w := httptest.NewRecorder()
+	profile.AdmissionControl(http.NotFoundHandler()).ServeHTTP(w, request)
+	if w.Code != http.StatusLengthRequired {
+		t.Fatalf("Admission limits of the profile not applied: %v", w.Code)
+	}
+
+	c.Profile = ""
+	if profile, err = c.LookupProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, cached := profile.CacheDecrypted(storage).(*blobstore.DecryptedCachingStorage); profile.Limits != blobstore.DefaultLimits || !cached {
+		t.Fatal("Default profile not applied")
+	}
+
+	// Workers and batches of the constrained profile are lower
+	if constrained.SyncOptions().Parallelism >= profile.SyncOptions().Parallelism {
+		t.Fatalf("Synchronization not constrained: %+v", constrained.SyncOptions())
+	}
+	executor, defaults := constrained.ExecutorConfig(), profile.ExecutorConfig()
+	if executor.BatchSize >= defaults.BatchSize || executor.BatchInterval <= defaults.BatchInterval {
+		t.Fatalf("Deletion executor not constrained: %+v", executor)
+	}
+
+	c.Profile = "unknown"
+	if _, err = c.LookupProfile(); err == nil || err.Error() != `Unknown profile "unknown"` {
+		t.Fatalf("Invalid error for unknown profile: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/gc"
+	"github.com/cinode/golib/httpstore"
+	cinodesync "github.com/cinode/golib/sync"
+	"net/http"
+	"time"
+)
+
+// Preset of resource limits
+type Profile struct {
+	Name      string
+	Limits    blobstore.Limits          // Limits of writers and readers
+	Admission httpstore.AdmissionConfig // Limits of servers
+
+	// Size of the cache of decrypted blobs of the storage shared
+	// by readers, the cache is disabled if zero
+	DecryptedCacheSize int64
+
+	// Number of blobs copied at once by the synchronization
+	SyncParallelism int
+
+	// Number of blobs deleted in one batch by the garbage collector
+	// and the minimum time between starts of consecutive batches
+	GCBatchSize     int
+	GCBatchInterval time.Duration
+}
+
+// Built-in profiles, "constrained" keeps memory usage low enough
+// for routers and single-board computers
+var profiles = map[string]Profile{
+	"default": {
+		Name:               "default",
+		Limits:             blobstore.DefaultLimits,
+		DecryptedCacheSize: 64 * 1024 * 1024,
+		SyncParallelism:    4,
+		GCBatchSize:        100,
+	},
+	"constrained": {
+		Name:   "constrained",
+		Limits: blobstore.ConstrainedLimits,
+		Admission: httpstore.AdmissionConfig{
+			MaxInflight:               8,
+			MaxInflightPerClient:      2,
+			MaxInflightBytes:          8 * 1024 * 1024,
+			MaxInflightBytesPerClient: 2 * 1024 * 1024,
+		},
+		SyncParallelism: 1,
+		GCBatchSize:     10,
+		GCBatchInterval: 100 * time.Millisecond,
+	},
+}
+
+// Error of unknown profile
+type UnknownProfileError struct {
+	Name string
+}
+
+func (e *UnknownProfileError) Error() string {
+	return fmt.Sprintf("Unknown profile %q", e.Name)
+}
+
+// Find the profile by its name, the default profile is used for empty name
+func LookupProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = "default"
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, &UnknownProfileError{Name: name}
+	}
+	return &profile, nil
+}
+
+// Get the profile of the configuration
+func (c *Config) LookupProfile() (*Profile, error) {
+	return LookupProfile(c.Profile)
+}
+
+// Wrap the storage with the decrypted content cache of the profile,
+// the storage is returned as is if the cache is disabled
+func (p *Profile) CacheDecrypted(storage blobstore.BlobStorage) blobstore.BlobStorage {
+	if p.DecryptedCacheSize <= 0 {
+		return storage
+	}
+	return blobstore.NewDecryptedCachingStorage(storage, p.DecryptedCacheSize)
+}
+
+// Get the configuration of writers respecting limits of the profile
+func (p *Profile) WriterConfig() *blobstore.WriterConfig {
+	limits := p.Limits
+	return &blobstore.WriterConfig{Limits: &limits}
+}
+
+// Get options of the synchronization respecting limits of the profile
+func (p *Profile) SyncOptions() cinodesync.Options {
+	return cinodesync.Options{Parallelism: p.SyncParallelism}
+}
+
+// Get the configuration of the deletion executor respecting
+// limits of the profile
+func (p *Profile) ExecutorConfig() gc.ExecutorConfig {
+	return gc.ExecutorConfig{BatchSize: p.GCBatchSize, BatchInterval: p.GCBatchInterval}
+}
+
+// Wrap the handler of the server with the admission control
+// limiting requests as configured in the profile
+func (p *Profile) AdmissionControl(next http.Handler) *httpstore.AdmissionControl {
+	return httpstore.NewAdmissionControl(p.Admission, next)
+}
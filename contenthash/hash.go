@@ -0,0 +1,123 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package contenthash computes logical hashes of file contents. The content
+// is normalized before hashing so that files differing only in encoding
+// details, i.e. line endings, get the same hash and can be deduplicated.
+package contenthash
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNormalizerAlreadyRegistered = errors.New("Normalizer has already been registered")
+	ErrUnknownNormalizer           = errors.New("Unknown normalizer")
+	ErrInvalidLogicalHash          = errors.New("Invalid logical hash")
+)
+
+// Name of the entry attribute the logical hash is recorded in
+const AttributeName = "logical-hash"
+
+// Normalizer transforms the content before it's hashed
+type Normalizer interface {
+
+	// Unique name of the normalizer, it's recorded along with the hash
+	Name() string
+
+	// Create writer normalizing data written to it, the normalized
+	// data is written to w, Close must be called to flush it
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+var (
+	normalizers     = make(map[string]Normalizer)
+	normalizersLock sync.RWMutex
+)
+
+// Register new normalizer
+func RegisterNormalizer(n Normalizer) error {
+	normalizersLock.Lock()
+	defer normalizersLock.Unlock()
+
+	if _, exists := normalizers[n.Name()]; exists {
+		return ErrNormalizerAlreadyRegistered
+	}
+	normalizers[n.Name()] = n
+	return nil
+}
+
+// Find normalizer with given name
+func LookupNormalizer(name string) (n Normalizer, ok bool) {
+	normalizersLock.RLock()
+	defer normalizersLock.RUnlock()
+
+	n, ok = normalizers[name]
+	return
+}
+
+// Logical hash of the content
+type LogicalHash struct {
+	Normalizer string // Name of the normalizer used
+	Sum        []byte // Hash of the normalized content
+}
+
+// Get the hash in the form stored in the entry attribute
+func (h LogicalHash) String() string {
+	return h.Normalizer + ":" + hex.EncodeToString(h.Sum)
+}
+
+// Parse the hash from the form stored in the entry attribute
+func ParseLogicalHash(s string) (h LogicalHash, err error) {
+	pos := strings.LastIndex(s, ":")
+	if pos <= 0 {
+		return h, ErrInvalidLogicalHash
+	}
+	if h.Sum, err = hex.DecodeString(s[pos+1:]); err != nil || len(h.Sum) != sha512.Size {
+		return LogicalHash{}, ErrInvalidLogicalHash
+	}
+	h.Normalizer = s[:pos]
+	return h, nil
+}
+
+// Test whether two hashes denote the same logical content, hashes
+// calculated with different normalizers are never equal
+func (h LogicalHash) Equal(other LogicalHash) bool {
+	return h.Normalizer == other.Normalizer && string(h.Sum) == string(other.Sum)
+}
+
+// Calculate logical hash of the content using normalizer with given name
+func Hash(content io.Reader, normalizer string) (h LogicalHash, err error) {
+	n, ok := LookupNormalizer(normalizer)
+	if !ok {
+		return h, ErrUnknownNormalizer
+	}
+
+	hasher := sha512.New()
+	w := n.NewWriter(hasher)
+	if _, err = io.Copy(w, content); err != nil {
+		return h, err
+	}
+	if err = w.Close(); err != nil {
+		return h, err
+	}
+
+	return LogicalHash{Normalizer: normalizer, Sum: hasher.Sum(nil)}, nil
+}
+
+// Calculate logical hash of the file blob
+func HashBlob(bid, key string, storage blobstore.BlobStorage, normalizer string) (h LogicalHash, err error) {
+	reader := blobstore.NewFileBlobReader(storage)
+	if err = reader.Open(bid, key); err != nil {
+		return h, err
+	}
+	defer reader.Close()
+	return Hash(reader, normalizer)
+}
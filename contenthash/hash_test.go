@@ -0,0 +1,105 @@
+package contenthash
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func mustHash(t *testing.T, content io.Reader, normalizer string) LogicalHash {
+	h, err := Hash(content, normalizer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestNormalizers(t *testing.T) {
+
+	for _, d := range []struct {
+		normalizer, a, b string
+		equal            bool
+	}{
+		{NormalizerNone, "a\r\nb", "a\nb", false},
+		{NormalizerTextLF, "a\r\nb\r\n", "a\nb\n", true},
+		{NormalizerTextLF, "a\rb\r", "a\nb\n", true},
+		{NormalizerTextLF, "a\r\r\nb", "a\n\nb", true},
+		{NormalizerTextLF, "a\n\rb", "a\n\nb", true},
+		{NormalizerTextLF, "a\nb", "a\n\nb", false},
+		{NormalizerTextLF, "\xEF\xBB\xBFa", "a", false},
+		{NormalizerTextLFBOM, "\xEF\xBB\xBFa\r\n", "a\n", true},
+		{NormalizerTextLFBOM, "\xEF\xBB", "\xEF\xBB", true},
+		{NormalizerTextLFBOM, "\xEF\xBBa", "a", false},
+		{NormalizerTextLFBOM, "a\xEF\xBB\xBF", "a", false},
+	} {
+		// Byte-by-byte reads make sure state is kept between writes
+		ha := mustHash(t, iotest.OneByteReader(strings.NewReader(d.a)), d.normalizer)
+		hb := mustHash(t, strings.NewReader(d.b), d.normalizer)
+		if ha.Equal(hb) != d.equal {
+			t.Fatalf("Invalid comparison of %q and %q with normalizer %v", d.a, d.b, d.normalizer)
+		}
+		if ha.Normalizer != d.normalizer {
+			t.Fatalf("Invalid normalizer recorded: %v", ha.Normalizer)
+		}
+	}
+
+	// Different normalizers never produce equal hashes
+	if mustHash(t, strings.NewReader("a"), NormalizerNone).Equal(mustHash(t, strings.NewReader("a"), NormalizerTextLF)) {
+		t.Fatal("Hashes with different normalizers are equal")
+	}
+
+	if _, err := Hash(strings.NewReader(""), "unknown"); err != ErrUnknownNormalizer {
+		t.Fatalf("Invalid error for unknown normalizer: %v", err)
+	}
+	if err := RegisterNormalizer(noneNormalizer{}); err != ErrNormalizerAlreadyRegistered {
+		t.Fatalf("Invalid error for duplicate normalizer: %v", err)
+	}
+}
+
+func TestLogicalHashString(t *testing.T) {
+
+	h := mustHash(t, strings.NewReader("Hello"), NormalizerTextLF)
+	parsed, err := ParseLogicalHash(h.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(h) {
+		t.Fatalf("Invalid parsed hash: %v", parsed)
+	}
+
+	for _, s := range []string{"", "text-lf", ":00", "text-lf:zz", "text-lf:00"} {
+		if _, err = ParseLogicalHash(s); err != ErrInvalidLogicalHash {
+			t.Fatalf("Invalid error for hash %q: %v", s, err)
+		}
+	}
+}
+
+func TestHashBlob(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	hashes := []LogicalHash{}
+	for _, content := range []string{"a\r\nb\r\n", "a\nb\n"} {
+		fw := blobstore.FileBlobWriter{Storage: storage}
+		fw.Write([]byte(content))
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bid, key := ref.Bid, ref.Key
+		h, err := HashBlob(bid, key, storage, NormalizerTextLF)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h)
+	}
+
+	if !hashes[0].Equal(hashes[1]) {
+		t.Fatal("Files differing only in line endings have different logical hashes")
+	}
+	if !bytes.Equal(hashes[1].Sum, mustHash(t, strings.NewReader("a\nb\n"), NormalizerNone).Sum) {
+		t.Fatal("Normalized content hash differs from raw content hash")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package contenthash
+
+import (
+	"io"
+)
+
+// Names of built-in normalizers
+const (
+	NormalizerNone      = "none"          // Content is hashed as is
+	NormalizerTextLF    = "text-lf"       // CRLF and CR line endings are converted to LF
+	NormalizerTextLFBOM = "text-lf-nobom" // As above, UTF-8 byte order mark is also removed
+)
+
+// Normalizer leaving the content untouched
+type noneNormalizer struct{}
+
+func (noneNormalizer) Name() string {
+	return NormalizerNone
+}
+
+func (noneNormalizer) NewWriter(w io.Writer) io.WriteCloser {
+	return nopCloser{w}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Normalizer converting line endings to LF, optionally
+// stripping the UTF-8 byte order mark
+type lineEndsNormalizer struct {
+	name     string
+	stripBOM bool
+}
+
+func (n lineEndsNormalizer) Name() string {
+	return n.name
+}
+
+func (n lineEndsNormalizer) NewWriter(w io.Writer) io.WriteCloser {
+	lw := &lineEndsWriter{w: w}
+	if n.stripBOM {
+		lw.bomLeft = len(utf8BOM)
+	}
+	return lw
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+type lineEndsWriter struct {
+	w         io.Writer
+	pendingCR bool   // Last byte written was CR
+	bomLeft   int    // Number of BOM bytes that may still follow
+	bom       []byte // Beginning of the BOM seen so far
+	buffer    []byte
+}
+
+func (l *lineEndsWriter) Write(p []byte) (n int, err error) {
+	l.buffer = l.buffer[:0]
+
+	for _, b := range p {
+
+		// Hold back the potential byte order mark
+		if l.bomLeft > 0 {
+			if b == utf8BOM[len(l.bom)] {
+				l.bom = append(l.bom, b)
+				if l.bomLeft--; l.bomLeft == 0 {
+					l.bom = nil
+				}
+				continue
+			}
+			l.bomLeft = 0
+			for _, c := range l.bom {
+				l.buffer = l.normalize(l.buffer, c)
+			}
+			l.bom = nil
+		}
+
+		l.buffer = l.normalize(l.buffer, b)
+	}
+
+	if _, err = l.w.Write(l.buffer); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *lineEndsWriter) normalize(out []byte, b byte) []byte {
+	if l.pendingCR {
+		l.pendingCR = false
+		out = append(out, '\n')
+		if b == '\n' {
+			return out
+		}
+	}
+	if b == '\r' {
+		l.pendingCR = true
+		return out
+	}
+	return append(out, b)
+}
+
+func (l *lineEndsWriter) Close() error {
+	out := append([]byte{}, l.bom...)
+	if l.pendingCR {
+		out = append(out, '\n')
+		l.pendingCR = false
+	}
+	l.bom, l.bomLeft = nil, 0
+	_, err := l.w.Write(out)
+	return err
+}
+
+func init() {
+	RegisterNormalizer(noneNormalizer{})
+	RegisterNormalizer(lineEndsNormalizer{name: NormalizerTextLF})
+	RegisterNormalizer(lineEndsNormalizer{name: NormalizerTextLFBOM, stripBOM: true})
+}
@@ -0,0 +1,255 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gateway serves decrypted content of blobs over HTTP, turning the
+// blob storage into a private web host. Unlike httpstore which exchanges
+// encrypted blobs, the gateway needs keys of blobs - those are given in
+// requests, directly or in share tokens.
+package gateway
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/share"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Prefix of URLs of blobs given by the blob id and the key
+const GetPath = "/get/"
+
+// Prefix of URLs of blobs given by the share token
+const SharePath = "/share/"
+
+// File served for directories
+const IndexFile = "index.html"
+
+// Methods accepted by the handler
+const allowedMethods = "GET, HEAD"
+
+// Content security policy of served files unless set in the handler,
+// files are isolated from the origin of the gateway and from each other
+const DefaultContentSecurityPolicy = "sandbox"
+
+// Handler serving files:
+//
+//	GET  /get/{bid}/{path}?key={key}  read the file, the path is optional
+//	GET  /share/{token}/{path}        read the file of the share token
+//	HEAD                              get headers of the file
+//
+// The path is looked up in the directory tree of the blob. Directories are
+// served with their IndexFile, requests for them are redirected to URLs
+// ending with a slash so that relative links resolve within the directory.
+// The key in the query is not passed to relative links, those work with
+// share tokens only. Keys in URLs end up in logs and histories, requests
+// with keys are thus redirected to share tokens if the handler has the
+// share key. Link blobs are followed, their MIME types and names are used
+// for Content-Type if the directory entry does not have one. Symbolic links
+// within directories are not followed.
+//
+// Responses are not sent as referrers, their content type is not sniffed
+// and files are served with the content security policy of the handler.
+//
+// Ranges of files are served with http.ServeContent, only partial blobs
+// containing the range are fetched and decrypted. The blob id of the file
+// is sent as the ETag. Storage operations are bound to the request context.
+type Handler struct {
+	Storage blobstore.BlobStorage
+
+	// Private key of the recipient of share tokens, tokens are refused if nil
+	ShareKey *ecdh.PrivateKey
+
+	// Prefix of URL paths stripped before routing requests,
+	// i.e. "/gateway" if the handler is mounted there
+	Prefix string
+
+	// Content-Security-Policy header of served files,
+	// DefaultContentSecurityPolicy if empty
+	ContentSecurityPolicy string
+}
+
+// Create handler of the storage
+func NewHandler(storage blobstore.BlobStorage) *Handler {
+	return &Handler{Storage: storage}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", allowedMethods)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	urlPath := r.URL.Path
+	if !strings.HasPrefix(urlPath, h.Prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	urlPath = strings.TrimPrefix(urlPath, h.Prefix)
+
+	var ref blobstore.BlobReference
+	var filePath string
+	switch {
+	case strings.HasPrefix(urlPath, GetPath):
+		var bidArg string
+		bidArg, filePath = split(strings.TrimPrefix(urlPath, GetPath))
+		bid, err := blobstore.NewBID(bidArg)
+		if err != nil {
+			http.Error(w, "Invalid blob id", http.StatusBadRequest)
+			return
+		}
+		key, err := blobstore.NewKeyInfo(r.URL.Query().Get("key"))
+		if err != nil {
+			http.Error(w, "Invalid key", http.StatusBadRequest)
+			return
+		}
+		ref = blobstore.NewBlobReference(bid, key)
+		if h.ShareKey != nil {
+			h.redirectToShare(w, r, ref, filePath)
+			return
+		}
+	case strings.HasPrefix(urlPath, SharePath) && h.ShareKey != nil:
+		var token string
+		token, filePath = split(strings.TrimPrefix(urlPath, SharePath))
+		var err error
+		if ref, err = share.Open(token, h.ShareKey); err != nil {
+			http.Error(w, "Invalid share token", http.StatusForbidden)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	storage := blobstore.WithContext(r.Context(), h.Storage)
+	entry, isDir, err := find(ref, filePath, storage)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if isDir && !strings.HasSuffix(r.URL.Path, "/") {
+		redirect := r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			redirect += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, redirect, http.StatusMovedPermanently)
+		return
+	}
+
+	reader, err := blobstore.OpenFileBlob(entry.Bid, entry.Key, storage)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer reader.Close()
+	csp := h.ContentSecurityPolicy
+	if csp == "" {
+		csp = DefaultContentSecurityPolicy
+	}
+	w.Header().Set("Content-Security-Policy", csp)
+	w.Header().Set("ETag", `"`+entry.Bid+`"`)
+	if entry.MimeType != "" {
+		w.Header().Set("Content-Type", entry.MimeType)
+	}
+	http.ServeContent(w, r, entry.Name, entry.ModTime, reader)
+}
+
+// Redirect the request with the key to the share token of the blob,
+// the token is created for the share key of the handler
+func (h *Handler) redirectToShare(w http.ResponseWriter, r *http.Request, ref blobstore.BlobReference, filePath string) {
+	token, err := share.Share(ref, h.ShareKey.PublicKey())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	location := h.Prefix + SharePath + token
+	if filePath != "" || strings.HasSuffix(r.URL.Path, "/") {
+		location += "/" + filePath
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, location, http.StatusSeeOther)
+}
+
+// Split the first component of the path from the rest
+func split(p string) (string, string) {
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+// Find the file at the path within the blob, the IndexFile is returned
+// for directories. Links are followed to their targets.
+func find(ref blobstore.BlobReference, filePath string, storage blobstore.BlobStorage) (entry blobstore.DirEntry, isDir bool, err error) {
+	if entry, err = follow(blobstore.DirEntry{Bid: ref.Bid, Key: ref.Key}, storage); err != nil {
+		return entry, false, err
+	}
+	if filePath = strings.Trim(path.Clean("/"+filePath), "/"); filePath != "" {
+		if entry, err = lookup(entry, filePath, storage); err != nil {
+			return entry, false, err
+		}
+	}
+
+	info, err := blobstore.InspectBlobWithKey(entry.Bid, entry.Key, storage)
+	switch {
+	case err != nil:
+		return entry, false, err
+	case info.IsFile():
+		return entry, false, nil
+	case !info.IsDir():
+		return entry, false, blobstore.ErrInvalidFileBlobType
+	}
+
+	if entry, err = lookup(entry, IndexFile, storage); err != nil {
+		return entry, true, err
+	}
+	if info, err = blobstore.InspectBlobWithKey(entry.Bid, entry.Key, storage); err == nil && !info.IsFile() {
+		err = blobstore.ErrInvalidFileBlobType
+	}
+	return entry, true, err
+}
+
+// Find the entry at the path within the directory
+func lookup(dir blobstore.DirEntry, filePath string, storage blobstore.BlobStorage) (blobstore.DirEntry, error) {
+	entry, err := blobstore.Resolve(dir.Bid, dir.Key, filePath, storage)
+	if err != nil {
+		return entry, err
+	}
+	if !entry.IsBlob() {
+		return entry, blobstore.ErrDirEntryNotFound
+	}
+	return follow(entry, storage)
+}
+
+// Replace the blob of the entry with the target of the link, the MIME
+// type and the name of the link are used unless the entry has them
+func follow(entry blobstore.DirEntry, storage blobstore.BlobStorage) (blobstore.DirEntry, error) {
+	target, link, err := blobstore.ResolveLinks(blobstore.BlobReference{Bid: entry.Bid, Key: entry.Key}, storage)
+	if err != nil || link == nil {
+		return entry, err
+	}
+	entry.Bid, entry.Key = target.Bid, target.Key
+	if entry.MimeType == "" {
+		entry.MimeType = link.MimeType
+	}
+	if entry.Name == "" {
+		entry.Name = link.Name
+	}
+	return entry, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, blobstore.ErrBIDNotFound), errors.Is(err, blobstore.ErrDirEntryNotFound),
+		errors.Is(err, blobstore.ErrNotDirectory), errors.Is(err, blobstore.ErrInvalidFileBlobType):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, blobstore.ErrInvalidKey):
+		http.Error(w, "Invalid key", http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,193 @@
+package gateway
+
+import (
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/share"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func genTestTree(t *testing.T, storage blobstore.BlobStorage) (root, file, link blobstore.BlobReference) {
+	writeFile := func(content string) blobstore.BlobReference {
+		fw := blobstore.FileBlobWriter{Storage: storage}
+		fw.Write([]byte(content))
+		result, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.BlobReference
+	}
+	writeDir := func(entries ...blobstore.DirEntry) blobstore.BlobReference {
+		dw := blobstore.DirBlobWriter{Storage: storage}
+		for _, entry := range entries {
+			dw.AddEntry(entry)
+		}
+		result, err := dw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.BlobReference
+	}
+
+	file = writeFile("Hello World!")
+	index := writeFile("<html>index</html>")
+	link, err := blobstore.CreateLinkBlob(blobstore.Link{Target: file, MimeType: "text/x-hello", Name: "hello"}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := writeDir(
+		blobstore.DirEntry{Name: IndexFile, Bid: index.Bid, Key: index.Key},
+		blobstore.DirEntry{Name: "out", Type: blobstore.EntryTypeSymlink, Target: "../hello.txt"})
+	empty := writeDir()
+	root = writeDir(
+		blobstore.DirEntry{Name: "hello.txt", Bid: file.Bid, Key: file.Key, ModTime: time.Unix(1400000000, 0)},
+		blobstore.DirEntry{Name: "linked", Bid: link.Bid, Key: link.Key},
+		blobstore.DirEntry{Name: "sub", Bid: sub.Bid, Key: sub.Key},
+		blobstore.DirEntry{Name: "empty", Bid: empty.Bid, Key: empty.Key})
+	return root, file, link
+}
+
+func request(t *testing.T, server *httptest.Server, method, path string, headers ...string) (*http.Response, string) {
+	req, _ := http.NewRequest(method, server.URL+path, nil)
+	for i := 0; i < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestHandler(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	root, file, link := genTestTree(t, storage)
+	server := httptest.NewServer(NewHandler(storage))
+	defer server.Close()
+
+	get := func(ref blobstore.BlobReference, path string) string {
+		return GetPath + ref.Bid + path + "?key=" + ref.Key
+	}
+
+	resp, body := request(t, server, "GET", get(file, ""))
+	if resp.StatusCode != http.StatusOK || body != "Hello World!" || resp.Header.Get("ETag") != `"`+file.Bid+`"` {
+		t.Fatalf("Invalid response for the file: %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	for header, value := range map[string]string{
+		"Referrer-Policy":         "no-referrer",
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": DefaultContentSecurityPolicy,
+	} {
+		if resp.Header.Get(header) != value {
+			t.Fatalf("Invalid %s header: %q", header, resp.Header.Get(header))
+		}
+	}
+	resp, body = request(t, server, "GET", get(root, "/hello.txt"), "Range", "bytes=6-10")
+	if resp.StatusCode != http.StatusPartialContent || body != "World" ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") ||
+		resp.Header.Get("Last-Modified") != "Tue, 13 May 2014 16:53:20 GMT" {
+		t.Fatalf("Invalid response for the range of the file: %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	resp, body = request(t, server, "HEAD", get(root, "/hello.txt"))
+	if resp.StatusCode != http.StatusOK || body != "" || resp.ContentLength != 12 {
+		t.Fatalf("Invalid response for HEAD: %d %q %d", resp.StatusCode, body, resp.ContentLength)
+	}
+	resp, _ = request(t, server, "GET", get(root, "/hello.txt"), "If-None-Match", `"`+file.Bid+`"`)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("Invalid response for the cached file: %d", resp.StatusCode)
+	}
+
+	// Links are followed
+	for _, path := range []string{get(link, ""), get(root, "/linked")} {
+		resp, body = request(t, server, "GET", path)
+		if resp.StatusCode != http.StatusOK || body != "Hello World!" || resp.Header.Get("Content-Type") != "text/x-hello" {
+			t.Fatalf("Invalid response for the link %s: %d %q %v", path, resp.StatusCode, body, resp.Header)
+		}
+	}
+
+	// Directories are served with the index file
+	resp, body = request(t, server, "GET", get(root, "/sub"))
+	if resp.StatusCode != http.StatusOK || body != "<html>index</html>" || resp.Request.URL.Path != GetPath+root.Bid+"/sub/" ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("Invalid response for the directory: %d %q %v", resp.StatusCode, body, resp.Request.URL)
+	}
+
+	for _, d := range []struct {
+		path   string
+		status int
+	}{
+		{get(root, "/missing"), http.StatusNotFound},
+		{get(root, "/empty/"), http.StatusNotFound},
+		{get(root, "/sub/out"), http.StatusNotFound},
+		{get(root, "/hello.txt/x"), http.StatusNotFound},
+		{GetPath + strings.Repeat("00", 64) + "?key=" + file.Key, http.StatusNotFound},
+		{GetPath + "zzz?key=" + file.Key, http.StatusBadRequest},
+		{GetPath + file.Bid, http.StatusBadRequest},
+		{SharePath + "token", http.StatusNotFound},
+		{"/blob/" + file.Bid, http.StatusNotFound},
+	} {
+		if resp, _ = request(t, server, "GET", d.path); resp.StatusCode != d.status {
+			t.Fatalf("Invalid status for %s: %d, expected %d", d.path, resp.StatusCode, d.status)
+		}
+	}
+	if resp, _ = request(t, server, "PUT", get(file, "")); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Invalid status for PUT: %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerShare(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	root, _, _ := genTestTree(t, storage)
+	privKey, err := share.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := share.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := share.Share(root, privKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := share.Share(root, otherKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(storage)
+	handler.ShareKey = privKey
+	handler.Prefix = "/gateway"
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, body := request(t, server, "GET", "/gateway"+SharePath+token+"/hello.txt")
+	if resp.StatusCode != http.StatusOK || body != "Hello World!" {
+		t.Fatalf("Invalid response for the shared file: %d %q", resp.StatusCode, body)
+	}
+	resp, body = request(t, server, "GET", "/gateway"+SharePath+token)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Invalid response for the shared directory without the index: %d %q", resp.StatusCode, body)
+	}
+	// Keys in URLs are replaced with share tokens
+	resp, body = request(t, server, "GET", "/gateway"+GetPath+root.Bid+"/hello.txt?key="+root.Key)
+	if resp.StatusCode != http.StatusOK || body != "Hello World!" || resp.Request.URL.RawQuery != "" ||
+		!strings.HasPrefix(resp.Request.URL.Path, "/gateway"+SharePath) || !strings.HasSuffix(resp.Request.URL.Path, "/hello.txt") {
+		t.Fatalf("Invalid response for the file given by the key: %d %q %v", resp.StatusCode, body, resp.Request.URL)
+	}
+
+	for _, path := range []string{SharePath + token + "/hello.txt", "/gateway" + SharePath + otherToken, "/gateway" + SharePath + "x"} {
+		if resp, _ = request(t, server, "GET", path); resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Invalid status for %s: %d", path, resp.StatusCode)
+		}
+	}
+}
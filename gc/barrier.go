@@ -0,0 +1,305 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gc contains garbage collection of blobs no longer reachable from roots
+package gc
+
+import (
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"sync"
+)
+
+var (
+	ErrCollectionInProgress = errors.New("Garbage collection is already in progress")
+	ErrCollectionEnded      = errors.New("Garbage collection has already ended")
+)
+
+// Barrier makes the garbage collection safe while new blobs are written.
+//
+// A blob written during an import is not reachable from any root until
+// the import finalizes its parent blobs. Blobs written by imports are
+// thus tracked and reported as protected to the collection running
+// concurrently. Blobs are protected from the moment their writers are
+// created until they're finalized or canceled, storages may find written
+// blobs are already stored before they're finalized. Blobs found to exist
+// are protected as well since writers skip writing blobs already stored
+// and reference them instead. Writers must use
+// storages wrapped by the barrier or by one of its sessions and must be
+// finalized, canceled or closed. Collections must find and delete garbage
+// with Collection.DryRun and Collection.NewExecutor.
+type Barrier struct {
+	lock       sync.Mutex
+	sessions   map[*Session]struct{} // Active write sessions
+	collection *Collection           // Collection in progress, nil if none
+	writing    map[string]int        // Numbers of writers of blobs
+}
+
+// Create new write barrier
+func NewBarrier() *Barrier {
+	return &Barrier{
+		sessions: make(map[*Session]struct{}),
+		writing:  make(map[string]int),
+	}
+}
+
+// Session groups blobs written by one import. All blobs written through
+// the session are protected from collections as long as the session is
+// open, the session should be closed once the import result is reachable
+// from roots.
+type Session struct {
+	barrier *Barrier
+	written map[string]struct{}
+}
+
+// Start new write session
+func (b *Barrier) NewSession() *Session {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	s := &Session{
+		barrier: b,
+		written: make(map[string]struct{}),
+	}
+	b.sessions[s] = struct{}{}
+	return s
+}
+
+// Wrap the storage so that blobs written through it are protected
+// during the session
+func (s *Session) Wrap(storage blobstore.BlobStorage) blobstore.BlobStorage {
+	return &barrierStorage{BlobStorage: storage, barrier: s.barrier, session: s}
+}
+
+// Close the session, blobs written so far are protected
+// until the end of the current collection
+func (s *Session) Close() {
+	s.barrier.lock.Lock()
+	defer s.barrier.lock.Unlock()
+
+	if c := s.barrier.collection; c != nil {
+		for bid := range s.written {
+			c.written[bid] = struct{}{}
+		}
+	}
+	delete(s.barrier.sessions, s)
+	s.written = nil
+}
+
+// Wrap the storage so that blobs written through it while the collection
+// is in progress are protected. Use sessions to protect blobs written
+// before the collection started.
+func (b *Barrier) Wrap(storage blobstore.BlobStorage) blobstore.BlobStorage {
+	return &barrierStorage{BlobStorage: storage, barrier: b}
+}
+
+// Protect the blob while it's being written
+func (b *Barrier) startWrite(bid string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.writing[bid]++
+}
+
+// Record the blob written in the session, nil if none. The blob is
+// only dropped from protected ones if it has not been finalized.
+func (b *Barrier) endWrite(session *Session, bid string, written bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.writing[bid]--; b.writing[bid] == 0 {
+		delete(b.writing, bid)
+	}
+	if !written {
+		return
+	}
+	if session != nil && session.written != nil {
+		session.written[bid] = struct{}{}
+	}
+	if b.collection != nil {
+		b.collection.written[bid] = struct{}{}
+	}
+}
+
+// Collection represents one run of the garbage collector
+type Collection struct {
+	barrier *Barrier
+	written map[string]struct{} // Blobs written during the collection
+}
+
+// Start new collection, only one collection can be in progress at a time
+func (b *Barrier) StartCollection() (*Collection, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.collection != nil {
+		return nil, ErrCollectionInProgress
+	}
+
+	b.collection = &Collection{
+		barrier: b,
+		written: make(map[string]struct{}),
+	}
+	return b.collection, nil
+}
+
+// Check whether the blob must not be removed by the collection
+// even if it's not reachable from roots
+func (c *Collection) IsProtected(bid string) bool {
+	c.barrier.lock.Lock()
+	defer c.barrier.lock.Unlock()
+	return c.isProtected(bid)
+}
+
+// Check the protection with the lock of the barrier held
+func (c *Collection) isProtected(bid string) bool {
+	if _, ok := c.written[bid]; ok {
+		return true
+	}
+	if c.barrier.writing[bid] > 0 {
+		return true
+	}
+	for s := range c.barrier.sessions {
+		if _, ok := s.written[bid]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Find garbage like DryRun, blobs protected by the collection are kept
+// together with those for which protected returns true
+func (c *Collection) DryRun(storage blobstore.BlobStorage, roots []blobstore.BlobReference, protected func(bid string) bool) (*Plan, error) {
+	return DryRun(storage, roots, c.protects(protected))
+}
+
+// Create the executor like NewExecutor, blobs protected by the collection
+// are dropped instead of deleted. The protection is checked and the blob
+// is deleted in one step, writers of the blob wait until it's deleted.
+// The collection must not end before the executor finishes.
+func (c *Collection) NewExecutor(storage blobstore.BlobStorage, config ExecutorConfig) (*Executor, error) {
+	protected := config.Protected
+	config.Protected = c.protects(protected)
+	e, err := NewExecutor(storage, config)
+	if err != nil {
+		return nil, err
+	}
+	e.remove = func(bid string) (bool, error) {
+		return c.deleteUnprotected(storage, bid, protected)
+	}
+	return e, nil
+}
+
+// Delete the blob unless it's protected, false is returned if it's kept
+func (c *Collection) deleteUnprotected(storage blobstore.BlobStorage, bid string, protected func(bid string) bool) (bool, error) {
+	if protected != nil && protected(bid) {
+		return false, nil
+	}
+
+	c.barrier.lock.Lock()
+	defer c.barrier.lock.Unlock()
+	if c.isProtected(bid) {
+		return false, nil
+	}
+	return true, storage.Delete(bid)
+}
+
+// Combine the protection of the collection with given one, nil if none
+func (c *Collection) protects(protected func(bid string) bool) func(bid string) bool {
+	return func(bid string) bool {
+		return c.IsProtected(bid) || (protected != nil && protected(bid))
+	}
+}
+
+// End the collection
+func (c *Collection) End() error {
+	c.barrier.lock.Lock()
+	defer c.barrier.lock.Unlock()
+
+	if c.barrier.collection != c {
+		return ErrCollectionEnded
+	}
+	c.barrier.collection = nil
+	return nil
+}
+
+// Storage reporting blobs written through it
+type barrierStorage struct {
+	blobstore.BlobStorage
+	barrier *Barrier
+	session *Session // Session of the storage, nil if wrapped by the barrier
+}
+
+// Get the wrapped storage
+func (b *barrierStorage) Unwrap() blobstore.BlobStorage {
+	return b.BlobStorage
+}
+
+// The blob is protected before the writer is created so that the collection
+// does not delete the blob the storage finds already stored meanwhile
+func (b *barrierStorage) NewBlobWriter(blobId string) (writer blobstore.WriteFinalizeCanceler, err error) {
+	b.barrier.startWrite(blobId)
+	writer, err = b.BlobStorage.NewBlobWriter(blobId)
+	if err != nil {
+		b.barrier.endWrite(nil, blobId, false)
+		return nil, err
+	}
+	return &barrierWriter{WriteFinalizeCanceler: writer, bid: blobId, storage: b}, nil
+}
+
+func (b *barrierStorage) NewBlobReader(blobId string) (reader io.Reader, err error) {
+	return b.BlobStorage.NewBlobReader(blobId)
+}
+
+// Existing blobs are protected like written ones, the blob is protected
+// during the check so that the collection does not delete it meanwhile
+func (b *barrierStorage) Exists(blobId string) (exists bool, err error) {
+	b.barrier.startWrite(blobId)
+	exists, err = b.BlobStorage.Exists(blobId)
+	b.barrier.endWrite(b.session, blobId, err == nil && exists)
+	return exists, err
+}
+
+func (b *barrierStorage) ExistsBatch(blobIds []string) (existing map[string]bool, err error) {
+	for _, bid := range blobIds {
+		b.barrier.startWrite(bid)
+	}
+	existing, err = blobstore.ExistsBatch(b.BlobStorage, blobIds)
+	for _, bid := range blobIds {
+		b.barrier.endWrite(b.session, bid, err == nil && existing[bid])
+	}
+	return existing, err
+}
+
+type barrierWriter struct {
+	blobstore.WriteFinalizeCanceler
+	bid     string
+	storage *barrierStorage
+	done    bool // Finalized or canceled, the blob is no longer being written
+}
+
+func (w *barrierWriter) Finalize() (duplicate bool, err error) {
+	duplicate, err = w.WriteFinalizeCanceler.Finalize()
+	w.end(err == nil)
+	return duplicate, err
+}
+
+func (w *barrierWriter) Cancel() error {
+	err := w.WriteFinalizeCanceler.Cancel()
+	w.end(false)
+	return err
+}
+
+func (w *barrierWriter) Close() error {
+	err := w.WriteFinalizeCanceler.Close()
+	w.end(false)
+	return err
+}
+
+func (w *barrierWriter) end(written bool) {
+	if !w.done {
+		w.done = true
+		w.storage.barrier.endWrite(w.storage.session, w.bid, written)
+	}
+}
@@ -0,0 +1,294 @@
+package gc
+
+import (
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeBlob(t *testing.T, storage blobstore.BlobStorage, bid string) {
+	w, err := storage.NewBlobWriter(bid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(bid))
+	if _, err = w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBarrierSessions(t *testing.T) {
+
+	b := NewBarrier()
+	storage := blobstore.NewMemoryBlobStorage()
+
+	// Import started before the collection
+	session := b.NewSession()
+	writeBlob(t, session.Wrap(storage), "before")
+
+	// Write outside of any session before the collection
+	writeBlob(t, b.Wrap(storage), "unprotected")
+
+	c, err := b.StartCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.StartCollection(); err != ErrCollectionInProgress {
+		t.Fatalf("Invalid error for concurrent collection: %v", err)
+	}
+
+	writeBlob(t, session.Wrap(storage), "during")
+	writeBlob(t, b.Wrap(storage), "plain-during")
+
+	// Closing the session must not unprotect blobs until the collection ends
+	session.Close()
+
+	for bid, protected := range map[string]bool{
+		"before":       true,
+		"during":       true,
+		"plain-during": true,
+		"unprotected":  false,
+	} {
+		if c.IsProtected(bid) != protected {
+			t.Errorf("Invalid protection of blob %v, expected: %v", bid, protected)
+		}
+	}
+
+	if err = c.End(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.End(); err != ErrCollectionEnded {
+		t.Fatalf("Invalid error for double end: %v", err)
+	}
+
+	// Next collection starts clean
+	c, err = b.StartCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bid := range []string{"before", "during", "plain-during"} {
+		if c.IsProtected(bid) {
+			t.Errorf("Blob %v protected by a closed session", bid)
+		}
+	}
+	c.End()
+}
+
+// Storage calling the hook on finalize instead of storing the blob
+type finalizeHookStorage struct {
+	blobstore.BlobStorage
+	hook func() error
+}
+
+func (s *finalizeHookStorage) NewBlobWriter(blobId string) (blobstore.WriteFinalizeCanceler, error) {
+	w, err := s.BlobStorage.NewBlobWriter(blobId)
+	if err != nil {
+		return nil, err
+	}
+	return &finalizeHookWriter{WriteFinalizeCanceler: w, hook: s.hook}, nil
+}
+
+type finalizeHookWriter struct {
+	blobstore.WriteFinalizeCanceler
+	hook func() error
+}
+
+func (w *finalizeHookWriter) Finalize() (bool, error) {
+	if err := w.hook(); err != nil {
+		w.Cancel()
+		return false, err
+	}
+	return w.WriteFinalizeCanceler.Finalize()
+}
+
+func TestBarrierFinalizing(t *testing.T) {
+
+	b := NewBarrier()
+	c, err := b.StartCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.End()
+
+	// The blob is protected while it's being stored
+	failure := errors.New("Finalize failed")
+	protected := false
+	storage := b.Wrap(&finalizeHookStorage{
+		BlobStorage: blobstore.NewMemoryBlobStorage(),
+		hook: func() error {
+			protected = c.IsProtected("failed")
+			return failure
+		},
+	})
+	w, err := storage.NewBlobWriter("failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("failed"))
+	if _, err = w.Finalize(); err != failure {
+		t.Fatalf("Invalid error of the failed finalize: %v", err)
+	}
+	if !protected {
+		t.Fatal("Blob not protected while being finalized")
+	}
+	if c.IsProtected("failed") {
+		t.Fatal("Blob which failed to finalize is still protected")
+	}
+}
+
+func TestCollectionSweep(t *testing.T) {
+
+	b := NewBarrier()
+	backend := blobstore.NewMemoryBlobStorage()
+	writeBlob(t, backend, "garbage")
+
+	c, err := b.StartCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBlob(t, b.Wrap(backend), "written")
+
+	plan, err := c.DryRun(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bids := plan.GarbageBids(); len(bids) != 1 || bids[0] != "garbage" || len(plan.Protected) != 1 {
+		t.Fatalf("Invalid plan of the collection: %+v", plan)
+	}
+
+	// Blobs written after the dry run are kept by the executor
+	executor, err := c.NewExecutor(backend, ExecutorConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.Add(0, "garbage", "written")
+	if err = executor.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := backend.Exists("written"); !exists {
+		t.Fatal("Protected blob deleted by the executor")
+	}
+	if exists, _ := backend.Exists("garbage"); exists {
+		t.Fatal("Garbage not deleted by the executor")
+	}
+	c.End()
+}
+
+func TestCollectionSweepRacingDuplicate(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := blobstore.NewFileBlobStorage(dir)
+	fw := blobstore.FileBlobWriter{Storage: backend}
+	fw.Write([]byte("content"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _ := backend.NewBlobReader(ref.Bid)
+	data, _ := ioutil.ReadAll(reader)
+	reader.(io.Closer).Close()
+
+	b := NewBarrier()
+	c, err := b.StartCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.End()
+
+	// The storage finds the blob is a duplicate on the first write,
+	// the executor runs before the writer is finalized
+	w, err := b.Wrap(backend).NewBlobWriter(ref.Bid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+
+	executor, err := c.NewExecutor(backend, ExecutorConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.Add(0, ref.Bid)
+	if err = executor.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if executor.Deleted() != 0 {
+		t.Fatal("Blob being written deleted by the executor")
+	}
+
+	if duplicate, err := w.Finalize(); err != nil || !duplicate {
+		t.Fatalf("Invalid result of the duplicate write: %v %v", duplicate, err)
+	}
+	if exists, _ := backend.Exists(ref.Bid); !exists {
+		t.Fatal("Finalized blob is not stored")
+	}
+
+	// Canceled writers no longer protect the blob
+	w, _ = b.Wrap(backend).NewBlobWriter(ref.Bid)
+	w.Write(data)
+	w.Cancel()
+	c.End()
+	c, _ = b.StartCollection()
+	executor, _ = c.NewExecutor(backend, ExecutorConfig{})
+	executor.Add(0, ref.Bid)
+	if err = executor.Run(); err != nil || executor.Deleted() != 1 {
+		t.Fatalf("Unprotected blob not deleted: %v", err)
+	}
+	c.End()
+}
+
+func TestBarrierDeduplicatedWrite(t *testing.T) {
+
+	backend := blobstore.NewMemoryBlobStorage()
+	fw := blobstore.FileBlobWriter{Storage: backend}
+	fw.Write([]byte("content"))
+	garbage, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBlob(t, backend, "batched")
+
+	b := NewBarrier()
+	c, err := b.StartCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.End()
+
+	// The blob already stored is not written again but it's referenced
+	// by the import now
+	session := b.NewSession()
+	defer session.Close()
+	fw = blobstore.FileBlobWriter{Storage: session.Wrap(backend)}
+	fw.Write([]byte("content"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Bid != garbage.Bid || !c.IsProtected(ref.Bid) {
+		t.Fatal("Deduplicated blob not protected")
+	}
+
+	existing, err := blobstore.ExistsBatch(b.Wrap(backend), []string{"batched", "missing"})
+	if err != nil || !existing["batched"] {
+		t.Fatalf("Invalid existing blobs: %v %v", existing, err)
+	}
+	if !c.IsProtected("batched") || c.IsProtected("missing") {
+		t.Fatal("Invalid protection of blobs checked in a batch")
+	}
+
+	plan, err := c.DryRun(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bids := plan.GarbageBids(); len(bids) != 0 {
+		t.Fatalf("Protected blobs found as garbage: %v", bids)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+)
+
+// Plan of the garbage collection, it's created without deleting anything
+// so that operators can review it before the sweep
+type Plan struct {
+	Reachable []blobstore.StoredBlob // Blobs reachable from roots
+	Protected []blobstore.StoredBlob // Unreachable blobs which must be kept, i.e. written by imports in progress
+	Garbage   []blobstore.StoredBlob // Blobs that would be deleted
+	Missing   []string               // Blobs referenced from roots but not found in the storage
+}
+
+// Find blobs that would be reclaimed by the garbage collection. Blobs
+// reachable from roots are marked first, then all blobs of the storage
+// are classified, the storage must implement blobstore.Lister. Signed
+// blobs are not followed, targets of mutable links must be given as roots.
+// Blobs pinned in the storage or in any storage it wraps, see
+// blobstore.ListPins, are roots too.
+// Blobs for which protected returns true are kept, protected may be nil.
+func DryRun(storage blobstore.BlobStorage, roots []blobstore.BlobReference, protected func(bid string) bool) (*Plan, error) {
+
+	plan := &Plan{}
+
+	pins, err := blobstore.ListPins(storage)
+	switch err {
+	case nil:
+		roots = append(roots[:len(roots):len(roots)], pins...)
+	case blobstore.ErrPinningNotSupported:
+	default:
+		return nil, err
+	}
+
+	reachable, err := mark(storage, roots, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := blobstore.ListAllBlobs(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blob := range blobs {
+		switch {
+		case reachable[blob.Bid]:
+			plan.Reachable = append(plan.Reachable, blob)
+		case protected != nil && protected(blob.Bid):
+			plan.Protected = append(plan.Protected, blob)
+		default:
+			plan.Garbage = append(plan.Garbage, blob)
+		}
+	}
+	return plan, nil
+}
+
+// Find all blobs reachable from roots, missing ones are recorded in the plan
+func mark(storage blobstore.BlobStorage, roots []blobstore.BlobReference, plan *Plan) (map[string]bool, error) {
+
+	reachable := make(map[string]bool)
+	pending := append([]blobstore.BlobReference{}, roots...)
+
+	for len(pending) > 0 {
+		ref := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[ref.Bid] {
+			continue
+		}
+
+		exists, err := storage.Exists(ref.Bid)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			plan.Missing = append(plan.Missing, ref.Bid)
+			continue
+		}
+		reachable[ref.Bid] = true
+
+		info, err := blobstore.InspectBlob(ref.Bid, storage)
+		if err != nil {
+			return nil, err
+		}
+		// References can't be read without the key, i.e. of roots given alone
+		if info.IsSigned() || ref.Key == "" {
+			continue
+		}
+
+		refs, err := blobstore.GetBlobReferences(ref.Bid, ref.Key, storage)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get references of blob %s: %v", ref.Bid, err)
+		}
+		pending = append(pending, refs...)
+	}
+	return reachable, nil
+}
+
+// Get the number of bytes that would be reclaimed
+func (p *Plan) ReclaimableBytes() int64 {
+	return totalSize(p.Garbage)
+}
+
+// Get ids of blobs that would be deleted
+func (p *Plan) GarbageBids() []string {
+	bids := make([]string, len(p.Garbage))
+	for i, blob := range p.Garbage {
+		bids[i] = blob.Bid
+	}
+	return bids
+}
+
+// Queue blobs of the plan for deletion in the executor
+func (p *Plan) Schedule(executor *Executor, priority int) error {
+	return executor.Add(priority, p.GarbageBids()...)
+}
+
+// Write human-readable report of the plan
+func (p *Plan) WriteReport(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Reachable: %d blobs, %d bytes\nProtected: %d blobs, %d bytes\nGarbage: %d blobs, %d bytes\nMissing: %d blobs\n",
+		len(p.Reachable), totalSize(p.Reachable),
+		len(p.Protected), totalSize(p.Protected),
+		len(p.Garbage), totalSize(p.Garbage),
+		len(p.Missing))
+	if err != nil {
+		return err
+	}
+	for _, blob := range p.Garbage {
+		if _, err = fmt.Fprintf(w, "delete %s %d\n", blob.Bid, blob.Size); err != nil {
+			return err
+		}
+	}
+	for _, bid := range p.Missing {
+		if _, err = fmt.Fprintf(w, "missing %s\n", bid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func totalSize(blobs []blobstore.StoredBlob) (size int64) {
+	for _, blob := range blobs {
+		size += blob.Size
+	}
+	return
+}
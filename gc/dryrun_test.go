@@ -0,0 +1,140 @@
+package gc
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"strings"
+	"testing"
+)
+
+// Storage failing on any deletion
+type noDeleteStorage struct {
+	blobstore.BlobStorage
+}
+
+func (n *noDeleteStorage) Delete(blobId string) error {
+	return errors.New("Unexpected deletion")
+}
+
+func (n *noDeleteStorage) ListBlobs(prefix, cursor string, limit int) ([]blobstore.StoredBlob, string, error) {
+	return blobstore.ListBlobs(n.BlobStorage, prefix, cursor, limit)
+}
+
+func TestDryRun(t *testing.T) {
+
+	backend := blobstore.NewMemoryBlobStorage()
+	storage := &noDeleteStorage{BlobStorage: backend}
+
+	createFile := func(content string) blobstore.BlobReference {
+		fw := blobstore.FileBlobWriter{Storage: storage}
+		fw.Write([]byte(content))
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bid, key := ref.Bid, ref.Key
+		return blobstore.BlobReference{Bid: bid, Key: key}
+	}
+
+	live := createFile("live")
+	dead := createFile("dead")
+	protected := createFile("protected")
+
+	dw := blobstore.DirBlobWriter{Storage: storage}
+	dw.AddEntry(blobstore.DirEntry{Name: "live", Bid: live.Bid, Key: live.Key})
+	dw.AddEntry(blobstore.DirEntry{Name: "missing", Bid: "missing", Key: "key"})
+	rootRef, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootBid, rootKey := rootRef.Bid, rootRef.Key
+
+	plan, err := DryRun(storage, []blobstore.BlobReference{{Bid: rootBid, Key: rootKey}},
+		func(bid string) bool { return bid == protected.Bid })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Reachable) != 2 || len(plan.Protected) != 1 || plan.Protected[0].Bid != protected.Bid {
+		t.Fatalf("Invalid classification of blobs: %+v", plan)
+	}
+	if bids := plan.GarbageBids(); len(bids) != 1 || bids[0] != dead.Bid {
+		t.Fatalf("Invalid garbage: %v", bids)
+	}
+	if len(plan.Missing) != 1 || plan.Missing[0] != "missing" {
+		t.Fatalf("Invalid missing blobs: %v", plan.Missing)
+	}
+	if plan.ReclaimableBytes() != plan.Garbage[0].Size || plan.ReclaimableBytes() <= 0 {
+		t.Fatalf("Invalid reclaimable bytes: %v", plan.ReclaimableBytes())
+	}
+
+	var report bytes.Buffer
+	if err = plan.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "delete "+dead.Bid) {
+		t.Fatalf("Invalid report: %v", report.String())
+	}
+
+	// Nothing has been deleted
+	if blobs, _ := blobstore.ListAllBlobs(backend); len(blobs) != 4 {
+		t.Fatalf("Blobs deleted by the dry run: %v", len(blobs))
+	}
+
+	if _, err = DryRun(struct{ blobstore.BlobStorage }{backend}, nil, nil); err != blobstore.ErrListNotSupported {
+		t.Fatalf("Invalid error for storage without listing: %v", err)
+	}
+}
+
+func TestDryRunPins(t *testing.T) {
+
+	backend := blobstore.NewMemoryBlobStorage()
+	storage, err := blobstore.NewPinningStorage(backend, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createFile := func(content string) blobstore.BlobReference {
+		fw := blobstore.FileBlobWriter{Storage: storage}
+		fw.Write([]byte(content))
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ref.BlobReference
+	}
+	child := createFile("child")
+	alone := createFile("alone")
+	dead := createFile("dead")
+
+	dw := blobstore.DirBlobWriter{Storage: storage}
+	dw.AddEntry(blobstore.DirEntry{Name: "child", Bid: child.Bid, Key: child.Key})
+	dir, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pinned blobs are kept with their content, pins are found through wrappers
+	if err = storage.Pin(blobstore.BlobReference{Bid: dead.Bid}); err != blobstore.ErrPinKeyRequired {
+		t.Fatalf("Invalid error for pinning without the key: %v", err)
+	}
+	storage.Pin(dir.BlobReference)
+	storage.Pin(alone)
+	for _, wrapped := range []blobstore.BlobStorage{
+		storage,
+		blobstore.NewMaintenanceStorage(blobstore.NewAccessTracker(storage)),
+		blobstore.NewReadOnlyStorage(blobstore.NewRetryingStorage(storage, blobstore.RetryPolicy{})),
+	} {
+		plan, err := DryRun(wrapped, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Reachable) != 3 || len(plan.Missing) != 0 {
+			t.Fatalf("Invalid classification of blobs: %+v", plan)
+		}
+		if bids := plan.GarbageBids(); len(bids) != 1 || bids[0] != dead.Bid {
+			t.Fatalf("Invalid garbage: %v", bids)
+		}
+	}
+}
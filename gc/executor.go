@@ -0,0 +1,396 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrExecutorPaused  = errors.New("Deletion executor has been paused")
+	ErrExecutorRunning = errors.New("Deletion executor is already running")
+	ErrInvalidWorklist = errors.New("Invalid deletion worklist file")
+)
+
+// Configuration of the deletion executor
+type ExecutorConfig struct {
+	BatchSize     int                   // Number of blobs deleted in one batch, 1 if not set
+	BatchInterval time.Duration         // Minimum time between starts of consecutive batches
+	WorklistFile  string                // File the worklist is persisted in, empty if not persisted
+	Clock         utils.Clock           // Source of time, system clock if not set
+	Protected     func(bid string) bool // Blobs for which it returns true are dropped instead of deleted
+
+	// Roots of the storage, blobs of the persisted worklist which are
+	// reachable from them or pinned when the sweep continues are dropped
+	Roots []blobstore.BlobReference
+}
+
+// Executor deletes blobs found by the garbage collector. Deletions are done
+// in batches with limited rate so that the storage backend is not overwhelmed,
+// blobs with higher priority are deleted first. The worklist is persisted
+// after each batch so that an interrupted sweep can be continued later,
+// blobs may have become reachable since then thus reachability of loaded
+// blobs is checked again before they're deleted.
+type Executor struct {
+	storage blobstore.BlobStorage
+	config  ExecutorConfig
+	remove  func(bid string) (deleted bool, err error) // Deletes the blob unless it's protected
+
+	lock     sync.Mutex
+	queue    deletionQueue
+	queued   map[string]struct{}
+	inFlight []*deletionItem // Items of the batch being deleted
+	seq      int64
+	paused   bool
+	running  bool
+	deleted  int64
+	missing  int64 // Number of blobs found already gone
+	loaded   int // Number of pending items loaded from the worklist file
+}
+
+// Create new executor, the worklist is loaded from the file if it does exist
+func NewExecutor(storage blobstore.BlobStorage, config ExecutorConfig) (*Executor, error) {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+	if config.Clock == nil {
+		config.Clock = utils.SystemClock
+	}
+
+	e := &Executor{
+		storage: storage,
+		config:  config,
+		queued:  make(map[string]struct{}),
+	}
+	e.remove = e.deleteUnprotected
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Add blobs to be deleted with given priority
+func (e *Executor) Add(priority int, bids ...string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, bid := range bids {
+		e.push(priority, bid)
+	}
+	return e.save()
+}
+
+// Stop processing after the current batch, Run returns ErrExecutorPaused
+func (e *Executor) Pause() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.paused = true
+}
+
+// Allow processing again, Run must be called to continue
+func (e *Executor) Resume() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.paused = false
+}
+
+// Get the number of blobs waiting for deletion
+func (e *Executor) Pending() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.queue.Len() + len(e.inFlight)
+}
+
+// Get the number of blobs deleted so far
+func (e *Executor) Deleted() int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.deleted
+}
+
+// Get the number of blobs of the worklist found already gone, those
+// are not counted as deleted
+func (e *Executor) Missing() int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.missing
+}
+
+// Process the worklist until it's empty or the executor is paused.
+// Blobs that no longer exist are dropped from the worklist and counted
+// as missing. On error the offending blob stays in the worklist.
+func (e *Executor) Run() error {
+	e.lock.Lock()
+	if e.running {
+		e.lock.Unlock()
+		return ErrExecutorRunning
+	}
+	e.running = true
+	e.lock.Unlock()
+
+	defer func() {
+		e.lock.Lock()
+		e.running = false
+		e.lock.Unlock()
+	}()
+
+	// Blobs of the interrupted sweep might be reachable again
+	var reachable map[string]bool
+	e.lock.Lock()
+	loaded := e.loaded
+	e.lock.Unlock()
+	if loaded > 0 {
+		var err error
+		if reachable, err = e.reachable(); err != nil {
+			return err
+		}
+	}
+
+	var lastBatch time.Time
+	for {
+		e.lock.Lock()
+		if e.paused {
+			e.lock.Unlock()
+			return ErrExecutorPaused
+		}
+		if e.queue.Len() == 0 {
+			e.lock.Unlock()
+			return nil
+		}
+		for e.queue.Len() > 0 && len(e.inFlight) < e.config.BatchSize {
+			e.inFlight = append(e.inFlight, heap.Pop(&e.queue).(*deletionItem))
+		}
+		e.lock.Unlock()
+
+		// Keep the rate of batches
+		if !lastBatch.IsZero() {
+			if wait := e.config.BatchInterval - e.config.Clock.Now().Sub(lastBatch); wait > 0 {
+				e.config.Clock.Sleep(context.Background(), wait)
+			}
+		}
+		lastBatch = e.config.Clock.Now()
+
+		if err := e.runBatch(reachable); err != nil {
+			return err
+		}
+	}
+}
+
+// Delete blobs of the current batch, blobs not deleted due to an error
+// are returned to the queue. Loaded blobs which are reachable are dropped.
+func (e *Executor) runBatch(reachable map[string]bool) (err error) {
+
+	done, deleted, missing := 0, int64(0), int64(0)
+	for _, item := range e.inFlight {
+		if item.loaded && reachable[item.bid] {
+			done++
+			continue
+		}
+		var removed bool
+		removed, err = e.remove(item.bid)
+		if err == blobstore.ErrBIDNotFound {
+			err, removed = nil, false
+			missing++
+		}
+		if err != nil {
+			break
+		}
+		if removed {
+			deleted++
+		}
+		done++
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, item := range e.inFlight[:done] {
+		delete(e.queued, item.bid)
+		if item.loaded {
+			e.loaded--
+		}
+	}
+	for _, item := range e.inFlight[done:] {
+		heap.Push(&e.queue, item)
+	}
+	e.inFlight = nil
+	e.deleted += deleted
+	e.missing += missing
+
+	if saveErr := e.save(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// Delete the blob unless it's protected, false is returned if it's kept
+func (e *Executor) deleteUnprotected(bid string) (bool, error) {
+	if e.config.Protected != nil && e.config.Protected(bid) {
+		return false, nil
+	}
+	return true, e.storage.Delete(bid)
+}
+
+// Find blobs reachable from roots or pinned
+func (e *Executor) reachable() (map[string]bool, error) {
+	roots := e.config.Roots
+	pins, err := blobstore.ListPins(e.storage)
+	switch err {
+	case nil:
+		roots = append(roots[:len(roots):len(roots)], pins...)
+	case blobstore.ErrPinningNotSupported:
+	default:
+		return nil, err
+	}
+	return mark(e.storage, roots, &Plan{})
+}
+
+func (e *Executor) push(priority int, bid string) *deletionItem {
+	if _, exists := e.queued[bid]; exists {
+		return nil
+	}
+	e.queued[bid] = struct{}{}
+	item := &deletionItem{bid: bid, priority: priority, seq: e.seq}
+	heap.Push(&e.queue, item)
+	e.seq++
+	return item
+}
+
+// Write the worklist file, it's replaced atomically
+func (e *Executor) save() error {
+	if e.config.WorklistFile == "" {
+		return nil
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(e.config.WorklistFile), ".worklist")
+	if err != nil {
+		return err
+	}
+
+	items := append([]*deletionItem{}, e.inFlight...)
+	items = append(items, e.queue...)
+	sort.Sort(byPriority(items))
+
+	w := bufio.NewWriter(file)
+	for _, item := range items {
+		fmt.Fprintf(w, "%d %s\n", item.priority, item.bid)
+	}
+	if err = w.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), e.config.WorklistFile)
+}
+
+// Read the worklist file
+func (e *Executor) load() error {
+	if e.config.WorklistFile == "" {
+		return nil
+	}
+
+	file, err := os.Open(e.config.WorklistFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var priority int
+		var bid string
+		if n, _ := fmt.Sscanf(scanner.Text(), "%d %s", &priority, &bid); n != 2 {
+			return ErrInvalidWorklist
+		}
+		if item := e.push(priority, bid); item != nil {
+			item.loaded = true
+			e.loaded++
+		}
+	}
+	return scanner.Err()
+}
+
+// Single blob waiting for deletion
+type deletionItem struct {
+	bid      string
+	priority int
+	seq      int64 // Order of adding, items with equal priority are deleted in this order
+	index    int   // Position in the heap
+	loaded   bool  // Read from the worklist file
+}
+
+func (d *deletionItem) before(other *deletionItem) bool {
+	if d.priority != other.priority {
+		return d.priority > other.priority
+	}
+	return d.seq < other.seq
+}
+
+// Heap of items waiting for deletion, highest priority first
+type deletionQueue []*deletionItem
+
+func (q deletionQueue) Len() int {
+	return len(q)
+}
+
+func (q deletionQueue) Less(i, j int) bool {
+	return q[i].before(q[j])
+}
+
+func (q deletionQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *deletionQueue) Push(x interface{}) {
+	item := x.(*deletionItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *deletionQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// Helper for sorting items in the deletion order
+type byPriority []*deletionItem
+
+func (s byPriority) Len() int {
+	return len(s)
+}
+
+func (s byPriority) Less(i, j int) bool {
+	return s[i].before(s[j])
+}
+
+func (s byPriority) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,226 @@
+package gc
+
+import (
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Storage recording the order of deletions
+type recordingStorage struct {
+	blobstore.BlobStorage
+	deleted []string
+	fail    map[string]error
+}
+
+func (r *recordingStorage) Delete(blobId string) error {
+	if err := r.fail[blobId]; err != nil {
+		return err
+	}
+	r.deleted = append(r.deleted, blobId)
+	return r.BlobStorage.Delete(blobId)
+}
+
+func newRecordingStorage(t *testing.T, bids ...string) *recordingStorage {
+	storage := &recordingStorage{
+		BlobStorage: blobstore.NewMemoryBlobStorage(),
+		fail:        make(map[string]error),
+	}
+	for _, bid := range bids {
+		writeBlob(t, storage, bid)
+	}
+	return storage
+}
+
+func equalBids(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestExecutorPriorityAndRate(t *testing.T) {
+
+	storage := newRecordingStorage(t, "a", "b", "c", "d", "e")
+	clock := utils.NewManualClock(time.Unix(0, 0))
+	e, err := NewExecutor(storage, ExecutorConfig{
+		BatchSize:     2,
+		BatchInterval: time.Second,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.Add(0, "a", "b")
+	e.Add(5, "c")
+	e.Add(1, "d", "e", "missing")
+	e.Add(9, "a") // Already queued
+
+	if err = e.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"c", "d", "e", "missing", "a", "b"}; !equalBids(storage.deleted, expected) {
+		t.Fatalf("Invalid deletion order: %v", storage.deleted)
+	}
+	if e.Pending() != 0 || e.Deleted() != 5 || e.Missing() != 1 {
+		t.Fatalf("Invalid executor state: %v pending, %v deleted, %v missing", e.Pending(), e.Deleted(), e.Missing())
+	}
+
+	// Three batches, two waits between them
+	if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed != 2*time.Second {
+		t.Fatalf("Invalid time spent: %v", elapsed)
+	}
+
+	if _, err = storage.NewBlobReader("a"); err != blobstore.ErrBIDNotFound {
+		t.Fatalf("Blob has not been deleted: %v", err)
+	}
+}
+
+func TestExecutorPauseAndPersistence(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := newRecordingStorage(t, "a", "b", "c", "d")
+	var e *Executor
+	config := ExecutorConfig{
+		WorklistFile: filepath.Join(dir, "worklist"),
+		Protected: func(bid string) bool {
+			// Interrupt the sweep after the first blob
+			e.Pause()
+			return bid == "protected"
+		},
+	}
+
+	if e, err = NewExecutor(storage, config); err != nil {
+		t.Fatal(err)
+	}
+	e.Add(0, "a", "b", "c", "d", "protected")
+
+	if err = e.Run(); err != ErrExecutorPaused {
+		t.Fatalf("Invalid error of paused executor: %v", err)
+	}
+	if e.Pending() != 4 {
+		t.Fatalf("Invalid number of pending blobs: %v", e.Pending())
+	}
+
+	// Failed deletion is retried by the next executor
+	storage.fail["b"] = errors.New("Backend failure")
+	e.Resume()
+	if err = e.Run(); err != storage.fail["b"] {
+		t.Fatalf("Invalid error of failed deletion: %v", err)
+	}
+
+	// Continue the sweep from the persisted worklist
+	delete(storage.fail, "b")
+	config.Protected = func(bid string) bool { return bid == "protected" }
+	if e, err = NewExecutor(storage, config); err != nil {
+		t.Fatal(err)
+	}
+	if e.Pending() != 4 {
+		t.Fatalf("Invalid number of pending blobs after reload: %v", e.Pending())
+	}
+	if err = e.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"a", "b", "c", "d"}; !equalBids(storage.deleted, expected) {
+		t.Fatalf("Invalid deleted blobs: %v", storage.deleted)
+	}
+
+	ioutil.WriteFile(config.WorklistFile, []byte("garbage\n"), 0666)
+	if _, err = NewExecutor(storage, config); err != ErrInvalidWorklist {
+		t.Fatalf("Invalid error for corrupted worklist: %v", err)
+	}
+}
+
+func TestExecutorResumedReachable(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := newRecordingStorage(t)
+	var bids []string
+	for _, content := range []string{"a", "b", "c"} {
+		fw := blobstore.FileBlobWriter{Storage: storage}
+		fw.Write([]byte(content))
+		ref, err := fw.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bids = append(bids, ref.Bid)
+	}
+
+	// The second blob has become reachable after the sweep was interrupted
+	config := ExecutorConfig{
+		WorklistFile: filepath.Join(dir, "worklist"),
+		Roots:        []blobstore.BlobReference{{Bid: bids[1]}},
+	}
+	ioutil.WriteFile(config.WorklistFile, []byte("0 "+bids[0]+"\n0 "+bids[1]+"\n0 "+bids[2]+"\n"), 0666)
+
+	e, err := NewExecutor(storage, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{bids[0], bids[2]}; !equalBids(storage.deleted, expected) {
+		t.Fatalf("Invalid deleted blobs: %v", storage.deleted)
+	}
+	if e.Pending() != 0 || e.Deleted() != 2 {
+		t.Fatalf("Invalid executor state: %v pending, %v deleted", e.Pending(), e.Deleted())
+	}
+	if exists, _ := storage.Exists(bids[1]); !exists {
+		t.Fatal("Reachable blob has been deleted")
+	}
+}
+
+func TestExecutorAlreadyDeleted(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The first blob has been deleted after the sweep was interrupted
+	storage := newRecordingStorage(t, "a", "b")
+	config := ExecutorConfig{WorklistFile: filepath.Join(dir, "worklist")}
+	ioutil.WriteFile(config.WorklistFile, []byte("0 a\n0 b\n"), 0666)
+	if err = storage.BlobStorage.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewExecutor(storage, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Pending() != 0 || e.Deleted() != 1 || e.Missing() != 1 {
+		t.Fatalf("Invalid executor state: %v pending, %v deleted, %v missing", e.Pending(), e.Deleted(), e.Missing())
+	}
+	if exists, _ := storage.Exists("b"); exists {
+		t.Fatal("Blob has not been deleted")
+	}
+}
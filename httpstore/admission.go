@@ -0,0 +1,123 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package httpstore exchanges blobs over HTTP
+package httpstore
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Limits of the admission control, zero values mean no limit
+type AdmissionConfig struct {
+	MaxInflight               int   // Requests processed at the same time
+	MaxInflightPerClient      int   // Requests of one client processed at the same time
+	MaxInflightBytes          int64 // Total size of request bodies being received
+	MaxInflightBytesPerClient int64 // Total size of request bodies of one client being received
+
+	// Identify the client of the request, the remote IP address is used if not set
+	ClientID func(r *http.Request) string
+}
+
+// Admission control rejects requests exceeding configured limits with
+// 429 Too Many Requests so that a single aggressive client can not
+// starve the others. Sizes of request bodies are taken from the
+// Content-Length header. Requests of unknown length, i.e. with chunked
+// bodies, are rejected with 411 Length Required if sizes are limited.
+type AdmissionControl struct {
+	config AdmissionConfig
+	next   http.Handler
+
+	lock     sync.Mutex
+	inflight int
+	bytes    int64
+	clients  map[string]*clientUsage
+}
+
+type clientUsage struct {
+	inflight int
+	bytes    int64
+}
+
+// Wrap the handler with the admission control
+func NewAdmissionControl(config AdmissionConfig, next http.Handler) *AdmissionControl {
+	if config.ClientID == nil {
+		config.ClientID = remoteIP
+	}
+	return &AdmissionControl{
+		config:  config,
+		next:    next,
+		clients: make(map[string]*clientUsage),
+	}
+}
+
+func (a *AdmissionControl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := a.config.ClientID(r)
+	size := r.ContentLength
+	if size < 0 {
+		if a.config.MaxInflightBytes > 0 || a.config.MaxInflightBytesPerClient > 0 {
+			http.Error(w, "Length required", http.StatusLengthRequired)
+			return
+		}
+		size = 0
+	}
+
+	if !a.acquire(client, size) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	defer a.release(client, size)
+
+	a.next.ServeHTTP(w, r)
+}
+
+func (a *AdmissionControl) acquire(client string, size int64) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	usage := a.clients[client]
+	if usage == nil {
+		usage = &clientUsage{}
+	}
+
+	c := &a.config
+	if (c.MaxInflight > 0 && a.inflight >= c.MaxInflight) ||
+		(c.MaxInflightPerClient > 0 && usage.inflight >= c.MaxInflightPerClient) ||
+		(c.MaxInflightBytes > 0 && a.bytes+size > c.MaxInflightBytes) ||
+		(c.MaxInflightBytesPerClient > 0 && usage.bytes+size > c.MaxInflightBytesPerClient) {
+		return false
+	}
+
+	a.inflight++
+	a.bytes += size
+	usage.inflight++
+	usage.bytes += size
+	a.clients[client] = usage
+	return true
+}
+
+func (a *AdmissionControl) release(client string, size int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.inflight--
+	a.bytes -= size
+	usage := a.clients[client]
+	usage.inflight--
+	usage.bytes -= size
+	if usage.inflight == 0 {
+		delete(a.clients, client)
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
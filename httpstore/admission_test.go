@@ -0,0 +1,212 @@
+package httpstore
+
+import (
+	"context"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Handler keeping requests in flight until released
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.started <- struct{}{}
+	<-b.release
+}
+
+func newRequest(client string, size int) *http.Request {
+	r := httptest.NewRequest("PUT", "/", strings.NewReader(strings.Repeat("x", size)))
+	r.Header.Set("X-Client", client)
+	return r
+}
+
+func TestAdmissionControl(t *testing.T) {
+
+	handler := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	admission := NewAdmissionControl(AdmissionConfig{
+		MaxInflight:               3,
+		MaxInflightPerClient:      2,
+		MaxInflightBytes:          150,
+		MaxInflightBytesPerClient: 100,
+		ClientID:                  func(r *http.Request) string { return r.Header.Get("X-Client") },
+	}, handler)
+
+	serve := func(client string, size int) int {
+		w := httptest.NewRecorder()
+		admission.ServeHTTP(w, newRequest(client, size))
+		return w.Code
+	}
+
+	// Requests admitted and kept in flight
+	done := make(chan int)
+	for _, r := range []struct {
+		client string
+		size   int
+	}{{"a", 10}, {"a", 80}, {"b", 50}} {
+		go func(client string, size int) { done <- serve(client, size) }(r.client, r.size)
+		<-handler.started
+	}
+
+	for _, r := range []struct {
+		client string
+		size   int
+	}{
+		{"a", 0}, // Per-client request limit
+		{"c", 0}, // Global request limit
+	} {
+		if code := serve(r.client, r.size); code != http.StatusTooManyRequests {
+			t.Fatalf("Request of client %v admitted over the limit: %v", r.client, code)
+		}
+	}
+
+	// Release one request of a and one of b
+	handler.release <- struct{}{}
+	handler.release <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Fatalf("Invalid status of admitted request: %v", code)
+		}
+	}
+
+	// One request in flight, byte limits apply
+	for _, r := range []struct {
+		client string
+		size   int
+	}{
+		{"a", 101}, // Per-client byte limit
+		{"c", 141}, // Global byte limit
+	} {
+		if code := serve(r.client, r.size); code != http.StatusTooManyRequests {
+			t.Fatalf("Request of client %v with %v bytes admitted over the limit: %v", r.client, r.size, code)
+		}
+	}
+
+	go func() { done <- serve("c", 60) }()
+	<-handler.started
+	handler.release <- struct{}{}
+	handler.release <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Fatalf("Invalid status of admitted request: %v", code)
+		}
+	}
+
+	if len(admission.clients) != 0 || admission.inflight != 0 || admission.bytes != 0 {
+		t.Fatal("Usage not released after requests finished")
+	}
+
+	// Bodies of unknown length can't be counted
+	r := newRequest("a", 10)
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	admission.ServeHTTP(w, r)
+	if w.Code != http.StatusLengthRequired {
+		t.Fatalf("Request of unknown length admitted: %v", w.Code)
+	}
+}
+
+// Clock calling the hook instead of waiting
+type hookClock struct {
+	*utils.ManualClock
+	hook func(ctx context.Context, d time.Duration) error
+}
+
+func (c *hookClock) Sleep(ctx context.Context, d time.Duration) error {
+	return c.hook(ctx, d)
+}
+
+func TestClientRetriesOverloadedServer(t *testing.T) {
+
+	backend := blobstore.NewMemoryBlobStorage()
+	blocking := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	server := NewServer(backend)
+	ts := httptest.NewServer(NewAdmissionControl(AdmissionConfig{MaxInflight: 1},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/0b10c4") {
+				blocking.ServeHTTP(w, r)
+				return
+			}
+			server.ServeHTTP(w, r)
+		})))
+	defer ts.Close()
+	client := NewHTTPBlobStorage(ts.URL, nil)
+
+	// Keep the only admitted request in flight
+	block := func() chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			client.Exists("0b10c4")
+			close(done)
+		}()
+		<-blocking.started
+		return done
+	}
+
+	done := block()
+	_, err := client.Exists("0b1d")
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.StatusCode != http.StatusTooManyRequests ||
+		serverErr.RetryDelay() != time.Second || !errors.Is(err, blobstore.ErrStorageUnavailable) {
+		t.Fatalf("Invalid error of the overloaded server: %v", err)
+	}
+
+	// The retry waits at least as long as requested by the server
+	var delays []time.Duration
+	storage := blobstore.NewRetryingStorage(client, blobstore.RetryPolicy{})
+	storage.Clock = &hookClock{
+		ManualClock: utils.NewManualClock(time.Unix(0, 0)),
+		hook: func(ctx context.Context, d time.Duration) error {
+			if len(delays) == 0 {
+				blocking.release <- struct{}{}
+				<-done
+			}
+			delays = append(delays, d)
+			return nil
+		},
+	}
+	if exists, err := storage.Exists("0b1d"); err != nil || exists {
+		t.Fatalf("Invalid result of the retried request: %v %v", exists, err)
+	}
+	if len(delays) != 1 || delays[0] != time.Second {
+		t.Fatalf("Invalid delays of retries: %v", delays)
+	}
+
+	// Waiting for the retry is interrupted by the context
+	done = block()
+	defer func() {
+		blocking.release <- struct{}{}
+		<-done
+	}()
+	storage.Clock = utils.SystemClock
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = storage.WithContext(ctx).Exists("0b1d"); err != context.DeadlineExceeded {
+		t.Fatalf("Invalid error of the interrupted retry: %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for header, delay := range map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Wed, 01 Jan 2014 00:00:10 GMT": 10 * time.Second,
+		"Tue, 31 Dec 2013 23:59:00 GMT": 0,
+	} {
+		if d := retryAfter(header, now); d != delay {
+			t.Errorf("Invalid delay of %q: %v, expected %v", header, d, delay)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error returned by the server which does not map to any storage error
+type ServerError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration // Delay requested by the server, 0 if none
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("Blob server error %d: %s", e.StatusCode, e.Message)
+}
+
+// Errors of gateways and of the overloaded server, including requests
+// refused by the admission control, match blobstore.ErrStorageUnavailable
+func (e *ServerError) Is(target error) bool {
+	if target != blobstore.ErrStorageUnavailable {
+		return false
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Get the delay requested with the Retry-After header, see blobstore.RetryDelayer
+func (e *ServerError) RetryDelay() time.Duration {
+	return e.RetryAfter
+}
+
+// Blob storage accessed through the blob server
+type HTTPBlobStorage struct {
+	baseURL string
+	client  *http.Client
+	ctx     context.Context
+}
+
+// Create storage using the server at given URL, http.DefaultClient
+// is used if the client is nil
+func NewHTTPBlobStorage(baseURL string, client *http.Client) *HTTPBlobStorage {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPBlobStorage{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+		ctx:     context.Background(),
+	}
+}
+
+// Get the storage sending requests bound to the context, requests
+// in progress are aborted once the context is done
+func (h *HTTPBlobStorage) WithContext(ctx context.Context) blobstore.BlobStorage {
+	bound := *h
+	bound.ctx = ctx
+	return &bound
+}
+
+// Listing is not part of the protocol, deletions may
+// still be refused by the server
+func (h *HTTPBlobStorage) Capabilities() blobstore.Capabilities {
+	return blobstore.CapabilityWrite | blobstore.CapabilityDelete | blobstore.CapabilityRangeReads
+}
+
+func (h *HTTPBlobStorage) blobURL(blobId string) string {
+	return h.baseURL + BlobPath + url.PathEscape(blobId)
+}
+
+func (h *HTTPBlobStorage) do(method, blobId string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(h.ctx, method, h.blobURL(blobId), body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.send(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// Send the request, failures to reach the server are reported as
+// blobstore.StorageUnavailableError unless the request was aborted
+func (h *HTTPBlobStorage) send(req *http.Request) (*http.Response, error) {
+	resp, err := h.client.Do(req)
+	if err != nil && h.ctx.Err() == nil {
+		return nil, &blobstore.StorageUnavailableError{Err: err}
+	}
+	return resp, err
+}
+
+// Convert the error response back to the storage error
+func responseError(resp *http.Response) error {
+	code := resp.Header.Get(errorHeader)
+	if err, ok := errorCodes[code]; ok {
+		return err
+	}
+	if code == invalidCode {
+		return blobstore.ErrBlobCorrupted
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &ServerError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(message)),
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// Parse the Retry-After header given either in seconds or as the date,
+// 0 is returned if it's missing or invalid
+func retryAfter(header string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+func (h *HTTPBlobStorage) NewBlobWriter(blobId string) (writer blobstore.WriteFinalizeCanceler, err error) {
+	return &httpBlobWriter{storage: h, bid: blobId}, nil
+}
+
+func (h *HTTPBlobStorage) NewBlobReader(blobId string) (reader io.Reader, err error) {
+	resp, err := h.do("GET", blobId, nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.body(resp), nil
+}
+
+// Get the body of the response, failures of reading it are reported as
+// blobstore.StorageUnavailableError unless the request was aborted
+func (h *HTTPBlobStorage) body(resp *http.Response) io.ReadCloser {
+	return &responseBody{ReadCloser: resp.Body, ctx: h.ctx}
+}
+
+type responseBody struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (b *responseBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.ctx.Err() == nil {
+		err = &blobstore.StorageUnavailableError{Err: err}
+	}
+	return
+}
+
+// The range is requested with the Range header, the data before the
+// offset is dropped if the server sends the whole blob
+func (h *HTTPBlobStorage) NewBlobReaderRange(blobId string, offset, length int64) (io.Reader, error) {
+	if offset < 0 {
+		return nil, blobstore.ErrInvalidRange
+	}
+	if length == 0 {
+		exists, err := h.Exists(blobId)
+		if err == nil && !exists {
+			err = blobstore.ErrBIDNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(nil), nil
+	}
+
+	req, err := http.NewRequestWithContext(h.ctx, "GET", h.blobURL(blobId), nil)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	resp, err := h.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body := h.body(resp)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return body, nil
+
+	// The range starts past the end of the blob
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return bytes.NewReader(nil), nil
+
+	case http.StatusOK:
+		if _, err = io.CopyN(ioutil.Discard, body, offset); err != nil && err != io.EOF {
+			body.Close()
+			return nil, err
+		}
+		if length < 0 {
+			return body, nil
+		}
+		return &limitedBody{Reader: io.LimitReader(body, length), Closer: body}, nil
+	}
+
+	defer resp.Body.Close()
+	return nil, responseError(resp)
+}
+
+// Body of the response limited to the requested range
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func (h *HTTPBlobStorage) Exists(blobId string) (bool, error) {
+	resp, err := h.do("HEAD", blobId, nil)
+	if err == blobstore.ErrBIDNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Check whether the server keeps the blob until it's deleted
+func (h *HTTPBlobStorage) existsWithoutExpiry(blobId string) (bool, error) {
+	resp, err := h.do("HEAD", blobId, nil)
+	if err == blobstore.ErrBIDNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.Header.Get(expiresHeader) == "", nil
+}
+
+// The size and the modification time come from headers of the HEAD
+// response, the modification time has the precision of seconds. The blob
+// is read to find its size if the server does not send it.
+func (h *HTTPBlobStorage) Stat(blobId string) (blobstore.BlobStat, error) {
+	resp, err := h.do("HEAD", blobId, nil)
+	if err != nil {
+		return blobstore.BlobStat{}, err
+	}
+	resp.Body.Close()
+
+	stat := blobstore.BlobStat{Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		stat.ModTime = modTime
+	}
+	if stat.Size < 0 {
+		if resp, err = h.do("GET", blobId, nil); err != nil {
+			return blobstore.BlobStat{}, err
+		}
+		defer resp.Body.Close()
+		if stat.Size, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
+			return blobstore.BlobStat{}, err
+		}
+	}
+	return stat, nil
+}
+
+// Check existence of many blobs at once, blob ids are sent in batches
+// of up to MaxHaveBatch ids
+func (h *HTTPBlobStorage) ExistsBatch(blobIds []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	for len(blobIds) > 0 {
+		batch := blobIds
+		if len(batch) > MaxHaveBatch {
+			batch = batch[:MaxHaveBatch]
+		}
+		blobIds = blobIds[len(batch):]
+
+		req, err := http.NewRequestWithContext(h.ctx, "POST", h.baseURL+HavePath, strings.NewReader(strings.Join(batch, "\n")))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := h.send(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = responseError(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, bid := range strings.Fields(string(body)) {
+			existing[bid] = true
+		}
+	}
+	return existing, nil
+}
+
+func (h *HTTPBlobStorage) Delete(blobId string) error {
+	resp, err := h.do("DELETE", blobId, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Size of hash-validated blobs from which their existence is checked
+// before they're sent, the HEAD request is cheaper than the upload
+const existenceCheckSize = 64 * 1024
+
+// Writer buffering the blob, it's sent to the server on finalize.
+// Once the buffer of the hash-validated blob reaches existenceCheckSize
+// the server is asked whether it has the blob already, content of
+// existing blobs is then discarded and the blob is not sent. Blobs which
+// expire on the server are sent to clear their expiry.
+type httpBlobWriter struct {
+	storage   *HTTPBlobStorage
+	buffer    bytes.Buffer
+	bid       string
+	checked   bool // Existence of the blob has been checked
+	duplicate bool // Blob is already stored by the server
+}
+
+func (w *httpBlobWriter) Write(p []byte) (n int, err error) {
+	if w.duplicate {
+		return len(p), nil
+	}
+	n, err = w.buffer.Write(p)
+	if !w.checked && w.buffer.Len() >= existenceCheckSize {
+		w.checked = true
+
+		// Failed checks are ignored, the blob is just sent
+		if blobstore.IsHashValidatedBlob(w.buffer.Bytes()) {
+			if exists, _ := w.storage.existsWithoutExpiry(w.bid); exists {
+				w.duplicate = true
+				w.buffer = bytes.Buffer{}
+			}
+		}
+	}
+	return
+}
+
+func (w *httpBlobWriter) Finalize() (duplicate bool, err error) {
+	if w.duplicate {
+		return true, nil
+	}
+	resp, err := w.storage.do("PUT", w.bid, bytes.NewReader(w.buffer.Bytes()))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	// Server responds with 201 Created only if the blob was not there
+	return resp.StatusCode != http.StatusCreated, nil
+}
+
+func (w *httpBlobWriter) Cancel() error {
+	w.buffer.Reset()
+	return nil
+}
+
+func (w *httpBlobWriter) Close() error {
+	return w.Cancel()
+}
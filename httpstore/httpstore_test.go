@@ -0,0 +1,528 @@
+package httpstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Raw hash-validated blob of given size with its blob id
+func testBlob(size int, seed byte) (string, []byte) {
+	data := bytes.Repeat([]byte{seed}, size)
+	data[0] = 0x01
+	sum := sha512.Sum512(data[1:])
+	return hex.EncodeToString(sum[:]), data
+}
+
+func TestServerAndClient(t *testing.T) {
+
+	backend := blobstore.NewMemoryBlobStorage()
+	server := NewServer(backend)
+	server.AllowDelete = true
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	storage := NewHTTPBlobStorage(ts.URL, nil)
+
+	// Blobs created through the client land in the backend
+	data := bytes.Repeat([]byte("Hello World! "), 1000)
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write(data)
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+	if exists, _ := backend.Exists(bid); !exists {
+		t.Fatal("Blob not stored in the backend")
+	}
+
+	rdr, err := blobstore.OpenFileBlob(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatal("Invalid blob content read through the client")
+	}
+
+	if exists, err := storage.Exists(bid); err != nil || !exists {
+		t.Fatalf("Invalid existence of stored blob: %v, %v", exists, err)
+	}
+	if exists, err := storage.Exists("0a55"); err != nil || exists {
+		t.Fatalf("Invalid existence of missing blob: %v, %v", exists, err)
+	}
+	if _, err = storage.NewBlobReader("0a55"); err != blobstore.ErrBIDNotFound {
+		t.Fatalf("Invalid error for missing blob: %v", err)
+	}
+
+	expected, _ := blobstore.Stat(backend, bid)
+	if stat, err := storage.Stat(bid); err != nil || stat.Size != expected.Size || !stat.ModTime.IsZero() {
+		t.Fatalf("Invalid stat of the blob: %+v, %v", stat, err)
+	}
+	if _, err = storage.Stat("0a55"); err != blobstore.ErrBIDNotFound {
+		t.Fatalf("Invalid error for stat of missing blob: %v", err)
+	}
+
+	// Blobs already stored are reported as duplicates
+	raw, _ := backend.NewBlobReader(bid)
+	rawData, _ := ioutil.ReadAll(raw)
+
+	// Blob ids in paths may be encoded
+	encoded, _ := blobstore.FormatBID(bid, blobstore.EncodingBase58)
+	resp, err := http.Get(ts.URL + BlobPath + encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, rawData) {
+		t.Fatalf("Invalid response for the encoded blob id: %v", resp.Status)
+	}
+
+	writer, _ := storage.NewBlobWriter(bid)
+	writer.Write(rawData)
+	if duplicate, err := writer.Finalize(); err != nil || !duplicate {
+		t.Fatalf("Stored blob not reported as duplicate: %v, %v", duplicate, err)
+	}
+
+	// Blobs not matching the blob id are rejected
+	for _, content := range [][]byte{[]byte("different content"), rawData[:len(rawData)-1], nil} {
+		writer, _ = storage.NewBlobWriter(bid)
+		writer.Write(content)
+		if _, err = writer.Finalize(); !errors.Is(err, blobstore.ErrBlobCorrupted) {
+			t.Fatalf("Invalid error for blob not matching the blob id: %v", err)
+		}
+	}
+	if stored, _ := blobstore.Stat(backend, bid); stored.Size != int64(len(rawData)) {
+		t.Fatal("Stored blob replaced by the invalid one")
+	}
+	otherBid, _ := testBlob(10, 0)
+	writer, _ = storage.NewBlobWriter(otherBid)
+	writer.Write(rawData)
+	if _, err = writer.Finalize(); !errors.Is(err, blobstore.ErrBlobCorrupted) {
+		t.Fatalf("Invalid error for blob stored under other blob id: %v", err)
+	}
+	if exists, _ := backend.Exists(otherBid); exists {
+		t.Fatal("Invalid blob stored")
+	}
+
+	if err = storage.Delete(bid); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := backend.Exists(bid); exists {
+		t.Fatal("Blob not deleted from the backend")
+	}
+	if err = storage.Delete(bid); err != blobstore.ErrBIDNotFound {
+		t.Fatalf("Invalid error for deleting missing blob: %v", err)
+	}
+}
+
+func TestClientRangeReads(t *testing.T) {
+
+	backend := blobstore.NewMemoryBlobStorage()
+	writer, _ := backend.NewBlobWriter("b10b")
+	writer.Write([]byte("0123456789"))
+	writer.Finalize()
+
+	server := NewServer(backend)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// Servers ignoring the Range header send whole blobs
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Range")
+		server.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+
+	for _, url := range []string{ts.URL, plain.URL} {
+		storage := NewHTTPBlobStorage(url, nil)
+		if !blobstore.StorageCapabilities(storage).Has(blobstore.CapabilityRangeReads) {
+			t.Fatal("Range reads not reported by the client")
+		}
+
+		for _, d := range []struct {
+			offset, length int64
+			data           string
+		}{
+			{0, -1, "0123456789"},
+			{3, 4, "3456"},
+			{8, 10, "89"},
+			{5, 0, ""},
+			{12, -1, ""},
+		} {
+			reader, err := blobstore.NewBlobReaderRange(storage, "b10b", d.offset, d.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(reader)
+			closeReader(reader)
+			if err != nil || string(data) != d.data {
+				t.Fatalf("Invalid range %v+%v: %q %v", d.offset, d.length, data, err)
+			}
+		}
+
+		for _, length := range []int64{-1, 0, 5} {
+			if _, err := storage.NewBlobReaderRange("0a55", 0, length); err != blobstore.ErrBIDNotFound {
+				t.Fatalf("Invalid error for range of missing blob: %v", err)
+			}
+		}
+	}
+
+	// Only the range is sent by the server
+	req, _ := http.NewRequest("GET", ts.URL+BlobPath+"b10b", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "234" ||
+		resp.Header.Get("Content-Range") != "bytes 2-4/10" {
+		t.Fatalf("Invalid range response: %v %q %v", resp.StatusCode, body, resp.Header)
+	}
+}
+
+func TestReadOnlyServer(t *testing.T) {
+
+	backend := blobstore.NewMemoryBlobStorage()
+	server := NewServer(backend)
+	server.ReadOnly = true
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	storage := NewHTTPBlobStorage(ts.URL, nil)
+	writer, _ := storage.NewBlobWriter("0b1d")
+	writer.Write([]byte("data"))
+	_, err := writer.Finalize()
+	if serr, ok := err.(*ServerError); !ok || serr.StatusCode != 403 {
+		t.Fatalf("Invalid error for write to read-only server: %v", err)
+	}
+	if exists, _ := backend.Exists("0b1d"); exists {
+		t.Fatal("Blob written to read-only server")
+	}
+	if err = storage.Delete("0b1d"); err == nil {
+		t.Fatal("Blob deleted on read-only server")
+	}
+
+	// Read-only storage is reported the same way
+	server.ReadOnly = false
+	server.Storage = blobstore.NewReadOnlyStorage(backend)
+	writer, _ = storage.NewBlobWriter("0b1d")
+	writer.Write([]byte("data"))
+	if _, err = writer.Finalize(); err != blobstore.ErrReadOnly {
+		t.Fatalf("Invalid error for write to read-only storage: %v", err)
+	}
+}
+
+func TestPushTreeNegotiation(t *testing.T) {
+
+	server, puts := NewServer(blobstore.NewMemoryBlobStorage()), 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			puts++
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	storage := NewHTTPBlobStorage(ts.URL, nil)
+
+	local := blobstore.NewMemoryBlobStorage()
+	dir := blobstore.DirBlobWriter{Storage: local}
+	for i := 0; i < 3; i++ {
+		fw := blobstore.FileBlobWriter{Storage: local}
+		fw.Write(bytes.Repeat([]byte{byte(i)}, 100))
+		ref, _ := fw.Finalize()
+		bid, key := ref.Bid, ref.Key
+		dir.AddEntry(blobstore.DirEntry{Name: string('a' + rune(i)), Bid: bid, Key: key})
+	}
+	ref, err := dir.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+
+	pushed, skipped, err := blobstore.PushTree(local, storage, bid, key)
+	if err != nil || pushed != 4 || skipped != 0 {
+		t.Fatalf("Invalid first push: %v, %v, %v", pushed, skipped, err)
+	}
+
+	// Nothing is transferred again
+	puts = 0
+	pushed, skipped, err = blobstore.PushTree(local, storage, bid, key)
+	if err != nil || pushed != 0 || skipped != 4 || puts != 0 {
+		t.Fatalf("Invalid second push: %v, %v, %v, %v", pushed, skipped, puts, err)
+	}
+
+	existing, err := storage.ExistsBatch([]string{bid, "0a55"})
+	if err != nil || len(existing) != 1 || !existing[bid] {
+		t.Fatalf("Invalid existence check: %v, %v", existing, err)
+	}
+
+	// Batches are limited
+	resp, err := http.Post(ts.URL+HavePath, "text/plain",
+		strings.NewReader(strings.Repeat("bid\n", MaxHaveBatch+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Invalid status for too large batch: %v", resp.StatusCode)
+	}
+}
+
+func TestClientSkipsExistingBlobs(t *testing.T) {
+
+	server, puts, heads := NewServer(blobstore.NewMemoryBlobStorage()), 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PUT":
+			puts++
+		case "HEAD":
+			heads++
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	storage := NewHTTPBlobStorage(ts.URL, nil)
+
+	write := func(size int) bool {
+		bid, data := testBlob(size, 0)
+		writer, _ := storage.NewBlobWriter(bid)
+		for len(data) > 0 {
+			n := min(len(data), 1000)
+			writer.Write(data[:n])
+			data = data[n:]
+		}
+		duplicate, err := writer.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return duplicate
+	}
+
+	// Large hash-validated blobs are checked before they're sent
+	if write(existenceCheckSize*2) || puts != 1 || heads != 1 {
+		t.Fatalf("Invalid first upload: %v puts, %v heads", puts, heads)
+	}
+	if !write(existenceCheckSize*2) || puts != 1 || heads != 2 {
+		t.Fatalf("Existing blob sent again: %v puts, %v heads", puts, heads)
+	}
+
+	// Small blobs are just sent
+	if write(100) || !write(100) || puts != 3 || heads != 2 {
+		t.Fatalf("Invalid upload of the small blob: %v puts, %v heads", puts, heads)
+	}
+}
+
+func TestClientSendsExpiringBlobs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-httpstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := blobstore.NewFileBlobStorage(dir)
+	server, puts := NewServer(backend), 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			puts++
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	bid, data := testBlob(existenceCheckSize*2, 0)
+	writer, err := blobstore.NewBlobWriterWithOptions(backend, bid, blobstore.WriterOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	if _, err = writer.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The blob expiring on the server is sent again to be kept
+	writer, _ = NewHTTPBlobStorage(ts.URL, nil).NewBlobWriter(bid)
+	writer.Write(data)
+	if _, err = writer.Finalize(); err != nil || puts != 1 {
+		t.Fatalf("Expiring blob not sent: %v puts, %v", puts, err)
+	}
+	if expires, err := blobstore.BlobExpiry(backend, bid); err != nil || !expires.IsZero() {
+		t.Fatalf("Expiry of the sent blob not cleared: %v %v", expires, err)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+
+	started := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	storage := blobstore.WithContext(ctx, NewHTTPBlobStorage(ts.URL, nil))
+
+	done := make(chan error)
+	go func() {
+		_, err := storage.NewBlobReader("0b1d")
+		done <- err
+	}()
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) || errors.Is(err, blobstore.ErrStorageUnavailable) {
+			t.Fatalf("Invalid error of aborted request: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request not aborted")
+	}
+}
+
+func TestServerMaintenance(t *testing.T) {
+
+	backend := blobstore.NewMemoryBlobStorage()
+	server := NewServer(backend)
+	server.AllowDelete = true
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	storage := NewHTTPBlobStorage(ts.URL, nil)
+
+	writer, _ := backend.NewBlobWriter("0b1d")
+	writer.Write([]byte("data"))
+	writer.Finalize()
+
+	server.SetMaintenance(true)
+	if exists, err := storage.Exists("0b1d"); err != nil || !exists {
+		t.Fatalf("Reads not served in maintenance mode: %v, %v", exists, err)
+	}
+	writer, _ = storage.NewBlobWriter("0a01")
+	writer.Write([]byte("data"))
+	if _, err := writer.Finalize(); err != blobstore.ErrReadOnlyMaintenance {
+		t.Fatalf("Invalid error of write in maintenance mode: %v", err)
+	}
+	if err := storage.Delete("0b1d"); err != blobstore.ErrReadOnlyMaintenance {
+		t.Fatalf("Invalid error of delete in maintenance mode: %v", err)
+	}
+
+	// Maintenance mode of the storage is reported the same way
+	server.SetMaintenance(false)
+	maintained := blobstore.NewMaintenanceStorage(backend)
+	server.Storage = maintained
+	maintained.SetMaintenance(true)
+	if err := storage.Delete("0b1d"); err != blobstore.ErrReadOnlyMaintenance {
+		t.Fatalf("Invalid error of delete in storage maintenance mode: %v", err)
+	}
+	maintained.SetMaintenance(false)
+	if err := storage.Delete("0b1d"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientErrorCategories(t *testing.T) {
+
+	server := NewServer(blobstore.NewMemoryBlobStorage())
+	server.MaxBlobSize = 1024
+	ts := httptest.NewServer(server)
+	storage := NewHTTPBlobStorage(ts.URL, nil)
+
+	writer, _ := storage.NewBlobWriter("0a02")
+	writer.Write(make([]byte, 2048))
+	if _, err := writer.Finalize(); !errors.Is(err, blobstore.ErrBlobTooLarge) {
+		t.Fatalf("Invalid error of too large blob: %v", err)
+	}
+	small, data := testBlob(1024, 0)
+	writer, _ = storage.NewBlobWriter(small)
+	writer.Write(data)
+	if _, err := writer.Finalize(); err != nil {
+		t.Fatalf("Could not write the blob within the limit: %v", err)
+	}
+
+	overloaded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Overloaded", http.StatusServiceUnavailable)
+	}))
+	defer overloaded.Close()
+	_, err := NewHTTPBlobStorage(overloaded.URL, nil).NewBlobReader("0b1d")
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || !errors.Is(err, blobstore.ErrStorageUnavailable) {
+		t.Fatalf("Invalid error of overloaded server: %v", err)
+	}
+
+	// Connections broken in the middle of the blob
+	truncated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write(make([]byte, 10))
+	}))
+	defer truncated.Close()
+	reader, err := NewHTTPBlobStorage(truncated.URL, nil).NewBlobReader("0b1d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(reader); !errors.Is(err, blobstore.ErrStorageUnavailable) {
+		t.Fatalf("Invalid error of truncated response: %v", err)
+	}
+
+	ts.Close()
+	_, err = storage.NewBlobReader(small)
+	var unavailable *blobstore.StorageUnavailableError
+	if !errors.As(err, &unavailable) || !errors.Is(err, blobstore.ErrStorageUnavailable) {
+		t.Fatalf("Invalid error of unreachable server: %v", err)
+	}
+}
+
+func TestServerRejectsInvalidIds(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-httpstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := NewServer(blobstore.NewFileBlobStorage(dir))
+	server.AllowDelete = true
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	for _, method := range []string{"GET", "HEAD", "DELETE"} {
+		for _, bid := range []string{"", "..", "%2e%2e%2fsecret", ".expiry", ".writing-1", "0a%2f0b"} {
+			req, _ := http.NewRequest(method, ts.URL+BlobPath+bid, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("Invalid status of %v %q: %v", method, bid, resp.StatusCode)
+			}
+		}
+	}
+
+	resp, err := http.Post(ts.URL+HavePath, "text/plain", strings.NewReader("0a\n../secret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Invalid status of the existence check: %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpstore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Prefix of blob URLs
+const BlobPath = "/blob/"
+
+// URL of the batched existence check
+const HavePath = "/have"
+
+// Maximum number of blob ids in one existence check
+const MaxHaveBatch = 1024
+
+// Maximum size of the list of blob ids, blob ids are expected
+// to be hex-encoded SHA-512 hashes
+const maxHaveBodySize = MaxHaveBatch * (128 + 1)
+
+// Header carrying the code of the storage error, it lets clients
+// return the same error the storage did
+const errorHeader = "X-Cinode-Error"
+
+// Header carrying the time the blob expires at, it's sent in responses to
+// HEAD requests for blobs which expire. Clients send such blobs again
+// instead of skipping them so that the blob is kept until deleted.
+const expiresHeader = "X-Cinode-Expires"
+
+// Code of blobs rejected since they don't match their ids, it's not
+// in errorCodes since the error would also match stored colliding blobs
+const invalidCode = "invalid"
+
+// Errors passed between the server and the client by their codes
+var errorCodes = map[string]error{
+	"not-found":   blobstore.ErrBIDNotFound,
+	"collision":   blobstore.ErrBIDCollision,
+	"outdated":    blobstore.ErrSignedBlobOutdated,
+	"conflict":    blobstore.ErrSignedBlobConflict,
+	"maintenance": blobstore.ErrReadOnlyMaintenance,
+	"too-large":   blobstore.ErrBlobTooLarge,
+	"read-only":   blobstore.ErrReadOnly,
+}
+
+// Server exposing the storage over HTTP:
+//
+//	GET    /blob/{bid}  read the blob, a single range of bytes can be
+//	                    requested with the Range header
+//	HEAD   /blob/{bid}  check whether the blob exists, its size and the
+//	                    modification time are sent in headers
+//	PUT    /blob/{bid}  write the blob, 200 OK instead of 201 Created
+//	                    indicates the blob was already stored, blobs not
+//	                    matching the blob id are rejected with 400
+//	DELETE /blob/{bid}  delete the blob, only if enabled
+//	POST   /have        check which of the blob ids listed one per line
+//	                    in the body exist, those are sent back the same way
+//
+// Blob ids in paths may be given in any encoding accepted by
+// blobstore.ParseBID, blobs are stored under canonical hex ids.
+// Ids listed in /have requests must be hex ones. Requests with
+// other ids are rejected with 400.
+//
+// Storage operations are bound to the request context, work for requests
+// abandoned by clients is aborted. Writes and deletions are rejected with
+// 503 Service Unavailable while the server is in the maintenance mode.
+// Wrap the storage with blobstore.InstrumentedStorage to monitor operations
+// and bandwidth of the server.
+type Server struct {
+	Storage     blobstore.BlobStorage
+	ReadOnly    bool  // Reject writes and deletions
+	AllowDelete bool  // Accept deletions
+	MaxBlobSize int64 // Reject larger blobs with 413, no limit if 0
+
+	maintenance atomic.Bool
+}
+
+// Create new server of the storage
+func NewServer(storage blobstore.BlobStorage) *Server {
+	return &Server{Storage: storage}
+}
+
+// Turn the maintenance mode on or off, requests being processed are not
+// affected. Wrap the storage with blobstore.MaintenanceStorage to wait
+// for writes in progress.
+func (s *Server) SetMaintenance(on bool) {
+	s.maintenance.Store(on)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == HavePath {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.have(w, r, blobstore.WithContext(r.Context(), s.Storage))
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, BlobPath) {
+		http.NotFound(w, r)
+		return
+	}
+	bid, err := blobstore.ParseBID(r.URL.Path[len(BlobPath):])
+	if err != nil {
+		http.Error(w, "Invalid blob id", http.StatusBadRequest)
+		return
+	}
+
+	storage := blobstore.WithContext(r.Context(), s.Storage)
+
+	switch r.Method {
+	case "GET":
+		s.get(w, r, storage, bid)
+	case "HEAD":
+		s.head(w, storage, bid)
+	case "PUT":
+		if s.ReadOnly {
+			http.Error(w, "Storage is read-only", http.StatusForbidden)
+			return
+		}
+		if s.maintenance.Load() {
+			writeError(w, blobstore.ErrReadOnlyMaintenance)
+			return
+		}
+		s.put(w, r, storage, bid)
+	case "DELETE":
+		if s.ReadOnly || !s.AllowDelete {
+			http.Error(w, "Deleting blobs is not allowed", http.StatusForbidden)
+			return
+		}
+		if s.maintenance.Load() {
+			writeError(w, blobstore.ErrReadOnlyMaintenance)
+			return
+		}
+		s.delete(w, storage, bid)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, storage blobstore.BlobStorage, bid string) {
+	if offset, length, ok := parseRange(r.Header.Get("Range")); ok {
+		s.getRange(w, storage, bid, offset, length)
+		return
+	}
+
+	reader, err := storage.NewBlobReader(bid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer closeReader(reader)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+	io.Copy(w, reader)
+}
+
+// Only the requested part of the blob is read from the storage
+func (s *Server) getRange(w http.ResponseWriter, storage blobstore.BlobStorage, bid string, offset, length int64) {
+	stat, err := blobstore.Stat(storage, bid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if offset >= stat.Size {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", stat.Size))
+		http.Error(w, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if length < 0 || offset+length > stat.Size {
+		length = stat.Size - offset
+	}
+
+	reader, err := blobstore.NewBlobReaderRange(storage, bid, offset, length)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer closeReader(reader)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, stat.Size))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	io.Copy(w, reader)
+}
+
+// Parse the Range header with a single range of bytes, the length is
+// negative if the range is open. Other ranges are not supported, the whole
+// blob is sent for them.
+func parseRange(header string) (offset, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found || first == "" {
+		return 0, 0, false
+	}
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, false
+	}
+	if last == "" {
+		return offset, -1, true
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < offset {
+		return 0, 0, false
+	}
+	return offset, end - offset + 1, true
+}
+
+// Size, modification time and expiry of the blob are sent in headers
+func (s *Server) head(w http.ResponseWriter, storage blobstore.BlobStorage, bid string) {
+	stat, err := blobstore.Stat(storage, bid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if expires, err := blobstore.BlobExpiry(storage, bid); err == nil && !expires.IsZero() {
+		w.Header().Set(expiresHeader, expires.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(stat.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if !stat.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", stat.ModTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, storage blobstore.BlobStorage, bid string) {
+	if s.MaxBlobSize > 0 {
+		if r.ContentLength > s.MaxBlobSize {
+			writeError(w, &blobstore.BlobTooLargeError{Bid: bid, Limit: s.MaxBlobSize})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.MaxBlobSize)
+	}
+
+	writer, err := storage.NewBlobWriter(bid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Blobs not matching their ids are never stored, they would
+	// prevent storing the valid blob of the same id
+	verifier := blobstore.NewBlobVerifier(bid)
+	_, err = io.Copy(io.MultiWriter(writer, verifier), r.Body)
+	verifyErr := verifier.Close()
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writer.Cancel()
+		writeError(w, &blobstore.BlobTooLargeError{Bid: bid, Limit: s.MaxBlobSize})
+		return
+	case err != nil && err != verifyErr:
+		writer.Cancel()
+		http.Error(w, "Could not read the blob", http.StatusBadRequest)
+		return
+	case verifyErr != nil:
+		writer.Cancel()
+		w.Header().Set(errorHeader, invalidCode)
+		http.Error(w, verifyErr.Error(), http.StatusBadRequest)
+		return
+	}
+	duplicate, err := writer.Finalize()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if duplicate {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) have(w http.ResponseWriter, r *http.Request, storage blobstore.BlobStorage) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxHaveBodySize+1))
+	if err != nil {
+		http.Error(w, "Could not read the list of blob ids", http.StatusBadRequest)
+		return
+	}
+	bids := strings.Fields(string(body))
+	if len(body) > maxHaveBodySize || len(bids) > MaxHaveBatch {
+		http.Error(w, "Too many blob ids", http.StatusRequestEntityTooLarge)
+		return
+	}
+	for _, bid := range bids {
+		if canonical, err := blobstore.ParseBID(bid); err != nil || canonical != bid {
+			http.Error(w, "Invalid blob id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	existing, err := blobstore.ExistsBatch(storage, bids)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	for _, bid := range bids {
+		if existing[bid] {
+			io.WriteString(w, bid+"\n")
+		}
+	}
+}
+
+func (s *Server) delete(w http.ResponseWriter, storage blobstore.BlobStorage, bid string) {
+	if err := storage.Delete(bid); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	for code, e := range errorCodes {
+		if errors.Is(err, e) {
+			w.Header().Set(errorHeader, code)
+			switch e {
+			case blobstore.ErrBIDNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case blobstore.ErrReadOnlyMaintenance:
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			case blobstore.ErrBlobTooLarge:
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			case blobstore.ErrReadOnly:
+				http.Error(w, err.Error(), http.StatusForbidden)
+			default:
+				http.Error(w, err.Error(), http.StatusConflict)
+			}
+			return
+		}
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func closeReader(reader io.Reader) {
+	if c, ok := reader.(io.Closer); ok {
+		c.Close()
+	}
+}
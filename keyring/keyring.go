@@ -0,0 +1,424 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package keyring keeps private keys of the user in a local file encrypted
+// under a passphrase. Signing keys of signed blobs and master keys wrapping
+// blob keys are stored by name, the key of the file is derived from the
+// passphrase with Argon2id and the content is sealed with AES-256-GCM.
+package keyring
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/cipherfactory"
+	"golang.org/x/crypto/argon2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrInvalidKeyring     = errors.New("Invalid keyring file")
+	ErrWrongPassphrase    = errors.New("Keyring can't be opened with given passphrase")
+	ErrInvalidParams      = errors.New("Invalid key derivation parameters")
+	ErrKeyExists          = errors.New("Key with given name already exists in the keyring")
+	ErrKeyNotFound        = errors.New("Key not found in the keyring")
+	ErrWrongKeyType       = errors.New("Key of given name is of different type")
+	ErrUnsupportedKey     = errors.New("Unsupported signing key - Ed25519 and RSA keys are supported")
+	ErrInvalidKeyName     = errors.New("Invalid key name")
+	ErrInvalidMasterKey   = cipherfactory.ErrInvalidMasterKey
+	ErrUnsupportedVersion = errors.New("Unsupported version of the keyring file")
+)
+
+// Parameters of Argon2id deriving the key of the keyring from the passphrase,
+// parameters are stored in the file
+type Params struct {
+	Time    uint32 `json:"time"`    // Number of passes over the memory
+	Memory  uint32 `json:"memory"`  // Memory in KiB
+	Threads uint8  `json:"threads"` // Degree of parallelism
+}
+
+// Parameters used by Create, the second recommended option of RFC 9106
+var DefaultParams = Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// Largest parameters accepted by CreateWithParams and Open, parameters
+// of keyring files are checked before the key is derived so that forged
+// files can't exhaust memory or time
+var MaxParams = Params{Time: 64, Memory: 4 * 1024 * 1024, Threads: 64}
+
+// Check the parameters are within limits of Argon2id and MaxParams
+func (p Params) valid() bool {
+	return p.Time >= 1 && p.Time <= MaxParams.Time &&
+		p.Threads >= 1 && p.Threads <= MaxParams.Threads &&
+		p.Memory >= 8*uint32(p.Threads) && p.Memory <= MaxParams.Memory
+}
+
+const (
+	keyringVersion = 1
+	kdfArgon2id    = "argon2id"
+	saltSize       = 16
+	fileKeySize    = 32
+
+	// Types of stored keys
+	keySigning = "signing"
+	keyMaster  = "master"
+)
+
+// Unencrypted header of the keyring file, the header is authenticated
+// together with the content
+type header struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Params  Params `json:"params"`
+}
+
+// Keyring file: the header, the nonce and the sealed JSON list of keys
+type file struct {
+	header
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// Key stored in the keyring, signing keys are kept in the PKCS#8 form
+type entry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Key  []byte `json:"key"`
+}
+
+// Keyring opened with the passphrase, changes are written to the file
+// immediately. Methods can be used by concurrent goroutines.
+type Keyring struct {
+	path    string
+	header  header
+	aead    cipher.AEAD
+	lock    sync.Mutex
+	entries map[string]entry
+}
+
+// Create new empty keyring file with DefaultParams, the file must not exist
+func Create(path, passphrase string) (*Keyring, error) {
+	return CreateWithParams(path, passphrase, DefaultParams)
+}
+
+// Create new empty keyring file deriving its key with given parameters,
+// the file must not exist
+func CreateWithParams(path, passphrase string, params Params) (*Keyring, error) {
+	if !params.valid() {
+		return nil, ErrInvalidParams
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{
+		path:    path,
+		header:  header{Version: keyringVersion, KDF: kdfArgon2id, Salt: salt, Params: params},
+		entries: make(map[string]entry),
+	}
+	if err := k.deriveKey(passphrase); err != nil {
+		return nil, err
+	}
+
+	// The file is created first so that an existing keyring is never replaced
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err = k.save(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return k, nil
+}
+
+// Open the keyring file, ErrWrongPassphrase is returned if the content
+// can't be decrypted with the key derived from the passphrase
+func Open(path, passphrase string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, ErrInvalidKeyring
+	}
+	if f.Version != keyringVersion || f.KDF != kdfArgon2id {
+		return nil, ErrUnsupportedVersion
+	}
+	if len(f.Salt) == 0 {
+		return nil, ErrInvalidKeyring
+	}
+	if !f.Params.valid() {
+		return nil, ErrInvalidParams
+	}
+
+	k := &Keyring{path: path, header: f.header}
+	if err = k.deriveKey(passphrase); err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != k.aead.NonceSize() {
+		return nil, ErrInvalidKeyring
+	}
+	plain, err := k.aead.Open(nil, f.Nonce, f.Data, k.additionalData())
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var entries []entry
+	if err = json.Unmarshal(plain, &entries); err != nil {
+		return nil, ErrInvalidKeyring
+	}
+	k.entries = make(map[string]entry, len(entries))
+	for _, e := range entries {
+		k.entries[e.Name] = e
+	}
+	return k, nil
+}
+
+// Get names of all keys in the keyring, sorted
+func (k *Keyring) Names() []string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	names := make([]string, 0, len(k.entries))
+	for name := range k.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Add the private key signing blobs, *rsa.PrivateKey and ed25519.PrivateKey
+// are supported
+func (k *Keyring) AddKey(name string, privKey crypto.Signer) error {
+	switch privKey.(type) {
+	case *rsa.PrivateKey, ed25519.PrivateKey:
+	default:
+		return ErrUnsupportedKey
+	}
+	data, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return err
+	}
+	return k.add(entry{Name: name, Type: keySigning, Key: data})
+}
+
+// Generate new Ed25519 signing key stored under given name
+func (k *Keyring) GenerateKey(name string) (crypto.PublicKey, error) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err = k.AddKey(name, privKey); err != nil {
+		return nil, err
+	}
+	return pubKey, nil
+}
+
+// Get the signing key of given name
+func (k *Keyring) Signer(name string) (crypto.Signer, error) {
+	e, err := k.get(name, keySigning)
+	if err != nil {
+		return nil, err
+	}
+	privKey, err := x509.ParsePKCS8PrivateKey(e.Key)
+	if err != nil {
+		return nil, ErrInvalidKeyring
+	}
+	switch privKey := privKey.(type) {
+	case *rsa.PrivateKey:
+		return privKey, nil
+	case ed25519.PrivateKey:
+		return privKey, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// Create new version of the signed blob with the signing key of given name,
+// see blobstore.CreateSignedBlob
+func (k *Keyring) Sign(name string, version int64, content []byte, storage blobstore.BlobStorage) (bid, key string, err error) {
+	privKey, err := k.Signer(name)
+	if err != nil {
+		return "", "", err
+	}
+	return blobstore.CreateSignedBlob(privKey, version, content, storage)
+}
+
+// Add the master key of cipherfactory.MasterKeySize bytes wrapping blob keys
+func (k *Keyring) AddMasterKey(name string, masterKey []byte) error {
+	if len(masterKey) != cipherfactory.MasterKeySize {
+		return ErrInvalidMasterKey
+	}
+	return k.add(entry{Name: name, Type: keyMaster, Key: append([]byte(nil), masterKey...)})
+}
+
+// Generate new random master key stored under given name
+func (k *Keyring) GenerateMasterKey(name string) ([]byte, error) {
+	masterKey := make([]byte, cipherfactory.MasterKeySize)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, err
+	}
+	if err := k.AddMasterKey(name, masterKey); err != nil {
+		return nil, err
+	}
+	return masterKey, nil
+}
+
+// Get the master key of given name, see cipherfactory.WrapKey
+func (k *Keyring) MasterKey(name string) ([]byte, error) {
+	e, err := k.get(name, keyMaster)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), e.Key...), nil
+}
+
+// Remove the key of given name
+func (k *Keyring) Remove(name string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	e, ok := k.entries[name]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	delete(k.entries, name)
+	if err := k.save(); err != nil {
+		k.entries[name] = e
+		return err
+	}
+	return nil
+}
+
+// Encrypt the keyring under the new passphrase, new salt is generated
+// and parameters of the key derivation are kept
+func (k *Keyring) ChangePassphrase(passphrase string) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	oldHeader, oldAEAD := k.header, k.aead
+	k.header.Salt = salt
+	err := k.deriveKey(passphrase)
+	if err == nil {
+		err = k.save()
+	}
+	if err != nil {
+		k.header, k.aead = oldHeader, oldAEAD
+	}
+	return err
+}
+
+func (k *Keyring) add(e entry) error {
+	if e.Name == "" {
+		return ErrInvalidKeyName
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if _, ok := k.entries[e.Name]; ok {
+		return ErrKeyExists
+	}
+	k.entries[e.Name] = e
+	if err := k.save(); err != nil {
+		delete(k.entries, e.Name)
+		return err
+	}
+	return nil
+}
+
+func (k *Keyring) get(name, keyType string) (entry, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	e, ok := k.entries[name]
+	if !ok {
+		return entry{}, ErrKeyNotFound
+	}
+	if e.Type != keyType {
+		return entry{}, ErrWrongKeyType
+	}
+	return e, nil
+}
+
+func (k *Keyring) deriveKey(passphrase string) error {
+	p := k.header.Params
+	key := argon2.IDKey([]byte(passphrase), k.header.Salt, p.Time, p.Memory, p.Threads, fileKeySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	k.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// The header is bound to the content, parameters can't be changed
+// without the passphrase
+func (k *Keyring) additionalData() []byte {
+	data, _ := json.Marshal(k.header)
+	return data
+}
+
+// Write the keyring to the file, the file is replaced atomically.
+// The lock must be held unless the keyring is being created.
+func (k *Keyring) save() error {
+	entries := make([]entry, 0, len(k.entries))
+	for _, e := range k.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(file{
+		header: k.header,
+		Nonce:  nonce,
+		Data:   k.aead.Seal(nil, nonce, plain, k.additionalData()),
+	})
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(k.path), ".keyring")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), k.path)
+}
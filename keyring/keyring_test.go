@@ -0,0 +1,221 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/cipherfactory"
+	"golang.org/x/crypto/argon2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Cheap parameters, keys derived in tests don't need to be strong
+var testParams = Params{Time: 1, Memory: 64, Threads: 2}
+
+func TestArgon2id(t *testing.T) {
+	// Keys derived before must stay the same so that existing keyrings open
+	key := argon2.IDKey([]byte("secret"), []byte("0123456789abcdef"), testParams.Time, testParams.Memory, testParams.Threads, fileKeySize)
+	expected := "65e61e167b0781574aa32dbe0fae2630eacadc3a8af6d37d4ff65cd3a392e981"
+	if hex.EncodeToString(key) != expected {
+		t.Fatalf("Invalid Argon2id key: %x, expected %s", key, expected)
+	}
+}
+
+func createTestKeyring(t *testing.T) (*Keyring, string) {
+	path := filepath.Join(t.TempDir(), "keyring")
+	k, err := CreateWithParams(path, "secret", testParams)
+	if err != nil {
+		t.Fatalf("Couldn't create the keyring: %v", err)
+	}
+	return k, path
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	k, path := createTestKeyring(t)
+
+	pubKey, err := k.GenerateKey("signing")
+	if err != nil {
+		t.Fatalf("Couldn't generate the signing key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.AddKey("rsa", rsaKey); err != nil {
+		t.Fatalf("Couldn't add the RSA key: %v", err)
+	}
+	masterKey, err := k.GenerateMasterKey("master")
+	if err != nil {
+		t.Fatalf("Couldn't generate the master key: %v", err)
+	}
+
+	k2, err := Open(path, "secret")
+	if err != nil {
+		t.Fatalf("Couldn't open the keyring: %v", err)
+	}
+	if names := k2.Names(); !reflect.DeepEqual(names, []string{"master", "rsa", "signing"}) {
+		t.Fatalf("Invalid names of keys: %v", names)
+	}
+	signer, err := k2.Signer("signing")
+	if err != nil {
+		t.Fatalf("Couldn't get the signing key: %v", err)
+	}
+	if !reflect.DeepEqual(signer.Public(), pubKey) {
+		t.Fatalf("Signing key changed after reopening the keyring")
+	}
+	if signer, err = k2.Signer("rsa"); err != nil || !rsaKey.Equal(signer) {
+		t.Fatalf("RSA key changed after reopening the keyring: %v", err)
+	}
+	key, err := k2.MasterKey("master")
+	if err != nil || !bytes.Equal(key, masterKey) {
+		t.Fatalf("Master key changed after reopening the keyring: %v", err)
+	}
+
+	// The master key wraps blob keys
+	blobKey := "01" + hex.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+	envelope, err := cipherfactory.WrapKey(blobKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped, err := cipherfactory.UnwrapKey(envelope, masterKey); err != nil || unwrapped != blobKey {
+		t.Fatalf("Couldn't unwrap the key with the master key: %v", err)
+	}
+}
+
+func TestKeyringSign(t *testing.T) {
+	k, _ := createTestKeyring(t)
+	pubKey, err := k.GenerateKey("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := blobstore.NewMemoryBlobStorage()
+	bid, key, err := k.Sign("site", 7, []byte("Hello World!"), storage)
+	if err != nil {
+		t.Fatalf("Couldn't create the signed blob: %v", err)
+	}
+	if expected, _ := blobstore.SignedBlobId(pubKey); bid != expected {
+		t.Fatalf("Blob id of the signed blob does not match the public key")
+	}
+
+	version, content, err := blobstore.OpenSignedBlob(bid, key, storage)
+	if err != nil {
+		t.Fatalf("Couldn't open the signed blob: %v", err)
+	}
+	data, err := ioutil.ReadAll(content)
+	if err != nil || version != 7 || string(data) != "Hello World!" {
+		t.Fatalf("Invalid signed blob: version %d, content %q, error %v", version, data, err)
+	}
+
+	if _, _, err = k.Sign("missing", 1, nil, storage); err != ErrKeyNotFound {
+		t.Fatalf("Signing with missing key must fail with ErrKeyNotFound, got: %v", err)
+	}
+}
+
+func TestKeyringErrors(t *testing.T) {
+	k, path := createTestKeyring(t)
+	if _, err := k.GenerateMasterKey("master"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateWithParams(path, "secret", testParams); !os.IsExist(err) {
+		t.Fatalf("Existing keyring must not be replaced, got: %v", err)
+	}
+	if _, err := CreateWithParams(path+"2", "secret", Params{}); err != ErrInvalidParams {
+		t.Fatalf("Invalid parameters must be rejected, got: %v", err)
+	}
+	if _, err := Open(path, "wrong"); err != ErrWrongPassphrase {
+		t.Fatalf("Opening with wrong passphrase must fail with ErrWrongPassphrase, got: %v", err)
+	}
+	if err := k.AddMasterKey("master", make([]byte, 32)); err != ErrKeyExists {
+		t.Fatalf("Adding duplicate key must fail with ErrKeyExists, got: %v", err)
+	}
+	if err := k.AddMasterKey("short", make([]byte, 16)); err != ErrInvalidMasterKey {
+		t.Fatalf("Short master key must be rejected, got: %v", err)
+	}
+	if _, err := k.GenerateKey(""); err != ErrInvalidKeyName {
+		t.Fatalf("Empty key name must be rejected, got: %v", err)
+	}
+	if _, err := k.Signer("master"); err != ErrWrongKeyType {
+		t.Fatalf("Master key must not be used for signing, got: %v", err)
+	}
+	if _, err := k.MasterKey("missing"); err != ErrKeyNotFound {
+		t.Fatalf("Missing key must not be found, got: %v", err)
+	}
+
+	// Modified headers are detected
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := bytes.Replace(data, []byte(`"time":1`), []byte(`"time":2`), 1)
+	if bytes.Equal(modified, data) {
+		t.Fatalf("Couldn't find parameters in the keyring file")
+	}
+	if err = ioutil.WriteFile(path, modified, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(path, "secret"); err != ErrWrongPassphrase {
+		t.Fatalf("Modified header must be detected, got: %v", err)
+	}
+
+	// Parameters above the limits are rejected before the key is derived
+	for _, param := range []struct{ from, to string }{
+		{`"time":1`, `"time":1000000`},
+		{`"memory":64`, `"memory":4294967295`},
+		{`"threads":2`, `"threads":255`},
+	} {
+		forged := bytes.Replace(data, []byte(param.from), []byte(param.to), 1)
+		if bytes.Equal(forged, data) {
+			t.Fatalf("Couldn't find %s in the keyring file", param.from)
+		}
+		if err = ioutil.WriteFile(path, forged, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = Open(path, "secret"); err != ErrInvalidParams {
+			t.Fatalf("Parameters %s must be rejected, got: %v", param.to, err)
+		}
+	}
+	if _, err := CreateWithParams(path+"3", "secret", Params{Time: 1, Memory: MaxParams.Memory + 1, Threads: 1}); err != ErrInvalidParams {
+		t.Fatalf("Too large parameters must be rejected, got: %v", err)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(path, "secret"); err != ErrInvalidKeyring {
+		t.Fatalf("Garbage must not be opened, got: %v", err)
+	}
+}
+
+func TestKeyringChangePassphrase(t *testing.T) {
+	k, path := createTestKeyring(t)
+	if _, err := k.GenerateKey("signing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.ChangePassphrase("new secret"); err != nil {
+		t.Fatalf("Couldn't change the passphrase: %v", err)
+	}
+	if _, err := Open(path, "secret"); err != ErrWrongPassphrase {
+		t.Fatalf("Old passphrase must not open the keyring, got: %v", err)
+	}
+	k2, err := Open(path, "new secret")
+	if err != nil {
+		t.Fatalf("Couldn't open the keyring with new passphrase: %v", err)
+	}
+	if err = k2.Remove("signing"); err != nil {
+		t.Fatalf("Couldn't remove the key: %v", err)
+	}
+	if err = k2.Remove("signing"); err != ErrKeyNotFound {
+		t.Fatalf("Removed key must not be found, got: %v", err)
+	}
+	if k3, err := Open(path, "new secret"); err != nil || len(k3.Names()) != 0 {
+		t.Fatalf("Removed key must not be stored: %v", err)
+	}
+}
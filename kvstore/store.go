@@ -0,0 +1,246 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package kvstore implements the key-value store kept in a LevelDB
+// database. Used with blobstore.NewKeyValueBlobStorage it holds millions
+// of small blobs without a file per blob.
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/journal"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrClosed    = errors.New("Key-value store is closed")
+	ErrCorrupted = errors.New("Key-value store is corrupted")
+)
+
+// Options of the store
+type Options struct {
+
+	// Sync the journal after each change, changes made right before
+	// a crash of the machine are lost otherwise
+	SyncWrites bool
+}
+
+// Key-value store kept in the LevelDB database. Checksums of the journal
+// and of tables are verified. The journal record torn by a crash is dropped
+// when the store is opened, the store is not opened if records before it
+// are damaged - ErrCorrupted is returned then.
+type Store struct {
+	db      *leveldb.DB
+	options Options
+}
+
+// Open the store in given directory, it's created if it does not exist
+func Open(path string, options Options) (*Store, error) {
+	err := dropTornJournalTail(path)
+	var db *leveldb.DB
+	if err == nil {
+		db, err = leveldb.OpenFile(path, &opt.Options{
+			Strict: opt.DefaultStrict | opt.StrictJournal | opt.StrictManifest,
+		})
+	}
+	if lerrors.IsCorrupted(err) {
+		return nil, &CorruptedError{Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db, options: options}, nil
+}
+
+// Error of the store which can't be opened because of damaged records
+type CorruptedError struct {
+	Err error // Cause of the error
+}
+
+func (e *CorruptedError) Error() string {
+	return "Key-value store is corrupted: " + e.Err.Error()
+}
+
+func (e *CorruptedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CorruptedError) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+// Drop the last record of the newest journal if the end of the journal
+// falls inside it. Records are only appended to the newest journal, any
+// other damage is left to be reported when the database is opened.
+func dropTornJournalTail(path string) error {
+	stor, err := storage.OpenFile(path, false)
+	if err != nil {
+		return err
+	}
+	defer stor.Close()
+
+	fds, err := stor.List(storage.TypeJournal)
+	if err != nil || len(fds) == 0 {
+		return err
+	}
+	newest := fds[0]
+	for _, fd := range fds {
+		if fd.Num > newest.Num {
+			newest = fd
+		}
+	}
+
+	file, err := stor.Open(newest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Records are copied until the first one which can't be read
+	counter := &countingReader{reader: file}
+	reader := journal.NewReader(counter, nil, true, true)
+	var records bytes.Buffer
+	writer := journal.NewWriter(&records)
+	for {
+		var data []byte
+		record, err := reader.Next()
+		if err == nil {
+			data, err = ioutil.ReadAll(record)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if !isTornTail(err, counter.count == size) {
+				return err
+			}
+			break
+		}
+		w, err := writer.Next()
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	// Journal is replaced atomically
+	journalPath := filepath.Join(path, newest.String())
+	temp, err := ioutil.TempFile(path, newest.String()+".")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(records.Bytes())
+	if err == nil {
+		err = temp.Sync()
+	}
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), journalPath)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// Check whether the journal error is caused by the record which is cut
+// off by the end of the journal, the end must be within the current block
+func isTornTail(err error, lastBlock bool) bool {
+	var corrupted *lerrors.ErrCorrupted
+	var chunk *journal.ErrCorrupted
+	if !lastBlock || !errors.As(err, &corrupted) || !errors.As(corrupted.Err, &chunk) {
+		return false
+	}
+	return chunk.Reason == "chunk length overflows block" || chunk.Reason == "missing chunk part"
+}
+
+// Reader counting bytes read from the underlying reader
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+func (s *Store) Get(key []byte) ([]byte, bool, error) {
+	value, err := s.db.Get(key, nil)
+	switch err {
+	case nil:
+		return value, true, nil
+	case leveldb.ErrNotFound:
+		return nil, false, nil
+	}
+	return nil, false, s.mapError(err)
+}
+
+// The value is not copied out of the database
+func (s *Store) Has(key []byte) (bool, error) {
+	found, err := s.db.Has(key, nil)
+	return found, s.mapError(err)
+}
+
+func (s *Store) Put(key, value []byte) error {
+	return s.mapError(s.db.Put(key, value, &opt.WriteOptions{Sync: s.options.SyncWrites}))
+}
+
+func (s *Store) Delete(key []byte) error {
+	return s.mapError(s.db.Delete(key, &opt.WriteOptions{Sync: s.options.SyncWrites}))
+}
+
+// Keys are read from the snapshot taken when the scan starts,
+// fn may use the store
+func (s *Store) Scan(start []byte, fn func(key []byte, size int64) bool) error {
+	iter := s.db.NewIterator(&util.Range{Start: start}, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if !fn(append([]byte{}, iter.Key()...), int64(len(iter.Value()))) {
+			break
+		}
+	}
+	return s.mapError(iter.Error())
+}
+
+// Reclaim space of overwritten and deleted values
+func (s *Store) Compact() error {
+	return s.mapError(s.db.CompactRange(util.Range{}))
+}
+
+// Close the store, it can't be used afterwards
+func (s *Store) Close() error {
+	return s.mapError(s.db.Close())
+}
+
+func (s *Store) mapError(err error) error {
+	if err == leveldb.ErrClosed {
+		return ErrClosed
+	}
+	return err
+}
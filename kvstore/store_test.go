@@ -0,0 +1,208 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func openTestStore(t *testing.T, path string) *Store {
+	s, err := Open(path, Options{SyncWrites: true})
+	if err != nil {
+		t.Fatalf("Couldn't open the store: %v", err)
+	}
+	return s
+}
+
+func scanAll(t *testing.T, s *Store, start string) []string {
+	var keys []string
+	err := s.Scan([]byte(start), func(key []byte, size int64) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Couldn't scan the store: %v", err)
+	}
+	return keys
+}
+
+func expectValue(t *testing.T, s *Store, key, value string, found bool) {
+	data, ok, err := s.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("Couldn't get %q: %v", key, err)
+	}
+	if ok != found || string(data) != value {
+		t.Fatalf("Invalid value of %q: %q %v, expected %q %v", key, data, ok, value, found)
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+
+	s := openTestStore(t, path)
+	for _, kv := range [][2]string{{"b", "2"}, {"a", "1"}, {"c", "3"}, {"b", "22"}} {
+		if err := s.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("Couldn't put %q: %v", kv[0], err)
+		}
+	}
+	if err := s.Delete([]byte("c")); err != nil {
+		t.Fatalf("Couldn't delete: %v", err)
+	}
+	if err := s.Delete([]byte("missing")); err != nil {
+		t.Fatalf("Deleting missing key failed: %v", err)
+	}
+
+	expectValue(t, s, "a", "1", true)
+	expectValue(t, s, "b", "22", true)
+	expectValue(t, s, "c", "", false)
+	if found, err := s.Has([]byte("a")); err != nil || !found {
+		t.Fatalf("Existing key not found: %v", err)
+	}
+	if found, err := s.Has([]byte("c")); err != nil || found {
+		t.Fatalf("Deleted key found: %v", err)
+	}
+	if keys := scanAll(t, s, ""); len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("Invalid scan result: %v", keys)
+	}
+	if keys := scanAll(t, s, "a\x00"); len(keys) != 1 || keys[0] != "b" {
+		t.Fatalf("Invalid scan result from the start key: %v", keys)
+	}
+
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Couldn't compact the store: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Couldn't close the store: %v", err)
+	}
+	if _, _, err := s.Get([]byte("a")); err != ErrClosed {
+		t.Fatalf("Invalid error of the closed store: %v", err)
+	}
+
+	s = openTestStore(t, path)
+	defer s.Close()
+	expectValue(t, s, "a", "1", true)
+	expectValue(t, s, "b", "22", true)
+	if keys := scanAll(t, s, ""); len(keys) != 2 {
+		t.Fatalf("Invalid keys after reopening: %v", keys)
+	}
+}
+
+// Get the path of the newest journal of the store
+func journalPath(t *testing.T, path string) string {
+	journals, _ := filepath.Glob(filepath.Join(path, "*.log"))
+	if len(journals) == 0 {
+		t.Fatal("Journal of the store not found")
+	}
+	return journals[len(journals)-1]
+}
+
+func TestStoreTornJournalTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := openTestStore(t, path)
+	s.Put([]byte("a"), []byte("1"))
+	s.Put([]byte("b"), bytes.Repeat([]byte("2"), 1000))
+	s.Close()
+
+	// The end of the journal falls inside the last record
+	journal := journalPath(t, path)
+	data, _ := ioutil.ReadFile(journal)
+	ioutil.WriteFile(journal, data[:len(data)-10], 0666)
+
+	s = openTestStore(t, path)
+	expectValue(t, s, "a", "1", true)
+	expectValue(t, s, "b", "", false)
+	if err := s.Put([]byte("c"), []byte("3")); err != nil {
+		t.Fatalf("Couldn't put after the torn record: %v", err)
+	}
+	s.Close()
+
+	s = openTestStore(t, path)
+	defer s.Close()
+	expectValue(t, s, "a", "1", true)
+	expectValue(t, s, "c", "3", true)
+}
+
+func TestStoreCorruptedJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := openTestStore(t, path)
+	s.Put([]byte("a"), bytes.Repeat([]byte("1"), 1000))
+	s.Put([]byte("b"), []byte("2"))
+	s.Close()
+
+	// Damaged record followed by other records is not dropped
+	journal := journalPath(t, path)
+	data, _ := ioutil.ReadFile(journal)
+	data[100] ^= 0xFF
+	ioutil.WriteFile(journal, data, 0666)
+
+	if _, err := Open(path, Options{}); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Store with the corrupted journal opened: %v", err)
+	}
+	if corrupted, _ := ioutil.ReadFile(journal); !bytes.Equal(corrupted, data) {
+		t.Fatal("Corrupted journal has been changed")
+	}
+}
+
+// Store counting reads of values
+type getCountingStore struct {
+	*Store
+	gets int
+}
+
+func (g *getCountingStore) Get(key []byte) ([]byte, bool, error) {
+	g.gets++
+	return g.Store.Get(key)
+}
+
+func TestKeyValueBlobStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := openTestStore(t, path)
+	storage := blobstore.NewKeyValueBlobStorage(s)
+
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatalf("Couldn't store the file: %v", err)
+	}
+	s.Close()
+
+	s = openTestStore(t, path)
+	defer s.Close()
+	storage = blobstore.NewKeyValueBlobStorage(s)
+	reader, err := blobstore.OpenFileBlob(ref.Bid, ref.Key, storage)
+	if err != nil {
+		t.Fatalf("Couldn't open the file: %v", err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil || string(data) != "Hello World!" {
+		t.Fatalf("Invalid file content: %q %v", data, err)
+	}
+
+	// Blobs are checked without reading their content
+	counting := &getCountingStore{Store: s}
+	storage = blobstore.NewKeyValueBlobStorage(counting)
+	if exists, err := storage.Exists(ref.Bid); err != nil || !exists {
+		t.Fatalf("Stored blob not found: %v", err)
+	}
+	if exists, err := storage.Exists("0000"); err != nil || exists {
+		t.Fatalf("Missing blob found: %v", err)
+	}
+	if counting.gets != 0 {
+		t.Fatalf("Values read to check blobs: %v", counting.gets)
+	}
+
+	blobs, _, err := blobstore.ListBlobs(storage, "", "", 0)
+	if err != nil || len(blobs) != 1 || blobs[0].Bid != ref.Bid {
+		t.Fatalf("Invalid list of blobs: %v %v", blobs, err)
+	}
+	if err = storage.Delete(ref.Bid); err != nil {
+		t.Fatalf("Couldn't delete the blob: %v", err)
+	}
+	if err = storage.Delete(ref.Bid); err != blobstore.ErrBIDNotFound {
+		t.Fatalf("Invalid error of deleting the missing blob: %v", err)
+	}
+}
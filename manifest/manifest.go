@@ -0,0 +1,314 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package manifest creates signed lists of files of published directory
+// trees. The blob id of the signed manifest depends on the publisher's
+// key only, consumers knowing it can audit trees served or exported by
+// any node without trusting that node.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	ErrInvalidManifest = errors.New("Invalid manifest")
+)
+
+const (
+	formatVersion = 1
+	maxPathLength = 4096
+	maxEntries    = 1 << 24
+)
+
+// Single file of the tree
+type Entry struct {
+	Path string // Slash-separated path of the file within the tree
+	Size int64  // Size of the file content
+	Hash string // Hex-encoded SHA-512 hash of the file content
+}
+
+// List of all files of the tree, directories are not listed
+type Manifest struct {
+	Entries []Entry // Entries sorted by path
+}
+
+// Helper for sorting by path
+type sortByPath []Entry
+
+func (s sortByPath) Len() int {
+	return len(s)
+}
+
+func (s sortByPath) Less(i, j int) bool {
+	return s[i].Path < s[j].Path
+}
+
+func (s sortByPath) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Generate the manifest of the directory blob tree, the content of every
+// file is read and hashed
+func Generate(bid, key string, storage blobstore.BlobStorage) (*Manifest, error) {
+	m := &Manifest{}
+	if err := m.addDir("", bid, key, storage); err != nil {
+		return nil, err
+	}
+	sort.Sort(sortByPath(m.Entries))
+	return m, nil
+}
+
+func (m *Manifest) addDir(prefix, bid, key string, storage blobstore.BlobStorage) error {
+
+	reader, err := blobstore.OpenDirBlob(bid, key, storage)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	entries, err := reader.Entries()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsBlob() {
+			continue
+		}
+		info, err := blobstore.InspectBlobWithKey(entry.Bid, entry.Key, storage)
+		if err != nil {
+			return err
+		}
+
+		path := prefix + entry.Name
+		if info.IsDir() {
+			err = m.addDir(path+"/", entry.Bid, entry.Key, storage)
+		} else {
+			err = m.addFile(path, func() (io.Reader, error) {
+				return blobstore.OpenFileBlob(entry.Bid, entry.Key, storage)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generate the manifest of the local directory, i.e. the one created by
+// blobstore.MaterializeDirectory. Only regular files are listed, the same
+// way blobstore.UploadDirectory stores them.
+func GenerateLocal(path string) (*Manifest, error) {
+	m := &Manifest{}
+	err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, name)
+		if err != nil {
+			return err
+		}
+		return m.addFile(filepath.ToSlash(rel), func() (io.Reader, error) {
+			return os.Open(name)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sortByPath(m.Entries))
+	return m, nil
+}
+
+func (m *Manifest) addFile(path string, open func() (io.Reader, error)) error {
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	hasher := sha512.New()
+	size, err := io.Copy(hasher, reader)
+	if err != nil {
+		return err
+	}
+	m.Entries = append(m.Entries, Entry{
+		Path: path,
+		Size: size,
+		Hash: hex.EncodeToString(hasher.Sum(nil)),
+	})
+	return nil
+}
+
+// Publish the manifest as the signed blob, newer versions of the manifest
+// signed with the same key replace older ones
+func (m *Manifest) Publish(privKey crypto.Signer, version int64, storage blobstore.BlobStorage) (blobstore.BlobReference, error) {
+	var b bytes.Buffer
+	if err := m.serialize(&b); err != nil {
+		return blobstore.BlobReference{}, err
+	}
+	bid, key, err := blobstore.CreateSignedBlob(privKey, version, b.Bytes(), storage)
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+	return blobstore.BlobReference{Bid: bid, Key: key}, nil
+}
+
+// Open the published manifest, the signature is verified
+func Open(ref blobstore.BlobReference, storage blobstore.BlobStorage) (m *Manifest, version int64, err error) {
+	version, content, err := blobstore.OpenSignedBlob(ref.Bid, ref.Key, storage)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The signature is only verified at the end of the content
+	r := bufio.NewReader(content)
+	if m, err = deserialize(r); err != nil {
+		return nil, 0, err
+	}
+	if _, err = r.ReadByte(); err != io.EOF {
+		if err == nil {
+			err = ErrInvalidManifest
+		}
+		return nil, 0, err
+	}
+	return m, version, nil
+}
+
+func (m *Manifest) serialize(w io.Writer) error {
+	utils.SerializeInt(formatVersion, w)
+	utils.SerializeInt(uint64(len(m.Entries)), w)
+	for i, entry := range m.Entries {
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil || len(hash) != sha512.Size || entry.Size < 0 ||
+			(i > 0 && entry.Path <= m.Entries[i-1].Path) {
+			return ErrInvalidManifest
+		}
+		if err = utils.SerializeString(entry.Path, w, maxPathLength); err != nil {
+			return err
+		}
+		utils.SerializeInt(uint64(entry.Size), w)
+		w.Write(hash)
+	}
+	return nil
+}
+
+func deserialize(r io.Reader) (*Manifest, error) {
+	version, err := utils.DeserializeInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if version != formatVersion {
+		return nil, ErrInvalidManifest
+	}
+	count, err := utils.DeserializeInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > maxEntries {
+		return nil, ErrInvalidManifest
+	}
+
+	m := &Manifest{}
+	for i := uint64(0); i < count; i++ {
+		path, err := utils.DeserializeString(r, maxPathLength)
+		if err != nil {
+			return nil, err
+		}
+		size, err := utils.DeserializeInt(r)
+		if err != nil {
+			return nil, err
+		}
+		hash := make([]byte, sha512.Size)
+		if _, err = io.ReadFull(r, hash); err != nil {
+			return nil, err
+		}
+
+		// Entries must be sorted and unique
+		if int64(size) < 0 || (i > 0 && path <= m.Entries[i-1].Path) {
+			return nil, ErrInvalidManifest
+		}
+		m.Entries = append(m.Entries, Entry{
+			Path: path,
+			Size: int64(size),
+			Hash: hex.EncodeToString(hash),
+		})
+	}
+	return m, nil
+}
+
+// Difference between the manifest and the verified tree
+type Mismatch struct {
+	Path     string
+	Expected *Entry // Entry of the manifest, nil for unexpected files
+	Actual   *Entry // Entry of the verified tree, nil for missing files
+}
+
+func (m Mismatch) String() string {
+	switch {
+	case m.Expected == nil:
+		return fmt.Sprintf("%v: unexpected file", m.Path)
+	case m.Actual == nil:
+		return fmt.Sprintf("%v: missing file", m.Path)
+	case m.Expected.Size != m.Actual.Size:
+		return fmt.Sprintf("%v: size %v instead of %v", m.Path, m.Actual.Size, m.Expected.Size)
+	}
+	return fmt.Sprintf("%v: content differs", m.Path)
+}
+
+// Compare the manifest with the manifest of the verified tree,
+// no mismatches mean the trees are equal
+func (m *Manifest) Compare(actual *Manifest) (mismatches []Mismatch) {
+	expected, found := m.Entries, actual.Entries
+	for len(expected) > 0 || len(found) > 0 {
+		switch {
+		case len(found) == 0 || (len(expected) > 0 && expected[0].Path < found[0].Path):
+			mismatches = append(mismatches, Mismatch{Path: expected[0].Path, Expected: &expected[0]})
+			expected = expected[1:]
+		case len(expected) == 0 || found[0].Path < expected[0].Path:
+			mismatches = append(mismatches, Mismatch{Path: found[0].Path, Actual: &found[0]})
+			found = found[1:]
+		default:
+			if expected[0] != found[0] {
+				mismatches = append(mismatches, Mismatch{Path: expected[0].Path, Expected: &expected[0], Actual: &found[0]})
+			}
+			expected, found = expected[1:], found[1:]
+		}
+	}
+	return mismatches
+}
+
+// Verify the directory blob tree served by the storage
+func (m *Manifest) VerifyTree(bid, key string, storage blobstore.BlobStorage) ([]Mismatch, error) {
+	actual, err := Generate(bid, key, storage)
+	if err != nil {
+		return nil, err
+	}
+	return m.Compare(actual), nil
+}
+
+// Verify the tree exported to the local directory
+func (m *Manifest) VerifyLocal(path string) ([]Mismatch, error) {
+	actual, err := GenerateLocal(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.Compare(actual), nil
+}
@@ -0,0 +1,107 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublishAndVerify(t *testing.T) {
+
+	src, err := ioutil.TempDir("", "cinode-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	os.MkdirAll(filepath.Join(src, "sub"), 0777)
+	ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("Hello"), 0666)
+	ioutil.WriteFile(filepath.Join(src, "sub", "b.bin"), make([]byte, 100000), 0666)
+
+	storage := blobstore.NewMemoryBlobStorage()
+	bid, key, err := blobstore.UploadDirectory(src, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Generate(bid, key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 2 || m.Entries[0].Path != "a.txt" || m.Entries[1].Path != "sub/b.bin" ||
+		m.Entries[1].Size != 100000 {
+		t.Fatalf("Invalid manifest entries: %v", m.Entries)
+	}
+
+	_, privKey, _ := ed25519.GenerateKey(rand.Reader)
+	ref, err := m.Publish(privKey, 1, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, version, err := Open(ref, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || len(published.Compare(m)) != 0 {
+		t.Fatal("Invalid published manifest")
+	}
+
+	if mismatches, err := published.VerifyTree(bid, key, storage); err != nil || len(mismatches) != 0 {
+		t.Fatalf("Invalid verification of the published tree: %v, %v", mismatches, err)
+	}
+
+	// Exported tree is verified the same way
+	dst, err := ioutil.TempDir("", "cinode-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if err = blobstore.MaterializeDirectory(bid, key, storage, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	if mismatches, err := published.VerifyLocal(dst); err != nil || len(mismatches) != 0 {
+		t.Fatalf("Invalid verification of the exported tree: %v, %v", mismatches, err)
+	}
+
+	// Tampering is detected
+	ioutil.WriteFile(filepath.Join(dst, "a.txt"), []byte("Jello"), 0666)
+	os.Remove(filepath.Join(dst, "sub", "b.bin"))
+	ioutil.WriteFile(filepath.Join(dst, "c.txt"), nil, 0666)
+	mismatches, err := published.VerifyLocal(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 3 ||
+		mismatches[0].String() != "a.txt: content differs" ||
+		mismatches[1].String() != "c.txt: unexpected file" ||
+		mismatches[2].String() != "sub/b.bin: missing file" {
+		t.Fatalf("Invalid mismatches: %v", mismatches)
+	}
+}
+
+func TestInvalidManifest(t *testing.T) {
+
+	_, privKey, _ := ed25519.GenerateKey(rand.Reader)
+	storage := blobstore.NewMemoryBlobStorage()
+
+	unsorted := &Manifest{Entries: []Entry{
+		{Path: "b", Hash: strings.Repeat("0", 128)},
+		{Path: "a", Hash: strings.Repeat("0", 128)},
+	}}
+	if _, err := unsorted.Publish(privKey, 1, storage); err != ErrInvalidManifest {
+		t.Fatalf("Invalid error for unsorted manifest: %v", err)
+	}
+
+	bid, key, err := blobstore.CreateSignedBlob(privKey, 1, []byte{2, 0}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Open(blobstore.BlobReference{Bid: bid, Key: key}, storage); err != ErrInvalidManifest {
+		t.Fatalf("Invalid error for unknown format version: %v", err)
+	}
+}
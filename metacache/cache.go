@@ -0,0 +1,215 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metacache keeps decoded directory listings and file chunk maps
+// on the local disk, so browsing remote trees does not fetch and decrypt
+// the same metadata blobs in every session. Cached data is encrypted with
+// the secret of the cache, file names don't reveal blob ids.
+package metacache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidSecret = errors.New("Cache secret must be 32 bytes long")
+)
+
+// Size of the cache secret
+const SecretSize = 32
+
+// Prefix of files with entries being written
+const tempFilePrefix = ".writing-"
+
+// Kinds of cached metadata
+const (
+	kindDirEntries = "dir"
+	kindFileChunks = "chunks"
+)
+
+// Cache of blob metadata. Hash-validated blobs never change, entries are
+// kept until they're invalidated. Entries are bound to both the bid and
+// the key, lookups with an invalid key miss and go to the storage.
+type Cache struct {
+	path   string
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// Generate random secret for the cache
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Open the cache in given directory, the directory is created if needed.
+// The same secret must be used to read entries in later sessions.
+func Open(path string, secret []byte) (*Cache, error) {
+	if len(secret) != SecretSize {
+		return nil, ErrInvalidSecret
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	// Separate keys for encryption and naming are derived from the secret
+	block, err := aes.NewCipher(derive(secret, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{path: path, aead: aead, macKey: derive(secret, "naming")}, nil
+}
+
+func derive(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Get entries of the directory blob
+func (c *Cache) DirEntries(bid, key string, storage blobstore.BlobStorage) ([]blobstore.DirEntry, error) {
+	var entries []blobstore.DirEntry
+	if c.load(kindDirEntries, bid, key, &entries) {
+		return entries, nil
+	}
+
+	reader, err := blobstore.OpenDirBlob(bid, key, storage)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if entries, err = reader.Entries(); err != nil {
+		return nil, err
+	}
+
+	c.store(kindDirEntries, bid, key, entries)
+	return entries, nil
+}
+
+// Get the chunk map of the file blob
+func (c *Cache) FileChunks(bid, key string, storage blobstore.BlobStorage) ([]blobstore.FileChunk, error) {
+	var chunks []blobstore.FileChunk
+	if c.load(kindFileChunks, bid, key, &chunks) {
+		return chunks, nil
+	}
+
+	chunks, err := blobstore.FileChunks(bid, key, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(kindFileChunks, bid, key, chunks)
+	return chunks, nil
+}
+
+// Remove cached metadata of the blob
+func (c *Cache) Invalidate(bid, key string) {
+	for _, kind := range []string{kindDirEntries, kindFileChunks} {
+		os.Remove(c.entryPath(kind, bid, key))
+	}
+}
+
+// Remove all cached metadata
+func (c *Cache) Clear() error {
+	names, err := c.names()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err = os.Remove(filepath.Join(c.path, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) names() ([]string, error) {
+	dir, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdirnames(-1)
+}
+
+// Identity of the entry, it's authenticated along with the data
+func entryID(kind, bid, key string) []byte {
+	return []byte(kind + "\x00" + bid + "\x00" + key)
+}
+
+func (c *Cache) entryPath(kind, bid, key string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write(entryID(kind, bid, key))
+	return filepath.Join(c.path, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Read the entry, returns false if it's missing or can not be decrypted
+func (c *Cache) load(kind, bid, key string, value interface{}) bool {
+	data, err := ioutil.ReadFile(c.entryPath(kind, bid, key))
+	if err != nil {
+		return false
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize {
+		return false
+	}
+	plain, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], entryID(kind, bid, key))
+	if err != nil {
+		return false
+	}
+
+	return gob.NewDecoder(bytes.NewReader(plain)).Decode(value) == nil
+}
+
+// Save the entry, failures are ignored since the cache is only an optimization
+func (c *Cache) store(kind, bid, key string, value interface{}) {
+	var plain bytes.Buffer
+	if err := gob.NewEncoder(&plain).Encode(value); err != nil {
+		return
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	data := c.aead.Seal(nonce, nonce, plain.Bytes(), entryID(kind, bid, key))
+
+	// Entries appear atomically, concurrent sessions never see partial data
+	tmp, err := ioutil.TempFile(c.path, tempFilePrefix)
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.entryPath(kind, bid, key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
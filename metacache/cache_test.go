@@ -0,0 +1,101 @@
+package metacache
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-metacache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := blobstore.NewAccessTracker(blobstore.NewMemoryBlobStorage())
+
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	fileRef, _ := fw.Finalize()
+	fileBid, fileKey := fileRef.Bid, fileRef.Key
+
+	dw := blobstore.DirBlobWriter{Storage: storage}
+	dw.AddEntry(blobstore.DirEntry{Name: "hello.txt", MimeType: "text/plain", Bid: fileBid, Key: fileKey})
+	dirRef, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirBid, dirKey := dirRef.Bid, dirRef.Key
+
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := Open(dir, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := cache.DirEntries(dirBid, dirKey, storage)
+	if err != nil || len(entries) != 1 || entries[0].Name != "hello.txt" {
+		t.Fatalf("Invalid directory entries: %v, %v", entries, err)
+	}
+	chunks, err := cache.FileChunks(fileBid, fileKey, storage)
+	if err != nil || len(chunks) != 1 || chunks[0].Length != 12 {
+		t.Fatalf("Invalid chunk map: %v, %v", chunks, err)
+	}
+
+	// Next session uses cached data, the storage is not accessed
+	storage.Reset()
+	cache, err = Open(dir, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = cache.DirEntries(dirBid, dirKey, storage); err != nil || len(entries) != 1 || entries[0].Bid != fileBid {
+		t.Fatalf("Invalid cached directory entries: %v, %v", entries, err)
+	}
+	if chunks, err = cache.FileChunks(fileBid, fileKey, storage); err != nil || len(chunks) != 1 || chunks[0].Length != 12 {
+		t.Fatalf("Invalid cached chunk map: %v, %v", chunks, err)
+	}
+	if len(storage.TopBlobs(10)) != 0 {
+		t.Fatal("Storage accessed for cached metadata")
+	}
+
+	// Cached data is encrypted and does not reveal blob ids
+	names, _ := cache.names()
+	for _, name := range names {
+		data, _ := ioutil.ReadFile(dir + "/" + name)
+		if bytes.Contains(data, []byte("hello.txt")) || bytes.Contains([]byte(name), []byte(dirBid[:16])) {
+			t.Fatal("Cached data not protected")
+		}
+	}
+
+	// Other secret can't read entries, lookups fall back to the storage
+	otherSecret, _ := NewSecret()
+	other, _ := Open(dir, otherSecret)
+	if entries, err = other.DirEntries(dirBid, dirKey, storage); err != nil || len(entries) != 1 {
+		t.Fatalf("Invalid directory entries: %v, %v", entries, err)
+	}
+	if stats, _ := storage.Stats(dirBid); stats.Reads != 1 {
+		t.Fatal("Entry encrypted with another secret used")
+	}
+
+	// Invalid key is not served from the cache
+	if _, err = cache.DirEntries(dirBid, fileKey, storage); err == nil {
+		t.Fatal("Directory opened with invalid key")
+	}
+
+	if err = cache.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ = cache.names(); len(names) != 0 {
+		t.Fatalf("Cache not cleared: %v", names)
+	}
+
+	if _, err = Open(dir, secret[:16]); err != ErrInvalidSecret {
+		t.Fatalf("Invalid error for short secret: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	ErrInvalidCasyncIndex = errors.New("Invalid casync index")
+)
+
+// Records of the casync index format
+const (
+	casyncFormatIndex      = 0x96824d9c7b129ff9
+	casyncFormatTable      = 0xe75b9e112f17417d
+	casyncTableTailMarker  = 0x4b4f050e5549ecd1
+	casyncFeatureSHA512256 = 0x2000000000000000
+
+	casyncIndexSize     = 48 // Size of the index header, the table follows it
+	casyncTableItemSize = 40 // End offset of the chunk and its 32-byte id
+	casyncChunkIdSize   = 32
+
+	// Limits of chunk sizes accepted by casync
+	casyncMinChunkSize = 1
+	casyncMaxChunkSize = 128 * 1024 * 1024
+)
+
+// Read the blob index of casync (.caibx) as the manifest of a single file,
+// the path and the MIME type of the file are not set. The index lists
+// chunks of the file by their SHA-256 or SHA-512/256 ids, chunk stores
+// of casync keep them compressed and must be read by an adapter.
+func ReadCasyncIndex(r io.Reader) (*FileManifest, error) {
+	br := bufio.NewReader(r)
+	var header [6]uint64
+	if err := binary.Read(br, binary.LittleEndian, header[:]); err != nil {
+		return nil, casyncError(err)
+	}
+	size, recordType, flags := header[0], header[1], header[2]
+	minSize, avgSize, maxSize := header[3], header[4], header[5]
+	if size != casyncIndexSize || recordType != casyncFormatIndex ||
+		minSize < casyncMinChunkSize || minSize > avgSize || avgSize > maxSize || maxSize > casyncMaxChunkSize {
+		return nil, ErrInvalidCasyncIndex
+	}
+
+	var tableHeader [2]uint64
+	if err := binary.Read(br, binary.LittleEndian, tableHeader[:]); err != nil {
+		return nil, casyncError(err)
+	}
+	if tableHeader[0] != math.MaxUint64 || tableHeader[1] != casyncFormatTable {
+		return nil, ErrInvalidCasyncIndex
+	}
+
+	file := &FileManifest{Chunks: []string{}, Digest: DigestSHA256}
+	if flags&casyncFeatureSHA512256 != 0 {
+		file.Digest = DigestSHA512256
+	}
+	for {
+		var item [casyncTableItemSize]byte
+		if _, err := io.ReadFull(br, item[:]); err != nil {
+			return nil, casyncError(err)
+		}
+
+		// The tail starts with zero fill where items have non-zero offsets
+		end := binary.LittleEndian.Uint64(item[:8])
+		if end == 0 {
+			return file, readCasyncTail(item[:], len(file.Chunks), br)
+		}
+		chunkSize := int64(end) - file.Size
+		if end > math.MaxInt64 || chunkSize <= 0 || uint64(chunkSize) > maxSize {
+			return nil, ErrInvalidCasyncIndex
+		}
+		file.Chunks = append(file.Chunks, hex.EncodeToString(item[8:]))
+		file.ChunkSizes = append(file.ChunkSizes, chunkSize)
+		file.Size = int64(end)
+	}
+}
+
+// Check the tail of the table with given number of items,
+// nothing may follow it
+func readCasyncTail(tail []byte, items int, r io.Reader) error {
+	zeroFill := binary.LittleEndian.Uint64(tail[8:16])
+	indexOffset := binary.LittleEndian.Uint64(tail[16:24])
+	size := binary.LittleEndian.Uint64(tail[24:32])
+	marker := binary.LittleEndian.Uint64(tail[32:40])
+	if zeroFill != 0 || indexOffset != casyncIndexSize || marker != casyncTableTailMarker ||
+		size != uint64(16+(items+1)*casyncTableItemSize) {
+		return ErrInvalidCasyncIndex
+	}
+	if n, _ := r.Read(make([]byte, 1)); n != 0 {
+		return ErrInvalidCasyncIndex
+	}
+	return nil
+}
+
+// Write the file as the blob index of casync (.caibx). Sizes of all chunks
+// must be known, chunk size limits of the index are taken from them.
+func WriteCasyncIndex(w io.Writer, file *FileManifest) error {
+	var flags uint64
+	switch file.Digest {
+	case "", DigestSHA256:
+	case DigestSHA512256:
+		flags = casyncFeatureSHA512256
+	default:
+		return ErrUnknownDigest
+	}
+	if len(file.ChunkSizes) != len(file.Chunks) {
+		return ErrFileSizeMismatch
+	}
+
+	minSize, maxSize, total := int64(math.MaxInt64), int64(0), int64(0)
+	for _, size := range file.ChunkSizes {
+		if size < casyncMinChunkSize || size > casyncMaxChunkSize {
+			return ErrInvalidCasyncIndex
+		}
+		minSize, maxSize, total = min(minSize, size), max(maxSize, size), total+size
+	}
+	if total != file.Size {
+		return ErrFileSizeMismatch
+	}
+	avgSize := int64(DefaultChunkSize)
+	if len(file.Chunks) == 0 {
+		minSize, maxSize = avgSize, avgSize
+	} else {
+		avgSize = total / int64(len(file.Chunks))
+	}
+
+	bw := bufio.NewWriter(w)
+	binary.Write(bw, binary.LittleEndian, []uint64{
+		casyncIndexSize, casyncFormatIndex, flags, uint64(minSize), uint64(avgSize), uint64(maxSize),
+		math.MaxUint64, casyncFormatTable,
+	})
+	end := uint64(0)
+	for i, id := range file.Chunks {
+		raw, err := hex.DecodeString(id)
+		if err != nil || len(raw) != casyncChunkIdSize {
+			return ErrInvalidChunkId
+		}
+		end += uint64(file.ChunkSizes[i])
+		binary.Write(bw, binary.LittleEndian, end)
+		bw.Write(raw)
+	}
+	binary.Write(bw, binary.LittleEndian, []uint64{
+		0, 0, casyncIndexSize, uint64(16 + (len(file.Chunks)+1)*casyncTableItemSize), casyncTableTailMarker,
+	})
+	return bw.Flush()
+}
+
+// Report truncated indexes as invalid ones
+func casyncError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidCasyncIndex
+	}
+	return err
+}
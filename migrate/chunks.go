@@ -0,0 +1,169 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package migrate moves data between cinode blobs and chunk-based formats
+// used by deduplicating backup tools. Such tools describe files as lists
+// of content-addressed chunks, adapters of particular tools implement
+// ChunkStore and produce or consume the Manifest. Encrypted repositories
+// of such tools must be decrypted by the adapter, this package only
+// includes the store for plain chunk directories and chunk lists of
+// casync blob indexes, see ReadCasyncIndex.
+package migrate
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrChunkNotFound         = errors.New("Chunk not found")
+	ErrInvalidChunkId        = errors.New("Invalid chunk id")
+	ErrChunkHashMismatch     = errors.New("Chunk content does not match its id")
+	ErrFileSizeMismatch      = errors.New("Size of imported file does not match the manifest")
+	ErrInvalidManifestPath   = errors.New("Invalid file path in the manifest")
+	ErrDuplicateManifestPath = errors.New("Duplicate file path in the manifest")
+	ErrUnknownDigest         = errors.New("Unknown digest of chunk ids")
+)
+
+// Digests of chunk ids
+const (
+	DigestSHA256    = "sha256"
+	DigestSHA512256 = "sha512-256"
+)
+
+// Store of content-addressed chunks
+type ChunkStore interface {
+
+	// Open the chunk with given id, ErrChunkNotFound is returned if there's no such chunk
+	ReadChunk(id string) (io.ReadCloser, error)
+
+	// Store the chunk, the id of the chunk is returned
+	WriteChunk(data []byte) (id string, err error)
+}
+
+// Single file described as a list of chunks
+type FileManifest struct {
+	Path       string   `json:"path"`                 // Slash-separated path of the file
+	MimeType   string   `json:"mimetype"`             // Mime type of the file, may be empty
+	Size       int64    `json:"size"`                 // Total size of the file
+	Chunks     []string `json:"chunks"`               // Ids of consecutive chunks of the file
+	ChunkSizes []int64  `json:"chunkSizes,omitempty"` // Sizes of chunks, not checked if empty
+	Digest     string   `json:"digest,omitempty"`     // Digest of chunk ids, DigestSHA256 if empty
+}
+
+// List of files in the chunk-based format
+type Manifest struct {
+	Files []FileManifest `json:"files"`
+}
+
+// Read manifest in JSON form
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Write manifest in JSON form
+func WriteManifest(w io.Writer, m *Manifest) error {
+	return json.NewEncoder(w).Encode(m)
+}
+
+// Chunk store keeping chunks in files named by SHA-256 of their content,
+// grouped in subdirectories by the first two characters of the id. Chunks
+// with ids of other digests of the same size can be read too.
+type dirChunkStore struct {
+	path string
+}
+
+// Create chunk store in given directory
+func NewDirChunkStore(path string) ChunkStore {
+	return &dirChunkStore{path: path}
+}
+
+func (d *dirChunkStore) chunkPath(id string) (string, error) {
+	if raw, err := hex.DecodeString(id); err != nil || len(raw) != sha256.Size || hex.EncodeToString(raw) != id {
+		return "", ErrInvalidChunkId
+	}
+	return filepath.Join(d.path, id[:2], id), nil
+}
+
+func (d *dirChunkStore) ReadChunk(id string) (io.ReadCloser, error) {
+	path, err := d.chunkPath(id)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrChunkNotFound
+	}
+	return file, err
+}
+
+func (d *dirChunkStore) WriteChunk(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	path, _ := d.chunkPath(id)
+
+	// Chunks are immutable, existing one does not have to be written again
+	if _, err := os.Stat(path); err == nil {
+		return id, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), ".chunk")
+	if err != nil {
+		return "", err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return id, os.Rename(file.Name(), path)
+}
+
+// Reader verifying the chunk content against its id at EOF
+type verifyingReader struct {
+	reader io.Reader
+	hasher hash.Hash
+	id     string
+}
+
+func newVerifyingReader(reader io.Reader, id, digest string) (*verifyingReader, error) {
+	var hasher hash.Hash
+	switch digest {
+	case "", DigestSHA256:
+		hasher = sha256.New()
+	case DigestSHA512256:
+		hasher = sha512.New512_256()
+	default:
+		return nil, ErrUnknownDigest
+	}
+	return &verifyingReader{reader: reader, hasher: hasher, id: id}, nil
+}
+
+func (v *verifyingReader) Read(p []byte) (n int, err error) {
+	n, err = v.reader.Read(p)
+	v.hasher.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.hasher.Sum(nil)) != v.id {
+		return n, ErrChunkHashMismatch
+	}
+	return
+}
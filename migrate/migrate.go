@@ -0,0 +1,252 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Default size of chunks created by the export
+const DefaultChunkSize = 1024 * 1024
+
+// Store files from the manifest as cinode blobs, a directory tree is built
+// from paths of files, paths must be unique. Bid and key of the root
+// directory are returned. Chunks are verified against their ids and sizes
+// while they're imported.
+func Import(manifest *Manifest, chunks ChunkStore, storage blobstore.BlobStorage) (bid, key string, err error) {
+
+	root := newImportDir()
+	for i := range manifest.Files {
+		file := &manifest.Files[i]
+
+		dir, name, err := root.lookup(file.Path)
+		if err != nil {
+			return "", "", err
+		}
+
+		entry, err := importFile(file, chunks, storage)
+		if err != nil {
+			return "", "", err
+		}
+		entry.Name = name
+		dir.files[name] = entry
+	}
+
+	return root.finalize(storage)
+}
+
+func importFile(file *FileManifest, chunks ChunkStore, storage blobstore.BlobStorage) (entry blobstore.DirEntry, err error) {
+
+	if len(file.ChunkSizes) != 0 && len(file.ChunkSizes) != len(file.Chunks) {
+		return entry, ErrFileSizeMismatch
+	}
+
+	writer := blobstore.FileBlobWriter{Storage: storage}
+	size := int64(0)
+	for i, id := range file.Chunks {
+		n, err := importChunk(&writer, chunks, id, file.Digest)
+		if err == nil && len(file.ChunkSizes) != 0 && n != file.ChunkSizes[i] {
+			err = ErrFileSizeMismatch
+		}
+		if err != nil {
+			writer.Cancel()
+			return entry, err
+		}
+		size += n
+	}
+
+	if size != file.Size {
+		writer.Cancel()
+		return entry, ErrFileSizeMismatch
+	}
+
+	entry.MimeType = file.MimeType
+	ref, err := writer.Finalize()
+	entry.Bid, entry.Key = ref.Bid, ref.Key
+	return entry, err
+}
+
+// Copy the verified chunk to the writer
+func importChunk(writer io.Writer, chunks ChunkStore, id, digest string) (int64, error) {
+	chunk, err := chunks.ReadChunk(id)
+	if err != nil {
+		return 0, err
+	}
+	defer chunk.Close()
+	reader, err := newVerifyingReader(chunk, id, digest)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(writer, reader)
+}
+
+// Directory built by the import
+type importDir struct {
+	files map[string]blobstore.DirEntry
+	dirs  map[string]*importDir
+}
+
+func newImportDir() *importDir {
+	return &importDir{
+		files: make(map[string]blobstore.DirEntry),
+		dirs:  make(map[string]*importDir),
+	}
+}
+
+// Find the directory of the file with given path, missing directories are created
+func (d *importDir) lookup(path string) (dir *importDir, name string, err error) {
+	elements := strings.Split(strings.Trim(path, "/"), "/")
+	for _, element := range elements {
+		if element == "" || element == "." || element == ".." {
+			return nil, "", ErrInvalidManifestPath
+		}
+	}
+
+	dir = d
+	for _, element := range elements[:len(elements)-1] {
+		if _, isFile := dir.files[element]; isFile {
+			return nil, "", ErrInvalidManifestPath
+		}
+		sub, ok := dir.dirs[element]
+		if !ok {
+			sub = newImportDir()
+			dir.dirs[element] = sub
+		}
+		dir = sub
+	}
+
+	name = elements[len(elements)-1]
+	if _, isDir := dir.dirs[name]; isDir {
+		return nil, "", ErrInvalidManifestPath
+	}
+	if _, isFile := dir.files[name]; isFile {
+		return nil, "", ErrDuplicateManifestPath
+	}
+	return dir, name, nil
+}
+
+func (d *importDir) finalize(storage blobstore.BlobStorage) (bid, key string, err error) {
+	writer := blobstore.DirBlobWriter{Storage: storage}
+
+	for name, sub := range d.dirs {
+		subBid, subKey, err := sub.finalize(storage)
+		if err != nil {
+			return "", "", err
+		}
+		writer.UpsertEntry(blobstore.DirEntry{Name: name, Bid: subBid, Key: subKey})
+	}
+	for _, entry := range d.files {
+		writer.UpsertEntry(entry)
+	}
+
+	ref, err := writer.Finalize()
+	return ref.Bid, ref.Key, err
+}
+
+// Store all files reachable from the directory blob in the chunk store.
+// Files are split into chunks of given size, DefaultChunkSize is used
+// if the size is not positive. Files are listed in the order of paths.
+func Export(bid, key string, storage blobstore.BlobStorage, chunks ChunkStore, chunkSize int) (*Manifest, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	e := exporter{storage: storage, chunks: chunks, buffer: make([]byte, chunkSize)}
+	if err := e.exportDir("", bid, key); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(byPath(e.manifest.Files))
+	return &e.manifest, nil
+}
+
+type exporter struct {
+	storage  blobstore.BlobStorage
+	chunks   ChunkStore
+	buffer   []byte
+	manifest Manifest
+}
+
+func (e *exporter) exportDir(path, bid, key string) error {
+	reader, err := blobstore.OpenDirBlob(bid, key, e.storage)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	entries, err := reader.Entries()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsBlob() {
+			continue
+		}
+		entryPath := path + "/" + entry.Name
+
+		info, err := blobstore.InspectBlobWithKey(entry.Bid, entry.Key, e.storage)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = e.exportDir(entryPath, entry.Bid, entry.Key)
+		} else {
+			err = e.exportFile(entryPath, entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *exporter) exportFile(path string, entry blobstore.DirEntry) error {
+	reader, err := blobstore.OpenFileBlob(entry.Bid, entry.Key, e.storage)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file := FileManifest{Path: path, MimeType: entry.MimeType, Chunks: []string{}}
+	for {
+		n, err := io.ReadFull(reader, e.buffer)
+		if n > 0 {
+			id, err := e.chunks.WriteChunk(e.buffer[:n])
+			if err != nil {
+				return err
+			}
+			file.Chunks = append(file.Chunks, id)
+			file.ChunkSizes = append(file.ChunkSizes, int64(n))
+			file.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	e.manifest.Files = append(e.manifest.Files, file)
+	return nil
+}
+
+// Helper for sorting files by path
+type byPath []FileManifest
+
+func (s byPath) Len() int {
+	return len(s)
+}
+
+func (s byPath) Less(i, j int) bool {
+	return s[i].Path < s[j].Path
+}
+
+func (s byPath) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
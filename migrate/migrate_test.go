@@ -0,0 +1,221 @@
+package migrate
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cinode-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeChunks(t *testing.T, chunks ChunkStore, data ...string) (ids []string) {
+	for _, d := range data {
+		id, err := chunks.WriteChunk([]byte(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+func TestImportExport(t *testing.T) {
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	chunks := NewDirChunkStore(filepath.Join(dir, "source"))
+	manifest := &Manifest{Files: []FileManifest{
+		{Path: "hello.txt", MimeType: "text/plain", Size: 12, Chunks: writeChunks(t, chunks, "Hello ", "World!")},
+		{Path: "docs/readme.txt", MimeType: "text/plain", Size: 7, Chunks: writeChunks(t, chunks, "Read me")},
+		{Path: "/docs/empty", Size: 0, Chunks: nil},
+	}}
+
+	storage := blobstore.NewMemoryBlobStorage()
+	bid, key, err := Import(manifest, chunks, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := NewDirChunkStore(filepath.Join(dir, "target"))
+	exported, err := Export(bid, key, storage, target, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		path   string
+		size   int64
+		chunks int
+	}{
+		{"/docs/empty", 0, 0},
+		{"/docs/readme.txt", 7, 2},
+		{"/hello.txt", 12, 3},
+	}
+	if len(exported.Files) != len(expected) {
+		t.Fatalf("Invalid exported files: %v", exported.Files)
+	}
+	for i, e := range expected {
+		f := exported.Files[i]
+		if f.Path != e.path || f.Size != e.size || len(f.Chunks) != e.chunks {
+			t.Fatalf("Invalid exported file: %+v", f)
+		}
+	}
+
+	// Manifest survives serialization and import of exported data
+	// produces the same tree
+	var b bytes.Buffer
+	if err = WriteManifest(&b, exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported, err = ReadManifest(&b); err != nil {
+		t.Fatal(err)
+	}
+	bid2, key2, err := Import(exported, target, blobstore.NewMemoryBlobStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bid2 != bid || key2 != key {
+		t.Fatal("Import of exported data produced different tree")
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	chunks := NewDirChunkStore(dir)
+	ids := writeChunks(t, chunks, "data", "other")
+
+	// Corrupt the second chunk
+	ioutil.WriteFile(filepath.Join(dir, ids[1][:2], ids[1]), []byte("corrupted"), 0666)
+
+	missing := "0000000000000000000000000000000000000000000000000000000000000000"
+	for _, d := range []struct {
+		file FileManifest
+		err  error
+	}{
+		{FileManifest{Path: "a", Size: 5, Chunks: ids[:1]}, ErrFileSizeMismatch},
+		{FileManifest{Path: "a", Size: 5, Chunks: ids[1:]}, ErrChunkHashMismatch},
+		{FileManifest{Path: "a", Size: 4, Chunks: []string{missing}}, ErrChunkNotFound},
+		{FileManifest{Path: "a", Size: 4, Chunks: []string{"../../etc/passwd"}}, ErrInvalidChunkId},
+		{FileManifest{Path: "a/../b", Size: 4, Chunks: ids[:1]}, ErrInvalidManifestPath},
+		{FileManifest{Path: "", Size: 4, Chunks: ids[:1]}, ErrInvalidManifestPath},
+		{FileManifest{Path: "a", Size: 4, Chunks: ids[:1], ChunkSizes: []int64{3}}, ErrFileSizeMismatch},
+		{FileManifest{Path: "a", Size: 4, Chunks: ids[:1], Digest: "md5"}, ErrUnknownDigest},
+	} {
+		manifest := &Manifest{Files: []FileManifest{d.file}}
+		if _, _, err := Import(manifest, chunks, blobstore.NewMemoryBlobStorage()); err != d.err {
+			t.Fatalf("Invalid error for file %+v: %v", d.file, err)
+		}
+	}
+
+	// File and directory with the same path
+	manifest := &Manifest{Files: []FileManifest{
+		{Path: "a", Size: 4, Chunks: ids[:1]},
+		{Path: "a/b", Size: 4, Chunks: ids[:1]},
+	}}
+	if _, _, err := Import(manifest, chunks, blobstore.NewMemoryBlobStorage()); err != ErrInvalidManifestPath {
+		t.Fatalf("Invalid error for conflicting paths: %v", err)
+	}
+
+	// Files with the same path
+	manifest = &Manifest{Files: []FileManifest{
+		{Path: "a/b", Size: 4, Chunks: ids[:1]},
+		{Path: "/a/b", Size: 4, Chunks: ids[:1]},
+	}}
+	if _, _, err := Import(manifest, chunks, blobstore.NewMemoryBlobStorage()); err != ErrDuplicateManifestPath {
+		t.Fatalf("Invalid error for duplicate paths: %v", err)
+	}
+}
+
+func TestCasyncIndex(t *testing.T) {
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	chunks := NewDirChunkStore(dir)
+	file := &FileManifest{Size: 12, Chunks: writeChunks(t, chunks, "Hello ", "World!"), ChunkSizes: []int64{6, 6}}
+
+	var b bytes.Buffer
+	if err := WriteCasyncIndex(&b, file); err != nil {
+		t.Fatal(err)
+	}
+	index := b.Bytes()
+	if len(index) != 48+16+3*40 {
+		t.Fatalf("Invalid size of the index: %v", len(index))
+	}
+	read, err := ReadCasyncIndex(bytes.NewReader(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Size != 12 || read.Digest != DigestSHA256 || len(read.Chunks) != 2 ||
+		read.Chunks[1] != file.Chunks[1] || read.ChunkSizes[1] != 6 {
+		t.Fatalf("Invalid file read from the index: %+v", read)
+	}
+
+	// Files of the index are imported
+	read.Path = "hello.txt"
+	storage := blobstore.NewMemoryBlobStorage()
+	bid, key, err := Import(&Manifest{Files: []FileManifest{*read}}, chunks, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := blobstore.Resolve(bid, key, "hello.txt", storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := blobstore.OpenFileBlob(entry.Bid, entry.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "Hello World!" {
+		t.Fatalf("Invalid content of the imported file: %q %v", data, err)
+	}
+
+	// Chunk ids of SHA-512/256 are verified with that digest
+	read.Digest = DigestSHA512256
+	if _, _, err = Import(&Manifest{Files: []FileManifest{*read}}, chunks, storage); err != ErrChunkHashMismatch {
+		t.Fatalf("Invalid error for chunks of other digest: %v", err)
+	}
+	b.Reset()
+	if err = WriteCasyncIndex(&b, read); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = ReadCasyncIndex(&b); err != nil || read.Digest != DigestSHA512256 {
+		t.Fatalf("Invalid digest read from the index: %v %v", read, err)
+	}
+
+	flipped := func(pos int) []byte {
+		data := append([]byte{}, index...)
+		data[pos] ^= 0x01
+		return data
+	}
+	for i, corrupted := range [][]byte{
+		index[:len(index)-1],
+		append(append([]byte{}, index...), 0),
+		append(append([]byte{}, index[:48]...), index[64:]...),
+		flipped(8),
+		flipped(len(index) - 1),
+		flipped(len(index) - 16),
+	} {
+		if _, err = ReadCasyncIndex(bytes.NewReader(corrupted)); err != ErrInvalidCasyncIndex {
+			t.Fatalf("Invalid error for corrupted index %v: %v", i, err)
+		}
+	}
+
+	file.ChunkSizes = nil
+	if err = WriteCasyncIndex(&b, file); err != ErrFileSizeMismatch {
+		t.Fatalf("Invalid error for unknown chunk sizes: %v", err)
+	}
+}
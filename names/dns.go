@@ -0,0 +1,48 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package names
+
+import (
+	"github.com/cinode/golib/blobstore"
+	"net"
+	"strings"
+)
+
+// Prefix of TXT records holding blob references
+const dnsRecordPrefix = "cinode="
+
+// DNSResolver resolves names through DNS TXT records of the form
+// cinode=<bid>:<key>. Only names containing a dot are looked up.
+type DNSResolver struct {
+
+	// Function used to get TXT records, net.LookupTXT if nil
+	LookupTXT func(name string) ([]string, error)
+}
+
+func (d *DNSResolver) Resolve(name string) (blobstore.BlobReference, error) {
+	if !strings.Contains(name, ".") {
+		return blobstore.BlobReference{}, ErrNameNotFound
+	}
+
+	lookup := d.LookupTXT
+	if lookup == nil {
+		lookup = net.LookupTXT
+	}
+
+	records, err := lookup(name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return blobstore.BlobReference{}, ErrNameNotFound
+	}
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+
+	for _, record := range records {
+		if strings.HasPrefix(record, dnsRecordPrefix) {
+			return ParseRecord(record[len(dnsRecordPrefix):])
+		}
+	}
+	return blobstore.BlobReference{}, ErrNameNotFound
+}
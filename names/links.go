@@ -0,0 +1,56 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package names
+
+import (
+	"crypto"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"io/ioutil"
+)
+
+// Maximum size of the link blob content
+const maxLinkSize = 4096
+
+// Create a mutable link, the signed blob pointing to the target. The link
+// is updated by creating it again with the same key and a higher version.
+func CreateLink(privKey crypto.Signer, version int64, target blobstore.BlobReference, storage blobstore.BlobStorage) (blobstore.BlobReference, error) {
+	bid, key, err := blobstore.CreateSignedBlob(privKey, version, []byte(FormatRecord(target)), storage)
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+	return blobstore.BlobReference{Bid: bid, Key: key}, nil
+}
+
+// Read the target of the link, the signature is verified
+func ReadLink(link blobstore.BlobReference, storage blobstore.BlobStorage) (blobstore.BlobReference, error) {
+	_, content, err := blobstore.OpenSignedBlob(link.Bid, link.Key, storage)
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(content, maxLinkSize+1))
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+	if len(data) > maxLinkSize {
+		return blobstore.BlobReference{}, ErrInvalidRecord
+	}
+	return ParseRecord(string(data))
+}
+
+// LinkResolver follows links, names are resolved to links by the
+// underlying resolver
+type LinkResolver struct {
+	Links   Resolver
+	Storage blobstore.BlobStorage
+}
+
+func (l *LinkResolver) Resolve(name string) (blobstore.BlobReference, error) {
+	link, err := l.Links.Resolve(name)
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+	return ReadLink(link, l.Storage)
+}
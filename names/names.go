@@ -0,0 +1,108 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package names maps human-readable names to blob references. Names are
+// resolved by local petnames, DNS TXT records or mutable links kept in signed
+// blobs, resolvers can be chained. Links like cinode://docs/report are
+// dereferenced by resolving the name and walking directory blobs.
+package names
+
+import (
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrNameNotFound  = errors.New("Name not found")
+	ErrPathNotFound  = errors.New("Path not found")
+	ErrInvalidURL    = errors.New("Invalid cinode URL")
+	ErrInvalidRecord = errors.New("Invalid name record")
+)
+
+// URL scheme of links to blobs
+const Scheme = "cinode"
+
+// Resolver maps names to blob references
+type Resolver interface {
+
+	// Find the reference for given name, ErrNameNotFound is returned
+	// if the name is not known to this resolver
+	Resolve(name string) (blobstore.BlobReference, error)
+}
+
+// Chain of resolvers, the name is resolved by the first resolver knowing it
+type Chain []Resolver
+
+func (c Chain) Resolve(name string) (blobstore.BlobReference, error) {
+	for _, r := range c {
+		ref, err := r.Resolve(name)
+		if err != ErrNameNotFound {
+			return ref, err
+		}
+	}
+	return blobstore.BlobReference{}, ErrNameNotFound
+}
+
+// Dereference the link of form cinode://name/path/to/entry, the name
+// is resolved and path elements are looked up in directory blobs
+func Dereference(resolver Resolver, link string, storage blobstore.BlobStorage) (blobstore.BlobReference, error) {
+
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != Scheme || u.Host == "" {
+		return blobstore.BlobReference{}, ErrInvalidURL
+	}
+
+	ref, err := resolver.Resolve(u.Host)
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+
+	for _, name := range strings.Split(u.Path, "/") {
+		if name == "" {
+			continue
+		}
+		if ref, err = lookupEntry(ref, name, storage); err != nil {
+			return blobstore.BlobReference{}, err
+		}
+	}
+	return ref, nil
+}
+
+// Find the entry with given name in the directory blob
+func lookupEntry(dir blobstore.BlobReference, name string, storage blobstore.BlobStorage) (blobstore.BlobReference, error) {
+	reader, err := blobstore.OpenDirBlob(dir.Bid, dir.Key, storage)
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+	defer reader.Close()
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return blobstore.BlobReference{}, ErrPathNotFound
+		}
+		if err != nil {
+			return blobstore.BlobReference{}, err
+		}
+		if entry.Name == name && entry.IsBlob() {
+			return blobstore.BlobReference{Bid: entry.Bid, Key: entry.Key}, nil
+		}
+	}
+}
+
+// Format the reference as a name record, i.e. to be put into a DNS TXT record
+func FormatRecord(ref blobstore.BlobReference) string {
+	return ref.Bid + ":" + ref.Key
+}
+
+// Parse the name record created with FormatRecord
+func ParseRecord(record string) (blobstore.BlobReference, error) {
+	parts := strings.Split(strings.TrimSpace(record), ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return blobstore.BlobReference{}, ErrInvalidRecord
+	}
+	return blobstore.BlobReference{Bid: parts[0], Key: parts[1]}, nil
+}
@@ -0,0 +1,129 @@
+package names
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"net"
+	"testing"
+)
+
+func TestPetnames(t *testing.T) {
+
+	p := NewPetnames()
+	ref := blobstore.BlobReference{Bid: "bid", Key: "key"}
+	p.Set("docs", ref)
+	p.Set("music", ref)
+
+	var buff bytes.Buffer
+	if err := p.Save(&buff); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadPetnames(&buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := loaded.Resolve("docs"); err != nil || r != ref {
+		t.Fatalf("Invalid resolved petname: %v, %v", r, err)
+	}
+
+	loaded.Remove("docs")
+	if _, err = loaded.Resolve("docs"); err != ErrNameNotFound {
+		t.Fatalf("Invalid error for removed petname: %v", err)
+	}
+	if names := loaded.Names(); len(names) != 1 || names[0] != "music" {
+		t.Fatalf("Invalid petnames: %v", names)
+	}
+}
+
+func TestDNSResolver(t *testing.T) {
+
+	lookupErr := errors.New("Lookup error")
+	d := &DNSResolver{LookupTXT: func(name string) ([]string, error) {
+		switch name {
+		case "docs.example.com":
+			return []string{"v=spf1 -all", "cinode=bid:key"}, nil
+		case "broken.example.com":
+			return []string{"cinode=bid"}, nil
+		case "failing.example.com":
+			return nil, lookupErr
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}}
+
+	if r, err := d.Resolve("docs.example.com"); err != nil || r.Bid != "bid" || r.Key != "key" {
+		t.Fatalf("Invalid resolved name: %v, %v", r, err)
+	}
+	for name, expected := range map[string]error{
+		"docs":                ErrNameNotFound,
+		"missing.example.com": ErrNameNotFound,
+		"broken.example.com":  ErrInvalidRecord,
+		"failing.example.com": lookupErr,
+	} {
+		if _, err := d.Resolve(name); err != expected {
+			t.Fatalf("Invalid error for %v: %v", name, err)
+		}
+	}
+}
+
+func TestDereference(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Report"))
+	fileRef, _ := fw.Finalize()
+	fileBid, fileKey := fileRef.Bid, fileRef.Key
+
+	dw := blobstore.DirBlobWriter{Storage: storage}
+	dw.AddEntry(blobstore.DirEntry{Name: "report", MimeType: "text/plain", Bid: fileBid, Key: fileKey})
+	dirRef, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirBid, dirKey := dirRef.Bid, dirRef.Key
+	dir := blobstore.BlobReference{Bid: dirBid, Key: dirKey}
+
+	// Mutable link to the directory
+	_, privKey, _ := ed25519.GenerateKey(rand.Reader)
+	link, err := CreateLink(privKey, 1, dir, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	petnames := NewPetnames()
+	petnames.Set("docs", dir)
+	links := NewPetnames()
+	links.Set("linked", link)
+	resolver := Chain{petnames, &LinkResolver{Links: links, Storage: storage}}
+
+	for _, url := range []string{"cinode://docs/report", "cinode://linked/report"} {
+		ref, err := Dereference(resolver, url, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.Bid != fileBid || ref.Key != fileKey {
+			t.Fatalf("Invalid reference for %v", url)
+		}
+	}
+
+	// Link update
+	if _, err = CreateLink(privKey, 2, blobstore.BlobReference{Bid: fileBid, Key: fileKey}, storage); err != nil {
+		t.Fatal(err)
+	}
+	if ref, err := Dereference(resolver, "cinode://linked", storage); err != nil || ref.Bid != fileBid {
+		t.Fatalf("Link update not visible: %v, %v", ref, err)
+	}
+
+	for url, expected := range map[string]error{
+		"http://docs/report":    ErrInvalidURL,
+		"cinode://docs/missing": ErrPathNotFound,
+		"cinode://unknown":      ErrNameNotFound,
+	} {
+		if _, err := Dereference(resolver, url, storage); err != expected {
+			t.Fatalf("Invalid error for %v: %v", url, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package names
+
+import (
+	"encoding/json"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Petnames are local names chosen by the user
+type Petnames struct {
+	lock  sync.RWMutex
+	names map[string]blobstore.BlobReference
+}
+
+// Create empty set of petnames
+func NewPetnames() *Petnames {
+	return &Petnames{names: make(map[string]blobstore.BlobReference)}
+}
+
+// Load petnames saved with Save
+func LoadPetnames(r io.Reader) (*Petnames, error) {
+	p := NewPetnames()
+	if err := json.NewDecoder(r).Decode(&p.names); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Save petnames as JSON
+func (p *Petnames) Save(w io.Writer) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return json.NewEncoder(w).Encode(p.names)
+}
+
+// Assign the name to the reference, previous assignment is replaced
+func (p *Petnames) Set(name string, ref blobstore.BlobReference) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.names[name] = ref
+}
+
+// Remove the name
+func (p *Petnames) Remove(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.names, name)
+}
+
+// Get all names, sorted
+func (p *Petnames) Names() []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	names := make([]string, 0, len(p.names))
+	for name := range p.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Petnames) Resolve(name string) (blobstore.BlobReference, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	ref, ok := p.names[name]
+	if !ok {
+		return blobstore.BlobReference{}, ErrNameNotFound
+	}
+	return ref, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"context"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io"
+)
+
+// Storage wrapper fetching blobs missing in the storage of the node from
+// peers when they're read, blobs no peer holds are reported as not found.
+// Peers are asked one by one, each of them for at most the peer timeout
+// of the node.
+type HealingStorage struct {
+	blobstore.BlobStorage
+
+	node *Node
+	ctx  context.Context
+}
+
+// Get the wrapped storage
+func (h *HealingStorage) Unwrap() blobstore.BlobStorage {
+	return h.BlobStorage
+}
+
+// Get the storage of the node healing missing blobs
+func (n *Node) HealingStorage() *HealingStorage {
+	return &HealingStorage{BlobStorage: n.Storage, node: n, ctx: context.Background()}
+}
+
+func (h *HealingStorage) NewBlobReader(blobId string) (io.Reader, error) {
+	reader, err := h.BlobStorage.NewBlobReader(blobId)
+	if !errors.Is(err, blobstore.ErrBIDNotFound) {
+		return reader, err
+	}
+
+	err = h.node.Fetch(h.ctx, blobId)
+	if err == ErrBlobUnavailable {
+		h.node.Cancel(blobId)
+		return nil, blobstore.ErrBIDNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.BlobStorage.NewBlobReader(blobId)
+}
+
+// Fetching missing blobs is aborted once the context is done
+func (h *HealingStorage) WithContext(ctx context.Context) blobstore.BlobStorage {
+	return &HealingStorage{BlobStorage: h.BlobStorage, node: h.node, ctx: ctx}
+}
+
+func (h *HealingStorage) ListBlobs(prefix, cursor string, limit int) ([]blobstore.StoredBlob, string, error) {
+	return blobstore.ListBlobs(h.BlobStorage, prefix, cursor, limit)
+}
+
+func (h *HealingStorage) ExistsBatch(blobIds []string) (map[string]bool, error) {
+	return blobstore.ExistsBatch(h.BlobStorage, blobIds)
+}
+
+func (h *HealingStorage) Stat(blobId string) (blobstore.BlobStat, error) {
+	return blobstore.Stat(h.BlobStorage, blobId)
+}
@@ -0,0 +1,508 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package p2p exchanges blobs directly between nodes over TCP, nodes fetch
+// blobs missing in their storages from peers without a central server.
+//
+// Each node keeps the want list of blobs it's looking for. During the
+// exchange round peers are asked which of the wanted blobs they hold,
+// blobs are then fetched from the first peer holding them. Fetched blobs
+// are verified before they're stored, blobs rejected by the verification
+// are fetched from other peers.
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBlobUnavailable = errors.New("Blob is not available from any peer")
+	ErrNodeClosed      = errors.New("Node is closed")
+)
+
+// Time allowed for one request and its answer, DefaultRequestTimeout
+const DefaultRequestTimeout = 30 * time.Second
+
+// Time allowed for the exchange with one peer, DefaultPeerTimeout
+const DefaultPeerTimeout = 5 * time.Minute
+
+// Number of peers served at once, DefaultMaxConns
+const DefaultMaxConns = 16
+
+// Node serving blobs of its storage to peers and fetching wanted blobs
+// from them
+type Node struct {
+	Storage blobstore.BlobStorage
+
+	// Time allowed for one request and its answer on both sides of the
+	// connection, connections of peers stalling longer are closed.
+	// DefaultRequestTimeout is used if 0.
+	RequestTimeout time.Duration
+
+	// Time allowed for the exchange with one peer, peers are skipped
+	// once it elapses. DefaultPeerTimeout is used if 0.
+	PeerTimeout time.Duration
+
+	// Maximum number of peers served at once, connections over the
+	// limit are closed right away. Each of them may keep MaxBlobSize
+	// bytes in memory. DefaultMaxConns is used if 0.
+	MaxConns int
+
+	lock      sync.Mutex
+	peers     map[string]bool // Addresses of peers
+	wants     map[string]bool // Blob ids of the want list
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool // Connections of peers being served
+	closed    bool
+
+	// Serializes exchange rounds
+	exchange sync.Mutex
+}
+
+// Create the node of the storage
+func NewNode(storage blobstore.BlobStorage) *Node {
+	return &Node{
+		Storage:   storage,
+		peers:     make(map[string]bool),
+		wants:     make(map[string]bool),
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[net.Conn]bool),
+	}
+}
+
+// Add the peer with given TCP address
+func (n *Node) AddPeer(addr string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.peers[addr] = true
+}
+
+func (n *Node) RemovePeer(addr string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.peers, addr)
+}
+
+// Get addresses of peers, sorted
+func (n *Node) Peers() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return sortedKeys(n.peers)
+}
+
+// Add blobs to the want list, they're fetched by the next exchange round
+func (n *Node) Want(blobIds ...string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, bid := range blobIds {
+		if validBid(bid) {
+			n.wants[bid] = true
+		}
+	}
+}
+
+// Remove blobs from the want list
+func (n *Node) Cancel(blobIds ...string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, bid := range blobIds {
+		delete(n.wants, bid)
+	}
+}
+
+// Get the want list, sorted
+func (n *Node) Wants() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return sortedKeys(n.wants)
+}
+
+func (n *Node) requestTimeout() time.Duration {
+	if n.RequestTimeout <= 0 {
+		return DefaultRequestTimeout
+	}
+	return n.RequestTimeout
+}
+
+func (n *Node) peerTimeout() time.Duration {
+	if n.PeerTimeout <= 0 {
+		return DefaultPeerTimeout
+	}
+	return n.PeerTimeout
+}
+
+func (n *Node) maxConns() int {
+	if n.MaxConns <= 0 {
+		return DefaultMaxConns
+	}
+	return n.MaxConns
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Serve peers connecting through the listener until it's closed
+func (n *Node) Serve(listener net.Listener) error {
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		listener.Close()
+		return ErrNodeClosed
+	}
+	n.listeners[listener] = true
+	n.lock.Unlock()
+
+	defer func() {
+		n.lock.Lock()
+		delete(n.listeners, listener)
+		n.lock.Unlock()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			n.lock.Lock()
+			closed := n.closed
+			n.lock.Unlock()
+			if closed {
+				return ErrNodeClosed
+			}
+			return err
+		}
+
+		n.lock.Lock()
+		if n.closed {
+			n.lock.Unlock()
+			conn.Close()
+			return ErrNodeClosed
+		}
+		if len(n.conns) >= n.maxConns() {
+			n.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		n.conns[conn] = true
+		n.lock.Unlock()
+
+		go n.serveConn(conn)
+	}
+}
+
+// Stop serving peers, exchange rounds can still be run
+func (n *Node) Close() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.closed = true
+	for listener := range n.listeners {
+		listener.Close()
+	}
+	for conn := range n.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+func (n *Node) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		n.lock.Lock()
+		delete(n.conns, conn)
+		n.lock.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		if conn.SetDeadline(time.Now().Add(n.requestTimeout())) != nil {
+			return
+		}
+		msg, args, err := readMessage(reader)
+		if err != nil {
+			return
+		}
+
+		switch msg {
+		case msgHave:
+			err = n.serveHave(writer, args)
+		case msgGet:
+			err = n.serveGet(writer, args)
+		default:
+			err = writeMessage(writer, msgError, "unknown message")
+		}
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (n *Node) serveHave(writer io.Writer, bids []string) error {
+	if len(bids) > MaxHaveBatch {
+		return writeMessage(writer, msgError, "too many blob ids")
+	}
+	canonical := make([]string, len(bids))
+	for i, bid := range bids {
+		var err error
+		if canonical[i], err = blobstore.ParseBID(bid); err != nil {
+			return writeMessage(writer, msgError, "invalid blob id")
+		}
+	}
+	existing, err := blobstore.ExistsBatch(n.Storage, canonical)
+	if err != nil {
+		return writeMessage(writer, msgError, "storage failure")
+	}
+
+	var held []string
+	for i, bid := range bids {
+		if existing[canonical[i]] {
+			held = append(held, bid)
+		}
+	}
+	return writeMessage(writer, msgHave, held...)
+}
+
+func (n *Node) serveGet(writer io.Writer, args []string) error {
+	if len(args) != 1 {
+		return writeMessage(writer, msgError, "invalid get message")
+	}
+
+	bid, err := blobstore.ParseBID(args[0])
+	if err != nil {
+		return writeMessage(writer, msgError, "invalid blob id")
+	}
+	reader, err := n.Storage.NewBlobReader(bid)
+	if errors.Is(err, blobstore.ErrBIDNotFound) {
+		return writeMessage(writer, msgMissing)
+	}
+	if err != nil {
+		return writeMessage(writer, msgError, "storage failure")
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(reader, MaxBlobSize+1))
+	if c, ok := reader.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil || len(data) > MaxBlobSize {
+		return writeMessage(writer, msgError, "blob can not be sent")
+	}
+
+	if err = writeMessage(writer, msgBlob, strconv.Itoa(len(data))); err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
+// Run the exchange round, wanted blobs are fetched from peers holding
+// them. Fetched blobs are removed from the want list and their ids are
+// returned. Peers which can't be reached are skipped, the error is
+// returned only if the context is done or the storage fails.
+func (n *Node) Exchange(ctx context.Context) ([]string, error) {
+	n.exchange.Lock()
+	defer n.exchange.Unlock()
+
+	var fetched []string
+	for _, addr := range n.Peers() {
+		wants := n.Wants()
+		if len(wants) == 0 {
+			break
+		}
+
+		got, err := n.exchangeWith(ctx, addr, wants)
+		fetched = append(fetched, got...)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fetched, ctxErr
+		}
+		if err != nil && !isPeerError(err) {
+			return fetched, err
+		}
+	}
+	sort.Strings(fetched)
+	return fetched, nil
+}
+
+// Fetch the blob from peers, it's stored in the storage of the node.
+// The blob is left in the want list if no peer holds it.
+func (n *Node) Fetch(ctx context.Context, blobId string) error {
+	n.Want(blobId)
+	fetched, err := n.Exchange(ctx)
+	if err != nil {
+		return err
+	}
+	for _, bid := range fetched {
+		if bid == blobId {
+			return nil
+		}
+	}
+
+	// Another round might have fetched it already
+	exists, err := n.Storage.Exists(blobId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrBlobUnavailable
+	}
+	n.Cancel(blobId)
+	return nil
+}
+
+// Error caused by the peer or the connection to it
+type peerError struct {
+	err error
+}
+
+func (p *peerError) Error() string {
+	return "Peer failure: " + p.err.Error()
+}
+
+func (p *peerError) Unwrap() error {
+	return p.err
+}
+
+func isPeerError(err error) bool {
+	var pe *peerError
+	return errors.As(err, &pe)
+}
+
+// Fetch wanted blobs held by the peer, the exchange is
+// aborted once the peer timeout elapses
+func (n *Node) exchangeWith(ctx context.Context, addr string, wants []string) ([]string, error) {
+	peerCtx, cancel := context.WithTimeout(ctx, n.peerTimeout())
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(peerCtx, "tcp", addr)
+	if err != nil {
+		return nil, &peerError{err}
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(peerCtx, func() { conn.Close() })
+	defer stop()
+
+	reader := bufio.NewReader(conn)
+	var fetched []string
+	for len(wants) > 0 {
+		batch := wants
+		if len(batch) > MaxHaveBatch {
+			batch = batch[:MaxHaveBatch]
+		}
+		wants = wants[len(batch):]
+
+		if err = conn.SetDeadline(time.Now().Add(n.requestTimeout())); err != nil {
+			return fetched, &peerError{err}
+		}
+		held, err := askHave(conn, reader, batch)
+		if err != nil {
+			return fetched, err
+		}
+		for _, bid := range held {
+			if err = conn.SetDeadline(time.Now().Add(n.requestTimeout())); err != nil {
+				return fetched, &peerError{err}
+			}
+			data, err := askGet(conn, reader, bid)
+			if err != nil {
+				return fetched, err
+			}
+			if data == nil {
+				continue
+			}
+
+			// Blobs failing the verification are left
+			// in the want list for other peers
+			if blobstore.VerifyBlob(bid, bytes.NewReader(data)) != nil {
+				continue
+			}
+			if err = n.store(bid, data); err != nil {
+				return fetched, err
+			}
+			n.Cancel(bid)
+			fetched = append(fetched, bid)
+		}
+	}
+	return fetched, nil
+}
+
+func askHave(conn net.Conn, reader *bufio.Reader, bids []string) ([]string, error) {
+	if err := writeMessage(conn, msgHave, bids...); err != nil {
+		return nil, &peerError{err}
+	}
+	msg, held, err := readMessage(reader)
+	if err != nil {
+		return nil, &peerError{err}
+	}
+	if msg != msgHave {
+		return nil, &peerError{ErrProtocol}
+	}
+
+	// Only asked blobs are accepted
+	asked := make(map[string]bool, len(bids))
+	for _, bid := range bids {
+		asked[bid] = true
+	}
+	for _, bid := range held {
+		if !asked[bid] {
+			return nil, &peerError{ErrProtocol}
+		}
+	}
+	return held, nil
+}
+
+// Get the blob, nil if the peer does not have it
+func askGet(conn net.Conn, reader *bufio.Reader, bid string) ([]byte, error) {
+	if err := writeMessage(conn, msgGet, bid); err != nil {
+		return nil, &peerError{err}
+	}
+	msg, args, err := readMessage(reader)
+	if err != nil {
+		return nil, &peerError{err}
+	}
+	switch msg {
+	case msgMissing, msgError:
+		return nil, nil
+	case msgBlob:
+		size, err := parseBlobSize(args)
+		if err != nil {
+			return nil, &peerError{err}
+		}
+		data := make([]byte, size)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, &peerError{err}
+		}
+		return data, nil
+	}
+	return nil, &peerError{ErrProtocol}
+}
+
+func (n *Node) store(bid string, data []byte) error {
+	writer, err := n.Storage.NewBlobWriter(bid)
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(data); err != nil {
+		writer.Cancel()
+		return err
+	}
+	_, err = writer.Finalize()
+	return err
+}
@@ -0,0 +1,263 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/cinode/golib/blobstore"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func startNode(t *testing.T, storage blobstore.BlobStorage) (*Node, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := NewNode(storage)
+	go node.Serve(listener)
+	t.Cleanup(func() { node.Close() })
+	return node, listener.Addr().String()
+}
+
+func storeFile(t *testing.T, storage blobstore.BlobStorage, content string) blobstore.BlobReference {
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte(content))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.BlobReference
+}
+
+func TestExchange(t *testing.T) {
+
+	storageA := blobstore.NewMemoryBlobStorage()
+	storageB := blobstore.NewMemoryBlobStorage()
+	first := storeFile(t, storageA, "first")
+	second := storeFile(t, storageB, "second")
+	_, addrA := startNode(t, storageA)
+	_, addrB := startNode(t, storageB)
+
+	node := NewNode(blobstore.NewMemoryBlobStorage())
+	node.AddPeer("127.0.0.1:1")
+	node.AddPeer(addrA)
+	node.AddPeer(addrB)
+	node.Want(first.Bid, second.Bid, "0a55")
+
+	fetched, err := node.Exchange(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched) != 2 {
+		t.Fatalf("Invalid fetched blobs: %v", fetched)
+	}
+	if wants := node.Wants(); len(wants) != 1 || wants[0] != "0a55" {
+		t.Fatalf("Invalid want list: %v", wants)
+	}
+
+	reader, err := blobstore.OpenFileBlob(second.Bid, second.Key, node.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(reader); string(data) != "second" {
+		t.Fatalf("Invalid fetched content: %q", data)
+	}
+
+	if err = node.Fetch(context.Background(), "0a55"); err != ErrBlobUnavailable {
+		t.Fatalf("Invalid error of the unavailable blob: %v", err)
+	}
+}
+
+func TestExchangeRejectsInvalidBlobs(t *testing.T) {
+
+	// The peer serves a blob not matching its id
+	good := blobstore.NewMemoryBlobStorage()
+	ref := storeFile(t, good, "content")
+	bad := blobstore.NewMemoryBlobStorage()
+	other := storeFile(t, bad, "other")
+	reader, _ := bad.NewBlobReader(other.Bid)
+	data, _ := ioutil.ReadAll(reader)
+	writer, _ := bad.NewBlobWriter(ref.Bid)
+	writer.Write(data)
+	writer.Finalize()
+
+	_, addrBad := startNode(t, bad)
+	_, addrGood := startNode(t, good)
+
+	node := NewNode(blobstore.NewMemoryBlobStorage())
+	node.AddPeer(addrBad)
+	if err := node.Fetch(context.Background(), ref.Bid); err != ErrBlobUnavailable {
+		t.Fatalf("Invalid blob accepted: %v", err)
+	}
+	node.AddPeer(addrGood)
+	if err := node.Fetch(context.Background(), ref.Bid); err != nil {
+		t.Fatalf("Valid blob not fetched: %v", err)
+	}
+}
+
+func TestHealingStorage(t *testing.T) {
+
+	remote := blobstore.NewMemoryBlobStorage()
+	ref := storeFile(t, remote, "healed")
+	_, addr := startNode(t, remote)
+
+	node := NewNode(blobstore.NewMemoryBlobStorage())
+	node.AddPeer(addr)
+	storage := node.HealingStorage()
+
+	reader, err := blobstore.OpenFileBlob(ref.Bid, ref.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(reader); string(data) != "healed" {
+		t.Fatalf("Invalid healed content: %q", data)
+	}
+	if exists, _ := node.Storage.Exists(ref.Bid); !exists {
+		t.Fatalf("Healed blob not stored locally")
+	}
+
+	if _, err = storage.NewBlobReader("0a55"); err != blobstore.ErrBIDNotFound {
+		t.Fatalf("Invalid error of the missing blob: %v", err)
+	}
+	if wants := node.Wants(); len(wants) != 0 {
+		t.Fatalf("Want list not cleaned: %v", wants)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = blobstore.WithContext(ctx, storage).NewBlobReader("other"); err == nil {
+		t.Fatalf("Fetching with cancelled context succeeded")
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+
+	_, addr := startNode(t, blobstore.NewMemoryBlobStorage())
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for _, d := range []struct {
+		request  string
+		expected string
+	}{
+		{"unknown\n", msgError},
+		{"get\n", msgError},
+		{"get 0a55\n", msgMissing},
+		{"get ../../secret.txt\n", msgError},
+		{"have 0a 0b\n", msgHave},
+		{"have 0a ../secret.txt\n", msgError},
+	} {
+		conn.Write([]byte(d.request))
+		msg, _, err := readMessage(reader)
+		if err != nil || msg != d.expected {
+			t.Fatalf("Invalid response to %q: %v %v", d.request, msg, err)
+		}
+	}
+}
+
+func TestRequestsOutsideStorage(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-p2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0666)
+
+	node := NewNode(blobstore.NewFileBlobStorage(filepath.Join(dir, "store")))
+	var b bytes.Buffer
+	if err = node.serveGet(&b, []string{"../secret.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _, err := readMessage(bufio.NewReader(&b)); err != nil || msg != msgError {
+		t.Fatalf("File outside of the storage served: %v %v", msg, err)
+	}
+	b.Reset()
+	if err = node.serveHave(&b, []string{"../secret.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _, err := readMessage(bufio.NewReader(&b)); err != nil || msg != msgError {
+		t.Fatalf("File outside of the storage checked: %v %v", msg, err)
+	}
+}
+
+func TestStalledPeers(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := NewNode(blobstore.NewMemoryBlobStorage())
+	served.RequestTimeout, served.MaxConns = 300*time.Millisecond, 1
+	go served.Serve(listener)
+	defer served.Close()
+
+	// Connections over the limit are closed right away
+	first, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	first.Write([]byte("have 0a\n"))
+	if msg, _, err := readMessage(bufio.NewReader(first)); err != nil || msg != msgHave {
+		t.Fatalf("Invalid response: %v %v", msg, err)
+	}
+	second, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err = second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Connection over the limit not closed: %v", err)
+	}
+
+	// The peer not sending the next request is disconnected
+	first.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = first.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Stalled peer not disconnected: %v", err)
+	}
+
+	// Peers which never answer are skipped
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	go func() {
+		for {
+			conn, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	for _, timeouts := range []struct{ request, peer time.Duration }{
+		{100 * time.Millisecond, 0},
+		{time.Minute, 100 * time.Millisecond},
+	} {
+		node := NewNode(blobstore.NewMemoryBlobStorage())
+		node.RequestTimeout, node.PeerTimeout = timeouts.request, timeouts.peer
+		node.AddPeer(stalled.Addr().String())
+
+		start := time.Now()
+		if _, err = node.HealingStorage().NewBlobReader("0a55"); err != blobstore.ErrBIDNotFound {
+			t.Fatalf("Invalid error of the blob held by no peer: %v", err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Stalled peer not skipped in time with %+v", timeouts)
+		}
+	}
+}
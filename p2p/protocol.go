@@ -0,0 +1,89 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrProtocol = errors.New("Invalid message of the blob exchange protocol")
+)
+
+// Maximum number of blob ids in one have message
+const MaxHaveBatch = 1024
+
+// Maximum size of the blob sent to peers
+const MaxBlobSize = 64 * 1024 * 1024
+
+// Maximum length of a message line, blob ids are expected
+// to be hex-encoded SHA-512 hashes
+const maxLineSize = 16 + MaxHaveBatch*(128+1)
+
+// Messages are text lines, many requests can be sent over one connection.
+// Each request is answered before the next one is read:
+//
+//	have {bid}...  ask which of the blobs the peer holds,
+//	               answered with have listing those blobs
+//	get {bid}      ask for the blob, answered with blob {size}
+//	               followed by the raw blob, or with missing
+//
+// Failures of the peer are answered with error {message}.
+const (
+	msgHave    = "have"
+	msgGet     = "get"
+	msgBlob    = "blob"
+	msgMissing = "missing"
+	msgError   = "error"
+)
+
+// Read the message line split into fields
+func readMessage(reader *bufio.Reader) (string, []string, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return "", nil, err
+		}
+		line = append(line, part...)
+		if len(line) > maxLineSize {
+			return "", nil, ErrProtocol
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return "", nil, ErrProtocol
+	}
+	return fields[0], fields[1:], nil
+}
+
+func writeMessage(writer io.Writer, msg string, args ...string) error {
+	_, err := io.WriteString(writer, strings.Join(append([]string{msg}, args...), " ")+"\n")
+	return err
+}
+
+// Parse the size of the blob message
+func parseBlobSize(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, ErrProtocol
+	}
+	size, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || size < 0 || size > MaxBlobSize {
+		return 0, ErrProtocol
+	}
+	return size, nil
+}
+
+// Check whether the blob id can be sent in a message
+func validBid(bid string) bool {
+	return bid != "" && !strings.ContainsAny(bid, " \t\r\n")
+}
@@ -0,0 +1,228 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package refs keeps named mutable references. Each reference is a link,
+// the signed blob pointing to the current target, updated with the private
+// key of the link. Updates are compare-and-swap operations, the reference
+// advances only if it still points to the target the update started from.
+package refs
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/names"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrRefExists     = errors.New("Reference with given name already exists")
+	ErrRefConflict   = errors.New("Reference has been changed by another update")
+	ErrSignerInvalid = errors.New("Private key does not match the link of the reference")
+)
+
+// Maximum size of the link content
+const maxLinkSize = 4096
+
+// State of the reference
+type Ref struct {
+	Link    blobstore.BlobReference // Signed blob keeping the target, it never changes
+	Version int64                   // Version of the signed blob, increased by each update
+	Target  blobstore.BlobReference // Blob the reference points to
+}
+
+// Store of references by their names. Only names and links are kept
+// locally, targets are always read from the storage thus updates made
+// elsewhere with the same private key are visible.
+type Store struct {
+	storage blobstore.BlobStorage
+
+	lock  sync.Mutex
+	links map[string]blobstore.BlobReference
+}
+
+// Create empty store of references kept in the storage
+func NewStore(storage blobstore.BlobStorage) *Store {
+	return &Store{storage: storage, links: make(map[string]blobstore.BlobReference)}
+}
+
+// Load the store saved with Save
+func LoadStore(r io.Reader, storage blobstore.BlobStorage) (*Store, error) {
+	s := NewStore(storage)
+	if err := json.NewDecoder(r).Decode(&s.links); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save names and links of references as JSON
+func (s *Store) Save(w io.Writer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return json.NewEncoder(w).Encode(s.links)
+}
+
+// Create the reference pointing to the target, the link is signed
+// with given private key
+func (s *Store) Create(name string, signer crypto.Signer, target blobstore.BlobReference) (Ref, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exists := s.links[name]; exists {
+		return Ref{}, ErrRefExists
+	}
+	// The link of the private key used before can't be created again
+	link, err := names.CreateLink(signer, 1, target, s.storage)
+	if err == blobstore.ErrSignedBlobOutdated || err == blobstore.ErrSignedBlobConflict {
+		return Ref{}, ErrRefConflict
+	}
+	if err != nil {
+		return Ref{}, err
+	}
+	ref, err := readRef(link, s.storage)
+	if err != nil {
+		return Ref{}, err
+	}
+	if ref.Target != target {
+		return Ref{}, ErrRefConflict
+	}
+	s.links[name] = link
+	return ref, nil
+}
+
+// Track the reference with the link created elsewhere, such
+// references can be updated only with the private key of the link
+func (s *Store) Add(name string, link blobstore.BlobReference) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exists := s.links[name]; exists {
+		return ErrRefExists
+	}
+	s.links[name] = link
+	return nil
+}
+
+// Stop tracking the reference, the link is kept in the storage
+func (s *Store) Remove(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.links, name)
+}
+
+// Get all names, sorted
+func (s *Store) Names() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	refNames := make([]string, 0, len(s.links))
+	for name := range s.links {
+		refNames = append(refNames, name)
+	}
+	sort.Strings(refNames)
+	return refNames
+}
+
+// Get the current state of the reference, names.ErrNameNotFound
+// is returned for unknown names
+func (s *Store) Get(name string) (Ref, error) {
+	link, err := s.link(name)
+	if err != nil {
+		return Ref{}, err
+	}
+	return readRef(link, s.storage)
+}
+
+// Find the current target of the reference, this makes the store
+// usable as a name resolver
+func (s *Store) Resolve(name string) (blobstore.BlobReference, error) {
+	ref, err := s.Get(name)
+	if err != nil {
+		return blobstore.BlobReference{}, err
+	}
+	return ref.Target, nil
+}
+
+// Point the reference to the new target if it still points to the old one,
+// ErrRefConflict is returned otherwise. Concurrent updates through other
+// stores sharing the storage are detected, at most one of them succeeds.
+func (s *Store) CompareAndSwap(name string, signer crypto.Signer, old, new blobstore.BlobReference) (Ref, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	link, found := s.links[name]
+	if !found {
+		return Ref{}, names.ErrNameNotFound
+	}
+	bid, err := blobstore.SignedBlobId(signer.Public())
+	if err != nil {
+		return Ref{}, err
+	}
+	if bid != link.Bid {
+		return Ref{}, ErrSignerInvalid
+	}
+
+	current, err := readRef(link, s.storage)
+	if err != nil {
+		return Ref{}, err
+	}
+	if current.Target != old {
+		return Ref{}, ErrRefConflict
+	}
+
+	_, err = names.CreateLink(signer, current.Version+1, new, s.storage)
+	if err == blobstore.ErrSignedBlobOutdated || err == blobstore.ErrSignedBlobConflict {
+		return Ref{}, ErrRefConflict
+	}
+	if err != nil {
+		return Ref{}, err
+	}
+
+	// Another update may have been stored in the meantime
+	updated, err := readRef(link, s.storage)
+	if err != nil {
+		return Ref{}, err
+	}
+	if updated.Version != current.Version+1 || updated.Target != new {
+		return Ref{}, ErrRefConflict
+	}
+	return updated, nil
+}
+
+func (s *Store) link(name string) (blobstore.BlobReference, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	link, found := s.links[name]
+	if !found {
+		return blobstore.BlobReference{}, names.ErrNameNotFound
+	}
+	return link, nil
+}
+
+// Read the reference from its link, the signature is verified
+func readRef(link blobstore.BlobReference, storage blobstore.BlobStorage) (Ref, error) {
+	version, content, err := blobstore.OpenSignedBlob(link.Bid, link.Key, storage)
+	if err != nil {
+		return Ref{}, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(content, maxLinkSize+1))
+	if err != nil {
+		return Ref{}, err
+	}
+	if len(data) > maxLinkSize {
+		return Ref{}, names.ErrInvalidRecord
+	}
+	target, err := names.ParseRecord(string(data))
+	if err != nil {
+		return Ref{}, err
+	}
+	return Ref{Link: link, Version: version, Target: target}, nil
+}
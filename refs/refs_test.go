@@ -0,0 +1,131 @@
+package refs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/names"
+	"sync"
+	"testing"
+)
+
+func target(n byte) blobstore.BlobReference {
+	return blobstore.BlobReference{Bid: "bid" + string('0'+n), Key: "key" + string('0'+n)}
+}
+
+func TestStore(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	store := NewStore(storage)
+	_, privKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	ref, err := store.Create("my-photos", privKey, target(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Version != 1 || ref.Target != target(1) {
+		t.Fatalf("Invalid created reference: %+v", ref)
+	}
+	if _, err = store.Create("my-photos", privKey, target(2)); err != ErrRefExists {
+		t.Fatalf("Invalid error of duplicated name: %v", err)
+	}
+
+	updated, err := store.CompareAndSwap("my-photos", privKey, target(1), target(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 2 || updated.Target != target(2) || updated.Link != ref.Link {
+		t.Fatalf("Invalid updated reference: %+v", updated)
+	}
+	if _, err = store.CompareAndSwap("my-photos", privKey, target(1), target(3)); err != ErrRefConflict {
+		t.Fatalf("Update from outdated target not rejected: %v", err)
+	}
+	if resolved, err := store.Resolve("my-photos"); err != nil || resolved != target(2) {
+		t.Fatalf("Invalid resolved target: %v, %v", resolved, err)
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err = store.CompareAndSwap("my-photos", otherKey, target(2), target(3)); err != ErrSignerInvalid {
+		t.Fatalf("Update with other key not rejected: %v", err)
+	}
+	if _, err = store.Get("missing"); err != names.ErrNameNotFound {
+		t.Fatalf("Invalid error of unknown name: %v", err)
+	}
+	if _, err = store.CompareAndSwap("missing", privKey, target(1), target(2)); err != names.ErrNameNotFound {
+		t.Fatalf("Invalid error of unknown name: %v", err)
+	}
+
+	// References survive saving and loading, updates made through
+	// other stores are visible
+	var saved bytes.Buffer
+	if err = store.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadStore(&saved, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loaded.CompareAndSwap("my-photos", privKey, target(2), target(3)); err != nil {
+		t.Fatal(err)
+	}
+	if ref, err = store.Get("my-photos"); err != nil || ref.Version != 3 || ref.Target != target(3) {
+		t.Fatalf("Update made through other store not visible: %+v, %v", ref, err)
+	}
+
+	other := NewStore(storage)
+	if err = other.Add("photos", ref.Link); err != nil {
+		t.Fatal(err)
+	}
+	if resolved, err := (names.Chain{other}).Resolve("photos"); err != nil || resolved != target(3) {
+		t.Fatalf("Invalid target of added reference: %v, %v", resolved, err)
+	}
+	if refNames := other.Names(); len(refNames) != 1 || refNames[0] != "photos" {
+		t.Fatalf("Invalid names: %v", refNames)
+	}
+	other.Remove("photos")
+	if _, err = other.Get("photos"); err != names.ErrNameNotFound {
+		t.Fatalf("Removed reference still available: %v", err)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	_, privKey, _ := ed25519.GenerateKey(rand.Reader)
+	ref, err := NewStore(storage).Create("tree", privKey, target(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each store updates the reference from the same target,
+	// only one of the updates can succeed
+	var wg sync.WaitGroup
+	results := make(chan error, 8)
+	for i := 1; i <= 8; i++ {
+		store := NewStore(storage)
+		store.Add("tree", ref.Link)
+		wg.Add(1)
+		go func(n byte) {
+			defer wg.Done()
+			_, err := store.CompareAndSwap("tree", privKey, target(0), target(n))
+			results <- err
+		}(byte(i))
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		switch err {
+		case nil:
+			succeeded++
+		case ErrRefConflict:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("Invalid number of successful updates: %d", succeeded)
+	}
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# Copyright 2014 The Cinode Authors. All rights reserved.
+# Use of this source code is governed by a BSD-style
+# license that can be found in the LICENSE file.
+
+# Run benchmarks of the library and save results in the format read by
+# benchstat. To check a change for performance regressions run it before
+# and after the change and compare results:
+#
+#   scripts/bench.sh old.txt
+#   scripts/bench.sh new.txt
+#   benchstat old.txt new.txt
+#
+# Variables:
+#   BENCH     - benchmarks to run, all by default
+#   COUNT     - number of runs of each benchmark, 10 by default
+#   BENCHTIME - duration or number of iterations of each run
+#   PACKAGES  - packages to benchmark, blobstore and cipherfactory by default
+
+set -e
+
+output=${1:-bench.txt}
+packages=${PACKAGES:-"./blobstore ./cipherfactory"}
+
+cd "$(dirname "$0")/.."
+
+# Tests are skipped so that only benchmark lines end up in the output
+go test -run '^$' -bench "${BENCH:-.}" -benchmem \
+	-count "${COUNT:-10}" ${BENCHTIME:+-benchtime "$BENCHTIME"} \
+	$packages | tee "$output"
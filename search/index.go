@@ -0,0 +1,288 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package search contains a local index of names and paths of entries
+// found in decrypted directory trees
+package search
+
+import (
+	"encoding/gob"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNotPersistent = errors.New("Index is not backed by a file")
+	ErrUnknownRoot   = errors.New("Unknown index root")
+)
+
+// Separator of path elements
+const PathSeparator = "/"
+
+// Single search result
+type Result struct {
+	Root string // Name of the indexed tree
+	Path string // Full path of the entry within the tree
+	blobstore.DirEntry
+}
+
+// Indexed directory tree
+type tree struct {
+	Dirs    map[string]string // Bids of indexed directories by their path
+	Entries []Result          // All entries of the tree
+}
+
+// Index of entries of directory trees. The index can be kept in memory
+// only or be backed by a file, in the latter case it must be saved
+// explicitly. Since the index contains keys of indexed blobs, the file
+// must be protected the same way as the keys are.
+type Index struct {
+	lock     sync.RWMutex
+	fileName string
+	trees    map[string]*tree
+}
+
+// Create new in-memory index
+func NewIndex() *Index {
+	return &Index{trees: make(map[string]*tree)}
+}
+
+// Open the index backed by given file, the file does not have to exist
+func OpenIndex(fileName string) (*Index, error) {
+	index := NewIndex()
+	index.fileName = fileName
+
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err = gob.NewDecoder(file).Decode(&index.trees); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Write the index to its file
+func (i *Index) Save() error {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	if i.fileName == "" {
+		return ErrNotPersistent
+	}
+
+	// Replace the file atomically so that it's never partially written
+	file, err := ioutil.TempFile(filepath.Dir(i.fileName), ".index")
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(file).Encode(i.trees); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), i.fileName)
+}
+
+// Index the directory tree under given root name, previously indexed
+// content of the root is replaced. Subdirectories which did not change
+// since the last indexing are not read again.
+func (i *Index) IndexTree(root string, storage blobstore.BlobStorage, bid, key string) error {
+
+	i.lock.RLock()
+	previous := i.trees[root]
+	i.lock.RUnlock()
+
+	w := walker{
+		root:     root,
+		storage:  storage,
+		previous: previous,
+		current:  &tree{Dirs: make(map[string]string)},
+	}
+	if err := w.walk("", bid, key); err != nil {
+		return err
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.trees[root] = w.current
+	return nil
+}
+
+// Remove the tree from the index
+func (i *Index) Remove(root string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if _, ok := i.trees[root]; !ok {
+		return ErrUnknownRoot
+	}
+	delete(i.trees, root)
+	return nil
+}
+
+// Get names of indexed trees
+func (i *Index) Roots() []string {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	roots := make([]string, 0, len(i.trees))
+	for root := range i.trees {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// Find entries matching the query. The query consists of space-separated
+// terms, each of them must match. A term does match if the full path of
+// the entry contains it, terms with "type:" prefix match the mime type
+// prefix instead. Matching is case-insensitive. Results are sorted
+// by root and path.
+func (i *Index) Search(query string) []Result {
+	terms := strings.Fields(strings.ToLower(query))
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	var results []Result
+	for _, t := range i.trees {
+		for _, entry := range t.Entries {
+			if matches(&entry, terms) {
+				results = append(results, entry)
+			}
+		}
+	}
+
+	sort.Sort(byRootAndPath(results))
+	return results
+}
+
+func matches(entry *Result, terms []string) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(term, "type:") {
+			if !strings.HasPrefix(strings.ToLower(entry.MimeType), term[len("type:"):]) {
+				return false
+			}
+		} else if !strings.Contains(strings.ToLower(entry.Path), term) {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper for sorting results
+type byRootAndPath []Result
+
+func (s byRootAndPath) Len() int {
+	return len(s)
+}
+
+func (s byRootAndPath) Less(i, j int) bool {
+	if s[i].Root != s[j].Root {
+		return s[i].Root < s[j].Root
+	}
+	return s[i].Path < s[j].Path
+}
+
+func (s byRootAndPath) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Helper for walking a directory tree
+type walker struct {
+	root     string
+	storage  blobstore.BlobStorage
+	previous *tree // Previously indexed tree, nil if none
+	current  *tree // Tree being built
+}
+
+func (w *walker) walk(path, bid, key string) error {
+
+	w.current.Dirs[path] = bid
+
+	// Reuse unchanged subtree
+	if w.previous != nil && w.previous.Dirs[path] == bid {
+		w.reuse(path)
+		return nil
+	}
+
+	reader := blobstore.NewDirBlobReader(w.storage)
+	if err := reader.Open(bid, key); err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for reader.IsNextEntry() {
+		entry, err := reader.NextEntry()
+		if err != nil {
+			return err
+		}
+
+		entryPath := path + PathSeparator + entry.Name
+		w.current.Entries = append(w.current.Entries, Result{
+			Root:     w.root,
+			Path:     entryPath,
+			DirEntry: entry,
+		})
+
+		isDir, err := w.isDir(entryPath, entry)
+		if err != nil {
+			return err
+		}
+		if isDir {
+			if err = w.walk(entryPath, entry.Bid, entry.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Test whether the entry is a directory, directories known from
+// the previous tree don't have to be read
+func (w *walker) isDir(path string, entry blobstore.DirEntry) (bool, error) {
+	if !entry.IsBlob() {
+		return false, nil
+	}
+	if w.previous != nil && w.previous.Dirs[path] == entry.Bid {
+		return true, nil
+	}
+
+	info, err := blobstore.InspectBlobWithKey(entry.Bid, entry.Key, w.storage)
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// Copy entries of the unchanged directory from the previous tree
+func (w *walker) reuse(path string) {
+	prefix := path + PathSeparator
+	for dir, bid := range w.previous.Dirs {
+		if strings.HasPrefix(dir, prefix) {
+			w.current.Dirs[dir] = bid
+		}
+	}
+	for _, entry := range w.previous.Entries {
+		if strings.HasPrefix(entry.Path, prefix) {
+			w.current.Entries = append(w.current.Entries, entry)
+		}
+	}
+}
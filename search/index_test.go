@@ -0,0 +1,162 @@
+package search
+
+import (
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createFile(t *testing.T, storage blobstore.BlobStorage, name, content string) blobstore.DirEntry {
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte(content))
+	ref, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+	return blobstore.DirEntry{Name: name, MimeType: "text/plain", Bid: bid, Key: key}
+}
+
+func createDir(t *testing.T, storage blobstore.BlobStorage, name string, entries ...blobstore.DirEntry) blobstore.DirEntry {
+	dw := blobstore.DirBlobWriter{Storage: storage}
+	for _, entry := range entries {
+		dw.AddEntry(entry)
+	}
+	ref, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid, key := ref.Bid, ref.Key
+	return blobstore.DirEntry{Name: name, MimeType: "inode/directory", Bid: bid, Key: key}
+}
+
+func paths(results []Result) (ret []string) {
+	for _, r := range results {
+		ret = append(ret, r.Root+":"+r.Path)
+	}
+	return
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearch(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	docs := createDir(t, storage, "docs",
+		createFile(t, storage, "readme.txt", "Read me"),
+		createFile(t, storage, "Notes.txt", "Notes"))
+	root := createDir(t, storage, "",
+		docs,
+		createFile(t, storage, "notes.txt", "Other notes"))
+
+	index := NewIndex()
+	if err := index.IndexTree("home", storage, root.Bid, root.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, expected := range map[string][]string{
+		"notes":            {"home:/docs/Notes.txt", "home:/notes.txt"},
+		"docs notes":       {"home:/docs/Notes.txt"},
+		"type:inode":       {"home:/docs"},
+		"/docs type:text/": {"home:/docs/Notes.txt", "home:/docs/readme.txt"},
+		"missing":          nil,
+		"":                 {"home:/docs", "home:/docs/Notes.txt", "home:/docs/readme.txt", "home:/notes.txt"},
+	} {
+		if found := paths(index.Search(query)); !equalPaths(found, expected) {
+			t.Fatalf("Invalid results for query %q: %v", query, found)
+		}
+	}
+
+	// Results do contain data needed to open the file
+	result := index.Search("readme")[0]
+	reader := blobstore.NewFileBlobReader(storage)
+	if err := reader.Open(result.Bid, result.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := index.Remove("home"); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Remove("home"); err != ErrUnknownRoot {
+		t.Fatalf("Invalid error for removed root: %v", err)
+	}
+	if len(index.Search("")) != 0 {
+		t.Fatal("Removed tree is still searchable")
+	}
+}
+
+func TestIncrementalIndexing(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	docs := createDir(t, storage, "docs", createFile(t, storage, "a.txt", "a"))
+	root := createDir(t, storage, "", docs)
+
+	index := NewIndex()
+	if err := index.IndexTree("home", storage, root.Bid, root.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the new root and the new file are readable, unchanged
+	// subdirectory must be taken from the previous index
+	updated := blobstore.NewMemoryBlobStorage()
+	root = createDir(t, updated, "", docs, createFile(t, updated, "b.txt", "b"))
+	if err := index.IndexTree("home", updated, root.Bid, root.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"home:/b.txt", "home:/docs", "home:/docs/a.txt"}
+	if found := paths(index.Search("")); !equalPaths(found, expected) {
+		t.Fatalf("Invalid results after reindexing: %v", found)
+	}
+}
+
+func TestPersistentIndex(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cinode-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "index")
+
+	storage := blobstore.NewMemoryBlobStorage()
+	root := createDir(t, storage, "", createFile(t, storage, "a.txt", "a"))
+
+	index, err := OpenIndex(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = index.IndexTree("home", storage, root.Bid, root.Key); err != nil {
+		t.Fatal(err)
+	}
+	if err = index.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err = OpenIndex(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := paths(index.Search("a.txt")); !equalPaths(found, []string{"home:/a.txt"}) {
+		t.Fatalf("Invalid results of reopened index: %v", found)
+	}
+	if roots := index.Roots(); !equalPaths(roots, []string{"home"}) {
+		t.Fatalf("Invalid roots: %v", roots)
+	}
+
+	if err = NewIndex().Save(); err != ErrNotPersistent {
+		t.Fatalf("Invalid error when saving in-memory index: %v", err)
+	}
+}
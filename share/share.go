@@ -0,0 +1,104 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package share sends blob references to other users. The reference is
+// encrypted for the X25519 public key of the recipient, the resulting
+// token can be passed over public channels - neither the key nor the blob
+// id can be read from it without the private key of the recipient.
+package share
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/cipherfactory"
+)
+
+var (
+	ErrInvalidToken     = errors.New("Invalid share token")
+	ErrInvalidPublicKey = errors.New("Invalid public key - X25519 key is required")
+	ErrInvalidReference = errors.New("Invalid blob reference - hex-encoded id and key are required")
+	ErrTokenNotForKey   = errors.New("Share token can't be opened with given key")
+)
+
+// Tokens are encoded without padding, they're safe in URLs
+var tokenEncoding = base64.RawURLEncoding
+
+// Generate the X25519 key pair of the recipient
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// Encode the public key of the recipient to be sent to others
+func FormatPublicKey(pubKey *ecdh.PublicKey) string {
+	return tokenEncoding.EncodeToString(pubKey.Bytes())
+}
+
+// Decode the public key encoded with FormatPublicKey
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	data, err := tokenEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	pubKey, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	return pubKey, nil
+}
+
+// Create the token of the reference readable by the owner of the private
+// key only. The reference is sealed with cipherfactory.SealForRecipient,
+// a new ephemeral key is used for each token thus tokens of the same
+// reference differ.
+func Share(ref blobstore.BlobReference, recipient *ecdh.PublicKey) (string, error) {
+	if recipient == nil || recipient.Curve() != ecdh.X25519() {
+		return "", ErrInvalidPublicKey
+	}
+	bid, err1 := hex.DecodeString(ref.Bid)
+	key, err2 := hex.DecodeString(ref.Key)
+	if err1 != nil || err2 != nil || len(bid) == 0 || len(bid) > 255 || len(key) == 0 {
+		return "", ErrInvalidReference
+	}
+
+	payload := append(append([]byte{byte(len(bid))}, bid...), key...)
+	token, err := cipherfactory.SealForRecipient(payload, recipient)
+	if err != nil {
+		return "", err
+	}
+	return tokenEncoding.EncodeToString(token), nil
+}
+
+// Get the reference from the token created for the public key
+// of the private key
+func Open(token string, privKey *ecdh.PrivateKey) (blobstore.BlobReference, error) {
+	if privKey == nil || privKey.Curve() != ecdh.X25519() {
+		return blobstore.BlobReference{}, ErrInvalidPublicKey
+	}
+	data, err := tokenEncoding.DecodeString(token)
+	if err != nil {
+		return blobstore.BlobReference{}, ErrInvalidToken
+	}
+
+	payload, err := cipherfactory.OpenWithPrivateKey(data, privKey)
+	switch err {
+	case nil:
+	case cipherfactory.ErrKeyUnwrapFailed:
+		return blobstore.BlobReference{}, ErrTokenNotForKey
+	default:
+		return blobstore.BlobReference{}, ErrInvalidToken
+	}
+
+	if len(payload) < 1 || len(payload) <= 1+int(payload[0]) || payload[0] == 0 {
+		return blobstore.BlobReference{}, ErrInvalidToken
+	}
+	bidSize := int(payload[0])
+	return blobstore.BlobReference{
+		Bid: hex.EncodeToString(payload[1 : 1+bidSize]),
+		Key: hex.EncodeToString(payload[1+bidSize:]),
+	}, nil
+}
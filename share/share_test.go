@@ -0,0 +1,113 @@
+package share
+
+import (
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestShare(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	file, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ParsePublicKey(FormatPublicKey(privKey.PublicKey()))
+	if err != nil || !pubKey.Equal(privKey.PublicKey()) {
+		t.Fatalf("Invalid parsed public key: %v", err)
+	}
+
+	token, err := Share(file.BlobReference, pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(token, file.Bid) || strings.Contains(token, file.Key) {
+		t.Fatalf("Token reveals the reference: %v", token)
+	}
+	if other, _ := Share(file.BlobReference, pubKey); other == token {
+		t.Fatal("Tokens of the same reference are equal")
+	}
+
+	ref, err := Open(token, privKey)
+	if err != nil || ref != file.BlobReference {
+		t.Fatalf("Invalid reference opened: %v %v", ref, err)
+	}
+	reader, err := blobstore.OpenFileBlob(ref.Bid, ref.Key, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(reader); string(data) != "Hello World!" {
+		t.Fatalf("Invalid shared content: %q", data)
+	}
+
+	otherKey, _ := GenerateKey()
+	data, _ := tokenEncoding.DecodeString(token)
+	data[len(data)-1] ^= 0x01
+	corrupted := tokenEncoding.EncodeToString(data)
+	for _, d := range []struct {
+		token string
+		err   error
+	}{
+		{corrupted, ErrTokenNotForKey},
+		{token[:20], ErrInvalidToken},
+		{"!" + token, ErrInvalidToken},
+		{"", ErrInvalidToken},
+	} {
+		if _, err = Open(d.token, privKey); err != d.err {
+			t.Fatalf("Invalid error opening token: %v, expected %v", err, d.err)
+		}
+	}
+	if _, err = Open(token, otherKey); err != ErrTokenNotForKey {
+		t.Fatalf("Token opened with other key: %v", err)
+	}
+
+	for _, ref := range []blobstore.BlobReference{{}, {Bid: "bid", Key: file.Key}, {Bid: file.Bid}} {
+		if _, err = Share(ref, pubKey); err != ErrInvalidReference {
+			t.Fatalf("Invalid error sharing %v: %v", ref, err)
+		}
+	}
+	if _, err = ParsePublicKey("short"); err != ErrInvalidPublicKey {
+		t.Fatalf("Invalid error parsing public key: %v", err)
+	}
+}
+
+func TestShareOtherRecipient(t *testing.T) {
+
+	storage := blobstore.NewMemoryBlobStorage()
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	file, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := GenerateKey()
+	bob, _ := GenerateKey()
+	forAlice, err := Share(file.BlobReference, alice.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	forBob, err := Share(file.BlobReference, bob.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Open(forAlice, bob); err != ErrTokenNotForKey {
+		t.Fatalf("Token of other recipient opened: %v", err)
+	}
+	if _, err = Open(forBob, alice); err != ErrTokenNotForKey {
+		t.Fatalf("Token of other recipient opened: %v", err)
+	}
+	if ref, err := Open(forBob, bob); err != nil || ref != file.BlobReference {
+		t.Fatalf("Invalid reference opened: %v %v", ref, err)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package simulation contains an in-process network of in-memory nodes
+// used to test replication and garbage collection end-to-end
+package simulation
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownNode    = errors.New("Unknown node")
+	ErrNodeExists     = errors.New("Node with given name already exists")
+	ErrNodeDown       = errors.New("Node is down")
+	ErrPartitioned    = errors.New("Nodes are separated by a network partition")
+	ErrMessageDropped = errors.New("Message has been dropped by the network")
+)
+
+// Network of simulated nodes. Every transfer between nodes advances the
+// simulated clock by the latency of the link used and may fail due to
+// partitions, nodes being down or random message drops. The simulation
+// is deterministic for given seed and sequence of operations.
+type Network struct {
+	Clock *utils.ManualClock
+
+	lock           sync.Mutex
+	nodes          map[string]*Node
+	defaultLatency time.Duration
+	latency        map[link]time.Duration
+	partitioned    map[link]bool
+	dropRate       map[link]float64
+	rand           *rand.Rand
+}
+
+// Undirected link between two nodes
+type link struct {
+	a, b string
+}
+
+func newLink(a, b string) link {
+	if a > b {
+		a, b = b, a
+	}
+	return link{a, b}
+}
+
+// Create new simulated network
+func NewNetwork(clock *utils.ManualClock, seed int64) *Network {
+	return &Network{
+		Clock:       clock,
+		nodes:       make(map[string]*Node),
+		latency:     make(map[link]time.Duration),
+		partitioned: make(map[link]bool),
+		dropRate:    make(map[link]float64),
+		rand:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Add new node with empty in-memory storage
+func (n *Network) AddNode(name string) (*Node, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, exists := n.nodes[name]; exists {
+		return nil, ErrNodeExists
+	}
+
+	node := &Node{
+		Name:    name,
+		Storage: blobstore.NewMemoryBlobStorage(),
+		network: n,
+	}
+	n.nodes[name] = node
+	return node, nil
+}
+
+// Find node with given name
+func (n *Network) Node(name string) (*Node, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	node, ok := n.nodes[name]
+	if !ok {
+		return nil, ErrUnknownNode
+	}
+	return node, nil
+}
+
+// Set latency of links without explicitly set one
+func (n *Network) SetDefaultLatency(d time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.defaultLatency = d
+}
+
+// Set latency of the link between two nodes
+func (n *Network) SetLatency(a, b string, d time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.latency[newLink(a, b)] = d
+}
+
+// Set probability of dropping a transfer between two nodes
+func (n *Network) SetDropRate(a, b string, rate float64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.dropRate[newLink(a, b)] = rate
+}
+
+// Separate two nodes
+func (n *Network) Partition(a, b string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.partitioned[newLink(a, b)] = true
+}
+
+// Restore the connectivity between two nodes
+func (n *Network) Heal(a, b string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.partitioned, newLink(a, b))
+}
+
+// Simulate a transfer between two nodes
+func (n *Network) transfer(from, to string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, name := range []string{from, to} {
+		node, ok := n.nodes[name]
+		if !ok {
+			return ErrUnknownNode
+		}
+		if node.down {
+			return ErrNodeDown
+		}
+	}
+
+	l := newLink(from, to)
+	if n.partitioned[l] {
+		return ErrPartitioned
+	}
+
+	latency, ok := n.latency[l]
+	if !ok {
+		latency = n.defaultLatency
+	}
+	n.Clock.Advance(latency)
+
+	if rate := n.dropRate[l]; rate > 0 && n.rand.Float64() < rate {
+		return ErrMessageDropped
+	}
+	return nil
+}
+
+// Node of the simulated network
+type Node struct {
+	Name    string
+	Storage blobstore.BlobStorage // Local storage of the node
+
+	network *Network
+	down    bool
+}
+
+// Stop the node, transfers to and from the node will fail
+func (n *Node) Fail() {
+	n.network.lock.Lock()
+	defer n.network.lock.Unlock()
+	n.down = true
+}
+
+// Bring the failed node back, its storage is preserved
+func (n *Node) Recover() {
+	n.network.lock.Lock()
+	defer n.network.lock.Unlock()
+	n.down = false
+}
+
+// Get the storage of the peer as seen by this node over the network
+func (n *Node) Remote(peer string) blobstore.BlobStorage {
+	return &remoteStorage{network: n.network, local: n.Name, peer: peer}
+}
+
+// Storage of a peer accessed through the simulated network
+type remoteStorage struct {
+	network *Network
+	local   string
+	peer    string
+}
+
+func (r *remoteStorage) NewBlobWriter(blobId string) (writer blobstore.WriteFinalizeCanceler, err error) {
+	return &remoteWriter{storage: r, bid: blobId}, nil
+}
+
+func (r *remoteStorage) NewBlobReader(blobId string) (reader io.Reader, err error) {
+	peer, err := r.peerStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := peer.NewBlobReader(blobId)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := source.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	// Whole blob is transferred at once
+	blob, err := ioutil.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(blob), nil
+}
+
+func (r *remoteStorage) Exists(blobId string) (bool, error) {
+	peer, err := r.peerStorage()
+	if err != nil {
+		return false, err
+	}
+	return peer.Exists(blobId)
+}
+
+func (r *remoteStorage) Delete(blobId string) error {
+	peer, err := r.peerStorage()
+	if err != nil {
+		return err
+	}
+	return peer.Delete(blobId)
+}
+
+// Get the storage of the peer simulating a request over the network
+func (r *remoteStorage) peerStorage() (blobstore.BlobStorage, error) {
+	if err := r.network.transfer(r.local, r.peer); err != nil {
+		return nil, err
+	}
+
+	peer, err := r.network.Node(r.peer)
+	if err != nil {
+		return nil, err
+	}
+	return peer.Storage, nil
+}
+
+// Writer buffering the blob until it's sent to the peer
+type remoteWriter struct {
+	storage *remoteStorage
+	buffer  bytes.Buffer
+	bid     string
+}
+
+func (w *remoteWriter) Write(p []byte) (n int, err error) {
+	return w.buffer.Write(p)
+}
+
+func (w *remoteWriter) Finalize() (duplicate bool, err error) {
+	peer, err := w.storage.peerStorage()
+	if err != nil {
+		return false, err
+	}
+
+	writer, err := peer.NewBlobWriter(w.bid)
+	if err != nil {
+		return false, err
+	}
+	if _, err = writer.Write(w.buffer.Bytes()); err != nil {
+		writer.Cancel()
+		return false, err
+	}
+	return writer.Finalize()
+}
+
+func (w *remoteWriter) Cancel() error {
+	w.buffer.Reset()
+	return nil
+}
+
+func (w *remoteWriter) Close() error {
+	return w.Cancel()
+}
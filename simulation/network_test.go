@@ -0,0 +1,124 @@
+package simulation
+
+import (
+	"bytes"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func newTestNetwork(t *testing.T, names ...string) (*Network, []*Node) {
+	network := NewNetwork(utils.NewManualClock(time.Unix(0, 0)), 1)
+	nodes := make([]*Node, len(names))
+	for i, name := range names {
+		node, err := network.AddNode(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = node
+	}
+	return network, nodes
+}
+
+func createTree(t *testing.T, storage blobstore.BlobStorage) (bid, key string) {
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	fileRef, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileBid, fileKey := fileRef.Bid, fileRef.Key
+
+	dw := blobstore.DirBlobWriter{Storage: storage}
+	dw.AddEntry(blobstore.DirEntry{Name: "hello.txt", MimeType: "text/plain", Bid: fileBid, Key: fileKey})
+	ref, err := dw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.Bid, ref.Key
+}
+
+func TestReplicateTree(t *testing.T) {
+
+	network, nodes := newTestNetwork(t, "a", "b")
+	network.SetDefaultLatency(10 * time.Millisecond)
+	a, b := nodes[0], nodes[1]
+
+	bid, key, err := blobstore.CreateTypedBlob(0x01, []byte("data"), a.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirBid, dirKey := createTree(t, a.Storage)
+
+	start := network.Clock.Now()
+	if err = Replicate(b.Remote("a"), b.Storage, dirBid, dirKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directory and file blob
+	if elapsed := network.Clock.Now().Sub(start); elapsed != 20*time.Millisecond {
+		t.Fatalf("Invalid simulated time: %v", elapsed)
+	}
+
+	reader := blobstore.NewFileBlobReader(b.Storage)
+	refs, _ := blobstore.GetBlobReferences(dirBid, dirKey, b.Storage)
+	if err = reader.Open(refs[0].Bid, refs[0].Key); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	if !bytes.Equal(data, []byte("Hello World!")) {
+		t.Fatalf("Invalid replicated data: %v", data)
+	}
+
+	// Push from a to b, blob is written over the network
+	if err = Replicate(a.Storage, a.Remote("b"), bid, key); err != nil {
+		t.Fatal(err)
+	}
+	if err = blobstore.ValidateBlob(bid, key, b.Storage); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkFailures(t *testing.T) {
+
+	network, nodes := newTestNetwork(t, "a", "b", "c")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	bid, key := createTree(t, a.Storage)
+
+	network.Partition("a", "b")
+	if err := Replicate(b.Remote("a"), b.Storage, bid, key); err != ErrPartitioned {
+		t.Fatalf("Invalid error for partitioned nodes: %v", err)
+	}
+
+	// Data can still reach b through c
+	if err := Replicate(c.Remote("a"), c.Storage, bid, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := Replicate(b.Remote("c"), b.Storage, bid, key); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Fail()
+	if _, err := b.Remote("c").NewBlobReader(bid); err != ErrNodeDown {
+		t.Fatalf("Invalid error for failed node: %v", err)
+	}
+	c.Recover()
+	if _, err := b.Remote("c").NewBlobReader(bid); err != nil {
+		t.Fatal(err)
+	}
+
+	network.Heal("a", "b")
+	network.SetDropRate("a", "b", 1)
+	if _, err := b.Remote("a").NewBlobReader(bid); err != ErrMessageDropped {
+		t.Fatalf("Invalid error for dropped message: %v", err)
+	}
+
+	if _, err := b.Remote("x").NewBlobReader(bid); err != ErrUnknownNode {
+		t.Fatalf("Invalid error for unknown node: %v", err)
+	}
+	if _, err := network.AddNode("a"); err != ErrNodeExists {
+		t.Fatalf("Invalid error for duplicate node: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package simulation
+
+import (
+	"github.com/cinode/golib/blobstore"
+	"io"
+)
+
+// Copy the blob and all blobs reachable from it from the source storage
+// to the destination one. Blobs already present in the destination are
+// not copied again. References are followed only for blobs with known key.
+func Replicate(source, destination blobstore.BlobStorage, bid, key string) error {
+
+	if err := copyBlob(source, destination, bid); err != nil {
+		return err
+	}
+
+	if key == "" {
+		return nil
+	}
+
+	refs, err := blobstore.GetBlobReferences(bid, key, destination)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if err = Replicate(source, destination, ref.Bid, ref.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy one blob unless it does exist in the destination
+func copyBlob(source, destination blobstore.BlobStorage, bid string) error {
+
+	exists, err := destination.Exists(bid)
+	if err != nil || exists {
+		return err
+	}
+
+	reader, err := source.NewBlobReader(bid)
+	if err != nil {
+		return err
+	}
+	defer closeReader(reader)
+
+	writer, err := destination.NewBlobWriter(bid)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(writer, reader); err != nil {
+		writer.Cancel()
+		return err
+	}
+	_, err = writer.Finalize()
+	return err
+}
+
+func closeReader(reader io.Reader) {
+	if c, ok := reader.(io.Closer); ok {
+		c.Close()
+	}
+}
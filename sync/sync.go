@@ -0,0 +1,258 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sync replicates blobs between storages, i.e. pushes local blobs
+// to a remote node
+package sync
+
+import (
+	"context"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Number of blobs listed and checked in the destination at once,
+// the checkpoint advances by whole pages
+const pageSize = 256
+
+// Options of the synchronization
+type Options struct {
+
+	// Number of blobs copied at once, 1 if not positive
+	Parallelism int
+
+	// Limit of bytes copied per second by all workers together,
+	// unlimited if not positive
+	BytesPerSecond int64
+
+	// Checkpoint of the interrupted synchronization, blobs with ids up to
+	// the checkpoint are not visited again
+	Checkpoint string
+
+	// Called after each page of blobs, nil if not needed
+	Progress func(Progress)
+
+	// Called with the number of bytes copied so far and the id of the blob
+	// being copied, nil if not needed. The total is not known before the
+	// synchronization ends.
+	ByteProgress blobstore.ProgressFunc
+
+	// Minimum time between calls of ByteProgress,
+	// blobstore.DefaultProgressInterval if 0
+	ProgressInterval time.Duration
+
+	// Source of time of the bandwidth limit and of progress calls,
+	// system clock if not set
+	Clock utils.Clock
+}
+
+// State of the synchronization
+type Progress struct {
+	Checked     int   // Number of blobs listed in the source
+	Copied      int   // Number of blobs copied to the destination
+	Skipped     int   // Number of blobs already held by the destination
+	BytesCopied int64 // Size of copied blobs
+
+	// All blobs with ids up to the checkpoint are synchronized, passing it
+	// in Options.Checkpoint resumes the synchronization
+	Checkpoint string
+}
+
+// Copy blobs of the source missing in the destination, the source must
+// implement blobstore.Lister. Blobs are visited in the order of their ids,
+// existence of each page of them is checked with a single batched query.
+// Blobs are copied as they are stored, they are not verified. Signed blobs
+// already held by the destination are skipped even if the source keeps
+// a newer version.
+//
+// On error the progress of pages synchronized so far is returned, its
+// checkpoint can be used to resume the synchronization.
+func Sync(src, dst blobstore.BlobStorage, options Options) (Progress, error) {
+
+	progress := Progress{Checkpoint: options.Checkpoint}
+	workers := options.Parallelism
+	if workers <= 0 {
+		workers = 1
+	}
+	clock := options.Clock
+	if clock == nil {
+		clock = utils.SystemClock
+	}
+	var limit *limiter
+	if options.BytesPerSecond > 0 {
+		limit = &limiter{rate: options.BytesPerSecond, clock: clock}
+	}
+	// Bytes of copies failed or found duplicate in the end are counted too
+	counter := blobstore.NewProgressCounter(options.ByteProgress, options.ProgressInterval, clock, blobstore.BlobInfoUnknown)
+
+	for cursor := options.Checkpoint; ; {
+		blobs, next, err := blobstore.ListBlobs(src, "", cursor, pageSize)
+		if err != nil {
+			return progress, err
+		}
+
+		bids := make([]string, len(blobs))
+		for i, blob := range blobs {
+			bids[i] = blob.Bid
+		}
+		existing, err := blobstore.ExistsBatch(dst, bids)
+		if err != nil {
+			return progress, err
+		}
+
+		var missing []string
+		for _, bid := range bids {
+			if !existing[bid] {
+				missing = append(missing, bid)
+			}
+		}
+		page, err := copyBlobs(src, dst, missing, workers, limit, counter)
+		if err != nil {
+			return progress, err
+		}
+
+		progress.Checked += len(bids)
+		progress.Copied += page.Copied
+		progress.Skipped += len(bids) - page.Copied
+		progress.BytesCopied += page.BytesCopied
+		if len(bids) > 0 {
+			progress.Checkpoint = bids[len(bids)-1]
+		}
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+
+		if next == "" {
+			counter.Finish()
+			return progress, nil
+		}
+		cursor = next
+	}
+}
+
+// Result of copying a single blob
+type copyResult struct {
+	copied bool
+	size   int64
+	err    error
+}
+
+// Copy blobs using given number of workers, the first error is returned
+// once all started copies end
+func copyBlobs(src, dst blobstore.BlobStorage, bids []string, workers int, limit *limiter, counter *blobstore.ProgressCounter) (Progress, error) {
+
+	queue := make(chan string)
+	results := make(chan copyResult)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for bid := range queue {
+				copied, size, err := copyBlob(src, dst, bid, limit, counter)
+				results <- copyResult{copied, size, err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, bid := range bids {
+			queue <- bid
+		}
+		close(queue)
+	}()
+
+	var progress Progress
+	var err error
+	for range bids {
+		result := <-results
+		switch {
+		case result.err != nil:
+			if err == nil {
+				err = result.err
+			}
+		case result.copied:
+			progress.Copied++
+			progress.BytesCopied += result.size
+		}
+	}
+	return progress, err
+}
+
+// Copy the blob as it is stored, blobs deleted from the source or written
+// to the destination in the meantime are not copied
+func copyBlob(src, dst blobstore.BlobStorage, bid string, limit *limiter, counter *blobstore.ProgressCounter) (copied bool, size int64, err error) {
+
+	reader, err := src.NewBlobReader(bid)
+	if err == blobstore.ErrBIDNotFound {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	defer closeReader(reader)
+
+	writer, err := dst.NewBlobWriter(bid)
+	if err != nil {
+		return false, 0, err
+	}
+	if limit != nil {
+		reader = &limitedReader{reader: reader, limit: limit}
+	}
+	reader = counter.Reader(reader, bid)
+	if size, err = io.Copy(writer, reader); err != nil {
+		writer.Cancel()
+		return false, 0, err
+	}
+	duplicate, err := writer.Finalize()
+	if err != nil {
+		return false, 0, err
+	}
+	return !duplicate, size, nil
+}
+
+func closeReader(reader io.Reader) {
+	if c, ok := reader.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// Bandwidth limit shared by all workers, transfers reserve consecutive
+// time slots proportional to their sizes
+type limiter struct {
+	rate  int64        // Bytes per second
+	clock utils.Clock  // Source of time
+	next  atomic.Int64 // End of the last reserved slot in nanoseconds
+}
+
+// Wait until transferring n bytes fits in the limit
+func (l *limiter) wait(n int) {
+	cost := int64(n) * int64(time.Second) / l.rate
+	for {
+		now := l.clock.Now().UnixNano()
+		next := l.next.Load()
+		start := next
+		if start < now {
+			start = now
+		}
+		if l.next.CompareAndSwap(next, start+cost) {
+			l.clock.Sleep(context.Background(), time.Duration(start+cost-now))
+			return
+		}
+	}
+}
+
+// Reader respecting the bandwidth limit
+type limitedReader struct {
+	reader io.Reader
+	limit  *limiter
+}
+
+func (l *limitedReader) Read(p []byte) (n int, err error) {
+	n, err = l.reader.Read(p)
+	if n > 0 {
+		l.limit.wait(n)
+	}
+	return
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/utils"
+	"testing"
+	"time"
+)
+
+func putBlob(t *testing.T, storage blobstore.BlobStorage, bid string, data []byte) {
+	w, err := storage.NewBlobWriter(bid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if _, err = w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Storage failing writes after the given number of them
+type failingStorage struct {
+	blobstore.BlobStorage
+	writesLeft int
+}
+
+func (f *failingStorage) NewBlobWriter(blobId string) (blobstore.WriteFinalizeCanceler, error) {
+	if f.writesLeft == 0 {
+		return nil, errors.New("Write failed")
+	}
+	f.writesLeft--
+	return f.BlobStorage.NewBlobWriter(blobId)
+}
+
+func TestSync(t *testing.T) {
+
+	src, dst := blobstore.NewMemoryBlobStorage(), blobstore.NewMemoryBlobStorage()
+	count := pageSize + 44
+	for i := 0; i < count; i++ {
+		bid := fmt.Sprintf("bid%04d", i)
+		putBlob(t, src, bid, []byte(bid))
+		if i%3 == 0 {
+			putBlob(t, dst, bid, []byte(bid))
+		}
+	}
+
+	pages := 0
+	var bytesDone, bytesTotal int64
+	progress, err := Sync(src, dst, Options{
+		Parallelism:      4,
+		Progress:         func(Progress) { pages++ },
+		ByteProgress:     func(done, total int64, bid string) { bytesDone, bytesTotal = done, total },
+		ProgressInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Checked != count || progress.Skipped != (count+2)/3 ||
+		progress.Copied != count-progress.Skipped || progress.BytesCopied != int64(7*progress.Copied) ||
+		progress.Checkpoint != fmt.Sprintf("bid%04d", count-1) || pages != 2 {
+		t.Fatalf("Invalid progress: %+v, %v pages", progress, pages)
+	}
+	if bytesDone != progress.BytesCopied || bytesTotal != bytesDone {
+		t.Fatalf("Invalid final byte progress: %v of %v", bytesDone, bytesTotal)
+	}
+	if blobs, _ := blobstore.ListAllBlobs(dst); len(blobs) != count {
+		t.Fatalf("Invalid number of synchronized blobs: %v", len(blobs))
+	}
+}
+
+func TestSyncResume(t *testing.T) {
+
+	src, dst := blobstore.NewMemoryBlobStorage(), blobstore.NewMemoryBlobStorage()
+	count := 2*pageSize + 10
+	for i := 0; i < count; i++ {
+		bid := fmt.Sprintf("bid%04d", i)
+		putBlob(t, src, bid, []byte(bid))
+	}
+
+	// Interrupted in the second page, the first one is done
+	progress, err := Sync(src, &failingStorage{BlobStorage: dst, writesLeft: pageSize + 10}, Options{})
+	if err == nil || progress.Copied != pageSize || progress.Checkpoint != fmt.Sprintf("bid%04d", pageSize-1) {
+		t.Fatalf("Invalid progress of the interrupted sync: %+v, %v", progress, err)
+	}
+
+	progress, err = Sync(src, dst, Options{Checkpoint: progress.Checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Checked != count-pageSize || progress.Skipped != 10 || progress.Copied != count-pageSize-10 {
+		t.Fatalf("Invalid progress of the resumed sync: %+v", progress)
+	}
+}
+
+func TestSyncBandwidth(t *testing.T) {
+
+	src, dst := blobstore.NewMemoryBlobStorage(), blobstore.NewMemoryBlobStorage()
+	for i := 0; i < 4; i++ {
+		putBlob(t, src, fmt.Sprint("bid", i), make([]byte, 5000))
+	}
+
+	start := time.Unix(0, 0)
+	clock := utils.NewManualClock(start)
+	if _, err := Sync(src, dst, Options{Parallelism: 4, BytesPerSecond: 100000, Clock: clock}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("Bandwidth limit not respected: %v", elapsed)
+	}
+}
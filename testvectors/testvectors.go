@@ -0,0 +1,328 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package testvectors generates canonical blobs of every built-in blob
+// format from fixed inputs. Other implementations of the blob format can
+// check their interoperability against them: the content of each vector
+// must be encrypted to exactly the same blob, blob id and key, and the
+// blob must decrypt back to the content.
+//
+// Vectors are generated through the public API of the blobstore package
+// only, the golden file in testdata is regenerated with:
+//
+//	go test ./testvectors -update
+//
+// or with the testvectors command of the cinode tool.
+package testvectors
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/blobstore/wire"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+var (
+	ErrVectorMismatch = errors.New("Test vector does not match the blob")
+)
+
+// Single blob with its unencrypted content
+type Vector struct {
+	Name string `json:"name"`
+	Bid  string `json:"bid"`
+	Key  string `json:"key"`
+
+	// Unencrypted content, the blob type followed by the data for
+	// hash-validated blobs or the signed data for signed ones
+	Content []byte `json:"content"`
+
+	// Raw blob as kept in storages
+	Blob []byte `json:"blob"`
+
+	// Version of the signed blob and the PKCS#8 private key
+	// it's signed with, signed blobs only
+	Version    int64  `json:"version,omitempty"`
+	PrivateKey []byte `json:"privateKey,omitempty"`
+}
+
+// Source of the signing key of signed vectors
+const signingSeed = "cinode test vector signing key"
+
+// Generate vectors of all built-in formats except compressed files, the
+// compressed data depends on the compressor implementation. Blobs
+// referenced by vectors are included as separate vectors. Master blobs
+// of split files and split directories reference other vectors, sizes
+// and numbers of entries they declare don't match those vectors since
+// full partial blobs would be too large.
+func Generate() ([]Vector, error) {
+	g := generator{storage: blobstore.NewMemoryBlobStorage(), added: make(map[string]bool)}
+
+	empty := g.file("empty file", nil, nil)
+	hello := g.file("simple file", []byte("Hello World!"), nil)
+	g.file("chunked file", pattern(2500), &blobstore.WriterConfig{ChunkSize: 1000})
+
+	var split []byte
+	split = wire.AppendInt(split, blobstore.BlobTypeSplitFile)
+	split = wire.AppendInt(split, 16*1024*1024+12)
+	split = wire.AppendInt(split, 2)
+	for _, ref := range []blobstore.BlobReference{empty, hello} {
+		split = wire.AppendString(wire.AppendString(split, ref.Bid), ref.Key)
+	}
+	g.typed("split file", split)
+
+	modTime := time.Date(2014, 5, 13, 16, 53, 20, 0, time.UTC)
+	simple := g.dir("simple directory", nil,
+		blobstore.DirEntry{Name: "hello.txt", MimeType: "text/plain", Bid: hello.Bid, Key: hello.Key},
+		blobstore.DirEntry{Name: "empty", Bid: empty.Bid, Key: empty.Key})
+	extended := g.dir("simple directory v2", nil,
+		blobstore.DirEntry{Name: "hello.txt", MimeType: "text/plain", Bid: hello.Bid, Key: hello.Key,
+			Mode: 0644, ModTime: modTime},
+		blobstore.DirEntry{Name: "link", Type: blobstore.EntryTypeSymlink, Target: "hello.txt"})
+
+	split = wire.AppendInt(nil, blobstore.BlobTypeSplitDir)
+	split = wire.AppendInt(split, 1024+2)
+	split = wire.AppendInt(split, 2)
+	for _, ref := range []blobstore.BlobReference{simple, extended} {
+		split = wire.AppendString(wire.AppendString(split, ref.Bid), ref.Key)
+	}
+	g.typed("split directory", split)
+
+	g.dir("chunked directory", &blobstore.WriterConfig{MaxDirEntries: 2},
+		blobstore.DirEntry{Name: "a", Bid: hello.Bid, Key: hello.Key},
+		blobstore.DirEntry{Name: "b", Bid: empty.Bid, Key: empty.Key},
+		blobstore.DirEntry{Name: "c", Bid: simple.Bid, Key: simple.Key})
+
+	g.commit("commit", blobstore.Commit{Root: simple, Time: modTime, Message: "Initial commit"})
+
+	g.link("link", blobstore.Link{Target: hello, MimeType: "text/plain", Name: "hello.txt"})
+
+	g.signed("signed blob", 1, []byte(simple.Bid+" "+simple.Key))
+
+	return g.vectors, g.err
+}
+
+type generator struct {
+	storage blobstore.BlobStorage
+	vectors []Vector
+	added   map[string]bool
+	err     error
+}
+
+// Repeatable content of given size
+func pattern(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func (g *generator) file(name string, data []byte, config *blobstore.WriterConfig) blobstore.BlobReference {
+	if g.err != nil {
+		return blobstore.BlobReference{}
+	}
+	fw := blobstore.FileBlobWriter{Storage: g.storage, Config: config}
+	fw.Write(data)
+	result, err := fw.Finalize()
+	if g.err = err; err == nil {
+		g.addTree(name, result.BlobReference)
+	}
+	return result.BlobReference
+}
+
+func (g *generator) dir(name string, config *blobstore.WriterConfig, entries ...blobstore.DirEntry) blobstore.BlobReference {
+	if g.err != nil {
+		return blobstore.BlobReference{}
+	}
+	dw := blobstore.DirBlobWriter{Storage: g.storage, Config: config}
+	for _, entry := range entries {
+		dw.AddEntry(entry)
+	}
+	result, err := dw.Finalize()
+	if g.err = err; err == nil {
+		g.addTree(name, result.BlobReference)
+	}
+	return result.BlobReference
+}
+
+// Create the blob from the content starting with the blob type
+func (g *generator) typed(name string, content []byte) {
+	if g.err != nil {
+		return
+	}
+	blobType, n := binary.Uvarint(content)
+	bid, key, err := blobstore.CreateTypedBlob(int64(blobType), content[n:], g.storage)
+	if g.err = err; err == nil {
+		g.add(name, blobstore.BlobReference{Bid: bid, Key: key})
+	}
+}
+
+func (g *generator) commit(name string, commit blobstore.Commit) {
+	if g.err != nil {
+		return
+	}
+	ref, err := blobstore.CreateCommit(commit, g.storage)
+	if g.err = err; err == nil {
+		g.add(name, ref)
+	}
+}
+
+func (g *generator) link(name string, link blobstore.Link) {
+	if g.err != nil {
+		return
+	}
+	ref, err := blobstore.CreateLinkBlob(link, g.storage)
+	if g.err = err; err == nil {
+		g.add(name, ref)
+	}
+}
+
+func (g *generator) signed(name string, version int64, content []byte) {
+	if g.err != nil {
+		return
+	}
+	seed := sha512.Sum512([]byte(signingSeed))
+	privKey := ed25519.NewKeyFromSeed(seed[:ed25519.SeedSize])
+	bid, key, err := blobstore.CreateSignedBlob(privKey, version, content, g.storage)
+	if g.err = err; err != nil {
+		return
+	}
+	v := Vector{Name: name, Bid: bid, Key: key, Content: content, Version: version}
+	if v.PrivateKey, g.err = x509.MarshalPKCS8PrivateKey(privKey); g.err == nil {
+		v.Blob, g.err = g.rawBlob(bid)
+	}
+	g.vectors = append(g.vectors, v)
+}
+
+// Add the vector of the hash-validated blob followed
+// by vectors of blobs it references
+func (g *generator) addTree(name string, ref blobstore.BlobReference) {
+	g.add(name, ref)
+	refs, err := blobstore.GetBlobReferences(ref.Bid, ref.Key, g.storage)
+	if err != nil {
+		g.err = err
+		return
+	}
+	for i, part := range refs {
+		if !g.added[part.Bid] && g.err == nil {
+			g.addTree(fmt.Sprintf("%s part %d", name, i), part)
+		}
+	}
+}
+
+func (g *generator) add(name string, ref blobstore.BlobReference) {
+	if g.added[ref.Bid] || g.err != nil {
+		return
+	}
+	v := Vector{Name: name, Bid: ref.Bid, Key: ref.Key}
+	if v.Content, g.err = typedContent(ref, g.storage); g.err != nil {
+		return
+	}
+	if v.Blob, g.err = g.rawBlob(ref.Bid); g.err != nil {
+		return
+	}
+	g.added[ref.Bid] = true
+	g.vectors = append(g.vectors, v)
+}
+
+func (g *generator) rawBlob(bid string) ([]byte, error) {
+	reader, err := g.storage.NewBlobReader(bid)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// Get the unencrypted content of the hash-validated blob
+func typedContent(ref blobstore.BlobReference, storage blobstore.BlobStorage) ([]byte, error) {
+	blobType, content, err := blobstore.OpenTypedBlob(ref.Bid, ref.Key, storage)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	return append(wire.AppendInt(nil, blobType), data...), nil
+}
+
+// Check the vector against this implementation: the blob must match its
+// id and decrypt to the content with the key
+func Verify(v Vector) error {
+	if err := blobstore.VerifyBlob(v.Bid, bytes.NewReader(v.Blob)); err != nil {
+		return fmt.Errorf("%s: %v", v.Name, err)
+	}
+	storage := blobstore.NewMemoryBlobStorage()
+	writer, _ := storage.NewBlobWriter(v.Bid)
+	writer.Write(v.Blob)
+	if _, err := writer.Finalize(); err != nil {
+		return err
+	}
+
+	var content []byte
+	var err error
+	if v.PrivateKey != nil {
+		var version int64
+		var reader io.Reader
+		if version, reader, err = blobstore.OpenSignedBlob(v.Bid, v.Key, storage); err == nil {
+			if content, err = ioutil.ReadAll(reader); err == nil && version != v.Version {
+				err = ErrVectorMismatch
+			}
+		}
+	} else {
+		content, err = typedContent(blobstore.BlobReference{Bid: v.Bid, Key: v.Key}, storage)
+	}
+	if err == nil && !bytes.Equal(content, v.Content) {
+		err = ErrVectorMismatch
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", v.Name, err)
+	}
+	return nil
+}
+
+// Compare vectors with expected ones i.e. loaded from the golden file,
+// the first difference is reported
+func Compare(expected, actual []Vector) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("%d vectors expected, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		e, a := expected[i], actual[i]
+		if e.Name != a.Name || e.Bid != a.Bid || e.Key != a.Key || e.Version != a.Version ||
+			!bytes.Equal(e.Content, a.Content) || !bytes.Equal(e.Blob, a.Blob) ||
+			!bytes.Equal(e.PrivateKey, a.PrivateKey) {
+			return fmt.Errorf("%s: %v", e.Name, ErrVectorMismatch)
+		}
+	}
+	return nil
+}
+
+// Write vectors as indented JSON, binary fields are base64-encoded
+func WriteJSON(w io.Writer, vectors []Vector) error {
+	data, err := json.MarshalIndent(vectors, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Read vectors written by WriteJSON
+func ReadJSON(r io.Reader) ([]Vector, error) {
+	var vectors []Vector
+	if err := json.NewDecoder(r).Decode(&vectors); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
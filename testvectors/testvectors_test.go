@@ -0,0 +1,68 @@
+package testvectors
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden file")
+
+var goldenFile = filepath.Join("testdata", "vectors.json")
+
+func TestGolden(t *testing.T) {
+
+	vectors, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		var b bytes.Buffer
+		WriteJSON(&b, vectors)
+		if err = ioutil.WriteFile(goldenFile, b.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	expected, err := ReadJSON(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(expected, vectors); err != nil {
+		t.Fatalf("Blob format changed, run with -update if intended: %v", err)
+	}
+
+	for _, v := range expected {
+		if err = Verify(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+
+	vectors, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors {
+		v.Content = append(append([]byte(nil), v.Content...), 0)
+		if err = Verify(v); err == nil {
+			t.Fatalf("Mismatched content of %s accepted", v.Name)
+		}
+	}
+
+	v := vectors[0]
+	v.Blob = append(append([]byte(nil), v.Blob...), 0)
+	if err = Verify(v); err == nil {
+		t.Fatalf("Corrupted blob accepted")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utils
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Clock is a source of the current time, components depending on time
+// should use it instead of calling time.Now directly so that their
+// behavior can be reproduced in tests and simulations
+type Clock interface {
+
+	// Get the current time
+	Now() time.Time
+
+	// Wait for given duration, the wait is interrupted with
+	// the context error once the context is done
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Clock using the system time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ManualClock is a clock that only changes when explicitly told to,
+// Sleep does advance the time immediately without blocking unless
+// the context is already done
+type ManualClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// Create manual clock starting at given time
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (m *ManualClock) Now() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.now
+}
+
+func (m *ManualClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.Advance(d)
+	return nil
+}
+
+// Move the clock forward
+func (m *ManualClock) Advance(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.now = m.now.Add(d)
+}
+
+// Set the current time
+func (m *ManualClock) Set(now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.now = now
+}
+
+// Create a deterministic source of random bytes, it must never
+// be used to generate real keys, it's intended for tests only
+func DeterministicRand(seed int64) io.Reader {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+type lockedRand struct {
+	lock sync.Mutex
+	r    *rand.Rand
+}
+
+func (l *lockedRand) Read(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.r.Read(p)
+}
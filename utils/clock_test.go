@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+
+	if !c.Now().Equal(start) {
+		t.Fatalf("Invalid initial time: %v", c.Now())
+	}
+
+	c.Advance(time.Hour)
+	c.Sleep(context.Background(), time.Minute)
+	if !c.Now().Equal(start.Add(time.Hour + time.Minute)) {
+		t.Fatalf("Invalid time after advancing: %v", c.Now())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Sleep(ctx, time.Minute); err != context.Canceled || !c.Now().Equal(start.Add(time.Hour+time.Minute)) {
+		t.Fatalf("Sleep not interrupted by the context: %v", err)
+	}
+
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Invalid time after set: %v", c.Now())
+	}
+}
+
+func TestSystemClock(t *testing.T) {
+	before := time.Now()
+	now := SystemClock.Now()
+	if now.Before(before) {
+		t.Fatalf("System clock went backwards: %v < %v", now, before)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := SystemClock.Sleep(ctx, time.Hour); err != context.DeadlineExceeded {
+		t.Fatalf("Sleep not interrupted by the context: %v", err)
+	}
+}
+
+func TestDeterministicRand(t *testing.T) {
+	a, b := make([]byte, 64), make([]byte, 64)
+	io.ReadFull(DeterministicRand(1), a)
+	io.ReadFull(DeterministicRand(1), b)
+	if !bytes.Equal(a, b) {
+		t.Fatal("Random sources with equal seeds differ")
+	}
+
+	io.ReadFull(DeterministicRand(2), b)
+	if bytes.Equal(a, b) {
+		t.Fatal("Random sources with different seeds are equal")
+	}
+}
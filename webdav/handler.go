@@ -0,0 +1,226 @@
+// Copyright 2014 The Cinode Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webdav exposes the directory blob tree over read-only WebDAV,
+// operating systems can mount it with their built-in clients
+package webdav
+
+import (
+	"encoding/xml"
+	"github.com/cinode/golib/blobstore"
+	"github.com/cinode/golib/cinodefs"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Methods accepted by the handler
+const allowedMethods = "OPTIONS, GET, HEAD, PROPFIND"
+
+// Handler serving the tree over WebDAV:
+//
+//	OPTIONS   announce the WebDAV class 1 compliance
+//	GET       read the file, directories can't be read
+//	HEAD      get headers of the file
+//	PROPFIND  get properties of the entry and, with Depth: 1, of the content
+//	          of the directory, Depth: infinity is refused
+//
+// Requested properties are not parsed, all the supported ones are sent.
+// Symbolic links are resolved within the tree, links leaving the tree are
+// not listed. Methods modifying the tree are refused with 405.
+type Handler struct {
+
+	// Tree being served
+	FS *cinodefs.FS
+
+	// Prefix of URL paths stripped before mapping them to the tree,
+	// i.e. "/dav" if the handler is mounted there
+	Prefix string
+}
+
+// Create handler of the tree with the root directory blob
+func NewHandler(root blobstore.BlobReference, storage blobstore.BlobStorage) *Handler {
+	return &Handler{FS: cinodefs.New(root, storage, cinodefs.Options{})}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.name(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", allowedMethods)
+		w.Header().Set("MS-Author-Via", "DAV")
+	case "GET", "HEAD":
+		h.serveFile(w, r, name)
+	case "PROPFIND":
+		h.servePropfind(w, r, name)
+	default:
+		w.Header().Set("Allow", allowedMethods)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Map the URL path to the name within the tree
+func (h *Handler) name(urlPath string) (string, bool) {
+	if !strings.HasPrefix(urlPath, h.Prefix) {
+		return "", false
+	}
+	name := strings.Trim(path.Clean("/"+strings.TrimPrefix(urlPath, h.Prefix)), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, true
+}
+
+// Get the URL of the entry, directories end with a slash
+func (h *Handler) href(name string, isDir bool) string {
+	href := strings.TrimSuffix(h.Prefix, "/") + "/"
+	if name != "." {
+		href += (&url.URL{Path: name}).EscapedPath()
+		if isDir {
+			href += "/"
+		}
+	}
+	return href
+}
+
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := h.FS.Open(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if info.IsDir() {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The blob id identifies the content
+	entry := info.Sys().(blobstore.DirEntry)
+	w.Header().Set("ETag", etag(entry))
+	if entry.MimeType != "" {
+		w.Header().Set("Content-Type", entry.MimeType)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file.(io.ReadSeeker))
+}
+
+func (h *Handler) servePropfind(w http.ResponseWriter, r *http.Request, name string) {
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		http.Error(w, "Only Depth 0 and 1 are supported", http.StatusForbidden)
+		return
+	}
+
+	info, err := h.FS.Stat(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := multistatus{Xmlns: "DAV:", Responses: []response{h.response(name, info)}}
+
+	if depth == "1" && info.IsDir() {
+		entries, err := h.FS.ReadDir(name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, entry := range entries {
+			entryName := path.Join(name, entry.Name())
+			entryInfo, err := h.FS.Stat(entryName)
+			if err != nil {
+				continue
+			}
+			status.Responses = append(status.Responses, h.response(entryName, entryInfo))
+		}
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(status)
+}
+
+// Get properties of the entry
+func (h *Handler) response(name string, info fs.FileInfo) response {
+	p := prop{DisplayName: path.Base(name)}
+	if name == "." {
+		p.DisplayName = ""
+	}
+	if !info.ModTime().IsZero() {
+		p.LastModified = info.ModTime().UTC().Format(http.TimeFormat)
+	}
+
+	entry := info.Sys().(blobstore.DirEntry)
+	if info.IsDir() {
+		p.ResourceType.Collection = &struct{}{}
+	} else {
+		size := info.Size()
+		p.ContentLength = &size
+		p.ContentType = entry.MimeType
+		p.ETag = etag(entry)
+	}
+
+	return response{
+		Href:     h.href(name, info.IsDir()),
+		Propstat: propstat{Prop: p, Status: "HTTP/1.1 200 OK"},
+	}
+}
+
+func etag(entry blobstore.DirEntry) string {
+	return `"` + entry.Bid + `"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if pathErr, ok := err.(*fs.PathError); ok && pathErr.Err == fs.ErrNotExist {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// Response body of PROPFIND
+type multistatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	Xmlns     string     `xml:"xmlns:D,attr"`
+	Responses []response `xml:"D:response"`
+}
+
+type response struct {
+	Href     string   `xml:"D:href"`
+	Propstat propstat `xml:"D:propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+type prop struct {
+	DisplayName   string       `xml:"D:displayname"`
+	ResourceType  resourceType `xml:"D:resourcetype"`
+	ContentLength *int64       `xml:"D:getcontentlength,omitempty"`
+	ContentType   string       `xml:"D:getcontenttype,omitempty"`
+	LastModified  string       `xml:"D:getlastmodified,omitempty"`
+	ETag          string       `xml:"D:getetag,omitempty"`
+}
+
+type resourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
@@ -0,0 +1,133 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"github.com/cinode/golib/blobstore"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func genTestTree(t *testing.T) (blobstore.BlobStorage, blobstore.BlobReference, string) {
+	storage := blobstore.NewMemoryBlobStorage()
+
+	fw := blobstore.FileBlobWriter{Storage: storage}
+	fw.Write([]byte("Hello World!"))
+	file, err := fw.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := blobstore.DirBlobWriter{Storage: storage}
+	sub.AddEntry(blobstore.DirEntry{Name: "a b.txt", MimeType: "text/plain", Bid: file.Bid, Key: file.Key,
+		ModTime: time.Unix(1400000000, 0)})
+	sub.AddEntry(blobstore.DirEntry{Name: "out", Type: blobstore.EntryTypeSymlink, Target: "../../secret"})
+	subRef, err := sub.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := blobstore.DirBlobWriter{Storage: storage}
+	root.AddEntry(blobstore.DirEntry{Name: "sub", Bid: subRef.Bid, Key: subRef.Key})
+	root.AddEntry(blobstore.DirEntry{Name: "hello.txt", MimeType: "text/plain", Bid: file.Bid, Key: file.Key})
+	rootRef, err := root.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage, rootRef.BlobReference, file.Bid
+}
+
+func request(t *testing.T, server *httptest.Server, method, path string, headers ...string) (*http.Response, string) {
+	req, _ := http.NewRequest(method, server.URL+path, nil)
+	for i := 0; i < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestHandler(t *testing.T) {
+
+	storage, root, fileBid := genTestTree(t)
+	handler := NewHandler(root, storage)
+	handler.Prefix = "/dav"
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, _ := request(t, server, "OPTIONS", "/dav/")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("DAV") != "1" {
+		t.Fatalf("Invalid OPTIONS response: %v %v", resp.StatusCode, resp.Header)
+	}
+
+	resp, body := request(t, server, "GET", "/dav/sub/a%20b.txt")
+	if resp.StatusCode != http.StatusOK || body != "Hello World!" || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("Invalid GET response: %v %q %v", resp.StatusCode, body, resp.Header)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag != `"`+fileBid+`"` {
+		t.Fatalf("Invalid ETag: %v", etag)
+	}
+	if resp, _ = request(t, server, "GET", "/dav/hello.txt", "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("Invalid status of the conditional GET: %v", resp.StatusCode)
+	}
+	if resp, body = request(t, server, "GET", "/dav/hello.txt", "Range", "bytes=6-"); resp.StatusCode != http.StatusPartialContent || body != "World!" {
+		t.Fatalf("Invalid range response: %v %q", resp.StatusCode, body)
+	}
+
+	for _, d := range []struct {
+		method, path string
+		headers      []string
+		status       int
+	}{
+		{"GET", "/dav/missing", nil, http.StatusNotFound},
+		{"GET", "/other/hello.txt", nil, http.StatusNotFound},
+		{"GET", "/dav/sub", nil, http.StatusMethodNotAllowed},
+		{"PUT", "/dav/new.txt", nil, http.StatusMethodNotAllowed},
+		{"DELETE", "/dav/hello.txt", nil, http.StatusMethodNotAllowed},
+		{"PROPFIND", "/dav/", []string{"Depth", "infinity"}, http.StatusForbidden},
+		{"PROPFIND", "/dav/", nil, http.StatusForbidden},
+		{"PROPFIND", "/dav/missing", []string{"Depth", "0"}, http.StatusNotFound},
+	} {
+		if resp, _ := request(t, server, d.method, d.path, d.headers...); resp.StatusCode != d.status {
+			t.Fatalf("Invalid status of %v %v: %v, expected %v", d.method, d.path, resp.StatusCode, d.status)
+		}
+	}
+
+	resp, body = request(t, server, "PROPFIND", "/dav/sub", "Depth", "1")
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("Invalid PROPFIND status: %v", resp.StatusCode)
+	}
+	var status struct {
+		Responses []struct {
+			Href          string    `xml:"href"`
+			Collection    *struct{} `xml:"propstat>prop>resourcetype>collection"`
+			ContentLength string    `xml:"propstat>prop>getcontentlength"`
+			LastModified  string    `xml:"propstat>prop>getlastmodified"`
+		} `xml:"response"`
+	}
+	if err := xml.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Responses) != 2 {
+		t.Fatalf("Invalid number of PROPFIND responses: %v", body)
+	}
+	dir, file := status.Responses[0], status.Responses[1]
+	if dir.Href != "/dav/sub/" || dir.Collection == nil {
+		t.Fatalf("Invalid directory properties: %+v", dir)
+	}
+	if file.Href != "/dav/sub/a%20b.txt" || file.Collection != nil || file.ContentLength != "12" ||
+		file.LastModified != "Tue, 13 May 2014 16:53:20 GMT" {
+		t.Fatalf("Invalid file properties: %+v", file)
+	}
+	if !strings.Contains(body, `xmlns:D="DAV:"`) {
+		t.Fatalf("Missing DAV namespace: %v", body)
+	}
+}